@@ -0,0 +1,103 @@
+package sip
+
+import (
+	"sip/header"
+	"sip/parser"
+	"strconv"
+	"strings"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// An AnswerMode is the way a caller asks the callee to answer (RFC 5373),
+// e.g. automatically for an intercom or paging call, or the way the
+// callee reports it answered.
+type AnswerMode struct {
+	// Mode is header.AnswerMode_AUTO or header.AnswerMode_MANUAL, or ""
+	// if no answer mode was asked for.
+	Mode string
+
+	// Privileged is set by Priv-Answer-Mode, which overrides the local
+	// settings of the callee such as do-not-disturb.
+	Privileged bool
+
+	// Require asks the callee to reject the call rather than answer it in
+	// another mode.
+	Require bool
+
+	// Delay is the answer-after delay of the Alert-Info convention.
+	Delay time.Duration
+}
+
+// ALERTINFO_AUTOANSWER is the Alert-Info value of the auto-answer
+// convention understood by phones which predate RFC 5373.
+const ALERTINFO_AUTOANSWER = "<http://127.0.0.1>;info=alert-autoanswer"
+
+// OPTIONTAG_ANSWERMODE is the option tag of RFC 5373.
+const OPTIONTAG_ANSWERMODE = "answermode"
+
+////////////////////Implementation////////////////////////
+
+// IsAuto reports whether an automatic answer is asked for.
+func (this AnswerMode) IsAuto() bool {
+	return this.Mode == header.AnswerMode_AUTO
+}
+
+// GetAnswerMode returns the answer mode asked for by req: Priv-Answer-Mode
+// takes precedence over Answer-Mode, and an Alert-Info following the
+// auto-answer convention (info=alert-autoanswer, info=auto-answer or
+// answer-after) stands for Answer-Mode: Auto.
+func GetAnswerMode(msg Message) AnswerMode {
+	h := msg.GetHeader()
+	if v := h.Get("Priv-Answer-Mode"); v != "" {
+		if am, err := parser.NewPrivAnswerModeParser("Priv-Answer-Mode: " + v + "\n").Parse(); err == nil {
+			return AnswerMode{Mode: am.(*header.AnswerMode).GetMode(), Privileged: true, Require: am.(*header.AnswerMode).IsRequire()}
+		}
+	}
+	if v := h.Get("Answer-Mode"); v != "" {
+		if am, err := parser.NewAnswerModeParser("Answer-Mode: " + v + "\n").Parse(); err == nil {
+			return AnswerMode{Mode: am.(*header.AnswerMode).GetMode(), Require: am.(*header.AnswerMode).IsRequire()}
+		}
+	}
+
+	for _, v := range h["Alert-Info"] {
+		sh, err := parser.NewAlertInfoParser("Alert-Info: " + v + "\n").Parse()
+		if err != nil {
+			continue
+		}
+		for e := sh.(*header.AlertInfoList).Front(); e != nil; e = e.Next() {
+			alertInfo := e.Value.(*header.AlertInfo)
+			info := strings.ToLower(alertInfo.GetParameter("info"))
+			if info == "alert-autoanswer" || info == "auto-answer" || alertInfo.HasParameter("answer-after") {
+				delay, _ := strconv.Atoi(alertInfo.GetParameter("answer-after"))
+				return AnswerMode{Mode: header.AnswerMode_AUTO, Delay: time.Duration(delay) * time.Second}
+			}
+		}
+	}
+	return AnswerMode{}
+}
+
+// SetAnswerMode asks for mode in the INVITE req: it sets Answer-Mode or
+// Priv-Answer-Mode and the answermode option tag, and for an automatic
+// answer the Alert-Info of the auto-answer convention as well.
+func SetAnswerMode(req Request, mode AnswerMode) {
+	h := req.GetHeader()
+
+	am := header.NewAnswerMode()
+	if mode.Privileged {
+		am = header.NewPrivAnswerMode()
+	}
+	if err := am.SetMode(mode.Mode); err != nil {
+		return
+	}
+	am.SetRequire(mode.Require)
+	h.Set(am.GetHeaderName(), am.EncodeBody())
+
+	if !hasToken(h.Get("Supported"), OPTIONTAG_ANSWERMODE) {
+		h.Add("Supported", OPTIONTAG_ANSWERMODE)
+	}
+	if mode.IsAuto() {
+		h.Add("Alert-Info", ALERTINFO_AUTOANSWER)
+	}
+}
@@ -0,0 +1,143 @@
+package sip
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// Blacklist records destinations, "host:port", that should be skipped by
+// transport failover until their quarantine expires. Once given to the
+// provider with SetBlacklist, the addresses a next hop resolves to are
+// tried in order skipping those blacklisted, unless they all are.
+type Blacklist interface {
+	Add(destination string, duration time.Duration)
+	Remove(destination string)
+	IsBlacklisted(destination string) bool
+	GetDestinations() []string
+	SetClock(clock Clock)
+}
+
+////////////////////Implementation////////////////////////
+
+type blacklist struct {
+	mutex   sync.Mutex
+	entries map[string]time.Time
+	clock   Clock
+}
+
+func NewBlacklist() Blacklist {
+	this := &blacklist{}
+
+	this.entries = make(map[string]time.Time)
+	this.clock = RealClock
+
+	return this
+}
+
+func (this *blacklist) SetClock(clock Clock) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.clock = clock
+}
+
+func (this *blacklist) Add(destination string, duration time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.entries[destination] = this.clock.Now().Add(duration)
+}
+
+func (this *blacklist) Remove(destination string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	delete(this.entries, destination)
+}
+
+func (this *blacklist) IsBlacklisted(destination string) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if until, ok := this.entries[destination]; ok {
+		if this.clock.Now().Before(until) {
+			return true
+		}
+		delete(this.entries, destination)
+	}
+	return false
+}
+
+func (this *blacklist) GetDestinations() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	now := this.clock.Now()
+	destinations := make([]string, 0, len(this.entries))
+	for destination, until := range this.entries {
+		if now.Before(until) {
+			destinations = append(destinations, destination)
+		} else {
+			delete(this.entries, destination)
+		}
+	}
+	return destinations
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (this *provider) GetBlacklist() Blacklist {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.blacklist
+}
+
+// SetBlacklist sets the destinations the provider does not send requests
+// to while they are blacklisted; nil, the default, skips none.
+func (this *provider) SetBlacklist(blacklist Blacklist) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.blacklist = blacklist
+}
+
+// isAvailable reports whether a request may be sent to h now, which is
+// neither blacklisted nor down in the PeerHealth of the provider. It is
+// only asked of the hop about to be tried, as a destination down lets a
+// single request through once its back-off is over.
+func (this *provider) isAvailable(h Hop) bool {
+	destination := getHopDestination(h)
+	if blacklist := this.GetBlacklist(); blacklist != nil && blacklist.IsBlacklisted(destination) {
+		return false
+	}
+	health := this.GetPeerHealth()
+	if health == nil {
+		return true
+	}
+	available := health.IsAvailable(destination)
+	this.exportPeerState(health, destination)
+	return available
+}
+
+// getAvailableHops returns the hops from the first a request may be sent
+// to, the later ones being checked as failover reaches them, or all of
+// them if none may: a request is better sent to a destination thought down
+// than not at all.
+func (this *provider) getAvailableHops(hops []Hop) []Hop {
+	for i, h := range hops {
+		if this.isAvailable(h) {
+			return hops[i:]
+		}
+	}
+	return hops
+}
+
+// getHopDestination returns the destination of h, "host:port".
+func getHopDestination(h Hop) string {
+	return net.JoinHostPort(h.GetHost(), strconv.Itoa(h.GetPort()))
+}
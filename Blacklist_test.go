@@ -0,0 +1,40 @@
+package sip
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlacklist(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	bl := NewBlacklist()
+	bl.SetClock(clock)
+
+	bl.Add("192.0.2.20:5060", time.Minute)
+	bl.Add("192.0.2.21:5060", 2*time.Minute)
+	if !bl.IsBlacklisted("192.0.2.20:5060") || bl.IsBlacklisted("192.0.2.22:5060") {
+		t.Error("wrong destinations blacklisted")
+	}
+	if destinations := bl.GetDestinations(); len(destinations) != 2 {
+		t.Errorf("destinations %q", destinations)
+	}
+
+	//a destination is quarantined until its duration is over
+	clock.Advance(time.Minute - time.Millisecond)
+	if !bl.IsBlacklisted("192.0.2.20:5060") {
+		t.Error("destination released early")
+	}
+	clock.Advance(time.Millisecond)
+	if bl.IsBlacklisted("192.0.2.20:5060") {
+		t.Error("destination still blacklisted")
+	}
+	if destinations := bl.GetDestinations(); len(destinations) != 1 || destinations[0] != "192.0.2.21:5060" {
+		t.Errorf("destinations %q", destinations)
+	}
+
+	//or removed
+	bl.Remove("192.0.2.21:5060")
+	if bl.IsBlacklisted("192.0.2.21:5060") || len(bl.GetDestinations()) != 0 {
+		t.Error("destination not removed")
+	}
+}
@@ -0,0 +1,112 @@
+package sip
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// A TypedBody is a message body which knows its own media type. When it is
+// attached to a message the Content-Type (and Content-Disposition, if the
+// body has one) are stamped automatically, and writing the message fails if
+// the application set a conflicting value.
+type TypedBody interface {
+	Read(p []byte) (n int, err error)
+	Len() int
+	GetContentType() string
+	GetContentDisposition() string
+}
+
+var (
+	ErrContentTypeConflict        = errors.New("sip: Content-Type conflicts with the body type")
+	ErrContentDispositionConflict = errors.New("sip: Content-Disposition conflicts with the body type")
+)
+
+const (
+	CONTENTTYPE_SDP     = "application/sdp"
+	CONTENTTYPE_PIDF    = "application/pidf+xml"
+	CONTENTTYPE_SIPFRAG = "message/sipfrag"
+	CONTENTTYPE_TEXT    = "text/plain"
+	CONTENTTYPE_CPIM    = "message/cpim"
+)
+
+////////////////////////////////////////////////////////////////////////////////
+
+type typedBody struct {
+	*bytes.Reader
+
+	body               []byte
+	contentType        string
+	contentDisposition string
+}
+
+func NewTypedBody(contentType, contentDisposition string, body []byte) TypedBody {
+	return &typedBody{
+		Reader:             bytes.NewReader(body),
+		body:               body,
+		contentType:        contentType,
+		contentDisposition: contentDisposition,
+	}
+}
+
+// Bytes returns the whole body, so that it can be set on several messages.
+func (this *typedBody) Bytes() []byte {
+	return this.body
+}
+
+// NewSDPBody returns a session description body, see RFC 3261 13.2.1.
+func NewSDPBody(sdp []byte) TypedBody {
+	return NewTypedBody(CONTENTTYPE_SDP, "session", sdp)
+}
+
+// NewPIDFBody returns a presence document body, see RFC 3863.
+func NewPIDFBody(pidf []byte) TypedBody {
+	return NewTypedBody(CONTENTTYPE_PIDF, "", pidf)
+}
+
+// NewSipfragBody returns a message fragment body, see RFC 3420.
+func NewSipfragBody(sipfrag []byte) TypedBody {
+	return NewTypedBody(CONTENTTYPE_SIPFRAG, "", sipfrag)
+}
+
+// NewTextBody returns a plain text body, such as the text of an instant
+// message, see Pager.
+func NewTextBody(text string) TypedBody {
+	return NewTypedBody(CONTENTTYPE_TEXT, "", []byte(text))
+}
+
+func (this *typedBody) GetContentType() string {
+	return this.contentType
+}
+
+func (this *typedBody) GetContentDisposition() string {
+	return this.contentDisposition
+}
+
+// stampBody sets the Content-Type and Content-Disposition of a TypedBody
+// which are missing from the header, and reports the ones that conflict.
+func stampBody(h Header, body TypedBody) error {
+	if contentType := body.GetContentType(); contentType != "" {
+		if v := h.Get("Content-Type"); v == "" {
+			h.Set("Content-Type", contentType)
+		} else if !strings.EqualFold(mediaToken(v), mediaToken(contentType)) {
+			return ErrContentTypeConflict
+		}
+	}
+	if disposition := body.GetContentDisposition(); disposition != "" {
+		if v := h.Get("Content-Disposition"); v == "" {
+			h.Set("Content-Disposition", disposition)
+		} else if !strings.EqualFold(mediaToken(v), mediaToken(disposition)) {
+			return ErrContentDispositionConflict
+		}
+	}
+	return nil
+}
+
+// mediaToken strips the parameters from a header value.
+func mediaToken(v string) string {
+	if i := strings.Index(v, ";"); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,74 @@
+package sip
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A BranchStrategy computes the branch parameter of the Via a component
+// adds when it sends req. It is given the request as received, before the
+// new Via is pushed, with its Request-URI already rewritten.
+type BranchStrategy interface {
+	GetBranch(req Request) string
+}
+
+// The branch parameter of RFC 3261 compliant elements starts with it
+// (RFC 3261 8.1.1.7).
+const BRANCH_MAGIC_COOKIE = "z9hG4bK"
+
+var (
+	// RandomBranch generates a new branch for every request, as
+	// transaction stateful elements do.
+	RandomBranch BranchStrategy = &randomBranch{}
+
+	// StatelessBranch derives the branch from the request, so that a
+	// stateless proxy forwards the retransmissions of a request with the
+	// same branch (RFC 3261 16.11).
+	StatelessBranch BranchStrategy = &statelessBranch{}
+)
+
+////////////////////Implementation////////////////////////
+
+type randomBranch struct {
+}
+
+func (this *randomBranch) GetBranch(req Request) string {
+	return GenerateBranchId()
+}
+
+type statelessBranch struct {
+}
+
+// GetBranch hashes the top Via and the Request-URI of req. When the top Via
+// has no RFC 3261 branch, the dialog identifiers and CSeq number are hashed
+// too. The CSeq method is left out so that the CANCEL and the ACK of an
+// INVITE get the branch of the INVITE.
+func (this *statelessBranch) GetBranch(req Request) string {
+	h := req.GetHeader()
+
+	via := h.Get("Via")
+	if i := strings.Index(via, ","); i >= 0 {
+		via = via[:i]
+	}
+
+	hash := sha1.New()
+	for _, s := range []string{req.GetRequestURIString(), via} {
+		hash.Write([]byte(s))
+		hash.Write([]byte{0})
+	}
+	if !strings.HasPrefix(getBranch(req), BRANCH_MAGIC_COOKIE) {
+		var cseq string
+		if fields := strings.Fields(h.Get("Cseq")); len(fields) > 0 {
+			cseq = fields[0]
+		}
+		for _, s := range []string{h.Get("From"), h.Get("To"), h.Get("Call-Id"), cseq} {
+			hash.Write([]byte(s))
+			hash.Write([]byte{0})
+		}
+	}
+
+	return BRANCH_MAGIC_COOKIE + hex.EncodeToString(hash.Sum(nil)[:8])
+}
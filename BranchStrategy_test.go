@@ -0,0 +1,43 @@
+package sip
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStatelessBranch(t *testing.T) {
+	newRequest := func(method, via string) Request {
+		req := NewRequest(method, "sip:bob@biloxi.com", nil)
+		req.GetHeader().Set("Via", via)
+		req.GetHeader().Set("From", "<sip:alice@atlanta.com>;tag=1")
+		req.GetHeader().Set("To", "<sip:bob@biloxi.com>")
+		req.GetHeader().Set("Call-Id", "a84b4c76e66710")
+		req.GetHeader().Set("Cseq", "1 "+method)
+		return req
+	}
+
+	for _, via := range []string{"SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds", "SIP/2.0/UDP pc33.atlanta.com;branch=1"} {
+		invite := newRequest(INVITE, via)
+		branch := StatelessBranch.GetBranch(invite)
+		if !strings.HasPrefix(branch, BRANCH_MAGIC_COOKIE) {
+			t.Errorf("branch %s lacks the magic cookie", branch)
+		}
+		if StatelessBranch.GetBranch(newRequest(INVITE, via)) != branch {
+			t.Errorf("retransmission forwarded with another branch")
+		}
+		if StatelessBranch.GetBranch(newRequest(CANCEL, via)) != branch {
+			t.Errorf("CANCEL forwarded with another branch than its INVITE")
+		}
+
+		other := newRequest(INVITE, via)
+		other.SetRequestURIString("sip:bob@192.0.2.4")
+		if StatelessBranch.GetBranch(other) == branch {
+			t.Errorf("distinct targets share the branch %s", branch)
+		}
+	}
+
+	req := newRequest(INVITE, "SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds")
+	if RandomBranch.GetBranch(req) == RandomBranch.GetBranch(req) {
+		t.Error("random branches repeat")
+	}
+}
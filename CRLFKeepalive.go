@@ -0,0 +1,209 @@
+package sip
+
+import (
+	"bufio"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// The stream connections of a provider carry the CRLF keep-alives of RFC
+// 5626 3.5.1. A double CRLF, a ping, received between two messages is
+// answered with a single CRLF, a pong; the CRLFs are otherwise ignored, as
+// RFC 3261 7.5 requires. Once SetCRLFKeepalive is given an interval, the
+// provider pings the connections it dialed itself, keeping the bindings of
+// the NATs on their way open, at a random 80 to 100% of the interval (RFC
+// 5626 4.4.1). A connection which does not answer a ping with a pong within
+// CRLF_PONG_TIMEOUT is dead: it is closed and the ConnectionDeadHandler of
+// the provider, if any, told, for the UA to register again over a new one.
+
+const (
+	// CRLF_KEEPALIVE_INTERVAL is the interval RFC 5626 4.4.1 recommends
+	// for connection-oriented transports.
+	CRLF_KEEPALIVE_INTERVAL = 120 * time.Second
+
+	// CRLF_PONG_TIMEOUT is how long a ping waits for its pong.
+	CRLF_PONG_TIMEOUT = 10 * time.Second
+)
+
+var (
+	crlfPing = []byte("\r\n\r\n")
+	crlfPong = []byte("\r\n")
+)
+
+// A ConnectionDeadHandler is called with a connection to address over
+// network which did not answer a keep-alive.
+type ConnectionDeadHandler func(network, address string)
+
+////////////////////Implementation////////////////////////
+
+// crlfKeepalive is the keep-alive state of a dialed connection.
+type crlfKeepalive struct {
+	mutex   sync.Mutex
+	network string
+	address string
+	ping    Timer //the next ping
+	pong    Timer //the pong awaited, if any
+	stopped bool
+}
+
+func (this *provider) GetCRLFKeepalive() time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.crlfKeepalive
+}
+
+// SetCRLFKeepalive sets the interval at which the connections the provider
+// dials are pinged, CRLF_KEEPALIVE_INTERVAL being the recommended one; 0,
+// the default, sends no pings. It applies to the connections dialed
+// afterwards.
+func (this *provider) SetCRLFKeepalive(interval time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.crlfKeepalive = interval
+}
+
+func (this *provider) GetConnectionDeadHandler() ConnectionDeadHandler {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.connectionDeadHandler
+}
+
+// SetConnectionDeadHandler sets the handler called with the connections
+// closed for not answering a keep-alive.
+func (this *provider) SetConnectionDeadHandler(handler ConnectionDeadHandler) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.connectionDeadHandler = handler
+}
+
+// startKeepalive pings conn, a connection to address over network the
+// provider dialed, if it is to.
+func (this *provider) startKeepalive(network, address string, conn *connection) {
+	if this.GetCRLFKeepalive() <= 0 {
+		return
+	}
+	k := &crlfKeepalive{network: network, address: address}
+
+	k.mutex.Lock()
+	conn.keepalive = k
+	this.schedulePing(conn, k)
+	k.mutex.Unlock()
+}
+
+// schedulePing arms the next ping of conn; the mutex of k is held.
+func (this *provider) schedulePing(conn *connection, k *crlfKeepalive) {
+	interval := this.GetCRLFKeepalive()
+	if k.stopped || interval <= 0 {
+		return
+	}
+	//80 to 100% of the interval, for the pings of many UAs to spread
+	d := interval - time.Duration(rand.Int63n(int64(interval/5)+1))
+	k.ping = this.GetClock().AfterFunc(d, func() {
+		this.ping(conn, k)
+	})
+}
+
+// ping sends a ping on conn and waits for its pong.
+func (this *provider) ping(conn *connection, k *crlfKeepalive) {
+	k.mutex.Lock()
+	if k.stopped {
+		k.mutex.Unlock()
+		return
+	}
+	k.pong = this.GetClock().AfterFunc(CRLF_PONG_TIMEOUT, func() {
+		this.processDeadConnection(conn, k)
+	})
+	k.mutex.Unlock()
+
+	if err := conn.send(crlfPing); err != nil {
+		this.getLogger(COMPONENT_TRANSPORT).Log(LOG_ERROR, "Sending keep-alive failed", "error", err)
+	}
+}
+
+// processPong takes a pong received on conn.
+func (this *provider) processPong(conn *connection) {
+	k := conn.keepalive
+	if k == nil {
+		return
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.pong == nil || k.stopped {
+		return
+	}
+	stopTimer(k.pong)
+	k.pong = nil
+	this.schedulePing(conn, k)
+}
+
+// processDeadConnection closes conn, the ping of which got no pong.
+func (this *provider) processDeadConnection(conn *connection, k *crlfKeepalive) {
+	k.mutex.Lock()
+	if k.stopped {
+		k.mutex.Unlock()
+		return
+	}
+	k.stopped = true
+	k.mutex.Unlock()
+
+	this.getLogger(COMPONENT_TRANSPORT).Log(LOG_WARN, "Keep-alive failed", "network", k.network, "address", k.address)
+	conn.Close()
+	if handler := this.GetConnectionDeadHandler(); handler != nil {
+		handler(k.network, k.address)
+	}
+}
+
+// stopKeepalive stops pinging conn, which is closed.
+func (this *provider) stopKeepalive(conn *connection) {
+	k := conn.keepalive
+	if k == nil {
+		return
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.stopped = true
+	stopTimer(k.ping)
+	stopTimer(k.pong)
+}
+
+// readKeepalives consumes the CRLFs b holds ahead of the next message of
+// conn, answering a ping with a pong and taking a pong. It reports whether
+// b holds nothing else yet.
+func (this *provider) readKeepalives(b *bufio.Reader, conn *connection) bool {
+	newlines := 0
+	for {
+		c, err := b.ReadByte()
+		if err != nil {
+			break
+		}
+		if c != '\r' && c != '\n' {
+			b.UnreadByte()
+			break
+		}
+		if c == '\n' {
+			newlines++
+		}
+		if b.Buffered() == 0 {
+			break
+		}
+	}
+
+	switch {
+	case newlines >= 2:
+		if err := conn.send(crlfPong); err != nil {
+			this.getLogger(COMPONENT_TRANSPORT).Log(LOG_ERROR, "Sending keep-alive failed", "error", err)
+		}
+	case newlines == 1:
+		this.processPong(conn)
+	}
+	return newlines > 0 && b.Buffered() == 0
+}
@@ -0,0 +1,133 @@
+package sip
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCRLFPing(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	received := make(chan Message, 1)
+	p.dispatcher = NewDispatcher(1, func(msg Message) { received <- msg })
+	p.dispatcher.Start()
+	defer p.dispatcher.Stop()
+
+	tr := newTransport(TCP, "127.0.0.1", 0, nil)
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := p.addConnection(TCP, server)
+	p.spawn(resourceServe, func() { p.ServeConn(tr, conn) })
+	defer func() {
+		close(p.quit)
+		conn.Close()
+		p.waitGroup.Wait()
+	}()
+
+	//a ping is answered with a pong
+	go client.Write(crlfPing)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b := make([]byte, 4)
+	if n, err := io.ReadAtLeast(client, b, 2); err != nil || string(b[:n]) != "\r\n" {
+		t.Fatalf("answered %q, %v", b[:n], err)
+	}
+
+	//and the connection still carries messages
+	go client.Write([]byte("\r\nOPTIONS sip:carol@chicago.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/TCP pc33.atlanta.com;branch=z9hG4bKka1\r\n" +
+		"Call-ID: a84b4c76e66710\r\n" +
+		"CSeq: 63104 OPTIONS\r\n" +
+		"Content-Length: 0\r\n\r\n"))
+	select {
+	case msg := <-received:
+		if req, ok := msg.(Request); !ok || req.GetMethod() != OPTIONS {
+			t.Errorf("received %v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing received after the ping")
+	}
+}
+
+func TestCRLFKeepalive(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	tr := newTransport(TCP, "127.0.0.1", 0, nil)
+	p.AddTransport(tr)
+	p.SetCRLFKeepalive(time.Minute)
+	dead := make(chan string, 1)
+	p.SetConnectionDeadHandler(func(network, address string) {
+		dead <- network + " " + address
+	})
+	go p.Run()
+	defer p.Stop()
+
+	conn, err := p.getConnection(tr, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	b := bufio.NewReader(peer)
+	expectPing := func() {
+		//a second at a time, for the pong timeout not to expire meanwhile
+		for i := 0; ; i++ {
+			if i == 60 {
+				t.Fatal("no ping sent")
+			}
+			clock.Advance(time.Second)
+			peer.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+			if _, err := b.Peek(len(crlfPing)); err == nil {
+				break
+			}
+		}
+		peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+		ping := make([]byte, 4)
+		if _, err := io.ReadFull(b, ping); err != nil || string(ping) != string(crlfPing) {
+			t.Fatalf("read %q, %v", ping, err)
+		}
+	}
+
+	//a ping answered
+	expectPing()
+	if _, err := peer.Write(crlfPong); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		conn.keepalive.mutex.Lock()
+		pong := conn.keepalive.pong
+		conn.keepalive.mutex.Unlock()
+		if pong == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatal("pong not taken")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	//a ping unanswered
+	expectPing()
+	clock.Advance(CRLF_PONG_TIMEOUT)
+	select {
+	case d := <-dead:
+		if d != "tcp "+l.Addr().String() {
+			t.Errorf("dead connection %q", d)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("dead connection not reported")
+	}
+	if _, err := b.ReadByte(); err == nil {
+		t.Error("dead connection left open")
+	}
+}
@@ -0,0 +1,637 @@
+package sip
+
+import (
+	"errors"
+	"sip/header"
+	"sip/parser"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A Call tracks one INVITE session, either placed (outgoing) or received
+// (incoming). It surfaces early media carried by provisional responses,
+// acknowledges reliable provisional responses with PRACK (RFC 3262), and
+// lets the local session description change until the call is answered.
+//
+// An INVITE without a session description is a delayed offer (RFC 3261
+// 13.2.1): the offer comes in the 2xx and the answer in the ACK. The ACK
+// is then given to ProcessAck, which takes the answer from an incoming ACK
+// or puts the local one in an outgoing ACK. The MediaHandler is called
+// once both session descriptions are known: when the 2xx is sent or
+// received for an offer in the INVITE, only at the ACK for a delayed
+// offer.
+type Call interface {
+	GetCallId() string
+	GetInvite() Request
+	GetState() CallState
+	IsIncoming() bool
+
+	GetLocalSDP() []byte
+	SetLocalSDP(sdp []byte) error
+	GetRemoteSDP() []byte
+	HasEarlyMedia() bool
+
+	SetEarlyMediaHandler(handler EarlyMediaHandler)
+	SetMediaHandler(handler MediaHandler)
+
+	IsDelayedOffer() bool
+	ProcessAck(ack Request) error
+
+	// GetAnswerMode returns the answer mode asked for by the caller of an
+	// incoming call, or the one reported by the callee of an outgoing call.
+	GetAnswerMode() AnswerMode
+
+	// GetDialog returns the dialog of the call once answered, and Bye ends
+	// it with a BYE.
+	GetDialog() Dialog
+	Bye() error
+
+	// outgoing calls
+	ProcessResponse(resp Response) error
+
+	// incoming calls
+	Progress() error
+	Answer() error
+	AutoAnswer() error
+	Reject(statusCode int) error
+}
+
+type CallState int
+
+const (
+	CALLSTATE_CALLING    CallState = iota //0
+	CALLSTATE_EARLY                       //1
+	CALLSTATE_CONFIRMED                   //2
+	CALLSTATE_TERMINATED                  //3
+)
+
+func (this CallState) String() string {
+	switch this {
+	case CALLSTATE_CALLING:
+		return "calling"
+	case CALLSTATE_EARLY:
+		return "early"
+	case CALLSTATE_CONFIRMED:
+		return "confirmed"
+	case CALLSTATE_TERMINATED:
+		return "terminated"
+	}
+	return "unknown"
+}
+
+// An EarlyMediaHandler is called when a provisional response carries a
+// session description, typically a 183 Session Progress from an IVR or a
+// PSTN gateway playing announcements before the call is answered.
+type EarlyMediaHandler func(call Call, resp Response, sdp []byte)
+
+// A MediaHandler is called when the offer/answer exchange of the INVITE
+// completes, with the local and remote session descriptions to set the
+// media up with.
+type MediaHandler func(call Call, local, remote []byte)
+
+var (
+	ErrCallAnswered     = errors.New("sip: call already answered")
+	ErrCallDirection    = errors.New("sip: operation not supported for this call direction")
+	ErrNoOffer          = errors.New("sip: no local session description to offer")
+	ErrNoAnswer         = errors.New("sip: no answer to the delayed offer")
+	ErrCallNotConfirmed = errors.New("sip: call not answered")
+)
+
+////////////////////Implementation////////////////////////
+
+type call struct {
+	mutex sync.Mutex
+
+	provider Provider
+	invite   Request
+	incoming bool
+	st       ServerTransaction
+	dialog   Dialog
+	//the Contact of the responses to an incoming call, if set
+	contact string
+
+	state      CallState
+	localTag   string
+	localSDP   []byte
+	remoteSDP  []byte
+	earlyMedia bool
+
+	//the INVITE had no offer: the 2xx carries it and the ACK the answer
+	delayedOffer bool
+	ackPending   bool
+	mediaReady   bool
+
+	cseq      int
+	lastRSeq  int
+	lastPrack Request
+
+	answerMode AnswerMode
+
+	earlyMediaHandler EarlyMediaHandler
+	mediaHandler      MediaHandler
+}
+
+// NewOutgoingCall tracks the INVITE invite sent through provider. Responses
+// received for it must be passed to ProcessResponse.
+func NewOutgoingCall(provider Provider, invite Request) Call {
+	this := newCall(provider, invite)
+
+	this.cseq, _ = getCSeq(invite)
+	this.localSDP = readSDP(invite)
+	this.delayedOffer = this.localSDP == nil
+
+	return this
+}
+
+// NewIncomingCall tracks the INVITE received in st. The session description
+// to answer with is set with SetLocalSDP before calling Progress or Answer.
+func NewIncomingCall(provider Provider, st ServerTransaction) Call {
+	this := newCall(provider, st.GetRequest())
+
+	this.incoming = true
+	this.st = st
+	this.localTag = GenerateTag()
+	this.remoteSDP = readSDP(this.invite)
+	this.delayedOffer = this.remoteSDP == nil
+	this.answerMode = GetAnswerMode(this.invite)
+
+	return this
+}
+
+func newCall(provider Provider, invite Request) *call {
+	this := &call{}
+
+	this.provider = provider
+	this.invite = invite
+	this.state = CALLSTATE_CALLING
+	this.lastRSeq = -1
+
+	return this
+}
+
+func (this *call) GetCallId() string {
+	return this.invite.GetHeader().Get("Call-Id")
+}
+
+func (this *call) GetInvite() Request {
+	return this.invite
+}
+
+func (this *call) GetState() CallState {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.state
+}
+
+func (this *call) IsIncoming() bool {
+	return this.incoming
+}
+
+func (this *call) GetLocalSDP() []byte {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.localSDP
+}
+
+// SetLocalSDP replaces the local session description. It may be called any
+// number of times, e.g. after early media has been offered, until the call
+// is answered; for an outgoing delayed offer, until the answer is sent in
+// the ACK.
+func (this *call) SetLocalSDP(sdp []byte) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.state >= CALLSTATE_CONFIRMED && !(this.ackPending && !this.incoming) {
+		return ErrCallAnswered
+	}
+	this.localSDP = sdp
+	return nil
+}
+
+func (this *call) GetRemoteSDP() []byte {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.remoteSDP
+}
+
+func (this *call) HasEarlyMedia() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.earlyMedia
+}
+
+func (this *call) SetEarlyMediaHandler(handler EarlyMediaHandler) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.earlyMediaHandler = handler
+}
+
+func (this *call) SetMediaHandler(handler MediaHandler) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.mediaHandler = handler
+}
+
+func (this *call) IsDelayedOffer() bool {
+	return this.delayedOffer
+}
+
+// getMediaHandler returns the MediaHandler to call now that the offer/answer
+// exchange completed, once per call. It must be called with the mutex held.
+func (this *call) getMediaHandler() MediaHandler {
+	if this.mediaReady {
+		return nil
+	}
+	this.mediaReady = true
+	return this.mediaHandler
+}
+
+// ProcessAck completes a delayed offer with the ACK of the 2xx: the answer
+// is taken from an incoming call's ACK, which fails with ErrNoAnswer if it
+// has none, and the local session description is put in an outgoing
+// call's. It does nothing for an offer made in the INVITE.
+func (this *call) ProcessAck(ack Request) error {
+	var sdp []byte
+	if this.incoming {
+		sdp = readSDP(ack)
+	}
+
+	this.mutex.Lock()
+	if !this.delayedOffer || this.mediaReady {
+		this.mutex.Unlock()
+		return nil
+	}
+	if !this.ackPending {
+		this.mutex.Unlock()
+		return ErrNoAck
+	}
+	if this.incoming {
+		if sdp == nil {
+			this.mutex.Unlock()
+			return ErrNoAnswer
+		}
+		this.remoteSDP = sdp
+	} else {
+		if this.localSDP == nil {
+			this.mutex.Unlock()
+			return ErrNoAnswer
+		}
+		ack.SetBody(NewSDPBody(this.localSDP))
+	}
+	this.ackPending = false
+	handler := this.getMediaHandler()
+	local, remote := this.localSDP, this.remoteSDP
+	this.mutex.Unlock()
+
+	if handler != nil {
+		handler(this, local, remote)
+	}
+	return nil
+}
+
+func (this *call) GetAnswerMode() AnswerMode {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.answerMode
+}
+
+// ProcessResponse updates an outgoing call with a response to its INVITE.
+// A provisional response with a session description is reported to the
+// EarlyMediaHandler, and a reliable one (Require: 100rel) is acknowledged
+// with a PRACK.
+func (this *call) ProcessResponse(resp Response) error {
+	if this.incoming {
+		return ErrCallDirection
+	}
+
+	statusCode := resp.GetStatusCode()
+	sdp := readSDP(resp)
+	var d Dialog
+	if p, ok := this.provider.(*provider); ok && statusCode >= OK && statusCode < MULTIPLE_CHOICES {
+		if matched := p.matchDialog(resp); matched != nil {
+			d = matched
+		}
+	}
+
+	this.mutex.Lock()
+	var handler EarlyMediaHandler
+	var mediaHandler MediaHandler
+	switch {
+	case statusCode < OK:
+		if this.state == CALLSTATE_CALLING && statusCode > TRYING {
+			this.state = CALLSTATE_EARLY
+		}
+		if sdp != nil {
+			this.remoteSDP = sdp
+			this.earlyMedia = true
+			handler = this.earlyMediaHandler
+		}
+	case statusCode < MULTIPLE_CHOICES:
+		this.state = CALLSTATE_CONFIRMED
+		this.earlyMedia = false
+		if sdp != nil {
+			this.remoteSDP = sdp
+		}
+		if d != nil && this.dialog == nil {
+			this.dialog = d
+		}
+		this.answerMode = GetAnswerMode(resp)
+		if this.delayedOffer {
+			//the answer goes in the ACK
+			this.ackPending = !this.mediaReady
+		} else if this.remoteSDP != nil {
+			mediaHandler = this.getMediaHandler()
+		}
+	default:
+		this.state = CALLSTATE_TERMINATED
+		this.earlyMedia = false
+	}
+	local, remote := this.localSDP, this.remoteSDP
+	this.mutex.Unlock()
+
+	if handler != nil {
+		handler(this, resp, sdp)
+	}
+	if mediaHandler != nil {
+		mediaHandler(this, local, remote)
+	}
+
+	if isReliableProvisional(resp) {
+		return this.sendPrack(resp)
+	}
+	return nil
+}
+
+// sendPrack acknowledges a reliable provisional response, once per RSeq.
+// One received by the provider is acknowledged by its early dialog, which
+// did unless the response made an offer: the PRACK then carries the local
+// session description as the answer.
+func (this *call) sendPrack(resp Response) error {
+	if p, ok := this.provider.(*provider); ok {
+		if d := p.matchDialog(resp); d != nil {
+			var sdp []byte
+			if d.GetOfferState() == OFFERSTATE_REMOTE {
+				sdp = this.GetLocalSDP()
+			}
+			prack, err := d.sendPrack(resp, sdp)
+			if prack != nil {
+				this.mutex.Lock()
+				this.lastPrack = prack
+				this.mutex.Unlock()
+			}
+			return err
+		}
+	}
+
+	rseq, err := strconv.Atoi(strings.TrimSpace(resp.GetHeader().Get("RSeq")))
+	if err != nil {
+		return errors.New("sip: reliable provisional response without a valid RSeq")
+	}
+
+	this.mutex.Lock()
+	if rseq <= this.lastRSeq {
+		//retransmission, already acknowledged
+		this.mutex.Unlock()
+		return nil
+	}
+	this.lastRSeq = rseq
+	this.cseq++
+	prack := this.createPrack(resp, rseq, this.cseq)
+	this.lastPrack = prack
+	this.mutex.Unlock()
+
+	return this.provider.GetNewClientTransaction(prack).SendRequest()
+}
+
+// createPrack builds the PRACK for resp as in RFC 3262 7.2: it is sent
+// within the early dialog to the remote target, and its RAck echoes the
+// RSeq and the CSeq of the INVITE.
+func (this *call) createPrack(resp Response, rseq, cseq int) Request {
+	target := this.invite.GetRequestURIString()
+	if contact := resp.GetHeader().Get("Contact"); contact != "" {
+		if uri := getAddressURI(contact); uri != "" {
+			target = uri
+		}
+	}
+
+	prack := NewRequest(PRACK, target, nil)
+	h := prack.GetHeader()
+
+	inviteCSeq, _ := getCSeq(this.invite)
+	if via := this.invite.GetHeader().Get("Via"); via != "" {
+		h.Set("Via", newBranch(via))
+	}
+	h.Set("Max-Forwards", "70")
+	h.Set("From", this.invite.GetHeader().Get("From"))
+	h.Set("To", resp.GetHeader().Get("To"))
+	h.Set("Call-Id", this.GetCallId())
+	h.Set("Cseq", strconv.Itoa(cseq)+" "+PRACK)
+	h.Set("RAck", strconv.Itoa(rseq)+" "+strconv.Itoa(inviteCSeq)+" "+INVITE)
+
+	//the route set is the Record-Route of the response, reversed
+	rr := resp.GetHeader()["Record-Route"]
+	for i := len(rr) - 1; i >= 0; i-- {
+		h.Add("Route", rr[i])
+	}
+
+	return prack
+}
+
+// Progress sends a 183 Session Progress carrying the local session
+// description, so that the caller can render early media.
+func (this *call) Progress() error {
+	return this.respond(SESSION_PROGRESS, "")
+}
+
+// Answer sends a 200 OK carrying the local session description, after the
+// user accepted the call.
+func (this *call) Answer() error {
+	return this.respond(OK, header.AnswerMode_MANUAL)
+}
+
+// AutoAnswer sends a 200 OK carrying the local session description without
+// any user action, as asked for by an intercom or paging caller. Whether
+// to honor such a request, see GetAnswerMode, is up to the application.
+func (this *call) AutoAnswer() error {
+	return this.respond(OK, header.AnswerMode_AUTO)
+}
+
+// Reject ends the call with a final response, e.g. 403 Forbidden when an
+// answer mode asked for with require cannot be honored (RFC 5373 7.1).
+func (this *call) Reject(statusCode int) error {
+	if statusCode < MULTIPLE_CHOICES {
+		return ErrStatusCode
+	}
+	return this.respond(statusCode, "")
+}
+
+func (this *call) respond(statusCode int, answerMode string) error {
+	if !this.incoming {
+		return ErrCallDirection
+	}
+
+	this.mutex.Lock()
+	if this.state >= CALLSTATE_CONFIRMED {
+		this.mutex.Unlock()
+		return ErrCallAnswered
+	}
+	if this.delayedOffer && this.localSDP == nil && statusCode >= OK && statusCode < MULTIPLE_CHOICES {
+		//the 2xx must carry the offer
+		this.mutex.Unlock()
+		return ErrNoOffer
+	}
+	resp := CreateResponse(this.invite, statusCode)
+	if to := resp.GetHeader().Get("To"); to != "" && !strings.Contains(strings.ToLower(to), ";tag=") {
+		resp.GetHeader().Set("To", to+";tag="+this.localTag)
+	}
+	if this.contact != "" && statusCode < MULTIPLE_CHOICES && resp.GetHeader().Get("Contact") == "" {
+		resp.GetHeader().Set("Contact", this.contact)
+	}
+	if this.localSDP != nil && statusCode < MULTIPLE_CHOICES {
+		resp.SetBody(NewSDPBody(this.localSDP))
+	}
+	var mediaHandler MediaHandler
+	switch {
+	case statusCode < OK:
+		this.state = CALLSTATE_EARLY
+		this.earlyMedia = this.localSDP != nil
+	case statusCode < MULTIPLE_CHOICES:
+		this.state = CALLSTATE_CONFIRMED
+		this.earlyMedia = false
+		if this.delayedOffer {
+			//the answer comes in the ACK
+			this.ackPending = true
+		} else {
+			mediaHandler = this.getMediaHandler()
+		}
+		//report how the call was answered to a caller who asked (RFC 5373 6)
+		if answerMode != "" && this.answerMode.Mode != "" {
+			this.answerMode.Mode = answerMode
+			am := header.NewAnswerMode()
+			if this.answerMode.Privileged {
+				am = header.NewPrivAnswerMode()
+			}
+			am.SetMode(answerMode)
+			resp.GetHeader().Set(am.GetHeaderName(), am.EncodeBody())
+		}
+	default:
+		this.state = CALLSTATE_TERMINATED
+		this.earlyMedia = false
+	}
+	local, remote := this.localSDP, this.remoteSDP
+	this.mutex.Unlock()
+
+	if err := this.st.SendResponse(resp); err != nil {
+		return err
+	}
+	if mediaHandler != nil {
+		mediaHandler(this, local, remote)
+	}
+	return nil
+}
+
+// GetDialog returns the dialog of the call, nil until it is set up: the
+// one confirmed by the 2xx of an outgoing call, or the one of the server
+// transaction of an incoming call.
+func (this *call) GetDialog() Dialog {
+	this.mutex.Lock()
+	d := this.dialog
+	this.mutex.Unlock()
+
+	if d == nil && this.st != nil {
+		return this.st.GetDialog()
+	}
+	return d
+}
+
+// Bye hangs up a call which was answered, sending a BYE within its dialog.
+// It fails with ErrCallNotConfirmed before the call is answered, when an
+// incoming call is rejected and an outgoing one cancelled instead.
+func (this *call) Bye() error {
+	d := this.GetDialog()
+
+	this.mutex.Lock()
+	if this.state != CALLSTATE_CONFIRMED || d == nil {
+		this.mutex.Unlock()
+		return ErrCallNotConfirmed
+	}
+	this.state = CALLSTATE_TERMINATED
+	this.mutex.Unlock()
+
+	bye, err := d.CreateRequest(BYE)
+	if err != nil {
+		return err
+	}
+	return d.SendRequest(this.provider.GetNewClientTransaction(bye))
+}
+
+// terminate ends the call without a request of its own, e.g. when its
+// dialog ended.
+func (this *call) terminate() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.state = CALLSTATE_TERMINATED
+	this.earlyMedia = false
+}
+
+// readSDP returns the session description carried by msg, if any: its
+// body, or the first session description part of a multipart body.
+func readSDP(msg Message) []byte {
+	contentType := mediaToken(msg.GetHeader().Get("Content-Type"))
+	if isMultipart(contentType) {
+		parts, _ := GetBodyParts(msg)
+		for _, part := range parts {
+			if strings.EqualFold(mediaToken(part.GetContentType()), CONTENTTYPE_SDP) && len(part.Body) > 0 {
+				return part.Body
+			}
+		}
+		return nil
+	}
+	if !strings.EqualFold(contentType, CONTENTTYPE_SDP) || len(msg.GetBodyBytes()) == 0 {
+		return nil
+	}
+	return msg.GetBodyBytes()
+}
+
+// getCSeq returns the sequence number of the CSeq header of msg.
+func getCSeq(msg Message) (int, error) {
+	fields := strings.Fields(msg.GetHeader().Get("Cseq"))
+	if len(fields) == 0 {
+		return 0, errors.New("sip: missing CSeq")
+	}
+	return strconv.Atoi(fields[0])
+}
+
+// getAddressURI returns the URI of a name-addr or addr-spec header value.
+func getAddressURI(v string) string {
+	addr, err := parser.NewAddressParser(v).Address()
+	if err != nil || addr.GetURI() == nil {
+		return ""
+	}
+	return addr.GetURI().String()
+}
+
+// newBranch replaces the branch parameter of a Via header value with a new
+// one, for a new transaction sent from the same place.
+func newBranch(via string) string {
+	if i := strings.Index(strings.ToLower(via), ";branch="); i >= 0 {
+		end := strings.IndexAny(via[i+1:], ";,")
+		if end < 0 {
+			via = via[:i]
+		} else {
+			via = via[:i] + via[i+1+end:]
+		}
+	}
+	return via + ";branch=" + RandomBranch.GetBranch(nil)
+}
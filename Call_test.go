@@ -0,0 +1,229 @@
+package sip
+
+import (
+	"bytes"
+	"sip/header"
+	"testing"
+	"time"
+)
+
+func TestCallEarlyMedia(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	go p.Run()
+	defer p.Stop()
+
+	invite := NewRequest(INVITE, "sip:ivr@example.com", NewSDPBody([]byte("v=0\r\no=offer\r\n")))
+	invite.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK1111;rport")
+	invite.GetHeader().Set("From", "<sip:alice@example.com>;tag=a1")
+	invite.GetHeader().Set("To", "<sip:ivr@example.com>")
+	invite.GetHeader().Set("Call-Id", "early@192.0.2.1")
+	invite.GetHeader().Set("Cseq", "10 INVITE")
+
+	var media []byte
+	c := NewOutgoingCall(p, invite)
+	c.SetEarlyMediaHandler(func(call Call, resp Response, sdp []byte) {
+		media = sdp
+	})
+
+	progress := CreateResponse(invite, SESSION_PROGRESS)
+	progress.GetHeader().Set("To", "<sip:ivr@example.com>;tag=b2")
+	progress.GetHeader().Set("Contact", "<sip:ivr@198.51.100.7:5070>")
+	progress.GetHeader().Set("Require", "100rel")
+	progress.GetHeader().Set("RSeq", "42")
+	progress.GetHeader().Add("Record-Route", "<sip:p2.example.com;lr>")
+	progress.GetHeader().Add("Record-Route", "<sip:p1.example.com;lr>")
+	progress.SetBody(NewSDPBody([]byte("v=0\r\no=answer\r\n")))
+
+	if err := c.ProcessResponse(progress); err != nil {
+		t.Fatal(err)
+	}
+	if c.GetState() != CALLSTATE_EARLY || !c.HasEarlyMedia() {
+		t.Fatalf("state = %v early media %v, want early with media", c.GetState(), c.HasEarlyMedia())
+	}
+	if string(media) != "v=0\r\no=answer\r\n" || !bytes.Equal(c.GetRemoteSDP(), media) {
+		t.Fatalf("early media sdp = %q", media)
+	}
+
+	prack := c.(*call).lastPrack
+	if prack == nil {
+		t.Fatal("no PRACK sent for a reliable 183")
+	}
+	h := prack.GetHeader()
+	if prack.GetRequestURIString() != "sip:ivr@198.51.100.7:5070" {
+		t.Errorf("PRACK Request-URI = %s", prack.GetRequestURIString())
+	}
+	if h.Get("RAck") != "42 10 INVITE" || h.Get("Cseq") != "11 PRACK" || h.Get("To") != "<sip:ivr@example.com>;tag=b2" {
+		t.Errorf("PRACK header = %v", h)
+	}
+	if routes := h["Route"]; len(routes) != 2 || routes[0] != "<sip:p1.example.com;lr>" {
+		t.Errorf("PRACK Route = %v", routes)
+	}
+	if via := h.Get("Via"); bytes.Contains([]byte(via), []byte("z9hG4bK1111")) {
+		t.Errorf("PRACK reused the INVITE branch: %s", via)
+	}
+
+	//a retransmission of the same 183 is not acknowledged again
+	if err := c.ProcessResponse(progress); err != nil || c.(*call).lastPrack != prack {
+		t.Errorf("retransmitted 183 was acknowledged again")
+	}
+
+	if err := c.SetLocalSDP([]byte("v=0\r\no=updated\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ProcessResponse(CreateResponse(invite, OK)); err != nil {
+		t.Fatal(err)
+	}
+	if c.GetState() != CALLSTATE_CONFIRMED || c.HasEarlyMedia() {
+		t.Fatalf("state = %v, want confirmed", c.GetState())
+	}
+	if err := c.SetLocalSDP(nil); err != ErrCallAnswered {
+		t.Errorf("SetLocalSDP after answer = %v, want ErrCallAnswered", err)
+	}
+}
+
+func TestCallAutoAnswer(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+
+	newInvite := func() Request {
+		invite := NewRequest(INVITE, "sip:intercom@example.com", NewSDPBody([]byte("v=0\r\no=page\r\n")))
+		invite.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK"+randomHex(4))
+		invite.GetHeader().Set("From", "<sip:alice@example.com>;tag=a1")
+		invite.GetHeader().Set("To", "<sip:intercom@example.com>")
+		invite.GetHeader().Set("Call-Id", "page@192.0.2.1")
+		invite.GetHeader().Set("Cseq", "1 INVITE")
+		return invite
+	}
+
+	invite := newInvite()
+	SetAnswerMode(invite, AnswerMode{Mode: header.AnswerMode_AUTO, Privileged: true, Require: true})
+	h := invite.GetHeader()
+	if h.Get("Priv-Answer-Mode") != "Auto;require" || !hasToken(h.Get("Supported"), OPTIONTAG_ANSWERMODE) || h.Get("Alert-Info") != ALERTINFO_AUTOANSWER {
+		t.Fatalf("INVITE header = %v", h)
+	}
+
+	st := &recordingTransaction{serverTransaction: newServerTransaction(invite)}
+	c := NewIncomingCall(p, st)
+	if mode := c.GetAnswerMode(); !mode.IsAuto() || !mode.Privileged || !mode.Require {
+		t.Fatalf("answer mode = %+v", mode)
+	}
+	if err := c.AutoAnswer(); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.responses) != 1 || st.responses[0].GetHeader().Get("Priv-Answer-Mode") != "Auto" {
+		t.Fatalf("200 does not report the auto answer: %v", st.responses)
+	}
+
+	//an outgoing call learns how it was answered
+	out := NewOutgoingCall(p, invite)
+	if err := out.ProcessResponse(st.responses[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !out.GetAnswerMode().IsAuto() {
+		t.Errorf("outgoing answer mode = %+v", out.GetAnswerMode())
+	}
+
+	//the Alert-Info convention of older phones
+	invite = newInvite()
+	invite.GetHeader().Set("Alert-Info", "<http://www.example.com/sounds/moo.wav>;answer-after=3")
+	st = &recordingTransaction{serverTransaction: newServerTransaction(invite)}
+	c = NewIncomingCall(p, st)
+	if mode := c.GetAnswerMode(); !mode.IsAuto() || mode.Delay != 3*time.Second || mode.Privileged {
+		t.Fatalf("Alert-Info answer mode = %+v", mode)
+	}
+	if err := c.Reject(OK); err != ErrStatusCode {
+		t.Errorf("Reject(200) = %v", err)
+	}
+	if err := c.Reject(FORBIDDEN); err != nil || c.GetState() != CALLSTATE_TERMINATED {
+		t.Errorf("Reject(403) = %v, state %v", err, c.GetState())
+	}
+	if err := c.Answer(); err != ErrCallAnswered {
+		t.Errorf("Answer after Reject = %v", err)
+	}
+}
+
+func TestCallDelayedOffer(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+
+	invite := NewRequest(INVITE, "sip:gw@example.com", nil)
+	invite.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bKdelayed")
+	invite.GetHeader().Set("From", "<sip:alice@example.com>;tag=a1")
+	invite.GetHeader().Set("To", "<sip:gw@example.com>")
+	invite.GetHeader().Set("Call-Id", "delayed@192.0.2.1")
+	invite.GetHeader().Set("Cseq", "1 INVITE")
+
+	type media struct{ local, remote string }
+	var in, out []media
+
+	//the gateway answers the INVITE without an offer with its own
+	st := &recordingTransaction{serverTransaction: newServerTransaction(invite)}
+	c := NewIncomingCall(p, st)
+	c.SetMediaHandler(func(call Call, local, remote []byte) {
+		in = append(in, media{string(local), string(remote)})
+	})
+	if !c.IsDelayedOffer() {
+		t.Fatal("INVITE without SDP is not a delayed offer")
+	}
+	if err := c.Answer(); err != ErrNoOffer {
+		t.Errorf("Answer without an offer = %v, want ErrNoOffer", err)
+	}
+	c.SetLocalSDP([]byte("v=0\r\no=offer\r\n"))
+	if err := c.Answer(); err != nil {
+		t.Fatal(err)
+	}
+	if len(in) != 0 {
+		t.Fatal("media set up before the ACK brought the answer")
+	}
+	ok := st.responses[0]
+
+	//the caller answers in the ACK
+	o := NewOutgoingCall(p, invite)
+	o.SetMediaHandler(func(call Call, local, remote []byte) {
+		out = append(out, media{string(local), string(remote)})
+	})
+	if !o.IsDelayedOffer() {
+		t.Fatal("outgoing INVITE without SDP is not a delayed offer")
+	}
+	if err := o.ProcessAck(NewRequest(ACK, "sip:gw@example.com", nil)); err != ErrNoAck {
+		t.Errorf("ProcessAck before the 2xx = %v, want ErrNoAck", err)
+	}
+	if err := o.ProcessResponse(ok); err != nil {
+		t.Fatal(err)
+	}
+	if string(o.GetRemoteSDP()) != "v=0\r\no=offer\r\n" || len(out) != 0 {
+		t.Fatalf("offer = %q, media %v", o.GetRemoteSDP(), out)
+	}
+	ack := NewRequest(ACK, "sip:gw@example.com", nil)
+	if err := o.ProcessAck(ack); err != ErrNoAnswer {
+		t.Errorf("ProcessAck without an answer = %v, want ErrNoAnswer", err)
+	}
+	if err := o.SetLocalSDP([]byte("v=0\r\no=answer\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.ProcessAck(ack); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0] != (media{"v=0\r\no=answer\r\n", "v=0\r\no=offer\r\n"}) {
+		t.Errorf("outgoing media = %v", out)
+	}
+	if err := o.SetLocalSDP(nil); err != ErrCallAnswered {
+		t.Errorf("SetLocalSDP after the ACK = %v, want ErrCallAnswered", err)
+	}
+
+	//an ACK without the answer does not set the media up
+	if err := c.ProcessAck(NewRequest(ACK, "sip:gw@example.com", nil)); err != ErrNoAnswer || len(in) != 0 {
+		t.Errorf("ProcessAck without SDP = %v, media %v", err, in)
+	}
+	if err := c.ProcessAck(ack); err != nil {
+		t.Fatal(err)
+	}
+	if len(in) != 1 || in[0] != (media{"v=0\r\no=offer\r\n", "v=0\r\no=answer\r\n"}) {
+		t.Errorf("incoming media = %v", in)
+	}
+
+	//retransmissions set nothing up again
+	o.ProcessResponse(ok)
+	c.ProcessAck(ack)
+	if len(in) != 1 || len(out) != 1 {
+		t.Errorf("media set up again: %v %v", in, out)
+	}
+}
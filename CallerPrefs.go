@@ -0,0 +1,295 @@
+package sip
+
+import (
+	"math"
+	"sip/core"
+	"sip/header"
+	"sip/parser"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A FeatureSet holds the feature tags of a Contact (RFC 3840) or of an
+// Accept-Contact or Reject-Contact predicate (RFC 3841). Tags are
+// lowercased and map to their values as decoded by
+// header.DecodeFeatureValues; a tag without a value is the boolean TRUE.
+type FeatureSet map[string][]string
+
+// A ContactMatch is a contact kept by MatchContacts, with its caller
+// preference score Qa and its own q-value (-1 if not set).
+type ContactMatch struct {
+	Contact string
+	Qa      float32
+	Q       float32
+}
+
+////////////////////Implementation////////////////////////
+
+// NewFeatureSet returns the feature tags among params.
+func NewFeatureSet(params *core.NameValueList) FeatureSet {
+	this := make(FeatureSet)
+	if params == nil {
+		return this
+	}
+
+	for e := params.Front(); e != nil; e = e.Next() {
+		nv := e.Value.(*core.NameValue)
+		if !header.IsFeatureTag(nv.GetName()) {
+			continue
+		}
+		value, _ := nv.GetValue().(string)
+		this[strings.ToLower(nv.GetName())] = header.DecodeFeatureValues(value)
+	}
+	return this
+}
+
+// Matches reports whether the contact declares every feature tag of
+// predicate with an accepted value.
+func (this FeatureSet) Matches(predicate FeatureSet) bool {
+	score, _, complete := this.score(predicate)
+	return complete && score == 1
+}
+
+// term reports whether the contact declares tag, and if so whether one of
+// its values is accepted by the predicate.
+func (this FeatureSet) term(tag string, values []string) (declared, matched bool) {
+	have, ok := this[tag]
+	if !ok {
+		return false, false
+	}
+	for _, v := range values {
+		for _, h := range have {
+			if matchFeatureValue(v, h) {
+				return true, true
+			}
+		}
+	}
+	return true, false
+}
+
+// matchFeatureValue reports whether the contact value have satisfies the
+// predicate value pred (RFC 3840 9): "!" negates, numerics match when their
+// ranges overlap, strings in "<" ">" compare exactly and tokens and
+// booleans case-insensitively.
+func matchFeatureValue(pred, have string) bool {
+	switch {
+	case strings.HasPrefix(pred, "!"):
+		return !matchFeatureValue(pred[1:], have)
+	case strings.HasPrefix(pred, "#"):
+		plo, phi, ok := featureRange(pred)
+		if !ok {
+			return false
+		}
+		hlo, hhi, ok := featureRange(have)
+		return ok && plo <= hhi && hlo <= phi
+	case strings.HasPrefix(pred, "<"):
+		return pred == have
+	}
+	return strings.EqualFold(pred, have)
+}
+
+// featureRange returns the range of a numeric feature value: "#=n",
+// "#>=n", "#<=n" or "#a:b".
+func featureRange(v string) (lo, hi float64, ok bool) {
+	if !strings.HasPrefix(v, "#") {
+		return 0, 0, false
+	}
+	v = v[1:]
+
+	var err error
+	switch {
+	case strings.HasPrefix(v, ">="):
+		lo, err = strconv.ParseFloat(v[2:], 64)
+		hi = math.Inf(1)
+	case strings.HasPrefix(v, "<="):
+		hi, err = strconv.ParseFloat(v[2:], 64)
+		lo = math.Inf(-1)
+	case strings.HasPrefix(v, "="):
+		lo, err = strconv.ParseFloat(v[1:], 64)
+		hi = lo
+	case strings.Contains(v, ":"):
+		bounds := strings.SplitN(v, ":", 2)
+		if lo, err = strconv.ParseFloat(bounds[0], 64); err == nil {
+			hi, err = strconv.ParseFloat(bounds[1], 64)
+		}
+	default:
+		lo, err = strconv.ParseFloat(v, 64)
+		hi = lo
+	}
+	return lo, hi, err == nil
+}
+
+// score returns the fraction of the terms of predicate matched by the
+// contact, and whether the contact contradicts a term it declares.
+func (this FeatureSet) score(predicate FeatureSet) (score float32, contradicts, complete bool) {
+	if len(predicate) == 0 {
+		return 1, false, true
+	}
+
+	matched := 0
+	complete = true
+	for tag, values := range predicate {
+		declared, ok := this.term(tag, values)
+		switch {
+		case ok:
+			matched++
+		case declared:
+			contradicts = true
+		default:
+			complete = false
+		}
+	}
+	return float32(matched) / float32(len(predicate)), contradicts, complete
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// MatchContacts applies the caller preferences of req to contacts, the
+// values of the registered Contact headers of the target, as in RFC 3841
+// 7.4: a contact matching every term of a Reject-Contact predicate is
+// dropped, a contact failing an Accept-Contact predicate with require is
+// dropped (with explicit, a tag it does not declare counts as a failure),
+// and the remaining contacts are sorted by their Qa score and then by
+// their q-value. Without preferences, contacts are only sorted by q-value.
+func MatchContacts(req Request, contacts []string) []ContactMatch {
+	accepts := getPreferences(req, "Accept-Contact")
+	rejects := getPreferences(req, "Reject-Contact")
+
+	var matches []ContactMatch
+	for _, c := range contacts {
+		contact := parseContact(c)
+		if contact == nil {
+			continue
+		}
+		features := NewFeatureSet(contact.GetContactParms())
+
+		rejected := false
+		for _, reject := range rejects {
+			if features.Matches(reject.features) {
+				rejected = true
+				break
+			}
+		}
+		if rejected {
+			continue
+		}
+
+		var qa float32 = 1
+		if len(accepts) > 0 {
+			var sum float32
+			dropped := false
+			for _, accept := range accepts {
+				score, contradicts, complete := features.score(accept.features)
+				satisfied := !contradicts && (complete || !accept.explicit)
+				if accept.require && !satisfied {
+					dropped = true
+					break
+				}
+				if contradicts {
+					score = 0
+				}
+				sum += score
+			}
+			if dropped {
+				continue
+			}
+			qa = sum / float32(len(accepts))
+		}
+
+		matches = append(matches, ContactMatch{Contact: c, Qa: qa, Q: contact.GetQValue()})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Qa != matches[j].Qa {
+			return matches[i].Qa > matches[j].Qa
+		}
+		return qValue(matches[i].Q) > qValue(matches[j].Q)
+	})
+	return matches
+}
+
+// qValue returns q, taking a missing q-value as 1.0 (RFC 3261 16.6).
+func qValue(q float32) float32 {
+	if q < 0 {
+		return 1
+	}
+	return q
+}
+
+type preference struct {
+	features FeatureSet
+	require  bool
+	explicit bool
+}
+
+// getPreferences returns the predicates of the Accept-Contact or
+// Reject-Contact headers of req.
+func getPreferences(req Request, name string) []preference {
+	var prefs []preference
+	for _, v := range req.GetHeader()[name] {
+		var sh header.Header
+		var err error
+		if name == "Accept-Contact" {
+			sh, err = parser.NewAcceptContactParser(name + ": " + v + "\n").Parse()
+		} else {
+			sh, err = parser.NewRejectContactParser(name + ": " + v + "\n").Parse()
+		}
+		if err != nil {
+			continue
+		}
+
+		for e := sh.(header.SIPHeaderLister).Front(); e != nil; e = e.Next() {
+			switch h := e.Value.(type) {
+			case *header.AcceptContact:
+				prefs = append(prefs, preference{NewFeatureSet(h.GetParameters()), h.IsRequire(), h.IsExplicit()})
+			case *header.RejectContact:
+				prefs = append(prefs, preference{NewFeatureSet(h.GetParameters()), false, false})
+			}
+		}
+	}
+	return prefs
+}
+
+func parseContact(v string) *header.Contact {
+	sh, err := parser.NewContactParser("Contact: " + v + "\n").Parse()
+	if err != nil {
+		return nil
+	}
+	e := sh.(*header.ContactList).Front()
+	if e == nil {
+		return nil
+	}
+	contact := e.Value.(*header.Contact)
+	if contact.GetWildCardFlag() {
+		return nil
+	}
+	return contact
+}
+
+// GetRequestDisposition returns the Request-Disposition directives of req,
+// lowercased and in order.
+func GetRequestDisposition(req Request) []string {
+	var directives []string
+	for _, v := range req.GetHeader()["Request-Disposition"] {
+		sh, err := parser.NewRequestDispositionParser("Request-Disposition: " + v + "\n").Parse()
+		if err != nil {
+			continue
+		}
+		directives = append(directives, sh.(*header.RequestDispositionList).GetDirectives()...)
+	}
+	return directives
+}
+
+// HasRequestDisposition reports whether req carries directive in its
+// Request-Disposition header.
+func HasRequestDisposition(req Request, directive string) bool {
+	for _, d := range GetRequestDisposition(req) {
+		if d == strings.ToLower(directive) {
+			return true
+		}
+	}
+	return false
+}
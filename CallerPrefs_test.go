@@ -0,0 +1,96 @@
+package sip
+
+import (
+	"testing"
+)
+
+func TestMatchContacts(t *testing.T) {
+	contacts := []string{
+		"<sip:alice@desk.example.com>;audio;q=0.5",
+		"<sip:alice@tablet.example.com>;audio;video",
+		"<sip:alice@pc.example.com>;audio;video;automata",
+		"<sip:alice@old.example.com>",
+	}
+	newInvite := func() Request {
+		req := NewRequest(INVITE, "sip:alice@example.com", nil)
+		req.GetHeader().Set("Cseq", "1 INVITE")
+		return req
+	}
+	targets := func(matches []ContactMatch) []string {
+		var uris []string
+		for _, m := range matches {
+			uris = append(uris, getAddressURI(m.Contact))
+		}
+		return uris
+	}
+
+	//no preferences: sorted by q-value only
+	got := targets(MatchContacts(newInvite(), contacts))
+	if len(got) != 4 || got[3] != "sip:alice@desk.example.com" {
+		t.Errorf("no preferences: %v", got)
+	}
+
+	//video required: contacts which do not declare video are kept with a
+	//lower score, the automaton is rejected
+	req := newInvite()
+	req.GetHeader().Set("Accept-Contact", "*;video;require")
+	req.GetHeader().Set("Reject-Contact", "*;automata")
+	got = targets(MatchContacts(req, contacts))
+	if len(got) != 3 || got[0] != "sip:alice@tablet.example.com" || got[1] != "sip:alice@old.example.com" {
+		t.Errorf("video required: %v", got)
+	}
+
+	//explicit: a contact must declare the required features
+	req.GetHeader().Set("Accept-Contact", "*;video;require;explicit")
+	got = targets(MatchContacts(req, contacts))
+	if len(got) != 1 || got[0] != "sip:alice@tablet.example.com" {
+		t.Errorf("video required explicitly: %v", got)
+	}
+
+	//preferred but not required: video first
+	req = newInvite()
+	req.GetHeader().Set("Accept-Contact", "*;video")
+	matches := MatchContacts(req, contacts)
+	if len(matches) != 4 || matches[0].Qa != 1 || matches[3].Qa != 0 {
+		t.Errorf("video preferred: %v", matches)
+	}
+
+	req.GetHeader().Set("Request-Disposition", "No-Fork, recurse")
+	if !HasRequestDisposition(req, "no-fork") || HasRequestDisposition(req, "parallel") {
+		t.Errorf("directives = %v", GetRequestDisposition(req))
+	}
+}
+
+func TestFeatureSetMatches(t *testing.T) {
+	contact := parseContact("<sip:alice@192.0.2.4>;audio;methods=\"INVITE,BYE\";+x.size=\"#=4\";+sip.instance=\"<urn:uuid:1>\"")
+	features := NewFeatureSet(contact.GetContactParms())
+	if len(features) != 4 {
+		t.Fatalf("features = %v", features)
+	}
+
+	var tests = []struct {
+		predicate string
+		match     bool
+	}{
+		{"*;audio", true},
+		{"*;audio;video", false},
+		{"*;methods=\"BYE\"", true},
+		{"*;methods=\"!MESSAGE\"", true},
+		{"*;methods=\"MESSAGE,SUBSCRIBE\"", false},
+		{"*;+x.size=\"#>=2\"", true},
+		{"*;+x.size=\"#1:3\"", false},
+		{"*;+sip.instance=\"<urn:uuid:1>\"", true},
+		{"*;+sip.instance=\"<URN:UUID:1>\"", false},
+	}
+	for _, test := range tests {
+		req := NewRequest(INVITE, "sip:alice@example.com", nil)
+		req.GetHeader().Set("Accept-Contact", test.predicate)
+		prefs := getPreferences(req, "Accept-Contact")
+		if len(prefs) != 1 {
+			t.Fatalf("%s: %d predicates", test.predicate, len(prefs))
+		}
+		if features.Matches(prefs[0].features) != test.match {
+			t.Errorf("%s: match = %v", test.predicate, !test.match)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package sip
+
+import (
+	"strings"
+)
+
+// A CANCEL matching a server INVITE transaction is handled by the provider
+// (RFC 3261 9.2): the CANCEL is answered with 200 OK and, unless it was
+// answered already, the INVITE with 487 Request Terminated, both with the
+// To tag of the responses to the INVITE. The CANCEL is then given to the
+// listeners in its completed transaction, for the application to stop
+// working on the INVITE. A CANCEL matching no INVITE, or one of a Proxy,
+// which forwards it, is given to the listeners to answer.
+
+////////////////////Implementation////////////////////////
+
+// processCancel handles req, a CANCEL, if it matches a server INVITE
+// transaction, and reports whether it did.
+func (this *provider) processCancel(req Request) bool {
+	this.mutex.Lock()
+	invite := this.servers[getServerTransactionKeyOf(req, INVITE)]
+	this.mutex.Unlock()
+
+	if invite == nil || !matchesServerTransaction(invite, req) || invite.isProxied() {
+		return false
+	}
+
+	tag := invite.getToTag()
+	st := this.GetNewServerTransaction(req)
+	if err := st.SendResponse(withToTag(CreateResponse(req, OK), tag)); err != nil {
+		this.getLogger(COMPONENT_TRANSACTION).Log(LOG_ERROR, "Sending response failed", "error", err)
+	}
+	if err := invite.SendResponse(withToTag(CreateResponse(invite.GetRequest(), REQUEST_TERMINATED), tag)); err != nil && err != ErrTransactionCompleted {
+		this.getLogger(COMPONENT_TRANSACTION).Log(LOG_ERROR, "Sending response failed", "error", err)
+	}
+
+	event := NewRequestEvent(st, req)
+	if d := invite.GetDialog(); d != nil {
+		event.dialog = d
+	}
+	this.listeners.fireRequest(event)
+	return true
+}
+
+// getToTag returns the To tag of the last response sent, or a new one if
+// none had one.
+func (this *serverTransaction) getToTag() string {
+	this.mutex.Lock()
+	resp := this.response
+	this.mutex.Unlock()
+
+	if resp != nil {
+		if tag := getTag(resp.GetHeader().Get("To")); tag != "" {
+			return tag
+		}
+	}
+	if tag := getTag(this.GetRequest().GetHeader().Get("To")); tag != "" {
+		return tag
+	}
+	return GenerateTag()
+}
+
+// withToTag gives the To of resp tag unless it has one.
+func withToTag(resp Response, tag string) Response {
+	h := resp.GetHeader()
+	if to := h.Get("To"); to != "" && !strings.Contains(strings.ToLower(to), ";tag=") {
+		h.Set("To", to+";tag="+tag)
+	}
+	return resp
+}
@@ -0,0 +1,81 @@
+package sip
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCreateCancel(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	go p.Run()
+	defer p.Stop()
+	invite := newProxiedRequest(INVITE, "sip:bob@biloxi.com", "z9hG4bKcc1")
+	invite.GetHeader().Set("Route", "<sip:192.0.2.1;lr>")
+	ct := p.GetNewClientTransaction(invite).(*clientTransaction)
+
+	cancel, err := ct.CreateCancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := cancel.GetHeader()
+	if cancel.GetMethod() != CANCEL || cancel.GetRequestURIString() != "sip:bob@biloxi.com" || h.Get("Cseq") != "1 CANCEL" {
+		t.Errorf("created %s %s, CSeq %q", cancel.GetMethod(), cancel.GetRequestURIString(), h.Get("Cseq"))
+	}
+	for _, name := range []string{"Via", "From", "To", "Call-Id", "Route"} {
+		if h.Get(name) != invite.GetHeader().Get(name) {
+			t.Errorf("%s = %q, want %q", name, h.Get(name), invite.GetHeader().Get(name))
+		}
+	}
+	if getServerTransactionKeyOf(cancel, INVITE) != getServerTransactionKey(invite) {
+		t.Error("the CANCEL does not match the INVITE")
+	}
+
+	//nothing left to cancel
+	ct.processResponse(CreateResponse(invite, BUSY_HERE))
+	if _, err := ct.CreateCancel(); err != ErrNoCancel {
+		t.Errorf("CANCEL after the final response created with %v", err)
+	}
+}
+
+func TestCancelInvite(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKcc2")
+	p.processMessage(invite)
+	if err := l.requests[0].GetResponseWriter().Provisional(RINGING); err != nil {
+		t.Fatal(err)
+	}
+	tag := getTag(sent.last().GetHeader().Get("To"))
+
+	//the CANCEL is answered, so is the INVITE, and the listener told
+	p.processMessage(newServerTestRequest(CANCEL, "UDP", "z9hG4bKcc2"))
+	if sent.len() != 3 {
+		t.Fatalf("%d messages sent, want 3", sent.len())
+	}
+	for i, want := range []string{"1 CANCEL", "1 INVITE"} {
+		resp := sent.get(i + 1).(Response)
+		if statusCode := []int{OK, REQUEST_TERMINATED}[i]; resp.GetStatusCode() != statusCode || resp.GetHeader().Get("Cseq") != want {
+			t.Errorf("sent %d for %q, want %d", resp.GetStatusCode(), resp.GetHeader().Get("Cseq"), statusCode)
+		}
+		if getTag(resp.GetHeader().Get("To")) != tag {
+			t.Errorf("To = %q, want the tag %q", resp.GetHeader().Get("To"), tag)
+		}
+	}
+	if len(l.requests) != 2 || l.requests[1].GetRequest().GetMethod() != CANCEL {
+		t.Fatalf("%d requests delivered", len(l.requests))
+	}
+	if err := l.requests[0].GetResponseWriter().Respond(OK, nil); err != ErrTransactionCompleted {
+		t.Errorf("cancelled INVITE answered with %v", err)
+	}
+
+	//a CANCEL matching nothing is left to the listeners
+	p.processMessage(newServerTestRequest(CANCEL, "UDP", "z9hG4bKcc3"))
+	if sent.len() != 3 || len(l.requests) != 3 {
+		t.Errorf("%d messages sent, %d requests delivered", sent.len(), len(l.requests))
+	}
+}
@@ -0,0 +1,249 @@
+package sip
+
+import (
+	"sip/header"
+	"strings"
+)
+
+////////////////////Interface//////////////////////////////
+
+// Capabilities are what a provider, or a stack, supports: the methods of
+// Allow, the extensions (option tags) of Supported, the event packages of
+// Allow-Events and the content types of Accept. The headers the provider
+// stamps on the messages it sends, the answers to OPTIONS of
+// CreateOptionsResponse and the feature tags of a REGISTER Contact
+// (RFC 3840) are all derived from the same snapshot, so they agree. An
+// empty list is not advertised.
+type Capabilities struct {
+	Methods       []string
+	Extensions    []string
+	EventPackages []string
+	ContentTypes  []string
+}
+
+////////////////////Implementation////////////////////////
+
+// CreateOptionsResponse returns the 200 OK answering req, an OPTIONS, with
+// the Allow, Supported, Allow-Events and Accept headers of the
+// capabilities.
+func (this Capabilities) CreateOptionsResponse(req Request) Response {
+	resp := CreateResponse(req, OK)
+	h := resp.GetHeader()
+	setList(h, "Allow", this.Methods)
+	setList(h, "Supported", this.Extensions)
+	setList(h, "Allow-Events", this.EventPackages)
+	setList(h, "Accept", this.ContentTypes)
+	return resp
+}
+
+// SetFeatureTags sets the methods, extensions, events and type feature
+// tags of contact, e.g. one of a REGISTER, to the capabilities.
+func (this Capabilities) SetFeatureTags(contact *header.Contact) {
+	for _, tag := range []struct {
+		name   string
+		values []string
+	}{
+		{header.FeatureTag_METHODS, this.Methods},
+		{header.FeatureTag_EXTENSIONS, this.Extensions},
+		{header.FeatureTag_EVENTS, this.EventPackages},
+		{header.FeatureTag_TYPE, this.ContentTypes},
+	} {
+		if len(tag.values) == 0 {
+			contact.RemoveFeatureTag(tag.name)
+		} else {
+			contact.SetFeatureTag(tag.name, tag.values...)
+		}
+	}
+}
+
+// merge adds those of other to the capabilities, without duplicates.
+func (this Capabilities) merge(other Capabilities) Capabilities {
+	return Capabilities{
+		Methods:       mergeTokens(this.Methods, other.Methods),
+		Extensions:    mergeTokens(this.Extensions, other.Extensions),
+		EventPackages: mergeTokens(this.EventPackages, other.EventPackages),
+		ContentTypes:  mergeTokens(this.ContentTypes, other.ContentTypes),
+	}
+}
+
+// mergeTokens returns tokens followed by those of more it lacks, compared
+// case-insensitively.
+func mergeTokens(tokens, more []string) []string {
+	merged := append([]string(nil), tokens...)
+	for _, t := range more {
+		if !containsToken(merged, t) {
+			merged = append(merged, t)
+		}
+	}
+	return merged
+}
+
+func containsToken(tokens []string, token string) bool {
+	for _, t := range tokens {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// setList sets the header name of h to values, unless it is set or there
+// are none.
+func setList(h Header, name string, values []string) {
+	if len(values) == 0 || h.Get(name) != "" {
+		return
+	}
+	h.Set(name, strings.Join(values, ", "))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Capabilities returns a snapshot of the capabilities of the provider: its
+// allowed methods, supported extensions, event packages and accepted
+// content types.
+func (this *provider) Capabilities() Capabilities {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return Capabilities{
+		Methods:       append([]string(nil), this.allowedMethods...),
+		Extensions:    append([]string(nil), this.supported...),
+		EventPackages: append([]string(nil), this.eventPackages...),
+		ContentTypes:  append([]string(nil), this.accept...),
+	}
+}
+
+func (this *provider) GetSupported() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.supported
+}
+
+// SetSupported sets the option tags of the extensions this provider
+// supports, none by default. The requests sent, but ACK and CANCEL, and
+// the responses, but 100 Trying, get the tags their Supported header
+// lacks.
+func (this *provider) SetSupported(extensions []string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.supported = extensions
+}
+
+func (this *provider) GetAccept() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.accept
+}
+
+// SetAccept sets the content types of the bodies this provider accepts,
+// none by default. The OPTIONS sent, their 2xx responses and the 415
+// Unsupported Media Type responses get an Accept header listing them,
+// unless they have one.
+func (this *provider) SetAccept(contentTypes []string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.accept = contentTypes
+}
+
+func (this *provider) stampSupported(msg Message) {
+	extensions := this.GetSupported()
+	if len(extensions) == 0 {
+		return
+	}
+	if req, ok := msg.(Request); ok && (req.GetMethod() == ACK || req.GetMethod() == CANCEL) {
+		return
+	}
+	if resp, ok := msg.(Response); ok && resp.GetStatusCode() == TRYING {
+		return
+	}
+	h := msg.GetHeader()
+	for _, ext := range extensions {
+		if !hasToken(strings.Join(h.Values("Supported"), ", "), strings.ToLower(ext)) {
+			h.Add("Supported", ext)
+		}
+	}
+}
+
+// hasOptionTag reports whether tag is listed by the name header of msg,
+// such as Supported or Require.
+func hasOptionTag(msg Message, name, tag string) bool {
+	return hasToken(strings.Join(msg.GetHeader().Values(name), ", "), strings.ToLower(tag))
+}
+
+// GetOptionTags returns the option tags listed by the name header of msg,
+// such as Supported, Require or Unsupported.
+func GetOptionTags(msg Message, name string) []string {
+	var tags []string
+	for _, v := range msg.GetHeader().Values(name) {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// IsSupported reports whether the sender of msg supports the extension of
+// tag, listing it in Supported or Require.
+func IsSupported(msg Message, tag string) bool {
+	return hasOptionTag(msg, "Supported", tag) || hasOptionTag(msg, "Require", tag)
+}
+
+// IsRequired reports whether the sender of msg requires the extension of
+// tag, listing it in Require.
+func IsRequired(msg Message, tag string) bool {
+	return hasOptionTag(msg, "Require", tag)
+}
+
+// AddOptionTag adds tag to the name header of msg, such as Supported or
+// Require, unless it lists it.
+func AddOptionTag(msg Message, name, tag string) {
+	if !hasOptionTag(msg, name, tag) {
+		msg.GetHeader().Add(name, tag)
+	}
+}
+
+// GetUnsupported returns the option tags required by req which extensions
+// lack, for req to be rejected with CreateBadExtensionResponse.
+func GetUnsupported(req Request, extensions []string) []string {
+	var unsupported []string
+	for _, tag := range GetOptionTags(req, "Require") {
+		if !containsToken(extensions, tag) {
+			unsupported = append(unsupported, tag)
+		}
+	}
+	return unsupported
+}
+
+// CreateBadExtensionResponse returns the 420 Bad Extension rejecting req,
+// which requires the extensions of unsupported, listed in its Unsupported
+// header (RFC 3261 8.2.2.3).
+func CreateBadExtensionResponse(req Request, unsupported []string) Response {
+	resp := CreateResponse(req, BAD_EXTENSION)
+	setList(resp.GetHeader(), "Unsupported", unsupported)
+	return resp
+}
+
+func (this *provider) stampAccept(msg Message) {
+	contentTypes := this.GetAccept()
+	if len(contentTypes) == 0 {
+		return
+	}
+	switch m := msg.(type) {
+	case Request:
+		if m.GetMethod() != OPTIONS {
+			return
+		}
+	case Response:
+		code := m.GetStatusCode()
+		if code != UNSUPPORTED_MEDIA_TYPE && !(code/100 == 2 && getCSeqMethod(m) == OPTIONS) {
+			return
+		}
+	}
+	setList(msg.GetHeader(), "Accept", contentTypes)
+}
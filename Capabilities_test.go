@@ -0,0 +1,91 @@
+package sip
+
+import (
+	"context"
+	"sip/header"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCapabilities(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	sent := captureSends(p)
+	p.SetAllowedMethods([]string{INVITE, ACK, BYE, CANCEL, OPTIONS})
+	p.SetSupported([]string{"timer", "replaces"})
+	p.SetEventPackages([]string{"dialog"})
+	p.SetAccept([]string{CONTENTTYPE_SDP})
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.3", 5060, UDP)}, nil
+	}))
+
+	capabilities := p.Capabilities()
+	if strings.Join(capabilities.Extensions, ",") != "timer,replaces" || capabilities.ContentTypes[0] != CONTENTTYPE_SDP {
+		t.Errorf("capabilities %+v", capabilities)
+	}
+
+	//the answer to OPTIONS
+	req := newServerTestRequest(OPTIONS, "UDP", "z9hG4bKcap1")
+	h := capabilities.CreateOptionsResponse(req).GetHeader()
+	for name, want := range map[string]string{
+		"Allow":        "INVITE, ACK, BYE, CANCEL, OPTIONS",
+		"Supported":    "timer, replaces",
+		"Allow-Events": "dialog",
+		"Accept":       CONTENTTYPE_SDP,
+	} {
+		if v := h.Get(name); v != want {
+			t.Errorf("%s: %s, want %s", name, v, want)
+		}
+	}
+
+	//the requests sent, with the tags their Supported lacks
+	invite := NewRequest(INVITE, "sip:bob@192.0.2.3", nil)
+	invite.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.10;branch=z9hG4bKcap2")
+	invite.GetHeader().Set("Supported", "answermode")
+	if err := p.SendRequest(invite); err != nil {
+		t.Fatal(err)
+	}
+	if v := strings.Join(sent.last().GetHeader().Values("Supported"), ", "); v != "answermode, timer, replaces" {
+		t.Errorf("Supported %s", v)
+	}
+	if v := sent.last().GetHeader().Get("Accept"); v != "" {
+		t.Errorf("INVITE Accept %s", v)
+	}
+
+	//415 lists what is accepted
+	if err := p.SendResponse(CreateResponse(req, UNSUPPORTED_MEDIA_TYPE)); err != nil {
+		t.Fatal(err)
+	}
+	if v := sent.last().GetHeader().Get("Accept"); v != CONTENTTYPE_SDP {
+		t.Errorf("415 Accept %s", v)
+	}
+
+	//the feature tags of a Contact
+	contact := header.NewContact()
+	capabilities.SetFeatureTags(contact)
+	if v, _ := contact.GetFeatureTag(header.FeatureTag_METHODS); strings.Join(v, ",") != "INVITE,ACK,BYE,CANCEL,OPTIONS" {
+		t.Errorf("methods %v", v)
+	}
+	if v, _ := contact.GetFeatureTag(header.FeatureTag_EVENTS); strings.Join(v, ",") != "dialog" {
+		t.Errorf("events %v", v)
+	}
+}
+
+func TestStackCapabilities(t *testing.T) {
+	s := newStack(TraceOff())
+	s.SetAllowedMethods([]string{INVITE, ACK})
+	p1 := s.CreateProvider()
+	p1.SetSupported([]string{"timer"})
+	p2 := s.CreateProvider()
+	p2.SetAllowedMethods([]string{INVITE, SUBSCRIBE})
+	p2.SetSupported([]string{"Timer", "100rel"})
+
+	//the providers are merged in no particular order
+	capabilities := s.Capabilities()
+	if v := capabilities.Methods; len(v) != 3 || !containsToken(v, INVITE) || !containsToken(v, ACK) || !containsToken(v, SUBSCRIBE) {
+		t.Errorf("methods %v", v)
+	}
+	if v := capabilities.Extensions; len(v) != 2 || !containsToken(v, "timer") || !containsToken(v, "100rel") {
+		t.Errorf("extensions %v", v)
+	}
+}
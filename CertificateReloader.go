@@ -0,0 +1,161 @@
+package sip
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A CertificateReloader serves the certificate of a TLS transport from its
+// PEM files, and loads them again on Reload or, once watched, whenever
+// they change. Transports keep running: new handshakes get the new
+// certificate, established connections keep theirs. A failed reload keeps
+// the previous certificate and is reported to the handler.
+type CertificateReloader interface {
+	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+	Reload() error
+	GetLastReload() time.Time
+
+	Watch(interval time.Duration)
+	Stop()
+
+	SetReloadHandler(handler CertificateReloadHandler)
+	SetClock(clock Clock)
+}
+
+type CertificateReloadHandler func(err error)
+
+////////////////////Implementation////////////////////////
+
+type certificateReloader struct {
+	mutex sync.Mutex
+
+	certFile string
+	keyFile  string
+
+	certificate *tls.Certificate
+	modified    time.Time
+	lastReload  time.Time
+
+	handler CertificateReloadHandler
+	clock   Clock
+	quit    chan bool
+}
+
+// NewCertificateReloader loads the certificate and key of certFile and
+// keyFile, failing if they cannot be loaded.
+func NewCertificateReloader(certFile, keyFile string) (CertificateReloader, error) {
+	this := &certificateReloader{}
+
+	this.certFile = certFile
+	this.keyFile = keyFile
+	this.clock = RealClock
+
+	if err := this.Reload(); err != nil {
+		return nil, err
+	}
+	return this, nil
+}
+
+func (this *certificateReloader) SetReloadHandler(handler CertificateReloadHandler) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.handler = handler
+}
+
+func (this *certificateReloader) SetClock(clock Clock) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.clock = clock
+}
+
+func (this *certificateReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.certificate, nil
+}
+
+func (this *certificateReloader) GetLastReload() time.Time {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.lastReload
+}
+
+// Reload loads the files again.
+func (this *certificateReloader) Reload() error {
+	modified := this.getModified()
+	certificate, err := tls.LoadX509KeyPair(this.certFile, this.keyFile)
+
+	this.mutex.Lock()
+	handler := this.handler
+	if err == nil {
+		this.certificate = &certificate
+		this.modified = modified
+		this.lastReload = this.clock.Now()
+	}
+	this.mutex.Unlock()
+
+	if handler != nil {
+		handler(err)
+	}
+	return err
+}
+
+// Watch checks the modification time of the files every interval, and
+// reloads them when it changes, until Stop.
+func (this *certificateReloader) Watch(interval time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.quit != nil {
+		return
+	}
+	this.quit = make(chan bool)
+
+	ticker := this.clock.NewTicker(interval)
+	go func(quit chan bool) {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C():
+				this.mutex.Lock()
+				changed := !this.getModified().Equal(this.modified)
+				this.mutex.Unlock()
+
+				if changed {
+					this.Reload()
+				}
+			}
+		}
+	}(this.quit)
+}
+
+func (this *certificateReloader) Stop() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.quit != nil {
+		close(this.quit)
+		this.quit = nil
+	}
+}
+
+// getModified returns the latest modification time of the files.
+func (this *certificateReloader) getModified() time.Time {
+	var modified time.Time
+	for _, file := range []string{this.certFile, this.keyFile} {
+		if fi, err := os.Stat(file); err == nil && fi.ModTime().After(modified) {
+			modified = fi.ModTime()
+		}
+	}
+	return modified
+}
@@ -0,0 +1,136 @@
+package sip
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCertificate(t *testing.T, certFile, keyFile, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if _, err := NewCertificateReloader(certFile, keyFile); err == nil {
+		t.Fatal("loaded missing files")
+	}
+
+	writeCertificate(t, certFile, keyFile, "a.example.com")
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloads := make(chan error, 4)
+	reloader.SetReloadHandler(func(err error) { reloads <- err })
+
+	tr := newTransport(TLS, "127.0.0.1", 0, nil)
+	tr.SetCertificateReloader(reloader)
+	tr.SetSessionTicketKeys([][32]byte{{1, 2, 3}})
+	if err := tr.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.lner.Close()
+	go func() {
+		for {
+			conn, err := tr.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("x"))
+			conn.Close()
+		}
+	}()
+
+	address := tr.lner.Addr().String()
+	dial := func(cache tls.ClientSessionCache) tls.ConnectionState {
+		conn, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true, ClientSessionCache: cache})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		//reading lets the client take the session ticket
+		conn.Read(make([]byte, 1))
+		return conn.ConnectionState()
+	}
+
+	cache := tls.NewLRUClientSessionCache(4)
+	if cn := dial(cache).PeerCertificates[0].Subject.CommonName; cn != "a.example.com" {
+		t.Fatalf("certificate %s", cn)
+	}
+	if !dial(cache).DidResume {
+		t.Error("session not resumed")
+	}
+
+	//an explicit reload switches new handshakes to the new certificate
+	writeCertificate(t, certFile, keyFile, "b.example.com")
+	if err := reloader.Reload(); err != nil || <-reloads != nil {
+		t.Fatal(err)
+	}
+	if cn := dial(nil).PeerCertificates[0].Subject.CommonName; cn != "b.example.com" {
+		t.Fatalf("certificate %s after reload", cn)
+	}
+
+	//a watched reloader picks up changed files
+	reloader.Watch(10 * time.Millisecond)
+	defer reloader.Stop()
+	writeCertificate(t, certFile, keyFile, "c.example.com")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	select {
+	case err := <-reloads:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("changed files not reloaded")
+	}
+	if cn := dial(nil).PeerCertificates[0].Subject.CommonName; cn != "c.example.com" {
+		t.Fatalf("certificate %s after the files changed", cn)
+	}
+
+	//a broken file keeps the current certificate
+	reloader.Stop()
+	os.WriteFile(certFile, []byte("garbage"), 0600)
+	if err := reloader.Reload(); err == nil || <-reloads == nil {
+		t.Fatal("broken certificate loaded")
+	}
+	cert, _ := reloader.GetCertificate(nil)
+	if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err != nil || leaf.Subject.CommonName != "c.example.com" {
+		t.Errorf("certificate after a failed reload: %v", err)
+	}
+}
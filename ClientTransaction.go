@@ -1,15 +1,47 @@
 package sip
 
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
 type ClientTransaction interface {
 	Transaction
 
 	SendRequest() error
+	SendRequestContext(ctx context.Context) error
 	CreateCancel() (Request, error)
 	CreateAck() (Request, error)
 }
 
+var ErrNoAck = errors.New("sip: no non-2xx final response to acknowledge")
+
+var ErrNoCancel = errors.New("sip: no pending request to cancel")
+
 type clientTransaction struct {
 	transaction
+
+	provider *provider
+	hops     []Hop
+	flow     Hop //the flow to send over, see setFlow
+
+	mutex    sync.Mutex
+	final    chan bool
+	response Response
+	err      error
+
+	//state machine of RFC 3261 17.1, guarded by mutex
+	reliable   bool
+	peer       string
+	interval   time.Duration
+	retransmit Timer //A or E
+	timeout    Timer //B or F
+	linger     Timer //D or K
+	ack        Request
+	cancelling bool //the context is done, CANCEL on the first provisional response
 }
 
 func newClientTransaction(request Request) *clientTransaction {
@@ -18,17 +50,489 @@ func newClientTransaction(request Request) *clientTransaction {
 			request: request,
 			quit:    make(chan bool),
 		},
+		final: make(chan bool),
 	}
 }
 
+// SendRequest selects the next hop of the request and hands it to the
+// provider for resolution. It never blocks on DNS: a resolution failure
+// completes the transaction with a *TransportError instead.
 func (this *clientTransaction) SendRequest() error {
+	return this.SendRequestContext(context.Background())
+}
+
+func (this *clientTransaction) send() error {
+	h, err := GetNextHop(this.request)
+	if err != nil {
+		return err
+	}
+	if this.provider == nil {
+		return nil
+	}
+	if err := this.provider.checkSecure(this.request); err != nil {
+		return err
+	}
+	d, _ := this.GetDialog().(*dialog)
+	if !this.isProxied() {
+		this.provider.stampSessionExpires(this.request, d)
+	}
+	//the requests of a dialog reuse the connection it was set up over
+	if d != nil {
+		if flow := this.provider.getFlowHop(d); flow != nil {
+			this.mutex.Lock()
+			this.hops = []Hop{flow}
+			this.mutex.Unlock()
+			this.start()
+			return nil
+		}
+	}
+	//the requests to a client registered with outbound go over its flow
+	this.mutex.Lock()
+	flow := this.flow
+	if flow != nil {
+		this.hops = []Hop{flow}
+	}
+	this.mutex.Unlock()
+	if flow != nil {
+		this.start()
+		return nil
+	}
+	this.provider.resolve(this, h)
 	return nil
 }
 
+// setFlow has the request sent over flow rather than to the hops it
+// resolves to, see Outbound.
+func (this *clientTransaction) setFlow(flow Hop) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.flow = flow
+}
+
+// Close abandons the transaction: its timers are stopped and responses are
+// no longer matched to it.
+func (this *clientTransaction) Close() {
+	this.mutex.Lock()
+	state := this.GetState()
+	this.setTerminated()
+	this.mutex.Unlock()
+
+	//abandoned before any response, the request probed its destination
+	//for nothing
+	if h := this.getHop(); h != nil && this.provider != nil && (state == TRANSACTIONSTATE_CALLING || state == TRANSACTIONSTATE_TRYING) {
+		this.provider.cancelPeerProbe(h)
+	}
+	this.transaction.Close()
+	this.leave()
+}
+
+// CreateCancel returns the CANCEL of the request, as in RFC 3261 9.1: it
+// shares the Request-URI, the top Via, the From, the To, the Call-ID, the
+// CSeq number and the Route of the request. It is to be sent in a client
+// transaction of its own, once a provisional response was received; a
+// request with a final response, an ACK or a CANCEL cannot be cancelled.
 func (this *clientTransaction) CreateCancel() (Request, error) {
-	return nil, nil
+	method := this.request.GetMethod()
+	if resp, err := this.getFinal(); method == ACK || method == CANCEL || resp != nil || err != nil {
+		return nil, ErrNoCancel
+	}
+
+	cancel := NewRequest(CANCEL, this.request.GetRequestURIString(), nil)
+	h := cancel.GetHeader()
+	h.Set("Via", getTopVia(this.request))
+	h.Set("Max-Forwards", "70")
+	for _, name := range []string{"From", "To", "Call-Id"} {
+		if v := this.request.GetHeader().Get(name); v != "" {
+			h.Set(name, v)
+		}
+	}
+	cseq, _ := getCSeq(this.request)
+	h.Set("Cseq", strconv.Itoa(cseq)+" "+CANCEL)
+	for _, route := range this.request.GetHeader()["Route"] {
+		h.Add("Route", route)
+	}
+	return cancel, nil
 }
 
+// CreateAck returns the ACK of the non-2xx final response to an INVITE, as
+// in RFC 3261 17.1.1.3: it shares the Request-URI, the top Via, the From,
+// the Call-ID, the CSeq number and the Route of the INVITE, and takes the
+// To of the response. The ACK of a 2xx belongs to the dialog instead.
 func (this *clientTransaction) CreateAck() (Request, error) {
-	return nil, nil
+	resp, _ := this.getFinal()
+	if this.request.GetMethod() != INVITE || resp == nil || resp.GetStatusCode() < MULTIPLE_CHOICES {
+		return nil, ErrNoAck
+	}
+
+	ack := NewRequest(ACK, this.request.GetRequestURIString(), nil)
+	h := ack.GetHeader()
+	h.Set("Via", getTopVia(this.request))
+	h.Set("Max-Forwards", "70")
+	for _, name := range []string{"From", "Call-Id"} {
+		if v := this.request.GetHeader().Get(name); v != "" {
+			h.Set(name, v)
+		}
+	}
+	h.Set("To", resp.GetHeader().Get("To"))
+	cseq, _ := getCSeq(this.request)
+	h.Set("Cseq", strconv.Itoa(cseq)+" "+ACK)
+	for _, route := range this.request.GetHeader()["Route"] {
+		h.Add("Route", route)
+	}
+	return ack, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// start sends the request to its resolved next hop. An INVITE enters the
+// calling state with Timers A and B, another request the trying state with
+// Timers E and F; A and E only run over unreliable transports. Unless the
+// retransmit timer of the transaction is set, the first retransmission
+// interval is estimated from the RTT of the destination.
+func (this *clientTransaction) start() {
+	clock := this.provider.GetClock()
+	t1 := this.getT1()
+	this.provider.stampRport(this.request)
+
+	this.mutex.Lock()
+	if len(this.hops) > 0 {
+		this.peer = this.hops[0].String()
+		//the resolver may have chosen another transport than the Via's
+		setViaTransport(this.request, this.hops[0].GetTransport())
+	}
+	this.reliable = isReliable(this.request)
+	this.interval = t1
+	if rtt := this.provider.GetRTTEstimator(); rtt != nil && this.retransmitTimer == 0 && this.peer != "" {
+		this.interval = rtt.GetT1(this.peer)
+	}
+	if this.request.GetMethod() == INVITE {
+		this.SetState(TRANSACTIONSTATE_CALLING)
+	} else {
+		this.SetState(TRANSACTIONSTATE_TRYING)
+	}
+	if !this.reliable {
+		this.retransmit = clock.AfterFunc(this.interval, this.onRetransmit)
+	}
+	this.timeout = clock.AfterFunc(64*t1, this.onTimeout)
+	this.mutex.Unlock()
+
+	this.recordSent(clock.Now())
+	this.provider.metrics.addTransaction()
+
+	if err := this.provider.sendRequest(this.request, this.getHop()); err != nil {
+		this.processError(&TransportError{Err: err})
+	}
+}
+
+// onRetransmit fires Timer A or E. Timer A doubles in the calling state;
+// Timer E doubles up to T2 in the trying state and stays at T2 in the
+// proceeding state.
+func (this *clientTransaction) onRetransmit() {
+	this.mutex.Lock()
+	state := this.GetState()
+	if this.request.GetMethod() == INVITE {
+		if state != TRANSACTIONSTATE_CALLING {
+			this.mutex.Unlock()
+			return
+		}
+		this.interval *= 2
+	} else {
+		switch state {
+		case TRANSACTIONSTATE_TRYING:
+			if this.interval *= 2; this.interval > TIMER_T2 {
+				this.interval = TIMER_T2
+			}
+		case TRANSACTIONSTATE_PROCEEDING:
+			this.interval = TIMER_T2
+		default:
+			this.mutex.Unlock()
+			return
+		}
+	}
+	this.retransmit = this.provider.GetClock().AfterFunc(this.interval, this.onRetransmit)
+	this.mutex.Unlock()
+
+	this.recordRetransmission()
+	this.provider.metrics.addRetransmission()
+	this.provider.getMetrics().AddRetransmission(this.request.GetMethod())
+
+	if err := this.provider.sendRequest(this.request, this.getHop()); err != nil {
+		this.processError(&TransportError{Err: err})
+	}
+}
+
+// onTimeout fires Timer B or F: the transaction expired without a final
+// response.
+func (this *clientTransaction) onTimeout() {
+	switch this.GetState() {
+	case TRANSACTIONSTATE_COMPLETED, TRANSACTIONSTATE_TERMINATED:
+		return
+	}
+	this.processError(&TimeoutError{Transaction: this})
+}
+
+// processResponse runs a response through the state machine and reports
+// whether it is passed up to the listeners. A provisional response moves
+// the transaction to the proceeding state. A final response completes it;
+// a 2xx to an INVITE terminates it at once, as do all final responses over
+// a reliable transport, while otherwise the completed state absorbs the
+// retransmissions of the final response until Timer D or K fires. A
+// non-2xx final response to an INVITE is acknowledged, again for each of
+// its retransmissions. The destination is reported up to the PeerHealth of
+// the provider on the first response, and failed on a 503.
+func (this *clientTransaction) processResponse(resp Response) bool {
+	statusCode := resp.GetStatusCode()
+	invite := this.request.GetMethod() == INVITE
+
+	if this.provider != nil && this.recordResponse(this.provider.GetClock().Now()) {
+		this.sampleRTT()
+		if h := this.getHop(); h != nil && statusCode != SERVICE_UNAVAILABLE {
+			this.provider.reportPeerSuccess(h, this.GetStats().RTT)
+		}
+	}
+
+	this.mutex.Lock()
+	switch this.GetState() {
+	case TRANSACTIONSTATE_TERMINATED:
+		this.mutex.Unlock()
+		return false
+
+	case TRANSACTIONSTATE_COMPLETED:
+		ack := this.ack
+		this.mutex.Unlock()
+		if ack != nil && statusCode >= MULTIPLE_CHOICES {
+			this.sendAck(ack)
+		}
+		return false
+	}
+
+	if statusCode < OK {
+		this.SetState(TRANSACTIONSTATE_PROCEEDING)
+		if invite {
+			//Timer B only bounds the calling state
+			stopTimer(this.retransmit)
+			stopTimer(this.timeout)
+		}
+		cancelling := this.cancelling
+		this.cancelling = false
+		this.mutex.Unlock()
+		if cancelling {
+			this.sendCancel()
+		}
+		return true
+	}
+
+	var linger time.Duration
+	terminated := false
+	if invite && statusCode < MULTIPLE_CHOICES {
+		terminated = this.setTerminated()
+	} else {
+		stopTimer(this.retransmit)
+		stopTimer(this.timeout)
+		this.SetState(TRANSACTIONSTATE_COMPLETED)
+		if !this.reliable {
+			linger = TIMER_K
+			if invite {
+				linger = TIMER_D
+			}
+		}
+	}
+	this.mutex.Unlock()
+
+	if h := this.getHop(); h != nil && this.provider != nil && statusCode == SERVICE_UNAVAILABLE {
+		this.provider.reportPeerFailure(h, PEERFAILURE_SERVICE_UNAVAILABLE)
+	}
+	this.complete(resp, nil)
+
+	if invite && statusCode >= MULTIPLE_CHOICES {
+		if ack, err := this.CreateAck(); err == nil {
+			this.mutex.Lock()
+			this.ack = ack
+			this.mutex.Unlock()
+			this.sendAck(ack)
+		}
+	}
+
+	switch {
+	case terminated:
+		this.leave()
+	case linger == 0:
+		this.terminate()
+	default:
+		this.mutex.Lock()
+		if this.GetState() == TRANSACTIONSTATE_COMPLETED {
+			this.linger = this.provider.GetClock().AfterFunc(linger, this.terminate)
+		}
+		this.mutex.Unlock()
+	}
+	return true
+}
+
+// processError terminates the transaction with a timeout or transport
+// error, reported to the listeners as well: a timeout as a
+// TIMEOUT_TRANSACTION, a transport error as a TIMEOUT_RETRANSMIT. The
+// destination is reported failed to the PeerHealth of the provider first.
+func (this *clientTransaction) processError(err error) {
+	if h := this.getHop(); h != nil && this.provider != nil {
+		if _, ok := err.(*TimeoutError); ok {
+			this.provider.reportPeerFailure(h, PEERFAILURE_TIMEOUT)
+		} else {
+			this.provider.reportPeerFailure(h, PEERFAILURE_TRANSPORT)
+		}
+	}
+	if this.failover() {
+		return
+	}
+
+	this.mutex.Lock()
+	terminated := this.setTerminated()
+	this.mutex.Unlock()
+
+	this.complete(nil, err)
+	if terminated {
+		this.leave()
+	}
+
+	if this.provider != nil {
+		if _, ok := err.(*TimeoutError); ok {
+			this.provider.metrics.addTimeout()
+			this.provider.getMetrics().AddTimeout(this.request.GetMethod())
+		}
+		this.provider.listeners.fireTimeout(newErrorTimeoutEvent(this, err))
+	}
+}
+
+// failover sends the request to the next address resolved for its next
+// hop, after a transport error or a timeout before any response, in a new
+// client transaction (RFC 3263 4.3): the request gets a new branch, and the
+// responses to the previous transaction are no longer matched. It reports
+// whether there was an address left to try, the blacklisted ones skipped.
+func (this *clientTransaction) failover() bool {
+	this.mutex.Lock()
+	state := this.GetState()
+	hops := this.hops
+	this.mutex.Unlock()
+	if this.provider == nil || len(hops) < 2 || (state != TRANSACTIONSTATE_CALLING && state != TRANSACTIONSTATE_TRYING) {
+		return false
+	}
+	//the addresses which went down meanwhile are skipped
+	next := hops[1:]
+	for len(next) > 0 && !this.provider.isAvailable(next[0]) {
+		next = next[1:]
+	}
+	if len(next) == 0 {
+		return false
+	}
+
+	this.mutex.Lock()
+	stopTimer(this.retransmit)
+	stopTimer(this.timeout)
+	this.hops = next
+	this.mutex.Unlock()
+
+	this.provider.renewClientTransaction(this)
+	this.start()
+	return true
+}
+
+// getHop returns the resolved next hop of the request, or nil to let the
+// provider find it.
+func (this *clientTransaction) getHop() Hop {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if len(this.hops) == 0 {
+		return nil
+	}
+	return this.hops[0]
+}
+
+// sampleRTT reports the RTT of the transaction to the metrics and, unless
+// the request was retransmitted, to the RTT estimator of its destination.
+func (this *clientTransaction) sampleRTT() {
+	stats := this.GetStats()
+	this.provider.metrics.addRTT(stats)
+
+	this.mutex.Lock()
+	peer := this.peer
+	this.mutex.Unlock()
+
+	if rtt := this.provider.GetRTTEstimator(); rtt != nil && peer != "" && stats.Retransmissions == 0 {
+		rtt.Sample(peer, stats.RTT)
+	}
+}
+
+func (this *clientTransaction) sendAck(ack Request) {
+	if this.provider == nil {
+		return
+	}
+	if err := this.provider.sendRequest(ack, this.getHop()); err != nil {
+		this.provider.getLogger(COMPONENT_TRANSACTION).Log(LOG_ERROR, "Sending ACK failed", "error", err)
+	}
+}
+
+// terminate ends the transaction when Timer D or K fires.
+func (this *clientTransaction) terminate() {
+	this.mutex.Lock()
+	terminated := this.setTerminated()
+	this.mutex.Unlock()
+
+	if terminated {
+		this.leave()
+	}
+}
+
+// setTerminated moves the transaction to the terminated state and stops its
+// timers. It reports false if it was terminated already; mutex is held.
+func (this *clientTransaction) setTerminated() bool {
+	if this.GetState() == TRANSACTIONSTATE_TERMINATED {
+		return false
+	}
+	this.SetState(TRANSACTIONSTATE_TERMINATED)
+	stopTimer(this.retransmit)
+	stopTimer(this.timeout)
+	stopTimer(this.linger)
+	return true
+}
+
+// leave removes a terminated transaction from the provider.
+func (this *clientTransaction) leave() {
+	if this.provider == nil {
+		return
+	}
+	this.provider.forgetClientTransaction(this)
+	this.provider.spawn(resourceLeave, func() {
+		select {
+		case this.provider.leave <- this:
+		case <-this.provider.quit:
+		}
+	})
+}
+
+func (this *clientTransaction) complete(resp Response, err error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	select {
+	case <-this.final:
+		//only the first final response counts
+	default:
+		this.response = resp
+		this.err = err
+		close(this.final)
+	}
+}
+
+func (this *clientTransaction) getFinal() (Response, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.response, this.err
+}
+
+func stopTimer(t Timer) {
+	if t != nil {
+		t.Stop()
+	}
 }
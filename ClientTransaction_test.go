@@ -0,0 +1,216 @@
+package sip
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestResponseMatchingWithRport(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	go p.Run()
+	defer p.Stop()
+
+	req := NewRequest(OPTIONS, "sip:bob@198.51.100.7:5060", nil)
+	req.GetHeader().Set("Via", "SIP/2.0/UDP 192.168.1.20:5060;rport;branch=z9hG4bKnat1")
+	req.GetHeader().Set("Cseq", "1 OPTIONS")
+	ct := p.GetNewClientTransaction(req).(*clientTransaction)
+
+	//the response names the NAT binding in the Via and comes from another
+	//port than the request was sent to
+	newResponse := func(branch string) Response {
+		resp := CreateResponse(req, OK)
+		resp.GetHeader().Set("Via", "SIP/2.0/UDP 192.168.1.20:5060;rport=61000;received=203.0.113.9;branch="+branch)
+		resp.SetMessageInfo(&MessageInfo{Network: UDP, RemoteAddr: &net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 5080}})
+		return resp
+	}
+
+	p.processMessage(newResponse("z9hG4bKother"))
+	select {
+	case <-ct.final:
+		t.Fatal("a response of another transaction was matched")
+	default:
+	}
+
+	resp := newResponse("z9hG4bKnat1")
+	p.processMessage(resp)
+	select {
+	case <-ct.final:
+	case <-time.After(time.Second):
+		t.Fatal("response from another source port was discarded")
+	}
+	if got, err := ct.getFinal(); err != nil || got != resp {
+		t.Fatalf("final = %v, %v", got, err)
+	}
+	//over UDP the completed transaction absorbs retransmissions until Timer K
+	if ct.GetState() != TRANSACTIONSTATE_COMPLETED || p.matchClientTransaction(resp) != ct {
+		t.Errorf("state = %d after the final response, want completed", ct.GetState())
+	}
+}
+
+func TestInviteClientTransaction(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+
+	invite := NewRequest(INVITE, "sip:bob@192.0.2.7", nil)
+	invite.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.1;branch=z9hG4bKinvite")
+	invite.GetHeader().Set("From", "<sip:alice@example.com>;tag=1")
+	invite.GetHeader().Set("To", "<sip:bob@example.com>")
+	invite.GetHeader().Set("Call-Id", "a84b4c76e66710")
+	invite.GetHeader().Set("Cseq", "314159 INVITE")
+	invite.GetHeader().Set("Route", "<sip:proxy.example.com;lr>")
+	ct := p.GetNewClientTransaction(invite).(*clientTransaction)
+	ct.hops = []Hop{NewHop("192.0.2.10", 5060, UDP)}
+	ct.start()
+
+	//Timer A doubles while calling
+	clock.Advance(TIMER_T1)
+	clock.Advance(2 * TIMER_T1)
+	if ct.interval != 4*TIMER_T1 || sent.len() != 3 {
+		t.Fatalf("Timer A = %v after %d transmissions, want %v", ct.interval, sent.len(), 4*TIMER_T1)
+	}
+
+	//a provisional response stops Timers A and B
+	if !ct.processResponse(CreateResponse(invite, RINGING)) {
+		t.Fatal("180 not passed up")
+	}
+	if stats := ct.GetStats(); stats.Retransmissions != 2 || stats.RTT != 3*TIMER_T1 {
+		t.Errorf("stats = %+v", stats)
+	}
+	//the RTT of a retransmitted request is ambiguous and not sampled
+	if m := p.GetTransactionMetrics(); m.Transactions != 1 || m.Retransmissions != 2 || m.RTTSamples != 0 {
+		t.Errorf("metrics = %+v", m)
+	}
+	clock.Advance(64 * TIMER_T1)
+	if ct.GetState() != TRANSACTIONSTATE_PROCEEDING {
+		t.Fatalf("state = %d after 64*T1 in proceeding", ct.GetState())
+	}
+
+	busy := CreateResponse(invite, BUSY_HERE)
+	busy.GetHeader().Set("To", "<sip:bob@example.com>;tag=8321234356")
+	if !ct.processResponse(busy) || ct.GetState() != TRANSACTIONSTATE_COMPLETED {
+		t.Fatalf("state = %d after 486, want completed", ct.GetState())
+	}
+	ack, err := ct.CreateAck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := sent.last().(Request); last.GetMethod() != ACK {
+		t.Fatalf("%s sent after 486, want ACK", last.GetMethod())
+	}
+	h := ack.GetHeader()
+	if ack.GetMethod() != ACK || ack.GetRequestURIString() != invite.GetRequestURIString() || h.Get("Cseq") != "314159 ACK" ||
+		h.Get("To") != busy.GetHeader().Get("To") || getBranch(ack) != "z9hG4bKinvite" || h.Get("Route") != "<sip:proxy.example.com;lr>" {
+		t.Errorf("ACK = %v", h)
+	}
+
+	//retransmissions of the final response are absorbed until Timer D
+	n := sent.len()
+	if ct.processResponse(busy) || sent.len() != n+1 {
+		t.Error("retransmitted 486 passed up or not acknowledged")
+	}
+	clock.Advance(TIMER_D)
+	if ct.GetState() != TRANSACTIONSTATE_TERMINATED || p.matchClientTransaction(busy) != nil {
+		t.Errorf("state = %d after Timer D, want terminated", ct.GetState())
+	}
+}
+
+func TestClientTransactionTimeout(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	var events []string
+	p.AddListener(&recordingListener{name: "listener", events: &events})
+
+	options := NewRequest(OPTIONS, "sip:bob@192.0.2.7", nil)
+	options.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.1;branch=z9hG4bKoptions")
+	options.GetHeader().Set("Cseq", "1 OPTIONS")
+	ct := p.GetNewClientTransaction(options).(*clientTransaction)
+	ct.start()
+
+	//Timer E doubles up to T2, and stays at T2 once proceeding
+	for _, d := range []time.Duration{TIMER_T1, 2 * TIMER_T1, 4 * TIMER_T1, TIMER_T2} {
+		clock.Advance(d)
+	}
+	if ct.interval != TIMER_T2 || sent.len() != 5 {
+		t.Fatalf("Timer E = %v after %d transmissions, want T2", ct.interval, sent.len())
+	}
+	ct.processResponse(CreateResponse(options, TRYING))
+	clock.Advance(TIMER_T2)
+	if ct.interval != TIMER_T2 || ct.GetState() != TRANSACTIONSTATE_PROCEEDING || sent.len() != 6 {
+		t.Fatalf("Timer E = %v in state %d", ct.interval, ct.GetState())
+	}
+
+	//Timer F fires at 64*T1 even when proceeding
+	clock.Advance(64 * TIMER_T1)
+	var te *TimeoutError
+	if _, err := ct.getFinal(); !errors.As(err, &te) {
+		t.Fatalf("final error = %v, want a *TimeoutError", err)
+	}
+	if ct.GetState() != TRANSACTIONSTATE_TERMINATED || len(events) != 1 || events[0] != "listener timeout" {
+		t.Errorf("state = %d, events = %v", ct.GetState(), events)
+	}
+}
+
+func TestClientTransactionTransportError(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	p.send = func(msg Message, h Hop) error { return errors.New("connection refused") }
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+
+	options := NewRequest(OPTIONS, "sip:bob@192.0.2.7", nil)
+	options.GetHeader().Set("Via", "SIP/2.0/TCP 192.0.2.1;branch=z9hG4bKtperr")
+	options.GetHeader().Set("Cseq", "1 OPTIONS")
+	ct := newClientTransaction(options)
+	ct.provider = p
+	ct.hops = []Hop{NewHop("192.0.2.7", 5060, TCP)}
+	ct.start()
+
+	var te *TransportError
+	if _, err := ct.getFinal(); !errors.As(err, &te) {
+		t.Fatalf("final error = %v, want a *TransportError", err)
+	}
+	if len(l.timeoutEvents) != 1 {
+		t.Fatalf("%d timeouts", len(l.timeoutEvents))
+	}
+	if timeout := l.timeoutEvents[0].GetTimeout(); timeout.GetValue() != TIMEOUT_RETRANSMIT || l.timeoutEvents[0].GetError() != te {
+		t.Errorf("timeout %s, error %v", timeout.String(), l.timeoutEvents[0].GetError())
+	}
+}
+
+func TestReliableClientTransaction(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+
+	options := NewRequest(OPTIONS, "sip:bob@192.0.2.7;transport=tcp", nil)
+	options.GetHeader().Set("Via", "SIP/2.0/TCP 192.0.2.1;branch=z9hG4bKtcp")
+	options.GetHeader().Set("Cseq", "1 OPTIONS")
+	ct := p.GetNewClientTransaction(options).(*clientTransaction)
+	ct.start()
+
+	//no Timer E over TCP, only Timer F
+	if clock.Pending() != 1 || sent.len() != 1 {
+		t.Fatalf("%d timers pending, want 1", clock.Pending())
+	}
+
+	//Timer K is zero: the final response terminates the transaction
+	clock.Advance(40 * time.Millisecond)
+	ct.processResponse(CreateResponse(options, OK))
+	if m := p.GetTransactionMetrics(); m.RTTSamples != 1 || m.MeanRTT() != 40*time.Millisecond || ct.GetStats().RTT != m.MaxRTT {
+		t.Errorf("metrics = %+v", m)
+	}
+	if ct.GetState() != TRANSACTIONSTATE_TERMINATED || clock.Pending() != 0 {
+		t.Errorf("state = %d with %d timers pending", ct.GetState(), clock.Pending())
+	}
+}
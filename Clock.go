@@ -0,0 +1,238 @@
+package sip
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A Clock is the source of time for every timer in the stack: transaction
+// timers, session timers, registration refreshes and keep-alives. Tests
+// inject a FakeClock and advance it instead of sleeping.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	NewTimer(d time.Duration) Timer
+	AfterFunc(d time.Duration, f func()) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+////////////////////Implementation////////////////////////
+
+// RealClock is the default Clock, backed by the time package.
+var RealClock Clock = &realClock{}
+
+type realClock struct {
+}
+
+func (this *realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (this *realClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (this *realClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{timer: time.NewTimer(d)}
+}
+
+func (this *realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return &realTimer{timer: time.AfterFunc(d, f)}
+}
+
+func (this *realClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{ticker: time.NewTicker(d)}
+}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (this *realTimer) C() <-chan time.Time {
+	return this.timer.C
+}
+
+func (this *realTimer) Stop() bool {
+	return this.timer.Stop()
+}
+
+func (this *realTimer) Reset(d time.Duration) bool {
+	return this.timer.Reset(d)
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (this *realTicker) C() <-chan time.Time {
+	return this.ticker.C
+}
+
+func (this *realTicker) Stop() {
+	this.ticker.Stop()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// FakeClock is a virtual Clock for deterministic tests. Time only moves when
+// Advance is called, which fires every timer and ticker that falls due, in
+// order, before returning.
+type FakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []*fakeTimer
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (this *FakeClock) Now() time.Time {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.now
+}
+
+func (this *FakeClock) Since(t time.Time) time.Duration {
+	return this.Now().Sub(t)
+}
+
+func (this *FakeClock) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: this, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+func (this *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &fakeTimer{clock: this, f: f}
+	t.Reset(d)
+	return t
+}
+
+func (this *FakeClock) NewTicker(d time.Duration) Ticker {
+	t := &fakeTimer{clock: this, c: make(chan time.Time, 1), period: d}
+	t.Reset(d)
+	return &fakeTicker{timer: t}
+}
+
+// Advance moves the clock forward by d, firing the timers which expire.
+func (this *FakeClock) Advance(d time.Duration) {
+	this.mutex.Lock()
+	end := this.now.Add(d)
+	this.mutex.Unlock()
+
+	for {
+		this.mutex.Lock()
+		if len(this.waiters) == 0 || this.waiters[0].when.After(end) {
+			this.now = end
+			this.mutex.Unlock()
+			return
+		}
+		t := this.waiters[0]
+		this.waiters = this.waiters[1:]
+		this.now = t.when
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+			this.schedule(t)
+		}
+		now := this.now
+		this.mutex.Unlock()
+
+		t.fire(now)
+	}
+}
+
+// Pending reports the number of timers waiting to fire.
+func (this *FakeClock) Pending() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return len(this.waiters)
+}
+
+func (this *FakeClock) schedule(t *fakeTimer) {
+	i := sort.Search(len(this.waiters), func(i int) bool {
+		return this.waiters[i].when.After(t.when)
+	})
+	this.waiters = append(this.waiters, nil)
+	copy(this.waiters[i+1:], this.waiters[i:])
+	this.waiters[i] = t
+}
+
+func (this *FakeClock) unschedule(t *fakeTimer) bool {
+	for i, w := range this.waiters {
+		if w == t {
+			this.waiters = append(this.waiters[:i], this.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	when   time.Time
+	period time.Duration
+	c      chan time.Time
+	f      func()
+}
+
+func (this *fakeTimer) C() <-chan time.Time {
+	return this.c
+}
+
+func (this *fakeTimer) Stop() bool {
+	this.clock.mutex.Lock()
+	defer this.clock.mutex.Unlock()
+
+	return this.clock.unschedule(this)
+}
+
+func (this *fakeTimer) Reset(d time.Duration) bool {
+	this.clock.mutex.Lock()
+	defer this.clock.mutex.Unlock()
+
+	active := this.clock.unschedule(this)
+	this.when = this.clock.now.Add(d)
+	this.clock.schedule(this)
+	return active
+}
+
+func (this *fakeTimer) fire(now time.Time) {
+	if this.f != nil {
+		this.f()
+		return
+	}
+	select {
+	case this.c <- now:
+	default:
+		//like time.Ticker, drop ticks for slow receivers
+	}
+}
+
+type fakeTicker struct {
+	timer *fakeTimer
+}
+
+func (this *fakeTicker) C() <-chan time.Time {
+	return this.timer.c
+}
+
+func (this *fakeTicker) Stop() {
+	this.timer.Stop()
+}
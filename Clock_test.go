@@ -0,0 +1,47 @@
+package sip
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	var fired []string
+	clock.AfterFunc(2*time.Second, func() { fired = append(fired, "B") })
+	clock.AfterFunc(500*time.Millisecond, func() { fired = append(fired, "A") })
+	stopped := clock.AfterFunc(time.Second, func() { fired = append(fired, "X") })
+	ticker := clock.NewTicker(time.Second)
+
+	if !stopped.Stop() {
+		t.Fatal("Stop of a pending timer should report true")
+	}
+
+	clock.Advance(1500 * time.Millisecond)
+	if len(fired) != 1 || fired[0] != "A" {
+		t.Fatalf("fired = %v, want [A]", fired)
+	}
+	select {
+	case tick := <-ticker.C():
+		if !tick.Equal(start.Add(time.Second)) {
+			t.Errorf("tick at %v, want %v", tick, start.Add(time.Second))
+		}
+	default:
+		t.Fatal("ticker did not fire")
+	}
+
+	clock.Advance(time.Second)
+	if len(fired) != 2 || fired[1] != "B" {
+		t.Fatalf("fired = %v, want [A B]", fired)
+	}
+	if clock.Since(start) != 2500*time.Millisecond {
+		t.Errorf("Since = %v, want 2.5s", clock.Since(start))
+	}
+
+	ticker.Stop()
+	if clock.Pending() != 0 {
+		t.Errorf("Pending = %d, want 0", clock.Pending())
+	}
+}
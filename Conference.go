@@ -0,0 +1,88 @@
+package sip
+
+import (
+	"errors"
+	"sip/address"
+	"sip/header"
+)
+
+// A conference is hosted by a focus (RFC 4579), a user agent reached at the
+// conference URI, which tags its Contact with the isfocus feature tag in
+// the dialogs it has with the participants. A conference is created ad hoc
+// by an INVITE to a conference factory URI, the focus answering with the
+// Contact of the new conference. A Dialog acting as a focus, see SetFocus,
+// tags the Contact of the requests it sends, and tells whether the peer is
+// one, see IsRemoteFocus.
+
+var ErrNotConferenceFactory = errors.New("sip: not a SIP conference factory URI")
+
+////////////////////Implementation////////////////////////
+
+// IsFocus reports whether contact, a Contact value, has the isfocus feature
+// tag.
+func IsFocus(contact string) bool {
+	c := parseContact(contact)
+	if c == nil {
+		return false
+	}
+	_, ok := c.GetFeatureTag(header.FeatureTag_ISFOCUS)
+	return ok
+}
+
+// GetFocusContact returns contact, a Contact value, with the isfocus
+// feature tag, or without it if focus is false.
+func GetFocusContact(contact string, focus bool) string {
+	c := parseContact(contact)
+	if c == nil {
+		return contact
+	}
+	if _, ok := c.GetFeatureTag(header.FeatureTag_ISFOCUS); ok == focus {
+		return contact
+	}
+	if focus {
+		c.SetFeatureTag(header.FeatureTag_ISFOCUS)
+	} else {
+		c.RemoveFeatureTag(header.FeatureTag_ISFOCUS)
+	}
+	return c.EncodeBody()
+}
+
+// IsConferenceFactory reports whether the Request-URI of req is one of the
+// conference factory URIs factories, compared as addresses-of-record.
+func IsConferenceFactory(req Request, factories ...string) bool {
+	aor, err := GetAOR(req.GetRequestURI())
+	if err != nil {
+		return false
+	}
+	for _, factory := range factories {
+		uri, err := ParseURI(factory)
+		if err != nil {
+			continue
+		}
+		if f, err := GetAOR(uri); err == nil && f == aor {
+			return true
+		}
+	}
+	return false
+}
+
+// NewConferenceURI returns the URI of a new conference created through
+// factory, a SIP conference factory URI: its user, suffixed with a random
+// identifier, at the host and with the parameters of factory.
+func NewConferenceURI(factory string) (string, error) {
+	uri, err := ParseURI(factory)
+	if err != nil {
+		return "", err
+	}
+	sipURI, ok := uri.(*address.SipURIImpl)
+	if !ok || sipURI.GetHost() == "" {
+		return "", ErrNotConferenceFactory
+	}
+	user := "conf"
+	if u := sipURI.GetUser(); u != "" {
+		user = u
+	}
+	sipURI.SetUser(user + "-" + randomHex(8))
+	sipURI.RemoveHeaders()
+	return sipURI.String(), nil
+}
@@ -0,0 +1,100 @@
+package sip
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFocusContact(t *testing.T) {
+	contact := GetFocusContact("<sip:conf1@192.0.2.2>;expires=60", true)
+	if contact != "<sip:conf1@192.0.2.2>;expires=60;isfocus" || !IsFocus(contact) {
+		t.Errorf("focus Contact %s", contact)
+	}
+	if GetFocusContact(contact, true) != contact {
+		t.Error("isfocus added twice")
+	}
+	if contact = GetFocusContact(contact, false); IsFocus(contact) {
+		t.Errorf("Contact %s still a focus", contact)
+	}
+	if IsFocus("not a contact <") {
+		t.Error("malformed Contact a focus")
+	}
+}
+
+func TestConferenceFactory(t *testing.T) {
+	uri, err := NewConferenceURI("sip:conf-factory@conf.example.com;transport=tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(uri, "sip:conf-factory-") || !strings.HasSuffix(uri, "@conf.example.com;transport=tcp") {
+		t.Errorf("conference URI %s", uri)
+	}
+	if other, _ := NewConferenceURI("sip:conf-factory@conf.example.com"); strings.HasPrefix(other, uri[:strings.Index(uri, "@")]) {
+		t.Errorf("conference URI %s created twice", other)
+	}
+	if _, err := NewConferenceURI("tel:+15551234"); err != ErrNotConferenceFactory {
+		t.Errorf("tel factory: %v", err)
+	}
+
+	req := NewRequest(INVITE, "sip:Conf-Factory@CONF.example.com;transport=udp", nil)
+	if !IsConferenceFactory(req, "sip:other@conf.example.com", "sip:Conf-Factory@conf.example.com") {
+		t.Error("factory not recognized")
+	}
+	if IsConferenceFactory(NewRequest(INVITE, uri, nil), "sip:conf-factory@conf.example.com") {
+		t.Error("conference taken for its factory")
+	}
+}
+
+func TestFocusDialog(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	ls := &serverListener{}
+	p.AddListener(ls)
+
+	//the focus answers with the Contact of the conference
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKfocus1")
+	invite.GetHeader().Set("Contact", "<sip:alice@192.0.2.1>")
+	invite.SetMessageInfo(&MessageInfo{
+		Network:    UDP,
+		LocalAddr:  &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5060},
+		RemoteAddr: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5060},
+	})
+	p.processMessage(invite)
+	resp := CreateResponse(invite, OK)
+	resp.GetHeader().Set("To", "<sip:bob@example.com>;tag=f1")
+	resp.GetHeader().Set("Contact", GetFocusContact("<sip:conf1@192.0.2.2>", true))
+	if err := ls.transactions[0].SendResponse(resp); err != nil {
+		t.Fatal(err)
+	}
+	d := ls.transactions[0].GetDialog().(*dialog)
+	if !d.IsFocus() || d.IsRemoteFocus() {
+		t.Errorf("focus %v, remote focus %v", d.IsFocus(), d.IsRemoteFocus())
+	}
+
+	reinvite, err := d.CreateRequest(INVITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := reinvite.GetHeader().Get("Contact"); !IsFocus(c) {
+		t.Errorf("re-INVITE Contact %s", c)
+	}
+	d.SetFocus(false)
+	update, _ := d.CreateRequest(UPDATE)
+	if c := update.GetHeader().Get("Contact"); IsFocus(c) || d.IsFocus() {
+		t.Errorf("UPDATE Contact %s", c)
+	}
+
+	//and learns the peer is one from its target refresh requests
+	refresh := newServerTestRequest(INVITE, "UDP", "z9hG4bKfocus2")
+	refresh.GetHeader().Set("To", "<sip:bob@example.com>;tag=f1")
+	refresh.GetHeader().Set("Cseq", "2 INVITE")
+	refresh.GetHeader().Set("Contact", "<sip:alice@192.0.2.1>;isfocus")
+	d.processRequest(refresh)
+	if !d.IsRemoteFocus() || d.GetRemoteTarget() != "sip:alice@192.0.2.1" {
+		t.Errorf("remote focus %v, target %s", d.IsRemoteFocus(), d.GetRemoteTarget())
+	}
+}
@@ -0,0 +1,256 @@
+package sip
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A ConnectionManager keeps the stream connections of a provider, accepted
+// or dialed, by their network and remote address, for the messages to the
+// same destination to share one (RFC 5923): a response goes back on the
+// connection of its request, and the requests of a dialog set up over a
+// connection go over it while it is open. A connection unused for the idle
+// timeout is closed, and so is the least recently used one when a new one
+// would exceed the maximum number of connections.
+type ConnectionManager interface {
+	GetIdleTimeout() time.Duration
+	SetIdleTimeout(timeout time.Duration)
+	GetMaxConnections() int
+	SetMaxConnections(max int)
+
+	GetConnections() []ConnectionInfo
+	CloseConnection(network, address string) bool
+}
+
+// ConnectionInfo describes a connection of a ConnectionManager.
+type ConnectionInfo struct {
+	Network    string
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+	Dialed     bool //set up by the provider rather than accepted
+	LastUsed   time.Time
+}
+
+////////////////////Implementation////////////////////////
+
+type connectionManager struct {
+	clock  func() Clock
+	logger func() Logger
+
+	mutex          sync.Mutex
+	connections    map[string]*connection
+	idleTimeout    time.Duration
+	maxConnections int
+}
+
+// newConnectionManager returns a ConnectionManager arming its idle timers on
+// the Clock clock returns, its connections logging to the Logger logger
+// returns.
+func newConnectionManager(clock func() Clock, logger func() Logger) *connectionManager {
+	this := &connectionManager{}
+
+	this.clock = clock
+	this.logger = logger
+	this.connections = make(map[string]*connection)
+
+	return this
+}
+
+func (this *connectionManager) GetIdleTimeout() time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.idleTimeout
+}
+
+// SetIdleTimeout sets how long a connection may stay unused before it is
+// closed; 0, the default, keeps it open. It applies to the connections set
+// up afterwards.
+func (this *connectionManager) SetIdleTimeout(timeout time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.idleTimeout = timeout
+}
+
+func (this *connectionManager) GetMaxConnections() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.maxConnections
+}
+
+// SetMaxConnections sets the number of connections kept at most; 0, the
+// default, sets no limit.
+func (this *connectionManager) SetMaxConnections(max int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.maxConnections = max
+}
+
+func (this *connectionManager) GetConnections() []ConnectionInfo {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	infos := make([]ConnectionInfo, 0, len(this.connections))
+	for _, conn := range this.connections {
+		infos = append(infos, ConnectionInfo{
+			Network:    conn.network,
+			LocalAddr:  conn.LocalAddr(),
+			RemoteAddr: conn.RemoteAddr(),
+			Dialed:     conn.dialed,
+			LastUsed:   conn.lastUsed,
+		})
+	}
+	return infos
+}
+
+// CloseConnection closes the connection to address over network, and
+// reports whether there was one.
+func (this *connectionManager) CloseConnection(network, address string) bool {
+	this.mutex.Lock()
+	conn, ok := this.connections[getConnectionKey(network, address)]
+	this.mutex.Unlock()
+
+	if ok {
+		conn.Close()
+	}
+	return ok
+}
+
+// get returns the connection to address over network, or nil.
+func (this *connectionManager) get(network, address string) *connection {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.connections[getConnectionKey(network, address)]
+}
+
+// add registers c, a connection over network, evicting the least recently
+// used connection if there are too many.
+func (this *connectionManager) add(network string, c net.Conn, dialed bool, limit int) *connection {
+	conn := newConnection(c, limit, this.logger())
+	conn.network = network
+	conn.dialed = dialed
+
+	var evicted *connection
+	clock := this.clock()
+
+	this.mutex.Lock()
+	conn.lastUsed = clock.Now()
+	if this.maxConnections > 0 && len(this.connections) >= this.maxConnections {
+		var key string
+		for k, c := range this.connections {
+			if evicted == nil || c.lastUsed.Before(evicted.lastUsed) {
+				key, evicted = k, c
+			}
+		}
+		delete(this.connections, key)
+	}
+	this.connections[getConnectionKey(network, c.RemoteAddr().String())] = conn
+	if this.idleTimeout > 0 {
+		idleTimeout := this.idleTimeout
+		conn.idle = clock.AfterFunc(idleTimeout, func() {
+			this.expire(conn, idleTimeout)
+		})
+	}
+	this.mutex.Unlock()
+
+	if evicted != nil {
+		evicted.Close()
+	}
+	return conn
+}
+
+// remove forgets conn, a connection over network.
+func (this *connectionManager) remove(network string, conn net.Conn) {
+	key := getConnectionKey(network, conn.RemoteAddr().String())
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if c, ok := this.connections[key]; ok && (c == conn || c.Conn == conn) {
+		stopTimer(c.idle)
+		delete(this.connections, key)
+	}
+}
+
+// touch records that conn was used now.
+func (this *connectionManager) touch(conn *connection) {
+	now := this.clock().Now()
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	conn.lastUsed = now
+}
+
+// expire closes conn if it was unused for idleTimeout, and waits for the
+// rest of it otherwise.
+func (this *connectionManager) expire(conn *connection, idleTimeout time.Duration) {
+	clock := this.clock()
+
+	this.mutex.Lock()
+	if this.connections[getConnectionKey(conn.network, conn.RemoteAddr().String())] != conn {
+		this.mutex.Unlock()
+		return
+	}
+	if idle := clock.Since(conn.lastUsed); idle < idleTimeout {
+		conn.idle = clock.AfterFunc(idleTimeout-idle, func() {
+			this.expire(conn, idleTimeout)
+		})
+		this.mutex.Unlock()
+		return
+	}
+	this.mutex.Unlock()
+
+	conn.Close()
+}
+
+// len returns the number of connections.
+func (this *connectionManager) len() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return len(this.connections)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// GetConnectionManager returns the manager of the stream connections of
+// the provider.
+func (this *provider) GetConnectionManager() ConnectionManager {
+	return this.connections
+}
+
+// getFlowHop returns the hop of the connection d was set up over, if it is
+// still open and, for a secure dialog, over TLS, or nil.
+func (this *provider) getFlowHop(d *dialog) Hop {
+	if d == nil {
+		return nil
+	}
+	network, address := d.getFlow()
+	if network == "" || (d.IsSecure() && !isSecureTransport(network)) {
+		return nil
+	}
+	return this.getConnectionHop(network, address)
+}
+
+// getConnectionHop returns the hop of the flow to address over network: a
+// stream connection while it is open, or a UDP address, or nil.
+func (this *provider) getConnectionHop(network, address string) Hop {
+	if network != UDP && this.connections.get(network, address) == nil {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil
+	}
+	p, _ := strconv.Atoi(port)
+	return NewHop(host, p, network)
+}
@@ -0,0 +1,146 @@
+package sip
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// dialPair returns both ends of a new TCP connection over l.
+func dialPair(t *testing.T, l net.Listener) (net.Conn, net.Conn) {
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+// waitClosed waits until the peer of conn closes it.
+func waitClosed(t *testing.T, conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("connection left open")
+	}
+}
+
+func TestConnectionIdleTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	cm := p.GetConnectionManager()
+	cm.SetIdleTimeout(time.Minute)
+
+	server, client := dialPair(t, l)
+	defer client.Close()
+	conn := p.addConnection(TCP, server)
+	defer conn.Close()
+
+	//used meanwhile, the connection lives on
+	clock.Advance(30 * time.Second)
+	p.connections.touch(conn)
+	clock.Advance(40 * time.Second)
+	if infos := cm.GetConnections(); len(infos) != 1 || infos[0].Dialed || !infos[0].LastUsed.Equal(clock.Now().Add(-40*time.Second)) {
+		t.Fatalf("connections %+v", infos)
+	}
+
+	clock.Advance(20 * time.Second)
+	waitClosed(t, client)
+}
+
+func TestMaxConnections(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	cm := p.GetConnectionManager()
+	cm.SetMaxConnections(2)
+
+	var clients []net.Conn
+	var conns []*connection
+	for i := 0; i < 3; i++ {
+		server, client := dialPair(t, l)
+		defer client.Close()
+		clients = append(clients, client)
+		conns = append(conns, p.addConnection(TCP, server))
+		defer conns[i].Close()
+		clock.Advance(time.Second)
+		if i == 1 {
+			//the first one is used last
+			p.connections.touch(conns[0])
+		}
+	}
+
+	if n := len(cm.GetConnections()); n != 2 {
+		t.Errorf("%d connections kept", n)
+	}
+	if p.connections.get(TCP, clients[1].LocalAddr().String()) != nil {
+		t.Error("least recently used connection kept")
+	}
+	waitClosed(t, clients[1])
+	if !cm.CloseConnection(TCP, clients[2].LocalAddr().String()) {
+		t.Error("connection not found")
+	}
+	waitClosed(t, clients[2])
+}
+
+func TestDialogFlow(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	ls := &serverListener{}
+	p.AddListener(ls)
+
+	server, client := dialPair(t, l)
+	defer client.Close()
+	conn := p.addConnection(TCP, server)
+	defer conn.Close()
+
+	invite := newServerTestRequest(INVITE, "TCP", "z9hG4bKflow1")
+	invite.GetHeader().Set("Contact", "<sip:alice@192.0.2.1;transport=tcp>")
+	invite.SetMessageInfo(&MessageInfo{Network: TCP, LocalAddr: server.LocalAddr(), RemoteAddr: server.RemoteAddr()})
+	p.processMessage(invite)
+	resp := CreateResponse(invite, OK)
+	resp.GetHeader().Set("To", "<sip:bob@example.com>;tag=b1")
+	resp.GetHeader().Set("Contact", "<sip:bob@192.0.2.2;transport=tcp>")
+	if err := ls.transactions[0].SendResponse(resp); err != nil {
+		t.Fatal(err)
+	}
+	d := ls.transactions[0].GetDialog().(*dialog)
+
+	//the BYE goes back over the connection of the INVITE
+	bye, err := d.CreateRequest(BYE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := sent.len()
+	if err := d.SendRequest(p.GetNewClientTransaction(bye)); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, n+1)
+	if h := sent.hops[n]; h.GetTransport() != TCP || h.String() != NewHop("127.0.0.1", client.LocalAddr().(*net.TCPAddr).Port, TCP).String() {
+		t.Errorf("BYE sent to %v", h)
+	}
+
+	//once it is closed, the remote target is resolved instead
+	p.removeConnection(TCP, conn)
+	if h := p.getFlowHop(d); h != nil {
+		t.Errorf("flow %v of a closed connection", h)
+	}
+}
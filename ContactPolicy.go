@@ -0,0 +1,137 @@
+package sip
+
+import (
+	"errors"
+	"net"
+	"sip/address"
+	"sip/core"
+	"strings"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A ContactPolicy validates the Contact of incoming dialog-forming
+// requests, which must be a URI reachable from outside the dialog (RFC
+// 3261 8.1.1.8). The zero value accepts any Contact.
+type ContactPolicy struct {
+	// RejectUnspecified rejects contacts without a usable host, such as
+	// 0.0.0.0 or [::].
+	RejectUnspecified bool
+
+	// RejectPrivate rejects contacts in private, shared, loopback and
+	// link-local address ranges.
+	RejectPrivate bool
+
+	// Fixup, if set, is called for a contact which would be rejected: it
+	// returns the address to substitute for the host and port of the
+	// contact, e.g. the NAT binding the request came from.
+	Fixup ContactFixup
+}
+
+// A ContactFixup returns the host and port which replace the unroutable
+// Contact of req, and false if there is none.
+type ContactFixup func(req Request) (host string, port int, ok bool)
+
+var (
+	ErrContactMissing    = errors.New("sip: missing or invalid Contact")
+	ErrContactUnroutable = errors.New("sip: unroutable Contact")
+)
+
+////////////////////Implementation////////////////////////
+
+// IsEnabled reports whether the policy checks anything.
+func (this ContactPolicy) IsEnabled() bool {
+	return this.RejectUnspecified || this.RejectPrivate || this.Fixup != nil
+}
+
+// IsRoutable reports whether the contact host is acceptable: host names
+// always are, IP addresses depend on the policy.
+func (this ContactPolicy) IsRoutable(host string) bool {
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	if ip == nil {
+		return host != ""
+	}
+	if ip.IsUnspecified() {
+		return !this.RejectUnspecified
+	}
+	if this.RejectPrivate && isPrivateIP(ip) {
+		return false
+	}
+	return true
+}
+
+// Validate checks the Contact of the dialog-forming request req and
+// rewrites it in canonical form, with its host and port substituted by
+// the Fixup if they were not routable.
+func (this ContactPolicy) Validate(req Request) error {
+	h := req.GetHeader()
+	values := h.Values("Contact")
+	if len(values) != 1 {
+		return ErrContactMissing
+	}
+	contact := parseContact(values[0])
+	if contact == nil {
+		return ErrContactMissing
+	}
+	uri, ok := contact.GetAddress().GetURI().(*address.SipURIImpl)
+	if !ok || uri.GetHostPort() == nil {
+		return ErrContactMissing
+	}
+
+	if !this.IsRoutable(uri.GetHost()) {
+		if this.Fixup == nil {
+			return ErrContactUnroutable
+		}
+		host, port, ok := this.Fixup(req)
+		if !ok || !this.IsRoutable(host) {
+			return ErrContactUnroutable
+		}
+		uri.SetHost(core.NewHost(host))
+		uri.SetPort(port)
+	}
+
+	h.Set("Contact", contact.EncodeBody())
+	return nil
+}
+
+// TransportContact is a ContactFixup which substitutes the address the
+// request was received from, as seen by the transport: behind a NAT, the
+// public binding of the client.
+func TransportContact(req Request) (string, int, bool) {
+	info := req.GetMessageInfo()
+	if info == nil || info.RemoteAddr == nil {
+		return "", 0, false
+	}
+	switch addr := info.RemoteAddr.(type) {
+	case *net.UDPAddr:
+		return addr.IP.String(), addr.Port, true
+	case *net.TCPAddr:
+		return addr.IP.String(), addr.Port, true
+	}
+	return "", 0, false
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || sharedAddressSpace.Contains(ip)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (this *provider) GetContactPolicy() ContactPolicy {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.contactPolicy
+}
+
+// SetContactPolicy sets the validation of the Contact of incoming
+// dialog-forming requests; those failing it are rejected with 400.
+func (this *provider) SetContactPolicy(policy ContactPolicy) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.contactPolicy = policy
+}
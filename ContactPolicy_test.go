@@ -0,0 +1,63 @@
+package sip
+
+import (
+	"net"
+	"testing"
+)
+
+func TestContactPolicy(t *testing.T) {
+	newInvite := func(contact string) Request {
+		req := NewRequest(INVITE, "sip:bob@example.com", nil)
+		req.GetHeader().Set("To", "<sip:bob@example.com>")
+		if contact != "" {
+			req.GetHeader().Set("Contact", contact)
+		}
+		return req
+	}
+
+	policy := ContactPolicy{RejectUnspecified: true, RejectPrivate: true}
+	var tests = []struct {
+		contact string
+		err     error
+	}{
+		{"sip:alice@198.51.100.7:5070", nil},
+		{"<sip:alice@pc33.example.com>;expires=60", nil},
+		{"", ErrContactMissing},
+		{"<sip:a@198.51.100.7>, <sip:b@198.51.100.8>", ErrContactMissing},
+		{"<tel:+15551234>", ErrContactMissing},
+		{"<sip:alice@0.0.0.0:5060>", ErrContactUnroutable},
+		{"<sip:alice@192.168.1.20:5060>", ErrContactUnroutable},
+		{"<sip:alice@100.72.0.1>", ErrContactUnroutable},
+		{"<sip:alice@127.0.0.1>", ErrContactUnroutable},
+	}
+	for _, test := range tests {
+		if err := policy.Validate(newInvite(test.contact)); err != test.err {
+			t.Errorf("%q: %v, want %v", test.contact, err, test.err)
+		}
+	}
+
+	//normalized to the canonical form
+	req := newInvite("sip:alice@198.51.100.7:5070")
+	policy.Validate(req)
+	if contact := req.GetHeader().Get("Contact"); contact != "<sip:alice@198.51.100.7:5070>" {
+		t.Errorf("Contact = %s", contact)
+	}
+
+	//a private contact is replaced by the address the request came from
+	policy.Fixup = TransportContact
+	req = newInvite("<sip:alice@192.168.1.20:5060;transport=udp>;expires=60")
+	if err := policy.Validate(req); err != ErrContactUnroutable {
+		t.Errorf("fixup without transport information: %v", err)
+	}
+	req.SetMessageInfo(&MessageInfo{RemoteAddr: &net.UDPAddr{IP: net.ParseIP("203.0.113.9"), Port: 61000}})
+	if err := policy.Validate(req); err != nil {
+		t.Fatal(err)
+	}
+	if contact := req.GetHeader().Get("Contact"); contact != "<sip:alice@203.0.113.9:61000;transport=udp>;expires=60" {
+		t.Errorf("fixed up Contact = %s", contact)
+	}
+
+	if (ContactPolicy{}).IsEnabled() || !(ContactPolicy{}).IsRoutable("10.0.0.1") {
+		t.Error("the zero policy checks contacts")
+	}
+}
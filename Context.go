@@ -0,0 +1,115 @@
+package sip
+
+import (
+	"context"
+)
+
+// The requests of a provider can be sent under a context.Context, to bound
+// or abandon them. A request sent statelessly, by Provider.SendRequestContext,
+// only has its next hop resolved under the context. A client transaction,
+// sent by ClientTransaction.SendRequestContext or Dialog.SendRequestContext,
+// ends when the context is done before its final response: it is closed
+// if it was not sent yet or is not an INVITE, while an INVITE is cancelled
+// (RFC 3261 9.1), at once if a provisional response was received, else on
+// the first one, and completes with the final response to it, usually a
+// 487 Request Terminated.
+
+const resourceContext = "context goroutine"
+
+////////////////////Implementation////////////////////////
+
+// SendRequestContext sends the request of the transaction as SendRequest
+// does, then watches ctx until the final response: if ctx is done first,
+// the transaction is closed, or cancelled for an INVITE already sent, and
+// its error, unless a final response arrives, is the one of ctx.
+func (this *clientTransaction) SendRequestContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := this.send(); err != nil {
+		return err
+	}
+	if this.provider == nil || ctx.Done() == nil {
+		return nil
+	}
+
+	this.provider.spawn(resourceContext, func() {
+		select {
+		case <-ctx.Done():
+			this.abandon(ctx.Err())
+		case <-this.final:
+		case <-this.quit:
+		case <-this.provider.quit:
+		}
+	})
+	return nil
+}
+
+// abandon ends the transaction whose context is done with err, before its
+// final response.
+func (this *clientTransaction) abandon(err error) {
+	this.mutex.Lock()
+	state := this.GetState()
+	if this.request.GetMethod() == INVITE {
+		switch state {
+		case TRANSACTIONSTATE_CALLING:
+			//a CANCEL may not be sent before a provisional response
+			this.cancelling = true
+			this.mutex.Unlock()
+			return
+		case TRANSACTIONSTATE_PROCEEDING:
+			this.mutex.Unlock()
+			this.sendCancel()
+			return
+		}
+	}
+	this.mutex.Unlock()
+
+	switch state {
+	case TRANSACTIONSTATE_COMPLETED, TRANSACTIONSTATE_TERMINATED:
+		return
+	}
+	this.Close()
+	this.complete(nil, err)
+}
+
+// sendCancel cancels the INVITE of the transaction, sending the CANCEL to
+// the same address (RFC 3261 9.1).
+func (this *clientTransaction) sendCancel() {
+	cancel, err := this.CreateCancel()
+	if err != nil {
+		return
+	}
+	ct := this.provider.GetNewClientTransaction(cancel).(*clientTransaction)
+	if h := this.getHop(); h != nil {
+		ct.mutex.Lock()
+		ct.hops = []Hop{h}
+		ct.mutex.Unlock()
+		ct.start()
+	} else if err := ct.SendRequest(); err != nil {
+		this.provider.getLogger(COMPONENT_TRANSACTION).Log(LOG_ERROR, "Sending CANCEL failed", "error", err)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// SendRequestContext sends the request of ct, created by CreateRequest,
+// within the dialog, as SendRequest does, under ctx as
+// ClientTransaction.SendRequestContext does.
+func (this *dialog) SendRequestContext(ctx context.Context, ct ClientTransaction) error {
+	if err := this.prepareRequest(ct); err != nil {
+		return err
+	}
+	return ct.SendRequestContext(ctx)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// SendRequestContext sends req statelessly, as SendRequest does, resolving
+// its next hop under ctx.
+func (this *provider) SendRequestContext(ctx context.Context, req Request) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return this.sendRequestContext(ctx, req, nil)
+}
@@ -0,0 +1,69 @@
+package sip
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSendRequestContext(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	hop := NewHop("192.0.2.10", 5060, UDP)
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{hop}, nil
+	}))
+
+	//a non-INVITE ends with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	ct := p.GetNewClientTransaction(newServerTestRequest(OPTIONS, "UDP", "z9hG4bKctx1")).(*clientTransaction)
+	if err := ct.SendRequestContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, 1)
+	cancel()
+	select {
+	case <-ct.final:
+	case <-time.After(2 * time.Second):
+		t.Fatal("transaction not ended")
+	}
+	if _, err := ct.getFinal(); err != context.Canceled || ct.GetState() != TRANSACTIONSTATE_TERMINATED {
+		t.Errorf("state %d, error %v", ct.GetState(), err)
+	}
+
+	//an INVITE is cancelled once a provisional response was received
+	ctx, cancel = context.WithCancel(context.Background())
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKctx2")
+	ct = p.GetNewClientTransaction(invite).(*clientTransaction)
+	if err := ct.SendRequestContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, 2)
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	if sent.len() != 2 {
+		t.Fatalf("%v sent before a provisional response", sent.last())
+	}
+	ct.processResponse(CreateResponse(invite, RINGING))
+	waitSent(t, sent, 3)
+	if req, ok := sent.last().(Request); !ok || req.GetMethod() != CANCEL || sent.hops[2] != hop {
+		t.Fatalf("sent %v to %v, want a CANCEL", sent.last(), sent.hops[2])
+	}
+	ct.processResponse(CreateResponse(invite, REQUEST_TERMINATED))
+	if resp, err := ct.getFinal(); err != nil || resp.GetStatusCode() != REQUEST_TERMINATED {
+		t.Errorf("final %v, %v", resp, err)
+	}
+
+	//a context done already sends nothing
+	if err := p.SendRequestContext(ctx, newServerTestRequest(OPTIONS, "UDP", "z9hG4bKctx3")); err != context.Canceled {
+		t.Errorf("SendRequestContext: %v", err)
+	}
+	if _, err := p.Do(ctx, newServerTestRequest(OPTIONS, "UDP", "z9hG4bKctx4")); err != context.Canceled {
+		t.Errorf("Do: %v", err)
+	}
+	if sent.len() != 4 {
+		t.Errorf("%d messages sent, want the INVITE, its CANCEL and ACK", sent.len())
+	}
+}
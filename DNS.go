@@ -0,0 +1,249 @@
+package sip
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"strings"
+)
+
+// The net package resolves SRV and A/AAAA records but not NAPTR ones, so
+// those are looked up with the minimal DNS client below: one question over
+// UDP to the name servers of /etc/resolv.conf, tried in turn.
+
+// A naptr is a NAPTR record (RFC 3403) naming the SRV records of a
+// transport (RFC 3263 4.1).
+type naptr struct {
+	order       uint16
+	preference  uint16
+	flags       string
+	services    string
+	regexp      string
+	replacement string
+}
+
+const (
+	dnsTypeNAPTR = 35
+	dnsClassIN   = 1
+
+	dnsRcodeNXDomain = 3
+)
+
+var errDNSMalformed = errors.New("sip: malformed DNS message")
+
+// getNameservers returns the name servers of /etc/resolv.conf, or the
+// local one.
+func getNameservers() []string {
+	var servers []string
+	if f, err := os.Open("/etc/resolv.conf"); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				servers = append(servers, net.JoinHostPort(fields[1], "53"))
+			}
+		}
+	}
+	if len(servers) == 0 {
+		servers = []string{"127.0.0.1:53"}
+	}
+	return servers
+}
+
+// lookupNAPTR returns the NAPTR records of name, asking servers in turn.
+// A name without records is not an error.
+func lookupNAPTR(ctx context.Context, servers []string, name string) ([]*naptr, error) {
+	query, id, err := newDNSQuery(name, dnsTypeNAPTR)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, server := range servers {
+		records, err := exchangeNAPTR(ctx, server, query, id)
+		if err == nil {
+			return records, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+func exchangeNAPTR(ctx context.Context, server string, query []byte, id uint16) ([]*naptr, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 65535)
+	for {
+		n, err := conn.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		if n >= 2 && binary.BigEndian.Uint16(b) == id {
+			return parseNAPTRResponse(b[:n])
+		}
+		//a late answer to another query, keep waiting
+	}
+}
+
+// newDNSQuery builds a recursive query for name and type qtype.
+func newDNSQuery(name string, qtype uint16) ([]byte, uint16, error) {
+	var idb [2]byte
+	if _, err := rand.Read(idb[:]); err != nil {
+		return nil, 0, err
+	}
+	id := binary.BigEndian.Uint16(idb[:])
+
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], id)
+	binary.BigEndian.PutUint16(b[2:], 0x0100) //RD
+	binary.BigEndian.PutUint16(b[4:], 1)      //QDCOUNT
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, 0, errors.New("sip: invalid DNS name " + name)
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	b = append(b, 0)
+	b = append(b, byte(qtype>>8), byte(qtype), 0, dnsClassIN)
+
+	return b, id, nil
+}
+
+func parseNAPTRResponse(b []byte) ([]*naptr, error) {
+	if len(b) < 12 {
+		return nil, errDNSMalformed
+	}
+	flags := binary.BigEndian.Uint16(b[2:])
+	switch rcode := flags & 0xf; rcode {
+	case 0:
+	case dnsRcodeNXDomain:
+		return nil, nil
+	default:
+		return nil, errors.New("sip: DNS server failure")
+	}
+	if flags&0x0200 != 0 {
+		return nil, errors.New("sip: truncated DNS response")
+	}
+	qdcount := int(binary.BigEndian.Uint16(b[4:]))
+	ancount := int(binary.BigEndian.Uint16(b[6:]))
+
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		var err error
+		if _, off, err = readDNSName(b, off); err != nil {
+			return nil, err
+		}
+		off += 4
+	}
+
+	var records []*naptr
+	for i := 0; i < ancount; i++ {
+		var err error
+		if _, off, err = readDNSName(b, off); err != nil {
+			return nil, err
+		}
+		if off+10 > len(b) {
+			return nil, errDNSMalformed
+		}
+		rtype := binary.BigEndian.Uint16(b[off:])
+		rdlength := int(binary.BigEndian.Uint16(b[off+8:]))
+		off += 10
+		if off+rdlength > len(b) {
+			return nil, errDNSMalformed
+		}
+		if rtype == dnsTypeNAPTR {
+			record, err := parseNAPTR(b, off, off+rdlength)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+		off += rdlength
+	}
+	return records, nil
+}
+
+func parseNAPTR(b []byte, off, end int) (*naptr, error) {
+	if off+4 > end {
+		return nil, errDNSMalformed
+	}
+	this := &naptr{}
+
+	this.order = binary.BigEndian.Uint16(b[off:])
+	this.preference = binary.BigEndian.Uint16(b[off+2:])
+	off += 4
+
+	for _, s := range []*string{&this.flags, &this.services, &this.regexp} {
+		if off >= end || off+1+int(b[off]) > end {
+			return nil, errDNSMalformed
+		}
+		*s = string(b[off+1 : off+1+int(b[off])])
+		off += 1 + int(b[off])
+	}
+
+	var err error
+	if this.replacement, _, err = readDNSName(b, off); err != nil {
+		return nil, err
+	}
+	return this, nil
+}
+
+// readDNSName reads the domain name at off, following compression
+// pointers, and returns it with the offset past it.
+func readDNSName(b []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, errDNSMalformed
+		}
+		n := int(b[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(b) || jumps > 16 {
+				return "", 0, errDNSMalformed
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+n > len(b) {
+				return "", 0, errDNSMalformed
+			}
+			labels = append(labels, string(b[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
@@ -1,5 +1,23 @@
 package sip
 
+import (
+	"context"
+	"errors"
+	"net"
+	"sip/address"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A Dialog is the peer-to-peer relationship between two user agents set up
+// by an INVITE, SUBSCRIBE or REFER (RFC 3261 12). The provider creates it
+// from a 101-299 response with a To tag, early on a provisional response
+// and confirmed on a 2xx, and attaches it to the transactions and events
+// of the requests sent and received within it.
 type Dialog interface {
 	GetLocalParty() string
 	GetRemoteParty() string
@@ -11,9 +29,19 @@ type Dialog interface {
 	GetRouteSet() []string
 	IsSecure() bool
 	IsServer() bool
+	IsFocus() bool
+	SetFocus(focus bool)
+	IsRemoteFocus() bool
+	GetProvisionalResponse() Response
+	GetOfferState() OfferState
+	GetSessionExpires() time.Duration
+	IsSessionRefresher() bool
 	IncrementLocalSequenceNumber()
 	CreateRequest(method string) (Request, error)
+	CreatePrack(resp Response) (Request, error)
+	Transfer(target string, replaces Dialog) (ClientTransaction, error)
 	SendRequest(ct ClientTransaction) error
+	SendRequestContext(ctx context.Context, ct ClientTransaction) error
 	SendAck(ack Request) error
 	GetState() DialogState
 	Close()
@@ -32,3 +60,758 @@ const (
 	DIALOGSTATE_COMPLETED                     //2
 	DIALOGSTATE_TERMINATED                    //3
 )
+
+// Dialogs still early after this long are reaped, as long as a proxy waits
+// for the final response of a branch (RFC 3261 16.6, Timer C).
+const DIALOG_EARLY_TIMEOUT = 3 * time.Minute
+
+var (
+	ErrDialogTerminated = errors.New("sip: dialog terminated")
+	ErrDialogMismatch   = errors.New("sip: request does not belong to the dialog")
+	ErrDialogMethod     = errors.New("sip: method cannot be sent within the dialog")
+	ErrNoLocalAddress   = errors.New("sip: no local address to send from")
+)
+
+////////////////////Implementation////////////////////////
+
+type dialog struct {
+	mutex sync.Mutex
+
+	provider *provider
+	first    Transaction
+	server   bool
+	secure   bool
+
+	state     DialogState
+	callId    string
+	localTag  string
+	remoteTag string
+
+	//From or To header values, with their tags
+	local  string
+	remote string
+
+	localContact string
+	remoteTarget string
+	routeSet     []string
+
+	//the methods of the last Allow of the remote party, nil until one is
+	//seen, see isRemoteAllowed
+	remoteAllow []string
+
+	//the isfocus feature tag of either Contact, see Conference
+	focus       bool
+	remoteFocus bool
+
+	//the stream connection the dialog was set up over, see getFlowHop
+	flowNetwork string
+	flowAddress string
+
+	//0 while empty
+	localSeq  int
+	remoteSeq int
+
+	ack    Request
+	reaper Timer
+
+	//the last provisional response of an early dialog of a UAC, and the
+	//order it was set up in, see GetEarlyDialogs
+	provisional Response
+	forkOrder   int
+
+	//INVITE transactions in progress either way, see admitInvite, and the
+	//re-INVITE or UPDATE to send again after a 491, see retryRequest
+	clientInvite Transaction
+	serverInvite Transaction
+	inviteBody   []byte
+	clientUpdate Transaction
+	updateBody   []byte
+	glareTimer   Timer
+
+	//the offers and answers of the session, see processOffer, and the
+	//last session description sent
+	offers   offerAnswer
+	localSDP []byte
+
+	//the session timer, see processSession: the session interval, 0
+	//without one, whether this side refreshes the session, and the
+	//refresh in progress
+	sessionInterval  time.Duration
+	sessionRefresher bool
+	sessionTimer     Timer
+	sessionRefresh   ClientTransaction
+
+	//the RSeq of the last reliable provisional response acknowledged, see
+	//sendPrack
+	prackRSeq int
+
+	//the REFERs accepted, see nextReferEvent
+	refers int
+
+	//dialog quota of the provider, see admit
+	source string
+	quota  bool
+
+	applicationData interface{}
+}
+
+// newDialog returns the dialog set up by resp to the dialog-forming request
+// of t, as in RFC 3261 12.1.1 for a UAS and 12.1.2 for a UAC. The route set
+// is the Record-Route of the request for a UAS, and of the response,
+// reversed, for a UAC.
+func newDialog(t Transaction, resp Response, server bool) *dialog {
+	req := t.GetRequest()
+
+	this := &dialog{}
+
+	this.first = t
+	this.server = server
+	this.secure = isSecureRequest(req)
+	this.callId = req.GetHeader().Get("Call-Id")
+
+	if server {
+		this.local = resp.GetHeader().Get("To")
+		this.remote = req.GetHeader().Get("From")
+		this.localContact = resp.GetHeader().Get("Contact")
+		this.setRemoteTarget(req.GetHeader().Get("Contact"))
+		this.setRemoteAllow(req)
+		this.routeSet = req.GetHeader().Values("Record-Route")
+		this.remoteSeq, _ = getCSeq(req)
+	} else {
+		this.local = req.GetHeader().Get("From")
+		this.remote = resp.GetHeader().Get("To")
+		this.localContact = req.GetHeader().Get("Contact")
+		this.localSeq, _ = getCSeq(req)
+		this.update(resp)
+	}
+	if this.secure && this.localContact != "" {
+		//the requests of a secure dialog carry a SIPS Contact
+		this.localContact = getSecureContact(this.localContact)
+	}
+	this.focus = IsFocus(this.localContact)
+	this.localTag = getTag(this.local)
+	this.remoteTag = getTag(this.remote)
+	if req.GetMethod() == INVITE {
+		if server {
+			this.serverInvite = t
+		} else {
+			this.clientInvite = t
+		}
+	}
+	//the responses follow, from the transaction
+	this.offers.process(req, !server)
+
+	info := resp.GetMessageInfo()
+	if server {
+		info = req.GetMessageInfo()
+	}
+	if info != nil && info.RemoteAddr != nil && info.Network != "" && info.Network != UDP {
+		this.flowNetwork = info.Network
+		this.flowAddress = info.RemoteAddr.String()
+	}
+
+	this.state = DIALOGSTATE_EARLY
+	if resp.GetStatusCode() >= OK {
+		this.state = DIALOGSTATE_CONFIRMED
+	}
+	return this
+}
+
+// getDialogId identifies a dialog by its Call-ID and its tags.
+func getDialogId(callId, localTag, remoteTag string) string {
+	return callId + ";" + localTag + ";" + remoteTag
+}
+
+func (this *dialog) GetDialogId() string {
+	return getDialogId(this.callId, this.localTag, this.remoteTag)
+}
+
+// getFlow returns the network and the remote address of the stream
+// connection the dialog was set up over, or empty strings.
+func (this *dialog) getFlow() (string, string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.flowNetwork, this.flowAddress
+}
+
+func (this *dialog) GetCallId() string {
+	return this.callId
+}
+
+func (this *dialog) GetLocalTag() string {
+	return this.localTag
+}
+
+func (this *dialog) GetRemoteTag() string {
+	return this.remoteTag
+}
+
+// GetLocalParty returns the URI of the local party, from the From of the
+// requests sent in the dialog.
+func (this *dialog) GetLocalParty() string {
+	return getAddressURI(this.local)
+}
+
+func (this *dialog) GetRemoteParty() string {
+	return getAddressURI(this.remote)
+}
+
+func (this *dialog) GetRemoteTarget() string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.remoteTarget
+}
+
+func (this *dialog) GetRouteSet() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return append([]string(nil), this.routeSet...)
+}
+
+func (this *dialog) GetLocalSequenceNumber() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.localSeq
+}
+
+func (this *dialog) GetRemoteSequenceNumber() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.remoteSeq
+}
+
+func (this *dialog) IncrementLocalSequenceNumber() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.localSeq++
+}
+
+func (this *dialog) IsSecure() bool {
+	return this.secure
+}
+
+// IsFocus reports whether the dialog acts as the focus of a conference,
+// its Contact having the isfocus feature tag.
+func (this *dialog) IsFocus() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.focus
+}
+
+// SetFocus sets whether the dialog acts as the focus of a conference, the
+// Contact of its target refresh requests having the isfocus feature tag
+// (RFC 4579 4.1), as the Contact of the response setting it up does.
+func (this *dialog) SetFocus(focus bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.focus = focus
+	if this.localContact != "" {
+		this.localContact = GetFocusContact(this.localContact, focus)
+	}
+}
+
+// IsRemoteFocus reports whether the peer is the focus of a conference, its
+// last Contact having the isfocus feature tag.
+func (this *dialog) IsRemoteFocus() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.remoteFocus
+}
+
+// GetProvisionalResponse returns the last provisional response received in
+// the early dialog of a UAC, nil if none.
+func (this *dialog) GetProvisionalResponse() Response {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.provisional
+}
+
+// setRemoteTarget takes the remote target from contact, a Contact value,
+// unless it has none.
+func (this *dialog) setRemoteTarget(contact string) {
+	if target := getAddressURI(contact); target != "" {
+		this.remoteTarget = target
+		this.remoteFocus = IsFocus(contact)
+	}
+}
+
+func (this *dialog) IsServer() bool {
+	return this.server
+}
+
+func (this *dialog) GetState() DialogState {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.state
+}
+
+func (this *dialog) GetFirstTransaction() Transaction {
+	return this.first
+}
+
+func (this *dialog) SetApplicationData(applicationData interface{}) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.applicationData = applicationData
+}
+
+func (this *dialog) GetApplicationData() interface{} {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.applicationData
+}
+
+// CreateRequest returns a request within the dialog, as in RFC 3261
+// 12.2.1.1: it is sent to the remote target through the route set, with
+// the tags and Call-ID of the dialog and the next local CSeq number. An
+// ACK takes the CSeq number of the last request instead, that is of the
+// INVITE it acknowledges, even with an UPDATE or a PRACK sent since. A
+// CANCEL is created from the client transaction it cancels.
+func (this *dialog) CreateRequest(method string) (Request, error) {
+	if method == CANCEL {
+		return nil, ErrDialogMethod
+	}
+	via, err := this.getVia()
+	if err != nil {
+		return nil, err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.state == DIALOGSTATE_TERMINATED {
+		return nil, ErrDialogTerminated
+	}
+	if method != ACK {
+		this.localSeq++
+	}
+
+	req := NewRequest(method, this.remoteTarget, nil)
+	h := req.GetHeader()
+	h.Set("Via", newBranch(via))
+	h.Set("Max-Forwards", "70")
+	h.Set("From", this.local)
+	h.Set("To", this.remote)
+	h.Set("Call-Id", this.callId)
+	cseq := this.localSeq
+	if method == ACK && this.clientInvite != nil {
+		cseq, _ = getCSeq(this.clientInvite.GetRequest())
+	}
+	h.Set("Cseq", strconv.Itoa(cseq)+" "+method)
+	for _, route := range this.routeSet {
+		h.Add("Route", route)
+	}
+	if this.localContact != "" && isTargetRefresh(method) {
+		h.Set("Contact", this.localContact)
+	}
+	return req, nil
+}
+
+// SendRequest sends the request of ct, created by CreateRequest, within the
+// dialog.
+func (this *dialog) SendRequest(ct ClientTransaction) error {
+	if err := this.prepareRequest(ct); err != nil {
+		return err
+	}
+	return ct.SendRequest()
+}
+
+// prepareRequest checks that the request of ct belongs to the dialog, and
+// binds ct to it.
+func (this *dialog) prepareRequest(ct ClientTransaction) error {
+	req := ct.GetRequest()
+	if req.GetMethod() == ACK || req.GetHeader().Get("Call-Id") != this.callId || getTag(req.GetHeader().Get("From")) != this.localTag {
+		return ErrDialogMismatch
+	}
+	if this.GetState() == DIALOGSTATE_TERMINATED {
+		return ErrDialogTerminated
+	}
+	if req.GetMethod() == UPDATE && !this.isRemoteAllowed(UPDATE) {
+		return ErrUpdateNotAllowed
+	}
+	if err := this.processOffer(req, true); err != nil {
+		return err
+	}
+
+	if t, ok := ct.(*clientTransaction); ok {
+		t.SetDialog(this)
+	}
+	if req.GetMethod() == INVITE || req.GetMethod() == UPDATE {
+		this.setClientRequest(ct)
+	}
+	return nil
+}
+
+// SendAck sends the ACK of a 2xx to the INVITE of the dialog. The ACK is
+// kept to answer the retransmissions of the 2xx.
+func (this *dialog) SendAck(ack Request) error {
+	if ack.GetMethod() != ACK || ack.GetHeader().Get("Call-Id") != this.callId {
+		return ErrDialogMismatch
+	}
+
+	this.mutex.Lock()
+	if this.state == DIALOGSTATE_TERMINATED {
+		this.mutex.Unlock()
+		return ErrDialogTerminated
+	}
+	if err := this.offers.process(ack, true); err != nil {
+		this.mutex.Unlock()
+		return err
+	}
+	this.ack = ack
+	this.mutex.Unlock()
+
+	if this.provider == nil {
+		return nil
+	}
+	return this.provider.sendRequest(ack, this.provider.getFlowHop(this))
+}
+
+// Close terminates the dialog.
+func (this *dialog) Close() {
+	this.terminate(DIALOGTERMINATED_CLOSED)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// update takes the remote target and the route set of a UAC from a
+// response setting up or confirming the dialog.
+func (this *dialog) update(resp Response) {
+	if this.server {
+		return
+	}
+	this.setRemoteTarget(resp.GetHeader().Get("Contact"))
+	this.setRemoteAllow(resp)
+	rr := resp.GetHeader().Values("Record-Route")
+	this.routeSet = make([]string, len(rr))
+	for i := range rr {
+		this.routeSet[i] = rr[len(rr)-1-i]
+	}
+}
+
+// confirm moves an early dialog to the confirmed state on a 2xx; for a UAC
+// the route set is recomputed from the 2xx (RFC 3261 13.2.2.4).
+func (this *dialog) confirm(resp Response) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.state != DIALOGSTATE_EARLY {
+		return
+	}
+	this.state = DIALOGSTATE_CONFIRMED
+	stopTimer(this.reaper)
+	this.update(resp)
+}
+
+// onEarlyTimeout reaps a dialog which is still early when the early dialog
+// timeout of the provider expires. Its transaction is abandoned with it.
+func (this *dialog) onEarlyTimeout() {
+	if this.GetState() != DIALOGSTATE_EARLY {
+		return
+	}
+	this.terminate(DIALOGTERMINATED_EARLY_TIMEOUT)
+	if this.first.GetState() != TRANSACTIONSTATE_TERMINATED {
+		this.first.Close()
+	}
+}
+
+// processRequest checks the CSeq of a request received within the dialog
+// and reports false if it is out of order (RFC 3261 12.2.2). A target
+// refresh request updates the remote target and the methods the remote
+// party allows, and a BYE terminates the dialog.
+func (this *dialog) processRequest(req Request) bool {
+	method := req.GetMethod()
+	if method == ACK || method == CANCEL {
+		return true
+	}
+	cseq, _ := getCSeq(req)
+
+	this.mutex.Lock()
+	if this.remoteSeq != 0 && cseq < this.remoteSeq {
+		this.mutex.Unlock()
+		return false
+	}
+	this.remoteSeq = cseq
+	if isTargetRefresh(method) {
+		this.setRemoteTarget(req.GetHeader().Get("Contact"))
+		this.setRemoteAllow(req)
+	}
+	this.mutex.Unlock()
+
+	if method == BYE {
+		this.terminate(DIALOGTERMINATED_BYE)
+	}
+	return true
+}
+
+// processResponse handles the response to a request sent within the dialog
+// (RFC 3261 12.2.1.2): a 2xx to a target refresh request updates the remote
+// target and the methods the remote party allows, while the final response
+// to a BYE, a 481 or a 408 terminates the dialog.
+func (this *dialog) processResponse(req Request, resp Response) {
+	statusCode := resp.GetStatusCode()
+	if statusCode < OK {
+		return
+	}
+
+	switch {
+	case req.GetMethod() == BYE:
+		this.terminate(DIALOGTERMINATED_BYE)
+	case statusCode == CALL_OR_TRANSACTION_DOES_NOT_EXIST, statusCode == REQUEST_TIMEOUT:
+		this.terminate(DIALOGTERMINATED_REJECTED)
+	case statusCode < MULTIPLE_CHOICES && isTargetRefresh(req.GetMethod()):
+		this.mutex.Lock()
+		this.setRemoteTarget(resp.GetHeader().Get("Contact"))
+		this.setRemoteAllow(resp)
+		this.mutex.Unlock()
+	}
+}
+
+// processOffer runs msg, sent when local is true and received otherwise,
+// through the offer/answer rules of the session, see offerAnswer.
+func (this *dialog) processOffer(msg Message, local bool) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if err := this.offers.process(msg, local); err != nil {
+		return err
+	}
+	if sdp := readSDP(msg); local && sdp != nil {
+		this.localSDP = sdp
+	}
+	return nil
+}
+
+// resendAck answers a retransmitted 2xx to the INVITE with the ACK sent for
+// the first one, if any.
+func (this *dialog) resendAck() {
+	this.mutex.Lock()
+	ack := this.ack
+	this.mutex.Unlock()
+
+	if ack != nil && this.provider != nil {
+		if err := this.provider.SendRequest(ack); err != nil {
+			this.provider.getLogger(COMPONENT_DIALOG).Log(LOG_ERROR, "Resending ACK failed", "dialog", this.GetDialogId(), "error", err)
+		}
+	}
+}
+
+// terminate ends the dialog, removes it from the provider and tells the
+// listeners why. The dialog quota of a confirmed dialog is returned; the
+// one of an early dialog goes with the final response of its transaction.
+func (this *dialog) terminate(reason DialogTerminationReason) {
+	this.mutex.Lock()
+	if this.state == DIALOGSTATE_TERMINATED {
+		this.mutex.Unlock()
+		return
+	}
+	confirmed := this.state == DIALOGSTATE_CONFIRMED
+	this.state = DIALOGSTATE_TERMINATED
+	stopTimer(this.reaper)
+	stopTimer(this.glareTimer)
+	stopTimer(this.sessionTimer)
+	this.mutex.Unlock()
+
+	if this.provider != nil {
+		this.provider.forgetDialog(this, confirmed && this.quota)
+		this.provider.listeners.fireDialogTerminated(NewDialogTerminatedEvent(this, reason))
+	}
+}
+
+// getVia returns the Via, without a branch, of the requests sent within
+// the dialog: the one of the first request for a UAC, and for a UAS the
+// address the first request was received on. A UAS listening on a
+// wildcard address puts the host of the Request-URI instead.
+func (this *dialog) getVia() (string, error) {
+	req := this.first.GetRequest()
+	if !this.server {
+		return getTopVia(req), nil
+	}
+
+	info := req.GetMessageInfo()
+	if info == nil || info.LocalAddr == nil {
+		return "", ErrNoLocalAddress
+	}
+	host, port, err := net.SplitHostPort(info.LocalAddr.String())
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		uri, ok := req.GetRequestURI().(*address.SipURIImpl)
+		if !ok {
+			return "", ErrNoLocalAddress
+		}
+		host = uri.GetHost()
+	}
+	return "SIP/2.0/" + strings.ToUpper(info.Network) + " " + net.JoinHostPort(strings.Trim(host, "[]"), port), nil
+}
+
+// isTargetRefresh reports whether requests of method update the remote
+// target of their dialog.
+func isTargetRefresh(method string) bool {
+	switch method {
+	case INVITE, UPDATE, SUBSCRIBE, NOTIFY, REFER:
+		return true
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// processDialog runs a response to the dialog-forming request of t through
+// the dialog layer: a 101-299 response with a To tag creates the dialog it
+// identifies, early until the early dialog timeout, or confirms it on a
+// 2xx. The early dialog of a UAC keeps its last provisional response. A
+// 2xx to a forked request ends the early dialogs of the other branches, see
+// ForkedDialogs, and a failure response all of them. The dialog is attached
+// to t.
+func (this *provider) processDialog(t Transaction, resp Response, server bool) {
+	statusCode := resp.GetStatusCode()
+	if statusCode >= MULTIPLE_CHOICES {
+		this.terminateEarlyDialogs(t, nil, DIALOGTERMINATED_REJECTED)
+		return
+	}
+	toTag := getTag(resp.GetHeader().Get("To"))
+	if statusCode <= TRYING || toTag == "" {
+		return
+	}
+
+	req := t.GetRequest()
+	id := getDialogId(req.GetHeader().Get("Call-Id"), getTag(req.GetHeader().Get("From")), toTag)
+	if server {
+		id = getDialogId(req.GetHeader().Get("Call-Id"), toTag, getTag(req.GetHeader().Get("From")))
+	}
+
+	this.mutex.Lock()
+	d, ok := this.dialogs[id]
+	if !ok {
+		d = newDialog(t, resp, server)
+		d.provider = this
+		if a, admitted := this.admitted[getTransactionKey(req)]; server && admitted && a.dialog {
+			d.source = a.source
+			d.quota = true
+			a.taken = true
+			this.admitted[getTransactionKey(req)] = a
+		}
+		if d.state == DIALOGSTATE_EARLY && this.earlyDialogTimeout > 0 {
+			d.reaper = this.clock.AfterFunc(this.earlyDialogTimeout, d.onEarlyTimeout)
+		}
+		this.forkOrder++
+		d.forkOrder = this.forkOrder
+		this.dialogs[id] = d
+	}
+	metrics := this.exported
+	this.mutex.Unlock()
+
+	if metrics != nil && !ok {
+		metrics.AddDialogs(1)
+	}
+
+	if statusCode < OK && !server {
+		d.mutex.Lock()
+		d.provisional = resp
+		d.mutex.Unlock()
+	}
+	if statusCode >= OK {
+		if ok {
+			d.confirm(resp)
+		}
+		this.terminateEarlyDialogs(t, d, DIALOGTERMINATED_FORKED)
+		if !server {
+			this.acceptFork(t, d)
+		}
+	}
+	if s, ok := t.(interface{ SetDialog(Dialog) }); ok {
+		s.SetDialog(d)
+	}
+}
+
+// terminateEarlyDialogs ends the early dialogs set up by t but winner.
+func (this *provider) terminateEarlyDialogs(t Transaction, winner *dialog, reason DialogTerminationReason) {
+	var losers []*dialog
+	this.mutex.Lock()
+	for _, d := range this.dialogs {
+		if d.first == t && d != winner {
+			losers = append(losers, d)
+		}
+	}
+	this.mutex.Unlock()
+
+	for _, d := range losers {
+		if d.GetState() == DIALOGSTATE_EARLY {
+			d.terminate(reason)
+		}
+	}
+}
+
+// GetEarlyDialogTimeout returns how long a dialog may stay early before it
+// is reaped.
+func (this *provider) GetEarlyDialogTimeout() time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.earlyDialogTimeout
+}
+
+// SetEarlyDialogTimeout sets how long a dialog may stay early before it is
+// reaped, from then on; 0 keeps early dialogs until their transaction ends.
+func (this *provider) SetEarlyDialogTimeout(timeout time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.earlyDialogTimeout = timeout
+}
+
+// matchDialog returns the dialog of a message received within it, by its
+// Call-ID and its tags: the To tag of a request is the local one, the From
+// tag of a response.
+func (this *provider) matchDialog(msg Message) *dialog {
+	h := msg.GetHeader()
+	local, remote := getTag(h.Get("To")), getTag(h.Get("From"))
+	if _, ok := msg.(Response); ok {
+		local, remote = remote, local
+	}
+	if local == "" {
+		return nil
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.dialogs[getDialogId(h.Get("Call-Id"), local, remote)]
+}
+
+// forgetDialog removes a terminated dialog, and returns the dialog quota
+// its 2xx kept if asked to.
+func (this *provider) forgetDialog(d *dialog, release bool) {
+	id := d.GetDialogId()
+
+	this.mutex.Lock()
+	forgotten := this.dialogs[id] == d
+	if forgotten {
+		delete(this.dialogs, id)
+	}
+	metrics := this.exported
+	this.mutex.Unlock()
+
+	if metrics != nil && forgotten {
+		metrics.AddDialogs(-1)
+	}
+
+	if release {
+		this.quota.ReleaseDialog(d.source)
+	}
+}
@@ -0,0 +1,295 @@
+package sip
+
+import (
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// DialogProber checks that the peers of long-lived dialogs are still there
+// by sending in-dialog OPTIONS (or UPDATE) at a regular interval. A dialog
+// whose peer stops answering, or answers that the dialog is gone (481, 408),
+// is torn down with a BYE and reported to the DialogDeadHandler, so that
+// dead calls don't leak. It must be added to the Provider as a Listener so
+// it can see the responses and timeouts of its probes.
+type DialogProber interface {
+	Listener
+
+	AddDialog(dialog Dialog)
+	RemoveDialog(dialog Dialog)
+	GetDialogs() []Dialog
+
+	SetInterval(interval time.Duration)
+	SetTimeout(timeout time.Duration)
+	SetMethod(method string)
+	SetMaxFailures(maxFailures int)
+	SetDeadHandler(handler DialogDeadHandler)
+	SetClock(clock Clock)
+
+	Start()
+	Stop()
+}
+
+// A DialogDeadHandler is called after a dialog was torn down because its
+// peer stopped responding.
+type DialogDeadHandler func(dialog Dialog)
+
+////////////////////Implementation////////////////////////
+
+const (
+	DIALOGPROBER_INTERVAL     = 90 * time.Second
+	DIALOGPROBER_TIMEOUT      = 32 * time.Second
+	DIALOGPROBER_MAX_FAILURES = 1
+)
+
+type dialogProbe struct {
+	dialog Dialog
+	sent   time.Time
+}
+
+type dialogProber struct {
+	mutex sync.Mutex
+
+	provider Provider
+
+	dialogs  map[Dialog]int
+	pending  map[ClientTransaction]*dialogProbe
+	interval time.Duration
+	timeout  time.Duration
+	method   string
+
+	maxFailures int
+	handler     DialogDeadHandler
+	clock       Clock
+
+	quit      chan bool
+	waitGroup *sync.WaitGroup
+}
+
+func NewDialogProber(provider Provider) DialogProber {
+	this := &dialogProber{}
+
+	this.provider = provider
+
+	this.dialogs = make(map[Dialog]int)
+	this.pending = make(map[ClientTransaction]*dialogProbe)
+
+	this.interval = DIALOGPROBER_INTERVAL
+	this.timeout = DIALOGPROBER_TIMEOUT
+	this.method = OPTIONS
+	this.maxFailures = DIALOGPROBER_MAX_FAILURES
+
+	this.clock = provider.GetClock()
+	this.waitGroup = &sync.WaitGroup{}
+
+	return this
+}
+
+func (this *dialogProber) AddDialog(dialog Dialog) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if _, ok := this.dialogs[dialog]; !ok {
+		this.dialogs[dialog] = 0
+	}
+}
+
+func (this *dialogProber) RemoveDialog(dialog Dialog) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.remove(dialog)
+}
+
+func (this *dialogProber) remove(dialog Dialog) {
+	delete(this.dialogs, dialog)
+	for ct, probe := range this.pending {
+		if probe.dialog == dialog {
+			delete(this.pending, ct)
+		}
+	}
+}
+
+func (this *dialogProber) GetDialogs() []Dialog {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	dialogs := make([]Dialog, 0, len(this.dialogs))
+	for dialog := range this.dialogs {
+		dialogs = append(dialogs, dialog)
+	}
+	return dialogs
+}
+
+func (this *dialogProber) SetInterval(interval time.Duration) {
+	this.interval = interval
+}
+
+func (this *dialogProber) SetTimeout(timeout time.Duration) {
+	this.timeout = timeout
+}
+
+// SetMethod selects OPTIONS (the default) or UPDATE as the probe.
+func (this *dialogProber) SetMethod(method string) {
+	this.method = method
+}
+
+func (this *dialogProber) SetMaxFailures(maxFailures int) {
+	this.maxFailures = maxFailures
+}
+
+func (this *dialogProber) SetDeadHandler(handler DialogDeadHandler) {
+	this.handler = handler
+}
+
+func (this *dialogProber) SetClock(clock Clock) {
+	this.clock = clock
+}
+
+func (this *dialogProber) Start() {
+	this.quit = make(chan bool)
+	this.waitGroup.Add(1)
+	go this.run()
+}
+
+func (this *dialogProber) Stop() {
+	close(this.quit)
+	this.waitGroup.Wait()
+}
+
+func (this *dialogProber) run() {
+	defer this.waitGroup.Done()
+
+	ticker := this.clock.NewTicker(this.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-this.quit:
+			return
+		case <-ticker.C():
+			this.expire()
+			for _, dialog := range this.GetDialogs() {
+				this.probe(dialog)
+			}
+		}
+	}
+}
+
+func (this *dialogProber) probe(dialog Dialog) {
+	if dialog.GetState() != DIALOGSTATE_CONFIRMED {
+		return
+	}
+
+	req, err := dialog.CreateRequest(this.method)
+	if err != nil {
+		return
+	}
+	ct := this.provider.GetNewClientTransaction(req)
+
+	this.mutex.Lock()
+	this.pending[ct] = &dialogProbe{dialog: dialog, sent: this.clock.Now()}
+	this.mutex.Unlock()
+
+	if err := dialog.SendRequest(ct); err != nil {
+		this.fail(ct)
+	}
+}
+
+// expire fails the probes which have been waiting longer than the timeout,
+// in case the transaction layer never reports back.
+func (this *dialogProber) expire() {
+	this.mutex.Lock()
+	expired := make([]ClientTransaction, 0)
+	for ct, probe := range this.pending {
+		if this.clock.Since(probe.sent) > this.timeout {
+			expired = append(expired, ct)
+		}
+	}
+	this.mutex.Unlock()
+
+	for _, ct := range expired {
+		this.fail(ct)
+	}
+}
+
+func (this *dialogProber) succeed(ct ClientTransaction) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if probe, ok := this.pending[ct]; ok {
+		delete(this.pending, ct)
+		if _, ok := this.dialogs[probe.dialog]; ok {
+			this.dialogs[probe.dialog] = 0
+		}
+	}
+}
+
+func (this *dialogProber) fail(ct ClientTransaction) {
+	this.mutex.Lock()
+	probe, ok := this.pending[ct]
+	if !ok {
+		this.mutex.Unlock()
+		return
+	}
+	delete(this.pending, ct)
+	failures, ok := this.dialogs[probe.dialog]
+	if !ok {
+		this.mutex.Unlock()
+		return
+	}
+	failures++
+	this.dialogs[probe.dialog] = failures
+	dead := failures >= this.maxFailures
+	if dead {
+		this.remove(probe.dialog)
+	}
+	this.mutex.Unlock()
+
+	if dead {
+		this.tearDown(probe.dialog)
+	}
+}
+
+// tearDown ends the dialog with a BYE, see RFC 5057 5.
+func (this *dialogProber) tearDown(dialog Dialog) {
+	if bye, err := dialog.CreateRequest(BYE); err == nil {
+		dialog.SendRequest(this.provider.GetNewClientTransaction(bye))
+	}
+	dialog.Close()
+
+	if this.handler != nil {
+		this.handler(dialog)
+	}
+}
+
+func (this *dialogProber) ProcessRequest(requestEvent RequestEvent) {
+}
+
+// ProcessResponse treats any response as a sign of life, except those
+// meaning the dialog no longer exists at the peer (RFC 5057 5.1).
+func (this *dialogProber) ProcessResponse(responseEvent ResponseEvent) {
+	ct := responseEvent.GetClientTransaction()
+	switch statusCode := responseEvent.GetResponse().GetStatusCode(); {
+	case statusCode < OK:
+		//provisional responses don't finish the probe
+	case statusCode == CALL_OR_TRANSACTION_DOES_NOT_EXIST, statusCode == REQUEST_TIMEOUT:
+		this.mutex.Lock()
+		if probe, ok := this.pending[ct]; ok {
+			if _, ok := this.dialogs[probe.dialog]; ok {
+				this.dialogs[probe.dialog] = this.maxFailures
+			}
+		}
+		this.mutex.Unlock()
+		this.fail(ct)
+	default:
+		this.succeed(ct)
+	}
+}
+
+func (this *dialogProber) ProcessTimeout(timeoutEvent TimeoutEvent) {
+	if ct, ok := timeoutEvent.GetTransaction().(ClientTransaction); ok {
+		this.fail(ct)
+	}
+}
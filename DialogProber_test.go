@@ -0,0 +1,89 @@
+package sip
+
+import (
+	"testing"
+	"time"
+)
+
+type probedDialog struct {
+	Dialog
+
+	sent   []string
+	closed bool
+}
+
+func (this *probedDialog) GetState() DialogState {
+	return DIALOGSTATE_CONFIRMED
+}
+
+func (this *probedDialog) CreateRequest(method string) (Request, error) {
+	req := NewRequest(method, "sip:bob@192.0.2.4", nil)
+	req.GetHeader().Set("Cseq", "2 "+method)
+	return req, nil
+}
+
+func (this *probedDialog) SendRequest(ct ClientTransaction) error {
+	this.sent = append(this.sent, ct.GetRequest().GetMethod())
+	return nil
+}
+
+func (this *probedDialog) Close() {
+	this.closed = true
+}
+
+func TestDialogProber(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	go p.Run()
+	defer p.Stop()
+
+	alive, dead := &probedDialog{}, &probedDialog{}
+	var reported []Dialog
+
+	prober := NewDialogProber(p)
+	prober.SetInterval(time.Minute)
+	prober.SetTimeout(10 * time.Second)
+	prober.SetMaxFailures(2)
+	prober.SetDeadHandler(func(d Dialog) { reported = append(reported, d) })
+	prober.AddDialog(alive)
+	prober.AddDialog(dead)
+
+	dp := prober.(*dialogProber)
+	probe := func() {
+		dp.expire()
+		for _, d := range prober.GetDialogs() {
+			dp.probe(d)
+		}
+	}
+	answer := func(d Dialog, statusCode int) {
+		for ct, probe := range dp.pending {
+			if probe.dialog == d {
+				prober.ProcessResponse(*NewResponseEvent(ct, CreateResponse(ct.GetRequest(), statusCode)))
+			}
+		}
+	}
+
+	//the dead peer misses two probes in a row
+	for i := 0; i < 2; i++ {
+		probe()
+		answer(alive, METHOD_NOT_ALLOWED)
+		clock.Advance(time.Minute)
+	}
+	probe()
+
+	if len(reported) != 1 || reported[0] != dead || !dead.closed {
+		t.Fatalf("dead dialog not torn down: %v", reported)
+	}
+	if last := dead.sent[len(dead.sent)-1]; last != BYE {
+		t.Errorf("dead dialog ended with %s, want BYE", last)
+	}
+	if alive.closed || len(prober.GetDialogs()) != 1 {
+		t.Errorf("live dialog torn down")
+	}
+
+	//481 means the dialog is already gone
+	answer(alive, CALL_OR_TRANSACTION_DOES_NOT_EXIST)
+	if len(reported) != 2 || reported[1] != alive {
+		t.Fatalf("481 did not tear the dialog down: %v", reported)
+	}
+}
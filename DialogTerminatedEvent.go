@@ -0,0 +1,51 @@
+package sip
+
+// DialogTerminationReason tells why a dialog terminated.
+type DialogTerminationReason int
+
+const (
+	DIALOGTERMINATED_CLOSED          DialogTerminationReason = iota //0
+	DIALOGTERMINATED_BYE                                            //1
+	DIALOGTERMINATED_REJECTED                                       //2
+	DIALOGTERMINATED_EARLY_TIMEOUT                                  //3
+	DIALOGTERMINATED_FORKED                                         //4
+	DIALOGTERMINATED_SESSION_EXPIRED                                //5
+)
+
+func (this DialogTerminationReason) String() string {
+	switch this {
+	case DIALOGTERMINATED_CLOSED:
+		return "closed"
+	case DIALOGTERMINATED_BYE:
+		return "bye"
+	case DIALOGTERMINATED_REJECTED:
+		return "rejected"
+	case DIALOGTERMINATED_EARLY_TIMEOUT:
+		return "early timeout"
+	case DIALOGTERMINATED_FORKED:
+		return "forked"
+	case DIALOGTERMINATED_SESSION_EXPIRED:
+		return "session expired"
+	}
+	return "unknown"
+}
+
+type DialogTerminatedEvent struct {
+	dialog Dialog
+	reason DialogTerminationReason
+}
+
+func NewDialogTerminatedEvent(dialog Dialog, reason DialogTerminationReason) *DialogTerminatedEvent {
+	return &DialogTerminatedEvent{
+		dialog: dialog,
+		reason: reason,
+	}
+}
+
+func (this *DialogTerminatedEvent) GetDialog() Dialog {
+	return this.dialog
+}
+
+func (this *DialogTerminatedEvent) GetReason() DialogTerminationReason {
+	return this.reason
+}
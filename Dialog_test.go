@@ -0,0 +1,251 @@
+package sip
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// waitSent waits for the asynchronous resolution of a request until n
+// messages were sent.
+func waitSent(t *testing.T, sent *sentMessages, n int) {
+	for i := 0; sent.len() < n; i++ {
+		if i == 100 {
+			t.Fatalf("%d messages sent, want %d", sent.len(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientDialog(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	var events []string
+	p.AddListener(&recordingListener{name: "listener", events: &events})
+
+	invite := NewRequest(INVITE, "sip:bob@biloxi.com", nil)
+	h := invite.GetHeader()
+	h.Set("Via", "SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bKdlg1")
+	h.Set("From", "Alice <sip:alice@atlanta.com>;tag=1928301774")
+	h.Set("To", "Bob <sip:bob@biloxi.com>")
+	h.Set("Call-Id", "a84b4c76e66710@pc33.atlanta.com")
+	h.Set("Cseq", "314159 INVITE")
+	h.Set("Contact", "<sip:alice@pc33.atlanta.com>")
+	ct := p.GetNewClientTransaction(invite).(*clientTransaction)
+	ct.hops = []Hop{NewHop("192.0.2.10", 5060, UDP)}
+	ct.start()
+
+	//a provisional response with a To tag sets up an early dialog
+	ringing := CreateResponse(invite, RINGING)
+	ringing.GetHeader().Set("To", "Bob <sip:bob@biloxi.com>;tag=a6c85cf")
+	ringing.GetHeader().Set("Contact", "<sip:bob@192.0.2.4>")
+	ringing.GetHeader().Set("Record-Route", "<sip:192.0.2.21;lr>, <sip:192.0.2.20;lr>")
+	p.processResponse(ringing)
+	d, ok := ct.GetDialog().(*dialog)
+	if !ok || d.GetState() != DIALOGSTATE_EARLY {
+		t.Fatalf("dialog = %v after 180, want early", ct.GetDialog())
+	}
+	if d.GetDialogId() != "a84b4c76e66710@pc33.atlanta.com;1928301774;a6c85cf" || d.IsServer() || d.GetLocalParty() != "sip:alice@atlanta.com" {
+		t.Errorf("dialog %s, local party %s", d.GetDialogId(), d.GetLocalParty())
+	}
+
+	//the 2xx confirms it and sets the route set again
+	ok200 := CreateResponse(invite, OK)
+	ok200.GetHeader().Set("To", "Bob <sip:bob@biloxi.com>;tag=a6c85cf")
+	ok200.GetHeader().Set("Contact", "<sip:bob@192.0.2.5>")
+	ok200.GetHeader().Set("Record-Route", "<sip:192.0.2.21;lr>")
+	ok200.GetHeader().Add("Record-Route", "<sip:192.0.2.20;lr>")
+	p.processResponse(ok200)
+	if d.GetState() != DIALOGSTATE_CONFIRMED || d.GetRemoteTarget() != "sip:bob@192.0.2.5" {
+		t.Fatalf("state %d, remote target %s after 200", d.GetState(), d.GetRemoteTarget())
+	}
+	if routes := d.GetRouteSet(); len(routes) != 2 || routes[0] != "<sip:192.0.2.20;lr>" {
+		t.Errorf("route set = %v", routes)
+	}
+
+	//the ACK takes the CSeq number of the INVITE and is sent again for a
+	//retransmitted 2xx
+	ack, err := d.CreateRequest(ACK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack.GetHeader().Get("Cseq") != "314159 ACK" || ack.GetRequestURIString() != "sip:bob@192.0.2.5" || getBranch(ack) == "z9hG4bKdlg1" {
+		t.Errorf("ACK = %v", ack.GetHeader())
+	}
+	if err = d.SendAck(ack); err != nil {
+		t.Fatal(err)
+	}
+	n := sent.len()
+	p.processResponse(ok200)
+	if sent.len() != n+1 || sent.last() != ack {
+		t.Error("retransmitted 2xx not acknowledged again")
+	}
+
+	bye, err := d.CreateRequest(BYE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bh := bye.GetHeader()
+	if bh.Get("Cseq") != "314160 BYE" || bh.Get("To") != "Bob <sip:bob@biloxi.com>;tag=a6c85cf" ||
+		bh.Get("From") != "Alice <sip:alice@atlanta.com>;tag=1928301774" || bh.Get("Route") != "<sip:192.0.2.20;lr>" || bh.Get("Contact") != "" {
+		t.Errorf("BYE = %v", bh)
+	}
+
+	other := NewRequest(BYE, "sip:bob@192.0.2.5", nil)
+	other.GetHeader().Set("Call-Id", "another")
+	if err = d.SendRequest(p.GetNewClientTransaction(other)); err != ErrDialogMismatch {
+		t.Errorf("SendRequest of another call: %v", err)
+	}
+
+	byeCt := p.GetNewClientTransaction(bye)
+	if err = d.SendRequest(byeCt); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, n+2)
+	p.processResponse(CreateResponse(bye, OK))
+	if d.GetState() != DIALOGSTATE_TERMINATED || p.matchDialog(ok200) != nil {
+		t.Errorf("state = %d after the 200 to the BYE, want terminated", d.GetState())
+	}
+	if _, err = d.CreateRequest(INFO); err != ErrDialogTerminated {
+		t.Errorf("CreateRequest in a terminated dialog: %v", err)
+	}
+}
+
+func TestServerDialog(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKdlg2")
+	invite.GetHeader().Set("Contact", "<sip:alice@192.0.2.1>")
+	invite.GetHeader().Set("Record-Route", "<sip:p1.example.com;lr>")
+	invite.SetMessageInfo(&MessageInfo{Network: UDP, LocalAddr: &net.UDPAddr{IP: net.IPv4zero, Port: 5060}})
+	p.processMessage(invite)
+	st := l.transactions[0].(*serverTransaction)
+
+	resp := CreateResponse(invite, OK)
+	resp.GetHeader().Set("To", "<sip:bob@example.com>;tag=b1")
+	resp.GetHeader().Set("Contact", "<sip:bob@192.0.2.2>")
+	if err := st.SendResponse(resp); err != nil {
+		t.Fatal(err)
+	}
+	d, ok := st.GetDialog().(*dialog)
+	if !ok || d.GetState() != DIALOGSTATE_CONFIRMED || !d.IsServer() || d.GetRemoteSequenceNumber() != 1 {
+		t.Fatalf("dialog = %v after 200", st.GetDialog())
+	}
+	if d.GetRemoteTarget() != "sip:alice@192.0.2.1" || d.GetRouteSet()[0] != "<sip:p1.example.com;lr>" {
+		t.Errorf("remote target %s, route set %v", d.GetRemoteTarget(), d.GetRouteSet())
+	}
+
+	//a request of the UAS leaves from the address the INVITE came in on
+	info, err := d.CreateRequest(INFO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if via := getTopVia(info); getSentBy(info) != "example.com:5060" || via[:12] != "SIP/2.0/UDP " {
+		t.Errorf("Via = %s", via)
+	}
+	if info.GetHeader().Get("From") != "<sip:bob@example.com>;tag=b1" || info.GetHeader().Get("Cseq") != "1 INFO" {
+		t.Errorf("INFO = %v", info.GetHeader())
+	}
+
+	//in-dialog requests are matched, and checked for their CSeq
+	reinvite := newServerTestRequest(INVITE, "UDP", "z9hG4bKdlg3")
+	reinvite.GetHeader().Set("To", "<sip:bob@example.com>;tag=b1")
+	reinvite.GetHeader().Set("Cseq", "2 INVITE")
+	reinvite.GetHeader().Set("Contact", "<sip:alice@192.0.2.9>")
+	p.processMessage(reinvite)
+	if len(l.requests) != 2 || l.requests[1].GetDialog() != d || d.GetRemoteTarget() != "sip:alice@192.0.2.9" {
+		t.Fatalf("re-INVITE not delivered in the dialog")
+	}
+
+	n := sent.len()
+	stale := newServerTestRequest(INFO, "UDP", "z9hG4bKdlg4")
+	stale.GetHeader().Set("To", "<sip:bob@example.com>;tag=b1")
+	stale.GetHeader().Set("Cseq", "1 INFO")
+	p.processMessage(stale)
+	if len(l.requests) != 2 || sent.len() != n+1 || sent.last().(Response).GetStatusCode() != SERVER_INTERNAL_ERROR {
+		t.Error("out of order request not rejected with 500")
+	}
+
+	bye := newServerTestRequest(BYE, "UDP", "z9hG4bKdlg5")
+	bye.GetHeader().Set("To", "<sip:bob@example.com>;tag=b1")
+	bye.GetHeader().Set("Cseq", "3 BYE")
+	p.processMessage(bye)
+	if d.GetState() != DIALOGSTATE_TERMINATED || p.matchDialog(bye) != nil {
+		t.Errorf("state = %d after BYE, want terminated", d.GetState())
+	}
+}
+
+type dialogListener struct {
+	serverListener
+	terminated []DialogTerminatedEvent
+}
+
+func (this *dialogListener) ProcessDialogTerminated(dialogTerminatedEvent DialogTerminatedEvent) {
+	this.terminated = append(this.terminated, dialogTerminatedEvent)
+}
+
+func TestEarlyDialogReaper(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &dialogListener{}
+	p.AddListener(l)
+
+	newInvite := func(branch string) (*clientTransaction, Request) {
+		invite := newServerTestRequest(INVITE, "UDP", branch)
+		ct := p.GetNewClientTransaction(invite).(*clientTransaction)
+		ct.hops = []Hop{NewHop("192.0.2.10", 5060, UDP)}
+		ct.start()
+		return ct, invite
+	}
+	respond := func(invite Request, statusCode int, tag string) *dialog {
+		resp := CreateResponse(invite, statusCode)
+		resp.GetHeader().Set("To", "<sip:bob@example.com>;tag="+tag)
+		p.processResponse(resp)
+		return p.matchDialog(resp)
+	}
+
+	//the 2xx of one branch ends the early dialogs of the others
+	ct, invite := newInvite("z9hG4bKfork")
+	loser := respond(invite, RINGING, "a")
+	winner := respond(invite, RINGING, "b")
+	if loser == nil || winner == nil || loser == winner {
+		t.Fatal("forked provisional responses did not set up two early dialogs")
+	}
+	respond(invite, OK, "b")
+	if loser.GetState() != DIALOGSTATE_TERMINATED || winner.GetState() != DIALOGSTATE_CONFIRMED || ct.GetDialog() != winner {
+		t.Fatalf("states %d and %d after the 2xx", loser.GetState(), winner.GetState())
+	}
+	if len(l.terminated) != 1 || l.terminated[0].GetDialog() != loser || l.terminated[0].GetReason() != DIALOGTERMINATED_FORKED {
+		t.Fatalf("%d dialogs reported terminated", len(l.terminated))
+	}
+
+	//an early dialog never confirmed is reaped with its transaction
+	ct, invite = newInvite("z9hG4bKearly")
+	early := respond(invite, SESSION_PROGRESS, "c")
+	clock.Advance(DIALOG_EARLY_TIMEOUT - time.Second)
+	if early.GetState() != DIALOGSTATE_EARLY {
+		t.Fatal("early dialog reaped before the timeout")
+	}
+	clock.Advance(time.Second)
+	if early.GetState() != DIALOGSTATE_TERMINATED || ct.GetState() != TRANSACTIONSTATE_TERMINATED {
+		t.Fatalf("dialog %d, transaction %d after the timeout", early.GetState(), ct.GetState())
+	}
+	if len(l.terminated) != 2 || l.terminated[1].GetReason() != DIALOGTERMINATED_EARLY_TIMEOUT {
+		t.Errorf("%d dialogs reported terminated", len(l.terminated))
+	}
+	if p.matchDialog(invite) != nil || winner.GetState() != DIALOGSTATE_CONFIRMED {
+		t.Error("reaper touched another dialog")
+	}
+}
@@ -0,0 +1,203 @@
+package sip
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A Dispatcher hands incoming messages to a fixed set of serial lanes,
+// chosen by hashing the Call-ID. Messages of different calls are processed
+// in parallel while the messages of one call keep their arrival order.
+//
+// Each lane has a second queue for the messages selected by the
+// Prioritizer, served first and never blocked behind normal traffic. The
+// order of a call holds as long as its requests are all prioritized or
+// none of them is, as RFC 4412 expects within a session.
+//
+// Ahead of both, the fast path of a lane takes the ACKs and CANCELs, which
+// confirm and tear down calls, so that they are not delayed behind a
+// backlog of new INVITEs during overload. They overtake the messages of
+// their own call queued before them: a CANCEL overtaking its INVITE is
+// answered as one with no INVITE to cancel.
+type Dispatcher interface {
+	Dispatch(msg Message)
+	GetLanes() int
+
+	GetPrioritizer() Prioritizer
+	SetPrioritizer(prioritizer Prioritizer)
+	GetFastPath() bool
+	SetFastPath(fastPath bool)
+
+	Start()
+	Stop()
+}
+
+const (
+	DISPATCHER_LANES      = 16
+	DISPATCHER_LANE_QUEUE = 64
+)
+
+////////////////////Implementation////////////////////////
+
+type dispatcher struct {
+	lanes   []*lane
+	handler func(Message)
+
+	mutex       sync.Mutex
+	prioritizer Prioritizer
+	fastPath    bool
+
+	quit      chan bool
+	waitGroup *sync.WaitGroup
+}
+
+// NewDispatcher returns a Dispatcher calling handler on lanes goroutines.
+// Dispatch blocks while the lane of a message is full, pushing back on the
+// connection it was read from.
+func NewDispatcher(lanes int, handler func(Message)) Dispatcher {
+	this := &dispatcher{}
+
+	if lanes <= 0 {
+		lanes = 1
+	}
+	this.lanes = make([]*lane, lanes)
+	for i := range this.lanes {
+		this.lanes[i] = &lane{
+			normal:   make(chan Message, DISPATCHER_LANE_QUEUE),
+			priority: make(chan Message, DISPATCHER_LANE_QUEUE),
+			fast:     make(chan Message, DISPATCHER_LANE_QUEUE),
+		}
+	}
+	this.handler = handler
+	this.prioritizer = NewResourcePrioritizer(PRIORITY_NAMESPACES...)
+	this.fastPath = true
+
+	this.quit = make(chan bool)
+	this.waitGroup = &sync.WaitGroup{}
+
+	return this
+}
+
+func (this *dispatcher) GetLanes() int {
+	return len(this.lanes)
+}
+
+func (this *dispatcher) GetPrioritizer() Prioritizer {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.prioritizer
+}
+
+// SetPrioritizer replaces the Prioritizer; nil disables priority queuing.
+func (this *dispatcher) SetPrioritizer(prioritizer Prioritizer) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.prioritizer = prioritizer
+}
+
+func (this *dispatcher) GetFastPath() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.fastPath
+}
+
+// SetFastPath sets whether ACKs and CANCELs are queued ahead of the other
+// messages, as they are by default.
+func (this *dispatcher) SetFastPath(fastPath bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.fastPath = fastPath
+}
+
+func (this *dispatcher) Dispatch(msg Message) {
+	l := this.lanes[this.getLane(msg)]
+	queue := l.normal
+	if this.GetFastPath() && isFastPath(msg) {
+		queue = l.fast
+	} else if prioritizer := this.GetPrioritizer(); prioritizer != nil && prioritizer(msg) {
+		queue = l.priority
+	}
+
+	select {
+	case queue <- msg:
+	case <-this.quit:
+	}
+}
+
+func (this *dispatcher) Start() {
+	for _, l := range this.lanes {
+		this.waitGroup.Add(1)
+		go this.serve(l)
+	}
+}
+
+// Stop waits for the messages being handled; the queued ones are dropped.
+func (this *dispatcher) Stop() {
+	close(this.quit)
+	this.waitGroup.Wait()
+}
+
+func (this *dispatcher) serve(l *lane) {
+	defer this.waitGroup.Done()
+
+	for {
+		//drain the fast path, then the priority queue, before looking at
+		//normal traffic
+		select {
+		case <-this.quit:
+			return
+		case msg := <-l.fast:
+			this.handler(msg)
+			continue
+		default:
+		}
+		select {
+		case <-this.quit:
+			return
+		case msg := <-l.fast:
+			this.handler(msg)
+			continue
+		case msg := <-l.priority:
+			this.handler(msg)
+			continue
+		default:
+		}
+
+		select {
+		case <-this.quit:
+			return
+		case msg := <-l.fast:
+			this.handler(msg)
+		case msg := <-l.priority:
+			this.handler(msg)
+		case msg := <-l.normal:
+			this.handler(msg)
+		}
+	}
+}
+
+// isFastPath reports whether msg is an ACK or a CANCEL.
+func isFastPath(msg Message) bool {
+	req, ok := msg.(Request)
+	return ok && (req.GetMethod() == ACK || req.GetMethod() == CANCEL)
+}
+
+func (this *dispatcher) getLane(msg Message) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(msg.GetHeader().Get("Call-Id")))
+	return int(hash.Sum32() % uint32(len(this.lanes)))
+}
+
+// lane is one serial lane: its fast path is served before its priority
+// queue, and its priority queue before the normal one.
+type lane struct {
+	normal   chan Message
+	priority chan Message
+	fast     chan Message
+}
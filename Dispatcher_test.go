@@ -0,0 +1,122 @@
+package sip
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatcher(t *testing.T) {
+	const calls, messages = 8, 50
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	seen := make(map[string][]int)
+
+	d := NewDispatcher(4, func(msg Message) {
+		defer wg.Done()
+		cseq, _ := getCSeq(msg)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		callId := msg.GetHeader().Get("Call-Id")
+		seen[callId] = append(seen[callId], cseq)
+	})
+	d.Start()
+	defer d.Stop()
+
+	for i := 0; i < messages; i++ {
+		for c := 0; c < calls; c++ {
+			req := NewRequest(INFO, "sip:bob@example.com", nil)
+			req.GetHeader().Set("Call-Id", "call-"+strconv.Itoa(c))
+			req.GetHeader().Set("Cseq", strconv.Itoa(i)+" INFO")
+			wg.Add(1)
+			d.Dispatch(req)
+		}
+	}
+	wg.Wait()
+
+	if len(seen) != calls {
+		t.Fatalf("%d calls handled, want %d", len(seen), calls)
+	}
+	for callId, cseqs := range seen {
+		for i, cseq := range cseqs {
+			if cseq != i {
+				t.Fatalf("%s handled out of order: %v", callId, cseqs)
+			}
+		}
+	}
+}
+
+func TestFastPath(t *testing.T) {
+	busy, release := make(chan bool), make(chan bool)
+	handled := make(chan string, 16)
+	d := NewDispatcher(1, func(msg Message) {
+		req := msg.(Request)
+		if req.GetHeader().Get("Call-Id") == "busy" {
+			busy <- true
+			<-release
+		}
+		handled <- req.GetMethod()
+	})
+	d.Start()
+	defer d.Stop()
+
+	dispatch := func(method, callId string) {
+		req := NewRequest(method, "sip:bob@example.com", nil)
+		req.GetHeader().Set("Call-Id", callId)
+		d.Dispatch(req)
+	}
+	//the lane is held while the others queue up
+	dispatch(INVITE, "busy")
+	<-busy
+	for i := 0; i < 4; i++ {
+		dispatch(INVITE, "call-"+strconv.Itoa(i))
+	}
+	dispatch(ACK, "call-ack")
+	dispatch(CANCEL, "call-cancel")
+	close(release)
+
+	want := []string{INVITE, ACK, CANCEL, INVITE, INVITE, INVITE, INVITE}
+	for i, method := range want {
+		if got := <-handled; got != method {
+			t.Fatalf("%d: handled %s, want %s", i, got, method)
+		}
+	}
+}
+
+func TestDispatchLanes(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	if p.GetDispatchLanes() != DISPATCHER_LANES {
+		t.Errorf("%d lanes by default", p.GetDispatchLanes())
+	}
+
+	//the settings of the dispatcher replaced are kept
+	p.SetFastPath(false)
+	p.SetPrioritizer(nil)
+	if err := p.SetDispatchLanes(1); err != nil {
+		t.Fatal(err)
+	}
+	if p.GetDispatchLanes() != 1 || p.GetFastPath() || p.GetPrioritizer() != nil {
+		t.Errorf("%d lanes, fast path %v, prioritizer %v", p.GetDispatchLanes(), p.GetFastPath(), p.GetPrioritizer())
+	}
+
+	//the dispatcher of a running provider stays
+	go p.Run()
+	defer p.Stop()
+	for i := 0; ; i++ {
+		p.mutex.Lock()
+		running := p.running
+		p.mutex.Unlock()
+		if running {
+			break
+		} else if i == 100 {
+			t.Fatal("provider not running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := p.SetDispatchLanes(4); err != ErrProviderRunning || p.GetDispatchLanes() != 1 {
+		t.Errorf("lanes replaced while running: %v", err)
+	}
+}
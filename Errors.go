@@ -0,0 +1,38 @@
+package sip
+
+import (
+	"errors"
+)
+
+var ErrTransactionTimeout = errors.New("sip: transaction timed out")
+
+// TimeoutError reports a transaction that expired without a final response
+// (Timer B, F or H).
+type TimeoutError struct {
+	Transaction Transaction
+}
+
+func (this *TimeoutError) Error() string {
+	return ErrTransactionTimeout.Error()
+}
+
+func (this *TimeoutError) Timeout() bool {
+	return true
+}
+
+func (this *TimeoutError) Unwrap() error {
+	return ErrTransactionTimeout
+}
+
+// TransportError reports a failure to transmit a message.
+type TransportError struct {
+	Err error
+}
+
+func (this *TransportError) Error() string {
+	return "sip: transport failure: " + this.Err.Error()
+}
+
+func (this *TransportError) Unwrap() error {
+	return this.Err
+}
@@ -0,0 +1,63 @@
+package sip
+
+import (
+	"strings"
+)
+
+// getEventPackage returns the event package of the Event header of msg,
+// without its parameters (RFC 6665 8.2.1), or "" if it has none.
+func getEventPackage(msg Message) string {
+	return mediaToken(msg.GetHeader().Get("Event"))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (this *provider) GetEventPackages() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.eventPackages
+}
+
+// SetEventPackages registers the event packages the subscriptions of this
+// provider support (RFC 6665), enabling the admission of SUBSCRIBE: one
+// for another package, or without an Event header, is answered with 489
+// Bad Event instead of reaching the listeners. The responses and the
+// OPTIONS sent get an Allow-Events header listing the packages, unless
+// they have one. An empty list disables both.
+func (this *provider) SetEventPackages(packages []string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.eventPackages = packages
+}
+
+// isEventAllowed reports whether req may reach the listeners: it is not a
+// SUBSCRIBE, or it is for a registered event package.
+func (this *provider) isEventAllowed(req Request) bool {
+	packages := this.GetEventPackages()
+	if len(packages) == 0 || req.GetMethod() != SUBSCRIBE {
+		return true
+	}
+	pkg := getEventPackage(req)
+	for _, p := range packages {
+		if strings.EqualFold(p, pkg) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *provider) stampAllowEvents(msg Message) {
+	packages := this.GetEventPackages()
+	if len(packages) == 0 || msg.GetHeader().Get("Allow-Events") != "" {
+		return
+	}
+	if req, ok := msg.(Request); ok && req.GetMethod() != OPTIONS {
+		return
+	}
+	if resp, ok := msg.(Response); ok && resp.GetStatusCode() == TRYING {
+		return
+	}
+	msg.GetHeader().Set("Allow-Events", strings.Join(packages, ", "))
+}
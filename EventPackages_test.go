@@ -0,0 +1,73 @@
+package sip
+
+import (
+	"testing"
+)
+
+func TestEventPackages(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+
+	//disabled, any package reaches the listener
+	req := newServerTestRequest(SUBSCRIBE, "UDP", "z9hG4bKev1")
+	req.GetHeader().Set("Event", "dialog")
+	p.processMessage(req)
+	if len(l.requests) != 1 {
+		t.Fatalf("SUBSCRIBE not delivered with no packages registered")
+	}
+
+	p.SetEventPackages([]string{"presence", "dialog"})
+
+	for i, c := range []struct {
+		event     string
+		delivered bool
+	}{
+		{"presence;id=1", true},
+		{"reg", false},
+		{"", false},
+	} {
+		delivered := len(l.requests)
+		req := newServerTestRequest(SUBSCRIBE, "UDP", "z9hG4bKev2"+string(rune('a'+i)))
+		if c.event != "" {
+			req.GetHeader().Set("Event", c.event)
+		}
+		p.processMessage(req)
+		if got := len(l.requests) > delivered; got != c.delivered {
+			t.Errorf("Event %q delivered %v, want %v", c.event, got, c.delivered)
+		}
+		if !c.delivered {
+			resp := sent.last().(Response)
+			if resp.GetStatusCode() != BAD_EVENT || resp.GetHeader().Get("Allow-Events") != "presence, dialog" {
+				t.Errorf("Event %q answered %d, Allow-Events %q", c.event, resp.GetStatusCode(), resp.GetHeader().Get("Allow-Events"))
+			}
+		}
+	}
+
+	//answers and OPTIONS advertise the packages, other requests do not
+	st := l.requests[0].GetServerTransaction()
+	if err := st.SendResponse(CreateResponse(st.GetRequest(), OK)); err != nil {
+		t.Fatal(err)
+	}
+	if v := sent.last().GetHeader().Get("Allow-Events"); v != "presence, dialog" {
+		t.Errorf("200 Allow-Events = %q", v)
+	}
+	for _, c := range []struct {
+		method string
+		want   string
+	}{
+		{OPTIONS, "presence, dialog"},
+		{MESSAGE, ""},
+	} {
+		req := NewRequest(c.method, "sip:192.0.2.2", nil)
+		if err := p.SendRequest(req); err != nil {
+			t.Fatal(err)
+		}
+		if v := req.GetHeader().Get("Allow-Events"); v != c.want {
+			t.Errorf("%s Allow-Events = %q, want %q", c.method, v, c.want)
+		}
+	}
+}
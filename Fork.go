@@ -0,0 +1,305 @@
+package sip
+
+import (
+	"sort"
+	"time"
+)
+
+// A stateful Proxy may fork a request to every target the location service
+// has for it (RFC 3261 16.6 and 16.7), each in a client transaction, or
+// branch, of its own; see ForkingPolicy. The provisional responses of the
+// branches are sent upstream as they come, and so is every 2xx, which
+// cancels the other branches. The other final responses are kept until
+// every branch has one, the best of them is then sent upstream; a 6xx
+// cancels the other branches too. A branch which fails without a response
+// counts as a 408 Request Timeout, or a 503 Service Unavailable.
+
+// A ForkingPolicy tells how a stateful Proxy tries the targets of a request.
+type ForkingPolicy int
+
+const (
+	FORKINGPOLICY_NONE       ForkingPolicy = iota //0, the first target only
+	FORKINGPOLICY_PARALLEL                        //1, every target at once
+	FORKINGPOLICY_SEQUENTIAL                      //2, one target at a time, by decreasing q-value
+)
+
+// PROXY_FORK_TIMEOUT is how long a sequential search waits for the final
+// response of a branch before cancelling it and trying the next target.
+const PROXY_FORK_TIMEOUT = 20 * time.Second
+
+////////////////////Implementation////////////////////////
+
+const resourceWatch = "watch goroutine"
+
+type proxyTransaction struct {
+	key    string
+	server ServerTransaction
+
+	//the request to forward, but for its Request-URI and Via
+	request Request
+	body    []byte
+
+	//the fields below are guarded by the mutex of the proxy
+
+	targets  []string                   //the targets left to try
+	bindings map[string]Binding         //the bindings of the targets registered
+	branches map[ClientTransaction]bool //the branches without a final response
+	finals   []Response                 //the final responses of the branches
+	answered bool                       //a final response was sent upstream
+
+	//the branch of a sequential search, until it times out
+	current ClientTransaction
+	timer   Timer
+}
+
+func (this *proxy) GetForkingPolicy() ForkingPolicy {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.forkingPolicy
+}
+
+// SetForkingPolicy sets how the requests are forked, FORKINGPOLICY_NONE by
+// default. A stateless proxy never forks.
+func (this *proxy) SetForkingPolicy(policy ForkingPolicy) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.forkingPolicy = policy
+}
+
+func (this *proxy) GetForkTimeout() time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.forkTimeout
+}
+
+// SetForkTimeout sets how long a branch of a sequential search is tried
+// when other targets are left, PROXY_FORK_TIMEOUT by default.
+func (this *proxy) SetForkTimeout(d time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.forkTimeout = d
+}
+
+// fork forwards the request of pt to the targets to try next: all of them,
+// or the first one of a sequential search.
+func (this *proxy) fork(pt *proxyTransaction) {
+	this.mutex.Lock()
+	n := len(pt.targets)
+	if this.forkingPolicy == FORKINGPOLICY_SEQUENTIAL && n > 1 {
+		n = 1
+	}
+	targets := pt.targets[:n]
+	pt.targets = pt.targets[n:]
+	this.mutex.Unlock()
+
+	for _, target := range targets {
+		this.branch(pt, target)
+	}
+}
+
+// branch forwards the request of pt to target in a new client transaction.
+func (this *proxy) branch(pt *proxyTransaction, target string) {
+	req := copyRequest(pt.request, pt.body)
+	req.SetRequestURIString(target)
+	branch := this.provider.GetBranchStrategy().GetBranch(req)
+	req.GetHeader().AddBefore("Via", 0, "SIP/2.0/UDP "+this.getHostPort()+";branch="+branch)
+	var flow Hop
+	if b, ok := pt.bindings[target]; ok {
+		flow = this.toBinding(req, b)
+	}
+
+	ct := this.provider.GetNewClientTransaction(req)
+	t, ok := ct.(*clientTransaction)
+	if ok {
+		t.setProxied()
+		if flow != nil {
+			t.setFlow(flow)
+		}
+	}
+
+	this.mutex.Lock()
+	pt.branches[ct] = true
+	this.clients[ct] = pt
+	if this.forkingPolicy == FORKINGPOLICY_SEQUENTIAL && len(pt.targets) > 0 {
+		pt.current = ct
+		pt.timer = this.provider.GetClock().AfterFunc(this.forkTimeout, func() {
+			this.timeout(pt, ct)
+		})
+	}
+	this.mutex.Unlock()
+
+	if err := ct.SendRequest(); err != nil {
+		this.getLogger().Log(LOG_ERROR, "Forwarding request failed", "target", ct.GetRequest().GetRequestURIString(), "error", err)
+		ct.Close()
+		this.processFinal(pt, ct, CreateResponse(pt.server.GetRequest(), SERVICE_UNAVAILABLE))
+		return
+	}
+	if ok {
+		t.provider.spawn(resourceWatch, func() { this.watch(pt, t) })
+	}
+}
+
+// timeout cancels ct, the branch of a sequential search which got no final
+// response in time, and tries the next target.
+func (this *proxy) timeout(pt *proxyTransaction, ct ClientTransaction) {
+	this.mutex.Lock()
+	if pt.current != ct || len(pt.targets) == 0 {
+		this.mutex.Unlock()
+		return
+	}
+	pt.current = nil
+	this.mutex.Unlock()
+
+	this.cancelBranch(ct)
+	this.fork(pt)
+}
+
+// processFinal takes resp, the final response of the branch ct, to send
+// upstream, and sends the best response of pt once every branch has
+// one.
+func (this *proxy) processFinal(pt *proxyTransaction, ct ClientTransaction, resp Response) {
+	var send []Response
+	var cancel []ClientTransaction
+
+	this.mutex.Lock()
+	if !pt.branches[ct] {
+		this.mutex.Unlock()
+		return
+	}
+	delete(pt.branches, ct)
+	delete(this.clients, ct)
+
+	switch resp.GetStatusCode() / 100 {
+	case 2:
+		//every 2xx to an INVITE goes upstream, for the dialogs to be set up
+		if !pt.answered || pt.server.GetRequest().GetMethod() == INVITE {
+			send = append(send, resp)
+		}
+		pt.answered = true
+		fallthrough
+	case 6:
+		if !pt.answered {
+			pt.finals = append(pt.finals, resp)
+		}
+		for b := range pt.branches {
+			cancel = append(cancel, b)
+		}
+		pt.targets = nil
+	default:
+		pt.finals = append(pt.finals, resp)
+	}
+
+	next := false
+	if pt.current == ct {
+		stopTimer(pt.timer)
+		pt.current = nil
+		next = len(pt.targets) > 0
+	}
+	if len(pt.branches) == 0 && len(pt.targets) == 0 {
+		if this.pending[pt.key] == pt {
+			delete(this.pending, pt.key)
+		}
+		if !pt.answered && len(pt.finals) > 0 {
+			send = append(send, getBestResponse(pt.finals))
+			pt.answered = true
+		}
+	}
+	this.mutex.Unlock()
+
+	for _, b := range cancel {
+		this.cancelBranch(b)
+	}
+	for _, r := range send {
+		if err := pt.server.SendResponse(r); err != nil && err != ErrTransactionCompleted {
+			this.getLogger().Log(LOG_ERROR, "Forwarding response failed", "error", err)
+		}
+	}
+	if next {
+		this.fork(pt)
+	}
+}
+
+// cancelBranch sends a CANCEL for ct, if it is an INVITE.
+func (this *proxy) cancelBranch(ct ClientTransaction) {
+	if ct.GetRequest().GetMethod() != INVITE {
+		return
+	}
+	cancel, err := ct.CreateCancel()
+	if err != nil || cancel == nil {
+		return
+	}
+	t := this.provider.GetNewClientTransaction(cancel)
+	if c, ok := t.(*clientTransaction); ok {
+		c.setProxied()
+	}
+	if err := t.SendRequest(); err != nil {
+		this.getLogger().Log(LOG_ERROR, "Sending CANCEL failed", "error", err)
+	}
+}
+
+// getBestResponse returns the response to send upstream of the final
+// responses of the branches (RFC 3261 16.7 step 6 and 7): a 6xx, else one
+// of the lowest class, preferring the 4xx which the caller may act upon.
+// A 503 becomes a 500, and a 401 or 407 takes the challenges of all of
+// them.
+func getBestResponse(finals []Response) Response {
+	rank := func(resp Response) int {
+		statusCode := resp.GetStatusCode()
+		if statusCode/100 == 6 {
+			return 0
+		}
+		switch statusCode {
+		case UNAUTHORIZED, PROXY_AUTHENTICATION_REQUIRED, UNSUPPORTED_MEDIA_TYPE, BAD_EXTENSION, ADDRESS_INCOMPLETE:
+			return 10*(statusCode/100) - 1
+		}
+		return 10 * (statusCode / 100)
+	}
+	sorted := append([]Response(nil), finals...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return rank(sorted[i]) < rank(sorted[j])
+	})
+	best := sorted[0]
+
+	switch best.GetStatusCode() {
+	case SERVICE_UNAVAILABLE:
+		best.SetStatusCode(SERVER_INTERNAL_ERROR)
+		best.SetReasonPhrase(StatusText(SERVER_INTERNAL_ERROR))
+	case UNAUTHORIZED, PROXY_AUTHENTICATION_REQUIRED:
+		h := best.GetHeader()
+		for _, resp := range sorted[1:] {
+			switch resp.GetStatusCode() {
+			case UNAUTHORIZED, PROXY_AUTHENTICATION_REQUIRED:
+				for _, name := range []string{"Www-Authenticate", "Proxy-Authenticate"} {
+					for _, v := range resp.GetHeader()[name] {
+						h.Add(name, v)
+					}
+				}
+			}
+		}
+	}
+	return best
+}
+
+// sortTargets orders the bindings by decreasing q-value, those without one
+// counting as 1.0, and returns their URIs.
+func sortTargets(bindings []Binding) []string {
+	q := func(b Binding) float32 {
+		if b.Q < 0 {
+			return 1
+		}
+		return b.Q
+	}
+	sorted := append([]Binding(nil), bindings...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return q(sorted[i]) > q(sorted[j])
+	})
+	targets := make([]string, len(sorted))
+	for i, b := range sorted {
+		targets[i] = b.URI
+	}
+	return targets
+}
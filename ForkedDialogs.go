@@ -0,0 +1,199 @@
+package sip
+
+import (
+	"sip/address"
+	"sort"
+	"strings"
+)
+
+// An INVITE forked by a proxy may set up an early dialog per branch that
+// answers with a provisional response, until one of the branches accepts
+// it with a 2xx. Each early dialog of a UAC keeps the last provisional
+// response of its branch, and the EarlyDialogPolicy of the provider ranks
+// them, see GetEarlyDialogs, for a find-me/follow-me client to render the
+// early media of the preferred one only. A UAC cannot cancel a single
+// branch: once one accepts, the forking proxy cancels the others (RFC 3261
+// 16.7, step 10) and the provider ends their early dialogs. With a policy,
+// a 2xx still coming from another branch, within 64*T1 of the first one,
+// is acknowledged and its dialog ended with a BYE (RFC 3261 13.2.2.4)
+// before the application sees it.
+
+////////////////////Interface//////////////////////////////
+
+// An EarlyDialogPolicy scores the early dialog d by its last provisional
+// response; the higher the score, the more d is preferred.
+type EarlyDialogPolicy func(d Dialog, provisional Response) int
+
+////////////////////Implementation////////////////////////
+
+// PreferEarlyMedia prefers the early dialogs whose provisional response
+// carries a session description.
+func PreferEarlyMedia(d Dialog, provisional Response) int {
+	if provisional != nil && readSDP(provisional) != nil {
+		return 1
+	}
+	return 0
+}
+
+// PreferDomains prefers the early dialogs whose remote target is in one of
+// domains, or in a subdomain of it, the first ones the most.
+func PreferDomains(domains ...string) EarlyDialogPolicy {
+	return func(d Dialog, provisional Response) int {
+		uri, err := ParseURI(d.GetRemoteTarget())
+		if err != nil {
+			return 0
+		}
+		sipURI, ok := uri.(*address.SipURIImpl)
+		if !ok {
+			return 0
+		}
+		host := strings.ToLower(sipURI.GetHost())
+		for i, domain := range domains {
+			domain = strings.ToLower(domain)
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return len(domains) - i
+			}
+		}
+		return 0
+	}
+}
+
+// acceptedFork is a forked INVITE accepted by a 2xx of the winner branch,
+// with the dialogs of the other branches it ended, by their To tag.
+type acceptedFork struct {
+	t      Transaction
+	winner string
+	losers map[string]*dialog
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (this *provider) GetEarlyDialogPolicy() EarlyDialogPolicy {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.earlyDialogPolicy
+}
+
+// SetEarlyDialogPolicy sets the policy ranking the early dialogs of a
+// forked INVITE, none by default: they are then listed in the order they
+// were set up, and the 2xx of the branches but the first one to accept are
+// left to the application.
+func (this *provider) SetEarlyDialogPolicy(policy EarlyDialogPolicy) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.earlyDialogPolicy = policy
+}
+
+// GetEarlyDialogs returns the early dialogs set up by t, preferred first by
+// the early dialog policy, then in the order they were set up.
+func (this *provider) GetEarlyDialogs(t ClientTransaction) []Dialog {
+	var early []*dialog
+	this.mutex.Lock()
+	policy := this.earlyDialogPolicy
+	for _, d := range this.dialogs {
+		if d.first == Transaction(t) && !d.server {
+			early = append(early, d)
+		}
+	}
+	this.mutex.Unlock()
+
+	scores := make(map[*dialog]int)
+	dialogs := early[:0]
+	for _, d := range early {
+		if d.GetState() != DIALOGSTATE_EARLY {
+			continue
+		}
+		if policy != nil {
+			scores[d] = policy(d, d.GetProvisionalResponse())
+		}
+		dialogs = append(dialogs, d)
+	}
+	sort.Slice(dialogs, func(i, j int) bool {
+		if scores[dialogs[i]] != scores[dialogs[j]] {
+			return scores[dialogs[i]] > scores[dialogs[j]]
+		}
+		return dialogs[i].forkOrder < dialogs[j].forkOrder
+	})
+
+	ranked := make([]Dialog, len(dialogs))
+	for i, d := range dialogs {
+		ranked[i] = d
+	}
+	return ranked
+}
+
+// acceptFork remembers the INVITE t accepted by the 2xx of winner, for 64*T1
+// (Timer M of RFC 6026), to end the dialogs of the other branches.
+func (this *provider) acceptFork(t Transaction, winner *dialog) {
+	req := t.GetRequest()
+	if req.GetMethod() != INVITE || this.GetEarlyDialogPolicy() == nil {
+		return
+	}
+	key := getTransactionKey(req)
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if _, ok := this.accepted[key]; ok {
+		return
+	}
+	a := &acceptedFork{t: t, winner: winner.remoteTag, losers: make(map[string]*dialog)}
+	this.accepted[key] = a
+	this.clock.AfterFunc(64*TIMER_T1, func() {
+		this.mutex.Lock()
+		defer this.mutex.Unlock()
+
+		if this.accepted[key] == a {
+			delete(this.accepted, key)
+		}
+	})
+}
+
+// rejectFork acknowledges resp, the 2xx of a branch of an accepted INVITE
+// but the winner one, and ends its dialog with a BYE; the retransmissions
+// of resp get the ACK again. It reports whether resp was such a 2xx.
+func (this *provider) rejectFork(resp Response) bool {
+	toTag := getTag(resp.GetHeader().Get("To"))
+	if resp.GetStatusCode()/100 != 2 || getCSeqMethod(resp) != INVITE || getBranch(resp) == "" || toTag == "" {
+		return false
+	}
+
+	this.mutex.Lock()
+	a := this.accepted[getTransactionKey(resp)]
+	if a == nil || a.winner == toTag {
+		this.mutex.Unlock()
+		return false
+	}
+	d, ok := a.losers[toTag]
+	if !ok {
+		d = newDialog(a.t, resp, false)
+		d.provider = this
+		a.losers[toTag] = d
+	}
+	this.mutex.Unlock()
+
+	if ok {
+		d.resendAck()
+		return true
+	}
+
+	err := d.processOffer(resp, false)
+	if err == nil {
+		var ack Request
+		if ack, err = d.CreateRequest(ACK); err == nil {
+			err = d.SendAck(ack)
+		}
+	}
+	if err == nil {
+		var bye Request
+		if bye, err = d.CreateRequest(BYE); err == nil {
+			err = d.SendRequest(this.GetNewClientTransaction(bye))
+		}
+	}
+	if err != nil {
+		this.getLogger(COMPONENT_DIALOG).Log(LOG_ERROR, "Ending forked dialog failed", "dialog", d.GetDialogId(), "error", err)
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package sip
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type forkListener struct {
+	dialogListener
+	responses []ResponseEvent
+}
+
+func (this *forkListener) ProcessResponse(responseEvent ResponseEvent) {
+	this.responses = append(this.responses, responseEvent)
+}
+
+func TestEarlyDialogPolicy(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	sent := captureSends(p)
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.10", 5060, UDP)}, nil
+	}))
+	go p.Run()
+	defer p.Stop()
+	l := &forkListener{}
+	p.AddListener(l)
+
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKpolicy")
+	ct := p.GetNewClientTransaction(invite).(*clientTransaction)
+	ct.hops = []Hop{NewHop("192.0.2.10", 5060, UDP)}
+	ct.start()
+	respond := func(statusCode int, tag, contact string, sdp bool) Response {
+		resp := CreateResponse(invite, statusCode)
+		resp.GetHeader().Set("To", "<sip:bob@example.com>;tag="+tag)
+		resp.GetHeader().Set("Contact", contact)
+		if sdp {
+			resp.SetBody(NewSDPBody([]byte("v=0\r\no=early\r\n")))
+		}
+		p.processResponse(resp)
+		return resp
+	}
+	tags := func() string {
+		s := ""
+		for _, d := range p.GetEarlyDialogs(ct) {
+			s += d.GetRemoteTag()
+		}
+		return s
+	}
+
+	//without a policy, in the order they were set up
+	respond(RINGING, "a", "<sip:bob@desk.example.com>", false)
+	respond(SESSION_PROGRESS, "b", "<sip:bob@mobile.example.net>", true)
+	respond(RINGING, "c", "<sip:bob@home.example.org>", false)
+	if v := tags(); v != "abc" {
+		t.Errorf("early dialogs %s", v)
+	}
+	if d := p.GetEarlyDialogs(ct)[1]; d.GetProvisionalResponse().GetStatusCode() != SESSION_PROGRESS {
+		t.Errorf("provisional response %v", d.GetProvisionalResponse())
+	}
+
+	p.SetEarlyDialogPolicy(PreferEarlyMedia)
+	if v := tags(); v != "bac" {
+		t.Errorf("early media first: %s", v)
+	}
+	p.SetEarlyDialogPolicy(PreferDomains("example.org", "desk.example.com"))
+	if v := tags(); v != "cab" {
+		t.Errorf("domains first: %s", v)
+	}
+
+	//the first branch to accept wins
+	respond(OK, "c", "<sip:bob@home.example.org>", false)
+	if v := tags(); v != "" || len(l.terminated) != 2 {
+		t.Errorf("early dialogs %s, %d terminated", v, len(l.terminated))
+	}
+
+	//another one is acknowledged and hung up before the listeners see it
+	n, responses := sent.len(), len(l.responses)
+	late := respond(OK, "a", "<sip:bob@desk.example.com>", false)
+	waitSent(t, sent, n+2)
+	if sent.len() != n+2 || len(l.responses) != responses {
+		t.Fatalf("%d messages sent, %d responses", sent.len()-n, len(l.responses)-responses)
+	}
+	ack, bye := sent.get(n).(Request), sent.get(n+1).(Request)
+	if ack.GetMethod() != ACK || bye.GetMethod() != BYE || bye.GetRequestURI().String() != "sip:bob@desk.example.com" || getTag(bye.GetHeader().Get("To")) != "a" {
+		t.Errorf("sent %s then %s", ack.GetMethod(), bye.GetMethod())
+	}
+	p.processResponse(late)
+	if sent.len() != n+3 || sent.last().(Request).GetMethod() != ACK {
+		t.Error("ACK not resent for the retransmitted 2xx")
+	}
+}
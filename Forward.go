@@ -0,0 +1,131 @@
+package sip
+
+import (
+	"errors"
+	"net"
+	"sip/address"
+	"strconv"
+	"strings"
+)
+
+// An application steering a few requests, without running a Proxy, hands
+// them on with ForwardRequest, which takes the steps of RFC 3261 16.6 a
+// stateless proxy takes for a single target. The responses come back to
+// the listeners of the provider without a client transaction, the Via of
+// the provider on top: the application pops it and sends them on, as
+// Proxy does.
+
+var ErrTooManyHops = errors.New("sip: Max-Forwards exhausted")
+
+////////////////////Implementation////////////////////////
+
+// ForwardRequest sends a copy of req, a request the provider received, on
+// to nextHop, or to the next hop of its route if nextHop is nil. The
+// provider is removed from the route first, a Max-Forwards of
+// PROXY_MAX_FORWARDS set if there is none, and a Via of the provider
+// pushed, with a branch of its BranchStrategy. A request with a
+// Max-Forwards of 0 is not forwarded: ErrTooManyHops is returned, for the
+// application to answer it with 483 Too Many Hops.
+func (this *provider) ForwardRequest(req Request, nextHop Hop) error {
+	maxForwards := PROXY_MAX_FORWARDS
+	if v := req.GetHeader().Get("Max-Forwards"); v != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 0 {
+			return errors.New("sip: invalid Max-Forwards: " + v)
+		}
+		if n == 0 {
+			return ErrTooManyHops
+		}
+		maxForwards = n - 1
+	}
+
+	fwd := copyRequest(req, readBody(req))
+	if err := preprocessRoute(fwd, func(uri address.URI) bool {
+		return this.isLocalURI(req, uri)
+	}); err != nil {
+		return err
+	}
+	via, err := this.getLocalVia(req)
+	if err != nil {
+		return err
+	}
+	h := fwd.GetHeader()
+	h.Set("Max-Forwards", strconv.Itoa(maxForwards))
+	h.AddBefore("Via", 0, via+";branch="+this.GetBranchStrategy().GetBranch(fwd))
+	if nextHop != nil {
+		setViaTransport(fwd, nextHop.GetTransport())
+	}
+
+	return this.sendRequest(fwd, nextHop)
+}
+
+// getLocalHostPorts returns the addresses of the provider, as host:port:
+// the one req was received on, and those of its transports.
+func (this *provider) getLocalHostPorts(req Request) []string {
+	var hostports []string
+	if info := req.GetMessageInfo(); info != nil && info.LocalAddr != nil {
+		hostports = append(hostports, info.LocalAddr.String())
+	}
+	for _, t := range this.transports {
+		hostports = append(hostports, net.JoinHostPort(t.GetAddress(), strconv.Itoa(t.GetPort())))
+	}
+	return hostports
+}
+
+// isLocalURI reports whether uri is an address of the provider, which
+// received req.
+func (this *provider) isLocalURI(req Request, uri address.URI) bool {
+	sipURI, ok := uri.(*address.SipURIImpl)
+	if !ok {
+		return false
+	}
+	port := sipURI.GetPort()
+	if port <= 0 {
+		port = getDefaultPort(sipURI.GetTransportParam(), sipURI.IsSecure())
+	}
+	hostport := net.JoinHostPort(strings.Trim(sipURI.GetHost(), "[]"), strconv.Itoa(port))
+	for _, local := range this.getLocalHostPorts(req) {
+		if strings.EqualFold(local, hostport) {
+			return true
+		}
+	}
+	return false
+}
+
+// getLocalVia returns the Via, without a branch, of the requests the
+// provider forwards: the address req was received on, or the one of a
+// transport of the provider when it was received on a wildcard address.
+func (this *provider) getLocalVia(req Request) (string, error) {
+	network := UDP
+	if info := req.GetMessageInfo(); info != nil && info.LocalAddr != nil {
+		if info.Network != "" {
+			network = info.Network
+		}
+		if host, port, err := net.SplitHostPort(info.LocalAddr.String()); err == nil {
+			if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+				return "SIP/2.0/" + strings.ToUpper(network) + " " + net.JoinHostPort(host, port), nil
+			}
+		}
+	}
+
+	t := this.getTransport(network)
+	for _, tr := range this.transports {
+		if t != nil {
+			break
+		}
+		t, _ = tr.(*transport)
+	}
+	if t == nil {
+		return "", ErrNoLocalAddress
+	}
+	return "SIP/2.0/" + strings.ToUpper(t.GetNetwork()) + " " + net.JoinHostPort(t.GetAddress(), strconv.Itoa(t.GetPort())), nil
+}
+
+// getProviderVia returns the Via, without a branch, of a request p sends
+// from one of its listening points.
+func getProviderVia(p Provider, req Request) (string, error) {
+	if p, ok := p.(*provider); ok {
+		return p.getLocalVia(req)
+	}
+	return "", ErrNoLocalAddress
+}
@@ -0,0 +1,63 @@
+package sip
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForwardRequest(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	sent := captureSends(p)
+	p.AddTransport(newTransport(UDP, "192.0.2.10", 5060, nil))
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.30", h.GetPort(), h.GetTransport())}, nil
+	}))
+	newRequest := func(maxForwards string) Request {
+		req := newServerTestRequest(OPTIONS, "UDP", "z9hG4bKfwd1")
+		req.GetHeader().Set("Max-Forwards", maxForwards)
+		req.GetHeader().Add("Route", "<sip:192.0.2.10;lr>, <sip:p2.example.com;lr>")
+		req.SetMessageInfo(&MessageInfo{Network: UDP, LocalAddr: &net.UDPAddr{IP: net.ParseIP("192.0.2.10"), Port: 5060}})
+		return req
+	}
+
+	//to the hop given
+	req := newRequest("5")
+	if err := p.ForwardRequest(req, NewHop("192.0.2.20", 5070, TCP)); err != nil {
+		t.Fatal(err)
+	}
+	fwd := sent.last().(Request)
+	if h := sent.hops[0].String(); h != "192.0.2.20:5070/tcp" {
+		t.Errorf("forwarded to %s", h)
+	}
+	if v := fwd.GetHeader().Get("Max-Forwards"); v != "4" {
+		t.Errorf("Max-Forwards %s", v)
+	}
+	if routes := fwd.GetHeader().Values("Route"); len(routes) != 1 || routes[0] != "<sip:p2.example.com;lr>" {
+		t.Errorf("Route %v", routes)
+	}
+	vias := fwd.GetHeader().Values("Via")
+	if len(vias) != 2 || !strings.HasPrefix(vias[0], "SIP/2.0/TCP 192.0.2.10:5060;branch=z9hG4bK") || vias[1] != "SIP/2.0/UDP 192.0.2.1;branch=z9hG4bKfwd1" {
+		t.Errorf("Via %v", vias)
+	}
+	if len(req.GetHeader().Values("Via")) != 1 || req.GetHeader().Get("Max-Forwards") != "5" {
+		t.Error("request received changed")
+	}
+
+	//to the next hop of its route
+	if err := p.ForwardRequest(newRequest("5"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if h := sent.hops[1].String(); h != "192.0.2.30:5060/udp" {
+		t.Errorf("forwarded to %s", h)
+	}
+
+	if err := p.ForwardRequest(newRequest("0"), nil); err != ErrTooManyHops {
+		t.Errorf("forwarded with no hops left: %v", err)
+	}
+	if sent.len() != 2 {
+		t.Errorf("%d requests sent", sent.len())
+	}
+}
@@ -0,0 +1,177 @@
+package sip
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// Glare (RFC 3261 14.1, 14.2): both ends of a dialog send a re-INVITE at
+// once, typically to put the call on hold or resume it. Each answers the
+// other's with 491 Request Pending, and each sends its own again after a
+// random delay, the longer one for the owner of the Call-ID, the UAC of
+// the dialog, so that one re-INVITE gets through first. UPDATEs whose
+// offers cross (RFC 3311 5.2) are sent again the same way.
+
+const (
+	// GLARE_OWNER_MIN and GLARE_OWNER_MAX bound the delay before the UAC
+	// of the dialog sends its re-INVITE again after a 491.
+	GLARE_OWNER_MIN = 2100 * time.Millisecond
+	GLARE_OWNER_MAX = 4 * time.Second
+
+	// GLARE_MAX bounds the delay of the UAS of the dialog.
+	GLARE_MAX = 2 * time.Second
+
+	// GLARE_RETRY_AFTER bounds the Retry-After, in seconds, of the 500
+	// answering an INVITE received while another one is unanswered, or an
+	// offer received while another one is.
+	GLARE_RETRY_AFTER = 10
+)
+
+// getGlareDelay returns a random delay, in units of 10 ms, before sending
+// a re-INVITE or an UPDATE again after a 491.
+func getGlareDelay(owner bool) time.Duration {
+	min, max := time.Duration(0), GLARE_MAX
+	if owner {
+		min, max = GLARE_OWNER_MIN, GLARE_OWNER_MAX
+	}
+	units := int64((max - min) / (10 * time.Millisecond))
+	return min + time.Duration(rand.Int63n(units+1))*10*time.Millisecond
+}
+
+// isPending reports whether t has not got a final response yet.
+func isPending(t Transaction) bool {
+	if t == nil {
+		return false
+	}
+	switch t.GetState() {
+	case TRANSACTIONSTATE_CALLING, TRANSACTIONSTATE_TRYING, TRANSACTIONSTATE_PROCEEDING, TRANSACTIONSTATE_RESOLVING:
+		return true
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// admitInvite decides on an INVITE received within the dialog, answered by
+// st: it is refused with 491 while an INVITE sent is unanswered, and with
+// 500 while one received is (RFC 3261 14.2). Otherwise it becomes the INVITE
+// in progress and admitInvite returns 0.
+func (this *dialog) admitInvite(st ServerTransaction) int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	switch {
+	case isPending(this.clientInvite):
+		return REQUEST_PENDING
+	case isPending(this.serverInvite):
+		return SERVER_INTERNAL_ERROR
+	}
+	this.serverInvite = st
+	return 0
+}
+
+// rejectRequest answers a request refused by admitInvite, or by the
+// offer/answer rules, with a random Retry-After on a 500.
+func rejectRequest(st ServerTransaction, statusCode int) error {
+	resp := CreateResponse(st.GetRequest(), statusCode)
+	if statusCode == SERVER_INTERNAL_ERROR {
+		resp.GetHeader().Set("Retry-After", strconv.Itoa(rand.Intn(GLARE_RETRY_AFTER+1)))
+	}
+	return st.SendResponse(resp)
+}
+
+// setClientRequest makes ct the INVITE or the UPDATE in progress sent
+// within the dialog, keeping its body to send it again after a 491.
+func (this *dialog) setClientRequest(ct ClientTransaction) {
+	body := ct.GetRequest().GetBodyBytes()
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if ct.GetRequest().GetMethod() == UPDATE {
+		this.clientUpdate = ct
+		this.updateBody = body
+	} else {
+		this.clientInvite = ct
+		this.inviteBody = body
+	}
+}
+
+// retryRequest schedules the re-INVITE or the UPDATE of ct, answered with
+// 491, to be sent again after the glare delay, and reports whether it did:
+// not once the dialog is terminated, nor for the INVITE setting up the
+// dialog.
+func (this *dialog) retryRequest(ct ClientTransaction) bool {
+	if this.provider == nil || ct == this.first {
+		return false
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	var body []byte
+	switch {
+	case this.state == DIALOGSTATE_TERMINATED:
+		return false
+	case this.clientInvite == ct:
+		body = this.inviteBody
+	case this.clientUpdate == ct:
+		body = this.updateBody
+	default:
+		return false
+	}
+	req := ct.GetRequest()
+	stopTimer(this.glareTimer)
+	this.glareTimer = this.provider.GetClock().AfterFunc(getGlareDelay(!this.server), func() {
+		this.resendRequest(req, body)
+	})
+	return true
+}
+
+// resendRequest sends the re-INVITE or the UPDATE req again in a new
+// transaction, with the next CSeq number and a new branch. It waits
+// another glare delay while an INVITE is in progress either way for a
+// re-INVITE, or while an offer is outstanding for an UPDATE.
+func (this *dialog) resendRequest(req Request, body []byte) {
+	method := req.GetMethod()
+
+	this.mutex.Lock()
+	if this.state == DIALOGSTATE_TERMINATED {
+		this.mutex.Unlock()
+		return
+	}
+	wait := isPending(this.serverInvite) || (isPending(this.clientInvite) && this.clientInvite.GetRequest() != req)
+	if method == UPDATE {
+		wait = this.offers.state != OFFERSTATE_NONE
+	}
+	if wait {
+		this.glareTimer = this.provider.GetClock().AfterFunc(getGlareDelay(!this.server), func() {
+			this.resendRequest(req, body)
+		})
+		this.mutex.Unlock()
+		return
+	}
+	this.mutex.Unlock()
+
+	retry, err := this.CreateRequest(method)
+	if err != nil {
+		this.provider.getLogger(COMPONENT_DIALOG).Log(LOG_ERROR, "Resending request failed", "method", method, "error", err)
+		return
+	}
+	h := retry.GetHeader()
+	for key, values := range req.GetHeader() {
+		if _, ok := h[key]; !ok && key != "Content-Length" {
+			h[key] = append([]string(nil), values...)
+		}
+	}
+	if body != nil {
+		retry.SetBody(bytes.NewReader(body))
+	}
+
+	ct := this.provider.GetNewClientTransaction(retry)
+	if err := this.SendRequest(ct); err != nil {
+		this.provider.getLogger(COMPONENT_DIALOG).Log(LOG_ERROR, "Resending request failed", "method", method, "error", err)
+	}
+}
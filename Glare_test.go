@@ -0,0 +1,120 @@
+package sip
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGlare(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	var events []string
+	p.AddListener(&recordingListener{name: "listener", events: &events})
+
+	invite := NewRequest(INVITE, "sip:bob@biloxi.com", nil)
+	h := invite.GetHeader()
+	h.Set("Via", "SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bKgl1")
+	h.Set("From", "Alice <sip:alice@atlanta.com>;tag=1928301774")
+	h.Set("To", "Bob <sip:bob@biloxi.com>")
+	h.Set("Call-Id", "glare@pc33.atlanta.com")
+	h.Set("Cseq", "1 INVITE")
+	h.Set("Contact", "<sip:alice@pc33.atlanta.com>")
+	ct := p.GetNewClientTransaction(invite).(*clientTransaction)
+	ct.hops = []Hop{NewHop("192.0.2.10", 5060, UDP)}
+	ct.start()
+	ok200 := CreateResponse(invite, OK)
+	ok200.GetHeader().Set("To", "Bob <sip:bob@biloxi.com>;tag=a6c85cf")
+	ok200.GetHeader().Set("Contact", "<sip:bob@192.0.2.5>")
+	p.processResponse(ok200)
+	d := ct.GetDialog().(*dialog)
+
+	//the re-INVITEs cross
+	hold, err := d.CreateRequest(INVITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hold.GetHeader().Set("Content-Type", CONTENTTYPE_SDP)
+	hold.SetBody(strings.NewReader("v=0\r\na=sendonly\r\n"))
+	holdCt := p.GetNewClientTransaction(hold)
+	n := sent.len()
+	if err := d.SendRequest(holdCt); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, n+1)
+
+	remoteInvite := func(branch, cseq string) Request {
+		req := NewRequest(INVITE, "sip:alice@pc33.atlanta.com", nil)
+		h := req.GetHeader()
+		h.Set("Via", "SIP/2.0/UDP 192.0.2.5;branch="+branch)
+		h.Set("From", "Bob <sip:bob@biloxi.com>;tag=a6c85cf")
+		h.Set("To", "Alice <sip:alice@atlanta.com>;tag=1928301774")
+		h.Set("Call-Id", "glare@pc33.atlanta.com")
+		h.Set("Cseq", cseq+" INVITE")
+		h.Set("Contact", "<sip:bob@192.0.2.5>")
+		return req
+	}
+	events = nil
+	p.processMessage(remoteInvite("z9hG4bKgl2", "1"))
+	if resp, ok := sent.last().(Response); !ok || resp.GetStatusCode() != REQUEST_PENDING {
+		t.Fatalf("crossing re-INVITE answered %v", sent.last())
+	}
+	if len(events) != 0 {
+		t.Errorf("events = %v, want the re-INVITE kept from the listeners", events)
+	}
+	ack := remoteInvite("z9hG4bKgl2", "1")
+	ack.SetMethod(ACK)
+	ack.GetHeader().Set("Cseq", "1 ACK")
+	p.processMessage(ack)
+
+	//the 491 to ours is not delivered, ours is sent again after at most
+	//4s, with the next CSeq and the same body
+	p.processResponse(CreateResponse(hold, REQUEST_PENDING))
+	if len(events) != 0 {
+		t.Errorf("events = %v, want the 491 kept from the listeners", events)
+	}
+	n = sent.len()
+	clock.Advance(GLARE_OWNER_MIN - time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if sent.len() != n {
+		t.Fatalf("re-INVITE sent again before %v", GLARE_OWNER_MIN)
+	}
+	clock.Advance(GLARE_OWNER_MAX - GLARE_OWNER_MIN)
+	waitSent(t, sent, n+1)
+	retry, ok := sent.last().(Request)
+	if !ok || retry.GetMethod() != INVITE || retry.GetHeader().Get("Cseq") != "3 INVITE" || getBranch(retry) == getBranch(hold) {
+		t.Fatalf("retry = %v", sent.last())
+	}
+	if body, _ := ioutil.ReadAll(retry.GetBody()); string(body) != "v=0\r\na=sendonly\r\n" || retry.GetHeader().Get("Content-Type") != CONTENTTYPE_SDP {
+		t.Errorf("retry body = %q", body)
+	}
+
+	//once it is answered, a re-INVITE is admitted, and another one is
+	//refused with 500 while the first is unanswered
+	p.processResponse(CreateResponse(retry, OK))
+	events = nil
+	p.processMessage(remoteInvite("z9hG4bKgl3", "2"))
+	if len(events) != 1 || events[0] != "listener INVITE" {
+		t.Fatalf("events = %v, want the re-INVITE", events)
+	}
+	p.processMessage(remoteInvite("z9hG4bKgl4", "3"))
+	resp, ok := sent.last().(Response)
+	if !ok || resp.GetStatusCode() != SERVER_INTERNAL_ERROR || resp.GetHeader().Get("Retry-After") == "" {
+		t.Errorf("overlapping re-INVITE answered %v", sent.last())
+	}
+}
+
+func TestGlareDelay(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := getGlareDelay(true); d < GLARE_OWNER_MIN || d > GLARE_OWNER_MAX || d%(10*time.Millisecond) != 0 {
+			t.Fatalf("owner delay %v", d)
+		}
+		if d := getGlareDelay(false); d < 0 || d > GLARE_MAX || d%(10*time.Millisecond) != 0 {
+			t.Fatalf("delay %v", d)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package sip
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A HEPCapture ships the messages a provider sends and receives to a
+// Homer capture server, encapsulated in HEPv3 (EEP) packets over UDP: it
+// is a WireTap, added to the providers to capture and removed to stop.
+// Each packet carries the addresses and ports of the message, the time it
+// was captured, the capture agent ID and the authentication key, if any,
+// of the HEPCapture, and the message itself.
+
+const (
+	// HEP_PORT is the usual port of a HEP capture server.
+	HEP_PORT = 9060
+
+	// the chunks of a HEPv3 packet
+	hepIPFamily     = 1
+	hepIPProtocol   = 2
+	hepIPv4Source   = 3
+	hepIPv4Dest     = 4
+	hepIPv6Source   = 5
+	hepIPv6Dest     = 6
+	hepSourcePort   = 7
+	hepDestPort     = 8
+	hepSeconds      = 9
+	hepMicroseconds = 10
+	hepProtocolType = 11
+	hepCaptureID    = 12
+	hepAuthKey      = 14
+	hepPayload      = 15
+
+	hepProtocolSIP = 1
+)
+
+var ErrHEPTooLarge = errors.New("sip: message too large for a HEP packet")
+
+////////////////////Implementation////////////////////////
+
+type HEPCapture struct {
+	captureID uint32
+	authKey   string
+
+	mutex  sync.Mutex
+	conn   net.Conn
+	errors uint64
+}
+
+// NewHEPCapture returns a HEPCapture shipping packets to the capture server
+// at address, host:port, as agent captureID, authenticated by authKey if
+// not empty.
+func NewHEPCapture(address string, captureID uint32, authKey string) (*HEPCapture, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	this := &HEPCapture{}
+
+	this.captureID = captureID
+	this.authKey = authKey
+	this.conn = conn
+
+	return this, nil
+}
+
+func (this *HEPCapture) GetCaptureID() uint32 {
+	return this.captureID
+}
+
+// GetErrors returns the number of messages which could not be shipped.
+func (this *HEPCapture) GetErrors() uint64 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.errors
+}
+
+// Capture ships event to the capture server; a failure is only counted,
+// see GetErrors.
+func (this *HEPCapture) Capture(event WireEvent) {
+	packet, err := encodeHEP(event, this.captureID, this.authKey)
+	if err == nil {
+		_, err = this.conn.Write(packet)
+	}
+	if err != nil {
+		this.mutex.Lock()
+		this.errors++
+		this.mutex.Unlock()
+	}
+}
+
+// Close closes the socket to the capture server; the HEPCapture is to be
+// removed from its providers first.
+func (this *HEPCapture) Close() error {
+	return this.conn.Close()
+}
+
+// encodeHEP returns the HEPv3 packet of event.
+func encodeHEP(event WireEvent, captureID uint32, authKey string) ([]byte, error) {
+	source, dest := event.RemoteAddr, event.LocalAddr
+	if event.Direction == WIRE_OUT {
+		source, dest = event.LocalAddr, event.RemoteAddr
+	}
+	sourceIP, sourcePort := getHEPAddress(source)
+	destIP, destPort := getHEPAddress(dest)
+
+	b := make([]byte, 6, 128+len(event.Data))
+	copy(b, "HEP3")
+	if sourceIP.To4() != nil && destIP.To4() != nil {
+		b = appendHEPChunk(b, hepIPFamily, []byte{2})
+		b = appendHEPChunk(b, hepIPv4Source, sourceIP.To4())
+		b = appendHEPChunk(b, hepIPv4Dest, destIP.To4())
+	} else {
+		b = appendHEPChunk(b, hepIPFamily, []byte{10})
+		b = appendHEPChunk(b, hepIPv6Source, sourceIP.To16())
+		b = appendHEPChunk(b, hepIPv6Dest, destIP.To16())
+	}
+	protocol := byte(6) //TCP
+	if event.Network == UDP {
+		protocol = 17
+	}
+	b = appendHEPChunk(b, hepIPProtocol, []byte{protocol})
+	b = appendHEPChunk(b, hepSourcePort, binary.BigEndian.AppendUint16(nil, sourcePort))
+	b = appendHEPChunk(b, hepDestPort, binary.BigEndian.AppendUint16(nil, destPort))
+	b = appendHEPChunk(b, hepSeconds, binary.BigEndian.AppendUint32(nil, uint32(event.Time.Unix())))
+	b = appendHEPChunk(b, hepMicroseconds, binary.BigEndian.AppendUint32(nil, uint32(event.Time.Nanosecond()/1000)))
+	b = appendHEPChunk(b, hepProtocolType, []byte{hepProtocolSIP})
+	b = appendHEPChunk(b, hepCaptureID, binary.BigEndian.AppendUint32(nil, captureID))
+	if authKey != "" {
+		b = appendHEPChunk(b, hepAuthKey, []byte(authKey))
+	}
+	b = appendHEPChunk(b, hepPayload, event.Data)
+
+	if len(b) > 0xffff {
+		return nil, ErrHEPTooLarge
+	}
+	binary.BigEndian.PutUint16(b[4:], uint16(len(b)))
+	return b, nil
+}
+
+// appendHEPChunk appends a chunk of the generic vendor to b.
+func appendHEPChunk(b []byte, chunk uint16, value []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, 0)
+	b = binary.BigEndian.AppendUint16(b, chunk)
+	b = binary.BigEndian.AppendUint16(b, uint16(6+len(value)))
+	return append(b, value...)
+}
+
+// getHEPAddress returns the IP address and port of addr, the unspecified
+// IPv4 address if it has none.
+func getHEPAddress(addr net.Addr) (net.IP, uint16) {
+	if addr == nil {
+		return net.IPv4zero, 0
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return net.IPv4zero, 0
+	}
+	if i := strings.Index(host, "%"); i >= 0 {
+		host = host[:i]
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ip = net.IPv4zero
+	}
+	p, _ := strconv.Atoi(port)
+	return ip, uint16(p)
+}
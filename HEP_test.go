@@ -0,0 +1,104 @@
+package sip
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// decodeHEP returns the chunks of a HEPv3 packet by type.
+func decodeHEP(t *testing.T, b []byte) map[uint16][]byte {
+	if len(b) < 6 || string(b[:4]) != "HEP3" || int(binary.BigEndian.Uint16(b[4:])) != len(b) {
+		t.Fatalf("malformed HEP packet %q", b)
+	}
+	chunks := make(map[uint16][]byte)
+	for b = b[6:]; len(b) >= 6; {
+		chunk, length := binary.BigEndian.Uint16(b[2:]), int(binary.BigEndian.Uint16(b[4:]))
+		if length < 6 || length > len(b) {
+			t.Fatalf("malformed chunk %d", chunk)
+		}
+		chunks[chunk] = b[6:length]
+		b = b[length:]
+	}
+	return chunks
+}
+
+func TestHEPCapture(t *testing.T) {
+	homer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer homer.Close()
+	capture, err := NewHEPCapture(homer.LocalAddr().String(), 2001, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer capture.Close()
+
+	message := "OPTIONS sip:bob@192.0.2.2 SIP/2.0\r\nContent-Length: 0\r\n\r\n"
+	capture.Capture(WireEvent{
+		Direction:  WIRE_IN,
+		Network:    UDP,
+		LocalAddr:  &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5060},
+		RemoteAddr: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5070},
+		Time:       time.Date(2026, 1, 1, 0, 0, 0, 250000000, time.UTC),
+		Data:       []byte(message),
+	})
+
+	buffer := make([]byte, DATAGRAM_SIZE)
+	homer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := homer.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := decodeHEP(t, buffer[:n])
+	tvi := []struct {
+		chunk uint16
+		value []byte
+	}{
+		{hepIPFamily, []byte{2}},
+		{hepIPProtocol, []byte{17}},
+		{hepIPv4Source, []byte{192, 0, 2, 1}},
+		{hepIPv4Dest, []byte{192, 0, 2, 2}},
+		{hepSourcePort, []byte{0x13, 0xce}},
+		{hepDestPort, []byte{0x13, 0xc4}},
+		{hepSeconds, binary.BigEndian.AppendUint32(nil, 1767225600)},
+		{hepMicroseconds, binary.BigEndian.AppendUint32(nil, 250000)},
+		{hepProtocolType, []byte{1}},
+		{hepCaptureID, binary.BigEndian.AppendUint32(nil, 2001)},
+		{hepAuthKey, []byte("secret")},
+		{hepPayload, []byte(message)},
+	}
+	for _, tv := range tvi {
+		if string(chunks[tv.chunk]) != string(tv.value) {
+			t.Errorf("chunk %d = %v, want %v", tv.chunk, chunks[tv.chunk], tv.value)
+		}
+	}
+
+	//a message sent over IPv6 goes from the local address
+	packet, err := encodeHEP(WireEvent{
+		Direction:  WIRE_OUT,
+		Network:    TCP,
+		LocalAddr:  &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 5060},
+		RemoteAddr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5070, Zone: "eth0"},
+		Data:       []byte(message),
+	}, 2001, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks = decodeHEP(t, packet)
+	if chunks[hepIPFamily][0] != 10 || chunks[hepIPProtocol][0] != 6 || !net.IP(chunks[hepIPv6Source]).Equal(net.ParseIP("2001:db8::2")) || !net.IP(chunks[hepIPv6Dest]).Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("IPv6 chunks %v", chunks)
+	}
+	if _, ok := chunks[hepAuthKey]; ok {
+		t.Error("empty authentication key sent")
+	}
+
+	if _, err := encodeHEP(WireEvent{Data: make([]byte, 0xffff)}, 2001, ""); err != ErrHEPTooLarge {
+		t.Errorf("encoding a large message: %v", err)
+	}
+	if capture.GetErrors() != 0 {
+		t.Errorf("%d errors", capture.GetErrors())
+	}
+}
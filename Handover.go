@@ -0,0 +1,122 @@
+package sip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The listening sockets of a provider can be handed over to another
+// process, for a new version of a long-running service to take over
+// without refusing a connection: the old process passes the File of each
+// of its transports to the new one, e.g. through the ExtraFiles of an
+// exec.Cmd, which adopts them with SetListenerFile instead of binding its
+// transports again. The old process then calls StopAccepting, and drains
+// its dialogs over the connections it accepted before stopping. A UDP
+// socket is read by both processes until the old one stops, each reading
+// the datagrams the other does not, so the transactions and dialogs of one
+// are best kept apart from the other, e.g. by a proxy in front.
+
+var ErrNoListenerFile = errors.New("sip: transport has no listening socket to hand over")
+
+////////////////////Implementation////////////////////////
+
+// SetListenerFile makes Listen adopt f, a listening socket handed over by
+// another process, instead of binding the address and port of the
+// transport, which become those of f. Listen closes f, keeping a
+// duplicate of its own. It must be set before Listen.
+func (this *transport) SetListenerFile(f *os.File) {
+	this.file = f
+}
+
+// File returns a duplicate of the listening socket of the transport, to
+// hand over to another process; the caller closes it. The transport keeps
+// listening until its provider stops accepting or stops.
+func (this *transport) File() (*os.File, error) {
+	var socket interface{}
+	if this.pconn != nil {
+		socket = this.pconn.PacketConn
+	} else if this.socket != nil {
+		socket = this.socket
+	}
+	if f, ok := socket.(interface{ File() (*os.File, error) }); ok {
+		return f.File()
+	}
+	return nil, ErrNoListenerFile
+}
+
+// listen binds a stream listener to address, or adopts the listener file.
+func (this *transport) listen(lc net.ListenConfig, address string) (lner net.Listener, err error) {
+	adopted := this.file != nil
+	if adopted {
+		lner, err = net.FileListener(this.file)
+		this.closeFile()
+	} else {
+		lner, err = lc.Listen(context.Background(), "tcp", address)
+	}
+	if err == nil {
+		this.socket = lner
+		this.setAddress(lner.Addr(), adopted)
+	}
+	return lner, err
+}
+
+// listenPacket binds a UDP socket to address, or adopts the listener file.
+func (this *transport) listenPacket(lc net.ListenConfig, address string) (pc net.PacketConn, err error) {
+	adopted := this.file != nil
+	if adopted {
+		pc, err = net.FilePacketConn(this.file)
+		this.closeFile()
+	} else {
+		pc, err = lc.ListenPacket(context.Background(), "udp", address)
+	}
+	if err == nil {
+		this.setAddress(pc.LocalAddr(), adopted)
+	}
+	return pc, err
+}
+
+func (this *transport) closeFile() {
+	this.file.Close()
+	this.file = nil
+}
+
+// setAddress sets the port of the transport, if 0, to the one it listens
+// on, addr, and for an adopted socket its address as well.
+func (this *transport) setAddress(addr net.Addr, adopted bool) {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return
+	}
+	if this.port == 0 || adopted {
+		this.port, _ = strconv.Atoi(port)
+	}
+	if adopted {
+		this.address = host
+	}
+}
+
+// stopAccepting makes the provider stop accepting connections on the
+// transport.
+func (this *transport) stopAccepting() {
+	this.stop.Do(func() {
+		close(this.quit)
+		this.SetDeadline(time.Now())
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// StopAccepting closes the stream listeners of the provider, handed over
+// to another process or not; the connections accepted, and the UDP
+// sockets, are still served until Stop.
+func (this *provider) StopAccepting() {
+	for _, t := range this.transports {
+		if tr, ok := t.(*transport); ok {
+			tr.stopAccepting()
+		}
+	}
+}
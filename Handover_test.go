@@ -0,0 +1,102 @@
+package sip
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestListenerHandover(t *testing.T) {
+	for _, network := range []string{TCP, UDP} {
+		old := newTransport(network, "127.0.0.1", 0, nil)
+		if err := old.Listen(); err != nil {
+			t.Fatal(err)
+		}
+		f, err := old.File()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		//the new transport listens on the socket of the old one
+		next := newTransport(network, "", 0, nil)
+		next.SetListenerFile(f)
+		if err := next.Listen(); err != nil {
+			t.Fatal(err)
+		}
+		if next.GetAddress() != "127.0.0.1" || next.GetPort() != old.GetPort() || next.GetPort() == 0 {
+			t.Errorf("%s: listening on %s:%d, want 127.0.0.1:%d", network, next.GetAddress(), next.GetPort(), old.GetPort())
+		}
+		if network == TCP {
+			old.lner.Close()
+		} else {
+			old.pconn.Close()
+		}
+
+		address := net.JoinHostPort("127.0.0.1", strconv.Itoa(next.GetPort()))
+		client, err := net.Dial(network, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Write([]byte("\r\n"))
+		conn, err := next.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if n, err := conn.Read(make([]byte, 4)); n != 2 {
+			t.Errorf("%s: read %d bytes: %v", network, n, err)
+		}
+		client.Close()
+		conn.Close()
+		if next.lner != nil {
+			next.lner.Close()
+		}
+	}
+
+	if _, err := newTransport(TCP, "127.0.0.1", 0, nil).File(); err != ErrNoListenerFile {
+		t.Errorf("File before Listen: %v", err)
+	}
+}
+
+func TestStopAccepting(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	tcp := newTransport(TCP, "127.0.0.1", 0, nil)
+	p.AddTransport(tcp)
+	if err := tcp.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	p.spawn(resourceAccept, func() { p.ServeAccept(tcp) })
+	defer func() {
+		close(p.quit)
+		p.waitGroup.Wait()
+	}()
+
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(tcp.GetPort()))
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	//the listener closes, the connection accepted is still served
+	p.StopAccepting()
+	p.StopAccepting()
+	for i := 0; ; i++ {
+		c, err := net.Dial("tcp", address)
+		if err != nil {
+			break
+		}
+		c.Close()
+		if i == 100 {
+			t.Fatal("still accepting")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	for i := 0; p.connections.get(TCP, conn.LocalAddr().String()) == nil; i++ {
+		if i == 100 {
+			t.Fatal("accepted connection not served")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
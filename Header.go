@@ -52,6 +52,123 @@ func (h Header) Del(key string) {
 	textproto.MIMEHeader(h).Del(key)
 }
 
+// Values returns the values associated with key, one element per value:
+// the comma-separated lists of the header fields which allow several values
+// (RFC 3261 7.3.1) are split.
+func (h Header) Values(key string) []string {
+	key = CanonicalHeaderKey(key)
+	if !multiValueHeaders[key] {
+		return h[key]
+	}
+	var values []string
+	for _, v := range h[key] {
+		values = append(values, splitHeaderValues(v)...)
+	}
+	return values
+}
+
+// Remove deletes the values associated with key and returns them, split
+// as by Values.
+func (h Header) Remove(key string) []string {
+	values := h.Values(key)
+	h.Del(key)
+	return values
+}
+
+// ReplaceAll replaces the values associated with key by values, keeping
+// their order. An empty list removes key.
+func (h Header) ReplaceAll(key string, values []string) {
+	key = CanonicalHeaderKey(key)
+	if len(values) == 0 {
+		delete(h, key)
+		return
+	}
+	h[key] = append([]string(nil), values...)
+}
+
+// AddBefore inserts value before the value at position i of key, as
+// numbered by Values. A position past the end appends value.
+func (h Header) AddBefore(key string, i int, value string) {
+	values := h.Values(key)
+	if i < 0 {
+		i = 0
+	}
+	if i > len(values) {
+		i = len(values)
+	}
+	values = append(values[:i], append([]string{value}, values[i:]...)...)
+	h.ReplaceAll(key, values)
+}
+
+// AddAfter inserts value after the value at position i of key, as numbered
+// by Values.
+func (h Header) AddAfter(key string, i int, value string) {
+	h.AddBefore(key, i+1, value)
+}
+
+// Header fields whose value is a comma-separated list, which may equally
+// be sent as several header fields.
+var multiValueHeaders = map[string]bool{
+	"Accept":              true,
+	"Accept-Contact":      true,
+	"Accept-Encoding":     true,
+	"Accept-Language":     true,
+	"Alert-Info":          true,
+	"Allow":               true,
+	"Allow-Events":        true,
+	"Call-Info":           true,
+	"Contact":             true,
+	"Content-Encoding":    true,
+	"Content-Language":    true,
+	"Error-Info":          true,
+	"In-Reply-To":         true,
+	"Path":                true,
+	"Proxy-Require":       true,
+	"Record-Route":        true,
+	"Reject-Contact":      true,
+	"Request-Disposition": true,
+	"Require":             true,
+	"Resource-Priority":   true,
+	"Route":               true,
+	"Service-Route":       true,
+	"Supported":           true,
+	"Unsupported":         true,
+	"Via":                 true,
+	"Warning":             true,
+}
+
+// splitHeaderValues splits a header field value on the commas which are
+// outside of quoted strings and angle brackets.
+func splitHeaderValues(v string) []string {
+	var values []string
+	quoted, escaped, angle := false, false, 0
+	start := 0
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '<':
+			angle++
+		case c == '>' && angle > 0:
+			angle--
+		case c == ',' && angle == 0:
+			if s := strings.TrimSpace(v[start:i]); s != "" {
+				values = append(values, s)
+			}
+			start = i + 1
+		}
+	}
+	if s := strings.TrimSpace(v[start:]); s != "" {
+		values = append(values, s)
+	}
+	return values
+}
+
 // Write writes a header in wire format.
 func (h Header) Write(w io.Writer) error {
 	return h.WriteSubset(w, nil)
@@ -149,26 +266,138 @@ func (h Header) sortedKeyValues(exclude map[string]bool) (kvs []keyValues, hs *h
 // WriteSubset writes a header in wire format.
 // If exclude is not nil, keys where exclude[key] == true are not written.
 func (h Header) WriteSubset(w io.Writer, exclude map[string]bool) error {
+	return h.writeSubset(w, exclude, false)
+}
+
+// writeSubset is like WriteSubset, but emits the compact form of the
+// header names when compact is true.
+func (h Header) writeSubset(w io.Writer, exclude map[string]bool, compact bool) error {
+	return h.writeOrdered(w, nil, exclude, compact)
+}
+
+// writeOrdered is like writeSubset, but writes the header fields named in
+// names first, one per name in that order and with the names spelled as
+// given, as received by ReadMessage. The other headers follow in name
+// order.
+func (h Header) writeOrdered(w io.Writer, names []string, exclude map[string]bool, compact bool) error {
 	ws, ok := w.(writeStringer)
 	if !ok {
 		ws = stringWriter{w}
 	}
-	kvs, sorter := h.sortedKeyValues(exclude)
-	for _, kv := range kvs {
-		for _, v := range kv.values {
+	writeKey := func(name string, values []string) error {
+		if compact {
+			name = compactHeaderKey(CanonicalHeaderKey(name))
+		}
+		for _, v := range values {
 			v = headerNewlineToSpace.Replace(v)
 			v = textproto.TrimString(v)
-			for _, s := range []string{kv.key, ": ", v, "\r\n"} {
+			for _, s := range []string{name, ": ", v, "\r\n"} {
 				if _, err := ws.WriteString(s); err != nil {
 					return err
 				}
 			}
 		}
+		return nil
+	}
+
+	if len(names) > 0 {
+		//a value per field listed, and the values past the fields of a name
+		//after its last one
+		last := make(map[string]int, len(names))
+		for i, name := range names {
+			last[CanonicalHeaderKey(name)] = i
+		}
+		next := make(map[string]int, len(last))
+		for i, name := range names {
+			key := CanonicalHeaderKey(name)
+			values := h[key]
+			n := next[key]
+			if exclude[key] || n >= len(values) {
+				continue
+			}
+			end := n + 1
+			if last[key] == i {
+				end = len(values)
+			}
+			next[key] = end
+			if err := writeKey(name, values[n:end]); err != nil {
+				return err
+			}
+		}
+
+		written := make(map[string]bool, len(exclude)+len(last))
+		for k, v := range exclude {
+			written[k] = v
+		}
+		for key := range last {
+			written[key] = true
+		}
+		exclude = written
+	}
+
+	kvs, sorter := h.sortedKeyValues(exclude)
+	defer headerSorterPool.Put(sorter)
+	for _, kv := range kvs {
+		if err := writeKey(kv.key, kv.values); err != nil {
+			return err
+		}
 	}
-	headerSorterPool.Put(sorter)
 	return nil
 }
 
+// Compact forms of header names, see RFC 3261 7.3.3 and the
+// extensions registering one.
+var compactHeaderNames = map[string]string{
+	"Accept-Contact":      "a",
+	"Allow-Events":        "u",
+	"Call-Id":             "i",
+	"Contact":             "m",
+	"Content-Encoding":    "e",
+	"Content-Length":      "l",
+	"Content-Type":        "c",
+	"Event":               "o",
+	"From":                "f",
+	"Refer-To":            "r",
+	"Referred-By":         "b",
+	"Reject-Contact":      "j",
+	"Request-Disposition": "d",
+	"Session-Expires":     "x",
+	"Subject":             "s",
+	"Supported":           "k",
+	"To":                  "t",
+	"Via":                 "v",
+}
+
+// compactHeaderKey returns the compact form of the canonical header key,
+// or key itself if it has none.
+func compactHeaderKey(key string) string {
+	if c, ok := compactHeaderNames[key]; ok {
+		return c
+	}
+	return key
+}
+
+// longHeaderNames maps the compact forms of header names back to their
+// canonical keys.
+var longHeaderNames = func() map[string]string {
+	m := make(map[string]string, len(compactHeaderNames))
+	for long, c := range compactHeaderNames {
+		m[c] = long
+	}
+	return m
+}()
+
+// longHeaderKey returns the canonical key of the header name, a compact
+// form being expanded to the long one.
+func longHeaderKey(name string) string {
+	if len(name) == 1 {
+		if long, ok := longHeaderNames[strings.ToLower(name)]; ok {
+			return long
+		}
+	}
+	return CanonicalHeaderKey(name)
+}
+
 // CanonicalHeaderKey returns the canonical format of the
 // header key s.  The canonicalization converts the first
 // letter and any letter following a hyphen to upper case;
@@ -0,0 +1,42 @@
+package sip
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHeaderSurgery(t *testing.T) {
+	h := make(Header)
+	h.Add("Route", "<sip:p1.example.com;lr>, \"Proxy, Two\" <sip:p2.example.com;lr>")
+	h.Add("Route", "<sip:p3.example.com;lr;x=\"a,b\">")
+	h.Set("Subject", "lunch, maybe")
+
+	want := []string{"<sip:p1.example.com;lr>", "\"Proxy, Two\" <sip:p2.example.com;lr>", "<sip:p3.example.com;lr;x=\"a,b\">"}
+	if got := h.Values("route"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Values = %q, want %q", got, want)
+	}
+	if got := h.Values("Subject"); !reflect.DeepEqual(got, []string{"lunch, maybe"}) {
+		t.Fatalf("single-valued header split: %q", got)
+	}
+
+	h.AddBefore("Route", 1, "<sip:new.example.com;lr>")
+	h.AddAfter("Route", 3, "<sip:last.example.com;lr>")
+	want = []string{"<sip:p1.example.com;lr>", "<sip:new.example.com;lr>", "\"Proxy, Two\" <sip:p2.example.com;lr>",
+		"<sip:p3.example.com;lr;x=\"a,b\">", "<sip:last.example.com;lr>"}
+	if got := h["Route"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Route = %q, want %q", got, want)
+	}
+
+	if got := h.Remove("Route"); len(got) != 5 || h.Get("Route") != "" {
+		t.Fatalf("Remove = %q, left %q", got, h["Route"])
+	}
+
+	h.ReplaceAll("Supported", []string{"100rel", "timer"})
+	if got := h["Supported"]; !reflect.DeepEqual(got, []string{"100rel", "timer"}) {
+		t.Fatalf("Supported = %q", got)
+	}
+	h.ReplaceAll("Supported", nil)
+	if _, ok := h["Supported"]; ok {
+		t.Fatal("ReplaceAll with no values kept the header")
+	}
+}
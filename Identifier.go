@@ -0,0 +1,37 @@
+package sip
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// GenerateCallId returns a new Call-ID (RFC 3261 8.1.1.4): 128 random bits,
+// followed by "@host" unless host is empty.
+func GenerateCallId(host string) string {
+	if host == "" {
+		return randomHex(16)
+	}
+	return randomHex(16) + "@" + host
+}
+
+// GenerateBranchId returns a new branch for a Via: the magic cookie and 64
+// random bits, unique across space and time (RFC 3261 8.1.1.7).
+func GenerateBranchId() string {
+	return BRANCH_MAGIC_COOKIE + randomHex(8)
+}
+
+// GenerateTag returns a new From or To tag with 64 random bits; RFC 3261
+// 19.3 asks for at least 32.
+func GenerateTag() string {
+	return randomHex(8)
+}
+
+// randomHex returns n bytes from the system's secure random number
+// generator, in hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("sip: no randomness: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,35 @@
+package sip
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIdentifiers(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		for _, id := range []string{GenerateCallId(""), GenerateBranchId(), GenerateTag()} {
+			if seen[id] {
+				t.Fatalf("%s generated twice", id)
+			}
+			seen[id] = true
+		}
+	}
+
+	if branch := GenerateBranchId(); !strings.HasPrefix(branch, BRANCH_MAGIC_COOKIE) || len(branch) != len(BRANCH_MAGIC_COOKIE)+16 {
+		t.Errorf("branch = %s", branch)
+	}
+	if tag := GenerateTag(); len(tag) != 16 {
+		t.Errorf("tag = %s", tag)
+	}
+
+	p := newProvider(TraceOff(), RealClock)
+	p.AddTransport(newTransport(UDP, "0.0.0.0", 5060, nil))
+	if id := p.GetNewCallId(); len(id) < 32 || strings.HasSuffix(id, "@0.0.0.0") {
+		t.Errorf("Call-ID = %s on a wildcard address", id)
+	}
+	p.AddTransport(newTransport(TCP, "192.0.2.1", 5060, nil))
+	if id := p.GetNewCallId(); !strings.HasSuffix(id, "@192.0.2.1") || len(id) != 32+len("@192.0.2.1") {
+		t.Errorf("Call-ID = %s", id)
+	}
+}
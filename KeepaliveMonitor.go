@@ -0,0 +1,456 @@
+package sip
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// KeepaliveMonitor periodically probes the configured peers, SIP URIs, with
+// OPTIONS sent from the listening point of the provider. It must be added
+// to the Provider as a Listener so it can see the responses and timeouts of
+// its probes. The Blacklist and the PeerHealth it is given know a peer by
+// its destination, "host:port".
+type KeepaliveMonitor interface {
+	Listener
+
+	AddPeer(peer string)
+	RemovePeer(peer string)
+	GetPeers() []string
+	GetPeerState(peer string) PeerState
+	GetPeerLatency(peer string) time.Duration
+	GetPeerFailures(peer string) int
+
+	SetInterval(interval time.Duration)
+	SetTimeout(timeout time.Duration)
+	SetMaxFailures(maxFailures int)
+	SetDegradedLatency(latency time.Duration)
+	SetBlacklist(blacklist Blacklist, duration time.Duration)
+	SetStateHandler(handler PeerStateHandler)
+	SetPeerHealth(health PeerHealth)
+	SetClock(clock Clock)
+
+	Start()
+	Stop()
+}
+
+////////////////////Implementation////////////////////////
+
+const (
+	KEEPALIVE_INTERVAL         = 30 * time.Second
+	KEEPALIVE_TIMEOUT          = 5 * time.Second
+	KEEPALIVE_MAX_FAILURES     = 3
+	KEEPALIVE_DEGRADED_LATENCY = 2 * time.Second
+)
+
+type keepalivePeer struct {
+	state    PeerState
+	latency  time.Duration
+	failures int
+	cseq     int
+}
+
+type keepaliveProbe struct {
+	peer string
+	sent time.Time
+}
+
+type keepaliveMonitor struct {
+	mutex sync.Mutex
+
+	provider Provider
+	from     string
+
+	peers   map[string]*keepalivePeer
+	pending map[ClientTransaction]*keepaliveProbe
+
+	interval          time.Duration
+	timeout           time.Duration
+	maxFailures       int
+	degradedLatency   time.Duration
+	blacklist         Blacklist
+	blacklistDuration time.Duration
+	handler           PeerStateHandler
+	health            PeerHealth
+	clock             Clock
+
+	quit      chan bool //nil unless started, guarded by mutex
+	waitGroup *sync.WaitGroup
+}
+
+// from is the URI used in From of the probes, e.g. "sip:monitor@example.com".
+func NewKeepaliveMonitor(provider Provider, from string) KeepaliveMonitor {
+	this := &keepaliveMonitor{}
+
+	this.provider = provider
+	this.from = from
+
+	this.peers = make(map[string]*keepalivePeer)
+	this.pending = make(map[ClientTransaction]*keepaliveProbe)
+
+	this.interval = KEEPALIVE_INTERVAL
+	this.timeout = KEEPALIVE_TIMEOUT
+	this.maxFailures = KEEPALIVE_MAX_FAILURES
+	this.degradedLatency = KEEPALIVE_DEGRADED_LATENCY
+
+	this.clock = provider.GetClock()
+	this.waitGroup = &sync.WaitGroup{}
+
+	return this
+}
+
+func (this *keepaliveMonitor) AddPeer(peer string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if _, ok := this.peers[peer]; !ok {
+		this.peers[peer] = &keepalivePeer{state: PEERSTATE_UP}
+	}
+}
+
+func (this *keepaliveMonitor) RemovePeer(peer string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	delete(this.peers, peer)
+	for ct, probe := range this.pending {
+		if probe.peer == peer {
+			delete(this.pending, ct)
+		}
+	}
+}
+
+func (this *keepaliveMonitor) GetPeers() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	peers := make([]string, 0, len(this.peers))
+	for peer := range this.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+func (this *keepaliveMonitor) GetPeerState(peer string) PeerState {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if p, ok := this.peers[peer]; ok {
+		return p.state
+	}
+	return PEERSTATE_DOWN
+}
+
+func (this *keepaliveMonitor) GetPeerLatency(peer string) time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if p, ok := this.peers[peer]; ok {
+		return p.latency
+	}
+	return 0
+}
+
+func (this *keepaliveMonitor) GetPeerFailures(peer string) int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if p, ok := this.peers[peer]; ok {
+		return p.failures
+	}
+	return 0
+}
+
+// SetInterval sets the interval between the probes; it applies once the
+// monitor is started again.
+func (this *keepaliveMonitor) SetInterval(interval time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.interval = interval
+}
+
+func (this *keepaliveMonitor) SetTimeout(timeout time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.timeout = timeout
+}
+
+func (this *keepaliveMonitor) SetMaxFailures(maxFailures int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.maxFailures = maxFailures
+}
+
+func (this *keepaliveMonitor) SetDegradedLatency(latency time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.degradedLatency = latency
+}
+
+// peers that go down are added to blacklist for duration, and removed
+// again as soon as they answer a probe.
+func (this *keepaliveMonitor) SetBlacklist(blacklist Blacklist, duration time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.blacklist = blacklist
+	this.blacklistDuration = duration
+}
+
+func (this *keepaliveMonitor) SetStateHandler(handler PeerStateHandler) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.handler = handler
+}
+
+// the outcome of every probe is also reported to health, so routing
+// layers see keep-alive failures next to the transport and transaction ones.
+func (this *keepaliveMonitor) SetPeerHealth(health PeerHealth) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.health = health
+}
+
+// SetClock sets the clock of the monitor; it applies once the monitor is
+// started again.
+func (this *keepaliveMonitor) SetClock(clock Clock) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.clock = clock
+}
+
+// Start starts probing the peers, unless the monitor runs already.
+func (this *keepaliveMonitor) Start() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.quit != nil {
+		return
+	}
+	this.quit = make(chan bool)
+	this.waitGroup.Add(1)
+	go this.run(this.quit)
+}
+
+// Stop stops probing the peers and waits for the monitor to return; it
+// does nothing unless the monitor runs, so it may be called again.
+func (this *keepaliveMonitor) Stop() {
+	this.mutex.Lock()
+	quit := this.quit
+	this.quit = nil
+	this.mutex.Unlock()
+
+	if quit == nil {
+		return
+	}
+	close(quit)
+	this.waitGroup.Wait()
+}
+
+func (this *keepaliveMonitor) run(quit chan bool) {
+	defer this.waitGroup.Done()
+
+	this.mutex.Lock()
+	ticker := this.clock.NewTicker(this.interval)
+	this.mutex.Unlock()
+	defer ticker.Stop()
+
+	this.probeAll()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C():
+			this.expire()
+			this.probeAll()
+		}
+	}
+}
+
+func (this *keepaliveMonitor) probeAll() {
+	for _, peer := range this.GetPeers() {
+		this.probe(peer)
+	}
+}
+
+func (this *keepaliveMonitor) probe(peer string) {
+	this.mutex.Lock()
+	p, ok := this.peers[peer]
+	if !ok {
+		this.mutex.Unlock()
+		return
+	}
+	p.cseq++
+	req, err := this.createOptions(peer, p.cseq)
+	this.mutex.Unlock()
+	if err != nil {
+		this.provider.GetLogger().With(LOG_COMPONENT, COMPONENT_TRANSPORT).Log(LOG_ERROR, "Creating probe failed", "peer", peer, "error", err)
+		return
+	}
+
+	ct := this.provider.GetNewClientTransaction(req)
+
+	this.mutex.Lock()
+	this.pending[ct] = &keepaliveProbe{peer: peer, sent: this.clock.Now()}
+	this.mutex.Unlock()
+
+	if err := ct.SendRequest(); err != nil {
+		this.fail(ct)
+	}
+}
+
+// createOptions returns the probe of peer, whose Via is the listening
+// point of the provider, with a branch of its BranchStrategy.
+func (this *keepaliveMonitor) createOptions(peer string, cseq int) (Request, error) {
+	req := NewRequest(OPTIONS, peer, nil)
+
+	req.GetHeader().Set("Max-Forwards", "70")
+	req.GetHeader().Set("From", "<"+this.from+">;tag="+randomHex(4))
+	req.GetHeader().Set("To", "<"+peer+">")
+	req.GetHeader().Set("Call-ID", GenerateCallId(""))
+	req.GetHeader().Set("CSeq", strconv.Itoa(cseq)+" "+OPTIONS)
+
+	via, err := getProviderVia(this.provider, req)
+	if err != nil {
+		return nil, err
+	}
+	req.GetHeader().Set("Via", via+";branch="+this.provider.GetBranchStrategy().GetBranch(req))
+
+	return req, nil
+}
+
+// expire fails the probes which have been waiting longer than the timeout,
+// in case the transaction layer never reports back.
+func (this *keepaliveMonitor) expire() {
+	this.mutex.Lock()
+	expired := make([]ClientTransaction, 0)
+	for ct, probe := range this.pending {
+		if this.clock.Since(probe.sent) > this.timeout {
+			expired = append(expired, ct)
+		}
+	}
+	this.mutex.Unlock()
+
+	for _, ct := range expired {
+		this.fail(ct)
+	}
+}
+
+func (this *keepaliveMonitor) succeed(ct ClientTransaction) {
+	this.mutex.Lock()
+	probe, ok := this.pending[ct]
+	if !ok {
+		this.mutex.Unlock()
+		return
+	}
+	delete(this.pending, ct)
+	p, ok := this.peers[probe.peer]
+	if !ok {
+		this.mutex.Unlock()
+		return
+	}
+	p.failures = 0
+	p.latency = this.clock.Since(probe.sent)
+	from := p.state
+	if p.latency > this.degradedLatency {
+		p.state = PEERSTATE_DEGRADED
+	} else {
+		p.state = PEERSTATE_UP
+	}
+	to, latency := p.state, p.latency
+	health := this.health
+	this.mutex.Unlock()
+
+	if health != nil {
+		health.ReportSuccess(getPeerDestination(probe.peer), latency)
+	}
+	this.transit(probe.peer, from, to)
+}
+
+func (this *keepaliveMonitor) fail(ct ClientTransaction) {
+	this.mutex.Lock()
+	probe, ok := this.pending[ct]
+	if !ok {
+		this.mutex.Unlock()
+		return
+	}
+	delete(this.pending, ct)
+	p, ok := this.peers[probe.peer]
+	if !ok {
+		this.mutex.Unlock()
+		return
+	}
+	p.failures++
+	from := p.state
+	if p.failures >= this.maxFailures {
+		p.state = PEERSTATE_DOWN
+	} else {
+		p.state = PEERSTATE_DEGRADED
+	}
+	to := p.state
+	health := this.health
+	this.mutex.Unlock()
+
+	if health != nil {
+		health.ReportFailure(getPeerDestination(probe.peer), PEERFAILURE_KEEPALIVE)
+	}
+	this.transit(probe.peer, from, to)
+}
+
+func (this *keepaliveMonitor) transit(peer string, from, to PeerState) {
+	this.mutex.Lock()
+	blacklist, duration, handler := this.blacklist, this.blacklistDuration, this.handler
+	this.mutex.Unlock()
+
+	if blacklist != nil {
+		if to == PEERSTATE_DOWN {
+			blacklist.Add(getPeerDestination(peer), duration)
+		} else if from == PEERSTATE_DOWN {
+			blacklist.Remove(getPeerDestination(peer))
+		}
+	}
+
+	if from != to && handler != nil {
+		handler(peer, from, to)
+	}
+}
+
+func (this *keepaliveMonitor) ProcessRequest(requestEvent RequestEvent) {
+}
+
+func (this *keepaliveMonitor) ProcessResponse(responseEvent ResponseEvent) {
+	ct := responseEvent.GetClientTransaction()
+	switch statusCode := responseEvent.GetResponse().GetStatusCode(); {
+	case statusCode < OK:
+		//provisional responses don't finish the probe
+	case statusCode == REQUEST_TIMEOUT, statusCode == SERVICE_UNAVAILABLE:
+		this.fail(ct)
+	default:
+		this.succeed(ct)
+	}
+}
+
+func (this *keepaliveMonitor) ProcessTimeout(timeoutEvent TimeoutEvent) {
+	if ct, ok := timeoutEvent.GetTransaction().(ClientTransaction); ok {
+		this.fail(ct)
+	}
+}
+
+// getPeerDestination returns the destination of peer, "host:port", the
+// next hop of the requests to it.
+func getPeerDestination(peer string) string {
+	h, err := GetNextHop(NewRequest(OPTIONS, peer, nil))
+	if err != nil {
+		return peer
+	}
+	return getHopDestination(h)
+}
@@ -0,0 +1,115 @@
+package sip
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// waitProbe waits for the OPTIONS of sequence number cseq to be sent, the
+// retransmissions of the previous ones being sent too.
+func waitProbe(t *testing.T, sent *sentMessages, cseq int) Request {
+	for i := 0; i < 100; i++ {
+		for j := sent.len() - 1; j >= 0; j-- {
+			if req, ok := sent.get(j).(Request); ok && req.GetMethod() == OPTIONS && req.GetHeader().Get("CSeq") == strconv.Itoa(cseq)+" "+OPTIONS {
+				return req
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("probe %d not sent", cseq)
+	return nil
+}
+
+func TestKeepaliveMonitor(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	p.AddTransport(newTransport(UDP, "192.0.2.1", 5070, nil))
+	p.SetBranchStrategy(StatelessBranch)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.20", 5060, UDP)}, nil
+	}))
+
+	bl := NewBlacklist()
+	bl.SetClock(clock)
+	states := make(chan PeerState, 4)
+	m := NewKeepaliveMonitor(p, "sip:monitor@example.com")
+	m.SetInterval(time.Minute)
+	m.SetMaxFailures(2)
+	m.SetBlacklist(bl, time.Minute)
+	m.SetStateHandler(func(peer string, from, to PeerState) {
+		states <- to
+	})
+	m.AddPeer("sip:192.0.2.20")
+	p.AddListener(m)
+	m.Start()
+	defer m.Stop()
+
+	expect := func(state PeerState) {
+		select {
+		case to := <-states:
+			if to != state {
+				t.Fatalf("peer went %v, want %v", to, state)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("peer did not go %v", state)
+		}
+	}
+
+	//the probes are sent from the listening point of the provider
+	req := waitProbe(t, sent, 1)
+	unsent := NewRequest(OPTIONS, "sip:192.0.2.20", nil)
+	for _, name := range []string{"From", "To", "Call-Id", "Cseq"} {
+		unsent.GetHeader().Set(name, req.GetHeader().Get(name))
+	}
+	if via := req.GetHeader().Get("Via"); via != "SIP/2.0/UDP 192.0.2.1:5070;branch="+StatelessBranch.GetBranch(unsent) {
+		t.Errorf("probe sent with Via %q", via)
+	}
+	p.processResponse(withToTag(CreateResponse(req, OK), "k1"))
+
+	//a probe timing out degrades the peer
+	clock.Advance(time.Minute)
+	waitProbe(t, sent, 2)
+	clock.Advance(64 * TIMER_T1)
+	expect(PEERSTATE_DEGRADED)
+
+	//and a second one takes it down, into the blacklist
+	clock.Advance(time.Minute - 64*TIMER_T1)
+	waitProbe(t, sent, 3)
+	clock.Advance(64 * TIMER_T1)
+	expect(PEERSTATE_DOWN)
+	if !bl.IsBlacklisted("192.0.2.20:5060") {
+		t.Errorf("blacklisted %q", bl.GetDestinations())
+	}
+
+	//until it answers again
+	clock.Advance(time.Minute - 64*TIMER_T1)
+	req = waitProbe(t, sent, 4)
+	p.processResponse(withToTag(CreateResponse(req, OK), "k4"))
+	expect(PEERSTATE_UP)
+	if bl.IsBlacklisted("192.0.2.20:5060") {
+		t.Error("peer still blacklisted once up")
+	}
+}
+
+func TestKeepaliveMonitorStop(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	m := NewKeepaliveMonitor(p, "sip:monitor@example.com")
+
+	//stopping a monitor never started does nothing
+	m.Stop()
+
+	//and stopping it twice neither
+	m.Start()
+	m.Stop()
+	m.Stop()
+
+	//it may be started again
+	m.Start()
+	m.Stop()
+}
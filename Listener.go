@@ -1,7 +1,213 @@
 package sip
 
+import (
+	"fmt"
+	"sync"
+)
+
+////////////////////Interface//////////////////////////////
+
 type Listener interface {
 	ProcessRequest(requestEvent RequestEvent)
 	ProcessResponse(responseEvent ResponseEvent)
 	ProcessTimeout(timeoutEvent TimeoutEvent)
 }
+
+// A DialogListener is also told about the dialogs which terminate. A
+// Listener need not implement it.
+type DialogListener interface {
+	Listener
+
+	ProcessDialogTerminated(dialogTerminatedEvent DialogTerminatedEvent)
+}
+
+// EventType selects the events a Listener is given, see ListenerFilter.
+type EventType int
+
+const (
+	EVENTTYPE_REQUEST  EventType = 1 << iota //1
+	EVENTTYPE_RESPONSE                       //2
+	EVENTTYPE_TIMEOUT                        //4
+	EVENTTYPE_DIALOG_TERMINATED              //8
+
+	EVENTTYPE_ALL = EVENTTYPE_REQUEST | EVENTTYPE_RESPONSE | EVENTTYPE_TIMEOUT | EVENTTYPE_DIALOG_TERMINATED
+)
+
+// A ListenerFilter restricts the events given to a Listener. A zero field
+// does not restrict anything.
+type ListenerFilter struct {
+	// Events is the set of event types to deliver.
+	Events EventType
+
+	// Methods are the methods of the requests, and of the requests
+	// answered by the responses or timed out, to deliver.
+	Methods []string
+}
+
+////////////////////Implementation////////////////////////
+
+// accepts reports whether an event of type event about a request of method
+// passes the filter.
+func (this ListenerFilter) accepts(event EventType, method string) bool {
+	if this.Events != 0 && this.Events&event == 0 {
+		return false
+	}
+	if len(this.Methods) == 0 {
+		return true
+	}
+	for _, m := range this.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+type listenerEntry struct {
+	listener Listener
+	filter   ListenerFilter
+}
+
+// listeners delivers the events of a provider. Listeners are called one at
+// a time, in the order they were added; a Listener added twice is called
+// once, and a Listener which panics is logged and does not keep the next
+// ones from being called.
+type listeners struct {
+	mutex   sync.Mutex
+	entries []*listenerEntry
+	views   bool
+
+	logger func() Logger
+}
+
+func newListeners(logger func() Logger) *listeners {
+	return &listeners{logger: logger}
+}
+
+// add registers l with filter, or replaces the filter of l if it is
+// already registered; it keeps its place in the order.
+func (this *listeners) add(l Listener, filter ListenerFilter) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for _, e := range this.entries {
+		if e.listener == l {
+			e.filter = filter
+			return
+		}
+	}
+	this.entries = append(this.entries, &listenerEntry{listener: l, filter: filter})
+}
+
+func (this *listeners) remove(l Listener) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for i, e := range this.entries {
+		if e.listener == l {
+			this.entries = append(this.entries[:i:i], this.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// snapshot returns the listeners to give an event to; listeners added or
+// removed meanwhile only see the next events.
+func (this *listeners) snapshot(event EventType, method string) []Listener {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	var ls []Listener
+	for _, e := range this.entries {
+		if e.filter.accepts(event, method) {
+			ls = append(ls, e.listener)
+		}
+	}
+	return ls
+}
+
+func (this *listeners) isViewing() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.views
+}
+
+func (this *listeners) setViewing(views bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.views = views
+}
+
+func (this *listeners) fireRequest(ev *RequestEvent) {
+	ls := this.snapshot(EVENTTYPE_REQUEST, ev.GetRequest().GetMethod())
+	if len(ls) == 0 {
+		return
+	}
+
+	var body []byte
+	views := this.isViewing()
+	if views {
+		body = readBody(ev.GetRequest())
+	}
+	for _, l := range ls {
+		e := *ev
+		if views {
+			e.request = newRequestView(ev.GetRequest(), body)
+		}
+		this.call(l, func() { l.ProcessRequest(e) })
+	}
+}
+
+func (this *listeners) fireResponse(ev *ResponseEvent) {
+	ls := this.snapshot(EVENTTYPE_RESPONSE, getCSeqMethod(ev.GetResponse()))
+	if len(ls) == 0 {
+		return
+	}
+
+	var body []byte
+	views := this.isViewing()
+	if views {
+		body = readBody(ev.GetResponse())
+	}
+	for _, l := range ls {
+		e := *ev
+		if views {
+			e.response = newResponseView(ev.GetResponse(), body)
+		}
+		this.call(l, func() { l.ProcessResponse(e) })
+	}
+}
+
+func (this *listeners) fireTimeout(ev *TimeoutEvent) {
+	method := ""
+	if t := ev.GetTransaction(); t != nil && t.GetRequest() != nil {
+		method = t.GetRequest().GetMethod()
+	}
+	for _, l := range this.snapshot(EVENTTYPE_TIMEOUT, method) {
+		this.call(l, func() { l.ProcessTimeout(*ev) })
+	}
+}
+
+func (this *listeners) fireDialogTerminated(ev *DialogTerminatedEvent) {
+	method := ""
+	if t := ev.GetDialog().GetFirstTransaction(); t != nil && t.GetRequest() != nil {
+		method = t.GetRequest().GetMethod()
+	}
+	for _, l := range this.snapshot(EVENTTYPE_DIALOG_TERMINATED, method) {
+		if dl, ok := l.(DialogListener); ok {
+			this.call(l, func() { dl.ProcessDialogTerminated(*ev) })
+		}
+	}
+}
+
+// call isolates the provider from a panicking Listener.
+func (this *listeners) call(l Listener, f func()) {
+	defer func() {
+		if r := recover(); r != nil && this.logger != nil {
+			this.logger().Log(LOG_ERROR, "Listener panicked", "listener", fmt.Sprintf("%T", l), "panic", r)
+		}
+	}()
+	f()
+}
@@ -0,0 +1,81 @@
+package sip
+
+import (
+	"testing"
+)
+
+type recordingListener struct {
+	name    string
+	events  *[]string
+	panicky bool
+}
+
+func (this *recordingListener) ProcessRequest(requestEvent RequestEvent) {
+	*this.events = append(*this.events, this.name+" "+requestEvent.GetRequest().GetMethod())
+	if this.panicky {
+		panic("listener bug")
+	}
+}
+
+func (this *recordingListener) ProcessResponse(responseEvent ResponseEvent) {
+	*this.events = append(*this.events, this.name+" "+responseEvent.GetResponse().GetReasonPhrase())
+}
+
+func (this *recordingListener) ProcessTimeout(timeoutEvent TimeoutEvent) {
+	*this.events = append(*this.events, this.name+" timeout")
+}
+
+func TestListenerDelivery(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	go p.Run()
+	defer p.Stop()
+
+	var events []string
+	first := &recordingListener{name: "first", events: &events, panicky: true}
+	second := &recordingListener{name: "second", events: &events}
+	invites := &recordingListener{name: "invites", events: &events}
+	p.AddListener(first)
+	p.AddListener(second)
+	p.AddListener(first)
+	p.AddFilteredListener(invites, ListenerFilter{Events: EVENTTYPE_REQUEST | EVENTTYPE_TIMEOUT, Methods: []string{INVITE}})
+
+	newRequest := func(method, branch string) Request {
+		req := NewRequest(method, "sip:bob@example.com", nil)
+		req.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.1;branch="+branch)
+		req.GetHeader().Set("From", "<sip:alice@example.com>;tag=1")
+		req.GetHeader().Set("To", "<sip:bob@example.com>")
+		req.GetHeader().Set("Cseq", "1 "+method)
+		return req
+	}
+
+	//the panic of the first listener neither stops the provider nor the
+	//delivery to the next ones
+	p.processMessage(newRequest(OPTIONS, "z9hG4bK1"))
+	p.processMessage(newRequest(INVITE, "z9hG4bK2"))
+	invite := newRequest(INVITE, "z9hG4bK3")
+	ct := p.GetNewClientTransaction(invite).(*clientTransaction)
+	p.processMessage(CreateResponse(invite, RINGING))
+	ct.processError(&TimeoutError{Transaction: ct})
+
+	want := []string{
+		"first OPTIONS", "second OPTIONS",
+		"first INVITE", "second INVITE", "invites INVITE",
+		"first Ringing", "second Ringing",
+		"first timeout", "second timeout", "invites timeout",
+	}
+	if len(events) != len(want) {
+		t.Fatalf("events = %q", events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("events = %q, want %q", events, want)
+		}
+	}
+
+	p.RemoveListener(first)
+	events = nil
+	p.processMessage(newRequest(OPTIONS, "z9hG4bK4"))
+	if len(events) != 1 || events[0] != "second OPTIONS" {
+		t.Errorf("after RemoveListener: %q", events)
+	}
+}
@@ -0,0 +1,220 @@
+package sip
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A provider reports what happens to it, a send failing, a connection
+// dying, a malformed message, to its Logger, as a message at a level with
+// fields, alternating keys and values as in log/slog. Each record is
+// scoped to the component it comes from, under the LOG_COMPONENT field.
+// A provider logs to the default logger, the standard log package from
+// LOG_INFO up unless SetDefaultLogger changed it, unless it has a logger
+// of its own; NewSlogLogger adapts a *slog.Logger. The Tracer of a
+// provider is left to tracing messages.
+
+type LogLevel int
+
+const (
+	LOG_DEBUG LogLevel = iota //0
+	LOG_INFO                  //1
+	LOG_WARN                  //2
+	LOG_ERROR                 //3
+)
+
+// The field a record names its component by, and the components.
+const (
+	LOG_COMPONENT = "component"
+
+	COMPONENT_PROVIDER    = "provider"
+	COMPONENT_TRANSPORT   = "transport"
+	COMPONENT_TRANSACTION = "transaction"
+	COMPONENT_DIALOG      = "dialog"
+	COMPONENT_PARSER      = "parser"
+	COMPONENT_PROXY       = "proxy"
+)
+
+////////////////////Interface//////////////////////////////
+
+type Logger interface {
+	// Log records msg at level, with fields alternating keys and values.
+	Log(level LogLevel, msg string, fields ...interface{})
+
+	// With returns a logger adding fields to each record.
+	With(fields ...interface{}) Logger
+}
+
+////////////////////Implementation////////////////////////
+
+func (this LogLevel) String() string {
+	switch this {
+	case LOG_DEBUG:
+		return "DEBUG"
+	case LOG_INFO:
+		return "INFO"
+	case LOG_WARN:
+		return "WARN"
+	case LOG_ERROR:
+		return "ERROR"
+	}
+	return "LEVEL(" + fmt.Sprint(int(this)) + ")"
+}
+
+var defaultLogger = struct {
+	mutex  sync.Mutex
+	logger Logger
+}{logger: NewStdLogger(log.Default(), LOG_INFO)}
+
+// GetDefaultLogger returns the logger of the providers without one of
+// their own.
+func GetDefaultLogger() Logger {
+	defaultLogger.mutex.Lock()
+	defer defaultLogger.mutex.Unlock()
+
+	return defaultLogger.logger
+}
+
+// SetDefaultLogger sets the logger of the providers without one of their
+// own, the standard log package from LOG_INFO up by default.
+func SetDefaultLogger(logger Logger) {
+	defaultLogger.mutex.Lock()
+	defer defaultLogger.mutex.Unlock()
+
+	defaultLogger.logger = logger
+}
+
+type stdLogger struct {
+	out    *log.Logger
+	min    LogLevel
+	fields []interface{}
+}
+
+// NewStdLogger returns a logger printing the records from level min up to
+// out, as the level, the message and the fields as key=value.
+func NewStdLogger(out *log.Logger, min LogLevel) Logger {
+	return &stdLogger{out: out, min: min}
+}
+
+func (this *stdLogger) Log(level LogLevel, msg string, fields ...interface{}) {
+	if level < this.min {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteString(" ")
+	b.WriteString(msg)
+	writeFields(&b, this.fields)
+	writeFields(&b, fields)
+	this.out.Print(b.String())
+}
+
+func (this *stdLogger) With(fields ...interface{}) Logger {
+	return &stdLogger{
+		out:    this.out,
+		min:    this.min,
+		fields: append(this.fields[:len(this.fields):len(this.fields)], fields...),
+	}
+}
+
+// writeFields writes fields as key=value, a value lacking its key as is.
+func writeFields(b *strings.Builder, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		b.WriteString(" ")
+		if i+1 == len(fields) {
+			fmt.Fprint(b, fields[i])
+			break
+		}
+		fmt.Fprintf(b, "%v=%v", fields[i], fields[i+1])
+	}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a logger recording to logger, LOG_DEBUG to
+// LOG_ERROR as slog.LevelDebug to slog.LevelError.
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return &slogLogger{logger: logger}
+}
+
+func (this *slogLogger) Log(level LogLevel, msg string, fields ...interface{}) {
+	this.logger.Log(context.Background(), getSlogLevel(level), msg, fields...)
+}
+
+func (this *slogLogger) With(fields ...interface{}) Logger {
+	return &slogLogger{logger: this.logger.With(fields...)}
+}
+
+func getSlogLevel(level LogLevel) slog.Level {
+	switch level {
+	case LOG_DEBUG:
+		return slog.LevelDebug
+	case LOG_INFO:
+		return slog.LevelInfo
+	case LOG_WARN:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
+
+type nilLogger struct {
+}
+
+// LogOff returns a logger discarding every record.
+func LogOff() Logger {
+	return &nilLogger{}
+}
+func (this *nilLogger) Log(level LogLevel, msg string, fields ...interface{}) {
+}
+func (this *nilLogger) With(fields ...interface{}) Logger {
+	return this
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// GetLogger returns the logger of the provider, the default logger unless
+// SetLogger was called.
+func (this *provider) GetLogger() Logger {
+	this.mutex.Lock()
+	logger := this.logger
+	this.mutex.Unlock()
+
+	if logger == nil {
+		return GetDefaultLogger()
+	}
+	return logger
+}
+
+// SetLogger sets the logger of the provider; nil, the default, logs to
+// the default logger.
+func (this *provider) SetLogger(logger Logger) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.logger = logger
+}
+
+// getLogger returns the logger of the provider scoped to component.
+func (this *provider) getLogger(component string) Logger {
+	return this.GetLogger().With(LOG_COMPONENT, component)
+}
+
+// getTransportURL names t in the records of a logger.
+func getTransportURL(t Transport) string {
+	return t.GetNetwork() + "://" + net.JoinHostPort(t.GetAddress(), strconv.Itoa(t.GetPort()))
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// getLogger returns the logger of the provider of the proxy, scoped to it.
+func (this *proxy) getLogger() Logger {
+	return this.provider.GetLogger().With(LOG_COMPONENT, COMPONENT_PROXY)
+}
@@ -0,0 +1,79 @@
+package sip
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+type panickingListener struct {
+	serverListener
+}
+
+func (this *panickingListener) ProcessRequest(requestEvent RequestEvent) {
+	panic("boom")
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0), LOG_INFO)
+	scoped := logger.With(LOG_COMPONENT, COMPONENT_TRANSPORT)
+
+	scoped.Log(LOG_DEBUG, "Disconnecting")
+	scoped.Log(LOG_WARN, "Keep-alive failed", "network", TCP, "address", "192.0.2.1:5060")
+	logger.Log(LOG_ERROR, "Odd", "lonely")
+	want := "WARN Keep-alive failed component=transport network=tcp address=192.0.2.1:5060\nERROR Odd lonely\n"
+	if buf.String() != want {
+		t.Errorf("logged %q, want %q", buf.String(), want)
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	logger := NewSlogLogger(slog.New(handler)).With(LOG_COMPONENT, COMPONENT_DIALOG)
+
+	logger.Log(LOG_DEBUG, "Received", "message", "BYE sip:bob@example.com SIP/2.0")
+	logger.Log(LOG_ERROR, "Resending ACK failed", "error", ErrNoAck)
+	want := `level=DEBUG msg=Received component=dialog message="BYE sip:bob@example.com SIP/2.0"
+level=ERROR msg="Resending ACK failed" component=dialog error="sip: no non-2xx final response to acknowledge"
+`
+	if buf.String() != want {
+		t.Errorf("logged\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestProviderLogger(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	if p.GetLogger() != GetDefaultLogger() {
+		t.Error("provider not logging to the default logger")
+	}
+
+	//a panicking listener is logged by the provider
+	var buf bytes.Buffer
+	p.SetLogger(NewStdLogger(log.New(&buf, "", 0), LOG_DEBUG))
+	p.AddListener(&panickingListener{})
+	p.processMessage(newServerTestRequest(OPTIONS, "UDP", "z9hG4bKlog1"))
+	for _, want := range []string{
+		"DEBUG Received component=provider message=OPTIONS",
+		"ERROR Listener panicked component=provider listener=*sip.panickingListener panic=boom",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("logged\n%s\nwant %q", buf.String(), want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package sip
+
+import (
+	"strings"
+)
+
+// A request larger than the UDP size limit of the provider, MTU_THRESHOLD
+// by default, is not sent over UDP but over TCP, which controls congestion
+// and does not fragment (RFC 3261 18.1.1): each UDP address resolved for
+// its next hop is preceded by the same address over TCP, so that the
+// request falls back to UDP when TCP fails, as the RFC allows. The top Via
+// follows the transport used. The provider needs a TCP transport for
+// this; without one, the request goes over UDP whatever its size.
+// Responses go where their Via tells, and are never switched.
+
+////////////////////Implementation////////////////////////
+
+func (this *provider) GetUDPSizeLimit() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.udpSizeLimit
+}
+
+// SetUDPSizeLimit sets the size, in bytes, above which a request is sent
+// over TCP rather than UDP, MTU_THRESHOLD by default; 0 never switches.
+func (this *provider) SetUDPSizeLimit(limit int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.udpSizeLimit = limit
+}
+
+// getSizedHops returns the hops to send req to, in order: hops, each UDP
+// hop preceded by its TCP counterpart if req is too large for UDP.
+func (this *provider) getSizedHops(req Request, hops []Hop) []Hop {
+	limit := this.GetUDPSizeLimit()
+	if limit <= 0 || this.getTransport(TCP) == nil || req.GetSize(nil) <= limit {
+		return hops
+	}
+
+	sized := make([]Hop, 0, 2*len(hops))
+	for _, h := range hops {
+		if strings.EqualFold(h.GetTransport(), UDP) {
+			sized = append(sized, NewHop(h.GetHost(), h.GetPort(), TCP))
+		}
+		sized = append(sized, h)
+	}
+	return sized
+}
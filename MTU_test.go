@@ -0,0 +1,91 @@
+package sip
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSizedRequest(size int, branch string) Request {
+	req := NewRequest(MESSAGE, "sip:bob@biloxi.com", strings.NewReader(strings.Repeat("x", size)))
+	h := req.GetHeader()
+	h.Set("Via", "SIP/2.0/UDP 192.0.2.9;branch="+branch)
+	h.Set("Cseq", "1 MESSAGE")
+	h.Set("Content-Type", "text/plain")
+	h.Set("Content-Length", "0")
+	req.SetContentLength(int64(size))
+	return req
+}
+
+func TestUDPSizeLimit(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.2", 5060, UDP)}, nil
+	}))
+	go p.Run()
+	defer p.Stop()
+
+	//without a TCP transport, UDP it is
+	if err := p.SendRequest(newSizedRequest(MTU_THRESHOLD, "z9hG4bKmtu1")); err != nil {
+		t.Fatal(err)
+	}
+	if h := sent.hops[0]; h.GetTransport() != UDP {
+		t.Errorf("sent over %s without a TCP transport", h.GetTransport())
+	}
+
+	p.AddTransport(newTransport(TCP, "127.0.0.1", 5060, nil))
+	for i, c := range []struct {
+		size  int
+		limit int
+		want  string
+	}{
+		{100, MTU_THRESHOLD, UDP},
+		{MTU_THRESHOLD, MTU_THRESHOLD, TCP},
+		{MTU_THRESHOLD, 0, UDP},
+	} {
+		p.SetUDPSizeLimit(c.limit)
+		req := newSizedRequest(c.size, "z9hG4bKmtu2")
+		if err := p.SendRequest(req); err != nil {
+			t.Fatal(err)
+		}
+		if h := sent.hops[i+1]; h.GetTransport() != c.want || h.GetHost() != "192.0.2.2" || !strings.Contains(req.GetHeader().Get("Via"), "/"+strings.ToUpper(c.want)+" ") {
+			t.Errorf("%d bytes with a limit of %d sent over %s, Via %q", c.size, c.limit, h.GetTransport(), req.GetHeader().Get("Via"))
+		}
+	}
+
+	//back to UDP when TCP fails
+	p.SetUDPSizeLimit(MTU_THRESHOLD)
+	send := p.send
+	p.send = func(msg Message, h Hop) error {
+		if h.GetTransport() == TCP {
+			return errors.New("connection refused")
+		}
+		return send(msg, h)
+	}
+	if err := p.SendRequest(newSizedRequest(MTU_THRESHOLD, "z9hG4bKmtu3")); err != nil {
+		t.Fatal(err)
+	}
+	if h := sent.hops[4]; h.GetTransport() != UDP {
+		t.Errorf("fell back over %s", h.GetTransport())
+	}
+	p.send = send
+
+	//a transaction over TCP does not retransmit
+	ct := p.GetNewClientTransaction(newSizedRequest(MTU_THRESHOLD, "z9hG4bKmtu4"))
+	if err := ct.SendRequest(); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, 6)
+	if h := sent.hops[5]; h.GetTransport() != TCP {
+		t.Errorf("transaction sent over %s", h.GetTransport())
+	}
+	clock.Advance(4 * TIMER_T1)
+	time.Sleep(20 * time.Millisecond)
+	if sent.len() != 6 {
+		t.Errorf("%d messages sent, the request retransmitted", sent.len())
+	}
+}
@@ -2,10 +2,14 @@ package sip
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/textproto"
+	"reflect"
+	"sip/address"
 	"sip/header"
 	"sip/parser"
 	"strconv"
@@ -26,9 +30,95 @@ type Message interface {
 	SetHeader(Header)
 	GetContentLength() int64
 	SetContentLength(l int64)
+
+	// The body is kept in memory: GetBody returns a new reader of it
+	// every time, so that it can be read again, e.g. to retransmit the
+	// message, and SetBody reads the reader given to the end.
+	// GetBodyBytes returns the body itself, not to be modified.
 	GetBody() io.Reader
 	SetBody(io.Reader)
+	GetBodyBytes() []byte
+	SetBodyBytes([]byte)
+
 	Write(io.Writer) error
+	WriteWithOptions(io.Writer, *WriteOptions) error
+	GetSize(*WriteOptions) int
+
+	GetMessageInfo() *MessageInfo
+	SetMessageInfo(*MessageInfo)
+
+	// Typed accessors for the headers every message has. The getters
+	// return nil when the header is missing or malformed; the setters
+	// replace the header, removing it on nil.
+	GetVia() []*header.Via
+	SetVia([]*header.Via)
+	GetFrom() *header.From
+	SetFrom(*header.From)
+	GetTo() *header.To
+	SetTo(*header.To)
+	GetCSeq() *header.CSeq
+	SetCSeq(*header.CSeq)
+	GetCallID() *header.CallID
+	SetCallID(*header.CallID)
+
+	// Typed access to any header. AddHeader appends h after the values
+	// of its name, GetHeaders parses them, one header object per header
+	// field, and GetHeaderNames lists the names in the order they were
+	// received or added.
+	AddHeader(h header.Header)
+	GetHeaders(name string) ([]header.Header, error)
+	RemoveHeader(name string)
+	GetHeaderNames() []string
+}
+
+// Messages larger than this should not be sent over UDP, see RFC 3261 18.1.1.
+const MTU_THRESHOLD = 1300
+
+// WriteOptions control how a message is serialized.
+type WriteOptions struct {
+	// Compact emits the single-letter header names of RFC 3261 7.3.3
+	// where one exists.
+	Compact bool
+	// Strip lists headers which are left out of the output, e.g.
+	// OptionalHeaders when the message is close to the MTU.
+	Strip []string
+}
+
+// Headers which carry no protocol semantics and can be dropped to trim a
+// message.
+var OptionalHeaders = []string{
+	"Call-Info",
+	"Date",
+	"Error-Info",
+	"In-Reply-To",
+	"Organization",
+	"Reply-To",
+	"Server",
+	"Subject",
+	"Timestamp",
+	"User-Agent",
+	"Warning",
+}
+
+func (this *WriteOptions) isCompact() bool {
+	return this != nil && this.Compact
+}
+
+// exclude returns the headers not to write, which are only read.
+func (this *WriteOptions) exclude() map[string]bool {
+	if this == nil || len(this.Strip) == 0 {
+		return reqWriteExcludeHeader
+	}
+	exclude := make(map[string]bool)
+	for k, v := range reqWriteExcludeHeader {
+		exclude[k] = v
+	}
+	if this != nil {
+		for _, name := range this.Strip {
+			exclude[CanonicalHeaderKey(name)] = true
+		}
+	}
+	return exclude
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -38,17 +128,24 @@ type message struct {
 	sipVersion string
 	header     Header
 
+	//the name of each header field in the order they were received or
+	//added, spelled as received; the values only set on the Header map
+	//follow the last field of their name, or come after the fields in name
+	//order
+	names []string
+
 	/** Direct accessors for frequently accessed headers  **/
-	via           []*header.Via
-	from          *header.From
-	to            *header.To
-	cSeq          *header.CSeq
-	callId        *header.CallID
-	maxForwards   *header.MaxForwards
+	//parsed by name, with the value they were parsed from: an edit of the
+	//Header map makes them parse again
+	parsed        map[string]*parsedHeader
+	parsedMutex   sync.Mutex
 	contentLength *header.ContentLength
 
 	//contentLength int64
-	body io.Reader
+	body     []byte
+	bodyType TypedBody //the body set, if it knows its media type
+
+	info *MessageInfo
 }
 
 func (this *message) GetSIPVersion() string {
@@ -72,6 +169,14 @@ func (this *message) SetHeader(header Header) {
 	this.header = header
 }
 
+func (this *message) GetMessageInfo() *MessageInfo {
+	return this.info
+}
+
+func (this *message) SetMessageInfo(info *MessageInfo) {
+	this.info = info
+}
+
 func (this *message) GetContentLength() int64 {
 	if this.contentLength != nil {
 		return int64(this.contentLength.GetContentLength())
@@ -88,11 +193,255 @@ func (this *message) SetContentLength(l int64) {
 }
 
 func (this *message) GetBody() io.Reader {
-	return this.body
+	if this.body == nil {
+		return nil
+	}
+	return bytes.NewReader(this.body)
 }
 
+// SetBody attaches the content of body to the message, read to the end or
+// to the first error, and sets the Content-Length. A TypedBody also stamps
+// its Content-Type and Content-Disposition.
 func (this *message) SetBody(body io.Reader) {
+	var b []byte
+	switch v := body.(type) {
+	case nil:
+	case interface{ Bytes() []byte }:
+		//its content, unread
+		b = v.Bytes()
+	default:
+		b, _ = ioutil.ReadAll(body)
+		if b == nil {
+			b = []byte{}
+		}
+	}
+	this.SetBodyBytes(b)
+
+	if v, ok := body.(TypedBody); ok {
+		this.bodyType = v
+		stampBody(this.header, v)
+	}
+}
+
+func (this *message) GetBodyBytes() []byte {
+	return this.body
+}
+
+// SetBodyBytes sets the body of the message to body, which it keeps, and
+// the Content-Length to its length.
+func (this *message) SetBodyBytes(body []byte) {
 	this.body = body
+	this.bodyType = nil
+	this.SetContentLength(int64(len(body)))
+}
+
+type parsedHeader struct {
+	value  string
+	header interface{}
+}
+
+// getParsed returns the header name parsed by parse, reusing the last
+// parse while the value of the header is unchanged.
+func (this *message) getParsed(name string, parse func(string) (header.Header, error)) interface{} {
+	value := strings.Join(this.header[name], ", ")
+	if value == "" {
+		return nil
+	}
+
+	this.parsedMutex.Lock()
+	defer this.parsedMutex.Unlock()
+
+	if p, ok := this.parsed[name]; ok && p.value == value {
+		return p.header
+	}
+	var h interface{}
+	if sh, err := parse(name + ": " + value + "\n"); err == nil {
+		h = sh
+	}
+	if this.parsed == nil {
+		this.parsed = make(map[string]*parsedHeader)
+	}
+	this.parsed[name] = &parsedHeader{value: value, header: h}
+	return h
+}
+
+var errMissingURI = errors.New("sip: address without URI")
+
+// hasURI reports whether the parser found the URI of addr, which it leaves
+// nil in a value like "Alice <".
+func hasURI(addr address.Address) bool {
+	if addr == nil || addr.GetURI() == nil {
+		return false
+	}
+	v := reflect.ValueOf(addr.GetURI())
+	return v.Kind() != reflect.Ptr || !v.IsNil()
+}
+
+// setParsed replaces the header name by values, parsed as h.
+func (this *message) setParsed(name string, values []string, h interface{}) {
+	this.parsedMutex.Lock()
+	defer this.parsedMutex.Unlock()
+
+	if len(values) == 0 {
+		delete(this.header, name)
+		delete(this.parsed, name)
+		return
+	}
+	this.header[name] = values
+	if this.parsed == nil {
+		this.parsed = make(map[string]*parsedHeader)
+	}
+	this.parsed[name] = &parsedHeader{value: strings.Join(values, ", "), header: h}
+}
+
+func (this *message) GetVia() []*header.Via {
+	vl, ok := this.getParsed("Via", func(v string) (header.Header, error) {
+		return parser.NewViaParser(v).Parse()
+	}).(*header.ViaList)
+	if !ok {
+		return nil
+	}
+	var vias []*header.Via
+	for e := vl.Front(); e != nil; e = e.Next() {
+		vias = append(vias, e.Value.(*header.Via))
+	}
+	return vias
+}
+
+func (this *message) SetVia(vias []*header.Via) {
+	vl := header.NewViaList()
+	var values []string
+	for _, via := range vias {
+		vl.PushBack(via)
+		values = append(values, via.EncodeBody())
+	}
+	this.setParsed("Via", values, vl)
+}
+
+func (this *message) GetFrom() *header.From {
+	from, _ := this.getParsed("From", func(v string) (header.Header, error) {
+		sh, err := parser.NewFromParser(v).Parse()
+		if err == nil && !hasURI(sh.(*header.From).GetAddress()) {
+			return nil, errMissingURI
+		}
+		return sh, err
+	}).(*header.From)
+	return from
+}
+
+func (this *message) SetFrom(from *header.From) {
+	if from == nil {
+		this.setParsed("From", nil, nil)
+	} else {
+		this.setParsed("From", []string{from.EncodeBody()}, from)
+	}
+}
+
+func (this *message) GetTo() *header.To {
+	to, _ := this.getParsed("To", func(v string) (header.Header, error) {
+		sh, err := parser.NewToParser(v).Parse()
+		if err == nil && !hasURI(sh.(*header.To).GetAddress()) {
+			return nil, errMissingURI
+		}
+		return sh, err
+	}).(*header.To)
+	return to
+}
+
+func (this *message) SetTo(to *header.To) {
+	if to == nil {
+		this.setParsed("To", nil, nil)
+	} else {
+		this.setParsed("To", []string{to.EncodeBody()}, to)
+	}
+}
+
+func (this *message) GetCSeq() *header.CSeq {
+	cseq, _ := this.getParsed("Cseq", func(v string) (header.Header, error) {
+		return parser.NewCSeqParser(v).Parse()
+	}).(*header.CSeq)
+	return cseq
+}
+
+func (this *message) SetCSeq(cseq *header.CSeq) {
+	if cseq == nil {
+		this.setParsed("Cseq", nil, nil)
+	} else {
+		this.setParsed("Cseq", []string{cseq.EncodeBody()}, cseq)
+	}
+}
+
+func (this *message) GetCallID() *header.CallID {
+	callId, _ := this.getParsed("Call-Id", func(v string) (header.Header, error) {
+		return parser.NewCallIDParser(v).Parse()
+	}).(*header.CallID)
+	return callId
+}
+
+func (this *message) SetCallID(callId *header.CallID) {
+	if callId == nil {
+		this.setParsed("Call-Id", nil, nil)
+	} else {
+		this.setParsed("Call-Id", []string{callId.EncodeBody()}, callId)
+	}
+}
+
+func (this *message) AddHeader(h header.Header) {
+	name := h.GetName()
+	key := CanonicalHeaderKey(name)
+	this.names = append(this.names, name)
+	this.header[key] = append(this.header[key], h.EncodeBody())
+}
+
+func (this *message) GetHeaders(name string) ([]header.Header, error) {
+	var headers []header.Header
+	for _, v := range this.header[CanonicalHeaderKey(name)] {
+		p, err := parser.CreateParser(name + ": " + v + "\n")
+		if err != nil {
+			return nil, err
+		}
+		h, err := p.Parse()
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, h)
+	}
+	return headers, nil
+}
+
+func (this *message) RemoveHeader(name string) {
+	key := CanonicalHeaderKey(name)
+	delete(this.header, key)
+	names := this.names[:0:0]
+	for _, n := range this.names {
+		if CanonicalHeaderKey(n) != key {
+			names = append(names, n)
+		}
+	}
+	this.names = names
+}
+
+// getFieldNames returns the name of each header field, in order.
+func (this *message) getFieldNames() []string {
+	return append([]string(nil), this.names...)
+}
+
+func (this *message) GetHeaderNames() []string {
+	var names []string
+	listed := make(map[string]bool)
+	for _, name := range this.names {
+		key := CanonicalHeaderKey(name)
+		if _, ok := this.header[key]; ok && !listed[key] {
+			listed[key] = true
+			names = append(names, name)
+		}
+	}
+	kvs, sorter := this.header.sortedKeyValues(listed)
+	for _, kv := range kvs {
+		names = append(names, kv.key)
+	}
+	headerSorterPool.Put(sorter)
+	return names
 }
 
 // Headers that Request.Write handles itself and should be skipped.
@@ -105,44 +454,118 @@ var reqWriteExcludeHeader = map[string]bool{
 //	ContentLength
 //	Body
 func (this *message) Write(w io.Writer) (err error) {
+	return this.WriteWithOptions(w, nil)
+}
+
+func (this *message) WriteWithOptions(w io.Writer, options *WriteOptions) (err error) {
 	var bw *bufio.Writer
 	if _, ok := w.(io.ByteWriter); !ok {
 		bw = bufio.NewWriter(w)
 		w = bw
 	}
 
-	if err = this.StartLineWriter.StartLineWrite(w); err != nil {
+	if err = this.writeHead(w, options); err != nil {
 		return err
 	}
 
-	if err = this.header.WriteSubset(w, reqWriteExcludeHeader); err != nil {
-		return err
+	// Write body
+	if body := this.body; len(body) > 0 {
+		if l := this.GetContentLength(); int64(len(body)) > l {
+			body = body[:l]
+		}
+		if _, err = w.Write(body); err != nil {
+			return err
+		}
+	}
+
+	if bw != nil {
+		return bw.Flush()
+	}
+	return nil
+}
+
+// GetSize reports the number of bytes WriteWithOptions would produce,
+// without consuming the body.
+func (this *message) GetSize(options *WriteOptions) int {
+	var cw countingWriter
+	this.writeHead(&cw, options)
+	return cw.n + int(this.GetContentLength())
+}
+
+func (this *message) writeHead(w io.Writer, options *WriteOptions) (err error) {
+	if this.bodyType != nil {
+		if err = stampBody(this.header, this.bodyType); err != nil {
+			return err
+		}
 	}
 
-	if _, err = fmt.Fprintf(w, "%s: %d\r\n", "Content-Length", this.GetContentLength()); err != nil {
+	if err = this.StartLineWriter.StartLineWrite(w); err != nil {
 		return err
 	}
 
-	if _, err = io.WriteString(w, "\r\n"); err != nil {
+	if err = this.header.writeOrdered(w, this.names, options.exclude(), options.isCompact()); err != nil {
 		return err
 	}
 
-	// Write body
-	if this.body != nil {
-		if _, err = io.Copy(w, io.LimitReader(this.body, this.GetContentLength())); err != nil {
-			return err
-		}
+	contentLength := "Content-Length"
+	if options.isCompact() {
+		contentLength = compactHeaderKey(contentLength)
+	}
+	if _, err = io.WriteString(w, contentLength+": "+strconv.FormatInt(this.GetContentLength(), 10)+"\r\n\r\n"); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-// ReadMessage reads and parses an incoming message from b.
+type countingWriter struct {
+	n int
+}
+
+func (this *countingWriter) Write(p []byte) (int, error) {
+	this.n += len(p)
+	return len(p), nil
+}
+
+func (this *countingWriter) WriteByte(c byte) error {
+	this.n++
+	return nil
+}
+
+// ErrMissingContentLength is returned with a message read from a stream
+// without a Content-Length, which tells where the message ends (RFC 3261
+// 18.3).
+var ErrMissingContentLength = errors.New("sip: missing Content-Length on a stream")
+
+// ErrContentLengthExceeded is returned for a datagram shorter than its
+// Content-Length says (RFC 3261 18.3).
+var ErrContentLengthExceeded = errors.New("sip: Content-Length exceeds the datagram")
+
+// framing tells how the end of the body of a message is found, by the
+// transport it is read from.
+type framing int
+
+const (
+	framingNone     framing = iota //a missing Content-Length is 0
+	framingDatagram                //b holds the datagram, the body is the rest of it
+	framingStream                  //the Content-Length is required
+)
+
+// ReadMessage reads and parses an incoming message from b. A message
+// without Content-Length has no body.
 func ReadMessage(b *bufio.Reader) (msg Message, err error) {
+	return readMessage(b, framingNone)
+}
+
+// readMessage reads a message from b, the transport of which is framed by
+// framing. The message read from a stream without Content-Length is
+// returned with ErrMissingContentLength, its body unread.
+func readMessage(b *bufio.Reader, framing framing) (msg Message, err error) {
 	tp := newTextprotoReader(b)
 
 	// First line: INVITE sip:bob@biloxi.com SIP/2.0 or SIP/2.0 180 Ringing
 	var s string
+	var m *message
 	if s, err = tp.ReadLine(); err != nil {
 		return nil, err
 	}
@@ -167,24 +590,32 @@ func ReadMessage(b *bufio.Reader) (msg Message, err error) {
 		}
 		sipVersion, reasonPhrase := s[:s1], s[s2+1:]
 		if _, _, ok := ParseSIPVersion(sipVersion); !ok {
-			return nil, fmt.Errorf("malformed SIP version", sipVersion)
+			return nil, fmt.Errorf("malformed SIP version %s", sipVersion)
 		}
-		msg = NewResponse(statusCode, reasonPhrase, nil)
+		resp := NewResponse(statusCode, reasonPhrase, nil)
+		msg, m = resp, &resp.message
 	} else {
 		method, requestURI, sipVersion := s[:s1], s[s1+1:s2], s[s2+1:]
 		if _, _, ok := ParseSIPVersion(sipVersion); !ok {
-			return nil, fmt.Errorf("malformed SIP version", sipVersion)
+			return nil, fmt.Errorf("malformed SIP version %s", sipVersion)
 		}
-		msg = NewRequest(method, requestURI, nil)
+		req := NewRequest(method, requestURI, nil)
+		msg, m = req, &req.message
 	}
 
 	////////////////////////////////////////////////////////////////////////////
 	// Subsequent lines: Key: value.
-	mimeHeader, err := tp.ReadMIMEHeader()
-	if err != nil {
+	if m.header, m.names, err = readHeader(tp); err != nil {
 		return nil, err
 	}
-	msg.SetHeader(Header(mimeHeader))
+
+	//parse the headers of the typed accessors once, while the message is
+	//not shared yet
+	msg.GetVia()
+	msg.GetFrom()
+	msg.GetTo()
+	msg.GetCSeq()
+	msg.GetCallID()
 
 	////////////////////////////////////////////////////////////////////////////
 
@@ -192,6 +623,9 @@ func ReadMessage(b *bufio.Reader) (msg Message, err error) {
 	if len(contentLens) > 1 { // harden against SIP request smuggling. See RFC 7230.
 		return nil, errors.New("http: message cannot contain multiple Content-Length headers")
 	} else if len(contentLens) == 0 {
+		if framing == framingStream {
+			return msg, ErrMissingContentLength
+		}
 		msg.SetContentLength(0)
 	} else {
 		if cl, err := parser.NewContentLengthParser("Content-Length: " + contentLens[0]).Parse(); err != nil {
@@ -203,15 +637,73 @@ func ReadMessage(b *bufio.Reader) (msg Message, err error) {
 
 	////////////////////////////////////////////////////////////////////////////
 
-	if msg.GetContentLength() > 0 {
-		msg.SetBody(io.LimitReader(b, int64(msg.GetContentLength())))
+	if framing == framingDatagram {
+		//the body ends with the datagram, or with the Content-Length if it
+		//is shorter
+		var body []byte
+		if body, err = ioutil.ReadAll(b); err != nil {
+			return nil, err
+		}
+		if len(contentLens) > 0 {
+			if msg.GetContentLength() > int64(len(body)) {
+				return nil, ErrContentLengthExceeded
+			}
+			body = body[:msg.GetContentLength()]
+		}
+		if len(body) > 0 {
+			msg.SetBodyBytes(body)
+		} else {
+			msg.SetBodyBytes(nil)
+		}
+		return msg, nil
+	}
+
+	if l := msg.GetContentLength(); l > 0 {
+		//read as it arrives, rather than allocated from the Content-Length
+		var body []byte
+		if body, err = ioutil.ReadAll(io.LimitReader(b, l)); err != nil {
+			return nil, err
+		}
+		if int64(len(body)) < l {
+			return nil, io.ErrUnexpectedEOF
+		}
+		msg.SetBodyBytes(body)
 	} else {
-		msg.SetBody(nil)
+		msg.SetBodyBytes(nil)
 	}
 
 	return msg, nil
 }
 
+// readHeader reads the header fields up to the empty line, and returns
+// them with the name of each field in order, as spelled in the message.
+func readHeader(tp *textproto.Reader) (Header, []string, error) {
+	h := make(Header)
+	var names []string
+	for {
+		line, err := tp.ReadContinuedLine()
+		if line == "" {
+			return h, names, err
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, nil, textproto.ProtocolError("malformed header line: " + line)
+		}
+		name := strings.TrimRight(line[:i], " \t")
+		key := longHeaderKey(name)
+		if key != CanonicalHeaderKey(name) {
+			//a compact form, see RFC 3261 7.3.3
+			name = key
+		}
+		names = append(names, name)
+		h[key] = append(h[key], textproto.TrimString(line[i+1:]))
+	}
+}
+
 var textprotoReaderPool sync.Pool
 
 func newTextprotoReader(br *bufio.Reader) *textproto.Reader {
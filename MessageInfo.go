@@ -0,0 +1,67 @@
+package sip
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// MessageInfo describes how an incoming message was received. It is attached
+// by the provider before the message reaches middleware and listeners, and
+// is nil for messages built locally.
+type MessageInfo struct {
+	Transport  Transport
+	Network    string
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+	ReceivedAt time.Time
+
+	// TLS is the state of the connection for messages received over TLS.
+	TLS *tls.ConnectionState
+}
+
+func newMessageInfo(t Transport, conn net.Conn, receivedAt time.Time) *MessageInfo {
+	this := &MessageInfo{}
+
+	this.Transport = t
+	this.ReceivedAt = receivedAt
+	if t != nil {
+		this.Network = t.GetNetwork()
+	}
+	if conn != nil {
+		this.LocalAddr = conn.LocalAddr()
+		this.RemoteAddr = conn.RemoteAddr()
+		if wc, ok := conn.(*wsConn); ok {
+			conn = wc.Conn
+		}
+		if tc, ok := conn.(*tls.Conn); ok {
+			state := tc.ConnectionState()
+			this.TLS = &state
+		}
+	}
+
+	return this
+}
+
+// IsSecure reports whether the message was received over TLS, WebSocket
+// over TLS included.
+func (this *MessageInfo) IsSecure() bool {
+	return this != nil && this.TLS != nil
+}
+
+// GetPeerNames returns the identities asserted by the TLS peer certificate:
+// its DNS subject alternative names or, failing those, its common name
+// (RFC 5922 7.1).
+func (this *MessageInfo) GetPeerNames() []string {
+	if !this.IsSecure() || len(this.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := this.TLS.PeerCertificates[0]
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames
+	}
+	if cert.Subject.CommonName != "" {
+		return []string{cert.Subject.CommonName}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package sip
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMessageInfo(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	tr := newTransport(TCP, "127.0.0.1", 5060, nil)
+
+	received := make(chan Message, 1)
+	p.dispatcher = NewDispatcher(1, func(msg Message) { received <- msg })
+	p.dispatcher.Start()
+	defer p.dispatcher.Stop()
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	p.waitGroup.Add(1)
+	go p.ServeConn(tr, local)
+	defer close(p.quit)
+
+	go remote.Write([]byte(testOptions))
+
+	select {
+	case msg := <-received:
+		info := msg.GetMessageInfo()
+		if info == nil {
+			t.Fatal("no MessageInfo attached")
+		}
+		if info.Transport != tr || info.Network != TCP || info.RemoteAddr != remote.LocalAddr() {
+			t.Errorf("MessageInfo = %+v", info)
+		}
+		if !info.ReceivedAt.Equal(clock.Now()) || info.IsSecure() || info.GetPeerNames() != nil {
+			t.Errorf("MessageInfo = %+v", info)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+}
@@ -3,6 +3,8 @@ package sip
 import (
 	"bufio"
 	"bytes"
+	"io/ioutil"
+	"sip/header"
 	"strings"
 	"testing"
 )
@@ -117,3 +119,301 @@ func TestReadMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestWriteWithOptions(t *testing.T) {
+	msg := "INVITE sip:bob@biloxi.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds\r\n" +
+		"To: Bob <sip:bob@biloxi.com>\r\n" +
+		"From: Alice <sip:alice@atlanta.com>;tag=1928301774\r\n" +
+		"Call-ID: a84b4c76e66710@pc33.atlanta.com\r\n" +
+		"CSeq: 314159 INVITE\r\n" +
+		"User-Agent: softphone/1.0\r\n" +
+		"Content-Length: 4\r\n\r\n" +
+		"v=0\n"
+
+	m, err := ReadMessage(bufio.NewReader(strings.NewReader(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := &WriteOptions{Compact: true, Strip: OptionalHeaders}
+	size := m.GetSize(options)
+
+	var buffer bytes.Buffer
+	if err = m.WriteWithOptions(&buffer, options); err != nil {
+		t.Fatal(err)
+	}
+	out := buffer.String()
+
+	if size != len(out) {
+		t.Errorf("GetSize = %d, written %d bytes", size, len(out))
+	}
+	if strings.Contains(out, "User-Agent") {
+		t.Errorf("optional header not stripped:\n%s", out)
+	}
+	for _, line := range []string{"v: ", "t: ", "f: ", "i: ", "l: 4"} {
+		if !strings.Contains(out, "\r\n"+line) {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+	if size >= MTU_THRESHOLD {
+		t.Errorf("size %d unexpectedly above MTU threshold", size)
+	}
+}
+
+func TestReadCompactHeaders(t *testing.T) {
+	msg := "INVITE sip:bob@biloxi.com SIP/2.0\r\n" +
+		"v: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds\r\n" +
+		"t: Bob <sip:bob@biloxi.com>\r\n" +
+		"F: Alice <sip:alice@atlanta.com>;tag=1928301774\r\n" +
+		"i: a84b4c76e66710@pc33.atlanta.com\r\n" +
+		"CSeq: 314159 INVITE\r\n" +
+		"m: <sip:alice@pc33.atlanta.com>\r\n" +
+		"k: timer\r\n" +
+		"s: lunch\r\n" +
+		"c: application/sdp\r\n" +
+		"e: identity\r\n" +
+		"l: 4\r\n\r\n" +
+		"v=0\n"
+
+	m, err := ReadMessage(bufio.NewReader(strings.NewReader(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := m.GetHeader()
+	for name, want := range map[string]string{
+		"Via":              "SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds",
+		"To":               "Bob <sip:bob@biloxi.com>",
+		"From":             "Alice <sip:alice@atlanta.com>;tag=1928301774",
+		"Call-Id":          "a84b4c76e66710@pc33.atlanta.com",
+		"Contact":          "<sip:alice@pc33.atlanta.com>",
+		"Supported":        "timer",
+		"Subject":          "lunch",
+		"Content-Type":     "application/sdp",
+		"Content-Encoding": "identity",
+		"Content-Length":   "4",
+	} {
+		if got := h.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if body := string(m.GetBodyBytes()); body != "v=0\n" {
+		t.Errorf("body %q", body)
+	}
+
+	var buffer bytes.Buffer
+	if err = m.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	if out := buffer.String(); !strings.Contains(out, "\r\nFrom: Alice") || strings.Contains(out, "\r\nf: ") {
+		t.Errorf("compact form written:\n%s", out)
+	}
+}
+
+func TestTypedBody(t *testing.T) {
+	sdp := []byte("v=0\r\n")
+
+	req := NewRequest(INVITE, "sip:bob@biloxi.com", NewSDPBody(sdp))
+	if got := req.GetHeader().Get("Content-Type"); got != CONTENTTYPE_SDP {
+		t.Errorf("Content-Type = %q, want %q", got, CONTENTTYPE_SDP)
+	}
+	if got := req.GetContentLength(); got != int64(len(sdp)) {
+		t.Errorf("Content-Length = %d, want %d", got, len(sdp))
+	}
+
+	req = NewRequest(INVITE, "sip:bob@biloxi.com", nil)
+	req.GetHeader().Set("Content-Type", "text/plain")
+	req.SetBody(NewSDPBody(sdp))
+
+	var buffer bytes.Buffer
+	if err := req.Write(&buffer); err != ErrContentTypeConflict {
+		t.Errorf("Write = %v, want %v", err, ErrContentTypeConflict)
+	}
+}
+
+func TestBodyRewritten(t *testing.T) {
+	sdp := NewSDPBody([]byte("v=0\r\n"))
+	req := NewRequest(INVITE, "sip:bob@biloxi.com", sdp)
+	other := NewRequest(INVITE, "sip:carol@chicago.com", sdp)
+
+	//written twice, as retransmitted, and read meanwhile
+	var first, second bytes.Buffer
+	if err := req.Write(&first); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(req.GetBody()); string(b) != "v=0\r\n" {
+		t.Errorf("body read %q", b)
+	}
+	if err := req.Write(&second); err != nil {
+		t.Fatal(err)
+	}
+	if first.String() != second.String() || !strings.HasSuffix(second.String(), "\r\n\r\nv=0\r\n") {
+		t.Errorf("written %q, then %q", first.String(), second.String())
+	}
+	if string(other.GetBodyBytes()) != "v=0\r\n" {
+		t.Errorf("body shared with another message %q", other.GetBodyBytes())
+	}
+
+	req.SetBodyBytes(nil)
+	if req.GetBody() != nil || req.GetContentLength() != 0 {
+		t.Error("body left")
+	}
+}
+
+func TestTypedHeaders(t *testing.T) {
+	msg, err := ReadMessage(bufio.NewReader(strings.NewReader("INVITE sip:bob@biloxi.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds, SIP/2.0/TCP 192.0.2.1:5070;branch=z9hG4bKnashds8\r\n" +
+		"From: Alice <sip:alice@atlanta.com>;tag=1928301774\r\n" +
+		"To: <sip:bob@biloxi.com>\r\n" +
+		"Call-ID: a84b4c76e66710@pc33.atlanta.com\r\n" +
+		"CSeq: 314159 INVITE\r\n" +
+		"Content-Length: 0\r\n\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vias := msg.GetVia()
+	if len(vias) != 2 || vias[0].GetBranch() != "z9hG4bK776asdhds" || vias[1].GetTransport() != "TCP" || vias[1].GetPort() != 5070 {
+		t.Errorf("Via = %v", vias)
+	}
+	if from := msg.GetFrom(); from == nil || from.GetTag() != "1928301774" || from.GetDisplayName() != "Alice" {
+		t.Errorf("From = %v", from)
+	}
+	if to := msg.GetTo(); to == nil || to.HasTag() {
+		t.Errorf("To = %v", to)
+	}
+	if cseq := msg.GetCSeq(); cseq == nil || cseq.GetSequenceNumber() != 314159 || cseq.GetMethod() != INVITE {
+		t.Errorf("CSeq = %v", cseq)
+	}
+	if callId := msg.GetCallID(); callId == nil || callId.GetCallId() != "a84b4c76e66710@pc33.atlanta.com" {
+		t.Errorf("Call-ID = %v", callId)
+	}
+
+	//the parse is reused until the Header map changes
+	if msg.GetFrom() != msg.GetFrom() {
+		t.Error("From parsed twice")
+	}
+	msg.GetHeader().Set("Cseq", "2 BYE")
+	if cseq := msg.GetCSeq(); cseq == nil || cseq.GetSequenceNumber() != 2 || cseq.GetMethod() != BYE {
+		t.Errorf("CSeq after edit = %v", cseq)
+	}
+	msg.GetHeader().Set("To", "not an address <")
+	if to := msg.GetTo(); to != nil {
+		t.Errorf("malformed To = %v", to)
+	}
+
+	//the setters write the Header map
+	from := msg.GetFrom()
+	from.SetTag("a6c85cf")
+	msg.SetFrom(from)
+	if v := msg.GetHeader().Get("From"); !strings.Contains(v, "tag=a6c85cf") {
+		t.Errorf("From header = %q", v)
+	}
+	msg.SetVia(vias[1:])
+	if v := msg.GetHeader()["Via"]; len(v) != 1 || !strings.Contains(v[0], "z9hG4bKnashds8") {
+		t.Errorf("Via header = %q", v)
+	}
+	msg.SetCallID(nil)
+	if _, ok := msg.GetHeader()["Call-Id"]; ok || msg.GetCallID() != nil {
+		t.Error("Call-ID not removed")
+	}
+}
+
+func TestHeaderOrder(t *testing.T) {
+	const raw = "SIP/2.0 180 Ringing\r\n" +
+		"v: SIP/2.0/UDP server10.biloxi.com;branch=z9hG4bK4b43c2ff8.1\r\n" +
+		"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK776asdhds\r\n" +
+		"To: Bob <sip:bob@biloxi.com>;tag=a6c85cf\r\n" +
+		"From: Alice <sip:alice@atlanta.com>;tag=1928301774\r\n" +
+		"Call-ID: a84b4c76e66710\r\n" +
+		"X-Trace: 1\r\n" +
+		"CSeq: 314159 INVITE\r\n" +
+		"X-Trace: 2\r\n" +
+		"Content-Length: 0\r\n\r\n"
+	msg, err := ReadMessage(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//what was parsed is written back field by field, in order, compact
+	//names in their long form
+	var b bytes.Buffer
+	if err := msg.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Replace(raw, "v: ", "Via: ", 1); b.String() != want {
+		t.Errorf("written\n%s\nwant\n%s", b.String(), want)
+	}
+
+	//a value added to the map follows the last field of its name
+	msg.GetHeader().Add("X-Trace", "3")
+	b.Reset()
+	if err := msg.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Replace(raw, "v: ", "Via: ", 1); b.String() != strings.Replace(want, "X-Trace: 2\r\n", "X-Trace: 2\r\nX-Trace: 3\r\n", 1) {
+		t.Errorf("written\n%s", b.String())
+	}
+
+	//typed headers are added after the others, the names only set on the
+	//map after those
+	msg.RemoveHeader("x-trace")
+	maxForwards := header.NewMaxForwards()
+	maxForwards.SetMaxForwards(70)
+	msg.AddHeader(maxForwards)
+	msg.GetHeader().Set("Allow", "INVITE, ACK")
+	if names := strings.Join(msg.GetHeaderNames(), " "); names != "Via To From Call-ID CSeq Content-Length Max-Forwards Allow" {
+		t.Errorf("names = %s", names)
+	}
+
+	headers, err := msg.GetHeaders("Max-Forwards")
+	if err != nil || len(headers) != 1 || headers[0].(*header.MaxForwards).GetMaxForwards() != 70 {
+		t.Errorf("Max-Forwards = %v, %v", headers, err)
+	}
+	if headers, err = msg.GetHeaders("X-Trace"); err != nil || len(headers) != 0 {
+		t.Errorf("removed X-Trace = %v, %v", headers, err)
+	}
+}
+
+func TestReadMessageFraming(t *testing.T) {
+	const head = "MESSAGE sip:bob@biloxi.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP 192.0.2.2;branch=z9hG4bKfr1\r\n" +
+		"CSeq: 1 MESSAGE\r\n"
+	read := func(s string, framing framing) (string, error) {
+		msg, err := readMessage(bufio.NewReader(strings.NewReader(s)), framing)
+		if err != nil {
+			return "", err
+		}
+		var body bytes.Buffer
+		if r := msg.GetBody(); r != nil {
+			body.ReadFrom(r)
+		}
+		if msg.GetContentLength() != int64(body.Len()) {
+			t.Errorf("Content-Length %d for the body %q", msg.GetContentLength(), body.String())
+		}
+		return body.String(), nil
+	}
+
+	//a datagram without Content-Length has the rest of it as body, one
+	//with a shorter one is cut
+	if body, err := read(head+"\r\nhello", framingDatagram); err != nil || body != "hello" {
+		t.Errorf("datagram body %q, %v", body, err)
+	}
+	if body, err := read(head+"Content-Length: 4\r\n\r\nhello", framingDatagram); err != nil || body != "hell" {
+		t.Errorf("datagram body %q, %v", body, err)
+	}
+	if _, err := read(head+"Content-Length: 6\r\n\r\nhello", framingDatagram); err != ErrContentLengthExceeded {
+		t.Errorf("datagram shorter than its Content-Length read with %v", err)
+	}
+
+	//a stream needs one
+	if _, err := read(head+"\r\nhello", framingStream); err != ErrMissingContentLength {
+		t.Errorf("stream without Content-Length read with %v", err)
+	}
+	if body, err := read(head+"Content-Length: 5\r\n\r\nhello", framingStream); err != nil || body != "hello" {
+		t.Errorf("stream body %q, %v", body, err)
+	}
+	if body, err := read(head+"\r\nhello", framingNone); err != nil || body != "" {
+		t.Errorf("body %q, %v", body, err)
+	}
+}
@@ -0,0 +1,257 @@
+package sip
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// A provider reports to its Metrics, as counters and gauges in the manner
+// of Prometheus: the messages it sends and receives, by method and status
+// class; the messages it could not parse; the retransmissions and
+// timeouts of its transactions; how many transactions and dialogs it
+// has; and the state of the peers in its PeerHealth. A provider has no Metrics by default; PrometheusMetrics exports them
+// in the Prometheus text format, and shows how to adapt them to another
+// client library.
+
+////////////////////Interface//////////////////////////////
+
+// Metrics are called on the goroutines of a provider, and should not
+// block.
+type Metrics interface {
+	// AddMessage counts a message sent or received: a request with class
+	// 0, a response with the class of its status code, 1 to 6, and the
+	// method of its CSeq.
+	AddMessage(direction WireDirection, method string, class int)
+
+	// AddParseFailure counts a message received over network which could
+	// not be parsed.
+	AddParseFailure(network string)
+
+	// AddRetransmission counts a retransmission, of a request by a client
+	// transaction or of a response by a server transaction of method.
+	AddRetransmission(method string)
+
+	// AddTimeout counts a transaction of method which timed out.
+	AddTimeout(method string)
+
+	// AddTransactions and AddDialogs change the gauges of the transactions
+	// and dialogs the provider has by delta.
+	AddTransactions(delta int)
+	AddDialogs(delta int)
+
+	// SetPeerState sets the gauge of the state of peer, a destination
+	// "host:port", as the PeerHealth of the provider last saw it.
+	SetPeerState(peer string, state PeerState)
+}
+
+////////////////////Implementation////////////////////////
+
+type nilMetrics struct {
+}
+
+func (this nilMetrics) AddMessage(direction WireDirection, method string, class int) {
+}
+func (this nilMetrics) AddParseFailure(network string) {
+}
+func (this nilMetrics) AddRetransmission(method string) {
+}
+func (this nilMetrics) AddTimeout(method string) {
+}
+func (this nilMetrics) AddTransactions(delta int) {
+}
+func (this nilMetrics) AddDialogs(delta int) {
+}
+func (this nilMetrics) SetPeerState(peer string, state PeerState) {
+}
+
+// PrometheusMetrics keep the Metrics of providers, to be scraped in the
+// Prometheus text format from its ServeHTTP, or written by WriteTo.
+type PrometheusMetrics struct {
+	mutex           sync.Mutex
+	messages        map[string]uint64
+	parseFailures   map[string]uint64
+	retransmissions map[string]uint64
+	timeouts        map[string]uint64
+	transactions    int
+	dialogs         int
+	peers           map[string]PeerState
+}
+
+func NewPrometheusMetrics() *PrometheusMetrics {
+	this := &PrometheusMetrics{}
+
+	this.messages = make(map[string]uint64)
+	this.parseFailures = make(map[string]uint64)
+	this.retransmissions = make(map[string]uint64)
+	this.timeouts = make(map[string]uint64)
+	this.peers = make(map[string]PeerState)
+
+	return this
+}
+
+func (this *PrometheusMetrics) AddMessage(direction WireDirection, method string, class int) {
+	labels := fmt.Sprintf(`direction=%q,method=%q`, direction.String(), method)
+	if class > 0 {
+		labels += fmt.Sprintf(`,class="%dxx"`, class)
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.messages[labels]++
+}
+
+func (this *PrometheusMetrics) AddParseFailure(network string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.parseFailures[fmt.Sprintf(`network=%q`, strings.ToLower(network))]++
+}
+
+func (this *PrometheusMetrics) AddRetransmission(method string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.retransmissions[fmt.Sprintf(`method=%q`, method)]++
+}
+
+func (this *PrometheusMetrics) AddTimeout(method string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.timeouts[fmt.Sprintf(`method=%q`, method)]++
+}
+
+func (this *PrometheusMetrics) AddTransactions(delta int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.transactions += delta
+}
+
+func (this *PrometheusMetrics) AddDialogs(delta int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.dialogs += delta
+}
+
+func (this *PrometheusMetrics) SetPeerState(peer string, state PeerState) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.peers[peer] = state
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (this *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+
+	this.mutex.Lock()
+	writePrometheusCounter(&b, "sip_messages_total", "SIP messages sent and received.", this.messages)
+	writePrometheusCounter(&b, "sip_parse_failures_total", "SIP messages received which could not be parsed.", this.parseFailures)
+	writePrometheusCounter(&b, "sip_retransmissions_total", "Retransmissions of SIP transactions.", this.retransmissions)
+	writePrometheusCounter(&b, "sip_transaction_timeouts_total", "SIP transactions which timed out.", this.timeouts)
+	fmt.Fprintf(&b, "# HELP sip_transactions SIP transactions in progress.\n# TYPE sip_transactions gauge\nsip_transactions %d\n", this.transactions)
+	fmt.Fprintf(&b, "# HELP sip_dialogs SIP dialogs in progress.\n# TYPE sip_dialogs gauge\nsip_dialogs %d\n", this.dialogs)
+	writePrometheusPeers(&b, this.peers)
+	this.mutex.Unlock()
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (this *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	this.WriteTo(w)
+}
+
+// writePrometheusCounter writes the counter name, by label set in order.
+func writePrometheusCounter(b *strings.Builder, name, help string, values map[string]uint64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	labels := make([]string, 0, len(values))
+	for l := range values {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		fmt.Fprintf(b, "%s{%s} %d\n", name, l, values[l])
+	}
+}
+
+// writePrometheusPeers writes the gauge of the peer states, by peer in
+// order.
+func writePrometheusPeers(b *strings.Builder, peers map[string]PeerState) {
+	fmt.Fprintf(b, "# HELP sip_peer_state State of the SIP peers: 0 up, 1 degraded, 2 down, 3 probing.\n# TYPE sip_peer_state gauge\n")
+	names := make([]string, 0, len(peers))
+	for peer := range peers {
+		names = append(names, peer)
+	}
+	sort.Strings(names)
+	for _, peer := range names {
+		fmt.Fprintf(b, "sip_peer_state{peer=%q} %d\n", peer, peers[peer])
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// GetMetrics returns the Metrics of the provider, or nil.
+func (this *provider) GetMetrics() Metrics {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.exported
+}
+
+// SetMetrics sets what the provider reports its metrics to, nothing by
+// default. The gauges start from the transactions and dialogs it has.
+func (this *provider) SetMetrics(metrics Metrics) {
+	this.mutex.Lock()
+	transactions, dialogs := len(this.clients)+len(this.servers), len(this.dialogs)
+	this.exported = metrics
+	this.mutex.Unlock()
+
+	if metrics != nil {
+		metrics.AddTransactions(transactions)
+		metrics.AddDialogs(dialogs)
+	}
+}
+
+// getMetrics returns the Metrics of the provider, which discard everything
+// if it has none.
+func (this *provider) getMetrics() Metrics {
+	if metrics := this.GetMetrics(); metrics != nil {
+		return metrics
+	}
+	return nilMetrics{}
+}
+
+// isParseError reports whether err, which ended the reading of a stream,
+// comes from a malformed message rather than from the connection.
+func isParseError(err error) bool {
+	if _, ok := err.(net.Error); ok {
+		return false
+	}
+	return err != io.EOF && !errors.Is(err, net.ErrClosed) && !errors.Is(err, syscall.ECONNRESET)
+}
+
+// countMessage counts msg, sent or received.
+func (this *provider) countMessage(direction WireDirection, msg Message) {
+	metrics := this.GetMetrics()
+	if metrics == nil {
+		return
+	}
+	switch m := msg.(type) {
+	case Request:
+		metrics.AddMessage(direction, m.GetMethod(), 0)
+	case Response:
+		metrics.AddMessage(direction, getCSeqMethod(m), m.GetStatusCode()/100)
+	}
+}
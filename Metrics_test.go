@@ -0,0 +1,94 @@
+package sip
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type answeringListener struct {
+	serverListener
+}
+
+func (this *answeringListener) ProcessRequest(requestEvent RequestEvent) {
+	requestEvent.GetServerTransaction().SendResponse(CreateResponse(requestEvent.GetRequest(), OK))
+}
+
+// waitMetrics waits for the metrics to show each of lines.
+func waitMetrics(t *testing.T, m *PrometheusMetrics, lines ...string) {
+	var b strings.Builder
+	for i := 0; ; i++ {
+		b.Reset()
+		m.WriteTo(&b)
+		missing := ""
+		for _, line := range lines {
+			if !strings.Contains(b.String(), line+"\n") {
+				missing = line
+				break
+			}
+		}
+		if missing == "" {
+			return
+		}
+		if i == 100 {
+			t.Fatalf("metrics\n%s\nwithout %s", b.String(), missing)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	p, server, _ := newListeningProvider(t)
+	m := NewPrometheusMetrics()
+	p.SetMetrics(m)
+	p.AddListener(&answeringListener{})
+	go p.Run()
+	defer p.Stop()
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.WriteTo([]byte("garbage\r\n\r\n"), server)
+	client.WriteTo([]byte("OPTIONS sip:bob@127.0.0.1 SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP "+client.LocalAddr().String()+";branch=z9hG4bKmetrics1\r\n"+
+		"From: <sip:alice@example.com>;tag=m1\r\n"+
+		"To: <sip:bob@example.com>\r\n"+
+		"Call-ID: metrics1@example.com\r\n"+
+		"CSeq: 1 OPTIONS\r\n"+
+		"Content-Length: 0\r\n\r\n"), server)
+
+	//the server transaction lingers for Timer J
+	waitMetrics(t, m,
+		`sip_messages_total{direction="in",method="OPTIONS"} 1`,
+		`sip_messages_total{direction="out",method="OPTIONS",class="2xx"} 1`,
+		`sip_parse_failures_total{network="udp"} 1`,
+		`sip_transactions 1`,
+		`sip_dialogs 0`,
+	)
+}
+
+func TestTransactionMetricsExported(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	m := NewPrometheusMetrics()
+	p.SetMetrics(m)
+
+	options := newServerTestRequest(OPTIONS, "UDP", "z9hG4bKmetrics2")
+	ct := p.GetNewClientTransaction(options).(*clientTransaction)
+	ct.hops = []Hop{NewHop("192.0.2.10", 5060, UDP)}
+	ct.start()
+	clock.Advance(TIMER_T1)
+	waitMetrics(t, m, `sip_retransmissions_total{method="OPTIONS"} 1`, `sip_transactions 1`)
+
+	clock.Advance(64 * TIMER_T1)
+	waitMetrics(t, m, `sip_transaction_timeouts_total{method="OPTIONS"} 1`, `sip_transactions 0`)
+	if p.GetMetrics() != m {
+		t.Error("GetMetrics")
+	}
+}
@@ -0,0 +1,156 @@
+package sip
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// A multipart body carries several bodies in one message (RFC 5621), e.g.
+// a session description along with a location object, each part with a
+// header of its own telling its Content-Type and Content-Disposition. A
+// session description part of a multipart/mixed body takes part in the
+// offer/answer of its dialog as a whole session description body does.
+
+const CONTENTTYPE_MULTIPART_MIXED = "multipart/mixed"
+
+var ErrNotMultipart = errors.New("sip: not a multipart body")
+
+////////////////////Interface//////////////////////////////
+
+// A BodyPart is a part of a multipart body: its header, with its
+// Content-Type and Content-Disposition among others, and its content.
+type BodyPart struct {
+	Header Header
+	Body   []byte
+}
+
+// A MultipartBody is a multipart/mixed TypedBody made of parts, separated
+// by the boundary its Content-Type tells.
+type MultipartBody interface {
+	TypedBody
+	GetParts() []*BodyPart
+}
+
+////////////////////Implementation////////////////////////
+
+// NewBodyPart returns a part of contentType and contentDisposition, which
+// may be empty.
+func NewBodyPart(contentType, contentDisposition string, body []byte) *BodyPart {
+	this := &BodyPart{Header: make(Header), Body: body}
+
+	if contentType != "" {
+		this.Header.Set("Content-Type", contentType)
+	}
+	if contentDisposition != "" {
+		this.Header.Set("Content-Disposition", contentDisposition)
+	}
+
+	return this
+}
+
+func (this *BodyPart) GetContentType() string {
+	return this.Header.Get("Content-Type")
+}
+
+func (this *BodyPart) GetContentDisposition() string {
+	return this.Header.Get("Content-Disposition")
+}
+
+type multipartBody struct {
+	*bytes.Reader
+
+	body        []byte
+	contentType string
+	parts       []*BodyPart
+}
+
+// NewMultipartBody returns a multipart/mixed body of parts, with a random
+// boundary.
+func NewMultipartBody(parts ...*BodyPart) MultipartBody {
+	this := &multipartBody{}
+
+	var buffer bytes.Buffer
+	w := multipart.NewWriter(&buffer)
+	w.SetBoundary(randomHex(16))
+	for _, part := range parts {
+		h := make(textproto.MIMEHeader)
+		for key, values := range part.Header {
+			h[key] = append([]string(nil), values...)
+		}
+		pw, _ := w.CreatePart(h)
+		pw.Write(part.Body)
+	}
+	w.Close()
+
+	this.body = buffer.Bytes()
+	this.Reader = bytes.NewReader(this.body)
+	this.contentType = CONTENTTYPE_MULTIPART_MIXED + ";boundary=" + w.Boundary()
+	this.parts = parts
+
+	return this
+}
+
+func (this *multipartBody) GetContentType() string {
+	return this.contentType
+}
+
+func (this *multipartBody) GetContentDisposition() string {
+	return ""
+}
+
+func (this *multipartBody) GetParts() []*BodyPart {
+	return this.parts
+}
+
+// Bytes returns the whole body, so that it can be set on several messages.
+func (this *multipartBody) Bytes() []byte {
+	return this.body
+}
+
+// GetBodyParts returns the parts of the multipart body of msg, or
+// ErrNotMultipart.
+func GetBodyParts(msg Message) ([]*BodyPart, error) {
+	return ParseBodyParts(msg.GetHeader().Get("Content-Type"), msg.GetBodyBytes())
+}
+
+// ParseBodyParts returns the parts of body, a multipart body of
+// contentType; a part which is a multipart body itself is parsed the same.
+func ParseBodyParts(contentType string, body []byte) ([]*BodyPart, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !isMultipart(mediaType) || params["boundary"] == "" {
+		return nil, ErrNotMultipart
+	}
+
+	var parts []*BodyPart
+	r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		p, err := r.NextRawPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		part := &BodyPart{Header: make(Header)}
+		for key, values := range p.Header {
+			for _, v := range values {
+				part.Header.Add(key, v)
+			}
+		}
+		if part.Body, err = ioutil.ReadAll(p); err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+}
+
+// isMultipart reports whether mediaType is a multipart one.
+func isMultipart(mediaType string) bool {
+	return strings.HasPrefix(strings.ToLower(mediaType), "multipart/")
+}
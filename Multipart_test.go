@@ -0,0 +1,51 @@
+package sip
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestMultipartBody(t *testing.T) {
+	sdp := []byte("v=0\r\no=alice 2890844526 2890844526 IN IP4 192.0.2.1\r\n")
+	pidf := []byte("<presence/>")
+	req := NewRequest(INVITE, "sip:bob@biloxi.com", NewMultipartBody(
+		NewBodyPart(CONTENTTYPE_SDP, "session", sdp),
+		NewBodyPart(CONTENTTYPE_PIDF, "render;handling=optional", pidf),
+	))
+	req.GetHeader().Set("Call-Id", "multipart1")
+	if v := req.GetHeader().Get("Content-Type"); !isMultipart(mediaToken(v)) {
+		t.Fatalf("Content-Type %q", v)
+	}
+
+	var buffer bytes.Buffer
+	if err := req.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := ReadMessage(bufio.NewReader(&buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts, err := GetBodyParts(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("%d parts", len(parts))
+	}
+	if parts[0].GetContentType() != CONTENTTYPE_SDP || parts[0].GetContentDisposition() != "session" || !bytes.Equal(parts[0].Body, sdp) {
+		t.Errorf("first part %v %q", parts[0].Header, parts[0].Body)
+	}
+	if parts[1].GetContentType() != CONTENTTYPE_PIDF || parts[1].GetContentDisposition() != "render;handling=optional" || !bytes.Equal(parts[1].Body, pidf) {
+		t.Errorf("second part %v %q", parts[1].Header, parts[1].Body)
+	}
+
+	//the session description takes part in offer/answer
+	if !bytes.Equal(readSDP(msg), sdp) {
+		t.Errorf("session description %q", readSDP(msg))
+	}
+
+	if _, err := GetBodyParts(NewRequest(INVITE, "sip:bob@biloxi.com", NewSDPBody(sdp))); err != ErrNotMultipart {
+		t.Errorf("parts of a session description: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package sip
+
+import (
+	"strings"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A Normalizer cleans up the messages a provider sends, once the provider
+// stamped them and just before they are serialized, so that the traffic
+// is the same however the application built them: the headers of
+// HeaderOrder are written first, the empty ones dropped, the duplicate
+// option tags collapsed, and the defaults a request or a body requires
+// set. The zero value changes nothing; DefaultNormalizer does it all.
+type Normalizer struct {
+	// HeaderOrder lists the headers written first, in order; the others
+	// follow in the order they were added.
+	HeaderOrder []string
+
+	// StripEmpty removes the headers without a value.
+	StripEmpty bool
+
+	// CollapseTokens merges the values of the option tag headers,
+	// Supported, Require, Proxy-Require and Unsupported, into one, without
+	// the tags listed twice.
+	CollapseTokens bool
+
+	// SetDefaults adds Max-Forwards: 70 to the requests without one, and
+	// sets the Content-Length to the length of the body.
+	SetDefaults bool
+}
+
+// The headers of the routing of a message, written first by
+// DefaultNormalizer, then those identifying its transaction and dialog
+// (RFC 3261 7.3.1 recommends them early, for proxies to find quickly).
+var NORMALIZED_HEADER_ORDER = []string{"Via", "Route", "Record-Route", "Max-Forwards", "From", "To", "Call-Id", "Cseq", "Contact"}
+
+var DefaultNormalizer = Normalizer{
+	HeaderOrder:    NORMALIZED_HEADER_ORDER,
+	StripEmpty:     true,
+	CollapseTokens: true,
+	SetDefaults:    true,
+}
+
+// the headers CollapseTokens merges
+var optionTagHeaders = []string{"Supported", "Require", "Proxy-Require", "Unsupported"}
+
+////////////////////Implementation////////////////////////
+
+// IsEnabled reports whether the normalizer changes anything.
+func (this Normalizer) IsEnabled() bool {
+	return len(this.HeaderOrder) > 0 || this.StripEmpty || this.CollapseTokens || this.SetDefaults
+}
+
+// Normalize cleans msg up as the normalizer says.
+func (this Normalizer) Normalize(msg Message) {
+	h := msg.GetHeader()
+	if this.StripEmpty {
+		for _, name := range msg.GetHeaderNames() {
+			if strings.TrimSpace(strings.Join(h.Values(name), "")) == "" {
+				msg.RemoveHeader(name)
+			}
+		}
+	}
+	if this.CollapseTokens {
+		for _, name := range optionTagHeaders {
+			values := h.Values(name)
+			if len(values) == 0 {
+				continue
+			}
+			var tags []string
+			for _, value := range values {
+				for _, tag := range strings.Split(value, ",") {
+					if tag = strings.TrimSpace(tag); tag != "" {
+						tags = append(tags, tag)
+					}
+				}
+			}
+			h.Set(name, strings.Join(mergeTokens(nil, tags), ", "))
+		}
+	}
+	if this.SetDefaults {
+		if _, ok := msg.(Request); ok && h.Get("Max-Forwards") == "" {
+			h.Set("Max-Forwards", "70")
+		}
+		msg.SetContentLength(int64(len(msg.GetBodyBytes())))
+	}
+	if m, ok := msg.(interface{ orderHeaders([]string) }); ok && len(this.HeaderOrder) > 0 {
+		m.orderHeaders(this.HeaderOrder)
+	}
+}
+
+// orderHeaders puts the header fields of the names of first the message
+// has in front of the others, which keep their order.
+func (this *message) orderHeaders(first []string) {
+	ordered := make([]string, 0, len(this.names))
+	moved := make(map[string]bool, len(first))
+	for _, name := range first {
+		key := CanonicalHeaderKey(name)
+		if _, ok := this.header[key]; !ok || moved[key] {
+			continue
+		}
+		moved[key] = true
+		n := len(ordered)
+		for _, field := range this.names {
+			if CanonicalHeaderKey(field) == key {
+				ordered = append(ordered, field)
+			}
+		}
+		if len(ordered) == n {
+			//only set on the Header map
+			ordered = append(ordered, key)
+		}
+	}
+	for _, field := range this.names {
+		if !moved[CanonicalHeaderKey(field)] {
+			ordered = append(ordered, field)
+		}
+	}
+	this.names = ordered
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (this *provider) GetNormalizer() Normalizer {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.normalizer
+}
+
+// SetNormalizer sets how the messages sent are cleaned up, not at all by
+// default; see DefaultNormalizer.
+func (this *provider) SetNormalizer(normalizer Normalizer) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.normalizer = normalizer
+}
+
+func (this *provider) normalize(msg Message) {
+	if normalizer := this.GetNormalizer(); normalizer.IsEnabled() {
+		normalizer.Normalize(msg)
+	}
+}
@@ -0,0 +1,73 @@
+package sip
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizer(t *testing.T) {
+	req := NewRequest(OPTIONS, "sip:bob@example.com", nil)
+	h := req.GetHeader()
+	h.Set("Supported", "timer, 100rel")
+	h.Set("Call-ID", "n1@example.com")
+	h.Set("To", "<sip:bob@example.com>")
+	h.Set("From", "<sip:alice@example.com>;tag=n1")
+	h.Set("Subject", " ")
+	h.Set("Cseq", "1 OPTIONS")
+	h.Add("Supported", "Timer,path")
+	h.Set("Via", "SIP/2.0/UDP 192.0.2.1;branch=z9hG4bKnorm1")
+	req.SetBodyBytes([]byte("hello"))
+	req.SetContentLength(2)
+
+	DefaultNormalizer.Normalize(req)
+	var buf bytes.Buffer
+	if err := req.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\r\n")
+	want := []string{
+		"OPTIONS sip:bob@example.com SIP/2.0",
+		"Via: SIP/2.0/UDP 192.0.2.1;branch=z9hG4bKnorm1",
+		"Max-Forwards: 70",
+		"From: <sip:alice@example.com>;tag=n1",
+		"To: <sip:bob@example.com>",
+		"Call-Id: n1@example.com",
+		"Cseq: 1 OPTIONS",
+		"Supported: timer, 100rel, path",
+		"Content-Length: 5",
+		"",
+		"hello",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("normalized into\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	//a response gets no Max-Forwards
+	resp := CreateResponse(req, OK)
+	DefaultNormalizer.Normalize(resp)
+	if resp.GetHeader().Get("Max-Forwards") != "" {
+		t.Error("Max-Forwards added to a response")
+	}
+	if (Normalizer{}).IsEnabled() || !DefaultNormalizer.IsEnabled() {
+		t.Error("IsEnabled")
+	}
+}
+
+func TestProviderNormalizer(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	sent := captureSends(p)
+	p.SetNormalizer(Normalizer{StripEmpty: true})
+
+	req := newServerTestRequest(OPTIONS, "UDP", "z9hG4bKnorm2")
+	req.GetHeader().Set("Subject", "")
+	resp := CreateResponse(req, OK)
+	resp.GetHeader().Set("Warning", "")
+	if err := p.SendResponse(resp); err != nil {
+		t.Fatal(err)
+	}
+	if h := sent.last().GetHeader(); h.Get("Warning") != "" || len(h.Values("Warning")) != 0 {
+		t.Errorf("empty Warning sent: %q", h.Values("Warning"))
+	}
+}
@@ -0,0 +1,166 @@
+package sip
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// The offer/answer model (RFC 3264) allows one offer at a time in a
+// session: an offer made in an INVITE, a reliable provisional response
+// (RFC 3262), an UPDATE (RFC 3311) or a PRACK must be answered, or
+// rejected with its request, before either side makes another one. An
+// offerAnswer follows the session descriptions sent and received within a
+// dialog to enforce it, as summed up in RFC 6337 3.
+
+var (
+	ErrOfferPending     = errors.New("sip: an offer sent is not answered yet")
+	ErrOfferUnanswered  = errors.New("sip: an offer received is not answered yet")
+	ErrUnexpectedAnswer = errors.New("sip: session description with no offer to answer")
+)
+
+// OfferState is where the offers and answers of a dialog stand: no offer
+// is outstanding, an offer sent awaits its answer, or an offer received
+// awaits ours, see Dialog.GetOfferState.
+type OfferState int
+
+const (
+	OFFERSTATE_NONE   OfferState = iota //0
+	OFFERSTATE_LOCAL                    //1
+	OFFERSTATE_REMOTE                   //2
+)
+
+type offerAnswer struct {
+	state OfferState
+
+	//the message carrying the outstanding offer: the method and number of
+	//its CSeq, and whether it is a response, a final one
+	method   string
+	cseq     int
+	response bool
+	final    bool
+
+	//the INVITE whose offer a reliable provisional response answered, to
+	//which the 2xx repeats the answer
+	answered int
+	lastRSeq int
+}
+
+// process runs msg, sent when local is true and received otherwise,
+// through the offer/answer state. A session description which would make
+// a second offer fails with ErrOfferPending while an offer sent is not
+// answered, and ErrOfferUnanswered while one received is not; one in a
+// message which can only carry an answer fails with ErrUnexpectedAnswer.
+// The state is unchanged on failure. A failure response to the request
+// carrying the offer withdraws it.
+func (this *offerAnswer) process(msg Message, local bool) error {
+	method := getCSeqMethod(msg)
+	cseq, _ := getCSeq(msg)
+
+	resp, isResponse := msg.(Response)
+	if isResponse {
+		statusCode := resp.GetStatusCode()
+		switch {
+		case statusCode >= MULTIPLE_CHOICES:
+			if this.state != OFFERSTATE_NONE && !this.response && this.method == method && this.cseq == cseq && (this.state == OFFERSTATE_LOCAL) != local {
+				this.state = OFFERSTATE_NONE
+			}
+			return nil
+		case statusCode < OK:
+			//only reliable provisional responses take part (RFC 3262 5)
+			if statusCode == TRYING || !hasToken(resp.GetHeader().Get("Require"), "100rel") {
+				return nil
+			}
+			if !local {
+				rseq, err := strconv.Atoi(strings.TrimSpace(resp.GetHeader().Get("RSeq")))
+				if err != nil || rseq <= this.lastRSeq {
+					return nil
+				}
+				this.lastRSeq = rseq
+			}
+		}
+	}
+	if readSDP(msg) == nil {
+		return nil
+	}
+
+	if this.isAnswer(msg, method, cseq, local) {
+		if isResponse && method == INVITE && resp.GetStatusCode() < OK {
+			this.answered = cseq
+		}
+		this.state = OFFERSTATE_NONE
+		return nil
+	}
+
+	switch this.state {
+	case OFFERSTATE_LOCAL:
+		return ErrOfferPending
+	case OFFERSTATE_REMOTE:
+		return ErrOfferUnanswered
+	}
+
+	//a new offer, from an INVITE, an UPDATE, a PRACK or a response to an
+	//INVITE without one
+	switch {
+	case isResponse && method == INVITE && cseq == this.answered:
+		//the 2xx repeating the answer of a reliable provisional response
+		return nil
+	case isResponse && method != INVITE, method == ACK:
+		return ErrUnexpectedAnswer
+	}
+	this.state = OFFERSTATE_REMOTE
+	if local {
+		this.state = OFFERSTATE_LOCAL
+	}
+	this.method = method
+	this.cseq = cseq
+	this.response = isResponse
+	this.final = isResponse && resp.GetStatusCode() >= OK
+	return nil
+}
+
+// isAnswer reports whether msg, carrying a session description, answers
+// the outstanding offer: a 2xx to its request, or a reliable provisional
+// response to its INVITE, and for an offer made in a response to an INVITE
+// the PRACK of a provisional one or the ACK of a 2xx.
+func (this *offerAnswer) isAnswer(msg Message, method string, cseq int, local bool) bool {
+	if this.state == OFFERSTATE_NONE || (this.state == OFFERSTATE_LOCAL) == local {
+		return false
+	}
+	if !this.response {
+		resp, ok := msg.(Response)
+		return ok && method == this.method && cseq == this.cseq && (resp.GetStatusCode() < MULTIPLE_CHOICES)
+	}
+	if _, ok := msg.(Request); !ok {
+		return false
+	}
+	if this.final {
+		return method == ACK && cseq == this.cseq
+	}
+	return method == PRACK
+}
+
+// getOfferStatusCode returns the status code rejecting a request whose
+// offer process refused with err: 491 while an offer sent is outstanding,
+// 500 with a Retry-After while one received is (RFC 3311 5.2), 400
+// otherwise.
+func getOfferStatusCode(err error) int {
+	switch err {
+	case ErrOfferPending:
+		return REQUEST_PENDING
+	case ErrOfferUnanswered:
+		return SERVER_INTERNAL_ERROR
+	}
+	return BAD_REQUEST
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// GetOfferState returns where the offers and answers of the dialog stand,
+// for the application to make an offer only once none is outstanding.
+func (this *dialog) GetOfferState() OfferState {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.offers.state
+}
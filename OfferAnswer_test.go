@@ -0,0 +1,143 @@
+package sip
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newOfferRequest(method string, cseq int, sdp bool) Request {
+	req := NewRequest(method, "sip:bob@192.0.2.5", nil)
+	req.GetHeader().Set("Cseq", strconv.Itoa(cseq)+" "+method)
+	if sdp {
+		req.SetBody(NewSDPBody([]byte("v=0\r\n")))
+	}
+	return req
+}
+
+func newOfferResponse(method string, cseq, statusCode, rseq int, sdp bool) Response {
+	resp := NewResponse(statusCode, "", nil)
+	resp.GetHeader().Set("Cseq", strconv.Itoa(cseq)+" "+method)
+	if rseq > 0 {
+		resp.GetHeader().Set("Require", "100rel")
+		resp.GetHeader().Set("RSeq", strconv.Itoa(rseq))
+	}
+	if sdp {
+		resp.SetBody(NewSDPBody([]byte("v=0\r\n")))
+	}
+	return resp
+}
+
+func TestOfferAnswer(t *testing.T) {
+	var oa offerAnswer
+	for i, c := range []struct {
+		msg   Message
+		local bool
+		err   error
+		state OfferState
+	}{
+		//an offer in the INVITE, answered in a reliable 183 repeated by the
+		//200
+		{newOfferRequest(INVITE, 1, true), true, nil, OFFERSTATE_LOCAL},
+		{newOfferResponse(INVITE, 1, RINGING, 0, true), false, nil, OFFERSTATE_LOCAL},
+		{newOfferResponse(INVITE, 1, SESSION_PROGRESS, 1, true), false, nil, OFFERSTATE_NONE},
+		{newOfferResponse(INVITE, 1, SESSION_PROGRESS, 1, true), false, nil, OFFERSTATE_NONE},
+		{newOfferResponse(INVITE, 1, OK, 0, true), false, nil, OFFERSTATE_NONE},
+
+		//an UPDATE offer allows no other one until its 2xx
+		{newOfferRequest(UPDATE, 2, true), true, nil, OFFERSTATE_LOCAL},
+		{newOfferRequest(UPDATE, 7, true), false, ErrOfferPending, OFFERSTATE_LOCAL},
+		{newOfferRequest(UPDATE, 3, true), true, ErrOfferPending, OFFERSTATE_LOCAL},
+		{newOfferResponse(UPDATE, 2, OK, 0, true), false, nil, OFFERSTATE_NONE},
+
+		//nor does an offer received until answered
+		{newOfferRequest(UPDATE, 8, true), false, nil, OFFERSTATE_REMOTE},
+		{newOfferRequest(UPDATE, 3, true), true, ErrOfferUnanswered, OFFERSTATE_REMOTE},
+		{newOfferRequest(UPDATE, 9, true), false, ErrOfferUnanswered, OFFERSTATE_REMOTE},
+		{newOfferResponse(UPDATE, 8, OK, 0, true), true, nil, OFFERSTATE_NONE},
+
+		//a rejected offer is withdrawn
+		{newOfferRequest(UPDATE, 3, true), true, nil, OFFERSTATE_LOCAL},
+		{newOfferResponse(UPDATE, 3, NOT_ACCEPTABLE_HERE, 0, false), false, nil, OFFERSTATE_NONE},
+
+		//a delayed offer comes in the 2xx, answered in the ACK
+		{newOfferRequest(INVITE, 4, false), true, nil, OFFERSTATE_NONE},
+		{newOfferResponse(INVITE, 4, OK, 0, true), false, nil, OFFERSTATE_REMOTE},
+		{newOfferRequest(ACK, 4, true), true, nil, OFFERSTATE_NONE},
+
+		//answers with no offer
+		{newOfferRequest(ACK, 4, true), false, ErrUnexpectedAnswer, OFFERSTATE_NONE},
+		{newOfferResponse(UPDATE, 5, OK, 0, true), false, ErrUnexpectedAnswer, OFFERSTATE_NONE},
+	} {
+		if err := oa.process(c.msg, c.local); err != c.err || oa.state != c.state {
+			t.Errorf("%d: error %v, state %d, want %v, %d", i, err, oa.state, c.err, c.state)
+		}
+	}
+}
+
+func TestOfferAnswerDialog(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	var events []string
+	p.AddListener(&recordingListener{name: "listener", events: &events})
+
+	invite := NewRequest(INVITE, "sip:bob@biloxi.com", NewSDPBody([]byte("v=0\r\n")))
+	h := invite.GetHeader()
+	h.Set("Via", "SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bKoa1")
+	h.Set("From", "Alice <sip:alice@atlanta.com>;tag=1928301774")
+	h.Set("To", "Bob <sip:bob@biloxi.com>")
+	h.Set("Call-Id", "offers@pc33.atlanta.com")
+	h.Set("Cseq", "1 INVITE")
+	h.Set("Contact", "<sip:alice@pc33.atlanta.com>")
+	ct := p.GetNewClientTransaction(invite).(*clientTransaction)
+	ct.hops = []Hop{NewHop("192.0.2.10", 5060, UDP)}
+	ct.start()
+
+	//the offer of the INVITE is still unanswered in the early dialog
+	ringing := CreateResponse(invite, RINGING)
+	ringing.GetHeader().Set("To", "Bob <sip:bob@biloxi.com>;tag=a6c85cf")
+	ringing.GetHeader().Set("Contact", "<sip:bob@192.0.2.5>")
+	p.processResponse(ringing)
+	d := ct.GetDialog().(*dialog)
+
+	update, err := d.CreateRequest(UPDATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update.SetBody(NewSDPBody([]byte("v=0\r\n")))
+	if err := d.SendRequest(p.GetNewClientTransaction(update)); err != ErrOfferPending {
+		t.Errorf("UPDATE with an offer pending: %v, want ErrOfferPending", err)
+	}
+
+	//and an offer received then is refused with 491
+	remote := NewRequest(UPDATE, "sip:alice@pc33.atlanta.com", NewSDPBody([]byte("v=0\r\n")))
+	rh := remote.GetHeader()
+	rh.Set("Via", "SIP/2.0/UDP 192.0.2.5;branch=z9hG4bKoa2")
+	rh.Set("From", "Bob <sip:bob@biloxi.com>;tag=a6c85cf")
+	rh.Set("To", "Alice <sip:alice@atlanta.com>;tag=1928301774")
+	rh.Set("Call-Id", "offers@pc33.atlanta.com")
+	rh.Set("Cseq", "1 UPDATE")
+	rh.Set("Contact", "<sip:bob@192.0.2.5>")
+	events = nil
+	p.processMessage(remote)
+	if resp, ok := sent.last().(Response); !ok || resp.GetStatusCode() != REQUEST_PENDING {
+		t.Errorf("crossing UPDATE answered %v", sent.last())
+	}
+	if len(events) != 0 {
+		t.Errorf("events = %v, want the UPDATE kept from the listeners", events)
+	}
+
+	//once answered, the UPDATE goes through
+	ok200 := CreateResponse(invite, OK)
+	ok200.GetHeader().Set("To", "Bob <sip:bob@biloxi.com>;tag=a6c85cf")
+	ok200.SetBody(NewSDPBody([]byte("v=0\r\n")))
+	p.processResponse(ok200)
+	update, _ = d.CreateRequest(UPDATE)
+	update.SetBody(NewSDPBody([]byte("v=0\r\n")))
+	if err := d.SendRequest(p.GetNewClientTransaction(update)); err != nil {
+		t.Errorf("UPDATE after the answer: %v", err)
+	}
+}
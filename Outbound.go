@@ -0,0 +1,658 @@
+package sip
+
+import (
+	"errors"
+	"math/rand"
+	"sip/header"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Client-initiated connections (outbound, RFC 5626) reach a UA behind a NAT
+// or a firewall over the flows it registered itself. The UA registers the
+// same contact through each of its edge proxies, with the instance ID
+// identifying it (+sip.instance) and a reg-id identifying the flow; the
+// registrar, seeing the REGISTER came directly over a flow, keeps that flow
+// with the binding and answers with Require: outbound. The requests to
+// the contact are then sent over the flow instead of to its URI, and fail
+// with 430 once the flow is gone.
+//
+// The client keeps its flows up with CRLF keep-alives on its connections
+// and STUN keep-alives on its flows over UDP, sent at least as often as the
+// Flow-Timer of the registrar asks, registering again before the bindings
+// expire, and over a new flow as soon as one dies. A flow which failed is tried again after a random 50
+// to 100% of min(OUTBOUND_MAX_TIME, base * 2^failures), the base being
+// OUTBOUND_BASE_TIME_ALL_FAILED while no flow is up, and
+// OUTBOUND_BASE_TIME_OK otherwise (RFC 5626 4.5).
+
+const OPTIONTAG_OUTBOUND = "outbound"
+
+// ErrFlowMoved is the failure of a flow whose keep-alive was answered from
+// another address than the previous ones, a NAT having rebound it.
+var ErrFlowMoved = errors.New("sip: flow mapped to another address")
+
+const (
+	OUTBOUND_BASE_TIME_ALL_FAILED = 30 * time.Second
+	OUTBOUND_BASE_TIME_OK         = 90 * time.Second
+	OUTBOUND_MAX_TIME             = 1800 * time.Second
+
+	// OUTBOUND_EXPIRES is the expiration asked for the flows' bindings.
+	OUTBOUND_EXPIRES = time.Hour
+)
+
+////////////////////Interface//////////////////////////////
+
+// An Outbound registers the contact of a UserAgent over one flow per edge
+// proxy, and keeps the flows up. It must be added to the Provider as a
+// Listener so it can see the responses and timeouts of its REGISTERs.
+type Outbound interface {
+	Listener
+
+	GetInstanceId() string
+	GetFlows() []FlowInfo
+	SetFlowHandler(handler FlowHandler)
+
+	Start()
+	Stop()
+}
+
+type FlowState int
+
+const (
+	FLOWSTATE_REGISTERING FlowState = iota
+	FLOWSTATE_UP
+	FLOWSTATE_FAILED
+)
+
+func (this FlowState) String() string {
+	switch this {
+	case FLOWSTATE_REGISTERING:
+		return "REGISTERING"
+	case FLOWSTATE_UP:
+		return "UP"
+	case FLOWSTATE_FAILED:
+		return "FAILED"
+	}
+	return "UNKNOWN"
+}
+
+// FlowInfo describes a flow of an Outbound.
+type FlowInfo struct {
+	RegId    int
+	Proxy    string //the edge proxy the flow goes through
+	State    FlowState
+	Failures int       //the consecutive failures to register it
+	Expires  time.Time //when its binding expires, once up
+	Outbound bool      //whether the registrar supports outbound
+}
+
+// A FlowHandler is called with a flow whose state changed.
+type FlowHandler func(flow FlowInfo)
+
+////////////////////Implementation////////////////////////
+
+type outboundFlow struct {
+	info FlowInfo
+
+	callId   string
+	cseq     int
+	attempts int //the times the REGISTER was challenged
+
+	//the connection the binding was registered over
+	network string
+	address string
+
+	//the STUN keep-alives of a flow over UDP
+	interval  time.Duration
+	mapped    string //the address the edge proxy saw the last one from
+	keepalive Timer
+
+	timer Timer
+}
+
+type outbound struct {
+	mutex sync.Mutex
+
+	ua         UserAgent
+	provider   Provider
+	instanceId string
+
+	flows   []*outboundFlow
+	pending map[ClientTransaction]*outboundFlow
+
+	handler     FlowHandler
+	deadHandler ConnectionDeadHandler //the handler of the provider before Start
+	started     bool
+}
+
+// GenerateInstanceId returns a new instance ID, a URN holding a random
+// UUID (RFC 5626 4.1), e.g. "urn:uuid:f81d4fae-7dec-41d0-a765-00a0c91e6bf6".
+// A UA keeps its instance ID across restarts.
+func GenerateInstanceId() string {
+	b := []byte(randomHex(16))
+	b[12] = '4'                  //version 4
+	b[16] = "89ab"[int(b[16])%4] //variant 10
+	s := string(b)
+	return "urn:uuid:" + s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// ua is the UserAgent whose contact is registered, instanceId its instance
+// ID, and proxies the URIs of its edge proxies, e.g.
+// "sip:edge1.example.com;transport=tcp", reg-ids 1, 2, ... in order.
+func NewOutbound(ua UserAgent, instanceId string, proxies ...string) Outbound {
+	this := &outbound{}
+
+	this.ua = ua
+	this.provider = ua.GetProvider()
+	this.instanceId = instanceId
+
+	for i, proxy := range proxies {
+		this.flows = append(this.flows, &outboundFlow{
+			info:   FlowInfo{RegId: i + 1, Proxy: proxy, State: FLOWSTATE_REGISTERING},
+			callId: this.provider.GetNewCallId(),
+		})
+	}
+	this.pending = make(map[ClientTransaction]*outboundFlow)
+
+	return this
+}
+
+func (this *outbound) GetInstanceId() string {
+	return this.instanceId
+}
+
+func (this *outbound) GetFlows() []FlowInfo {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	flows := make([]FlowInfo, len(this.flows))
+	for i, f := range this.flows {
+		flows[i] = f.info
+	}
+	return flows
+}
+
+func (this *outbound) SetFlowHandler(handler FlowHandler) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.handler = handler
+}
+
+// Start registers every flow, turning the CRLF keep-alives of the provider
+// on if they are not.
+func (this *outbound) Start() {
+	this.mutex.Lock()
+	if this.started {
+		this.mutex.Unlock()
+		return
+	}
+	this.started = true
+	this.deadHandler = this.provider.GetConnectionDeadHandler()
+	this.mutex.Unlock()
+
+	if this.provider.GetCRLFKeepalive() <= 0 {
+		this.provider.SetCRLFKeepalive(CRLF_KEEPALIVE_INTERVAL)
+	}
+	this.provider.SetConnectionDeadHandler(this.onConnectionDead)
+
+	for _, f := range this.flows {
+		this.register(f, OUTBOUND_EXPIRES)
+	}
+}
+
+// Stop stops keeping the flows up, and removes the bindings of those up.
+func (this *outbound) Stop() {
+	this.mutex.Lock()
+	if !this.started {
+		this.mutex.Unlock()
+		return
+	}
+	this.started = false
+	var up []*outboundFlow
+	for _, f := range this.flows {
+		if f.timer != nil {
+			f.timer.Stop()
+			f.timer = nil
+		}
+		this.stopKeepalive(f)
+		if f.info.State == FLOWSTATE_UP {
+			up = append(up, f)
+		}
+	}
+	deadHandler := this.deadHandler
+	this.mutex.Unlock()
+
+	this.provider.SetConnectionDeadHandler(deadHandler)
+	for _, f := range up {
+		this.register(f, 0)
+	}
+}
+
+// register sends the REGISTER of f binding the contact for expires over its
+// edge proxy.
+func (this *outbound) register(f *outboundFlow, expires time.Duration) {
+	this.mutex.Lock()
+	f.cseq++
+	req, err := this.createRegister(f, expires)
+	this.mutex.Unlock()
+	if err != nil {
+		this.getLogger().Log(LOG_ERROR, "Creating REGISTER failed", "error", err)
+		return
+	}
+	this.send(f, req)
+}
+
+// createRegister returns the REGISTER of f; the mutex is held.
+func (this *outbound) createRegister(f *outboundFlow, expires time.Duration) (Request, error) {
+	registrar, err := getRegistrarURI(this.ua.GetAddressOfRecord())
+	if err != nil {
+		return nil, err
+	}
+	via, err := getContactVia(this.ua.GetContact())
+	if err != nil {
+		return nil, err
+	}
+	aor := this.ua.GetAddressOfRecord()
+	req := NewRequest(REGISTER, registrar, nil)
+	h := req.GetHeader()
+
+	h.Set("Via", via+";branch="+RandomBranch.GetBranch(req))
+	h.Set("Route", "<"+f.info.Proxy+";lr>")
+	h.Set("Max-Forwards", "70")
+	h.Set("From", "<"+aor+">;tag="+GenerateTag())
+	h.Set("To", "<"+aor+">")
+	h.Set("Call-Id", f.callId)
+	h.Set("Cseq", strconv.Itoa(f.cseq)+" "+REGISTER)
+	h.Set("Contact", "<"+this.ua.GetContact()+">;+sip.instance=\"<"+this.instanceId+">\";reg-id="+strconv.Itoa(f.info.RegId))
+	h.Set("Supported", OPTIONTAG_OUTBOUND)
+	h.Set("Expires", strconv.Itoa(int(expires/time.Second)))
+
+	return req, nil
+}
+
+// send sends req, a REGISTER of f, failing f if it cannot.
+func (this *outbound) send(f *outboundFlow, req Request) {
+	ct := this.provider.GetNewClientTransaction(req)
+
+	this.mutex.Lock()
+	this.pending[ct] = f
+	this.mutex.Unlock()
+
+	if err := ct.SendRequest(); err != nil {
+		this.mutex.Lock()
+		delete(this.pending, ct)
+		this.mutex.Unlock()
+		this.getLogger().Log(LOG_WARN, "Sending REGISTER failed", "proxy", f.info.Proxy, "error", err)
+		this.fail(f)
+	}
+}
+
+// setState moves f to state, telling the FlowHandler; the mutex is held,
+// and the handler called once it is released.
+func (this *outbound) setState(f *outboundFlow, state FlowState) func() {
+	changed := f.info.State != state
+	f.info.State = state
+	handler, info := this.handler, f.info
+	return func() {
+		if changed && handler != nil {
+			handler(info)
+		}
+	}
+}
+
+// schedule registers f again after d; the mutex is held.
+func (this *outbound) schedule(f *outboundFlow, d time.Duration) {
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	if !this.started {
+		f.timer = nil
+		return
+	}
+	f.timer = this.provider.GetClock().AfterFunc(d, func() {
+		this.mutex.Lock()
+		started := this.started
+		f.timer = nil
+		this.mutex.Unlock()
+		if started {
+			this.register(f, OUTBOUND_EXPIRES)
+		}
+	})
+}
+
+// fail records that registering f failed, and tries it again after the
+// backoff.
+func (this *outbound) fail(f *outboundFlow) {
+	this.mutex.Lock()
+	f.info.Failures++
+	f.attempts = 0
+	f.network, f.address = "", ""
+	this.stopKeepalive(f)
+	notify := this.setState(f, FLOWSTATE_FAILED)
+	base := OUTBOUND_BASE_TIME_ALL_FAILED
+	for _, other := range this.flows {
+		if other.info.State == FLOWSTATE_UP {
+			base = OUTBOUND_BASE_TIME_OK
+			break
+		}
+	}
+	this.schedule(f, outboundWaitTime(base, f.info.Failures))
+	this.mutex.Unlock()
+
+	notify()
+}
+
+// succeed records the binding of f registered by resp, and refreshes it
+// before it expires. A flow over UDP to a registrar supporting outbound is
+// kept up with STUN keep-alives, and the CRLF keep-alives of the provider
+// are sent at least as often as the Flow-Timer of resp asks.
+func (this *outbound) succeed(f *outboundFlow, resp Response) {
+	expires := getRegisteredExpires(resp, this.instanceId, f.info.RegId)
+	if expires <= 0 {
+		this.getLogger().Log(LOG_WARN, "REGISTER accepted without the binding", "proxy", f.info.Proxy)
+		this.fail(f)
+		return
+	}
+
+	if u, ok := this.ua.(*userAgent); ok {
+		u.setServiceRoute(resp)
+	}
+
+	this.mutex.Lock()
+	f.info.Failures = 0
+	f.info.Expires = this.provider.GetClock().Now().Add(expires)
+	f.info.Outbound = hasOptionTag(resp, "Require", OPTIONTAG_OUTBOUND)
+	f.attempts = 0
+	if info := resp.GetMessageInfo(); info != nil && info.RemoteAddr != nil {
+		f.network, f.address = info.Network, info.RemoteAddr.String()
+	}
+	flowTimer := getFlowTimer(resp)
+	this.stopKeepalive(f)
+	if f.info.Outbound && f.network == UDP {
+		f.interval = STUN_KEEPALIVE_INTERVAL
+		if flowTimer > 0 {
+			f.interval = flowTimer
+		}
+		f.mapped = ""
+		this.scheduleKeepalive(f)
+	}
+	notify := this.setState(f, FLOWSTATE_UP)
+	this.schedule(f, expires*9/10)
+	stream := f.info.Outbound && f.network != "" && f.network != UDP
+	this.mutex.Unlock()
+
+	if interval := this.provider.GetCRLFKeepalive(); stream && flowTimer > 0 && (interval <= 0 || interval > flowTimer) {
+		this.provider.SetCRLFKeepalive(flowTimer)
+	}
+	notify()
+}
+
+// scheduleKeepalive sends the next STUN keep-alive of f, a flow over UDP,
+// after a random 80 to 100% of its interval (RFC 5626 4.4.1); the mutex is
+// held.
+func (this *outbound) scheduleKeepalive(f *outboundFlow) {
+	d := f.interval - time.Duration(rand.Int63n(int64(f.interval/5)+1))
+	f.keepalive = this.provider.GetClock().AfterFunc(d, func() {
+		this.keepalive(f)
+	})
+}
+
+// stopKeepalive stops the STUN keep-alives of f; the mutex is held.
+func (this *outbound) stopKeepalive(f *outboundFlow) {
+	stopTimer(f.keepalive)
+	f.keepalive = nil
+}
+
+// keepalive sends a STUN keep-alive over f, if it is still up.
+func (this *outbound) keepalive(f *outboundFlow) {
+	this.mutex.Lock()
+	f.keepalive = nil
+	address := f.address
+	up := this.started && f.info.State == FLOWSTATE_UP && f.network == UDP
+	this.mutex.Unlock()
+	if !up {
+		return
+	}
+
+	p, ok := this.provider.(*provider)
+	if !ok {
+		this.processKeepalive(f, address, "", ErrSTUNNotSupported)
+		return
+	}
+	err := p.sendBindingRequest(address, func(mapped string, err error) {
+		this.processKeepalive(f, address, mapped, err)
+	})
+	if err != nil {
+		this.processKeepalive(f, address, "", err)
+	}
+}
+
+// processKeepalive takes the outcome of a STUN keep-alive sent over f to
+// address: the flow failed if it got no response, or one with another
+// address than the previous ones (RFC 5626 4.4.2), and registers again at
+// once.
+func (this *outbound) processKeepalive(f *outboundFlow, address, mapped string, err error) {
+	this.mutex.Lock()
+	if !this.started || f.info.State != FLOWSTATE_UP || f.network != UDP || f.address != address {
+		this.mutex.Unlock()
+		return
+	}
+	if err == nil && (f.mapped == "" || f.mapped == mapped) {
+		f.mapped = mapped
+		this.scheduleKeepalive(f)
+		this.mutex.Unlock()
+		return
+	}
+	if err == nil {
+		err = ErrFlowMoved
+	}
+	notify := this.lose(f)
+	this.mutex.Unlock()
+
+	this.getLogger().Log(LOG_WARN, "Keep-alive failed", "proxy", f.info.Proxy, "address", address, "error", err)
+	notify()
+	this.register(f, OUTBOUND_EXPIRES)
+}
+
+// lose moves f, whose flow died, back to registering; the mutex is held,
+// and the FlowHandler called once it is released.
+func (this *outbound) lose(f *outboundFlow) func() {
+	f.network, f.address = "", ""
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+	this.stopKeepalive(f)
+	return this.setState(f, FLOWSTATE_REGISTERING)
+}
+
+// onConnectionDead registers again at once the flows which went over the
+// connection to address which died, then tells the handler the provider
+// had before Start.
+func (this *outbound) onConnectionDead(network, address string) {
+	this.mutex.Lock()
+	var dead []*outboundFlow
+	var notify []func()
+	for _, f := range this.flows {
+		if f.info.State == FLOWSTATE_UP && f.network == network && f.address == address {
+			notify = append(notify, this.lose(f))
+			dead = append(dead, f)
+		}
+	}
+	started := this.started
+	deadHandler := this.deadHandler
+	this.mutex.Unlock()
+
+	for _, n := range notify {
+		n()
+	}
+	if started {
+		for _, f := range dead {
+			this.register(f, OUTBOUND_EXPIRES)
+		}
+	}
+	if deadHandler != nil {
+		deadHandler(network, address)
+	}
+}
+
+func (this *outbound) getLogger() Logger {
+	return this.provider.GetLogger().With(LOG_COMPONENT, COMPONENT_TRANSPORT)
+}
+
+func (this *outbound) ProcessRequest(requestEvent RequestEvent) {
+}
+
+func (this *outbound) ProcessResponse(responseEvent ResponseEvent) {
+	ct := responseEvent.GetClientTransaction()
+	resp := responseEvent.GetResponse()
+	if ct == nil || resp.GetStatusCode() < OK {
+		return
+	}
+
+	this.mutex.Lock()
+	f := this.pending[ct]
+	delete(this.pending, ct)
+	this.mutex.Unlock()
+	if f == nil {
+		return
+	}
+
+	req := ct.GetRequest()
+	if req.GetHeader().Get("Expires") == "0" {
+		//the binding of a flow stopped was removed
+		return
+	}
+	switch {
+	case resp.GetStatusCode() < MULTIPLE_CHOICES:
+		this.succeed(f, resp)
+	case isChallenge(resp) && this.authorize(f, resp):
+	default:
+		this.getLogger().Log(LOG_WARN, "REGISTER failed", "proxy", f.info.Proxy, "status", resp.GetStatusCode())
+		this.fail(f)
+	}
+}
+
+// authorize sends the REGISTER of f challenged by resp again with the
+// credentials of the UserAgent, and reports whether it did.
+func (this *outbound) authorize(f *outboundFlow, resp Response) bool {
+	authorizer := this.ua.GetAuthorizer()
+
+	this.mutex.Lock()
+	if authorizer == nil || f.attempts == USERAGENT_AUTH_ATTEMPTS {
+		this.mutex.Unlock()
+		return false
+	}
+	f.attempts++
+	f.cseq++
+	req, err := this.createRegister(f, OUTBOUND_EXPIRES)
+	this.mutex.Unlock()
+
+	if err != nil || authorizer.Authorize(req, resp) != nil {
+		return false
+	}
+	this.send(f, req)
+	return true
+}
+
+func (this *outbound) ProcessTimeout(timeoutEvent TimeoutEvent) {
+	ct, ok := timeoutEvent.GetTransaction().(ClientTransaction)
+	if !ok {
+		return
+	}
+
+	this.mutex.Lock()
+	f := this.pending[ct]
+	delete(this.pending, ct)
+	this.mutex.Unlock()
+
+	if f != nil && ct.GetRequest().GetHeader().Get("Expires") != "0" {
+		this.fail(f)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// outboundWaitTime returns the time to wait before registering a flow again
+// after failures consecutive failures (RFC 5626 4.5).
+func outboundWaitTime(base time.Duration, failures int) time.Duration {
+	wait := OUTBOUND_MAX_TIME
+	if failures < 16 && base<<uint(failures) < wait {
+		wait = base << uint(failures)
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// getRegisteredExpires returns how long the binding of the flow regId of
+// instanceId lasts according to resp, a 2xx to its REGISTER, or 0 if resp
+// does not list it.
+func getRegisteredExpires(resp Response, instanceId string, regId int) time.Duration {
+	for _, v := range resp.GetHeader()["Contact"] {
+		for _, value := range splitHeaderValues(v) {
+			contact := parseContact(value)
+			if contact == nil || contact.GetInstance() != instanceId || contact.GetRegId() != regId {
+				continue
+			}
+			expires := contact.GetExpires()
+			if contact.GetParameter(header.ParameterNames_EXPIRES) == "" {
+				expires, _ = strconv.Atoi(resp.GetHeader().Get("Expires"))
+			}
+			return time.Duration(expires) * time.Second
+		}
+	}
+	return 0
+}
+
+// getFlowTimer returns the Flow-Timer of resp, a 2xx to a REGISTER: the
+// interval its flow is to be kept up at, or 0 if it has none.
+func getFlowTimer(resp Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.GetHeader().Get("Flow-Timer"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// getFlows keeps, of bindings, those not registered with outbound and, for
+// each instance, the most recent one registered with outbound whose flow is
+// still up; a proxy sends to a UA over only one of its flows (RFC 5626
+// 5.3).
+func (this *proxy) getFlows(bindings []Binding) []Binding {
+	p, ok := this.provider.(*provider)
+	if !ok {
+		return bindings
+	}
+	var kept []Binding
+	instances := make(map[string]bool)
+	for i := len(bindings) - 1; i >= 0; i-- {
+		b := bindings[i]
+		if b.RegId == 0 || b.FlowNetwork == "" {
+			kept = append(kept, b)
+			continue
+		}
+		if instances[b.InstanceId] {
+			continue
+		}
+		if p.getConnectionHop(b.FlowNetwork, b.FlowAddress) != nil {
+			instances[b.InstanceId] = true
+			kept = append(kept, b)
+		}
+	}
+	//back in their order
+	for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+		kept[i], kept[j] = kept[j], kept[i]
+	}
+	return kept
+}
+
+// getFlow returns the flow to send to the contact of b over, if it was
+// registered with outbound and the flow is still up.
+func (this *proxy) getFlow(b Binding) Hop {
+	p, ok := this.provider.(*provider)
+	if !ok || b.RegId == 0 || b.FlowNetwork == "" {
+		return nil
+	}
+	return p.getConnectionHop(b.FlowNetwork, b.FlowAddress)
+}
@@ -0,0 +1,316 @@
+package sip
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testInstanceId = "urn:uuid:00000000-0000-1000-8000-000A95A0E128"
+
+// findRegister returns the last REGISTER sent for the flow of regId.
+func findRegister(sent *sentMessages, regId string) Request {
+	for i := sent.len() - 1; i >= 0; i-- {
+		if req, ok := sent.get(i).(Request); ok && req.GetMethod() == REGISTER && strings.HasSuffix(req.GetHeader().Get("Contact"), ";reg-id="+regId) {
+			return req
+		}
+	}
+	return nil
+}
+
+func TestOutboundFlows(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.7", 5060, UDP)}, nil
+	}))
+
+	ua, err := NewUserAgent(p, "sip:alice@example.com", "sip:alice@192.0.2.1:5070")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := NewOutbound(ua, testInstanceId, "sip:edge1.example.com", "sip:edge2.example.com")
+	p.AddListener(o)
+	var changes []FlowInfo
+	o.SetFlowHandler(func(flow FlowInfo) { changes = append(changes, flow) })
+
+	//each flow registers the contact through its own edge proxy
+	o.Start()
+	defer o.Stop()
+	if p.GetCRLFKeepalive() != CRLF_KEEPALIVE_INTERVAL {
+		t.Errorf("keep-alive interval %v", p.GetCRLFKeepalive())
+	}
+	waitSent(t, sent, 2)
+	first, second := findRegister(sent, "1"), findRegister(sent, "2")
+	if first == nil || second == nil {
+		t.Fatal("a flow did not register")
+	}
+	h := first.GetHeader()
+	if h.Get("Contact") != "<sip:alice@192.0.2.1:5070>;+sip.instance=\"<"+testInstanceId+">\";reg-id=1" || h.Get("Route") != "<sip:edge1.example.com;lr>" ||
+		!IsSupported(first, OPTIONTAG_OUTBOUND) || first.GetRequestURIString() != "sip:example.com" || h.Get("Expires") != "3600" {
+		t.Errorf("REGISTER %v", h)
+	}
+	if second.GetHeader().Get("Route") != "<sip:edge2.example.com;lr>" || second.GetHeader().Get("Call-Id") == h.Get("Call-Id") {
+		t.Errorf("second flow registered with %v", second.GetHeader())
+	}
+
+	//the first one is up, over the connection its 2xx came from
+	ok200 := withToTag(CreateResponse(first, OK), "r1")
+	ok200.GetHeader().Set("Contact", h.Get("Contact")+";expires=600")
+	ok200.GetHeader().Set("Require", OPTIONTAG_OUTBOUND)
+	ok200.GetHeader().Set("Flow-Timer", "60")
+	ok200.SetMessageInfo(&MessageInfo{Network: TCP, RemoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 5060}})
+	p.processResponse(ok200)
+	if p.GetCRLFKeepalive() != time.Minute {
+		t.Errorf("keep-alive interval %v with a Flow-Timer", p.GetCRLFKeepalive())
+	}
+
+	//the second one fails, and is tried again after the backoff
+	p.processResponse(withToTag(CreateResponse(second, SERVICE_UNAVAILABLE), "r2"))
+	flows := o.GetFlows()
+	if flows[0].State != FLOWSTATE_UP || !flows[0].Outbound || !flows[0].Expires.Equal(clock.Now().Add(600*time.Second)) {
+		t.Errorf("first flow %+v", flows[0])
+	}
+	if flows[1].State != FLOWSTATE_FAILED || flows[1].Failures != 1 {
+		t.Errorf("second flow %+v", flows[1])
+	}
+	if len(changes) != 2 || changes[0].RegId != 1 || changes[1].RegId != 2 {
+		t.Errorf("changes %+v", changes)
+	}
+	clock.Advance(2 * OUTBOUND_BASE_TIME_OK)
+	waitSent(t, sent, 3)
+	retry := findRegister(sent, "2")
+	if retry == second || retry.GetHeader().Get("Cseq") != "2 REGISTER" || retry.GetHeader().Get("Call-Id") != second.GetHeader().Get("Call-Id") {
+		t.Fatalf("second flow retried with %v", retry.GetHeader())
+	}
+	ok200 = withToTag(CreateResponse(retry, OK), "r3")
+	ok200.GetHeader().Set("Contact", retry.GetHeader().Get("Contact"))
+	ok200.GetHeader().Set("Expires", "600")
+	p.processResponse(ok200)
+	if flows := o.GetFlows(); flows[1].State != FLOWSTATE_UP || flows[1].Failures != 0 {
+		t.Errorf("second flow %+v after its retry", flows[1])
+	}
+
+	//a dead connection registers its flow again at once
+	p.GetConnectionDeadHandler()(TCP, "192.0.2.7:5060")
+	waitSent(t, sent, 4)
+	if again := findRegister(sent, "1"); again == first || again.GetHeader().Get("Cseq") != "2 REGISTER" {
+		t.Errorf("first flow registered again with %v", again.GetHeader())
+	}
+	if flows := o.GetFlows(); flows[0].State != FLOWSTATE_REGISTERING || flows[1].State != FLOWSTATE_UP {
+		t.Errorf("flows %+v after the connection died", flows)
+	}
+}
+
+func TestOutboundUDPFlow(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	p.AddTransport(newTransport(UDP, "127.0.0.1", 0, nil))
+	go p.Run()
+	defer p.Stop()
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.7", 5060, UDP)}, nil
+	}))
+	edge, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer edge.Close()
+
+	ua, err := NewUserAgent(p, "sip:alice@example.com", "sip:alice@192.0.2.1:5070")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := NewOutbound(ua, testInstanceId, "sip:edge1.example.com")
+	p.AddListener(o)
+	o.Start()
+	defer o.Stop()
+	registered := func(n int, flowTimer string) {
+		waitSent(t, sent, n)
+		req := findRegister(sent, "1")
+		ok200 := withToTag(CreateResponse(req, OK), "r1")
+		ok200.GetHeader().Set("Contact", req.GetHeader().Get("Contact")+";expires=600")
+		ok200.GetHeader().Set("Require", OPTIONTAG_OUTBOUND)
+		if flowTimer != "" {
+			ok200.GetHeader().Set("Flow-Timer", flowTimer)
+		}
+		ok200.SetMessageInfo(&MessageInfo{Network: UDP, RemoteAddr: edge.LocalAddr()})
+		p.processResponse(ok200)
+		if flows := o.GetFlows(); flows[0].State != FLOWSTATE_UP {
+			t.Fatalf("flow %+v", flows[0])
+		}
+	}
+	//the next keep-alive, sent after 80 to 100% of interval, before it is
+	//sent again
+	keepalive := func(interval time.Duration) ([]byte, net.Addr) {
+		clock.Advance(interval * 8 / 10)
+		buffer := make([]byte, DATAGRAM_SIZE)
+		for i := 0; i < 100; i++ {
+			edge.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+			if n, from, err := edge.ReadFrom(buffer); err == nil {
+				return buffer[:n], from
+			}
+			clock.Advance(interval / 100)
+		}
+		t.Fatal("no keep-alive sent")
+		return nil, nil
+	}
+	//which the edge proxy answers from the address given
+	answer := func(interval time.Duration, mapped *net.UDPAddr) {
+		req, from := keepalive(interval)
+		if mapped == nil {
+			mapped = from.(*net.UDPAddr)
+		}
+		id := req[8:stunHeaderSize]
+		edge.WriteTo(newSTUNMessage(stunBindingSuccess, id, encodeXorMappedAddress(mapped, id)), from)
+	}
+	//and waits until the outcome was taken
+	answered := func() {
+		for i := 0; ; i++ {
+			o.(*outbound).mutex.Lock()
+			taken := o.(*outbound).flows[0].keepalive != nil || o.(*outbound).flows[0].info.State != FLOWSTATE_UP
+			o.(*outbound).mutex.Unlock()
+			if taken {
+				return
+			}
+			if i == 100 {
+				t.Fatal("keep-alive response not taken")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	//the flow is kept up with STUN keep-alives, as often as its Flow-Timer
+	registered(1, "20")
+	answer(20*time.Second, nil)
+	answered()
+	answer(20*time.Second, nil)
+	answered()
+	if flows := o.GetFlows(); flows[0].State != FLOWSTATE_UP || sent.len() != 1 {
+		t.Fatalf("flow %+v after its keep-alives", flows[0])
+	}
+
+	//one answered from another address means the NAT rebound the flow
+	answer(20*time.Second, &net.UDPAddr{IP: net.ParseIP("198.51.100.9"), Port: 40000})
+	answered()
+	waitSent(t, sent, 2)
+	if flows := o.GetFlows(); flows[0].State != FLOWSTATE_REGISTERING || findRegister(sent, "1").GetHeader().Get("Cseq") != "2 REGISTER" {
+		t.Fatalf("flow %+v once rebound", flows[0])
+	}
+
+	//and one never answered that it is gone
+	registered(2, "")
+	keepalive(STUN_KEEPALIVE_INTERVAL)
+	clock.Advance(STUN_RTO<<(STUN_REQUEST_COUNT-1) - STUN_RTO + STUN_LAST_WAIT)
+	waitSent(t, sent, 3)
+	if flows := o.GetFlows(); flows[0].State != FLOWSTATE_REGISTERING || findRegister(sent, "1").GetHeader().Get("Cseq") != "3 REGISTER" {
+		t.Errorf("flow %+v once its keep-alive timed out", flows[0])
+	}
+}
+
+func TestOutboundWaitTime(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := outboundWaitTime(OUTBOUND_BASE_TIME_ALL_FAILED, 3); d < 120*time.Second || d > 240*time.Second {
+			t.Fatalf("wait after 3 failures %v", d)
+		}
+		if d := outboundWaitTime(OUTBOUND_BASE_TIME_OK, 10); d < OUTBOUND_MAX_TIME/2 || d > OUTBOUND_MAX_TIME {
+			t.Fatalf("wait after 10 failures %v", d)
+		}
+	}
+	if id := GenerateInstanceId(); len(id) != 45 || !strings.HasPrefix(id, "urn:uuid:") || id[23] != '4' || !strings.ContainsRune("89ab", rune(id[28])) {
+		t.Errorf("instance ID %q", id)
+	}
+}
+
+func TestRegistrarOutbound(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	location := NewLocationService(clock)
+	r := NewRegistrar(nil, location, clock)
+	instance := ";+sip.instance=\"<" + testInstanceId + ">\""
+	overFlow := func(req Request, port int) Request {
+		req.SetMessageInfo(&MessageInfo{Network: TCP, RemoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.4"), Port: port}})
+		return req
+	}
+
+	//the flow of a REGISTER using outbound is kept with its binding
+	resp := register(r, overFlow(newRegister(1, "3600", "<sip:bob@192.0.2.4>"+instance+";reg-id=1"), 40000))
+	if resp.GetStatusCode() != OK || !IsRequired(resp, OPTIONTAG_OUTBOUND) {
+		t.Fatalf("REGISTER answered %d with %v", resp.GetStatusCode(), resp.GetHeader())
+	}
+	register(r, overFlow(newRegister(2, "3600", "<sip:bob@192.0.2.4>"+instance+";reg-id=2"), 40001))
+	bindings, _ := location.Lookup("sip:bob@biloxi.com")
+	if len(bindings) != 2 || bindings[0].RegId != 1 || bindings[0].InstanceId != testInstanceId || bindings[0].FlowNetwork != TCP ||
+		bindings[0].FlowAddress != "192.0.2.4:40000" || bindings[1].RegId != 2 || bindings[1].FlowAddress != "192.0.2.4:40001" {
+		t.Fatalf("bindings %+v", bindings)
+	}
+
+	//a flow registered again replaces its binding, whatever its URI
+	register(r, overFlow(newRegister(3, "3600", "<sip:bob@192.0.2.40>"+instance+";reg-id=1"), 40002))
+	bindings, _ = location.Lookup("sip:bob@biloxi.com")
+	if len(bindings) != 2 || bindings[0].RegId != 2 || bindings[1].URI != "sip:bob@192.0.2.40" || bindings[1].FlowAddress != "192.0.2.4:40002" {
+		t.Errorf("bindings %+v", bindings)
+	}
+
+	//only one flow per REGISTER
+	twice := overFlow(newRegister(4, "3600", "<sip:bob@192.0.2.4>"+instance+";reg-id=1", "<sip:bob@192.0.2.5>"+instance+";reg-id=2"), 40003)
+	if resp := register(r, twice); resp.GetStatusCode() != BAD_REQUEST {
+		t.Errorf("two reg-ids answered %d", resp.GetStatusCode())
+	}
+
+	//a REGISTER which did not come over its flow does not use outbound
+	resp = register(r, newRegister(5, "3600", "<sip:bob@192.0.2.6>"+instance+";reg-id=3"))
+	bindings, _ = location.Lookup("sip:bob@biloxi.com")
+	if resp.GetStatusCode() != OK || IsRequired(resp, OPTIONTAG_OUTBOUND) || len(bindings) != 3 || bindings[2].RegId != 0 || bindings[2].FlowNetwork != "" {
+		t.Errorf("REGISTER without a flow answered %v, bindings %+v", resp.GetHeader(), bindings)
+	}
+}
+
+func TestProxyOutboundFlow(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+
+	location := NewLocationService(clock)
+	p.AddListener(NewProxy(p, "192.0.2.1", 5060, location, true))
+	p.SetTryingPolicy(INVITE, TRYINGPOLICY_NEVER)
+
+	//a request to a contact registered with outbound goes over its flow
+	location.Store("sip:bob@biloxi.com", []Binding{
+		{AOR: "sip:bob@biloxi.com", URI: "sip:bob@10.0.0.4", Q: -1, Expires: clock.Now().Add(time.Hour),
+			InstanceId: testInstanceId, RegId: 1, FlowNetwork: UDP, FlowAddress: "198.51.100.4:40000"},
+	})
+	p.processMessage(newProxiedRequest(INVITE, "sip:bob@biloxi.com", "z9hG4bKob1"))
+	waitForwarded(t, sent, 0, INVITE, "sip:bob@10.0.0.4")
+	sent.mutex.Lock()
+	hop := sent.hops[len(sent.hops)-1]
+	sent.mutex.Unlock()
+	if hop.String() != "198.51.100.4:40000/udp" {
+		t.Errorf("INVITE sent to %v", hop)
+	}
+
+	//and fails once the flow is gone
+	location.Store("sip:bob@biloxi.com", []Binding{
+		{AOR: "sip:bob@biloxi.com", URI: "sip:bob@10.0.0.4", Q: -1, Expires: clock.Now().Add(time.Hour),
+			InstanceId: testInstanceId, RegId: 1, FlowNetwork: TCP, FlowAddress: "198.51.100.4:40001"},
+	})
+	req := newProxiedRequest(INVITE, "sip:bob@biloxi.com", "z9hG4bKob2")
+	req.GetHeader().Set("Call-Id", "flow@192.0.2.2")
+	p.processMessage(req)
+	for i := 0; len(sentResponses(sent, "z9hG4bKob2")) == 0; i++ {
+		if i == 100 {
+			t.Fatal("INVITE not answered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if codes := sentResponses(sent, "z9hG4bKob2"); codes[0] != FLOW_FAILED {
+		t.Errorf("INVITE over a dead flow answered %v", codes)
+	}
+}
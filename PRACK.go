@@ -0,0 +1,231 @@
+package sip
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// A provisional response to an INVITE is sent reliably (RFC 3262) when it
+// has Require: 100rel, which a server transaction adds to the 101-199
+// responses of an INVITE requiring 100rel. The transaction numbers it with
+// an RSeq, from a random one, and retransmits it from T1 on, doubling the
+// interval, over any transport until the PRACK whose RAck names it comes;
+// one unacknowledged for 64*T1 has its INVITE rejected with 500. Only one
+// reliable provisional response is sent at a time.
+//
+// The provider takes the PRACKs received within a dialog: one matching no
+// reliable provisional response is answered with 481, one without an offer
+// with 200 OK, before it is given to the listeners, like a CANCEL. A PRACK
+// making an offer is answered by the listeners. Reliable provisional
+// responses received are acknowledged by their dialog, once per RSeq,
+// unless they make an offer, whose answer the application sends in the
+// PRACK of CreatePrack.
+
+// OPTIONTAG_100REL is the option tag of RFC 3262.
+const OPTIONTAG_100REL = "100rel"
+
+var (
+	ErrReliableUnsupported = errors.New("sip: the request does not support reliable provisional responses")
+	ErrReliablePending     = errors.New("sip: a reliable provisional response is not acknowledged yet")
+	ErrNotReliable         = errors.New("sip: not a reliable provisional response")
+)
+
+////////////////////Implementation////////////////////////
+
+// isReliableProvisional reports whether resp is a provisional response
+// sent reliably.
+func isReliableProvisional(resp Response) bool {
+	statusCode := resp.GetStatusCode()
+	return statusCode > TRYING && statusCode < OK && hasOptionTag(resp, "Require", OPTIONTAG_100REL)
+}
+
+// getRSeq returns the RSeq of resp.
+func getRSeq(resp Response) (int, error) {
+	rseq, err := strconv.Atoi(strings.TrimSpace(resp.GetHeader().Get("RSeq")))
+	if err != nil || rseq <= 0 {
+		return 0, ErrNotReliable
+	}
+	return rseq, nil
+}
+
+// getRAck returns the RSeq, the CSeq number and the method of the RAck of
+// req, a PRACK.
+func getRAck(req Request) (int, int, string, bool) {
+	fields := strings.Fields(req.GetHeader().Get("RAck"))
+	if len(fields) != 3 {
+		return 0, 0, "", false
+	}
+	rseq, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, "", false
+	}
+	cseq, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, "", false
+	}
+	return rseq, cseq, fields[2], true
+}
+
+// prepareReliable reports whether resp is to be sent reliably, adding
+// Require: 100rel to a 101-199 response to an INVITE requiring it. It
+// fails if the INVITE does not support 100rel, or while another reliable
+// provisional response is not acknowledged.
+func (this *serverTransaction) prepareReliable(resp Response) (bool, error) {
+	statusCode := resp.GetStatusCode()
+	if this.request.GetMethod() != INVITE || statusCode <= TRYING || statusCode >= OK {
+		return false, nil
+	}
+	if !hasOptionTag(resp, "Require", OPTIONTAG_100REL) {
+		if !hasOptionTag(this.request, "Require", OPTIONTAG_100REL) {
+			return false, nil
+		}
+		resp.GetHeader().Add("Require", OPTIONTAG_100REL)
+	} else if !IsSupported(this.request, OPTIONTAG_100REL) {
+		return false, ErrReliableUnsupported
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.unacked != nil {
+		return false, ErrReliablePending
+	}
+	return true, nil
+}
+
+// scheduleReliable numbers resp, a reliable provisional response about to
+// be sent, and arms its retransmissions until its PRACK comes; mutex is
+// held.
+func (this *serverTransaction) scheduleReliable(resp Response) {
+	if this.rseq == 0 {
+		this.rseq = 1 + rand.Intn(1<<31-1)
+	} else {
+		this.rseq++
+	}
+	resp.GetHeader().Set("RSeq", strconv.Itoa(this.rseq))
+	this.unacked = resp
+	stopTimer(this.provisional)
+	if this.provider != nil {
+		clock := this.provider.GetClock()
+		this.rinterval = this.getT1()
+		this.rretransmit = clock.AfterFunc(this.rinterval, this.onReliableRetransmit)
+		this.prackTimeout = clock.AfterFunc(64*this.getT1(), this.onPrackTimeout)
+	}
+}
+
+// stopReliable stops retransmitting the reliable provisional response
+// awaiting its PRACK, if any; mutex is held.
+func (this *serverTransaction) stopReliable() {
+	this.unacked = nil
+	stopTimer(this.rretransmit)
+	stopTimer(this.prackTimeout)
+}
+
+// onReliableRetransmit sends the reliable provisional response again,
+// doubling the interval.
+func (this *serverTransaction) onReliableRetransmit() {
+	this.mutex.Lock()
+	resp := this.unacked
+	if resp == nil || this.GetState() != TRANSACTIONSTATE_PROCEEDING {
+		this.mutex.Unlock()
+		return
+	}
+	this.rinterval *= 2
+	this.rretransmit = this.provider.GetClock().AfterFunc(this.rinterval, this.onReliableRetransmit)
+	this.mutex.Unlock()
+
+	this.recordRetransmission()
+	this.provider.getMetrics().AddRetransmission(this.request.GetMethod())
+	if err := this.transmit(resp); err != nil {
+		this.processError(err)
+	}
+}
+
+// onPrackTimeout rejects the INVITE whose reliable provisional response
+// was not acknowledged after 64*T1 (RFC 3262 3).
+func (this *serverTransaction) onPrackTimeout() {
+	this.mutex.Lock()
+	expired := this.unacked != nil && this.GetState() == TRANSACTIONSTATE_PROCEEDING
+	this.mutex.Unlock()
+
+	if !expired {
+		return
+	}
+	this.provider.getLogger(COMPONENT_TRANSACTION).Log(LOG_WARN, "Reliable provisional response not acknowledged", "call-id", this.request.GetHeader().Get("Call-Id"))
+	if err := this.SendResponse(withToTag(CreateResponse(this.request, SERVER_INTERNAL_ERROR), this.getToTag())); err != nil && err != ErrTransactionCompleted {
+		this.provider.getLogger(COMPONENT_TRANSACTION).Log(LOG_ERROR, "Sending response failed", "error", err)
+	}
+}
+
+// acknowledge stops retransmitting the reliable provisional response named
+// by the RAck of req, a PRACK, and reports whether it awaited req.
+func (this *serverTransaction) acknowledge(req Request) bool {
+	rseq, cseq, method, ok := getRAck(req)
+	inviteCSeq, _ := getCSeq(this.request)
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if !ok || this.unacked == nil || rseq != this.rseq || cseq != inviteCSeq || method != this.request.GetMethod() {
+		return false
+	}
+	this.stopReliable()
+	return true
+}
+
+// CreatePrack returns the PRACK acknowledging resp, a reliable provisional
+// response to an INVITE of the dialog (RFC 3262 7.2): a request of the
+// dialog whose RAck names the RSeq and the CSeq of resp.
+func (this *dialog) CreatePrack(resp Response) (Request, error) {
+	rseq, err := getRSeq(resp)
+	if err != nil || !isReliableProvisional(resp) || getCSeqMethod(resp) != INVITE {
+		return nil, ErrNotReliable
+	}
+	cseq, _ := getCSeq(resp)
+
+	prack, err := this.CreateRequest(PRACK)
+	if err != nil {
+		return nil, err
+	}
+	prack.GetHeader().Set("RAck", strconv.Itoa(rseq)+" "+strconv.Itoa(cseq)+" "+INVITE)
+	return prack, nil
+}
+
+// sendPrack acknowledges resp, a reliable provisional response, with a
+// PRACK carrying sdp, if any, and returns it; a response acknowledged
+// already is not acknowledged again, and nil is returned.
+func (this *dialog) sendPrack(resp Response, sdp []byte) (Request, error) {
+	rseq, err := getRSeq(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	this.mutex.Lock()
+	if rseq <= this.prackRSeq {
+		this.mutex.Unlock()
+		return nil, nil
+	}
+	this.prackRSeq = rseq
+	this.mutex.Unlock()
+
+	prack, err := this.CreatePrack(resp)
+	if err != nil {
+		return nil, err
+	}
+	if sdp != nil {
+		prack.SetBody(NewSDPBody(sdp))
+	}
+	return prack, this.SendRequest(this.provider.GetNewClientTransaction(prack))
+}
+
+// acknowledge reports whether req, a PRACK, acknowledges a reliable
+// provisional response to the INVITE received in the dialog.
+func (this *dialog) acknowledge(req Request) bool {
+	this.mutex.Lock()
+	st, _ := this.serverInvite.(*serverTransaction)
+	this.mutex.Unlock()
+
+	return st != nil && st.acknowledge(req)
+}
@@ -0,0 +1,182 @@
+package sip
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newPrackTestRequest(branch, rack string) Request {
+	prack := newServerTestRequest(PRACK, "UDP", branch)
+	prack.GetHeader().Set("To", "<sip:bob@example.com>;tag=b1")
+	prack.GetHeader().Set("Cseq", "2 PRACK")
+	prack.GetHeader().Set("RAck", rack)
+	return prack
+}
+
+func TestPrackServer(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+
+	invite := newServerTestRequest(INVITE, "TCP", "z9hG4bKpr1")
+	invite.GetHeader().Set("Contact", "<sip:alice@192.0.2.1>")
+	invite.GetHeader().Set("Require", OPTIONTAG_100REL)
+	invite.SetMessageInfo(&MessageInfo{Network: TCP, LocalAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5060}})
+	p.processMessage(invite)
+	st := l.transactions[0]
+
+	//a provisional response to an INVITE requiring 100rel is sent reliably
+	ringing := CreateResponse(invite, RINGING)
+	ringing.GetHeader().Set("To", "<sip:bob@example.com>;tag=b1")
+	ringing.GetHeader().Set("Contact", "<sip:bob@192.0.2.2>")
+	if err := st.SendResponse(ringing); err != nil {
+		t.Fatal(err)
+	}
+	rseq, err := getRSeq(ringing)
+	if err != nil || !isReliableProvisional(ringing) {
+		t.Fatalf("180 with Require %q, RSeq %q", ringing.GetHeader().Get("Require"), ringing.GetHeader().Get("RSeq"))
+	}
+	if err := st.SendResponse(CreateResponse(invite, SESSION_PROGRESS)); err != ErrReliablePending {
+		t.Errorf("second reliable response sent with %v", err)
+	}
+
+	//it is sent again, even over a stream, until its PRACK comes
+	n := sent.len()
+	clock.Advance(TIMER_T1)
+	waitSent(t, sent, n+1)
+	clock.Advance(2 * TIMER_T1)
+	waitSent(t, sent, n+2)
+
+	p.processMessage(newPrackTestRequest("z9hG4bKpr2", strconv.Itoa(rseq+1)+" 1 INVITE"))
+	if resp, ok := sent.last().(Response); !ok || resp.GetStatusCode() != CALL_OR_TRANSACTION_DOES_NOT_EXIST {
+		t.Fatalf("PRACK of another response answered %v", sent.last())
+	}
+	p.processMessage(newPrackTestRequest("z9hG4bKpr3", strconv.Itoa(rseq)+" 1 INVITE"))
+	if resp, ok := sent.last().(Response); !ok || resp.GetStatusCode() != OK || getCSeqMethod(resp) != PRACK {
+		t.Fatalf("PRACK answered %v", sent.last())
+	}
+	if len(l.requests) != 2 || l.requests[1].GetRequest().GetMethod() != PRACK || l.requests[1].GetDialog() == nil {
+		t.Fatalf("listener got %d requests", len(l.requests))
+	}
+	n = sent.len()
+	clock.Advance(4 * TIMER_T1)
+	time.Sleep(50 * time.Millisecond)
+	if sent.len() != n {
+		t.Error("180 sent again once acknowledged")
+	}
+
+	//the next one is numbered after it, and rejects the INVITE if never
+	//acknowledged
+	progress := CreateResponse(invite, SESSION_PROGRESS)
+	progress.GetHeader().Set("To", "<sip:bob@example.com>;tag=b1")
+	if err := st.SendResponse(progress); err != nil {
+		t.Fatal(err)
+	}
+	if next, _ := getRSeq(progress); next != rseq+1 {
+		t.Errorf("RSeq %d after %d", next, rseq)
+	}
+	clock.Advance(64 * TIMER_T1)
+	for i := 0; st.GetState() != TRANSACTIONSTATE_COMPLETED && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if resp, ok := sent.last().(Response); !ok || resp.GetStatusCode() != SERVER_INTERNAL_ERROR || getTag(resp.GetHeader().Get("To")) != "b1" {
+		t.Fatalf("unacknowledged 183 ended with %v", sent.last())
+	}
+}
+
+func TestPrackUnsupported(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKpr4")
+	p.processMessage(invite)
+	ringing := CreateResponse(invite, RINGING)
+	ringing.GetHeader().Set("Require", OPTIONTAG_100REL)
+	if err := l.transactions[0].SendResponse(ringing); err != ErrReliableUnsupported {
+		t.Errorf("reliable 180 to an INVITE without 100rel sent with %v", err)
+	}
+
+	req := newServerTestRequest(INVITE, "UDP", "z9hG4bKpr5")
+	req.GetHeader().Set("Require", "100rel, foo")
+	req.GetHeader().Add("Require", "bar")
+	if !IsRequired(req, OPTIONTAG_100REL) || !IsSupported(req, OPTIONTAG_100REL) || IsSupported(req, OPTIONTAG_TIMER) {
+		t.Error("option tags of Require")
+	}
+	unsupported := GetUnsupported(req, []string{"100REL", OPTIONTAG_TIMER})
+	resp := CreateBadExtensionResponse(req, unsupported)
+	if resp.GetStatusCode() != BAD_EXTENSION || resp.GetHeader().Get("Unsupported") != "foo, bar" {
+		t.Errorf("%d with Unsupported %q", resp.GetStatusCode(), resp.GetHeader().Get("Unsupported"))
+	}
+}
+
+func TestPrackClient(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKpr6")
+	invite.GetHeader().Set("Contact", "<sip:alice@192.0.2.1>")
+	invite.GetHeader().Set("Supported", OPTIONTAG_100REL)
+	ct := p.GetNewClientTransaction(invite).(*clientTransaction)
+	ct.hops = []Hop{NewHop("192.0.2.7", 5060, UDP)}
+	ct.start()
+
+	//a reliable provisional response is acknowledged once by its dialog
+	ringing := CreateResponse(invite, RINGING)
+	ringing.GetHeader().Set("To", "<sip:bob@example.com>;tag=b6")
+	ringing.GetHeader().Set("Contact", "<sip:bob@192.0.2.7:5070>")
+	ringing.GetHeader().Set("Require", OPTIONTAG_100REL)
+	ringing.GetHeader().Set("RSeq", "42")
+	n := sent.len()
+	p.processResponse(ringing)
+	waitSent(t, sent, n+1)
+	prack := findSent(sent, PRACK)
+	if prack == nil {
+		t.Fatalf("no PRACK: %v", sent.last())
+	}
+	h := prack.GetHeader()
+	if prack.GetRequestURIString() != "sip:bob@192.0.2.7:5070" || h.Get("RAck") != "42 1 INVITE" || h.Get("Cseq") != "2 PRACK" || getTag(h.Get("To")) != "b6" {
+		t.Errorf("PRACK to %s with %v", prack.GetRequestURIString(), h)
+	}
+	p.processResponse(ringing)
+	time.Sleep(50 * time.Millisecond)
+	if findSent(sent, PRACK) != prack {
+		t.Error("retransmitted 180 acknowledged again")
+	}
+
+	//one making an offer is acknowledged by the application, with the
+	//answer
+	progress := CreateResponse(invite, SESSION_PROGRESS)
+	progress.GetHeader().Set("To", "<sip:bob@example.com>;tag=b6")
+	progress.GetHeader().Set("Require", OPTIONTAG_100REL)
+	progress.GetHeader().Set("RSeq", "43")
+	progress.SetBody(NewSDPBody([]byte("v=0\r\no=offer\r\n")))
+	p.processResponse(progress)
+	time.Sleep(50 * time.Millisecond)
+	if findSent(sent, PRACK) != prack {
+		t.Fatal("offer acknowledged without an answer")
+	}
+	d := ct.GetDialog()
+	answer, err := d.CreatePrack(progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := answer.GetHeader().Get("RAck"); v != "43 1 INVITE" {
+		t.Errorf("PRACK RAck %q", v)
+	}
+	if _, err := d.CreatePrack(CreateResponse(invite, RINGING)); err != ErrNotReliable {
+		t.Errorf("PRACK of an unreliable response created with %v", err)
+	}
+}
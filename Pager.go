@@ -0,0 +1,249 @@
+package sip
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A Pager sends and receives instant messages in pager mode (RFC 3428):
+// each one a MESSAGE of its own, outside of any dialog, whose body is the
+// message, text/plain or message/cpim (RFC 3862) usually. The final
+// response to a MESSAGE sent, or its timeout, is reported to the
+// MessageResultHandler; a MESSAGE received is given to the MessageHandler,
+// answered with 200 OK first if the Pager answers automatically. It must
+// be added to the Provider as a Listener so it can see the MESSAGEs
+// received, and the responses and timeouts of those it sent.
+type Pager interface {
+	Listener
+
+	CreateMessage(to string, body TypedBody) (Request, error)
+	SendMessage(to string, body TypedBody) (ClientTransaction, error)
+
+	SetMessageHandler(handler MessageHandler)
+	SetResultHandler(handler MessageResultHandler)
+	SetAutoAnswer(autoAnswer bool)
+}
+
+// A MessageHandler is called with each MESSAGE received, and the
+// ResponseWriter answering it, which has written the 200 OK already if
+// the Pager answers automatically.
+type MessageHandler func(w ResponseWriter, msg Request)
+
+// A MessageResultHandler is called with the final response to a MESSAGE
+// sent, a 2xx once delivered, or nil if it timed out.
+type MessageResultHandler func(msg Request, resp Response)
+
+// A CPIMMessage is a message/cpim body (RFC 3862): the message headers
+// which identify the sender and the recipient end to end, and the content
+// of the message, of any MIME type.
+type CPIMMessage struct {
+	From     string
+	To       string
+	DateTime time.Time
+
+	ContentType string
+	Content     []byte
+}
+
+var ErrBadCPIM = errors.New("sip: invalid message/cpim body")
+
+////////////////////Implementation////////////////////////
+
+type pager struct {
+	mutex sync.Mutex
+
+	provider Provider
+	from     string
+
+	pending map[ClientTransaction]bool
+
+	messageHandler MessageHandler
+	resultHandler  MessageResultHandler
+	autoAnswer     bool
+}
+
+// from is the URI in the From of the MESSAGEs sent, e.g.
+// "sip:alice@example.com".
+func NewPager(provider Provider, from string) Pager {
+	this := &pager{}
+
+	this.provider = provider
+	this.from = from
+
+	this.pending = make(map[ClientTransaction]bool)
+
+	return this
+}
+
+// CreateMessage returns a MESSAGE to the URI to, carrying body. Its Via is
+// the listening point of the provider, with a branch of its BranchStrategy.
+func (this *pager) CreateMessage(to string, body TypedBody) (Request, error) {
+	req := NewRequest(MESSAGE, to, nil)
+
+	req.GetHeader().Set("Max-Forwards", "70")
+	req.GetHeader().Set("From", "<"+this.from+">;tag="+GenerateTag())
+	req.GetHeader().Set("To", "<"+to+">")
+	req.GetHeader().Set("Call-Id", GenerateCallId(""))
+	req.GetHeader().Set("Cseq", "1 "+MESSAGE)
+	req.SetBody(body)
+
+	via, err := getProviderVia(this.provider, req)
+	if err != nil {
+		return nil, err
+	}
+	req.GetHeader().Set("Via", via+";branch="+this.provider.GetBranchStrategy().GetBranch(req))
+
+	return req, nil
+}
+
+// SendMessage sends body to the URI to in a MESSAGE, whose final response
+// goes to the MessageResultHandler.
+func (this *pager) SendMessage(to string, body TypedBody) (ClientTransaction, error) {
+	req, err := this.CreateMessage(to, body)
+	if err != nil {
+		return nil, err
+	}
+	ct := this.provider.GetNewClientTransaction(req)
+
+	this.mutex.Lock()
+	this.pending[ct] = true
+	this.mutex.Unlock()
+
+	if err := ct.SendRequest(); err != nil {
+		this.mutex.Lock()
+		delete(this.pending, ct)
+		this.mutex.Unlock()
+		return nil, err
+	}
+	return ct, nil
+}
+
+func (this *pager) SetMessageHandler(handler MessageHandler) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.messageHandler = handler
+}
+
+func (this *pager) SetResultHandler(handler MessageResultHandler) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.resultHandler = handler
+}
+
+// SetAutoAnswer sets whether the MESSAGEs received are answered with 200 OK
+// before they are given to the MessageHandler.
+func (this *pager) SetAutoAnswer(autoAnswer bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.autoAnswer = autoAnswer
+}
+
+// finish reports the final response to the MESSAGE of ct, if the pager
+// sent it.
+func (this *pager) finish(ct ClientTransaction, resp Response) {
+	this.mutex.Lock()
+	pending := this.pending[ct]
+	delete(this.pending, ct)
+	handler := this.resultHandler
+	this.mutex.Unlock()
+
+	if pending && handler != nil {
+		handler(ct.GetRequest(), resp)
+	}
+}
+
+func (this *pager) ProcessRequest(requestEvent RequestEvent) {
+	msg := requestEvent.GetRequest()
+	w := requestEvent.GetResponseWriter()
+	if msg.GetMethod() != MESSAGE || w == nil {
+		return
+	}
+
+	this.mutex.Lock()
+	handler := this.messageHandler
+	autoAnswer := this.autoAnswer
+	this.mutex.Unlock()
+
+	if autoAnswer {
+		if err := w.Respond(OK, nil); err != nil {
+			return
+		}
+	}
+	if handler != nil {
+		handler(w, msg)
+	}
+}
+
+func (this *pager) ProcessResponse(responseEvent ResponseEvent) {
+	if resp := responseEvent.GetResponse(); resp.GetStatusCode() >= OK {
+		this.finish(responseEvent.GetClientTransaction(), resp)
+	}
+}
+
+func (this *pager) ProcessTimeout(timeoutEvent TimeoutEvent) {
+	if ct, ok := timeoutEvent.GetTransaction().(ClientTransaction); ok {
+		this.finish(ct, nil)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// NewCPIMBody returns the message/cpim body of msg.
+func NewCPIMBody(msg *CPIMMessage) TypedBody {
+	var b bytes.Buffer
+	if msg.From != "" {
+		b.WriteString("From: " + msg.From + "\r\n")
+	}
+	if msg.To != "" {
+		b.WriteString("To: " + msg.To + "\r\n")
+	}
+	if !msg.DateTime.IsZero() {
+		b.WriteString("DateTime: " + msg.DateTime.Format(time.RFC3339) + "\r\n")
+	}
+	b.WriteString("\r\n")
+	if msg.ContentType != "" {
+		b.WriteString("Content-Type: " + msg.ContentType + "\r\n")
+	}
+	b.WriteString("\r\n")
+	b.Write(msg.Content)
+
+	return NewTypedBody(CONTENTTYPE_CPIM, "", b.Bytes())
+}
+
+// ParseCPIM reads a message/cpim body: its message headers, then the MIME
+// headers and the content of the message.
+func ParseCPIM(body []byte) (*CPIMMessage, error) {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(body)))
+	headers, err := r.ReadMIMEHeader()
+	if err != nil {
+		return nil, ErrBadCPIM
+	}
+	mime, err := r.ReadMIMEHeader()
+	if err != nil {
+		return nil, ErrBadCPIM
+	}
+	content, _ := ioutil.ReadAll(r.R)
+
+	msg := &CPIMMessage{}
+	msg.From = headers.Get("From")
+	msg.To = headers.Get("To")
+	if v := headers.Get("DateTime"); v != "" {
+		if msg.DateTime, err = time.Parse(time.RFC3339, strings.TrimSpace(v)); err != nil {
+			return nil, ErrBadCPIM
+		}
+	}
+	msg.ContentType = mime.Get("Content-Type")
+	msg.Content = content
+	return msg, nil
+}
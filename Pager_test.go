@@ -0,0 +1,135 @@
+package sip
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPagerSend(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	p.AddTransport(newTransport(UDP, "192.0.2.1", 5070, nil))
+	go p.Run()
+	defer p.Stop()
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.7", 5060, UDP)}, nil
+	}))
+	p.SetBranchStrategy(StatelessBranch)
+
+	pager := NewPager(p, "sip:alice@example.com")
+	p.AddListener(pager)
+	results := make(chan Response, 2)
+	pager.SetResultHandler(func(msg Request, resp Response) { results <- resp })
+
+	//a MESSAGE is sent outside of any dialog, and its 2xx reported
+	if _, err := pager.SendMessage("sip:bob@example.com", NewTextBody("Watson, come here.")); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, 1)
+	msg := findSent(sent, MESSAGE)
+	h := msg.GetHeader()
+	if h.Get("To") != "<sip:bob@example.com>" || getTag(h.Get("From")) == "" || h.Get("Cseq") != "1 MESSAGE" || h.Get("Content-Type") != CONTENTTYPE_TEXT || string(msg.GetBodyBytes()) != "Watson, come here." {
+		t.Fatalf("MESSAGE %v with %q", h, msg.GetBodyBytes())
+	}
+	//from the listening point of the provider, with a branch of its strategy
+	unsent := NewRequest(MESSAGE, "sip:bob@example.com", nil)
+	for _, name := range []string{"From", "To", "Call-Id", "Cseq"} {
+		unsent.GetHeader().Set(name, h.Get(name))
+	}
+	if via := h.Get("Via"); via != "SIP/2.0/UDP 192.0.2.1:5070;branch="+StatelessBranch.GetBranch(unsent) {
+		t.Errorf("MESSAGE sent with Via %q", via)
+	}
+	p.processResponse(withToTag(CreateResponse(msg, ACCEPTED), "b1"))
+	select {
+	case resp := <-results:
+		if resp == nil || resp.GetStatusCode() != ACCEPTED {
+			t.Errorf("MESSAGE delivered with %v", resp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no result")
+	}
+
+	//one never answered times out
+	if _, err := pager.SendMessage("sip:carol@example.com", NewTextBody("hello")); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, 2)
+	clock.Advance(64 * TIMER_T1)
+	select {
+	case resp := <-results:
+		if resp != nil {
+			t.Errorf("unanswered MESSAGE ended with %v", resp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no timeout")
+	}
+}
+
+func TestPagerReceive(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+
+	pager := NewPager(p, "sip:bob@example.com")
+	p.AddListener(pager)
+	var received []Request
+	pager.SetMessageHandler(func(w ResponseWriter, msg Request) {
+		received = append(received, msg)
+		if !w.Written() {
+			w.Respond(UNSUPPORTED_MEDIA_TYPE, nil)
+		}
+	})
+
+	//a MESSAGE received is answered automatically, if asked to
+	pager.SetAutoAnswer(true)
+	msg := newServerTestRequest(MESSAGE, "UDP", "z9hG4bKpg1")
+	msg.SetBody(NewTextBody("hi"))
+	p.processMessage(msg)
+	waitSent(t, sent, 1)
+	resp, ok := sent.last().(Response)
+	if !ok || resp.GetStatusCode() != OK || getTag(resp.GetHeader().Get("To")) == "" || resp.GetHeader().Get("Contact") != "" {
+		t.Fatalf("MESSAGE answered %v", sent.last())
+	}
+	if len(received) != 1 || string(received[0].GetBodyBytes()) != "hi" {
+		t.Fatalf("handler got %d MESSAGEs", len(received))
+	}
+
+	//otherwise by the handler
+	pager.SetAutoAnswer(false)
+	p.processMessage(newServerTestRequest(MESSAGE, "UDP", "z9hG4bKpg2"))
+	waitSent(t, sent, 2)
+	if resp, ok := sent.last().(Response); !ok || resp.GetStatusCode() != UNSUPPORTED_MEDIA_TYPE {
+		t.Errorf("MESSAGE answered %v", sent.last())
+	}
+}
+
+func TestCPIM(t *testing.T) {
+	body := NewCPIMBody(&CPIMMessage{
+		From:        "MR SANDERS <im:piglet@100akerwood.com>",
+		To:          "Depressed Donkey <im:eeyore@100akerwood.com>",
+		DateTime:    time.Date(2000, 12, 13, 13, 40, 0, 0, time.UTC),
+		ContentType: CONTENTTYPE_TEXT,
+		Content:     []byte("Here is the text of my message.\r\n"),
+	})
+	if body.GetContentType() != CONTENTTYPE_CPIM {
+		t.Errorf("Content-Type %s", body.GetContentType())
+	}
+	req := NewRequest(MESSAGE, "sip:bob@example.com", nil)
+	req.SetBody(body)
+	msg, err := ParseCPIM(req.GetBodyBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.From != "MR SANDERS <im:piglet@100akerwood.com>" || msg.To != "Depressed Donkey <im:eeyore@100akerwood.com>" || !msg.DateTime.Equal(time.Date(2000, 12, 13, 13, 40, 0, 0, time.UTC)) {
+		t.Errorf("message headers %+v", msg)
+	}
+	if msg.ContentType != CONTENTTYPE_TEXT || string(msg.Content) != "Here is the text of my message.\r\n" {
+		t.Errorf("content %s %q", msg.ContentType, msg.Content)
+	}
+	if _, err := ParseCPIM([]byte("From: <im:piglet@100akerwood.com>\r\n")); err != ErrBadCPIM {
+		t.Errorf("truncated body parsed with %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package sip
+
+import (
+	"errors"
+	"sip/header"
+	"sip/parser"
+)
+
+// The proxies a REGISTER goes through on its way to the registrar may add
+// themselves to its Path (RFC 3327), to be on the path of the requests to
+// the contact registered as well. The Registrar keeps the Path with the
+// bindings of the REGISTER, and returns it in the 2xx if the UA supports
+// path; a Proxy sending a request to a contact preloads it with a Route
+// holding the Path of its binding. The other way round, the registrar may
+// answer a REGISTER with a Service-Route (RFC 3608), the proxies the
+// requests of the UA are to go through: the UserAgent keeps the one of
+// its last registration, and preloads it as the Route of the requests it
+// sends outside of a dialog.
+
+// The option tag of RFC 3327.
+const OPTIONTAG_PATH = "path"
+
+var (
+	ErrBadPath         = errors.New("sip: invalid Path")
+	ErrBadServiceRoute = errors.New("sip: invalid Service-Route")
+)
+
+////////////////////Implementation////////////////////////
+
+// GetPath returns the Path values of msg, in order.
+func GetPath(msg Message) ([]string, error) {
+	var path []string
+	for _, v := range msg.GetHeader()["Path"] {
+		sh, err := parser.NewPathParser("Path: " + v + "\n").Parse()
+		if err != nil {
+			return nil, ErrBadPath
+		}
+		for e := sh.(*header.PathList).Front(); e != nil; e = e.Next() {
+			path = append(path, e.Value.(*header.Path).EncodeBody())
+		}
+	}
+	return path, nil
+}
+
+// GetServiceRoute returns the Service-Route values of msg, in order.
+func GetServiceRoute(msg Message) ([]string, error) {
+	var route []string
+	for _, v := range msg.GetHeader()["Service-Route"] {
+		sh, err := parser.NewServiceRouteParser("Service-Route: " + v + "\n").Parse()
+		if err != nil {
+			return nil, ErrBadServiceRoute
+		}
+		for e := sh.(*header.ServiceRouteList).Front(); e != nil; e = e.Next() {
+			route = append(route, e.Value.(*header.ServiceRoute).EncodeBody())
+		}
+	}
+	return route, nil
+}
+
+// toBinding prepares req, retargeted to the contact of b, to be sent to it:
+// it preloads the Route of req with the Path of b, and returns the flow to
+// send req over, if any.
+func (this *proxy) toBinding(req Request, b Binding) Hop {
+	for _, route := range b.Path {
+		req.GetHeader().Add("Route", route)
+	}
+	return this.getFlow(b)
+}
@@ -0,0 +1,125 @@
+package sip
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRegistrarPath(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	location := NewLocationService(clock)
+	r := NewRegistrar(nil, location, clock)
+
+	//the Path is stored, and returned to a UA which supports it
+	req := newRegister(1, "3600", "<sip:bob@192.0.2.4>")
+	req.GetHeader().Set("Path", "<sip:p2.example.com;lr>, <sip:p1.example.com;lr>")
+	req.GetHeader().Set("Supported", OPTIONTAG_PATH)
+	resp := register(r, req)
+	if resp.GetStatusCode() != OK {
+		t.Fatalf("REGISTER answered %d", resp.GetStatusCode())
+	}
+	if path := resp.GetHeader()["Path"]; len(path) != 2 || path[0] != "<sip:p2.example.com;lr>" || path[1] != "<sip:p1.example.com;lr>" {
+		t.Errorf("Path %q", path)
+	}
+	bindings, _ := location.Lookup("sip:bob@biloxi.com")
+	if len(bindings) != 1 || len(bindings[0].Path) != 2 || bindings[0].Path[0] != "<sip:p2.example.com;lr>" {
+		t.Fatalf("bindings %+v", bindings)
+	}
+
+	//but not to one which does not
+	req = newRegister(2, "3600", "<sip:bob@192.0.2.4>")
+	req.GetHeader().Set("Path", "<sip:p3.example.com;lr>")
+	if resp := register(r, req); resp.GetStatusCode() != OK || resp.GetHeader().Get("Path") != "" {
+		t.Errorf("REGISTER answered %d with Path %q", resp.GetStatusCode(), resp.GetHeader().Get("Path"))
+	}
+	bindings, _ = location.Lookup("sip:bob@biloxi.com")
+	if len(bindings) != 1 || len(bindings[0].Path) != 1 || bindings[0].Path[0] != "<sip:p3.example.com;lr>" {
+		t.Errorf("bindings %+v", bindings)
+	}
+
+	req = newRegister(3, "3600", "<sip:bob@192.0.2.4>")
+	req.GetHeader().Set("Path", "<sip:p3.example.com;lr> x")
+	if resp := register(r, req); resp.GetStatusCode() != BAD_REQUEST {
+		t.Errorf("invalid Path answered %d", resp.GetStatusCode())
+	}
+}
+
+func TestProxyPath(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.12", 5060, UDP)}, nil
+	}))
+
+	location := NewLocationService(clock)
+	p.AddListener(NewProxy(p, "192.0.2.1", 5060, location, true))
+	p.SetTryingPolicy(INVITE, TRYINGPOLICY_NEVER)
+
+	//a request to a contact is routed through the Path of its binding
+	location.Store("sip:bob@biloxi.com", []Binding{
+		{AOR: "sip:bob@biloxi.com", URI: "sip:bob@10.0.0.4", Q: -1, Expires: clock.Now().Add(time.Hour),
+			Path: []string{"<sip:p2.example.com;lr>", "<sip:p1.example.com;lr>"}},
+	})
+	p.processMessage(newProxiedRequest(INVITE, "sip:bob@biloxi.com", "z9hG4bKpt1"))
+	fwd := waitForwarded(t, sent, 0, INVITE, "sip:bob@10.0.0.4")
+	if route := fwd.GetHeader()["Route"]; len(route) != 2 || route[0] != "<sip:p2.example.com;lr>" || route[1] != "<sip:p1.example.com;lr>" {
+		t.Errorf("INVITE forwarded with Route %q", route)
+	}
+}
+
+func TestUserAgentServiceRoute(t *testing.T) {
+	p, sent := newUserAgentTestProvider()
+	defer p.Stop()
+
+	ua, err := NewUserAgent(p, "sip:alice@example.com", "sip:alice@192.0.2.1:5070")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddListener(ua)
+
+	register := func(expires time.Duration, serviceRoute ...string) {
+		results := make(chan error, 1)
+		n := sent.len()
+		go func() {
+			_, err := ua.Register(context.Background(), expires)
+			results <- err
+		}()
+		waitSent(t, sent, n+1)
+		req := findSent(sent, REGISTER)
+		if route := req.GetHeader().Get("Route"); route != "" {
+			t.Errorf("REGISTER sent with Route %q", route)
+		}
+		resp := withToTag(CreateResponse(req, OK), "r1")
+		for _, v := range serviceRoute {
+			resp.GetHeader().Add("Service-Route", v)
+		}
+		p.processResponse(resp)
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//the requests sent after registering go through the Service-Route
+	register(time.Hour, "<sip:p2.home.example.com;lr>, <sip:hsp.home.example.com;lr>")
+	if route := ua.GetServiceRoute(); len(route) != 2 || route[0] != "<sip:p2.home.example.com;lr>" || route[1] != "<sip:hsp.home.example.com;lr>" {
+		t.Fatalf("service route %q", route)
+	}
+	if _, err := ua.Invite(context.Background(), "sip:bob@example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, 2)
+	invite := findSent(sent, INVITE)
+	if route := invite.GetHeader()["Route"]; len(route) != 2 || route[0] != "<sip:p2.home.example.com;lr>" || route[1] != "<sip:hsp.home.example.com;lr>" {
+		t.Errorf("INVITE sent with Route %q", route)
+	}
+
+	//until the binding is removed
+	register(0, "<sip:p2.home.example.com;lr>")
+	if route := ua.GetServiceRoute(); len(route) != 0 {
+		t.Errorf("service route %q once unregistered", route)
+	}
+}
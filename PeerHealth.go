@@ -0,0 +1,384 @@
+package sip
+
+import (
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+type PeerState int
+
+const (
+	PEERSTATE_UP       PeerState = iota //0
+	PEERSTATE_DEGRADED                  //1
+	PEERSTATE_DOWN                      //2
+	PEERSTATE_PROBING                   //3
+)
+
+func (this PeerState) String() string {
+	switch this {
+	case PEERSTATE_UP:
+		return "up"
+	case PEERSTATE_DEGRADED:
+		return "degraded"
+	case PEERSTATE_DOWN:
+		return "down"
+	case PEERSTATE_PROBING:
+		return "probing"
+	}
+	return "unknown"
+}
+
+type PeerStateHandler func(peer string, from, to PeerState)
+
+type PeerFailure int
+
+const (
+	PEERFAILURE_TRANSPORT           PeerFailure = iota //0
+	PEERFAILURE_TIMEOUT                                //1
+	PEERFAILURE_SERVICE_UNAVAILABLE                    //2
+	PEERFAILURE_KEEPALIVE                              //3
+)
+
+type PeerHealthStats struct {
+	State       PeerState
+	Successes   uint64
+	Failures    uint64
+	Transitions uint64
+	Consecutive int
+	LastRTT     time.Duration
+	LastChange  time.Time
+	NextProbe   time.Time
+}
+
+// PeerHealth is the single source of truth about destination availability.
+// Transports, transactions and the KeepaliveMonitor report into it, and
+// routing layers consult IsAvailable before choosing a destination. Given
+// to a provider with SetPeerHealth, it learns the outcome of the requests
+// the provider sends, and the provider skips the addresses it has down.
+//
+// A destination goes DEGRADED on its first failure and DOWN once the
+// consecutive failures reach the threshold. While DOWN, IsAvailable lets
+// a single probe through after an exponentially growing back-off; the
+// destination is then PROBING until that probe succeeds or fails, or is
+// cancelled with CancelProbe. A probe whose outcome is not reported within
+// the back-off is given up, and the destination goes DOWN again.
+type PeerHealth interface {
+	ReportSuccess(peer string, rtt time.Duration)
+	ReportFailure(peer string, failure PeerFailure)
+
+	IsAvailable(peer string) bool
+	CancelProbe(peer string)
+	GetState(peer string) PeerState
+	GetStats(peer string) PeerHealthStats
+	GetPeers() []string
+	Reset(peer string)
+
+	SetMaxFailures(maxFailures int)
+	SetDegradedLatency(latency time.Duration)
+	SetProbeBackoff(min, max time.Duration)
+	SetStateHandler(handler PeerStateHandler)
+	SetClock(clock Clock)
+}
+
+////////////////////Implementation////////////////////////
+
+const (
+	PEERHEALTH_MAX_FAILURES      = 3
+	PEERHEALTH_DEGRADED_LATENCY  = 2 * time.Second
+	PEERHEALTH_MIN_PROBE_BACKOFF = 1 * time.Second
+	PEERHEALTH_MAX_PROBE_BACKOFF = 5 * time.Minute
+)
+
+type peerHealthEntry struct {
+	PeerHealthStats
+
+	backoff time.Duration
+}
+
+type peerHealth struct {
+	mutex sync.Mutex
+
+	peers map[string]*peerHealthEntry
+
+	maxFailures     int
+	degradedLatency time.Duration
+	minBackoff      time.Duration
+	maxBackoff      time.Duration
+	handler         PeerStateHandler
+	clock           Clock
+}
+
+func NewPeerHealth() PeerHealth {
+	this := &peerHealth{}
+
+	this.peers = make(map[string]*peerHealthEntry)
+
+	this.maxFailures = PEERHEALTH_MAX_FAILURES
+	this.degradedLatency = PEERHEALTH_DEGRADED_LATENCY
+	this.minBackoff = PEERHEALTH_MIN_PROBE_BACKOFF
+	this.maxBackoff = PEERHEALTH_MAX_PROBE_BACKOFF
+	this.clock = RealClock
+
+	return this
+}
+
+func (this *peerHealth) SetMaxFailures(maxFailures int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.maxFailures = maxFailures
+}
+
+func (this *peerHealth) SetDegradedLatency(latency time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.degradedLatency = latency
+}
+
+func (this *peerHealth) SetProbeBackoff(min, max time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.minBackoff = min
+	this.maxBackoff = max
+}
+
+func (this *peerHealth) SetStateHandler(handler PeerStateHandler) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.handler = handler
+}
+
+func (this *peerHealth) SetClock(clock Clock) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.clock = clock
+}
+
+func (this *peerHealth) entry(peer string) *peerHealthEntry {
+	e, ok := this.peers[peer]
+	if !ok {
+		e = &peerHealthEntry{}
+		e.State = PEERSTATE_UP
+		this.peers[peer] = e
+	}
+	return e
+}
+
+func (this *peerHealth) ReportSuccess(peer string, rtt time.Duration) {
+	this.mutex.Lock()
+	e := this.entry(peer)
+	e.Successes++
+	e.Consecutive = 0
+	e.LastRTT = rtt
+	e.backoff = 0
+	from := e.State
+	if rtt > this.degradedLatency {
+		this.transit(e, PEERSTATE_DEGRADED)
+	} else {
+		this.transit(e, PEERSTATE_UP)
+	}
+	to := e.State
+	this.mutex.Unlock()
+
+	this.notify(peer, from, to)
+}
+
+func (this *peerHealth) ReportFailure(peer string, failure PeerFailure) {
+	this.mutex.Lock()
+	e := this.entry(peer)
+	e.Failures++
+	e.Consecutive++
+	from := e.State
+	if e.State == PEERSTATE_PROBING || e.State == PEERSTATE_DOWN ||
+		e.Consecutive >= this.maxFailures {
+		this.down(e)
+	} else {
+		this.transit(e, PEERSTATE_DEGRADED)
+	}
+	to := e.State
+	this.mutex.Unlock()
+
+	this.notify(peer, from, to)
+}
+
+// down (re)arms the probe-back timer, doubling the back-off each time a
+// probe fails.
+func (this *peerHealth) down(e *peerHealthEntry) {
+	if e.backoff == 0 {
+		e.backoff = this.minBackoff
+	} else if e.State == PEERSTATE_PROBING {
+		e.backoff *= 2
+		if e.backoff > this.maxBackoff {
+			e.backoff = this.maxBackoff
+		}
+	}
+	this.transit(e, PEERSTATE_DOWN)
+	e.NextProbe = e.LastChange.Add(e.backoff)
+}
+
+func (this *peerHealth) transit(e *peerHealthEntry, state PeerState) {
+	if e.State != state {
+		e.State = state
+		e.Transitions++
+		e.LastChange = this.clock.Now()
+	}
+}
+
+func (this *peerHealth) notify(peer string, from, to PeerState) {
+	if from == to {
+		return
+	}
+	this.mutex.Lock()
+	handler := this.handler
+	this.mutex.Unlock()
+
+	if handler != nil {
+		handler(peer, from, to)
+	}
+}
+
+func (this *peerHealth) IsAvailable(peer string) bool {
+	this.mutex.Lock()
+	e, ok := this.peers[peer]
+	if !ok {
+		this.mutex.Unlock()
+		return true
+	}
+	from := e.State
+	available := true
+	switch e.State {
+	case PEERSTATE_DOWN:
+		if this.clock.Now().Before(e.NextProbe) {
+			available = false
+		} else {
+			this.transit(e, PEERSTATE_PROBING)
+		}
+	case PEERSTATE_PROBING:
+		//only one probe at a time, given up after the back-off
+		if !this.clock.Now().Before(e.LastChange.Add(e.backoff)) {
+			this.down(e)
+		}
+		available = false
+	}
+	to := e.State
+	this.mutex.Unlock()
+
+	this.notify(peer, from, to)
+	return available
+}
+
+// CancelProbe ends the probe of peer, if it is PROBING, without an outcome:
+// its request was abandoned. The next request may probe it again.
+func (this *peerHealth) CancelProbe(peer string) {
+	this.mutex.Lock()
+	e, ok := this.peers[peer]
+	if !ok || e.State != PEERSTATE_PROBING {
+		this.mutex.Unlock()
+		return
+	}
+	this.transit(e, PEERSTATE_DOWN)
+	e.NextProbe = e.LastChange
+	this.mutex.Unlock()
+
+	this.notify(peer, PEERSTATE_PROBING, PEERSTATE_DOWN)
+}
+
+func (this *peerHealth) GetState(peer string) PeerState {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if e, ok := this.peers[peer]; ok {
+		return e.State
+	}
+	return PEERSTATE_UP
+}
+
+func (this *peerHealth) GetStats(peer string) PeerHealthStats {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if e, ok := this.peers[peer]; ok {
+		return e.PeerHealthStats
+	}
+	return PeerHealthStats{State: PEERSTATE_UP}
+}
+
+func (this *peerHealth) GetPeers() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	peers := make([]string, 0, len(this.peers))
+	for peer := range this.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+func (this *peerHealth) Reset(peer string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	delete(this.peers, peer)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (this *provider) GetPeerHealth() PeerHealth {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.health
+}
+
+// SetPeerHealth sets what the provider reports the outcome of its client
+// transactions to, and consults before sending a request to an address:
+// the transport errors, the timeouts and the 503 responses as failures,
+// the other responses as successes. nil, the default, reports nothing.
+func (this *provider) SetPeerHealth(health PeerHealth) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.health = health
+}
+
+// reportPeerSuccess reports that h answered after rtt.
+func (this *provider) reportPeerSuccess(h Hop, rtt time.Duration) {
+	if health := this.GetPeerHealth(); health != nil {
+		destination := getHopDestination(h)
+		health.ReportSuccess(destination, rtt)
+		this.exportPeerState(health, destination)
+	}
+}
+
+// reportPeerFailure reports that h failed.
+func (this *provider) reportPeerFailure(h Hop, failure PeerFailure) {
+	if health := this.GetPeerHealth(); health != nil {
+		destination := getHopDestination(h)
+		health.ReportFailure(destination, failure)
+		this.exportPeerState(health, destination)
+	}
+}
+
+// cancelPeerProbe ends the probe of h, if it is one, whose request was
+// abandoned before any outcome.
+func (this *provider) cancelPeerProbe(h Hop) {
+	if health := this.GetPeerHealth(); health != nil {
+		destination := getHopDestination(h)
+		health.CancelProbe(destination)
+		this.exportPeerState(health, destination)
+	}
+}
+
+// exportPeerState sets the state of destination in health to the Metrics
+// of the provider.
+func (this *provider) exportPeerState(health PeerHealth, destination string) {
+	if metrics := this.GetMetrics(); metrics != nil {
+		metrics.SetPeerState(destination, health.GetState(destination))
+	}
+}
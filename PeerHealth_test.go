@@ -0,0 +1,260 @@
+package sip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeerHealth(t *testing.T) {
+	const peer = "sip:trunk.example.com"
+
+	var transitions []PeerState
+
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	h := NewPeerHealth()
+	h.SetClock(clock)
+	h.SetMaxFailures(2)
+	h.SetProbeBackoff(10*time.Millisecond, 40*time.Millisecond)
+	h.SetStateHandler(func(p string, from, to PeerState) {
+		transitions = append(transitions, to)
+	})
+
+	if !h.IsAvailable(peer) || h.GetState(peer) != PEERSTATE_UP {
+		t.Fatal("unknown peer should be up")
+	}
+
+	h.ReportFailure(peer, PEERFAILURE_TIMEOUT)
+	if h.GetState(peer) != PEERSTATE_DEGRADED {
+		t.Fatalf("state = %v, want degraded", h.GetState(peer))
+	}
+	h.ReportFailure(peer, PEERFAILURE_SERVICE_UNAVAILABLE)
+	if h.GetState(peer) != PEERSTATE_DOWN || h.IsAvailable(peer) {
+		t.Fatalf("state = %v, want down and unavailable", h.GetState(peer))
+	}
+
+	clock.Advance(15 * time.Millisecond)
+	if !h.IsAvailable(peer) || h.GetState(peer) != PEERSTATE_PROBING {
+		t.Fatalf("state = %v, want probing", h.GetState(peer))
+	}
+	if h.IsAvailable(peer) {
+		t.Fatal("only one probe should be let through")
+	}
+
+	h.ReportFailure(peer, PEERFAILURE_KEEPALIVE)
+	stats := h.GetStats(peer)
+	if stats.State != PEERSTATE_DOWN || stats.NextProbe.Sub(stats.LastChange) != 20*time.Millisecond {
+		t.Fatalf("back-off not doubled: %+v", stats)
+	}
+
+	h.ReportSuccess(peer, time.Millisecond)
+	if h.GetState(peer) != PEERSTATE_UP || !h.IsAvailable(peer) {
+		t.Fatalf("state = %v, want up", h.GetState(peer))
+	}
+
+	want := []PeerState{PEERSTATE_DEGRADED, PEERSTATE_DOWN, PEERSTATE_PROBING, PEERSTATE_DOWN, PEERSTATE_UP}
+	if len(transitions) != len(want) {
+		t.Fatalf("transitions = %v, want %v", transitions, want)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Fatalf("transitions = %v, want %v", transitions, want)
+		}
+	}
+}
+
+func TestProviderPeerHealth(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	health := NewPeerHealth()
+	health.SetClock(clock)
+	health.SetMaxFailures(1)
+	p.SetPeerHealth(health)
+	m := NewPrometheusMetrics()
+	p.SetMetrics(m)
+	sent := captureSends(p)
+	send := p.send
+	p.send = func(msg Message, h Hop) error {
+		if h.GetHost() == "192.0.2.1" {
+			return errors.New("connection refused")
+		}
+		return send(msg, h)
+	}
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.1", 5060, UDP), NewHop("192.0.2.2", 5060, UDP), NewHop("192.0.2.3", 5060, UDP)}, nil
+	}))
+	go p.Run()
+	defer p.Stop()
+
+	sendOptions := func(n int) ClientTransaction {
+		req := NewRequest(OPTIONS, "sip:bob@biloxi.com", nil)
+		req.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.9;branch="+GenerateBranchId())
+		req.GetHeader().Set("Cseq", "1 OPTIONS")
+		ct := p.GetNewClientTransaction(req)
+		if err := ct.SendRequest(); err != nil {
+			t.Fatal(err)
+		}
+		waitSent(t, sent, n)
+		return ct
+	}
+	lastHost := func() string {
+		sent.mutex.Lock()
+		defer sent.mutex.Unlock()
+
+		return sent.hops[len(sent.hops)-1].GetHost()
+	}
+
+	//a transport error takes the first address down, an answer keeps the
+	//second up
+	ct := sendOptions(1)
+	p.processResponse(CreateResponse(ct.GetRequest(), OK))
+	if health.GetState("192.0.2.1:5060") != PEERSTATE_DOWN || health.GetState("192.0.2.2:5060") != PEERSTATE_UP {
+		t.Fatalf("peers %v and %v", health.GetState("192.0.2.1:5060"), health.GetState("192.0.2.2:5060"))
+	}
+
+	//the next request skips the first address, down, the second times out,
+	//and the third answers 503
+	ct = sendOptions(2)
+	if host := lastHost(); host != "192.0.2.2" {
+		t.Fatalf("sent to %s, want the second address", host)
+	}
+	clock.Advance(64 * TIMER_T1)
+	if host := lastHost(); host != "192.0.2.3" {
+		t.Fatalf("sent to %s, want the third address", host)
+	}
+	p.processResponse(CreateResponse(ct.GetRequest(), SERVICE_UNAVAILABLE))
+	for _, peer := range []string{"192.0.2.1:5060", "192.0.2.2:5060", "192.0.2.3:5060"} {
+		if stats := health.GetStats(peer); stats.State != PEERSTATE_DOWN || stats.Failures != 1 {
+			t.Errorf("%s %+v", peer, stats)
+		}
+	}
+
+	//and the states are exported
+	var b bytes.Buffer
+	m.WriteTo(&b)
+	for _, line := range []string{
+		`sip_peer_state{peer="192.0.2.1:5060"} 2`,
+		`sip_peer_state{peer="192.0.2.2:5060"} 2`,
+		`sip_peer_state{peer="192.0.2.3:5060"} 2`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("metrics\n%s\nwithout %s", b.String(), line)
+		}
+	}
+}
+
+func TestPeerHealthProbe(t *testing.T) {
+	const peer = "192.0.2.2:5060"
+
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := NewPeerHealth()
+	h.SetClock(clock)
+	h.SetMaxFailures(1)
+	h.SetProbeBackoff(time.Second, time.Minute)
+
+	h.ReportFailure(peer, PEERFAILURE_TIMEOUT)
+	clock.Advance(time.Second)
+	if !h.IsAvailable(peer) || h.GetState(peer) != PEERSTATE_PROBING {
+		t.Fatalf("state = %v, want probing", h.GetState(peer))
+	}
+
+	//a probe whose outcome is never reported is given up after the back-off
+	clock.Advance(time.Second - time.Millisecond)
+	if h.IsAvailable(peer) || h.GetState(peer) != PEERSTATE_PROBING {
+		t.Fatalf("state = %v, want probing", h.GetState(peer))
+	}
+	clock.Advance(time.Millisecond)
+	if h.IsAvailable(peer) {
+		t.Fatal("probe given up let through")
+	}
+	stats := h.GetStats(peer)
+	if stats.State != PEERSTATE_DOWN || stats.NextProbe.Sub(stats.LastChange) != 2*time.Second {
+		t.Fatalf("probe given up: %+v", stats)
+	}
+
+	//and one cancelled lets the next request probe at once
+	clock.Advance(2 * time.Second)
+	if !h.IsAvailable(peer) {
+		t.Fatal("second probe not let through")
+	}
+	h.CancelProbe(peer)
+	if h.GetState(peer) != PEERSTATE_DOWN || !h.IsAvailable(peer) {
+		t.Fatalf("state = %v after the probe was cancelled", h.GetState(peer))
+	}
+}
+
+func TestProviderPeerProbe(t *testing.T) {
+	const peer = "192.0.2.2:5060"
+
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	health := NewPeerHealth()
+	health.SetClock(clock)
+	health.SetMaxFailures(1)
+	health.SetProbeBackoff(time.Second, time.Minute)
+	p.SetPeerHealth(health)
+	sent := captureSends(p)
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.2", 5060, UDP)}, nil
+	}))
+	go p.Run()
+	defer p.Stop()
+
+	//takes the peer down, with a probe due
+	down := func() {
+		health.ReportFailure(peer, PEERFAILURE_TIMEOUT)
+		clock.Advance(time.Minute)
+	}
+	newOptions := func() Request {
+		req := NewRequest(OPTIONS, "sip:bob@biloxi.com", nil)
+		req.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.9;branch="+GenerateBranchId())
+		req.GetHeader().Set("Max-Forwards", "70")
+		req.GetHeader().Set("From", "<sip:alice@atlanta.com>;tag=1")
+		req.GetHeader().Set("To", "<sip:bob@biloxi.com>")
+		req.GetHeader().Set("Call-Id", GenerateCallId(""))
+		req.GetHeader().Set("Cseq", "1 OPTIONS")
+		return req
+	}
+
+	//a request sent statelessly is the success of its probe
+	down()
+	if err := p.SendRequest(newOptions()); err != nil {
+		t.Fatal(err)
+	}
+	if state := health.GetState(peer); state != PEERSTATE_UP {
+		t.Errorf("state = %v after a stateless send", state)
+	}
+
+	//a transaction abandoned before any response cancels it
+	down()
+	ct := p.GetNewClientTransaction(newOptions())
+	if err := ct.SendRequest(); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, 2)
+	if state := health.GetState(peer); state != PEERSTATE_PROBING {
+		t.Fatalf("state = %v, want probing", state)
+	}
+	ct.Close()
+	if stats := health.GetStats(peer); stats.State != PEERSTATE_DOWN || stats.NextProbe != stats.LastChange {
+		t.Errorf("probe of an abandoned transaction: %+v", stats)
+	}
+
+	//and a request forwarded by a stateless proxy succeeds it too
+	location := NewLocationService(clock)
+	location.Store("sip:bob@biloxi.com", []Binding{{AOR: "sip:bob@biloxi.com", URI: "sip:bob@192.0.2.4", Q: -1, Expires: clock.Now().Add(time.Hour)}})
+	p.AddListener(NewProxy(p, "192.0.2.1", 5060, location, false))
+	down()
+	p.processMessage(newProxiedRequest(OPTIONS, "sip:bob@biloxi.com", "z9hG4bKprobe"))
+	waitForwarded(t, sent, 2, OPTIONS, "sip:bob@192.0.2.4")
+	for i := 0; health.GetState(peer) != PEERSTATE_UP; i++ {
+		if i == 100 {
+			t.Fatalf("state = %v after a stateless proxy forwarded", health.GetState(peer))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,107 @@
+package sip
+
+import (
+	"bytes"
+	"net"
+	"sync/atomic"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A FilterVerdict is what a PreParseFilter makes of a datagram.
+type FilterVerdict int
+
+const (
+	FILTER_CONTINUE FilterVerdict = iota //0, the datagram is parsed
+	FILTER_DROP                          //the datagram is dropped unparsed
+)
+
+// A PreParseFilter is given each datagram a UDP transport of the provider
+// reads, with the address it came from, before it is parsed: a flood of
+// scanners, or of garbage, can then be dropped for the cost of a few byte
+// comparisons. data is only valid during the call. The datagrams dropped
+// are counted in the Filtered metric of the transport.
+type PreParseFilter func(data []byte, source net.Addr) FilterVerdict
+
+// ScannerFilter returns a PreParseFilter dropping the datagrams which do
+// not start as a SIP message or a CRLF keep-alive does, and those with a
+// User-Agent containing one of userAgents, compared case-insensitively,
+// e.g. "friendly-scanner" or "sipvicious".
+func ScannerFilter(userAgents ...string) PreParseFilter {
+	var patterns [][]byte
+	for _, ua := range userAgents {
+		patterns = append(patterns, bytes.ToLower([]byte(ua)))
+	}
+
+	return func(data []byte, source net.Addr) FilterVerdict {
+		if len(data) == 0 {
+			return FILTER_DROP
+		}
+		if c := data[0]; c != '\r' && c != '\n' && (c < 'A' || c > 'Z') {
+			return FILTER_DROP
+		}
+		if ua := getRawHeader(data, "user-agent"); ua != nil {
+			ua = bytes.ToLower(ua)
+			for _, pattern := range patterns {
+				if bytes.Contains(ua, pattern) {
+					return FILTER_DROP
+				}
+			}
+		}
+		return FILTER_CONTINUE
+	}
+}
+
+////////////////////Implementation////////////////////////
+
+// getRawHeader returns the value of the first header name, all lowercase,
+// in the head of the raw message data, or nil if it has none.
+func getRawHeader(data []byte, name string) []byte {
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		line = bytes.TrimRight(line, "\r")
+		if len(line) == 0 {
+			//the end of the head
+			return nil
+		}
+		i := bytes.IndexByte(line, ':')
+		if i > 0 && bytes.EqualFold(bytes.TrimRight(line[:i], " \t"), []byte(name)) {
+			return bytes.TrimSpace(line[i+1:])
+		}
+	}
+	return nil
+}
+
+func (this *provider) GetPreParseFilter() PreParseFilter {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.preParseFilter
+}
+
+// SetPreParseFilter sets the filter given the datagrams read, nil by
+// default, for every datagram to be parsed.
+func (this *provider) SetPreParseFilter(filter PreParseFilter) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.preParseFilter = filter
+}
+
+// filterDatagram reports whether data, a datagram read by t from source,
+// is to be parsed.
+func (this *provider) filterDatagram(t Transport, data []byte, source net.Addr) bool {
+	filter := this.GetPreParseFilter()
+	if filter == nil || filter(data, source) != FILTER_DROP {
+		return true
+	}
+	if tr, ok := t.(*transport); ok {
+		atomic.AddUint64(&tr.filtered, 1)
+	}
+	return false
+}
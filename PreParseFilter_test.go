@@ -0,0 +1,90 @@
+package sip
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScannerFilter(t *testing.T) {
+	filter := ScannerFilter("friendly-scanner", "sipvicious")
+	scanner := strings.Replace(testOptions, "Content-Length", "User-Agent: Friendly-Scanner\r\nContent-Length", 1)
+	body := strings.Replace(testOptions, "Content-Length: 0\r\n\r\n", "Content-Length: 26\r\n\r\nUser-Agent: sipvicious\r\n", 1)
+
+	var tvi = []struct {
+		data string
+		want FilterVerdict
+	}{
+		{testOptions, FILTER_CONTINUE},
+		{testRinging, FILTER_CONTINUE},
+		{"\r\n\r\n", FILTER_CONTINUE},
+		{scanner, FILTER_DROP},
+		{body, FILTER_CONTINUE},
+		{"\x00\x01\x00\x00", FILTER_DROP},
+		{"", FILTER_DROP},
+	}
+	for i, tv := range tvi {
+		if v := filter([]byte(tv.data), nil); v != tv.want {
+			t.Errorf("%d: verdict %d, want %d", i, v, tv.want)
+		}
+	}
+}
+
+func TestPreParseFilter(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	tr := newTransport(UDP, "127.0.0.1", 0, nil)
+	if err := tr.Listen(); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan Message, 4)
+	p.dispatcher = NewDispatcher(1, func(msg Message) { received <- msg })
+	p.dispatcher.Start()
+	defer p.dispatcher.Stop()
+
+	var sources []net.Addr
+	p.SetPreParseFilter(func(data []byte, source net.Addr) FilterVerdict {
+		sources = append(sources, source)
+		if strings.Contains(string(data), "sipvicious") {
+			return FILTER_DROP
+		}
+		return FILTER_CONTINUE
+	})
+
+	conn, err := tr.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.spawn(resourceServe, func() { p.ServeConn(tr, conn) })
+	defer func() {
+		close(p.quit)
+		conn.Close()
+		p.waitGroup.Wait()
+	}()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	//the filtered datagram is neither parsed nor counted as malformed
+	client.Write([]byte(strings.Replace(testOptions, "Content-Length", "User-Agent: sipvicious\r\nContent-Length", 1)))
+	client.Write([]byte(testOptions))
+
+	select {
+	case msg := <-received:
+		if msg.GetHeader().Get("User-Agent") != "" {
+			t.Errorf("filtered message received:\n%v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("datagram not received")
+	}
+	if m := tr.GetMetrics(); m.Messages != 1 || m.Malformed != 0 || m.Filtered != 1 {
+		t.Errorf("metrics = %+v", m)
+	}
+	if len(sources) != 2 || sources[0].String() != client.LocalAddr().String() {
+		t.Errorf("sources %v", sources)
+	}
+}
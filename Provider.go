@@ -3,9 +3,13 @@ package sip
 import (
 	"bufio"
 	"bytes"
-	"log"
+	"context"
+	"errors"
 	"net"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -16,49 +20,247 @@ type Provider interface {
 	RemoveTransport(Transport)
 
 	AddListener(Listener)
+	AddFilteredListener(Listener, ListenerFilter)
 	RemoveListener(Listener)
+	GetMessageSnapshots() bool
+	SetMessageSnapshots(bool)
 
 	GetNewCallId() string
 
 	GetNewClientTransaction(Request) ClientTransaction
 	GetNewServerTransaction(Request) ServerTransaction
 
+	GetLogger() Logger
+	SetLogger(Logger)
+	AddWireTap(WireTap)
+	RemoveWireTap(WireTap)
+	GetMetrics() Metrics
+	SetMetrics(Metrics)
+
 	SendRequest(Request) error
+	SendRequestContext(ctx context.Context, req Request) error
 	SendResponse(Response) error
+	ForwardRequest(Request, Hop) error
+
+	Do(ctx context.Context, req Request) (Response, error)
+
+	GetClock() Clock
+	SetClock(Clock)
+
+	GetAllowedMethods() []string
+	SetAllowedMethods([]string)
+
+	GetEventPackages() []string
+	SetEventPackages([]string)
+
+	GetSupported() []string
+	SetSupported([]string)
+	GetAccept() []string
+	SetAccept([]string)
+	Capabilities() Capabilities
+
+	GetQuota() Quota
+
+	GetResolver() Resolver
+	SetResolver(Resolver)
+	GetBlacklist() Blacklist
+	SetBlacklist(Blacklist)
+	GetPeerHealth() PeerHealth
+	SetPeerHealth(PeerHealth)
+
+	GetBranchStrategy() BranchStrategy
+	SetBranchStrategy(BranchStrategy)
+
+	GetTryingPolicy(method string) TryingPolicy
+	SetTryingPolicy(method string, policy TryingPolicy)
+
+	GetPrioritizer() Prioritizer
+	SetPrioritizer(Prioritizer)
+	GetFastPath() bool
+	SetFastPath(bool)
+	GetDispatchLanes() int
+	SetDispatchLanes(int) error
+
+	GetContactPolicy() ContactPolicy
+	SetContactPolicy(ContactPolicy)
+	GetNormalizer() Normalizer
+	SetNormalizer(Normalizer)
+	GetSessionTimer() SessionTimer
+	SetSessionTimer(SessionTimer)
+
+	GetTransactionMetrics() TransactionMetrics
+
+	GetRTTEstimator() RTTEstimator
+	SetRTTEstimator(RTTEstimator)
+
+	GetEarlyDialogTimeout() time.Duration
+	SetEarlyDialogTimeout(time.Duration)
+	GetEarlyDialogPolicy() EarlyDialogPolicy
+	SetEarlyDialogPolicy(EarlyDialogPolicy)
+	StopAccepting()
+	Shutdown(ctx context.Context) error
+	IsShuttingDown() bool
+	GetEarlyDialogs(t ClientTransaction) []Dialog
+	GetReplacedDialog(req Request) (Dialog, int)
+	GetJoinedDialog(req Request) (Dialog, int)
+	GetProvisionalInterval() time.Duration
+	SetProvisionalInterval(time.Duration)
+
+	GetRewriter() Rewriter
+	SetRewriter(Rewriter)
+
+	GetSendQueueLimit() int
+	SetSendQueueLimit(int)
+	GetOverflowHandler() OverflowHandler
+	SetOverflowHandler(OverflowHandler)
+	GetSendQueueLength(network, address string) int
+
+	GetUDPSizeLimit() int
+	SetUDPSizeLimit(int)
+
+	GetRport() bool
+	SetRport(bool)
+
+	GetPreParseFilter() PreParseFilter
+	SetPreParseFilter(PreParseFilter)
+
+	GetCRLFKeepalive() time.Duration
+	SetCRLFKeepalive(time.Duration)
+	GetConnectionDeadHandler() ConnectionDeadHandler
+	SetConnectionDeadHandler(ConnectionDeadHandler)
+
+	GetConnectionManager() ConnectionManager
 }
 
 ////////////////////Implementation////////////////////////
 
 type provider struct {
-	listeners    map[Listener]Listener
+	listeners    *listeners
 	transports   map[Transport]Transport
 	transactions map[Transaction]Transaction
 
-	forward chan Message
-	join    chan Transaction
-	leave   chan Transaction
+	dispatcher Dispatcher
+
+	join  chan Transaction
+	leave chan Transaction
 
-	quit      chan bool
-	waitGroup *sync.WaitGroup
+	resolver  Resolver
+	blacklist Blacklist  //nil for none, guarded by mutex
+	health    PeerHealth //nil for none, guarded by mutex
+	branches  BranchStrategy
+	workers   chan bool
+	resolved  chan *resolution
+
+	quit         chan bool
+	waitGroup    *sync.WaitGroup
+	resources    *resources
+	running      bool      //Run was called, guarded by mutex
+	shuttingDown bool      //Shutdown was called, guarded by mutex
+	done         chan bool //closed when Run returns
+	stopped      chan bool //closed when Stop returns
 
 	tracer Tracer
+	logger Logger //nil for the default logger
+	clock  Clock
+
+	allowedMethods []string
+
+	mutex    sync.Mutex
+	quota    Quota
+	admitted map[string]admission
+	clients  map[string]*clientTransaction
+	servers  map[string]*serverTransaction
+	dialogs  map[string]*dialog
+
+	earlyDialogTimeout  time.Duration
+	provisionalInterval time.Duration
+
+	earlyDialogPolicy EarlyDialogPolicy
+	forkOrder         int
+	accepted          map[string]*acceptedFork
+
+	connections     *connectionManager
+	send            func(msg Message, h Hop) error
+	sendQueueLimit  int
+	overflowHandler OverflowHandler
+	udpSizeLimit    int
+	rport           bool
+	preParseFilter  PreParseFilter
+
+	crlfKeepalive         time.Duration
+	connectionDeadHandler ConnectionDeadHandler
+	stunTransactions      map[string]*stunTransaction //guarded by mutex
+
+	tryingPolicies map[string]TryingPolicy
+	trying         map[string]Timer
+
+	contactPolicy ContactPolicy
+	rewriter      Rewriter
+	normalizer    Normalizer
+	sessionTimer  SessionTimer
+	wireTaps      []WireTap
+	eventPackages []string
+	supported     []string
+	accept        []string
+
+	metrics  *transactionMetrics
+	exported Metrics //nil for none, guarded by mutex
+	rtt      RTTEstimator
+}
+
+// admission is the quota held by a server transaction until its final
+// response is sent, or it ends without one.
+type admission struct {
+	source string
+	dialog bool
+	taken  bool //the dialog quota, by the dialog set up, see processDialog
 }
 
-func newProvider(tracer Tracer) *provider {
+func newProvider(tracer Tracer, clock Clock) *provider {
 	this := &provider{}
 
-	this.listeners = make(map[Listener]Listener)
+	this.listeners = newListeners(func() Logger { return this.getLogger(COMPONENT_PROVIDER) })
 	this.transports = make(map[Transport]Transport)
 	this.transactions = make(map[Transaction]Transaction)
 
-	this.forward = make(chan Message)
+	this.dispatcher = NewDispatcher(DISPATCHER_LANES, this.processMessage)
 	this.join = make(chan Transaction)
 	this.leave = make(chan Transaction)
 
+	this.resolver = NewLocator()
+	this.branches = RandomBranch
+	this.workers = make(chan bool, RESOLVE_WORKERS)
+	this.resolved = make(chan *resolution)
+
 	this.quit = make(chan bool)
 	this.waitGroup = &sync.WaitGroup{}
+	this.resources = newResources()
+	this.done = make(chan bool)
+	this.stopped = make(chan bool)
 
 	this.tracer = tracer
+	this.clock = this.resources.track(clock)
+
+	this.quota = NewQuota()
+	this.admitted = make(map[string]admission)
+	this.clients = make(map[string]*clientTransaction)
+	this.servers = make(map[string]*serverTransaction)
+	this.dialogs = make(map[string]*dialog)
+	this.earlyDialogTimeout = DIALOG_EARLY_TIMEOUT
+	this.accepted = make(map[string]*acceptedFork)
+	this.stunTransactions = make(map[string]*stunTransaction)
+
+	this.connections = newConnectionManager(this.GetClock, func() Logger { return this.getLogger(COMPONENT_TRANSPORT) })
+	this.send = this.transmit
+	this.sendQueueLimit = SEND_QUEUE_LIMIT
+	this.udpSizeLimit = MTU_THRESHOLD
+	this.provisionalInterval = PROVISIONAL_INTERVAL
+
+	this.tryingPolicies = make(map[string]TryingPolicy)
+	this.trying = make(map[string]Timer)
+
+	this.metrics = &transactionMetrics{}
+	this.rtt = NewRTTEstimator()
 
 	return this
 }
@@ -71,52 +273,442 @@ func (this *provider) RemoveTransport(t Transport) {
 	delete(this.transports, t)
 }
 
+// AddListener registers l for every event. Listeners are called in the
+// order they were added, one at a time; registering l again does not make
+// it called twice.
 func (this *provider) AddListener(l Listener) {
-	this.listeners[l] = l
+	this.listeners.add(l, ListenerFilter{})
+}
+
+// AddFilteredListener registers l for the events passing filter, or
+// replaces the filter of l if it is already registered.
+func (this *provider) AddFilteredListener(l Listener, filter ListenerFilter) {
+	this.listeners.add(l, filter)
 }
 
 func (this *provider) RemoveListener(l Listener) {
-	delete(this.listeners, l)
+	this.listeners.remove(l)
 }
 
+// GetNewCallId returns a new Call-ID at the address the provider listens
+// on, or at the host name if it listens on a wildcard address only.
 func (this *provider) GetNewCallId() string {
-	return ""
+	host := ""
+	for _, t := range this.transports {
+		if ip := net.ParseIP(t.GetAddress()); t.GetAddress() != "" && (ip == nil || !ip.IsUnspecified()) {
+			host = t.GetAddress()
+			break
+		}
+	}
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	return GenerateCallId(host)
+}
+
+func (this *provider) GetClock() Clock {
+	return this.clock
+}
+
+// SetClock replaces the Clock of the provider, on which its timers are
+// armed from then on.
+func (this *provider) SetClock(clock Clock) {
+	this.clock = this.resources.track(clock)
+}
+
+// GetTransactionMetrics returns the retransmission, timeout and RTT
+// statistics of the client transactions sent so far.
+func (this *provider) GetTransactionMetrics() TransactionMetrics {
+	return this.metrics.get()
+}
+
+func (this *provider) GetRTTEstimator() RTTEstimator {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.rtt
+}
+
+// SetRTTEstimator replaces the estimator of the initial retransmission
+// interval per destination; nil restores the fixed TIMER_T1.
+func (this *provider) SetRTTEstimator(rtt RTTEstimator) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.rtt = rtt
+}
+
+func (this *provider) GetResolver() Resolver {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.resolver
+}
+
+// SetResolver sets how the next hops of the requests sent are turned into
+// addresses, by default with the RFC 3263 lookups of NewLocator.
+func (this *provider) SetResolver(resolver Resolver) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.resolver = resolver
+}
+
+func (this *provider) GetBranchStrategy() BranchStrategy {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.branches
+}
+
+// SetBranchStrategy selects how the branches of the requests this provider
+// forwards are computed: RandomBranch (the default) for transaction stateful
+// forwarding, StatelessBranch for stateless forwarding.
+func (this *provider) SetBranchStrategy(branches BranchStrategy) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.branches = branches
+}
+
+// GetPrioritizer returns the Prioritizer selecting the incoming messages
+// processed ahead of normal traffic, by default the requests with a
+// Resource-Priority in PRIORITY_NAMESPACES.
+func (this *provider) GetPrioritizer() Prioritizer {
+	return this.dispatcher.GetPrioritizer()
+}
+
+func (this *provider) SetPrioritizer(prioritizer Prioritizer) {
+	this.dispatcher.SetPrioritizer(prioritizer)
+}
+
+// GetFastPath reports whether the ACKs and CANCELs received are processed
+// ahead of the other messages, see Dispatcher.
+func (this *provider) GetFastPath() bool {
+	return this.dispatcher.GetFastPath()
+}
+
+func (this *provider) SetFastPath(fastPath bool) {
+	this.dispatcher.SetFastPath(fastPath)
+}
+
+// GetDispatchLanes returns the number of goroutines the messages received
+// are processed on, and the listeners called from, DISPATCHER_LANES by
+// default. The messages of a call are processed on the same one, in order.
+func (this *provider) GetDispatchLanes() int {
+	return this.dispatcher.GetLanes()
+}
+
+// SetDispatchLanes sets the number of goroutines the messages received are
+// processed on, 1 for every listener to be called from a single one. The
+// Prioritizer and the fast path are kept. It fails with ErrProviderRunning
+// once Run was called.
+func (this *provider) SetDispatchLanes(lanes int) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.running {
+		return ErrProviderRunning
+	}
+	dispatcher := NewDispatcher(lanes, this.processMessage)
+	dispatcher.SetPrioritizer(this.dispatcher.GetPrioritizer())
+	dispatcher.SetFastPath(this.dispatcher.GetFastPath())
+	this.dispatcher = dispatcher
+	return nil
+}
+
+func (this *provider) GetAllowedMethods() []string {
+	return this.allowedMethods
+}
+
+// SetAllowedMethods configures the methods this provider supports. Outgoing
+// messages without an Allow header get one listing them, and incoming
+// requests for other methods are answered with 405 Method Not Allowed
+// instead of reaching the listeners. An empty list allows every method.
+func (this *provider) SetAllowedMethods(methods []string) {
+	this.allowedMethods = methods
+}
+
+func (this *provider) isAllowed(method string) bool {
+	if len(this.allowedMethods) == 0 || method == ACK || method == CANCEL {
+		return true
+	}
+	for _, m := range this.allowedMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *provider) stampAllow(msg Message) {
+	if len(this.allowedMethods) == 0 || msg.GetHeader().Get("Allow") != "" {
+		return
+	}
+	if req, ok := msg.(Request); ok && (req.GetMethod() == ACK || req.GetMethod() == CANCEL) {
+		return
+	}
+	if resp, ok := msg.(Response); ok && resp.GetStatusCode() == TRYING {
+		return
+	}
+	msg.GetHeader().Set("Allow", strings.Join(this.allowedMethods, ", "))
+}
+
+// GetQuota returns the limits on concurrent server transactions and
+// dialogs. Requests over a source quota are rejected with 486 Busy Here,
+// and requests over a global one with 503 Service Unavailable; only
+// dialog-forming requests are subject to the dialog limits.
+func (this *provider) GetQuota() Quota {
+	return this.quota
+}
+
+// admit acquires the quota for an incoming request, returning the status
+// code to reject it with if it is exceeded.
+func (this *provider) admit(req Request) int {
+	source := this.quota.GetSource(req)
+	dialog := isDialogForming(req)
+
+	err := this.quota.AcquireTransaction(source)
+	if err == nil && dialog {
+		if err = this.quota.AcquireDialog(source); err != nil {
+			this.quota.ReleaseTransaction(source)
+		}
+	}
+	switch err {
+	case nil:
+	case ErrSourceQuotaExceeded:
+		return BUSY_HERE
+	default:
+		return SERVICE_UNAVAILABLE
+	}
+
+	this.mutex.Lock()
+	this.admitted[getTransactionKey(req)] = admission{source: source, dialog: dialog}
+	this.mutex.Unlock()
+	return 0
+}
+
+// release returns the quota of the transaction answered by a final
+// response. The dialog quota of a 2xx is kept until the dialog ends when
+// a dialog took it over, and returned otherwise, e.g. for a 2xx proxied.
+func (this *provider) release(resp Response) {
+	if resp.GetStatusCode() < OK {
+		return
+	}
+	this.unadmit(getTransactionKey(resp), resp.GetStatusCode() < MULTIPLE_CHOICES)
+}
+
+// unadmit returns the quota the transaction of key still holds, but the
+// dialog quota a dialog took over if keep.
+func (this *provider) unadmit(key string, keep bool) {
+	this.mutex.Lock()
+	a, ok := this.admitted[key]
+	delete(this.admitted, key)
+	this.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+	this.quota.ReleaseTransaction(a.source)
+	if a.dialog && !(keep && a.taken) {
+		this.quota.ReleaseDialog(a.source)
+	}
 }
 
 func (this *provider) GetNewClientTransaction(req Request) ClientTransaction {
 	ct := newClientTransaction(req)
-	this.join <- ct
+	ct.provider = this
+
+	this.mutex.Lock()
+	key := getTransactionKey(req)
+	_, replaced := this.clients[key]
+	this.clients[key] = ct
+	metrics := this.exported
+	this.mutex.Unlock()
+
+	if metrics != nil && !replaced {
+		metrics.AddTransactions(1)
+	}
+
+	select {
+	case this.join <- ct:
+	case <-this.quit:
+	}
 	return ct
 }
+
+// matchClientTransaction returns the client transaction of resp, matched
+// by the branch of its top Via and its CSeq method only (RFC 3261 17.1.3).
+// The address the response came from is deliberately ignored: with rport
+// (RFC 3581) or behind a NAT, it need not be the one the request was sent
+// to. A response without a branch matches nothing.
+func (this *provider) matchClientTransaction(resp Response) *clientTransaction {
+	if getBranch(resp) == "" {
+		return nil
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.clients[getTransactionKey(resp)]
+}
+
+// forgetClientTransaction stops matching responses to ct.
+func (this *provider) forgetClientTransaction(ct *clientTransaction) {
+	key := getTransactionKey(ct.GetRequest())
+
+	this.mutex.Lock()
+	forgotten := this.clients[key] == ct
+	if forgotten {
+		delete(this.clients, key)
+	}
+	metrics := this.exported
+	this.mutex.Unlock()
+
+	if metrics != nil && forgotten {
+		metrics.AddTransactions(-1)
+	}
+}
+
+// renewClientTransaction gives the request of ct a new branch, for ct to be
+// a new transaction, and matches the responses to ct by its new key.
+func (this *provider) renewClientTransaction(ct *clientTransaction) {
+	this.mutex.Lock()
+	if key := getTransactionKey(ct.GetRequest()); this.clients[key] == ct {
+		delete(this.clients, key)
+	}
+	setViaBranch(ct.GetRequest(), GenerateBranchId())
+	this.clients[getTransactionKey(ct.GetRequest())] = ct
+	this.mutex.Unlock()
+
+	ct.resetStats()
+}
+
 func (this *provider) GetNewServerTransaction(req Request) ServerTransaction {
 	st := newServerTransaction(req)
-	this.join <- st
+	st.provider = this
+
+	this.mutex.Lock()
+	key := getServerTransactionKey(req)
+	_, replaced := this.servers[key]
+	this.servers[key] = st
+	metrics := this.exported
+	this.mutex.Unlock()
+
+	if metrics != nil && !replaced {
+		metrics.AddTransactions(1)
+	}
+
+	select {
+	case this.join <- st:
+	case <-this.quit:
+	}
 	return st
 }
 
-func (this *provider) SendRequest(Request) error {
-	return nil
+// matchServerTransaction returns the server transaction of req, a
+// retransmission or the ACK of a non-2xx final response (RFC 3261 17.2.3).
+func (this *provider) matchServerTransaction(req Request) *serverTransaction {
+	this.mutex.Lock()
+	st := this.servers[getServerTransactionKey(req)]
+	this.mutex.Unlock()
+
+	if st == nil || !matchesServerTransaction(st, req) {
+		return nil
+	}
+	return st
 }
-func (this *provider) SendResponse(Response) error {
-	return nil
+
+// forgetServerTransaction stops matching requests to st, and returns the
+// quota it still holds if it ends without a final response.
+func (this *provider) forgetServerTransaction(st *serverTransaction) {
+	key := getServerTransactionKey(st.GetRequest())
+
+	this.mutex.Lock()
+	forgotten := this.servers[key] == st
+	if forgotten {
+		delete(this.servers, key)
+	}
+	metrics := this.exported
+	this.mutex.Unlock()
+
+	if metrics != nil && forgotten {
+		metrics.AddTransactions(-1)
+	}
+	if forgotten {
+		this.unadmit(getTransactionKey(st.GetRequest()), false)
+	}
 }
 
+// Do sends req in a new client transaction and waits for its final
+// response, like net/http's Client.Do. Provisional responses are skipped;
+// a non-2xx final response to an INVITE is acknowledged by the transaction
+// itself. A transaction timeout is reported as a *TimeoutError and a
+// send failure as a *TransportError; if ctx is done first the transaction
+// is ended, an INVITE cancelled, as by SendRequestContext, and ctx.Err() is
+// returned.
+func (this *provider) Do(ctx context.Context, req Request) (Response, error) {
+	ct := this.GetNewClientTransaction(req).(*clientTransaction)
+
+	if err := ct.SendRequestContext(ctx); err != nil {
+		ct.Close()
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, &TransportError{Err: err}
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-ct.final:
+	}
+
+	return ct.getFinal()
+}
+
+// Run listens on the transports of the provider and serves them until Stop
+// is called; it returns at once if it was.
 func (this *provider) Run() {
+	this.mutex.Lock()
+	select {
+	case <-this.quit:
+		this.mutex.Unlock()
+		return
+	default:
+	}
+	this.running = true
+	this.mutex.Unlock()
+	defer close(this.done)
+
 	for _, t := range this.transports {
 		if err := t.Listen(); err != nil {
-			this.tracer.Printf("Listening %s://%s:%d Failed!!!\n", t.GetNetwork(), t.GetAddress(), t.GetPort())
+			this.getLogger(COMPONENT_TRANSPORT).Log(LOG_ERROR, "Listening failed", "transport", getTransportURL(t), "error", err)
 		} else {
-			this.tracer.Printf("Listening %s://%s:%d Runing...\n", t.GetNetwork(), t.GetAddress(), t.GetPort())
-			this.waitGroup.Add(1)
-			go this.ServeAccept(t.(*transport))
+			this.getLogger(COMPONENT_TRANSPORT).Log(LOG_INFO, "Listening", "transport", getTransportURL(t))
+			if t.GetNetwork() == UDP {
+				//a single connection carries every datagram
+				conn, err := t.Accept()
+				if err != nil {
+					this.getLogger(COMPONENT_TRANSPORT).Log(LOG_ERROR, "Accepting failed", "transport", getTransportURL(t), "error", err)
+					continue
+				}
+				t := t
+				this.spawn(resourceServe, func() { this.ServeConn(t, conn) })
+			} else {
+				t := t.(*transport)
+				this.spawn(resourceAccept, func() { this.ServeAccept(t) })
+			}
 		}
 	}
 
+	this.dispatcher.Start()
+
 	//infinite loop run until ctrl+c
 	for {
 		select {
 		case <-this.quit:
-			this.tracer.Println("Provider Stopped!!!")
+			this.getLogger(COMPONENT_PROVIDER).Log(LOG_INFO, "Provider stopped")
 			return
 
 		case s := <-this.join:
@@ -125,33 +717,317 @@ func (this *provider) Run() {
 		case s := <-this.leave:
 			delete(this.transactions, s)
 
-		case msg := <-this.forward:
-			var buffer bytes.Buffer
-			if err := msg.StartLineWrite(&buffer); err != nil {
-				log.Println(err)
-			} else {
-				log.Println("Received: ", buffer.String())
+		case r := <-this.resolved:
+			this.processResolution(r)
+		}
+	}
+}
+
+func (this *provider) processMessage(msg Message) {
+	var buffer bytes.Buffer
+	if err := msg.StartLineWrite(&buffer); err != nil {
+		this.getLogger(COMPONENT_PARSER).Log(LOG_WARN, "Malformed start line", "error", err)
+	} else {
+		this.getLogger(COMPONENT_PROVIDER).Log(LOG_DEBUG, "Received", "message", buffer.String())
+	}
+
+	switch m := msg.(type) {
+	case Response:
+		this.processResponse(m)
+	case Request:
+		this.processRequest(m)
+	}
+}
+
+// processRequest stamps the top Via of req with its source, see
+// stampReceived, then hands retransmissions, and the ACK of a non-2xx final
+// response, to their server transaction, and handles the CANCEL of an
+// INVITE, see processCancel, and the PRACKs of a dialog, see PRACK. It
+// rejects the requests the provider does not accept, or those outside of
+// its dialogs once it shuts down, and gives the others to the listeners, in
+// a new server transaction unless they are ACKs, and in their dialog if
+// they belong to one, once rewritten by the Rewriter if any.
+func (this *provider) processRequest(req Request) {
+	stampReceived(req)
+	if st := this.matchServerTransaction(req); st != nil {
+		st.processRequest(req)
+		return
+	}
+	if req.GetMethod() == CANCEL && this.processCancel(req) {
+		return
+	}
+	if req.GetMethod() != ACK && this.IsShuttingDown() && this.matchDialog(req) == nil {
+		if err := this.rejectShuttingDown(req); err != nil {
+			this.getLogger(COMPONENT_PROVIDER).Log(LOG_ERROR, "Sending response failed", "error", err)
+		}
+		return
+	}
+
+	if !this.isAllowed(req.GetMethod()) {
+		if err := this.SendResponse(CreateResponse(req, METHOD_NOT_ALLOWED)); err != nil {
+			this.getLogger(COMPONENT_PROVIDER).Log(LOG_ERROR, "Sending response failed", "error", err)
+		}
+		return
+	}
+	if !this.isEventAllowed(req) {
+		if err := this.SendResponse(CreateResponse(req, BAD_EVENT)); err != nil {
+			this.getLogger(COMPONENT_PROVIDER).Log(LOG_ERROR, "Sending response failed", "error", err)
+		}
+		return
+	}
+	if isDialogForming(req) {
+		if policy := this.GetContactPolicy(); policy.IsEnabled() {
+			if err := policy.Validate(req); err != nil {
+				if err := this.SendResponse(CreateResponse(req, BAD_REQUEST)); err != nil {
+					this.getLogger(COMPONENT_PROVIDER).Log(LOG_ERROR, "Sending response failed", "error", err)
+				}
+				return
+			}
+		}
+	}
+	if req.GetMethod() != ACK && req.GetMethod() != CANCEL {
+		if statusCode := this.admit(req); statusCode != 0 {
+			if err := this.SendResponse(CreateResponse(req, statusCode)); err != nil {
+				this.getLogger(COMPONENT_PROVIDER).Log(LOG_ERROR, "Sending response failed", "error", err)
+			}
+			return
+		}
+	}
+	if resp := this.checkSessionExpires(req); resp != nil {
+		if err := this.SendResponse(resp); err != nil {
+			this.getLogger(COMPONENT_PROVIDER).Log(LOG_ERROR, "Sending response failed", "error", err)
+		}
+		return
+	}
+
+	d := this.matchDialog(req)
+	if d != nil && !d.processRequest(req) {
+		//out of order (RFC 3261 12.2.2)
+		if err := this.SendResponse(CreateResponse(req, SERVER_INTERNAL_ERROR)); err != nil {
+			this.getLogger(COMPONENT_PROVIDER).Log(LOG_ERROR, "Sending response failed", "error", err)
+		}
+		return
+	}
+	if req.GetMethod() == PRACK && d != nil && !d.acknowledge(req) {
+		//no reliable provisional response awaits it (RFC 3262 3)
+		if err := this.SendResponse(CreateResponse(req, CALL_OR_TRANSACTION_DOES_NOT_EXIST)); err != nil {
+			this.getLogger(COMPONENT_PROVIDER).Log(LOG_ERROR, "Sending response failed", "error", err)
+		}
+		return
+	}
+
+	var st ServerTransaction
+	if req.GetMethod() == ACK && d != nil {
+		if err := d.processOffer(req, false); err != nil {
+			this.getLogger(COMPONENT_DIALOG).Log(LOG_WARN, "Offer rejected", "method", ACK, "error", err)
+		}
+	}
+	if req.GetMethod() != ACK {
+		st = this.GetNewServerTransaction(req)
+		if d != nil {
+			st.(*serverTransaction).SetDialog(d)
+			if req.GetMethod() == INVITE {
+				if statusCode := d.admitInvite(st); statusCode != 0 {
+					//glare (RFC 3261 14.2)
+					if err := rejectRequest(st, statusCode); err != nil {
+						this.getLogger(COMPONENT_PROVIDER).Log(LOG_ERROR, "Sending response failed", "error", err)
+					}
+					return
+				}
 			}
+			if err := d.processOffer(req, false); err != nil {
+				if err := rejectRequest(st, getOfferStatusCode(err)); err != nil {
+					this.getLogger(COMPONENT_PROVIDER).Log(LOG_ERROR, "Sending response failed", "error", err)
+				}
+				return
+			}
+			if req.GetMethod() == PRACK && d.GetOfferState() != OFFERSTATE_REMOTE {
+				if err := st.SendResponse(CreateResponse(req, OK)); err != nil {
+					this.getLogger(COMPONENT_PROVIDER).Log(LOG_ERROR, "Sending response failed", "error", err)
+				}
+			}
+		}
+		if req.GetMethod() != CANCEL {
+			this.scheduleTrying(req)
 		}
 	}
+	//after the transaction took its key from the request as received
+	if rewriter := this.GetRewriter(); rewriter != nil {
+		rewriter.Rewrite(req)
+	}
+	event := NewRequestEvent(st, req)
+	if d != nil {
+		event.dialog = d
+	}
+	this.listeners.fireRequest(event)
+}
+
+// processResponse gives a response to its client transaction and its
+// dialog, which acknowledges a reliable provisional response, then to the
+// listeners unless the transaction absorbed it as a retransmission. A
+// response matching no transaction, such as a retransmitted 2xx to an
+// INVITE, is given to the listeners without one; the dialog of a
+// retransmitted 2xx sends its ACK again.
+func (this *provider) processResponse(resp Response) {
+	ct := this.matchClientTransaction(resp)
+	if ct == nil {
+		event := NewResponseEvent(nil, resp)
+		if d := this.matchDialog(resp); d != nil {
+			event.dialog = d
+			if resp.GetStatusCode()/100 == 2 && getCSeqMethod(resp) == INVITE {
+				d.resendAck()
+			}
+		} else if this.rejectFork(resp) {
+			return
+		}
+		this.listeners.fireResponse(event)
+		return
+	}
+
+	if !ct.processResponse(resp) {
+		return
+	}
+	if isDialogForming(ct.GetRequest()) {
+		//a proxy forms no dialog
+		if !ct.isProxied() {
+			this.processDialog(ct, resp, false)
+		}
+	} else if d, ok := ct.GetDialog().(*dialog); ok {
+		d.processResponse(ct.GetRequest(), resp)
+	}
+	if d, ok := ct.GetDialog().(*dialog); ok {
+		if err := d.processOffer(resp, false); err != nil {
+			this.getLogger(COMPONENT_DIALOG).Log(LOG_WARN, "Offer rejected", "status", resp.GetStatusCode(), "error", err)
+		}
+		if method := ct.GetRequest().GetMethod(); resp.GetStatusCode() == REQUEST_PENDING && (method == INVITE || method == UPDATE) && d.retryRequest(ct) {
+			//the listeners get the responses to the retry instead
+			return
+		}
+		if isReliableProvisional(resp) && ct.GetRequest().GetMethod() == INVITE && d.GetOfferState() != OFFERSTATE_REMOTE {
+			if _, err := d.sendPrack(resp, nil); err != nil {
+				this.getLogger(COMPONENT_DIALOG).Log(LOG_ERROR, "Sending PRACK failed", "dialog", d.GetDialogId(), "error", err)
+			}
+		}
+		d.processSession(ct, resp)
+		if d.processRefreshResponse(ct, resp) {
+			return
+		}
+	}
+
+	event := NewResponseEvent(ct, resp)
+	if d := ct.GetDialog(); d != nil {
+		event.dialog = d
+	}
+	this.listeners.fireResponse(event)
 }
 
+// Stop stops the provider and returns once nothing of it runs: the event
+// loop of Run has returned, the messages being processed are, the
+// transactions are closed, the goroutines of the provider have returned
+// and closed their sockets, and the timers left are stopped. Calling it
+// again waits for the first call to return.
 func (this *provider) Stop() {
+	this.mutex.Lock()
+	select {
+	case <-this.quit:
+		this.mutex.Unlock()
+		<-this.stopped
+		return
+	default:
+	}
 	close(this.quit)
+	running := this.running
+	this.mutex.Unlock()
+	defer close(this.stopped)
+
+	//the event loop owns the transactions until it returns
+	if running {
+		<-this.done
+	}
+	this.dispatcher.Stop()
+
+	transactions := make(map[Transaction]bool)
 	for _, s := range this.transactions {
+		transactions[s] = true
+	}
+	this.mutex.Lock()
+	for _, ct := range this.clients {
+		transactions[ct] = true
+	}
+	for _, st := range this.servers {
+		transactions[st] = true
+	}
+	this.mutex.Unlock()
+	for s := range transactions {
 		s.Close()
 	}
+
 	this.waitGroup.Wait()
+	this.resources.stopTimers()
+}
+
+// rejectUnframed answers msg, a request read from conn without a
+// Content-Length, with 400 Bad Request before the connection is closed.
+func (this *provider) rejectUnframed(conn net.Conn, msg Message) {
+	req, ok := msg.(Request)
+	if !ok || req.GetMethod() == ACK {
+		return
+	}
+	resp := CreateResponse(req, BAD_REQUEST)
+	resp.SetReasonPhrase("Missing Content-Length")
+
+	//written past the queue of the connection, which is closed next
+	if c, ok := conn.(*connection); ok {
+		conn = c.Conn
+	}
+	conn.SetWriteDeadline(time.Now().Add(SEND_TIMEOUT))
+	if err := resp.Write(conn); err != nil {
+		this.getLogger(COMPONENT_TRANSPORT).Log(LOG_ERROR, "Sending response failed", "error", err)
+	}
 }
 
+var ErrProviderRunning = errors.New("sip: provider already running")
+
+// errStopped is returned by a streamReader once the provider stops.
+var errStopped = errors.New("sip: provider stopped")
+
+// streamReader reads a stream connection for ServeConn, a second at a time
+// for the provider stopping to be noticed, but without giving up on a
+// message whose segments arrive further apart.
+type streamReader struct {
+	conn net.Conn
+	quit chan bool
+}
+
+func (this *streamReader) Read(b []byte) (int, error) {
+	for {
+		select {
+		case <-this.quit:
+			return 0, errStopped
+		default:
+		}
+		this.conn.SetReadDeadline(time.Now().Add(1e9)) //wait for 1 second
+		n, err := this.conn.Read(b)
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() || n > 0 {
+			return n, err
+		}
+	}
+}
+
+// ServeAccept accepts the connections of t, a listening stream transport,
+// until the provider stops, and serves each on a goroutine of its own.
 func (this *provider) ServeAccept(t *transport) {
-	defer this.waitGroup.Done()
+	this.resources.acquire(resourceSocket)
+	defer this.resources.release(resourceSocket)
 	defer t.lner.Close()
 
 	for {
 		select {
 		case <-this.quit:
-			log.Printf("Listening %s://%s:%d Stoped!!!\n", t.GetNetwork(), t.GetAddress(), t.GetPort())
+			this.getLogger(COMPONENT_TRANSPORT).Log(LOG_INFO, "Listening stopped", "transport", getTransportURL(t))
+			return
+		case <-t.quit:
+			this.getLogger(COMPONENT_TRANSPORT).Log(LOG_INFO, "Accepting stopped", "transport", getTransportURL(t))
 			return
 		default:
 			//can't delete default, otherwise blocking call
@@ -160,38 +1036,121 @@ func (this *provider) ServeAccept(t *transport) {
 		conn, err := t.Accept()
 		if err != nil {
 			if opErr, ok := err.(*net.OpError); !(ok && opErr.Timeout()) {
-				log.Println(err)
+				this.getLogger(COMPONENT_TRANSPORT).Log(LOG_ERROR, "Accepting failed", "transport", getTransportURL(t), "error", err)
 			}
 			continue
 		}
-		this.waitGroup.Add(1)
-		go this.ServeConn(conn)
+		atomic.AddUint64(&t.connections, 1)
+		c := this.addConnection(t.GetNetwork(), conn)
+		this.spawn(resourceServe, func() { this.ServeConn(t, c) })
 	}
 }
 
-func (this *provider) ServeConn(conn net.Conn) {
-	defer this.waitGroup.Done()
+// ServeConn reads the messages of conn until it is closed or the provider
+// stops. A stream connection can be written to meanwhile, see transmit. It
+// is read through a single buffered reader, for the messages pipelined
+// behind one, and for those split across segments, to be read whole.
+func (this *provider) ServeConn(t Transport, conn net.Conn) {
+	this.resources.acquire(resourceSocket)
+	defer this.resources.release(resourceSocket)
+
+	raw := conn
+	if dc, ok := conn.(*datagramConn); ok {
+		dc.filter = func(data []byte, source net.Addr) bool {
+			if isSTUNMessage(data) {
+				this.processSTUN(dc, data, source)
+				return false
+			}
+			return this.filterDatagram(t, data, source)
+		}
+	}
+	if c, ok := conn.(*connection); ok {
+		raw = c.Conn
+		defer this.removeConnection(t.GetNetwork(), c)
+		//its writer and its keep-alive go with it
+		defer c.wait()
+		defer this.stopKeepalive(c)
+	}
 	defer conn.Close()
 
+	fc, framed := raw.(interface{ Discard() })
+	rec := &wireRecorder{reader: conn, enabled: this.hasWireTaps}
+	var b *bufio.Reader
+	if !framed {
+		rec.reader = &streamReader{conn, this.quit}
+		b = bufio.NewReader(rec)
+	}
+
 	for {
 		select {
 		case <-this.quit:
-			log.Println("Disconnecting...", conn.RemoteAddr())
+			this.getLogger(COMPONENT_TRANSPORT).Log(LOG_DEBUG, "Disconnecting", "remote", conn.RemoteAddr())
 			return
 		default:
 			//can't delete default, otherwise blocking call
 		}
 
-		conn.SetDeadline(time.Now().Add(1e9)) //wait for 1 second
-		if msg, err := ReadMessage(bufio.NewReader(conn)); err != nil {
+		//each datagram, or WebSocket message, holds one message
+		framing := framingStream
+		if framed {
+			fc.Discard()
+			framing = framingDatagram
+			conn.SetReadDeadline(time.Now().Add(1e9)) //wait for 1 second
+			rec.reset()
+			b = bufio.NewReader(rec)
+		}
+
+		c, stream := conn.(*connection)
+		if stream && this.readKeepalives(b, c) {
+			this.connections.touch(c)
+			rec.take(b.Buffered())
+			continue
+		}
+		if stream {
+			//the keep-alives before the message are not captured
+			rec.take(b.Buffered())
+		}
+		if msg, err := readMessage(b, framing); err != nil {
 			if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
 				continue
+			} else if err == errStopped {
+				continue
+			} else if err == ErrMissingContentLength {
+				//where the message ends is unknown, and so is where the next
+				//one starts
+				this.getLogger(COMPONENT_PARSER).Log(LOG_WARN, "Malformed message", "remote", conn.RemoteAddr(), "error", err)
+				this.getMetrics().AddParseFailure(t.GetNetwork())
+				this.rejectUnframed(conn, msg)
+				return
+			} else if framed && !errors.Is(err, net.ErrClosed) {
+				//a malformed datagram does not close the socket
+				this.getLogger(COMPONENT_PARSER).Log(LOG_WARN, "Malformed message", "remote", conn.RemoteAddr(), "error", err)
+				this.getMetrics().AddParseFailure(t.GetNetwork())
+				if tr, ok := t.(*transport); ok {
+					atomic.AddUint64(&tr.malformed, 1)
+				}
+				continue
 			} else {
-				log.Println(err)
+				if isParseError(err) {
+					this.getMetrics().AddParseFailure(t.GetNetwork())
+				}
+				this.getLogger(COMPONENT_TRANSPORT).Log(LOG_INFO, "Connection closed", "remote", conn.RemoteAddr(), "error", err)
 				return
 			}
 		} else {
-			this.forward <- msg
+			if stream {
+				this.connections.touch(c)
+			}
+			info := newMessageInfo(t, raw, this.GetClock().Now())
+			msg.SetMessageInfo(info)
+			if data := rec.take(b.Buffered()); data != nil {
+				this.captureWire(WIRE_IN, info.Network, info.LocalAddr, info.RemoteAddr, data)
+			}
+			this.countMessage(WIRE_IN, msg)
+			if tr, ok := t.(*transport); ok {
+				atomic.AddUint64(&tr.messages, 1)
+			}
+			this.dispatcher.Dispatch(msg)
 		}
 	}
 }
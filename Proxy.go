@@ -0,0 +1,450 @@
+package sip
+
+import (
+	"net"
+	"sip/address"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A Proxy is a Listener forwarding the requests it is given, and their
+// responses, as in RFC 3261 16. The Request-URI of a request for an
+// address-of-record of the location service is replaced by its registered
+// contact; one for another address of the domains of the proxy, with no
+// binding, is answered with 480 Temporarily Unavailable. A forwarded
+// request has its Max-Forwards decremented, a Via of the proxy pushed and,
+// when the proxy record-routes, a Record-Route added if it forms a dialog.
+// The Route headers naming the proxy are removed first.
+//
+// A stateful proxy forwards each request in a client transaction of its
+// own, answering the server transaction of the request with the responses
+// of the client transaction, but 100 Trying, and with 408 or 503 when it
+// fails; it may fork the request to several targets, see ForkingPolicy.
+// A stateless proxy sends the request and forgets about it, giving
+// its retransmissions the same branch (StatelessBranch); it should have
+// the provider send no 100 Trying, see TRYINGPOLICY_NEVER. Either way, the
+// responses with a Via of the proxy and no transaction, such as
+// retransmitted 2xx to an INVITE, are sent on with that Via popped, and the
+// ACK of a 2xx passes through statelessly.
+type Proxy interface {
+	DialogListener
+
+	GetLocationService() LocationService
+	IsStateful() bool
+
+	GetRecordRoute() bool
+	SetRecordRoute(recordRoute bool)
+
+	GetDomains() []string
+	SetDomains(domains []string)
+
+	GetForkingPolicy() ForkingPolicy
+	SetForkingPolicy(policy ForkingPolicy)
+	GetForkTimeout() time.Duration
+	SetForkTimeout(d time.Duration)
+}
+
+// PROXY_MAX_FORWARDS is the Max-Forwards of the requests forwarded without
+// one (RFC 3261 16.6 step 3).
+const PROXY_MAX_FORWARDS = 70
+
+////////////////////Implementation////////////////////////
+
+const resourceForward = "forward goroutine"
+
+type proxy struct {
+	provider Provider
+	host     string
+	port     int
+	location LocationService
+	stateful bool
+
+	mutex         sync.Mutex
+	recordRoute   bool
+	domains       []string
+	forkingPolicy ForkingPolicy
+	forkTimeout   time.Duration
+
+	//the requests forwarded statefully, by the branch and sent-by of the
+	//request received, and their branches
+	pending map[string]*proxyTransaction
+	clients map[ClientTransaction]*proxyTransaction
+}
+
+// NewProxy returns a Proxy forwarding the requests given to it by provider,
+// which listens on host and port, and retargeting them to the bindings of
+// location, which may be nil.
+func NewProxy(provider Provider, host string, port int, location LocationService, stateful bool) Proxy {
+	this := &proxy{}
+
+	this.provider = provider
+	this.host = strings.Trim(host, "[]")
+	this.port = port
+	this.location = location
+	this.stateful = stateful
+	this.forkTimeout = PROXY_FORK_TIMEOUT
+	this.pending = make(map[string]*proxyTransaction)
+	this.clients = make(map[ClientTransaction]*proxyTransaction)
+
+	return this
+}
+
+func (this *proxy) GetLocationService() LocationService {
+	return this.location
+}
+
+func (this *proxy) IsStateful() bool {
+	return this.stateful
+}
+
+func (this *proxy) GetRecordRoute() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.recordRoute
+}
+
+// SetRecordRoute sets whether the proxy stays on the path of the dialogs
+// it sees set up, off by default.
+func (this *proxy) SetRecordRoute(recordRoute bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.recordRoute = recordRoute
+}
+
+func (this *proxy) GetDomains() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return append([]string(nil), this.domains...)
+}
+
+// SetDomains sets the domains the proxy is responsible for, besides its
+// own host.
+func (this *proxy) SetDomains(domains []string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.domains = append([]string(nil), domains...)
+}
+
+func (this *proxy) ProcessRequest(requestEvent RequestEvent) {
+	req := requestEvent.GetRequest()
+	st := requestEvent.GetServerTransaction()
+	w := requestEvent.GetResponseWriter()
+	respond := func(statusCode int) {
+		if w != nil {
+			if err := w.Respond(statusCode, nil); err != nil {
+				this.getLogger().Log(LOG_ERROR, "Sending response failed", "error", err)
+			}
+		}
+	}
+
+	if this.stateful && req.GetMethod() == CANCEL {
+		this.cancel(req, respond)
+		return
+	}
+
+	//Max-Forwards (RFC 3261 16.3 step 3)
+	maxForwards := PROXY_MAX_FORWARDS
+	if v := req.GetHeader().Get("Max-Forwards"); v != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 0 {
+			respond(BAD_REQUEST)
+			return
+		}
+		if n == 0 {
+			respond(TOO_MANY_HOPS)
+			return
+		}
+		maxForwards = n - 1
+	}
+
+	fwd := copyRequest(req, readBody(req))
+	if err := preprocessRoute(fwd, this.isLocalURI); err != nil {
+		respond(BAD_REQUEST)
+		return
+	}
+	targets, bindings, statusCode := this.retarget(fwd)
+	if statusCode != 0 {
+		respond(statusCode)
+		return
+	}
+
+	h := fwd.GetHeader()
+	h.Set("Max-Forwards", strconv.Itoa(maxForwards))
+	if isDialogForming(req) && this.GetRecordRoute() {
+		scheme := "sip:"
+		if isSecureRequest(req) {
+			scheme = "sips:"
+		}
+		h.AddBefore("Record-Route", 0, "<"+scheme+this.getHostPort()+";lr>")
+	}
+
+	if !this.stateful || st == nil {
+		var flow Hop
+		if len(targets) > 0 {
+			fwd.SetRequestURIString(targets[0])
+			if b, ok := bindings[targets[0]]; ok {
+				flow = this.toBinding(fwd, b)
+			}
+		}
+		h.AddBefore("Via", 0, "SIP/2.0/UDP "+this.getHostPort()+";branch="+StatelessBranch.GetBranch(fwd))
+		if st != nil {
+			//retransmissions are forwarded again
+			st.Close()
+		}
+		forward := func() {
+			var err error
+			if p, ok := this.provider.(*provider); ok && flow != nil {
+				err = p.sendRequest(fwd, flow)
+			} else {
+				err = this.provider.SendRequest(fwd)
+			}
+			if err != nil {
+				this.getLogger().Log(LOG_ERROR, "Forwarding request failed", "error", err)
+			}
+		}
+		//sending may set up a connection, off the goroutine delivering
+		//the requests
+		if p, ok := this.provider.(*provider); ok {
+			p.spawn(resourceForward, forward)
+		} else {
+			forward()
+		}
+		return
+	}
+
+	if len(targets) == 0 {
+		targets = []string{fwd.GetRequestURIString()}
+	} else if this.GetForkingPolicy() == FORKINGPOLICY_NONE {
+		targets = targets[:1]
+	}
+	if t, ok := st.(*serverTransaction); ok {
+		t.setProxied()
+	}
+	pt := &proxyTransaction{
+		key:      getProxyKey(req),
+		server:   st,
+		request:  fwd,
+		body:     readBody(fwd),
+		targets:  targets,
+		bindings: bindings,
+		branches: make(map[ClientTransaction]bool),
+	}
+	this.mutex.Lock()
+	this.pending[pt.key] = pt
+	this.mutex.Unlock()
+
+	this.fork(pt)
+}
+
+func (this *proxy) ProcessResponse(responseEvent ResponseEvent) {
+	resp := responseEvent.GetResponse()
+	ct := responseEvent.GetClientTransaction()
+
+	if ct == nil {
+		//stateless, or a retransmitted 2xx
+		if !this.isLocalVia(resp) {
+			return
+		}
+		fwd := copyResponse(resp, readBody(resp))
+		if !popVia(fwd) {
+			return
+		}
+		if err := this.provider.SendResponse(fwd); err != nil {
+			this.getLogger().Log(LOG_ERROR, "Forwarding response failed", "error", err)
+		}
+		return
+	}
+
+	this.mutex.Lock()
+	pt := this.clients[ct]
+	this.mutex.Unlock()
+	if pt == nil || resp.GetStatusCode() == TRYING {
+		return
+	}
+	fwd := copyResponse(resp, readBody(resp))
+	if !popVia(fwd) {
+		return
+	}
+	if resp.GetStatusCode() >= OK {
+		this.processFinal(pt, ct, fwd)
+		return
+	}
+
+	this.mutex.Lock()
+	answered := pt.answered
+	this.mutex.Unlock()
+	if !answered {
+		if err := pt.server.SendResponse(fwd); err != nil {
+			this.getLogger().Log(LOG_ERROR, "Forwarding response failed", "error", err)
+		}
+	}
+}
+
+func (this *proxy) ProcessTimeout(timeoutEvent TimeoutEvent) {
+}
+
+func (this *proxy) ProcessDialogTerminated(dialogTerminatedEvent DialogTerminatedEvent) {
+}
+
+// cancel answers a CANCEL, and cancels the branches of the INVITE it
+// matches, which are not tried further (RFC 3261 16.10).
+func (this *proxy) cancel(req Request, respond func(statusCode int)) {
+	this.mutex.Lock()
+	pt := this.pending[getProxyKey(req)]
+	var branches []ClientTransaction
+	if pt != nil {
+		for ct := range pt.branches {
+			branches = append(branches, ct)
+		}
+		pt.targets = nil
+	}
+	this.mutex.Unlock()
+
+	if pt == nil || pt.server.GetRequest().GetMethod() != INVITE {
+		respond(CALL_OR_TRANSACTION_DOES_NOT_EXIST)
+		return
+	}
+	respond(OK)
+
+	for _, ct := range branches {
+		this.cancelBranch(ct)
+	}
+}
+
+// watch gives the branch ct of pt a 408 Request Timeout, or a 503 Service
+// Unavailable after a transport error, when it fails without a final
+// response (RFC 3261 16.7 step 2 and 16.8). It ends with the provider.
+func (this *proxy) watch(pt *proxyTransaction, ct *clientTransaction) {
+	select {
+	case <-ct.final:
+	case <-ct.quit:
+		return
+	case <-ct.provider.quit:
+		return
+	}
+	resp, err := ct.getFinal()
+	if resp != nil || err == nil {
+		return
+	}
+
+	statusCode := SERVICE_UNAVAILABLE
+	if _, ok := err.(*TimeoutError); ok {
+		statusCode = REQUEST_TIMEOUT
+	}
+	this.processFinal(pt, ct, CreateResponse(pt.server.GetRequest(), statusCode))
+}
+
+// retarget returns the targets of req, the contacts registered for its
+// Request-URI by decreasing q-value (RFC 3261 16.5), their bindings, and
+// the status code rejecting req, or 0. A request with no targets keeps its
+// Request-URI, as one still routed elsewhere does.
+func (this *proxy) retarget(req Request) ([]string, map[string]Binding, int) {
+	if len(req.GetHeader()["Route"]) > 0 {
+		return nil, nil, 0
+	}
+	uri, err := ParseURI(req.GetRequestURIString())
+	if err != nil {
+		return nil, nil, BAD_REQUEST
+	}
+	if this.location != nil {
+		if aor, err := GetAOR(uri); err == nil {
+			bindings, err := this.location.Lookup(aor)
+			if err != nil {
+				return nil, nil, SERVER_INTERNAL_ERROR
+			}
+			if len(bindings) > 0 {
+				bindings = this.getFlows(bindings)
+				if len(bindings) == 0 {
+					return nil, nil, FLOW_FAILED
+				}
+				targets := make(map[string]Binding)
+				for _, b := range bindings {
+					targets[b.URI] = b
+				}
+				return sortTargets(bindings), targets, 0
+			}
+		}
+	}
+	if this.isLocalDomain(uri) {
+		return nil, nil, TEMPORARILY_UNAVAILABLE
+	}
+	return nil, nil, 0
+}
+
+func (this *proxy) getHostPort() string {
+	if this.port > 0 {
+		return net.JoinHostPort(this.host, strconv.Itoa(this.port))
+	}
+	if strings.Contains(this.host, ":") {
+		return "[" + this.host + "]"
+	}
+	return this.host
+}
+
+// isLocalURI reports whether uri is the address of the proxy.
+func (this *proxy) isLocalURI(uri address.URI) bool {
+	sipURI, ok := uri.(*address.SipURIImpl)
+	if !ok || !strings.EqualFold(strings.Trim(sipURI.GetHost(), "[]"), this.host) {
+		return false
+	}
+	port := sipURI.GetPort()
+	if port <= 0 {
+		port = getDefaultPort(sipURI.GetTransportParam(), sipURI.IsSecure())
+	}
+	return this.port <= 0 || port == this.port
+}
+
+// isLocalDomain reports whether the proxy is responsible for the domain of
+// uri.
+func (this *proxy) isLocalDomain(uri address.URI) bool {
+	if this.isLocalURI(uri) {
+		return true
+	}
+	sipURI, ok := uri.(*address.SipURIImpl)
+	if !ok {
+		return false
+	}
+	for _, domain := range this.GetDomains() {
+		if strings.EqualFold(sipURI.GetHost(), domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocalVia reports whether the top Via of resp is one the proxy pushed.
+func (this *proxy) isLocalVia(resp Response) bool {
+	host, port, err := net.SplitHostPort(getSentBy(resp))
+	if err != nil {
+		host, port = getSentBy(resp), ""
+	}
+	if !strings.EqualFold(strings.Trim(host, "[]"), this.host) {
+		return false
+	}
+	return this.port <= 0 || port == strconv.Itoa(this.port)
+}
+
+// getProxyKey identifies the requests of a transaction but for their
+// method, so that a CANCEL finds its INVITE.
+func getProxyKey(req Request) string {
+	return getBranch(req) + " " + getSentBy(req)
+}
+
+// popVia removes the top Via of resp, and reports whether another one is
+// left to send it to.
+func popVia(resp Response) bool {
+	vias := resp.GetHeader().Values("Via")
+	if len(vias) < 2 {
+		return false
+	}
+	resp.GetHeader().ReplaceAll("Via", vias[1:])
+	return true
+}
@@ -0,0 +1,299 @@
+package sip
+
+import (
+	"testing"
+	"time"
+)
+
+func newProxiedRequest(method, uri, branch string) Request {
+	req := NewRequest(method, uri, nil)
+	h := req.GetHeader()
+	h.Set("Via", "SIP/2.0/UDP 192.0.2.2:5060;branch="+branch)
+	h.Set("Max-Forwards", "70")
+	h.Set("From", "Alice <sip:alice@atlanta.com>;tag=1928301774")
+	h.Set("To", "Bob <sip:bob@biloxi.com>")
+	h.Set("Call-Id", "proxy@192.0.2.2")
+	h.Set("Cseq", "1 "+method)
+	h.Set("Contact", "<sip:alice@192.0.2.2>")
+	return req
+}
+
+func TestStatefulProxy(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+
+	location := NewLocationService(clock)
+	location.Store("sip:bob@biloxi.com", []Binding{{AOR: "sip:bob@biloxi.com", Contact: "<sip:bob@192.0.2.4>", URI: "sip:bob@192.0.2.4", Expires: clock.Now().Add(time.Hour)}})
+	proxy := NewProxy(p, "192.0.2.1", 5060, location, true)
+	proxy.SetRecordRoute(true)
+	proxy.SetDomains([]string{"biloxi.com"})
+	p.AddListener(proxy)
+	p.SetTryingPolicy(INVITE, TRYINGPOLICY_NEVER)
+
+	//retargeted to the contact of Bob, with the route of the proxy removed
+	invite := newProxiedRequest(INVITE, "sip:bob@biloxi.com", "z9hG4bKpx1")
+	invite.GetHeader().Set("Route", "<sip:192.0.2.1;lr>")
+	p.processMessage(invite)
+	waitSent(t, sent, 1)
+	fwd, ok := sent.last().(Request)
+	if !ok || fwd.GetRequestURIString() != "sip:bob@192.0.2.4" {
+		t.Fatalf("forwarded %v", sent.last())
+	}
+	h := fwd.GetHeader()
+	if vias := h.Values("Via"); len(vias) != 2 || getSentBy(fwd) != "192.0.2.1:5060" || vias[1] != invite.GetHeader().Get("Via") {
+		t.Errorf("Via = %q", vias)
+	}
+	if h.Get("Max-Forwards") != "69" || h.Get("Record-Route") != "<sip:192.0.2.1:5060;lr>" || h.Get("Route") != "" {
+		t.Errorf("Max-Forwards %q, Record-Route %q, Route %q", h.Get("Max-Forwards"), h.Get("Record-Route"), h.Get("Route"))
+	}
+	if invite.GetRequestURIString() != "sip:bob@biloxi.com" || len(invite.GetHeader()["Via"]) != 1 {
+		t.Error("the request received was changed")
+	}
+
+	//the responses go back with the Via of the proxy popped, but 100
+	for _, statusCode := range []int{TRYING, RINGING, OK} {
+		resp := CreateResponse(fwd, statusCode)
+		resp.GetHeader().Set("To", "Bob <sip:bob@biloxi.com>;tag=a6c85cf")
+		p.processResponse(resp)
+	}
+	waitSent(t, sent, 3)
+	if sent.len() != 3 {
+		t.Fatalf("%d messages sent, want 3", sent.len())
+	}
+	for i, statusCode := range []int{RINGING, OK} {
+		resp, ok := sent.get(i + 1).(Response)
+		if !ok || resp.GetStatusCode() != statusCode || resp.GetHeader().Get("Via") != invite.GetHeader().Get("Via") {
+			t.Errorf("sent %v, want a %d", sent.get(i+1), statusCode)
+		}
+	}
+	p.mutex.Lock()
+	if len(p.dialogs) != 0 {
+		t.Errorf("%d dialogs formed", len(p.dialogs))
+	}
+	p.mutex.Unlock()
+
+	//the retransmitted 2xx passes statelessly
+	retransmission := CreateResponse(fwd, OK)
+	p.processResponse(retransmission)
+	waitSent(t, sent, 4)
+	if resp, ok := sent.last().(Response); !ok || len(resp.GetHeader().Values("Via")) != 1 {
+		t.Errorf("retransmitted 2xx sent as %v", sent.last())
+	}
+
+	//an unknown user of the domain, and a loop
+	unknown := newProxiedRequest(MESSAGE, "sip:carol@biloxi.com", "z9hG4bKpx2")
+	p.processMessage(unknown)
+	if resp, ok := sent.last().(Response); !ok || resp.GetStatusCode() != TEMPORARILY_UNAVAILABLE {
+		t.Errorf("request for an unknown user answered %v", sent.last())
+	}
+	looping := newProxiedRequest(MESSAGE, "sip:bob@biloxi.com", "z9hG4bKpx3")
+	looping.GetHeader().Set("Max-Forwards", "0")
+	p.processMessage(looping)
+	if resp, ok := sent.last().(Response); !ok || resp.GetStatusCode() != TOO_MANY_HOPS {
+		t.Errorf("request with no hops left answered %v", sent.last())
+	}
+}
+
+func TestStatefulProxyTimeout(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	p.AddListener(NewProxy(p, "192.0.2.1", 5060, nil, true))
+
+	p.processMessage(newProxiedRequest(OPTIONS, "sip:bob@192.0.2.4", "z9hG4bKpx4"))
+	waitSent(t, sent, 1)
+	clock.Advance(64 * TIMER_T1)
+	for i := 0; ; i++ {
+		if resp, ok := sent.last().(Response); ok {
+			if resp.GetStatusCode() != REQUEST_TIMEOUT {
+				t.Errorf("answered %d, want 408", resp.GetStatusCode())
+			}
+			break
+		}
+		if i == 100 {
+			t.Fatal("no response after the timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStatelessProxy(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	p.AddListener(NewProxy(p, "192.0.2.1", 5060, nil, false))
+	p.SetTryingPolicy(MESSAGE, TRYINGPOLICY_NEVER)
+
+	//a retransmission is forwarded again, with the same branch
+	req := newProxiedRequest(MESSAGE, "sip:bob@192.0.2.4", "z9hG4bKpx5")
+	p.processMessage(req)
+	waitSent(t, sent, 1)
+	p.processMessage(newProxiedRequest(MESSAGE, "sip:bob@192.0.2.4", "z9hG4bKpx5"))
+	waitSent(t, sent, 2)
+	first, second := sent.get(0).(Request), sent.get(1).(Request)
+	if getBranch(first) != getBranch(second) || getSentBy(first) != "192.0.2.1:5060" {
+		t.Errorf("forwarded with Via %q then %q", getTopVia(first), getTopVia(second))
+	}
+
+	p.processResponse(CreateResponse(first, OK))
+	waitSent(t, sent, 3)
+	if resp, ok := sent.last().(Response); !ok || resp.GetHeader().Get("Via") != req.GetHeader().Get("Via") {
+		t.Errorf("response sent as %v", sent.last())
+	}
+}
+
+// waitForwarded waits until a request with method was sent to uri, as the
+// message from or a later one.
+func waitForwarded(t *testing.T, sent *sentMessages, from int, method, uri string) Request {
+	for i := 0; ; i++ {
+		for j := sent.len() - 1; j >= from; j-- {
+			if req, ok := sent.get(j).(Request); ok && req.GetMethod() == method && req.GetRequestURIString() == uri {
+				return req
+			}
+		}
+		if i == 100 {
+			t.Fatalf("no %s sent to %s", method, uri)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// sentResponses returns the status codes of the responses sent to the
+// request of branch.
+func sentResponses(sent *sentMessages, branch string) []int {
+	var statusCodes []int
+	for i := 0; i < sent.len(); i++ {
+		if resp, ok := sent.get(i).(Response); ok && getBranch(resp) == branch {
+			statusCodes = append(statusCodes, resp.GetStatusCode())
+		}
+	}
+	return statusCodes
+}
+
+func newForkingProxy(policy ForkingPolicy) (*provider, *sentMessages, *FakeClock, Proxy) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+
+	location := NewLocationService(clock)
+	location.Store("sip:bob@biloxi.com", []Binding{
+		{AOR: "sip:bob@biloxi.com", URI: "sip:bob@192.0.2.5", Q: 0.5, Expires: clock.Now().Add(time.Hour)},
+		{AOR: "sip:bob@biloxi.com", URI: "sip:bob@192.0.2.4", Q: -1, Expires: clock.Now().Add(time.Hour)},
+	})
+	proxy := NewProxy(p, "192.0.2.1", 5060, location, true)
+	proxy.SetForkingPolicy(policy)
+	proxy.SetForkTimeout(5 * time.Second)
+	p.AddListener(proxy)
+	p.SetTryingPolicy(INVITE, TRYINGPOLICY_NEVER)
+	return p, sent, clock, proxy
+}
+
+func TestParallelForking(t *testing.T) {
+	p, sent, _, _ := newForkingProxy(FORKINGPOLICY_PARALLEL)
+	defer p.Stop()
+	n := 0
+
+	//the best of the final responses, with the challenges of all of them
+	p.processMessage(newProxiedRequest(INVITE, "sip:bob@biloxi.com", "z9hG4bKfk1"))
+	first := waitForwarded(t, sent, n, INVITE, "sip:bob@192.0.2.4")
+	second := waitForwarded(t, sent, n, INVITE, "sip:bob@192.0.2.5")
+	if getBranch(first) == getBranch(second) {
+		t.Errorf("branches share %q", getBranch(first))
+	}
+	busy := CreateResponse(first, BUSY_HERE)
+	p.processResponse(busy)
+	unauthorized := CreateResponse(second, UNAUTHORIZED)
+	unauthorized.GetHeader().Set("Www-Authenticate", `Digest realm="biloxi.com", nonce="1"`)
+	p.processResponse(unauthorized)
+	if codes := sentResponses(sent, "z9hG4bKfk1"); len(codes) != 1 || codes[0] != UNAUTHORIZED {
+		t.Fatalf("responses sent %v, want [401]", codes)
+	}
+
+	//a 2xx goes upstream at once, and the responses after it do not
+	n = sent.len()
+	p.processMessage(newProxiedRequest(INVITE, "sip:bob@biloxi.com", "z9hG4bKfk2"))
+	first = waitForwarded(t, sent, n, INVITE, "sip:bob@192.0.2.4")
+	second = waitForwarded(t, sent, n, INVITE, "sip:bob@192.0.2.5")
+	for _, resp := range []Response{CreateResponse(first, RINGING), CreateResponse(second, OK), CreateResponse(first, REQUEST_TERMINATED)} {
+		resp.GetHeader().Set("To", "Bob <sip:bob@biloxi.com>;tag="+getBranch(resp))
+		p.processResponse(resp)
+	}
+	if codes := sentResponses(sent, "z9hG4bKfk2"); len(codes) != 2 || codes[0] != RINGING || codes[1] != OK {
+		t.Errorf("responses sent %v, want [180 200]", codes)
+	}
+	if cancel := waitForwarded(t, sent, n, CANCEL, "sip:bob@192.0.2.4"); getBranch(cancel) != getBranch(first) {
+		t.Errorf("CANCEL with the branch %q, want %q", getBranch(cancel), getBranch(first))
+	}
+
+	//a CANCEL from upstream cancels every branch
+	n = sent.len()
+	p.processMessage(newProxiedRequest(INVITE, "sip:bob@biloxi.com", "z9hG4bKfk5"))
+	first = waitForwarded(t, sent, n, INVITE, "sip:bob@192.0.2.4")
+	second = waitForwarded(t, sent, n, INVITE, "sip:bob@192.0.2.5")
+	p.processMessage(newProxiedRequest(CANCEL, "sip:bob@biloxi.com", "z9hG4bKfk5"))
+	waitForwarded(t, sent, n, CANCEL, "sip:bob@192.0.2.4")
+	waitForwarded(t, sent, n, CANCEL, "sip:bob@192.0.2.5")
+	p.processResponse(CreateResponse(first, REQUEST_TERMINATED))
+	p.processResponse(CreateResponse(second, REQUEST_TERMINATED))
+	if codes := sentResponses(sent, "z9hG4bKfk5"); len(codes) != 2 || codes[0] != OK || codes[1] != REQUEST_TERMINATED {
+		t.Errorf("responses sent %v, want [200 487]", codes)
+	}
+}
+
+func TestSequentialForking(t *testing.T) {
+	p, sent, clock, _ := newForkingProxy(FORKINGPOLICY_SEQUENTIAL)
+	defer p.Stop()
+	n := 0
+
+	//the next target is tried after a failure, a 6xx ends the search
+	p.processMessage(newProxiedRequest(INVITE, "sip:bob@biloxi.com", "z9hG4bKfk3"))
+	first := waitForwarded(t, sent, n, INVITE, "sip:bob@192.0.2.4")
+	if sent.len() != 1 {
+		t.Fatalf("%d messages sent, want 1", sent.len())
+	}
+	p.processResponse(CreateResponse(first, TEMPORARILY_UNAVAILABLE))
+	second := waitForwarded(t, sent, n, INVITE, "sip:bob@192.0.2.5")
+	p.processResponse(CreateResponse(second, DECLINE))
+	if codes := sentResponses(sent, "z9hG4bKfk3"); len(codes) != 1 || codes[0] != DECLINE {
+		t.Fatalf("responses sent %v, want [603]", codes)
+	}
+
+	//and after the fork timeout
+	n = sent.len()
+	p.processMessage(newProxiedRequest(INVITE, "sip:bob@biloxi.com", "z9hG4bKfk4"))
+	first = waitForwarded(t, sent, n, INVITE, "sip:bob@192.0.2.4")
+	p.processResponse(CreateResponse(first, RINGING))
+	clock.Advance(5 * time.Second)
+	second = waitForwarded(t, sent, n, INVITE, "sip:bob@192.0.2.5")
+	ok := CreateResponse(second, OK)
+	ok.GetHeader().Set("To", "Bob <sip:bob@biloxi.com>;tag=a6c85cf")
+	p.processResponse(ok)
+	if codes := sentResponses(sent, "z9hG4bKfk4"); len(codes) != 2 || codes[0] != RINGING || codes[1] != OK {
+		t.Errorf("responses sent %v, want [180 200]", codes)
+	}
+}
+
+func TestForkingStop(t *testing.T) {
+	p, sent, _, _ := newForkingProxy(FORKINGPOLICY_PARALLEL)
+
+	//the branches are watched until the provider stops
+	p.processMessage(newProxiedRequest(INVITE, "sip:bob@biloxi.com", "z9hG4bKfk9"))
+	waitForwarded(t, sent, 0, INVITE, "sip:bob@192.0.2.4")
+	waitForwarded(t, sent, 0, INVITE, "sip:bob@192.0.2.5")
+	if held := p.getResources(); held[resourceWatch] != 2 {
+		t.Errorf("%d branches watched, want 2", held[resourceWatch])
+	}
+
+	p.Stop()
+	if held := p.getResources(); len(held) != 0 {
+		t.Errorf("%v held once stopped", held)
+	}
+}
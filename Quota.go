@@ -0,0 +1,209 @@
+package sip
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A Quota caps the number of concurrent server transactions and dialogs,
+// both in total and per source, to protect a server from runaway clients.
+// A zero limit means unlimited.
+type Quota interface {
+	GetLimits() Limits
+	SetLimits(limits Limits)
+
+	GetSource(req Request) string
+
+	AcquireTransaction(source string) error
+	ReleaseTransaction(source string)
+	AcquireDialog(source string) error
+	ReleaseDialog(source string)
+
+	GetTransactionCount(source string) int
+	GetDialogCount(source string) int
+}
+
+type Limits struct {
+	MaxTransactions          int
+	MaxTransactionsPerSource int
+	MaxDialogs               int
+	MaxDialogsPerSource      int
+
+	// Source identifies the sender of a request; SourceAOR by default.
+	Source func(req Request) string
+}
+
+var (
+	ErrQuotaExceeded       = errors.New("sip: global quota exceeded")
+	ErrSourceQuotaExceeded = errors.New("sip: source quota exceeded")
+)
+
+////////////////////Implementation////////////////////////
+
+type quota struct {
+	mutex sync.Mutex
+
+	limits Limits
+
+	transactions       int
+	dialogs            int
+	sourceTransactions map[string]int
+	sourceDialogs      map[string]int
+}
+
+func NewQuota() Quota {
+	this := &quota{}
+
+	this.sourceTransactions = make(map[string]int)
+	this.sourceDialogs = make(map[string]int)
+
+	return this
+}
+
+func (this *quota) GetLimits() Limits {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.limits
+}
+
+func (this *quota) SetLimits(limits Limits) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.limits = limits
+}
+
+func (this *quota) GetSource(req Request) string {
+	this.mutex.Lock()
+	source := this.limits.Source
+	this.mutex.Unlock()
+
+	if source == nil {
+		source = SourceAOR
+	}
+	return source(req)
+}
+
+func (this *quota) AcquireTransaction(source string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return acquire(&this.transactions, this.sourceTransactions, source,
+		this.limits.MaxTransactions, this.limits.MaxTransactionsPerSource)
+}
+
+func (this *quota) ReleaseTransaction(source string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	release(&this.transactions, this.sourceTransactions, source)
+}
+
+func (this *quota) AcquireDialog(source string) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return acquire(&this.dialogs, this.sourceDialogs, source,
+		this.limits.MaxDialogs, this.limits.MaxDialogsPerSource)
+}
+
+func (this *quota) ReleaseDialog(source string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	release(&this.dialogs, this.sourceDialogs, source)
+}
+
+// GetTransactionCount returns the transactions held by source, or by every
+// source if it is empty.
+func (this *quota) GetTransactionCount(source string) int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if source == "" {
+		return this.transactions
+	}
+	return this.sourceTransactions[source]
+}
+
+// GetDialogCount returns the dialogs held by source, or by every source if
+// it is empty.
+func (this *quota) GetDialogCount(source string) int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if source == "" {
+		return this.dialogs
+	}
+	return this.sourceDialogs[source]
+}
+
+func acquire(total *int, counts map[string]int, source string, max, maxPerSource int) error {
+	if max > 0 && *total >= max {
+		return ErrQuotaExceeded
+	}
+	if maxPerSource > 0 && counts[source] >= maxPerSource {
+		return ErrSourceQuotaExceeded
+	}
+	*total++
+	counts[source]++
+	return nil
+}
+
+func release(total *int, counts map[string]int, source string) {
+	if counts[source] <= 0 {
+		return
+	}
+	*total--
+	if counts[source]--; counts[source] == 0 {
+		delete(counts, source)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// SourceAOR identifies the sender of a request by the URI of its From.
+func SourceAOR(req Request) string {
+	return getAddressURI(req.GetHeader().Get("From"))
+}
+
+// SourceIP identifies the sender of a request by the address of its top
+// Via: the received parameter if present, otherwise the sent-by host.
+func SourceIP(req Request) string {
+	via := req.GetHeader().Get("Via")
+	if i := strings.Index(via, ","); i >= 0 {
+		via = via[:i]
+	}
+
+	params := strings.Split(via, ";")
+	for _, param := range params[1:] {
+		if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && strings.EqualFold(kv[0], "received") {
+			return kv[1]
+		}
+	}
+
+	fields := strings.Fields(params[0])
+	if len(fields) < 2 {
+		return ""
+	}
+	host := fields[len(fields)-1]
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.Trim(host, "[]")
+}
+
+// isDialogForming reports whether req creates a dialog when accepted: an
+// INVITE, SUBSCRIBE or REFER outside of any dialog.
+func isDialogForming(req Request) bool {
+	switch req.GetMethod() {
+	case INVITE, SUBSCRIBE, REFER:
+		return !strings.Contains(strings.ToLower(req.GetHeader().Get("To")), ";tag=")
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package sip
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	p.GetQuota().SetLimits(Limits{
+		MaxTransactions:     3,
+		MaxDialogsPerSource: 1,
+	})
+
+	newInvite := func(from, branch string) Request {
+		req := NewRequest(INVITE, "sip:bob@example.com", nil)
+		req.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.1;branch="+branch)
+		req.GetHeader().Set("From", "<"+from+">;tag=1")
+		req.GetHeader().Set("To", "<sip:bob@example.com>")
+		req.GetHeader().Set("Cseq", "1 INVITE")
+		return req
+	}
+
+	first := newInvite("sip:alice@example.com", "z9hG4bK1")
+	if code := p.admit(first); code != 0 {
+		t.Fatalf("first INVITE rejected with %d", code)
+	}
+	if code := p.admit(newInvite("sip:alice@example.com", "z9hG4bK2")); code != BUSY_HERE {
+		t.Fatalf("second dialog of the same source: %d, want 486", code)
+	}
+	if code := p.admit(newInvite("sip:carol@example.com", "z9hG4bK3")); code != 0 {
+		t.Fatalf("other source rejected with %d", code)
+	}
+
+	options := NewRequest(OPTIONS, "sip:bob@example.com", nil)
+	options.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.9;branch=z9hG4bK4")
+	options.GetHeader().Set("From", "<sip:dave@example.com>;tag=1")
+	options.GetHeader().Set("Cseq", "1 OPTIONS")
+	if code := p.admit(options); code != 0 {
+		t.Fatalf("OPTIONS rejected with %d", code)
+	}
+	if code := p.admit(newInvite("sip:erin@example.com", "z9hG4bK5")); code != SERVICE_UNAVAILABLE {
+		t.Fatalf("over the global limit: %d, want 503", code)
+	}
+
+	//a failed INVITE releases both its transaction and its dialog
+	p.release(CreateResponse(first, BUSY_HERE))
+	q := p.GetQuota()
+	if q.GetTransactionCount("") != 2 || q.GetDialogCount("sip:alice@example.com") != 0 {
+		t.Fatalf("transactions %d, alice dialogs %d after release", q.GetTransactionCount(""), q.GetDialogCount("sip:alice@example.com"))
+	}
+
+	//an answered INVITE keeps its dialog until it ends
+	second := newInvite("sip:alice@example.com", "z9hG4bK6")
+	if code := p.admit(second); code != 0 {
+		t.Fatalf("INVITE after release rejected with %d", code)
+	}
+	st := newServerTransaction(second)
+	st.provider = p
+	answer := withToTag(CreateResponse(second, OK), "b1")
+	p.processDialog(st, answer, true)
+	p.release(answer)
+	if q.GetTransactionCount("sip:alice@example.com") != 0 || q.GetDialogCount("sip:alice@example.com") != 1 {
+		t.Fatalf("answered INVITE: transactions %d dialogs %d", q.GetTransactionCount("sip:alice@example.com"), q.GetDialogCount("sip:alice@example.com"))
+	}
+
+	//but one whose 2xx sets up no dialog of ours releases it at once
+	third := newInvite("sip:frank@example.com", "z9hG4bK7")
+	if code := p.admit(third); code != 0 {
+		t.Fatalf("INVITE of another source rejected with %d", code)
+	}
+	p.release(withToTag(CreateResponse(third, OK), "b2"))
+	if q.GetTransactionCount("sip:frank@example.com") != 0 || q.GetDialogCount("sip:frank@example.com") != 0 {
+		t.Fatalf("INVITE without dialog: transactions %d dialogs %d", q.GetTransactionCount("sip:frank@example.com"), q.GetDialogCount("sip:frank@example.com"))
+	}
+
+	if ip := SourceIP(options); ip != "192.0.2.9" {
+		t.Errorf("SourceIP = %q", ip)
+	}
+	options.GetHeader().Set("Via", "SIP/2.0/UDP [2001:db8::1]:5060;received=198.51.100.2;branch=z9hG4bK4")
+	if ip := SourceIP(options); ip != "198.51.100.2" {
+		t.Errorf("SourceIP = %q", ip)
+	}
+}
+
+func TestQuotaRelease(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.12", 5060, UDP)}, nil
+	}))
+	location := NewLocationService(clock)
+	location.Store("sip:bob@biloxi.com", []Binding{{AOR: "sip:bob@biloxi.com", URI: "sip:bob@192.0.2.4", Q: -1, Expires: clock.Now().Add(time.Hour)}})
+	proxy := NewProxy(p, "192.0.2.1", 5060, location, true)
+	p.AddListener(proxy)
+	p.SetTryingPolicy(INVITE, TRYINGPOLICY_NEVER)
+	q := p.GetQuota()
+
+	//an INVITE proxied to a 200 keeps no dialog quota
+	p.processMessage(newProxiedRequest(INVITE, "sip:bob@biloxi.com", "z9hG4bKq1"))
+	if q.GetTransactionCount("") != 1 || q.GetDialogCount("") != 1 {
+		t.Fatalf("INVITE admitted: transactions %d dialogs %d", q.GetTransactionCount(""), q.GetDialogCount(""))
+	}
+	fwd := waitForwarded(t, sent, 0, INVITE, "sip:bob@192.0.2.4")
+	p.processResponse(withToTag(CreateResponse(fwd, OK), "b1"))
+	for i := 0; len(sentResponses(sent, "z9hG4bKq1")) == 0; i++ {
+		if i == 100 {
+			t.Fatal("200 not proxied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if q.GetTransactionCount("") != 0 || q.GetDialogCount("") != 0 {
+		t.Errorf("INVITE proxied: transactions %d dialogs %d", q.GetTransactionCount(""), q.GetDialogCount(""))
+	}
+
+	//and a transaction closed unanswered keeps no quota at all
+	l := &serverListener{}
+	p.RemoveListener(proxy)
+	p.AddListener(l)
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKq2")
+	p.processMessage(invite)
+	if len(l.transactions) != 1 || q.GetTransactionCount("") != 1 || q.GetDialogCount("") != 1 {
+		t.Fatalf("INVITE admitted: transactions %d dialogs %d", q.GetTransactionCount(""), q.GetDialogCount(""))
+	}
+	l.transactions[0].Close()
+	if q.GetTransactionCount("") != 0 || q.GetDialogCount("") != 0 {
+		t.Errorf("INVITE closed: transactions %d dialogs %d", q.GetTransactionCount(""), q.GetDialogCount(""))
+	}
+}
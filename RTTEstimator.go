@@ -0,0 +1,129 @@
+package sip
+
+import (
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+type RTTEstimate struct {
+	SRTT    time.Duration
+	RTTVAR  time.Duration
+	Samples uint64
+}
+
+// An RTTEstimator keeps a smoothed RTT and its variance per destination,
+// the way TCP does (RFC 6298), from the RTT samples of the client
+// transactions sent there. GetT1 turns them into the initial
+// retransmission interval of the next transaction to that destination, in
+// place of the fixed TIMER_T1: SRTT + 4*RTTVAR, within the bounds. Timers
+// B and F keep using TIMER_T1, so a fast peer does not shorten the time a
+// transaction waits for an answer.
+type RTTEstimator interface {
+	Sample(peer string, rtt time.Duration)
+	GetT1(peer string) time.Duration
+	GetEstimate(peer string) RTTEstimate
+	Reset(peer string)
+
+	SetBounds(min, max time.Duration)
+}
+
+////////////////////Implementation////////////////////////
+
+const (
+	RTTESTIMATOR_MIN_T1 = 100 * time.Millisecond
+	RTTESTIMATOR_MAX_T1 = TIMER_T2
+)
+
+type rttEstimator struct {
+	mutex sync.Mutex
+
+	peers map[string]*RTTEstimate
+
+	min time.Duration
+	max time.Duration
+}
+
+func NewRTTEstimator() RTTEstimator {
+	this := &rttEstimator{}
+
+	this.peers = make(map[string]*RTTEstimate)
+
+	this.min = RTTESTIMATOR_MIN_T1
+	this.max = RTTESTIMATOR_MAX_T1
+
+	return this
+}
+
+func (this *rttEstimator) SetBounds(min, max time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.min = min
+	this.max = max
+}
+
+// Sample folds rtt into the estimate of peer: the first sample sets SRTT to
+// it and RTTVAR to half of it, the next ones are smoothed with gains of
+// 1/8 and 1/4.
+func (this *rttEstimator) Sample(peer string, rtt time.Duration) {
+	if rtt <= 0 {
+		return
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	e, ok := this.peers[peer]
+	if !ok {
+		this.peers[peer] = &RTTEstimate{SRTT: rtt, RTTVAR: rtt / 2, Samples: 1}
+		return
+	}
+
+	delta := e.SRTT - rtt
+	if delta < 0 {
+		delta = -delta
+	}
+	e.RTTVAR = (3*e.RTTVAR + delta) / 4
+	e.SRTT = (7*e.SRTT + rtt) / 8
+	e.Samples++
+}
+
+// GetT1 returns the initial retransmission interval towards peer, or
+// TIMER_T1 for a peer without samples.
+func (this *rttEstimator) GetT1(peer string) time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	e, ok := this.peers[peer]
+	if !ok {
+		return TIMER_T1
+	}
+
+	t1 := e.SRTT + 4*e.RTTVAR
+	if t1 < this.min {
+		t1 = this.min
+	}
+	if t1 > this.max {
+		t1 = this.max
+	}
+	return t1
+}
+
+func (this *rttEstimator) GetEstimate(peer string) RTTEstimate {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if e, ok := this.peers[peer]; ok {
+		return *e
+	}
+	return RTTEstimate{}
+}
+
+func (this *rttEstimator) Reset(peer string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	delete(this.peers, peer)
+}
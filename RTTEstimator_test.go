@@ -0,0 +1,75 @@
+package sip
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTTEstimator(t *testing.T) {
+	rtt := NewRTTEstimator()
+	if t1 := rtt.GetT1("192.0.2.7:5060/udp"); t1 != TIMER_T1 {
+		t.Fatalf("T1 without samples = %v", t1)
+	}
+
+	rtt.Sample("192.0.2.7:5060/udp", 80*time.Millisecond)
+	if e := rtt.GetEstimate("192.0.2.7:5060/udp"); e.SRTT != 80*time.Millisecond || e.RTTVAR != 40*time.Millisecond {
+		t.Fatalf("first sample: %+v", e)
+	}
+	rtt.Sample("192.0.2.7:5060/udp", 160*time.Millisecond)
+	if e := rtt.GetEstimate("192.0.2.7:5060/udp"); e.SRTT != 90*time.Millisecond || e.RTTVAR != 50*time.Millisecond || e.Samples != 2 {
+		t.Fatalf("second sample: %+v", e)
+	}
+	if t1 := rtt.GetT1("192.0.2.7:5060/udp"); t1 != 290*time.Millisecond {
+		t.Errorf("T1 = %v, want SRTT + 4*RTTVAR", t1)
+	}
+
+	//estimates are bounded
+	rtt.Sample("198.51.100.1:5060/udp", time.Millisecond)
+	if t1 := rtt.GetT1("198.51.100.1:5060/udp"); t1 != RTTESTIMATOR_MIN_T1 {
+		t.Errorf("fast peer T1 = %v", t1)
+	}
+	rtt.Sample("203.0.113.9:5060/udp", 3*time.Second)
+	if t1 := rtt.GetT1("203.0.113.9:5060/udp"); t1 != RTTESTIMATOR_MAX_T1 {
+		t.Errorf("slow peer T1 = %v", t1)
+	}
+}
+
+func TestAdaptiveT1(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	captureSends(p)
+	go p.Run()
+	defer p.Stop()
+
+	newOptions := func(branch string) *clientTransaction {
+		req := NewRequest(OPTIONS, "sip:bob@192.0.2.7", nil)
+		req.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.1;branch="+branch)
+		req.GetHeader().Set("Cseq", "1 OPTIONS")
+		ct := p.GetNewClientTransaction(req).(*clientTransaction)
+		ct.hops = []Hop{NewHop("192.0.2.7", 5060, UDP)}
+		return ct
+	}
+
+	first := newOptions("z9hG4bKfirst")
+	first.start()
+	if first.interval != TIMER_T1 {
+		t.Fatalf("first interval = %v", first.interval)
+	}
+	clock.Advance(60 * time.Millisecond)
+	first.processResponse(CreateResponse(first.GetRequest(), OK))
+
+	//the next transaction to the same peer starts from its measured RTT
+	second := newOptions("z9hG4bKsecond")
+	second.start()
+	if second.interval != 180*time.Millisecond {
+		t.Errorf("second interval = %v, want 180ms", second.interval)
+	}
+
+	//an explicit retransmit timer wins over the estimate
+	third := newOptions("z9hG4bKthird")
+	third.SetRetransmitTimer(1000)
+	third.start()
+	if third.interval != time.Second {
+		t.Errorf("third interval = %v", third.interval)
+	}
+}
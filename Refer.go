@@ -0,0 +1,313 @@
+package sip
+
+import (
+	"errors"
+	"net/url"
+	"sip/address"
+	"sip/header"
+	"sip/parser"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A REFER (RFC 3515) asks its recipient to send a request to the URI of its
+// Refer-To, usually an INVITE transferring a call. Transfer sends one within
+// a dialog, naming the transfer target, and for an attended transfer the
+// dialog with it the target is to replace, in a Replaces header of the URI
+// (RFC 3891). GetReferTo returns the URI to contact and the header fields
+// the request sent to it is to carry.
+//
+// Accepting a REFER creates an implicit subscription to the refer event
+// package, within the dialog of the REFER or the dialog its 202 sets up:
+// the recipient reports the progress of the request it sends with NOTIFYs
+// whose message/sipfrag body is the status line of its last response, the
+// first one 100 Trying, until a final response terminates the subscription
+// or it expires. A subscription of the second REFER of a dialog and later
+// ones is identified by the CSeq of its REFER, in the id of its Event.
+// GetReferStatus reads such a NOTIFY on the side which sent the REFER.
+
+// EVENT_REFER is the event package of the implicit subscriptions of REFER.
+const EVENT_REFER = "refer"
+
+// REFER_EXPIRES is how long the implicit subscription of a REFER without an
+// Expires lasts, unless a final status ends it first.
+const REFER_EXPIRES = 60 * time.Second
+
+var (
+	ErrBadReferTo             = errors.New("sip: missing or invalid Refer-To")
+	ErrBadSipfrag             = errors.New("sip: invalid message/sipfrag status line")
+	ErrSubscriptionTerminated = errors.New("sip: subscription terminated")
+)
+
+////////////////////Interface//////////////////////////////
+
+// A ReferSubscription is the implicit subscription created by accepting a
+// REFER, see AcceptRefer.
+type ReferSubscription interface {
+	GetDialog() Dialog
+	GetRequest() Request
+	GetReferTo() string
+	Notify(statusCode int, reasonPhrase string) error
+	IsTerminated() bool
+}
+
+////////////////////Implementation////////////////////////
+
+type referSubscription struct {
+	mutex sync.Mutex
+
+	dialog  *dialog
+	request Request
+	referTo string
+
+	//the Event of the NOTIFYs, with the id of the subscription if any
+	event string
+
+	//the status line of the last NOTIFY, and when the subscription
+	//expires
+	status     string
+	expires    time.Time
+	timer      Timer
+	terminated bool
+}
+
+// newReferTo returns the Refer-To value naming target, a URI; the Replaces
+// of an attended transfer identifies replaces, the dialog target has with
+// the remote party of replaces, which is the target when empty.
+func newReferTo(target string, replaces Dialog) (string, error) {
+	if target == "" && replaces != nil {
+		target = replaces.GetRemoteTarget()
+	}
+	uri, err := ParseURI(target)
+	if err != nil {
+		return "", ErrBadReferTo
+	}
+	if replaces != nil {
+		sipURI, ok := uri.(*address.SipURIImpl)
+		if !ok {
+			return "", ErrBadReferTo
+		}
+		sipURI.SetHeader("Replaces", url.QueryEscape(NewReplaces(replaces).EncodeBody()))
+	}
+	return "<" + uri.String() + ">", nil
+}
+
+// GetReferTo returns the URI of the Refer-To of req, a REFER, without its
+// header fields, and the header fields the request sent to that URI is to
+// carry, unescaped.
+func GetReferTo(req Request) (string, Header, error) {
+	v := req.GetHeader().Get("Refer-To")
+	if v == "" {
+		return "", nil, ErrBadReferTo
+	}
+	referTo, err := parser.NewReferToParser("Refer-To: " + v + "\n").Parse()
+	if err != nil {
+		return "", nil, ErrBadReferTo
+	}
+	addr := referTo.(*header.ReferTo).GetAddress()
+	if addr == nil || addr.GetURI() == nil {
+		return "", nil, ErrBadReferTo
+	}
+
+	headers := make(Header)
+	sipURI, ok := addr.GetURI().(*address.SipURIImpl)
+	if !ok {
+		return addr.GetURI().String(), headers, nil
+	}
+	for e := sipURI.GetHeaderNames().GetNames().Front(); e != nil; e = e.Next() {
+		name := e.Value.(string)
+		value, err := url.QueryUnescape(sipURI.GetHeader(name))
+		if err != nil {
+			return "", nil, ErrBadReferTo
+		}
+		headers.Add(name, value)
+	}
+	sipURI.RemoveHeaders()
+	return sipURI.String(), headers, nil
+}
+
+// getSipfrag returns the message/sipfrag body of a NOTIFY reporting status
+// code statusCode, with the standard reason phrase if none is given.
+func getSipfrag(statusCode int, reasonPhrase string) string {
+	if reasonPhrase == "" {
+		reasonPhrase = StatusText(statusCode)
+	}
+	return "SIP/2.0 " + strconv.Itoa(statusCode) + " " + reasonPhrase + "\r\n"
+}
+
+// GetReferStatus returns the status code of the message/sipfrag body of
+// notify, a NOTIFY of the implicit subscription of a REFER, and whether it
+// terminated the subscription.
+func GetReferStatus(notify Request) (int, bool, error) {
+	terminated := strings.EqualFold(mediaToken(notify.GetHeader().Get("Subscription-State")), "terminated")
+	line := string(notify.GetBodyBytes())
+	if i := strings.IndexAny(line, "\r\n"); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "SIP/") {
+		return 0, terminated, ErrBadSipfrag
+	}
+	statusCode, err := strconv.Atoi(fields[1])
+	if err != nil || statusCode < 100 || statusCode > 699 {
+		return 0, terminated, ErrBadSipfrag
+	}
+	return statusCode, terminated, nil
+}
+
+// Transfer asks the remote party to send an INVITE to target with a REFER
+// (RFC 5589): a blind transfer when replaces is nil, and otherwise an
+// attended one, the INVITE replacing replaces, the dialog with the target,
+// whose remote target is the target when target is empty.
+func (this *dialog) Transfer(target string, replaces Dialog) (ClientTransaction, error) {
+	referTo, err := newReferTo(target, replaces)
+	if err != nil {
+		return nil, err
+	}
+	refer, err := this.CreateRequest(REFER)
+	if err != nil {
+		return nil, err
+	}
+	refer.GetHeader().Set("Refer-To", referTo)
+
+	ct := this.provider.GetNewClientTransaction(refer)
+	return ct, this.SendRequest(ct)
+}
+
+// nextReferEvent returns the Event of the implicit subscription of refer:
+// that of the first REFER of the dialog has no id.
+func (this *dialog) nextReferEvent(refer Request) string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.refers++
+	if this.refers == 1 {
+		return EVENT_REFER
+	}
+	cseq, _ := getCSeq(refer)
+	return EVENT_REFER + ";id=" + strconv.Itoa(cseq)
+}
+
+// AcceptRefer answers the REFER of st with 202 Accepted and notifies the
+// implicit subscription it creates with 100 Trying. Outside of a dialog,
+// the 202 gets a To tag and, unless it has one, a Contact naming the
+// Request-URI of the REFER, so that it sets up the dialog of the
+// subscription.
+func AcceptRefer(st ServerTransaction) (ReferSubscription, error) {
+	req := st.GetRequest()
+	if req.GetMethod() != REFER {
+		return nil, ErrDialogMethod
+	}
+	referTo, _, err := GetReferTo(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := CreateResponse(req, ACCEPTED)
+	if getTag(req.GetHeader().Get("To")) == "" {
+		if t, ok := st.(*serverTransaction); ok {
+			withToTag(resp, t.getToTag())
+		} else {
+			withToTag(resp, GenerateTag())
+		}
+		resp.GetHeader().Set("Contact", "<"+req.GetRequestURIString()+">")
+	}
+	if err := st.SendResponse(resp); err != nil {
+		return nil, err
+	}
+	d, ok := st.GetDialog().(*dialog)
+	if !ok {
+		return nil, ErrDialogTerminated
+	}
+
+	expires := REFER_EXPIRES
+	if seconds, err := strconv.Atoi(strings.TrimSpace(req.GetHeader().Get("Expires"))); err == nil && seconds >= 0 {
+		expires = time.Duration(seconds) * time.Second
+	}
+	this := &referSubscription{
+		dialog:  d,
+		request: req,
+		referTo: referTo,
+		event:   d.nextReferEvent(req),
+	}
+	clock := d.provider.GetClock()
+	this.expires = clock.Now().Add(expires)
+	this.timer = clock.AfterFunc(expires, this.onExpire)
+	return this, this.Notify(TRYING, "")
+}
+
+func (this *referSubscription) GetDialog() Dialog {
+	return this.dialog
+}
+
+func (this *referSubscription) GetRequest() Request {
+	return this.request
+}
+
+// GetReferTo returns the URI of the Refer-To of the REFER, without its
+// header fields.
+func (this *referSubscription) GetReferTo() string {
+	return this.referTo
+}
+
+func (this *referSubscription) IsTerminated() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.terminated
+}
+
+// Notify reports the status of the request sent to the Refer-To: a final
+// one, 200 and above, terminates the subscription.
+func (this *referSubscription) Notify(statusCode int, reasonPhrase string) error {
+	this.mutex.Lock()
+	if this.terminated {
+		this.mutex.Unlock()
+		return ErrSubscriptionTerminated
+	}
+	this.status = getSipfrag(statusCode, reasonPhrase)
+	state := "terminated;reason=noresource"
+	if statusCode < OK {
+		remaining := this.expires.Sub(this.dialog.provider.GetClock().Now())
+		state = "active;expires=" + strconv.Itoa(int((remaining+time.Second-1)/time.Second))
+	} else {
+		this.terminated = true
+		stopTimer(this.timer)
+	}
+	status := this.status
+	this.mutex.Unlock()
+
+	return this.sendNotify(state, status)
+}
+
+// onExpire terminates the subscription once it expires, notifying the
+// last status again.
+func (this *referSubscription) onExpire() {
+	this.mutex.Lock()
+	if this.terminated {
+		this.mutex.Unlock()
+		return
+	}
+	this.terminated = true
+	status := this.status
+	this.mutex.Unlock()
+
+	if err := this.sendNotify("terminated;reason=timeout", status); err != nil {
+		this.dialog.provider.getLogger(COMPONENT_DIALOG).Log(LOG_ERROR, "Sending NOTIFY failed", "error", err)
+	}
+}
+
+// sendNotify sends a NOTIFY of the subscription in state, with status as
+// its body.
+func (this *referSubscription) sendNotify(state, status string) error {
+	notify, err := this.dialog.CreateRequest(NOTIFY)
+	if err != nil {
+		return err
+	}
+	notify.GetHeader().Set("Event", this.event)
+	notify.GetHeader().Set("Subscription-State", state)
+	notify.SetBody(NewSipfragBody([]byte(status)))
+	return this.dialog.SendRequest(this.dialog.provider.GetNewClientTransaction(notify))
+}
@@ -0,0 +1,190 @@
+package sip
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTransfer(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+
+	dial := func(branch, tag, contact string) Dialog {
+		invite := newServerTestRequest(INVITE, "UDP", branch)
+		invite.GetHeader().Set("Call-Id", branch+"@example.com")
+		invite.GetHeader().Set("Contact", "<sip:alice@192.0.2.1>")
+		ct := p.GetNewClientTransaction(invite).(*clientTransaction)
+		ct.hops = []Hop{NewHop("192.0.2.7", 5060, UDP)}
+		ct.start()
+		ok200 := CreateResponse(invite, OK)
+		ok200.GetHeader().Set("To", "<sip:bob@example.com>;tag="+tag)
+		ok200.GetHeader().Set("Contact", contact)
+		p.processResponse(ok200)
+		return ct.GetDialog()
+	}
+	d := dial("z9hG4bKrf1", "r1", "<sip:bob@192.0.2.7>")
+	consultation := dial("z9hG4bKrf2", "r2", "<sip:carol@192.0.2.8>")
+
+	//a blind transfer names the target
+	n := sent.len()
+	if _, err := d.Transfer("sip:carol@example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, n+1)
+	refer := findSent(sent, REFER)
+	if h := refer.GetHeader(); h.Get("Refer-To") != "<sip:carol@example.com>" || h.Get("Cseq") != "2 REFER" || getTag(h.Get("To")) != "r1" {
+		t.Fatalf("REFER %v", h)
+	}
+
+	//an attended one the dialog with it to replace, at its remote target
+	if _, err := d.Transfer("", consultation); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, n+2)
+	refer = findSent(sent, REFER)
+	if v := refer.GetHeader().Get("Refer-To"); v != "<sip:carol@192.0.2.8?Replaces=z9hG4bKrf2%40example.com%3Bto-tag%3Dr2%3Bfrom-tag%3D1>" {
+		t.Errorf("Refer-To %q", v)
+	}
+	uri, headers, err := GetReferTo(refer)
+	if err != nil || uri != "sip:carol@192.0.2.8" || headers.Get("Replaces") != "z9hG4bKrf2@example.com;to-tag=r2;from-tag=1" {
+		t.Errorf("Refer-To %s with %v, %v", uri, headers, err)
+	}
+	if _, err := d.Transfer("tel:+15551234", consultation); err != ErrBadReferTo {
+		t.Errorf("attended transfer to a tel URI sent with %v", err)
+	}
+}
+
+// nextNotify waits for a NOTIFY sent after last and answers it.
+func nextNotify(t *testing.T, p *provider, sent *sentMessages, last Request) Request {
+	notify := findSent(sent, NOTIFY)
+	for i := 0; notify == last && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		notify = findSent(sent, NOTIFY)
+	}
+	if notify == last {
+		t.Fatalf("no NOTIFY: %v", sent.last())
+	}
+	p.processResponse(CreateResponse(notify, OK))
+	return notify
+}
+
+func TestAcceptRefer(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKrf3")
+	invite.GetHeader().Set("Contact", "<sip:alice@192.0.2.1>")
+	invite.SetMessageInfo(&MessageInfo{Network: UDP, LocalAddr: &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5060}})
+	p.processMessage(invite)
+	ok200 := CreateResponse(invite, OK)
+	ok200.GetHeader().Set("To", "<sip:bob@example.com>;tag=r3")
+	ok200.GetHeader().Set("Contact", "<sip:bob@192.0.2.2>")
+	if err := l.transactions[0].SendResponse(ok200); err != nil {
+		t.Fatal(err)
+	}
+
+	newRefer := func(branch, cseq string) Request {
+		refer := newServerTestRequest(REFER, "UDP", branch)
+		refer.GetHeader().Set("To", "<sip:bob@example.com>;tag=r3")
+		refer.GetHeader().Set("Cseq", cseq)
+		refer.GetHeader().Set("Refer-To", "<sip:carol@example.com>")
+		p.processMessage(refer)
+		return refer
+	}
+
+	//the REFER is accepted, and its progress notified until a final status
+	newRefer("z9hG4bKrf4", "2 REFER")
+	n := sent.len()
+	sub, err := AcceptRefer(l.transactions[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	notify := nextNotify(t, p, sent, nil)
+	if resp, ok := sent.get(n).(Response); !ok || resp.GetStatusCode() != ACCEPTED {
+		t.Fatalf("REFER answered %v", sent.get(n))
+	}
+	h := notify.GetHeader()
+	if h.Get("Event") != EVENT_REFER || h.Get("Subscription-State") != "active;expires=60" || h.Get("Content-Type") != CONTENTTYPE_SIPFRAG || string(notify.GetBodyBytes()) != "SIP/2.0 100 Trying\r\n" {
+		t.Fatalf("NOTIFY %v with %q", h, notify.GetBodyBytes())
+	}
+	if sub.GetReferTo() != "sip:carol@example.com" || sub.GetDialog() != l.transactions[0].GetDialog() {
+		t.Errorf("subscription to %s", sub.GetReferTo())
+	}
+	clock.Advance(15 * time.Second)
+	if err := sub.Notify(RINGING, ""); err != nil {
+		t.Fatal(err)
+	}
+	notify = nextNotify(t, p, sent, notify)
+	if v := notify.GetHeader().Get("Subscription-State"); v != "active;expires=45" || string(notify.GetBodyBytes()) != "SIP/2.0 180 Ringing\r\n" {
+		t.Errorf("NOTIFY %q with %q", v, notify.GetBodyBytes())
+	}
+	if err := sub.Notify(OK, ""); err != nil {
+		t.Fatal(err)
+	}
+	notify = nextNotify(t, p, sent, notify)
+	if statusCode, terminated, err := GetReferStatus(notify); statusCode != OK || !terminated || err != nil {
+		t.Errorf("NOTIFY of %d, terminated %v, %v", statusCode, terminated, err)
+	}
+	if err := sub.Notify(OK, ""); err != ErrSubscriptionTerminated || !sub.IsTerminated() {
+		t.Errorf("NOTIFY after the final status sent with %v", err)
+	}
+
+	//the subscription of the next REFER has an id, and times out
+	newRefer("z9hG4bKrf5", "3 REFER")
+	if _, err := AcceptRefer(l.transactions[2]); err != nil {
+		t.Fatal(err)
+	}
+	notify = nextNotify(t, p, sent, notify)
+	if v := notify.GetHeader().Get("Event"); v != "refer;id=3" {
+		t.Errorf("Event %q", v)
+	}
+	clock.Advance(REFER_EXPIRES)
+	notify = nextNotify(t, p, sent, notify)
+	if statusCode, terminated, _ := GetReferStatus(notify); statusCode != TRYING || !terminated || notify.GetHeader().Get("Subscription-State") != "terminated;reason=timeout" {
+		t.Errorf("expired subscription notified %v", notify.GetHeader())
+	}
+}
+
+func TestAcceptReferOutsideDialog(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+
+	refer := newServerTestRequest(REFER, "UDP", "z9hG4bKrf6")
+	refer.GetHeader().Set("Contact", "<sip:alice@192.0.2.1>")
+	refer.GetHeader().Set("Refer-To", "sip:carol@example.com")
+	refer.SetMessageInfo(&MessageInfo{Network: UDP, LocalAddr: &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5060}})
+	p.processMessage(refer)
+	if _, err := AcceptRefer(l.transactions[0]); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, 2)
+
+	//the 202 sets up the dialog the NOTIFYs are sent in
+	accepted := sent.get(0).(Response)
+	tag := getTag(accepted.GetHeader().Get("To"))
+	if tag == "" || accepted.GetHeader().Get("Contact") != "<"+refer.GetRequestURIString()+">" {
+		t.Fatalf("202 %v", accepted.GetHeader())
+	}
+	notify := findSent(sent, NOTIFY)
+	if notify.GetRequestURIString() != "sip:alice@192.0.2.1" || getTag(notify.GetHeader().Get("From")) != tag || getTag(notify.GetHeader().Get("To")) != "1" {
+		t.Errorf("NOTIFY to %s with %v", notify.GetRequestURIString(), notify.GetHeader())
+	}
+
+	bad := newServerTestRequest(REFER, "UDP", "z9hG4bKrf7")
+	p.processMessage(bad)
+	if _, err := AcceptRefer(l.transactions[1]); err != ErrBadReferTo {
+		t.Errorf("REFER without Refer-To accepted with %v", err)
+	}
+}
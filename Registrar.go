@@ -0,0 +1,467 @@
+package sip
+
+import (
+	"errors"
+	"sip/address"
+	"sip/header"
+	"sip/parser"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A Binding maps an address-of-record to a contact address registered for
+// it (RFC 3261 10), until Expires.
+type Binding struct {
+	AOR     string
+	Contact string  //the Contact header value, without its expires parameter
+	URI     string  //the contact URI, which identifies the binding of the AOR
+	Q       float32 //the q-value of the contact, or -1
+	CallId  string
+	CSeq    int
+	Expires time.Time
+
+	//registered with outbound (RFC 5626): the instance ID and reg-id of the
+	//contact, which identify the binding instead of its URI, and the flow
+	//the REGISTER came over, for the requests to the contact to reuse
+	InstanceId  string
+	RegId       int
+	FlowNetwork string
+	FlowAddress string
+
+	//the Path of the REGISTER (RFC 3327), the proxies the requests to the
+	//contact are routed through
+	Path []string
+}
+
+// A LocationService keeps the bindings of the address-of-records, for the
+// Registrar to update and for a proxy to look up where to send requests.
+type LocationService interface {
+	// Lookup returns the bindings of aor which have not expired.
+	Lookup(aor string) ([]Binding, error)
+	// Store replaces the bindings of aor, removing it when empty.
+	Store(aor string, bindings []Binding) error
+}
+
+// A Registrar is a Listener answering the REGISTER requests (RFC 3261
+// 10.3), and passing the others to the next listener. It adds, refreshes
+// and removes the bindings in its LocationService, and answers with all
+// the bindings of the address-of-record. A contact with a +sip.instance
+// and a reg-id registered directly by the UA, over a single Via, binds the
+// flow the REGISTER came over (RFC 5626 6), see Outbound, and the Path of
+// the REGISTER is kept with its bindings, see GetPath. An expiration
+// shorter than the minimum, other than 0, is refused with 423 Interval Too
+// Brief and a Min-Expires header; a longer one than the maximum is
+// shortened.
+type Registrar interface {
+	DialogListener
+
+	GetLocationService() LocationService
+
+	GetMinExpires() time.Duration
+	SetMinExpires(d time.Duration)
+
+	GetMaxExpires() time.Duration
+	SetMaxExpires(d time.Duration)
+
+	GetDefaultExpires() time.Duration
+	SetDefaultExpires(d time.Duration)
+}
+
+const (
+	REGISTRAR_MIN_EXPIRES     = 60 * time.Second
+	REGISTRAR_MAX_EXPIRES     = 24 * time.Hour
+	REGISTRAR_DEFAULT_EXPIRES = time.Hour
+)
+
+var ErrInvalidAOR = errors.New("sip: invalid address-of-record")
+
+////////////////////Implementation////////////////////////
+
+type locationService struct {
+	mutex    sync.Mutex
+	clock    Clock
+	bindings map[string][]Binding
+}
+
+// NewLocationService returns a LocationService keeping the bindings in
+// memory. A nil clock is RealClock.
+func NewLocationService(clock Clock) LocationService {
+	this := &locationService{}
+
+	this.clock = clock
+	if this.clock == nil {
+		this.clock = RealClock
+	}
+	this.bindings = make(map[string][]Binding)
+
+	return this
+}
+
+func (this *locationService) Lookup(aor string) ([]Binding, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	now := this.clock.Now()
+	var bindings []Binding
+	for _, b := range this.bindings[aor] {
+		if b.Expires.After(now) {
+			bindings = append(bindings, b)
+		}
+	}
+	if len(bindings) == 0 {
+		delete(this.bindings, aor)
+	} else {
+		this.bindings[aor] = bindings
+	}
+	return append([]Binding(nil), bindings...), nil
+}
+
+func (this *locationService) Store(aor string, bindings []Binding) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if len(bindings) == 0 {
+		delete(this.bindings, aor)
+	} else {
+		this.bindings[aor] = append([]Binding(nil), bindings...)
+	}
+	return nil
+}
+
+type registrar struct {
+	next     Listener
+	location LocationService
+	clock    Clock
+
+	//serializes the updates of the bindings, which are a lookup followed
+	//by a store
+	update sync.Mutex
+
+	mutex          sync.Mutex
+	minExpires     time.Duration
+	maxExpires     time.Duration
+	defaultExpires time.Duration
+}
+
+// NewRegistrar returns a Registrar keeping the bindings in location, and
+// passing the requests other than REGISTER, the responses and the
+// timeouts to next, if not nil. A nil clock is RealClock.
+func NewRegistrar(next Listener, location LocationService, clock Clock) Registrar {
+	this := &registrar{}
+
+	this.next = next
+	this.location = location
+	this.clock = clock
+	if this.clock == nil {
+		this.clock = RealClock
+	}
+	this.minExpires = REGISTRAR_MIN_EXPIRES
+	this.maxExpires = REGISTRAR_MAX_EXPIRES
+	this.defaultExpires = REGISTRAR_DEFAULT_EXPIRES
+
+	return this
+}
+
+func (this *registrar) GetLocationService() LocationService {
+	return this.location
+}
+
+func (this *registrar) GetMinExpires() time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.minExpires
+}
+
+func (this *registrar) SetMinExpires(d time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.minExpires = d
+}
+
+func (this *registrar) GetMaxExpires() time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.maxExpires
+}
+
+func (this *registrar) SetMaxExpires(d time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.maxExpires = d
+}
+
+func (this *registrar) GetDefaultExpires() time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.defaultExpires
+}
+
+// SetDefaultExpires sets the expiration of the contacts registered with
+// neither an expires parameter nor an Expires header.
+func (this *registrar) SetDefaultExpires(d time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.defaultExpires = d
+}
+
+func (this *registrar) ProcessRequest(requestEvent RequestEvent) {
+	req := requestEvent.GetRequest()
+	w := requestEvent.GetResponseWriter()
+	if req.GetMethod() != REGISTER || w == nil {
+		if this.next != nil {
+			this.next.ProcessRequest(requestEvent)
+		}
+		return
+	}
+
+	statusCode := this.register(req, w.Header())
+	w.Respond(statusCode, nil)
+}
+
+func (this *registrar) ProcessResponse(responseEvent ResponseEvent) {
+	if this.next != nil {
+		this.next.ProcessResponse(responseEvent)
+	}
+}
+
+func (this *registrar) ProcessTimeout(timeoutEvent TimeoutEvent) {
+	if this.next != nil {
+		this.next.ProcessTimeout(timeoutEvent)
+	}
+}
+
+func (this *registrar) ProcessDialogTerminated(dialogTerminatedEvent DialogTerminatedEvent) {
+	if next, ok := this.next.(DialogListener); ok {
+		next.ProcessDialogTerminated(dialogTerminatedEvent)
+	}
+}
+
+// register applies the Contact headers of req to the bindings of its
+// address-of-record, and returns the status code of the response, whose
+// headers it sets in h.
+func (this *registrar) register(req Request, h Header) int {
+	to := req.GetTo()
+	if to == nil {
+		return BAD_REQUEST
+	}
+	aor, err := GetAOR(to.GetAddress().GetURI())
+	if err != nil {
+		return NOT_FOUND
+	}
+	callId := req.GetHeader().Get("Call-Id")
+	cseq, err := getCSeq(req)
+	if err != nil {
+		return BAD_REQUEST
+	}
+
+	expires := -1
+	if v := req.GetHeader().Get("Expires"); v != "" {
+		if expires, err = strconv.Atoi(strings.TrimSpace(v)); err != nil || expires < 0 {
+			return BAD_REQUEST
+		}
+	}
+
+	var contacts []*header.Contact
+	wildcard := false
+	for _, v := range req.GetHeader()["Contact"] {
+		sh, err := parser.NewContactParser("Contact: " + v + "\n").Parse()
+		if err != nil {
+			return BAD_REQUEST
+		}
+		for e := sh.(*header.ContactList).Front(); e != nil; e = e.Next() {
+			contact := e.Value.(*header.Contact)
+			//the parser keeps "*" as a wildcard address
+			wildcard = wildcard || contact.GetAddress().IsWildcard()
+			contacts = append(contacts, contact)
+		}
+	}
+	//"*" only with Expires: 0, alone (RFC 3261 10.3 step 6)
+	if wildcard && (len(contacts) != 1 || expires != 0) {
+		return BAD_REQUEST
+	}
+	flowNetwork, flowAddress, statusCode := getRegisterFlow(req, contacts)
+	if statusCode != 0 {
+		return statusCode
+	}
+	path, err := GetPath(req)
+	if err != nil {
+		return BAD_REQUEST
+	}
+
+	//the expiration of each contact, checked before any is applied
+	minExpires := int(this.GetMinExpires() / time.Second)
+	maxExpires := int(this.GetMaxExpires() / time.Second)
+	defaultExpires := int(this.GetDefaultExpires() / time.Second)
+	contactExpires := make([]int, len(contacts))
+	for i, contact := range contacts {
+		e := expires
+		if v := contact.GetParameter(header.ParameterNames_EXPIRES); v != "" {
+			if e, err = strconv.Atoi(v); err != nil || e < 0 {
+				e = defaultExpires
+			}
+		} else if e < 0 {
+			e = defaultExpires
+		}
+		if e != 0 && e < minExpires {
+			h.Set("Min-Expires", strconv.Itoa(minExpires))
+			return INTERVAL_TOO_BRIEF
+		}
+		if e > maxExpires {
+			e = maxExpires
+		}
+		contactExpires[i] = e
+	}
+
+	this.update.Lock()
+	defer this.update.Unlock()
+
+	bindings, err := this.location.Lookup(aor)
+	if err != nil {
+		return SERVER_INTERNAL_ERROR
+	}
+	now := this.clock.Now()
+
+	if wildcard {
+		for _, b := range bindings {
+			if b.CallId == callId && cseq <= b.CSeq {
+				return SERVER_INTERNAL_ERROR
+			}
+		}
+		contacts = nil
+		bindings = nil
+		if err := this.location.Store(aor, nil); err != nil {
+			return SERVER_INTERNAL_ERROR
+		}
+	}
+	outbound := false
+	for i, contact := range contacts {
+		uri := contact.GetAddress().GetURI().String()
+		instance, regId := contact.GetInstance(), 0
+		if flowNetwork != "" && instance != "" {
+			regId = contact.GetRegId()
+		}
+		//an outbound binding is identified by its instance and reg-id
+		matches := func(b Binding) bool {
+			if regId > 0 {
+				return b.InstanceId == instance && b.RegId == regId
+			}
+			return b.RegId == 0 && b.URI == uri
+		}
+		j := 0
+		for ; j < len(bindings) && !matches(bindings[j]); j++ {
+		}
+		if j < len(bindings) {
+			//an older or replayed request for this binding is refused
+			if bindings[j].CallId == callId && cseq <= bindings[j].CSeq {
+				return SERVER_INTERNAL_ERROR
+			}
+			bindings = append(bindings[:j], bindings[j+1:]...)
+		}
+		if contactExpires[i] == 0 {
+			continue
+		}
+		contact.RemoveParameter(header.ParameterNames_EXPIRES)
+		b := Binding{
+			AOR:        aor,
+			Contact:    contact.EncodeBody(),
+			URI:        uri,
+			Q:          contact.GetQValue(),
+			CallId:     callId,
+			CSeq:       cseq,
+			Expires:    now.Add(time.Duration(contactExpires[i]) * time.Second),
+			InstanceId: instance,
+			Path:       path,
+		}
+		if regId > 0 {
+			b.RegId = regId
+			b.FlowNetwork = flowNetwork
+			b.FlowAddress = flowAddress
+			outbound = true
+		}
+		bindings = append(bindings, b)
+	}
+
+	//a REGISTER without Contact is a query
+	if len(contacts) != 0 {
+		if err := this.location.Store(aor, bindings); err != nil {
+			return SERVER_INTERNAL_ERROR
+		}
+	}
+
+	sort.SliceStable(bindings, func(i, j int) bool {
+		return bindings[i].Expires.Before(bindings[j].Expires)
+	})
+	for _, b := range bindings {
+		h.Add("Contact", b.Contact+";expires="+strconv.Itoa(int((b.Expires.Sub(now)+time.Second-1)/time.Second)))
+	}
+	h.Set("Date", now.UTC().Format(TimeFormat))
+	if outbound {
+		h.Set("Require", OPTIONTAG_OUTBOUND)
+	}
+	//the Path stored, only to a UA which supports it (RFC 3327 5.3)
+	if len(path) > 0 && len(contacts) > 0 && IsSupported(req, OPTIONTAG_PATH) {
+		for _, v := range path {
+			h.Add("Path", v)
+		}
+	}
+	return OK
+}
+
+// getRegisterFlow returns the flow a REGISTER using outbound came over, or
+// empty strings if it does not use outbound: none of its contacts has both
+// a +sip.instance and a reg-id, or it did not come directly from the UA,
+// in which case the reg-ids are ignored. It also returns the status code
+// refusing the REGISTER, or 0: only one contact may have a reg-id.
+func getRegisterFlow(req Request, contacts []*header.Contact) (string, string, int) {
+	n := 0
+	for _, contact := range contacts {
+		if contact.GetRegId() > 0 && contact.GetInstance() != "" {
+			n++
+		}
+	}
+	switch {
+	case n == 0:
+		return "", "", 0
+	case n > 1:
+		return "", "", BAD_REQUEST
+	}
+
+	info := req.GetMessageInfo()
+	if len(req.GetHeader().Values("Via")) != 1 || info == nil || info.RemoteAddr == nil || info.Network == "" {
+		return "", "", 0
+	}
+	return info.Network, info.RemoteAddr.String(), 0
+}
+
+// GetAOR returns the address-of-record of uri, for a SIP or SIPS URI its
+// scheme, user, host, lowercased, and port, without the parameters and
+// headers (RFC 3261 10.3 step 5).
+func GetAOR(uri address.URI) (string, error) {
+	sipURI, ok := uri.(*address.SipURIImpl)
+	if !ok || sipURI == nil || sipURI.GetHost() == "" {
+		if uri == nil {
+			return "", ErrInvalidAOR
+		}
+		return uri.String(), nil
+	}
+	aor := strings.ToLower(sipURI.GetScheme()) + ":"
+	if user := sipURI.GetUser(); user != "" {
+		aor += user + "@"
+	}
+	aor += strings.ToLower(sipURI.GetHost())
+	if port := sipURI.GetPort(); port > 0 {
+		aor += ":" + strconv.Itoa(port)
+	}
+	return aor, nil
+}
@@ -0,0 +1,105 @@
+package sip
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// registrarTransaction hands the response sent to the test.
+type registrarTransaction struct {
+	ServerTransaction
+
+	request  Request
+	response Response
+}
+
+func (this *registrarTransaction) GetRequest() Request {
+	return this.request
+}
+
+func (this *registrarTransaction) SendResponse(resp Response) error {
+	this.response = resp
+	return nil
+}
+
+func newRegister(cseq int, expires string, contacts ...string) Request {
+	req := NewRequest(REGISTER, "sip:registrar.biloxi.com", nil)
+	h := req.GetHeader()
+	h.Set("Via", "SIP/2.0/UDP bobspc.biloxi.com:5060;branch=z9hG4bKnashds7")
+	h.Set("From", "Bob <sip:bob@biloxi.com>;tag=456248")
+	h.Set("To", "Bob <sip:bob@Biloxi.com>")
+	h.Set("Call-Id", "843817637684230@998sdasdh09")
+	h.Set("Cseq", strconv.Itoa(cseq)+" REGISTER")
+	if expires != "" {
+		h.Set("Expires", expires)
+	}
+	for _, c := range contacts {
+		h.Add("Contact", c)
+	}
+	return req
+}
+
+func register(r Registrar, req Request) Response {
+	st := &registrarTransaction{request: req}
+	r.ProcessRequest(*NewRequestEvent(st, req))
+	return st.response
+}
+
+func TestRegistrar(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	location := NewLocationService(clock)
+	r := NewRegistrar(nil, location, clock)
+
+	resp := register(r, newRegister(1, "7200", "<sip:bob@192.0.2.4>", "<sip:bob@192.0.2.5>;expires=600"))
+	if resp.GetStatusCode() != OK {
+		t.Fatalf("REGISTER answered %d", resp.GetStatusCode())
+	}
+	if c := resp.GetHeader()["Contact"]; len(c) != 2 || c[0] != "<sip:bob@192.0.2.5>;expires=600" || c[1] != "<sip:bob@192.0.2.4>;expires=7200" {
+		t.Errorf("Contact = %q", c)
+	}
+	if resp.GetHeader().Get("Date") != "Thu, 01 Jan 2026 00:00:00 GMT" {
+		t.Errorf("Date = %q", resp.GetHeader().Get("Date"))
+	}
+	bindings, _ := location.Lookup("sip:bob@biloxi.com")
+	if len(bindings) != 2 {
+		t.Fatalf("bindings = %v", bindings)
+	}
+
+	//a replayed request changes nothing
+	if resp := register(r, newRegister(1, "", "<sip:bob@192.0.2.4>;expires=0")); resp.GetStatusCode() != SERVER_INTERNAL_ERROR {
+		t.Errorf("replayed REGISTER answered %d", resp.GetStatusCode())
+	}
+
+	//the bindings expire
+	clock.Advance(10 * time.Minute)
+	resp = register(r, newRegister(2, ""))
+	if c := resp.GetHeader()["Contact"]; resp.GetStatusCode() != OK || len(c) != 1 || c[0] != "<sip:bob@192.0.2.4>;expires=6600" {
+		t.Errorf("query answered %d %q", resp.GetStatusCode(), c)
+	}
+
+	//too brief, too long, removed
+	resp = register(r, newRegister(3, "30", "<sip:bob@192.0.2.6>"))
+	if resp.GetStatusCode() != INTERVAL_TOO_BRIEF || resp.GetHeader().Get("Min-Expires") != "60" {
+		t.Errorf("too brief REGISTER answered %d, Min-Expires %q", resp.GetStatusCode(), resp.GetHeader().Get("Min-Expires"))
+	}
+	resp = register(r, newRegister(4, "", "<sip:bob@192.0.2.4>;expires=0", "<sip:bob@192.0.2.6>;expires=999999"))
+	if c := resp.GetHeader()["Contact"]; resp.GetStatusCode() != OK || len(c) != 1 || c[0] != "<sip:bob@192.0.2.6>;expires=86400" {
+		t.Errorf("REGISTER answered %d %q", resp.GetStatusCode(), c)
+	}
+
+	//"*" needs Expires: 0 and no other contact
+	if resp := register(r, newRegister(5, "", "*")); resp.GetStatusCode() != BAD_REQUEST {
+		t.Errorf("* without Expires: 0 answered %d", resp.GetStatusCode())
+	}
+	if resp := register(r, newRegister(5, "0", "*", "<sip:bob@192.0.2.4>")); resp.GetStatusCode() != BAD_REQUEST {
+		t.Errorf("* with a contact answered %d", resp.GetStatusCode())
+	}
+	resp = register(r, newRegister(5, "0", "*"))
+	if resp.GetStatusCode() != OK || len(resp.GetHeader()["Contact"]) != 0 {
+		t.Errorf("* answered %d %q", resp.GetStatusCode(), resp.GetHeader()["Contact"])
+	}
+	if bindings, _ := location.Lookup("sip:bob@biloxi.com"); len(bindings) != 0 {
+		t.Errorf("bindings = %v after *", bindings)
+	}
+}
@@ -0,0 +1,136 @@
+package sip
+
+import (
+	"sip/header"
+	"sip/parser"
+)
+
+// An INVITE with a Replaces (RFC 3891) asks to replace a dialog of its
+// recipient with the one it sets up, as the target of an attended transfer
+// or a call pickup does, and one with a Join (RFC 3911) to add its session
+// to that of the dialog. Both identify the dialog as the recipient sees
+// it: their to-tag is its local tag, and their from-tag its remote tag.
+// NewReplaces and NewJoin identify a dialog of this side to its remote
+// party, and GetReplacedDialog and GetJoinedDialog find the dialog an
+// INVITE received names, or the status code to reject it with: 481 if no
+// dialog matches, including an early dialog which this side did not
+// initiate, 603 if it has terminated, and 486 for a confirmed dialog a
+// Replaces with early-only names. Replacing or joining the dialog, and
+// ending it with a BYE, is left to the application.
+
+// The option tags of RFC 3891 and RFC 3911.
+const (
+	OPTIONTAG_REPLACES = "replaces"
+	OPTIONTAG_JOIN     = "join"
+)
+
+////////////////////Implementation////////////////////////
+
+// NewReplaces returns the Replaces identifying d to its remote party.
+func NewReplaces(d Dialog) *header.Replaces {
+	replaces := header.NewReplaces()
+	replaces.SetCallId(d.GetCallId())
+	replaces.SetToTag(d.GetRemoteTag())
+	replaces.SetFromTag(d.GetLocalTag())
+	return replaces
+}
+
+// NewJoin returns the Join identifying d to its remote party.
+func NewJoin(d Dialog) *header.Join {
+	join := header.NewJoin()
+	join.SetCallId(d.GetCallId())
+	join.SetToTag(d.GetRemoteTag())
+	join.SetFromTag(d.GetLocalTag())
+	return join
+}
+
+// GetReplaces returns the Replaces of msg, and false if it has none or an
+// invalid one.
+func GetReplaces(msg Message) (*header.Replaces, bool) {
+	v := msg.GetHeader().Get("Replaces")
+	if v == "" {
+		return nil, false
+	}
+	sh, err := parser.NewReplacesParser("Replaces: " + v + "\n").Parse()
+	if err != nil {
+		return nil, false
+	}
+	return sh.(*header.Replaces), true
+}
+
+// GetJoin returns the Join of msg, and false if it has none or an invalid
+// one.
+func GetJoin(msg Message) (*header.Join, bool) {
+	v := msg.GetHeader().Get("Join")
+	if v == "" {
+		return nil, false
+	}
+	sh, err := parser.NewJoinParser("Join: " + v + "\n").Parse()
+	if err != nil {
+		return nil, false
+	}
+	return sh.(*header.Join), true
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// GetReplacedDialog returns the dialog the Replaces of req, an INVITE,
+// names, or the status code to reject req with; nil and 0 if req has no
+// Replaces.
+func (this *provider) GetReplacedDialog(req Request) (Dialog, int) {
+	switch len(req.GetHeader().Values("Replaces")) {
+	case 0:
+		return nil, 0
+	case 1:
+	default:
+		return nil, BAD_REQUEST
+	}
+	replaces, ok := GetReplaces(req)
+	if !ok {
+		return nil, BAD_REQUEST
+	}
+	d, statusCode := this.matchDialogId(replaces.GetCallId(), replaces.GetToTag(), replaces.GetFromTag())
+	if statusCode == 0 && replaces.IsEarlyOnly() && d.GetState() != DIALOGSTATE_EARLY {
+		return nil, BUSY_HERE
+	}
+	return d, statusCode
+}
+
+// GetJoinedDialog returns the dialog the Join of req, an INVITE, names,
+// or the status code to reject req with; nil and 0 if req has no Join.
+func (this *provider) GetJoinedDialog(req Request) (Dialog, int) {
+	switch len(req.GetHeader().Values("Join")) {
+	case 0:
+		return nil, 0
+	case 1:
+	default:
+		return nil, BAD_REQUEST
+	}
+	join, ok := GetJoin(req)
+	if !ok {
+		return nil, BAD_REQUEST
+	}
+	return this.matchDialogId(join.GetCallId(), join.GetToTag(), join.GetFromTag())
+}
+
+// matchDialogId returns the dialog of a Replaces or a Join, or the status
+// code rejecting the INVITE naming it: an early dialog only matches if
+// this side initiated it.
+func (this *provider) matchDialogId(callId, localTag, remoteTag string) (Dialog, int) {
+	this.mutex.Lock()
+	d := this.dialogs[getDialogId(callId, localTag, remoteTag)]
+	this.mutex.Unlock()
+
+	if d == nil {
+		return nil, CALL_OR_TRANSACTION_DOES_NOT_EXIST
+	}
+	switch d.GetState() {
+	case DIALOGSTATE_EARLY:
+		if d.IsServer() {
+			return nil, CALL_OR_TRANSACTION_DOES_NOT_EXIST
+		}
+	case DIALOGSTATE_TERMINATED:
+		return nil, DECLINE
+	}
+	return d, 0
+}
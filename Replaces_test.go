@@ -0,0 +1,98 @@
+package sip
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReplacedDialog(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKrp1")
+	invite.GetHeader().Set("Contact", "<sip:alice@192.0.2.1>")
+	invite.SetMessageInfo(&MessageInfo{Network: UDP, LocalAddr: &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5060}})
+	p.processMessage(invite)
+	ringing := CreateResponse(invite, RINGING)
+	ringing.GetHeader().Set("To", "<sip:bob@example.com>;tag=rp1")
+	ringing.GetHeader().Set("Contact", "<sip:bob@192.0.2.2>")
+	if err := l.transactions[0].SendResponse(ringing); err != nil {
+		t.Fatal(err)
+	}
+	d := l.transactions[0].GetDialog()
+	if v := NewReplaces(d).EncodeBody(); v != "3848276298220188511;to-tag=1;from-tag=rp1" {
+		t.Errorf("Replaces %q", v)
+	}
+
+	newInvite := func(name, value string) Request {
+		req := newServerTestRequest(INVITE, "UDP", "z9hG4bKrp2")
+		req.GetHeader().Set(name, value)
+		return req
+	}
+
+	//an early dialog this side did not initiate is not replaced
+	if _, statusCode := p.GetReplacedDialog(newInvite("Replaces", "3848276298220188511;to-tag=rp1;from-tag=1")); statusCode != CALL_OR_TRANSACTION_DOES_NOT_EXIST {
+		t.Errorf("early dialog of the UAS replaced with %d", statusCode)
+	}
+
+	//once confirmed, it is, unless only early ones may be
+	ok200 := CreateResponse(invite, OK)
+	ok200.GetHeader().Set("To", "<sip:bob@example.com>;tag=rp1")
+	if err := l.transactions[0].SendResponse(ok200); err != nil {
+		t.Fatal(err)
+	}
+	if replaced, statusCode := p.GetReplacedDialog(newInvite("Replaces", "3848276298220188511;from-tag=1;to-tag=rp1")); replaced != d || statusCode != 0 {
+		t.Errorf("Replaces matched %v, %d", replaced, statusCode)
+	}
+	if _, statusCode := p.GetReplacedDialog(newInvite("Replaces", "3848276298220188511;to-tag=rp1;from-tag=1;early-only")); statusCode != BUSY_HERE {
+		t.Errorf("confirmed dialog replaced early-only with %d", statusCode)
+	}
+	if _, statusCode := p.GetReplacedDialog(newInvite("Replaces", "3848276298220188511;to-tag=1;from-tag=rp1")); statusCode != CALL_OR_TRANSACTION_DOES_NOT_EXIST {
+		t.Errorf("Replaces with swapped tags answered %d", statusCode)
+	}
+	if joined, statusCode := p.GetJoinedDialog(newInvite("Join", "3848276298220188511;to-tag=rp1;from-tag=1")); joined != d || statusCode != 0 {
+		t.Errorf("Join matched %v, %d", joined, statusCode)
+	}
+
+	twice := newInvite("Replaces", "3848276298220188511;to-tag=rp1;from-tag=1")
+	twice.GetHeader().Add("Replaces", "3848276298220188511;to-tag=rp1;from-tag=1")
+	if _, statusCode := p.GetReplacedDialog(twice); statusCode != BAD_REQUEST {
+		t.Errorf("two Replaces answered %d", statusCode)
+	}
+	if d, statusCode := p.GetReplacedDialog(newServerTestRequest(INVITE, "UDP", "z9hG4bKrp3")); d != nil || statusCode != 0 {
+		t.Errorf("INVITE without Replaces matched %v, %d", d, statusCode)
+	}
+}
+
+func TestReplacedEarlyDialog(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	captureSends(p)
+	go p.Run()
+	defer p.Stop()
+
+	//a call ringing elsewhere is picked up by replacing its early dialog
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKrp4")
+	invite.GetHeader().Set("Contact", "<sip:alice@192.0.2.1>")
+	ct := p.GetNewClientTransaction(invite).(*clientTransaction)
+	ct.hops = []Hop{NewHop("192.0.2.7", 5060, UDP)}
+	ct.start()
+	ringing := CreateResponse(invite, RINGING)
+	ringing.GetHeader().Set("To", "<sip:bob@example.com>;tag=rp4")
+	ringing.GetHeader().Set("Contact", "<sip:bob@192.0.2.7>")
+	p.processResponse(ringing)
+	d := ct.GetDialog()
+
+	pickup := newServerTestRequest(INVITE, "UDP", "z9hG4bKrp5")
+	pickup.GetHeader().Set("Replaces", d.GetCallId()+";to-tag=1;from-tag=rp4;early-only")
+	if replaced, statusCode := p.GetReplacedDialog(pickup); replaced != d || statusCode != 0 {
+		t.Errorf("pickup matched %v, %d", replaced, statusCode)
+	}
+	if v := NewJoin(d).String(); v != "Join: "+d.GetCallId()+";to-tag=rp4;from-tag=1\r\n" {
+		t.Errorf("Join %q", v)
+	}
+}
@@ -1,10 +1,12 @@
 package sip
 
 import (
-	"bytes"
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
-	"strings"
+	"sip/address"
+	"sip/parser"
 )
 
 type Request interface {
@@ -12,8 +14,10 @@ type Request interface {
 
 	GetMethod() string
 	SetMethod(method string) error
-	GetRequestURI() string
-	SetRequestURI(uri string) error
+	GetRequestURI() address.URI
+	SetRequestURI(uri address.URI) error
+	GetRequestURIString() string
+	SetRequestURIString(uri string) error
 }
 
 const (
@@ -45,26 +49,38 @@ func NewRequest(method, requestURI string, body io.Reader) *request {
 		message: message{
 			sipVersion: "SIP/2.0",
 			header:     make(Header),
-			body:       body,
 		},
 		method:     method,
 		requestURI: requestURI,
 	}
 	this.StartLineWriter = this
 	if body != nil {
-		switch v := body.(type) {
-		case *bytes.Buffer:
-			this.SetContentLength(int64(v.Len()))
-		case *bytes.Reader:
-			this.SetContentLength(int64(v.Len()))
-		case *strings.Reader:
-			this.SetContentLength(int64(v.Len()))
-		}
+		this.SetBody(body)
 	}
 
 	return this
 }
 
+// ParseURI parses a URI: a sip or sips URI gives an *address.SipURIImpl, a
+// tel URI an *address.TelURLImpl, any other scheme an *address.URIImpl.
+func ParseURI(uri string) (address.URI, error) {
+	return parser.NewURLParser(uri).Parse()
+}
+
+// ReadRequest reads and parses an incoming request from b. It fails if the
+// message read is a response.
+func ReadRequest(b *bufio.Reader) (Request, error) {
+	msg, err := ReadMessage(b)
+	if err != nil {
+		return nil, err
+	}
+	req, ok := msg.(Request)
+	if !ok {
+		return nil, fmt.Errorf("sip: expected a request, received response %d", msg.(Response).GetStatusCode())
+	}
+	return req, nil
+}
+
 func (this *request) GetMethod() string {
 	return this.method
 }
@@ -74,18 +90,38 @@ func (this *request) SetMethod(method string) error {
 	return nil
 }
 
-func (this *request) GetRequestURI() string {
+// GetRequestURI parses the Request-URI, or returns nil if it is malformed.
+// The URI is a copy: changes to it take effect through SetRequestURI.
+func (this *request) GetRequestURI() address.URI {
+	uri, err := ParseURI(this.requestURI)
+	if err != nil {
+		return nil
+	}
+	return uri
+}
+
+func (this *request) SetRequestURI(uri address.URI) error {
+	if uri == nil {
+		return errors.New("sip: nil Request-URI")
+	}
+	this.requestURI = uri.String()
+	return nil
+}
+
+// GetRequestURIString returns the Request-URI as it is written on the
+// request line.
+func (this *request) GetRequestURIString() string {
 	return this.requestURI
 }
 
-func (this *request) SetRequestURI(requestURI string) error {
+func (this *request) SetRequestURIString(requestURI string) error {
 	this.requestURI = requestURI
 	return nil
 }
 
 //Method RequestURI SIP/2.0
 func (this *request) StartLineWrite(w io.Writer) (err error) {
-	if _, err = fmt.Fprintf(w, "%s %s SIP/2.0\r\n", this.GetMethod(), this.GetRequestURI()); err != nil {
+	if _, err = io.WriteString(w, this.GetMethod()+" "+this.GetRequestURIString()+" SIP/2.0\r\n"); err != nil {
 		return err
 	}
 	return nil
@@ -3,19 +3,41 @@ package sip
 type RequestEvent struct {
 	transaction ServerTransaction
 	request     Request
+	writer      ResponseWriter
+	dialog      Dialog
 }
 
 func NewRequestEvent(serverTransaction ServerTransaction, request Request) *RequestEvent {
-	return &RequestEvent{
+	this := &RequestEvent{
 		transaction: serverTransaction,
 		request:     request,
 	}
+	if serverTransaction != nil {
+		this.writer = NewResponseWriter(serverTransaction)
+	}
+	return this
 }
 
 func (this *RequestEvent) GetServerTransaction() ServerTransaction {
 	return this.transaction
 }
 
+// GetResponseWriter returns the ResponseWriter answering the request in its
+// server transaction, or nil if it has none.
+func (this *RequestEvent) GetResponseWriter() ResponseWriter {
+	return this.writer
+}
+
+// GetDialog returns the dialog the request was received in, or nil.
+func (this *RequestEvent) GetDialog() Dialog {
+	return this.dialog
+}
+
 func (this *RequestEvent) GetRequest() Request {
 	return this.request
 }
+
+// GetMessageInfo returns how the request was received.
+func (this *RequestEvent) GetMessageInfo() *MessageInfo {
+	return this.request.GetMessageInfo()
+}
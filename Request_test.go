@@ -0,0 +1,76 @@
+package sip
+
+import (
+	"bytes"
+	"sip/address"
+	"strings"
+	"testing"
+)
+
+func TestRequestURI(t *testing.T) {
+	req := NewRequest(INVITE, "sip:bob:secret@biloxi.com:5070;transport=tcp;lr?subject=hi", nil)
+
+	uri, ok := req.GetRequestURI().(*address.SipURIImpl)
+	if !ok {
+		t.Fatalf("Request-URI = %T, want *address.SipURIImpl", req.GetRequestURI())
+	}
+	if uri.GetUser() != "bob" || uri.GetUserPassword() != "secret" || uri.GetHost() != "biloxi.com" || uri.GetPort() != 5070 {
+		t.Errorf("user:password@host:port = %s:%s@%s:%d", uri.GetUser(), uri.GetUserPassword(), uri.GetHost(), uri.GetPort())
+	}
+	if uri.GetTransportParam() != "tcp" || !uri.HasLrParam() || uri.GetHeader("subject") != "hi" {
+		t.Errorf("parameters = %s", uri)
+	}
+
+	//the URI is a copy until set back
+	uri.SetUser("carol")
+	if req.GetRequestURIString() != "sip:bob:secret@biloxi.com:5070;transport=tcp;lr?subject=hi" {
+		t.Errorf("Request-URI changed to %s", req.GetRequestURIString())
+	}
+	if err := req.SetRequestURI(uri); err != nil {
+		t.Fatal(err)
+	}
+	var buffer bytes.Buffer
+	req.StartLineWrite(&buffer)
+	if buffer.String() != "INVITE sip:carol:secret@biloxi.com:5070;transport=tcp;lr?subject=hi SIP/2.0\r\n" {
+		t.Errorf("request line = %q", buffer.String())
+	}
+
+	if err := req.SetRequestURI(nil); err == nil {
+		t.Error("SetRequestURI accepted nil")
+	}
+
+	req.SetRequestURIString("tel:+1-201-555-0123")
+	if tel, ok := req.GetRequestURI().(*address.TelURLImpl); !ok || !tel.IsGlobal() || tel.GetPhoneNumber() != "1-201-555-0123" {
+		t.Errorf("Request-URI = %v", req.GetRequestURI())
+	}
+
+	req.SetRequestURIString("sip:bob@biloxi.com:port")
+	if uri := req.GetRequestURI(); uri != nil {
+		t.Errorf("malformed Request-URI parsed as %s", uri)
+	}
+}
+
+func TestURIBuilders(t *testing.T) {
+	uri := address.NewSipURI("alice", "atlanta.com", 0)
+	uri.SetTransportParam("tcp")
+	if uri.String() != "sip:alice@atlanta.com;transport=tcp" {
+		t.Errorf("sip URI = %s", uri)
+	}
+
+	uri = address.NewSipURI("", "192.0.2.1", 5061)
+	uri.SetSecure(true)
+	if uri.String() != "sips:192.0.2.1:5061" {
+		t.Errorf("sips URI = %s", uri)
+	}
+
+	for _, s := range []string{"+1-201-555-0123", "7042"} {
+		tel := address.NewTelURL(s)
+		if tel.String() != "tel:"+s || tel.IsGlobal() != strings.HasPrefix(s, "+") {
+			t.Errorf("tel URI = %s, global %v", tel, tel.IsGlobal())
+		}
+		parsed, err := ParseURI(tel.String())
+		if err != nil || parsed.String() != tel.String() {
+			t.Errorf("ParseURI(%s) = %v, %v", tel, parsed, err)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package sip
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A Resolver turns the next hop of a request into the transport addresses
+// to try, in order. It may block on DNS, so the provider always calls it
+// from a worker goroutine and never from its event loop.
+type Resolver interface {
+	Resolve(ctx context.Context, hop Hop) ([]Hop, error)
+}
+
+// RESOLVE_TIMEOUT bounds a resolution like Timer B bounds a transaction.
+const RESOLVE_TIMEOUT = 32 * time.Second
+
+// RESOLVE_WORKERS is the number of resolutions a provider runs at once.
+const RESOLVE_WORKERS = 16
+
+////////////////////Implementation////////////////////////
+
+type hostResolver struct {
+	resolver *net.Resolver
+}
+
+// NewResolver returns a Resolver which looks up the A and AAAA records of
+// the hop host; the port and transport of the hop are kept.
+func NewResolver() Resolver {
+	return &hostResolver{resolver: net.DefaultResolver}
+}
+
+func (this *hostResolver) Resolve(ctx context.Context, h Hop) ([]Hop, error) {
+	if net.ParseIP(h.GetHost()) != nil {
+		return []Hop{h}, nil
+	}
+
+	addrs, err := this.resolver.LookupHost(ctx, h.GetHost())
+	if err != nil {
+		return nil, err
+	}
+
+	hops := make([]Hop, len(addrs))
+	for i, addr := range addrs {
+		hops[i] = &hop{host: addr, port: h.GetPort(), transport: h.GetTransport(), ttl: h.GetTTL()}
+	}
+	return hops, nil
+}
+
+// The NAPTR services and SRV prefixes of the transports (RFC 3263 4.1).
+var (
+	naptrServices = map[string]string{
+		UDP: "SIP+D2U",
+		TCP: "SIP+D2T",
+		TLS: "SIPS+D2T",
+		WS:  "SIP+D2W",
+		WSS: "SIPS+D2W",
+	}
+	srvServices = map[string]string{
+		UDP: "_sip._udp.",
+		TCP: "_sip._tcp.",
+		TLS: "_sips._tcp.",
+		WS:  "_sip._ws.",
+		WSS: "_sips._ws.",
+	}
+)
+
+type locator struct {
+	transports []string
+
+	lookupNAPTR func(ctx context.Context, name string) ([]*naptr, error)
+	lookupSRV   func(ctx context.Context, name string) ([]*net.SRV, error)
+	lookupHost  func(ctx context.Context, host string) ([]string, error)
+}
+
+// NewLocator returns a Resolver locating SIP servers as RFC 3263 does.
+// For a hop whose URI set neither the port nor the transport, the NAPTR
+// records of the host select the transports, in order, and name their SRV
+// records; without NAPTR records the SRV records of each transport are
+// tried. A hop with only the transport set looks up the SRV records of
+// that transport. The SRV targets are ordered by priority and weight
+// (RFC 2782), each giving the addresses of its A and AAAA records. When
+// there are no such records, or the port is set, the host itself is looked
+// up, as NewResolver does.
+//
+// Every address found is returned, so that a transaction fails over to the
+// next one. Only the transports given are used, UDP, TCP and TLS if none
+// is; a sips URI only uses TLS and WSS. The hops made by NewHop are taken as
+// having both port and transport set.
+func NewLocator(transports ...string) Resolver {
+	this := &locator{}
+
+	this.transports = transports
+	if len(this.transports) == 0 {
+		this.transports = []string{UDP, TCP, TLS}
+	}
+	servers := getNameservers()
+	this.lookupNAPTR = func(ctx context.Context, name string) ([]*naptr, error) {
+		return lookupNAPTR(ctx, servers, name)
+	}
+	this.lookupSRV = func(ctx context.Context, name string) ([]*net.SRV, error) {
+		_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+		return srvs, err
+	}
+	this.lookupHost = net.DefaultResolver.LookupHost
+
+	return this
+}
+
+func (this *locator) Resolve(ctx context.Context, h Hop) ([]Hop, error) {
+	if net.ParseIP(h.GetHost()) != nil {
+		return []Hop{h}, nil
+	}
+
+	if hh, ok := h.(*hop); ok {
+		var hops []Hop
+		switch {
+		case hh.defaultTransport && hh.defaultPort:
+			if hops = this.resolveNAPTR(ctx, hh); len(hops) == 0 {
+				hops = this.resolveSRV(ctx, hh, this.getTransports(hh))
+			}
+		case hh.defaultPort:
+			hops = this.resolveSRV(ctx, hh, []string{hh.transport})
+		}
+		if len(hops) > 0 {
+			return hops, nil
+		}
+	}
+
+	return this.resolveHost(ctx, h.GetHost(), h.GetPort(), h.GetTransport(), h.GetTTL())
+}
+
+// getTransports returns the transports usable for h.
+func (this *locator) getTransports(h *hop) []string {
+	var transports []string
+	for _, t := range this.transports {
+		if !h.secure || t == TLS || t == WSS {
+			transports = append(transports, t)
+		}
+	}
+	return transports
+}
+
+func (this *locator) resolveNAPTR(ctx context.Context, h *hop) []Hop {
+	records, err := this.lookupNAPTR(ctx, h.GetHost())
+	if err != nil {
+		return nil
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].order != records[j].order {
+			return records[i].order < records[j].order
+		}
+		return records[i].preference < records[j].preference
+	})
+
+	var hops []Hop
+	for _, record := range records {
+		if !strings.EqualFold(record.flags, "s") {
+			continue
+		}
+		for _, t := range this.getTransports(h) {
+			if strings.EqualFold(record.services, naptrServices[t]) {
+				hops = append(hops, this.resolveTarget(ctx, record.replacement, t)...)
+			}
+		}
+	}
+	return hops
+}
+
+func (this *locator) resolveSRV(ctx context.Context, h *hop, transports []string) []Hop {
+	var hops []Hop
+	for _, t := range transports {
+		if service, ok := srvServices[t]; ok {
+			hops = append(hops, this.resolveTarget(ctx, service+h.GetHost(), t)...)
+		}
+	}
+	return hops
+}
+
+// resolveTarget returns the addresses of the SRV records of name.
+func (this *locator) resolveTarget(ctx context.Context, name, transport string) []Hop {
+	srvs, err := this.lookupSRV(ctx, name)
+	if err != nil {
+		return nil
+	}
+
+	var hops []Hop
+	for _, srv := range srvs {
+		if srv.Target == "." {
+			//the service is decidedly not available
+			continue
+		}
+		found, err := this.resolveHost(ctx, strings.TrimSuffix(srv.Target, "."), int(srv.Port), transport, -1)
+		if err == nil {
+			hops = append(hops, found...)
+		}
+	}
+	return hops
+}
+
+func (this *locator) resolveHost(ctx context.Context, host string, port int, transport string, ttl int) ([]Hop, error) {
+	if net.ParseIP(host) != nil {
+		return []Hop{&hop{host: host, port: port, transport: transport, ttl: ttl}}, nil
+	}
+	addrs, err := this.lookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	hops := make([]Hop, len(addrs))
+	for i, addr := range addrs {
+		hops[i] = &hop{host: addr, port: port, transport: transport, ttl: ttl}
+	}
+	return hops, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// resolution is the outcome of resolving the next hop of a client
+// transaction, handed back to the provider event loop.
+type resolution struct {
+	transaction *clientTransaction
+	hops        []Hop
+	err         error
+}
+
+// resolve resolves hop for ct on a worker goroutine, with ct held in the
+// resolving state, and posts the outcome to the event loop.
+func (this *provider) resolve(ct *clientTransaction, h Hop) {
+	ct.SetState(TRANSACTIONSTATE_RESOLVING)
+	resolver := this.GetResolver()
+
+	this.spawn(resourceResolve, func() {
+		select {
+		case this.workers <- true:
+			defer func() { <-this.workers }()
+		case <-ct.quit:
+			return
+		case <-this.quit:
+			return
+		}
+
+		//abandoning the transaction, or stopping, cancels the lookup
+		ctx, cancel := context.WithTimeout(context.Background(), RESOLVE_TIMEOUT)
+		watched := make(chan bool)
+		defer func() {
+			cancel()
+			<-watched
+		}()
+		go func() {
+			defer close(watched)
+			select {
+			case <-ct.quit:
+				cancel()
+			case <-this.quit:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		r := &resolution{transaction: ct}
+		r.hops, r.err = resolver.Resolve(ctx, h)
+
+		select {
+		case this.resolved <- r:
+		case <-this.quit:
+		}
+	})
+}
+
+// processResolution resumes a transaction once its next hop is resolved;
+// a failure completes it with a *TransportError. The transaction starts
+// off the event loop, since sending may first set up a connection.
+func (this *provider) processResolution(r *resolution) {
+	ct := r.transaction
+	select {
+	case <-ct.quit:
+		//abandoned while resolving
+		return
+	default:
+	}
+
+	if r.err == nil && len(r.hops) == 0 {
+		r.err = &net.DNSError{Err: "no addresses", Name: ct.GetRequest().GetRequestURIString()}
+	}
+	if r.err != nil {
+		ct.processError(&TransportError{Err: r.err})
+		return
+	}
+
+	hops, err := this.getSecureHops(ct.GetRequest(), this.getSizedHops(ct.GetRequest(), r.hops))
+	if err != nil {
+		ct.processError(&TransportError{Err: err})
+		return
+	}
+	hops = this.getAvailableHops(hops)
+	ct.mutex.Lock()
+	ct.hops = hops
+	ct.mutex.Unlock()
+
+	this.spawn(resourceStart, ct.start)
+}
@@ -0,0 +1,356 @@
+package sip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type blockingResolver struct {
+	release chan bool
+}
+
+func (this *blockingResolver) Resolve(ctx context.Context, h Hop) ([]Hop, error) {
+	select {
+	case <-this.release:
+		return nil, errors.New("no such host")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestAsyncResolution(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	resolver := &blockingResolver{release: make(chan bool)}
+	p.SetResolver(resolver)
+	go p.Run()
+	defer p.Stop()
+
+	newOptions := func() Request {
+		req := NewRequest(OPTIONS, "sip:bob@unresolvable.example", nil)
+		req.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.1;branch=z9hG4bK"+randomHex(8))
+		req.GetHeader().Set("Cseq", "1 OPTIONS")
+		return req
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := p.Do(context.Background(), newOptions())
+		done <- err
+	}()
+
+	//the event loop keeps serving while the first resolution is blocked
+	second := make(chan ClientTransaction)
+	go func() {
+		ct := p.GetNewClientTransaction(newOptions())
+		ct.SendRequest()
+		second <- ct
+	}()
+	var ct ClientTransaction
+	select {
+	case ct = <-second:
+	case <-time.After(time.Second):
+		t.Fatal("provider loop blocked by a pending resolution")
+	}
+	if ct.GetState() != TRANSACTIONSTATE_RESOLVING {
+		t.Errorf("state = %d, want resolving", ct.GetState())
+	}
+
+	resolver.release <- true
+	resolver.release <- true
+	select {
+	case err := <-done:
+		var te *TransportError
+		if !errors.As(err, &te) {
+			t.Fatalf("Do error = %v, want a *TransportError", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("resolution failure was not delivered")
+	}
+}
+
+func newTestLocator() *locator {
+	this := NewLocator(UDP, TCP, TLS).(*locator)
+	this.lookupNAPTR = func(ctx context.Context, name string) ([]*naptr, error) {
+		if name != "atlanta.com" {
+			return nil, nil
+		}
+		return []*naptr{
+			{order: 50, preference: 50, flags: "s", services: "SIP+D2U", replacement: "_sip._udp.atlanta.com."},
+			{order: 90, preference: 50, flags: "s", services: "SIP+D2T", replacement: "_sip._tcp.atlanta.com."},
+			{order: 90, preference: 50, flags: "s", services: "SIP+D2X", replacement: "_sip._sctp.atlanta.com."},
+			{order: 50, preference: 10, flags: "s", services: "SIPS+D2T", replacement: "_sips._tcp.atlanta.com."},
+		}, nil
+	}
+	this.lookupSRV = func(ctx context.Context, name string) ([]*net.SRV, error) {
+		switch strings.TrimSuffix(name, ".") {
+		case "_sip._udp.atlanta.com", "_sip._udp.biloxi.com":
+			return []*net.SRV{{Target: "server1.atlanta.com.", Port: 5060}, {Target: "server2.atlanta.com.", Port: 5060}}, nil
+		case "_sip._tcp.atlanta.com", "_sip._tcp.biloxi.com":
+			return []*net.SRV{{Target: "server1.atlanta.com.", Port: 5062}}, nil
+		case "_sips._tcp.atlanta.com":
+			return []*net.SRV{{Target: "server3.atlanta.com.", Port: 5061}}, nil
+		case "_sips._tcp.biloxi.com":
+			return []*net.SRV{{Target: ".", Port: 0}}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	this.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		switch host {
+		case "server1.atlanta.com":
+			return []string{"192.0.2.1"}, nil
+		case "server2.atlanta.com":
+			return []string{"192.0.2.2", "2001:db8::2"}, nil
+		case "server3.atlanta.com":
+			return []string{"192.0.2.3"}, nil
+		case "chicago.com":
+			return []string{"192.0.2.4"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	return this
+}
+
+func TestLocator(t *testing.T) {
+	l := newTestLocator()
+
+	for _, c := range []struct {
+		uri  string
+		want []string
+	}{
+		//NAPTR in order and preference, then the SRV of each
+		{"sip:bob@atlanta.com", []string{"192.0.2.3:5061/tls", "192.0.2.1:5060/udp", "192.0.2.2:5060/udp", "[2001:db8::2]:5060/udp", "192.0.2.1:5062/tcp"}},
+		{"sips:bob@atlanta.com", []string{"192.0.2.3:5061/tls"}},
+		//no NAPTR: the SRV of each transport, skipping those unavailable
+		{"sip:bob@biloxi.com", []string{"192.0.2.1:5060/udp", "192.0.2.2:5060/udp", "[2001:db8::2]:5060/udp", "192.0.2.1:5062/tcp"}},
+		//the transport chosen: its SRV only
+		{"sip:bob@atlanta.com;transport=tcp", []string{"192.0.2.1:5062/tcp"}},
+		{"sip:bob@chicago.com;transport=tcp", []string{"192.0.2.4:5060/tcp"}},
+		//the port chosen, or no records: the host itself
+		{"sip:bob@chicago.com:5070", []string{"192.0.2.4:5070/udp"}},
+		{"sip:bob@chicago.com", []string{"192.0.2.4:5060/udp"}},
+		{"sip:bob@192.0.2.9;transport=tcp", []string{"192.0.2.9:5060/tcp"}},
+	} {
+		req := NewRequest(OPTIONS, c.uri, nil)
+		next, err := GetNextHop(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hops, err := l.Resolve(context.Background(), next)
+		var got []string
+		for _, h := range hops {
+			got = append(got, h.String())
+		}
+		if err != nil || strings.Join(got, " ") != strings.Join(c.want, " ") {
+			t.Errorf("%s resolved to %v, %v, want %v", c.uri, got, err, c.want)
+		}
+	}
+
+	if _, err := l.Resolve(context.Background(), NewHop("unknown.example", 5060, UDP)); err == nil {
+		t.Error("unknown host resolved")
+	}
+}
+
+// serveNAPTR answers the DNS queries received on conn with the records of
+// answers, sharing their names as the responses of real servers do.
+func serveNAPTR(conn net.PacketConn, answers []*naptr) {
+	b := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		resp := append([]byte(nil), b[:n]...)
+		resp[2] |= 0x80 //QR
+		resp[7] = byte(len(answers))
+		for _, a := range answers {
+			var rdata []byte
+			rdata = append(rdata, byte(a.order>>8), byte(a.order), byte(a.preference>>8), byte(a.preference))
+			for _, s := range []string{a.flags, a.services, a.regexp} {
+				rdata = append(rdata, byte(len(s)))
+				rdata = append(rdata, s...)
+			}
+			for _, label := range strings.Split(strings.TrimSuffix(a.replacement, "."), ".") {
+				rdata = append(rdata, byte(len(label)))
+				rdata = append(rdata, label...)
+			}
+			rdata = append(rdata, 0)
+
+			//a pointer to the question name
+			resp = append(resp, 0xc0, 12, 0, dnsTypeNAPTR, 0, dnsClassIN, 0, 0, 0x0e, 0x10, byte(len(rdata)>>8), byte(len(rdata)))
+			resp = append(resp, rdata...)
+		}
+		conn.WriteTo(resp, addr)
+	}
+}
+
+func TestLookupNAPTR(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go serveNAPTR(conn, []*naptr{
+		{order: 90, preference: 50, flags: "s", services: "SIP+D2T", replacement: "_sip._tcp.atlanta.com."},
+		{order: 50, preference: 50, flags: "s", services: "SIP+D2U", replacement: "_sip._udp.atlanta.com."},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	records, err := lookupNAPTR(ctx, []string{conn.LocalAddr().String()}, "atlanta.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].order != 90 || records[0].flags != "s" || records[0].replacement != "_sip._tcp.atlanta.com." ||
+		records[1].services != "SIP+D2U" || records[1].preference != 50 || records[1].replacement != "_sip._udp.atlanta.com." {
+		for _, r := range records {
+			t.Logf("%+v", r)
+		}
+		t.Error("records not parsed")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	sent := captureSends(p)
+	send := p.send
+	p.send = func(msg Message, h Hop) error {
+		if h.GetHost() == "192.0.2.1" {
+			return errors.New("connection refused")
+		}
+		return send(msg, h)
+	}
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.1", 5060, TCP), NewHop("192.0.2.2", 5060, UDP)}, nil
+	}))
+	go p.Run()
+	defer p.Stop()
+
+	req := NewRequest(OPTIONS, "sip:bob@biloxi.com", nil)
+	req.GetHeader().Set("Via", "SIP/2.0/TCP 192.0.2.9;branch=z9hG4bKfailover")
+	req.GetHeader().Set("Cseq", "1 OPTIONS")
+	ct := p.GetNewClientTransaction(req).(*clientTransaction)
+	if err := ct.SendRequest(); err != nil {
+		t.Fatal(err)
+	}
+
+	waitSent(t, sent, 1)
+	sent.mutex.Lock()
+	h := sent.hops[0]
+	sent.mutex.Unlock()
+	if h.GetHost() != "192.0.2.2" {
+		t.Errorf("sent to %s, want the second address", h)
+	}
+	//in a new transaction, with a branch of its own
+	if via := req.GetHeader().Get("Via"); via != "SIP/2.0/UDP 192.0.2.9;branch="+getBranch(req) || getBranch(req) == "z9hG4bKfailover" || !strings.HasPrefix(getBranch(req), BRANCH_MAGIC_COOKIE) {
+		t.Errorf("Via = %s", via)
+	}
+	resp := CreateResponse(req, OK)
+	if p.matchClientTransaction(resp) != ct {
+		t.Error("response to the second address not matched")
+	}
+	resp.GetHeader().Set("Via", "SIP/2.0/TCP 192.0.2.9;branch=z9hG4bKfailover")
+	if p.matchClientTransaction(resp) != nil {
+		t.Error("response to the first address matched")
+	}
+	if ct.GetState() != TRANSACTIONSTATE_TRYING {
+		t.Errorf("state = %d, want trying", ct.GetState())
+	}
+}
+
+func TestFailoverBlacklist(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	bl := NewBlacklist()
+	p.SetBlacklist(bl)
+	sent := captureSends(p)
+	send := p.send
+	p.send = func(msg Message, h Hop) error {
+		if h.GetHost() == "192.0.2.1" {
+			//the second address goes down meanwhile
+			bl.Add("192.0.2.2:5060", time.Minute)
+			return errors.New("connection refused")
+		}
+		return send(msg, h)
+	}
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.1", 5060, UDP), NewHop("192.0.2.2", 5060, UDP), NewHop("192.0.2.3", 5060, UDP)}, nil
+	}))
+	go p.Run()
+	defer p.Stop()
+
+	sendOptions := func(n int) Hop {
+		req := NewRequest(OPTIONS, "sip:bob@biloxi.com", nil)
+		req.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.9;branch="+GenerateBranchId())
+		req.GetHeader().Set("Cseq", strconv.Itoa(n)+" OPTIONS")
+		if err := p.GetNewClientTransaction(req).SendRequest(); err != nil {
+			t.Fatal(err)
+		}
+		waitSent(t, sent, n)
+		sent.mutex.Lock()
+		defer sent.mutex.Unlock()
+		return sent.hops[n-1]
+	}
+
+	//failover skips an address blacklisted since the resolution
+	if h := sendOptions(1); h.GetHost() != "192.0.2.3" {
+		t.Errorf("sent to %s, want the third address", h)
+	}
+
+	//and the resolution drops those blacklisted already
+	bl.Remove("192.0.2.2:5060")
+	bl.Add("192.0.2.1:5060", time.Minute)
+	if h := sendOptions(2); h.GetHost() != "192.0.2.2" {
+		t.Errorf("sent to %s, want the second address", h)
+	}
+}
+
+func TestResolutionStart(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	sent := captureSends(p)
+	send := p.send
+	dialing, release := make(chan bool, 1), make(chan bool)
+	p.send = func(msg Message, h Hop) error {
+		if h.GetHost() == "192.0.2.1" {
+			//a connection which takes long to set up
+			dialing <- true
+			<-release
+		}
+		return send(msg, h)
+	}
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop(h.GetHost(), 5060, TCP)}, nil
+	}))
+	go p.Run()
+	defer p.Stop()
+	defer close(release)
+
+	//the transactions start off the event loop, which goes on meanwhile
+	for _, host := range []string{"192.0.2.1", "192.0.2.2"} {
+		req := NewRequest(OPTIONS, "sip:bob@"+host, nil)
+		req.GetHeader().Set("Via", "SIP/2.0/TCP 192.0.2.9;branch="+GenerateBranchId())
+		req.GetHeader().Set("Cseq", "1 OPTIONS")
+		if err := p.GetNewClientTransaction(req).SendRequest(); err != nil {
+			t.Fatal(err)
+		}
+		if host == "192.0.2.1" {
+			<-dialing
+		}
+	}
+	waitSent(t, sent, 1)
+	sent.mutex.Lock()
+	h := sent.hops[0]
+	sent.mutex.Unlock()
+	if h.GetHost() != "192.0.2.2" {
+		t.Errorf("sent to %s first", h)
+	}
+}
+
+type resolverFunc func(ctx context.Context, h Hop) ([]Hop, error)
+
+func (f resolverFunc) Resolve(ctx context.Context, h Hop) ([]Hop, error) {
+	return f(ctx, h)
+}
@@ -0,0 +1,60 @@
+package sip
+
+import (
+	"sip/header"
+	"sip/parser"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A Prioritizer reports whether an incoming message is queued and
+// processed ahead of normal traffic.
+type Prioritizer func(msg Message) bool
+
+// PRIORITY_NAMESPACES are the Resource-Priority namespaces served first by
+// default: emergency calls (RFC 7135) and government emergency services.
+var PRIORITY_NAMESPACES = []string{
+	header.ResourcePriority_ESNET,
+	header.ResourcePriority_ETS,
+	header.ResourcePriority_WPS,
+}
+
+////////////////////Implementation////////////////////////
+
+// NewResourcePrioritizer returns a Prioritizer selecting the requests with
+// a Resource-Priority (RFC 4412) in one of namespaces. Responses are never
+// prioritized.
+func NewResourcePrioritizer(namespaces ...string) Prioritizer {
+	recognized := make(map[string]bool)
+	for _, namespace := range namespaces {
+		recognized[namespace] = true
+	}
+
+	return func(msg Message) bool {
+		if _, ok := msg.(Request); !ok {
+			return false
+		}
+		for _, rp := range GetResourcePriority(msg) {
+			if recognized[rp.GetNamespace()] {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// GetResourcePriority returns the Resource-Priority values of msg, skipping
+// the malformed ones.
+func GetResourcePriority(msg Message) []*header.ResourcePriority {
+	var values []*header.ResourcePriority
+	for _, v := range msg.GetHeader()["Resource-Priority"] {
+		sh, err := parser.NewResourcePriorityParser("Resource-Priority: " + v + "\n").Parse()
+		if err != nil {
+			continue
+		}
+		for e := sh.(*header.ResourcePriorityList).Front(); e != nil; e = e.Next() {
+			values = append(values, e.Value.(*header.ResourcePriority))
+		}
+	}
+	return values
+}
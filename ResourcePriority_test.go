@@ -0,0 +1,54 @@
+package sip
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestPriorityQueuing(t *testing.T) {
+	block := make(chan bool)
+	busy := make(chan bool)
+	handled := make(chan string, 8)
+
+	d := NewDispatcher(1, func(msg Message) {
+		if msg.GetHeader().Get("Call-Id") == "busy" {
+			busy <- true
+			<-block
+		}
+		handled <- msg.GetHeader().Get("Call-Id")
+	})
+	d.Start()
+	defer d.Stop()
+
+	newInvite := func(callId, rp string) Request {
+		req := NewRequest(INVITE, "sip:bob@example.com", nil)
+		req.GetHeader().Set("Call-Id", callId)
+		if rp != "" {
+			req.GetHeader().Set("Resource-Priority", rp)
+		}
+		return req
+	}
+
+	//hold the only lane, then queue normal and priority traffic behind it
+	d.Dispatch(newInvite("busy", ""))
+	<-busy
+	for i := 0; i < 3; i++ {
+		d.Dispatch(newInvite("normal-"+strconv.Itoa(i), ""))
+	}
+	d.Dispatch(newInvite("unknown", "q735.1"))
+	d.Dispatch(newInvite("emergency", "esnet.0"))
+	d.Dispatch(newInvite("ets", "wps.2, ets.1"))
+	close(block)
+
+	var order []string
+	for i := 0; i < 7; i++ {
+		order = append(order, <-handled)
+	}
+	if order[0] != "busy" || order[1] != "emergency" || order[2] != "ets" || order[6] != "unknown" {
+		t.Errorf("handled in order %v", order)
+	}
+
+	if NewResourcePrioritizer("esnet")(CreateResponse(newInvite("r", "esnet.0"), OK)) {
+		t.Error("a response was prioritized")
+	}
+}
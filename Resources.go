@@ -0,0 +1,177 @@
+package sip
+
+import (
+	"sync"
+	"time"
+)
+
+// A provider registers what it holds while it runs: the goroutines it
+// starts and the sockets they serve, by kind, and the timers armed on its
+// Clock. Stop waits for the goroutines, which close their sockets, and
+// stops the timers left, so that nothing of the provider runs once it
+// returns; the registry then tells whether anything leaked.
+
+const (
+	resourceAccept  = "accept goroutine"
+	resourceServe   = "serve goroutine"
+	resourceResolve = "resolve goroutine"
+	resourceStart   = "start goroutine"
+	resourceLeave   = "leave goroutine"
+	resourceSocket  = "socket"
+	resourceTimer   = "timer"
+)
+
+////////////////////Implementation////////////////////////
+
+type resources struct {
+	mutex  sync.Mutex
+	counts map[string]int
+	timers map[*trackedTimer]bool
+}
+
+func newResources() *resources {
+	this := &resources{}
+
+	this.counts = make(map[string]int)
+	this.timers = make(map[*trackedTimer]bool)
+
+	return this
+}
+
+func (this *resources) acquire(kind string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.counts[kind]++
+}
+
+func (this *resources) release(kind string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.counts[kind]--; this.counts[kind] <= 0 {
+		delete(this.counts, kind)
+	}
+}
+
+// get returns the number of resources held, by kind, the kinds of which
+// none is held left out.
+func (this *resources) get() map[string]int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	counts := make(map[string]int)
+	for kind, n := range this.counts {
+		counts[kind] = n
+	}
+	if len(this.timers) > 0 {
+		counts[resourceTimer] = len(this.timers)
+	}
+	return counts
+}
+
+// stopTimers stops the timers armed and not fired yet.
+func (this *resources) stopTimers() {
+	this.mutex.Lock()
+	timers := make([]*trackedTimer, 0, len(this.timers))
+	for t := range this.timers {
+		timers = append(timers, t)
+	}
+	this.mutex.Unlock()
+
+	for _, t := range timers {
+		t.Stop()
+	}
+}
+
+// track returns clock, registering the timers of its AfterFunc until they
+// fire or are stopped.
+func (this *resources) track(clock Clock) Clock {
+	if c, ok := clock.(*trackedClock); ok {
+		clock = c.Clock
+	}
+	return &trackedClock{Clock: clock, resources: this}
+}
+
+type trackedClock struct {
+	Clock
+
+	resources *resources
+}
+
+func (this *trackedClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &trackedTimer{resources: this.resources}
+
+	//registered before it can fire, which waits for the mutex
+	this.resources.mutex.Lock()
+	t.Timer = this.Clock.AfterFunc(d, func() {
+		this.resources.removeTimer(t)
+		f()
+	})
+	this.resources.timers[t] = true
+	this.resources.mutex.Unlock()
+
+	return t
+}
+
+type trackedTimer struct {
+	Timer
+
+	resources *resources
+}
+
+func (this *trackedTimer) Stop() bool {
+	this.resources.removeTimer(this)
+	return this.Timer.Stop()
+}
+
+func (this *trackedTimer) Reset(d time.Duration) bool {
+	this.resources.addTimer(this)
+	return this.Timer.Reset(d)
+}
+
+func (this *resources) addTimer(t *trackedTimer) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.timers[t] = true
+}
+
+func (this *resources) removeTimer(t *trackedTimer) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	delete(this.timers, t)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// spawn runs f on a goroutine of kind, which Stop waits for.
+func (this *provider) spawn(kind string, f func()) {
+	this.waitGroup.Add(1)
+	this.resources.acquire(kind)
+	go func() {
+		defer this.waitGroup.Done()
+		defer this.resources.release(kind)
+
+		f()
+	}()
+}
+
+// getResources returns what the provider holds, by kind: goroutines,
+// sockets, armed timers, connections and transactions. After Stop, there
+// should be none.
+func (this *provider) getResources() map[string]int {
+	counts := this.resources.get()
+	if n := this.connections.len(); n > 0 {
+		counts["connection"] = n
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if n := len(this.clients) + len(this.servers); n > 0 {
+		counts["transaction"] = n
+	}
+	return counts
+}
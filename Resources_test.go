@@ -0,0 +1,89 @@
+package sip
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// waitResources waits until the provider holds n resources of kind.
+func waitResources(t *testing.T, p *provider, kind string, n int) {
+	for i := 0; p.getResources()[kind] != n; i++ {
+		if i == 100 {
+			t.Fatalf("%v held, want %d %s", p.getResources(), n, kind)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStopReleasesResources(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	p.AddTransport(newTransport(TCP, "127.0.0.1", port, nil))
+	go p.Run()
+
+	//a connection, a client transaction and a completed server one
+	var client net.Conn
+	for i := 0; client == nil; i++ {
+		if client, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port))); err != nil && i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer client.Close()
+	waitResources(t, p, "connection", 1)
+
+	ct := p.GetNewClientTransaction(newProxiedRequest(OPTIONS, "sip:bob@192.0.2.4", "z9hG4bKleak1"))
+	if err := ct.SendRequest(); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, 1)
+	st := p.GetNewServerTransaction(newServerTestRequest(OPTIONS, "UDP", "z9hG4bKleak2"))
+	if err := st.SendResponse(CreateResponse(st.GetRequest(), OK)); err != nil {
+		t.Fatal(err)
+	}
+
+	held := p.getResources()
+	for _, kind := range []string{resourceAccept, resourceServe, resourceSocket, resourceTimer, "transaction"} {
+		if held[kind] == 0 {
+			t.Errorf("no %s held while running: %v", kind, held)
+		}
+	}
+
+	p.Stop()
+	if held := p.getResources(); len(held) != 0 {
+		t.Errorf("%v held once stopped", held)
+	}
+	if clock.Pending() != 0 {
+		t.Errorf("%d timers pending once stopped", clock.Pending())
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Error("connection left open")
+	}
+
+	//stopping again, or running once stopped, does nothing
+	p.Stop()
+	p.Run()
+}
+
+func TestStopWithoutRun(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	p.GetClock().AfterFunc(time.Second, func() {
+		t.Error("timer fired once stopped")
+	})
+
+	p.Stop()
+	if held := p.getResources(); len(held) != 0 {
+		t.Errorf("%v held once stopped", held)
+	}
+}
@@ -1,10 +1,10 @@
 package sip
 
 import (
-	"bytes"
+	"bufio"
 	"fmt"
 	"io"
-	"strings"
+	"strconv"
 )
 
 type Response interface {
@@ -45,7 +45,9 @@ const (
 	UNSUPPORTED_URI_SCHEME             = 416
 	BAD_EXTENSION                      = 420
 	EXTENSION_REQUIRED                 = 421
+	SESSION_INTERVAL_TOO_SMALL         = 422
 	INTERVAL_TOO_BRIEF                 = 423
+	FLOW_FAILED                        = 430
 	TEMPORARILY_UNAVAILABLE            = 480
 	CALL_OR_TRANSACTION_DOES_NOT_EXIST = 481
 	LOOP_DETECTED                      = 482
@@ -71,6 +73,69 @@ const (
 	SESSION_NOT_ACCEPTABLE             = 606
 )
 
+var reasonPhrases = map[int]string{
+	TRYING:                             "Trying",
+	RINGING:                            "Ringing",
+	CALL_IS_BEING_FORWARDED:            "Call Is Being Forwarded",
+	QUEUED:                             "Queued",
+	SESSION_PROGRESS:                   "Session Progress",
+	OK:                                 "OK",
+	ACCEPTED:                           "Accepted",
+	MULTIPLE_CHOICES:                   "Multiple Choices",
+	MOVED_PERMANENTLY:                  "Moved Permanently",
+	MOVED_TEMPORARILY:                  "Moved Temporarily",
+	USE_PROXY:                          "Use Proxy",
+	ALTERNATIVE_SERVICE:                "Alternative Service",
+	BAD_REQUEST:                        "Bad Request",
+	UNAUTHORIZED:                       "Unauthorized",
+	PAYMENT_REQUIRED:                   "Payment Required",
+	FORBIDDEN:                          "Forbidden",
+	NOT_FOUND:                          "Not Found",
+	METHOD_NOT_ALLOWED:                 "Method Not Allowed",
+	NOT_ACCEPTABLE:                     "Not Acceptable",
+	PROXY_AUTHENTICATION_REQUIRED:      "Proxy Authentication Required",
+	REQUEST_TIMEOUT:                    "Request Timeout",
+	GONE:                               "Gone",
+	REQUEST_ENTITY_TOO_LARGE:           "Request Entity Too Large",
+	REQUEST_URI_TOO_LONG:               "Request-URI Too Long",
+	UNSUPPORTED_MEDIA_TYPE:             "Unsupported Media Type",
+	UNSUPPORTED_URI_SCHEME:             "Unsupported URI Scheme",
+	BAD_EXTENSION:                      "Bad Extension",
+	EXTENSION_REQUIRED:                 "Extension Required",
+	SESSION_INTERVAL_TOO_SMALL:         "Session Interval Too Small",
+	INTERVAL_TOO_BRIEF:                 "Interval Too Brief",
+	FLOW_FAILED:                        "Flow Failed",
+	TEMPORARILY_UNAVAILABLE:            "Temporarily Unavailable",
+	CALL_OR_TRANSACTION_DOES_NOT_EXIST: "Call/Transaction Does Not Exist",
+	LOOP_DETECTED:                      "Loop Detected",
+	TOO_MANY_HOPS:                      "Too Many Hops",
+	ADDRESS_INCOMPLETE:                 "Address Incomplete",
+	AMBIGUOUS:                          "Ambiguous",
+	BUSY_HERE:                          "Busy Here",
+	REQUEST_TERMINATED:                 "Request Terminated",
+	NOT_ACCEPTABLE_HERE:                "Not Acceptable Here",
+	BAD_EVENT:                          "Bad Event",
+	REQUEST_PENDING:                    "Request Pending",
+	UNDECIPHERABLE:                     "Undecipherable",
+	SERVER_INTERNAL_ERROR:              "Server Internal Error",
+	NOT_IMPLEMENTED:                    "Not Implemented",
+	BAD_GATEWAY:                        "Bad Gateway",
+	SERVICE_UNAVAILABLE:                "Service Unavailable",
+	SERVER_TIMEOUT:                     "Server Time-out",
+	VERSION_NOT_SUPPORTED:              "Version Not Supported",
+	MESSAGE_TOO_LARGE:                  "Message Too Large",
+	BUSY_EVERYWHERE:                    "Busy Everywhere",
+	DECLINE:                            "Decline",
+	DOES_NOT_EXIST_ANYWHERE:            "Does Not Exist Anywhere",
+	SESSION_NOT_ACCEPTABLE:             "Not Acceptable",
+}
+
+// StatusText returns the default reason phrase of a status code, or ""
+// if the code is unknown.
+func StatusText(statusCode int) string {
+	return reasonPhrases[statusCode]
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 type response struct {
 	message
@@ -84,26 +149,52 @@ func NewResponse(statusCode int, reasonPhrase string, body io.Reader) *response
 		message: message{
 			sipVersion: "SIP/2.0",
 			header:     make(Header),
-			body:       body,
 		},
 		statusCode:   statusCode,
 		reasonPhrase: reasonPhrase,
 	}
 	this.StartLineWriter = this
 	if body != nil {
-		switch v := body.(type) {
-		case *bytes.Buffer:
-			this.SetContentLength(int64(v.Len()))
-		case *bytes.Reader:
-			this.SetContentLength(int64(v.Len()))
-		case *strings.Reader:
-			this.SetContentLength(int64(v.Len()))
-		}
+		this.SetBody(body)
 	}
 
 	return this
 }
 
+// CreateResponse builds a response to req as described in RFC 3261 8.2.6:
+// Via, From, To, Call-ID and CSeq are copied, and the default reason phrase
+// of statusCode is used.
+func CreateResponse(req Request, statusCode int) Response {
+	resp := NewResponse(statusCode, StatusText(statusCode), nil)
+
+	for _, key := range []string{"Via", "From", "To", "Call-Id", "Cseq"} {
+		if v, ok := req.GetHeader()[key]; ok {
+			resp.GetHeader()[key] = append([]string(nil), v...)
+		}
+	}
+	if statusCode == TRYING {
+		if v, ok := req.GetHeader()["Timestamp"]; ok {
+			resp.GetHeader()["Timestamp"] = append([]string(nil), v...)
+		}
+	}
+
+	return resp
+}
+
+// ReadResponse reads and parses an incoming response from b. It fails if the
+// message read is a request.
+func ReadResponse(b *bufio.Reader) (Response, error) {
+	msg, err := ReadMessage(b)
+	if err != nil {
+		return nil, err
+	}
+	resp, ok := msg.(Response)
+	if !ok {
+		return nil, fmt.Errorf("sip: expected a response, received %s request", msg.(Request).GetMethod())
+	}
+	return resp, nil
+}
+
 func (this *response) SetStatusCode(statusCode int) error {
 	this.statusCode = statusCode
 	return nil
@@ -124,7 +215,7 @@ func (this *response) GetReasonPhrase() string {
 
 //SIP/2.0 StatusCode reasonPhrase
 func (this *response) StartLineWrite(w io.Writer) (err error) {
-	if _, err = fmt.Fprintf(w, "SIP/2.0 %d %s\r\n", this.GetStatusCode(), this.GetReasonPhrase()); err != nil {
+	if _, err = io.WriteString(w, "SIP/2.0 "+strconv.Itoa(this.GetStatusCode())+" "+this.GetReasonPhrase()+"\r\n"); err != nil {
 		return err
 	}
 	return nil
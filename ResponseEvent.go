@@ -3,6 +3,7 @@ package sip
 type ResponseEvent struct {
 	transaction ClientTransaction
 	response    Response
+	dialog      Dialog
 }
 
 func NewResponseEvent(clientTransaction ClientTransaction, response Response) *ResponseEvent {
@@ -16,6 +17,16 @@ func (this *ResponseEvent) GetClientTransaction() ClientTransaction {
 	return this.transaction
 }
 
+// GetDialog returns the dialog the response belongs to, or nil.
+func (this *ResponseEvent) GetDialog() Dialog {
+	return this.dialog
+}
+
 func (this *ResponseEvent) GetResponse() Response {
 	return this.response
 }
+
+// GetMessageInfo returns how the response was received.
+func (this *ResponseEvent) GetMessageInfo() *MessageInfo {
+	return this.response.GetMessageInfo()
+}
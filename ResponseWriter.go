@@ -0,0 +1,120 @@
+package sip
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A ResponseWriter answers the request of a server transaction, like
+// net/http's ResponseWriter. It sends any number of provisional responses
+// followed by exactly one final response, all sharing the same To tag and
+// carrying the default headers set with Header.
+type ResponseWriter interface {
+	Header() Header
+
+	Provisional(statusCode int) error
+	Respond(statusCode int, body io.Reader) error
+	Challenge(realm string) error
+
+	Written() bool
+	GetServerTransaction() ServerTransaction
+}
+
+var (
+	ErrResponseWritten = errors.New("sip: final response already sent")
+	ErrStatusCode      = errors.New("sip: status code not allowed here")
+)
+
+////////////////////Implementation////////////////////////
+
+type responseWriter struct {
+	mutex sync.Mutex
+
+	transaction ServerTransaction
+	header      Header
+	toTag       string
+	written     bool
+}
+
+func NewResponseWriter(st ServerTransaction) ResponseWriter {
+	this := &responseWriter{}
+
+	this.transaction = st
+	this.header = make(Header)
+	this.toTag = GenerateTag()
+
+	return this
+}
+
+// Header returns the headers added to every response sent, unless the
+// response already has them.
+func (this *responseWriter) Header() Header {
+	return this.header
+}
+
+func (this *responseWriter) GetServerTransaction() ServerTransaction {
+	return this.transaction
+}
+
+func (this *responseWriter) Written() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.written
+}
+
+// Provisional sends a 1xx response.
+func (this *responseWriter) Provisional(statusCode int) error {
+	if statusCode < TRYING || statusCode >= OK {
+		return ErrStatusCode
+	}
+	return this.write(CreateResponse(this.transaction.GetRequest(), statusCode), nil)
+}
+
+// Respond sends the final response, with an optional body.
+func (this *responseWriter) Respond(statusCode int, body io.Reader) error {
+	if statusCode < OK {
+		return ErrStatusCode
+	}
+	return this.write(CreateResponse(this.transaction.GetRequest(), statusCode), body)
+}
+
+// Challenge sends a 401 Unauthorized asking for digest credentials of
+// realm (RFC 3261 22.1).
+func (this *responseWriter) Challenge(realm string) error {
+	resp := CreateResponse(this.transaction.GetRequest(), UNAUTHORIZED)
+	resp.GetHeader().Set("WWW-Authenticate",
+		"Digest realm=\""+realm+"\", nonce=\""+randomHex(16)+"\", algorithm=MD5, qop=\"auth\"")
+	return this.write(resp, nil)
+}
+
+func (this *responseWriter) write(resp Response, body io.Reader) error {
+	this.mutex.Lock()
+	if this.written {
+		this.mutex.Unlock()
+		return ErrResponseWritten
+	}
+	if resp.GetStatusCode() >= OK {
+		this.written = true
+	}
+	this.mutex.Unlock()
+
+	h := resp.GetHeader()
+	for key, values := range this.header {
+		if _, ok := h[key]; !ok {
+			h[key] = append([]string(nil), values...)
+		}
+	}
+	if to := h.Get("To"); resp.GetStatusCode() != TRYING && to != "" && !strings.Contains(strings.ToLower(to), ";tag=") {
+		h.Set("To", to+";tag="+this.toTag)
+	}
+	if body != nil {
+		resp.SetBody(body)
+	}
+
+	return this.transaction.SendResponse(resp)
+}
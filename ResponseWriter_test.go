@@ -0,0 +1,68 @@
+package sip
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+type recordingTransaction struct {
+	*serverTransaction
+
+	responses []Response
+}
+
+func (this *recordingTransaction) SendResponse(resp Response) error {
+	this.responses = append(this.responses, resp)
+	return nil
+}
+
+func TestResponseWriter(t *testing.T) {
+	req, err := ReadRequest(bufio.NewReader(strings.NewReader(testOptions)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &recordingTransaction{serverTransaction: newServerTransaction(req)}
+
+	w := NewRequestEvent(st, req).GetResponseWriter()
+	w.Header().Set("Server", "sip")
+
+	if err := w.Provisional(OK); err != ErrStatusCode {
+		t.Errorf("Provisional(200) = %v", err)
+	}
+	if err := w.Provisional(TRYING); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Provisional(RINGING); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Respond(OK, NewSDPBody([]byte("v=0\r\n"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Challenge("atlanta.com"); err != ErrResponseWritten || !w.Written() {
+		t.Errorf("second final response: %v", err)
+	}
+
+	if len(st.responses) != 3 {
+		t.Fatalf("%d responses sent, want 3", len(st.responses))
+	}
+	trying, ringing, ok := st.responses[0], st.responses[1], st.responses[2]
+	if strings.Contains(trying.GetHeader().Get("To"), "tag=") {
+		t.Errorf("100 Trying has a To tag: %s", trying.GetHeader().Get("To"))
+	}
+	if tag := ringing.GetHeader().Get("To"); !strings.Contains(tag, ";tag=") || tag != ok.GetHeader().Get("To") {
+		t.Errorf("To of 180 %q and 200 %q differ", tag, ok.GetHeader().Get("To"))
+	}
+	if ok.GetHeader().Get("Server") != "sip" || ok.GetHeader().Get("Content-Type") != CONTENTTYPE_SDP || ok.GetContentLength() != 5 {
+		t.Errorf("200 header = %v", ok.GetHeader())
+	}
+
+	w = NewResponseWriter(st)
+	if err := w.Challenge("atlanta.com"); err != nil {
+		t.Fatal(err)
+	}
+	challenge := st.responses[3]
+	if challenge.GetStatusCode() != UNAUTHORIZED || !strings.HasPrefix(challenge.GetHeader().Get("WWW-Authenticate"), "Digest realm=\"atlanta.com\"") {
+		t.Errorf("challenge = %d %v", challenge.GetStatusCode(), challenge.GetHeader())
+	}
+}
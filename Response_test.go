@@ -0,0 +1,53 @@
+package sip
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+const (
+	testRinging = "SIP/2.0 180 Ringing\r\n" +
+		"Via: SIP/2.0/UDP 172.18.1.29:5060;branch=z9hG4bK43fc10fb4446d55fc5c8f969607991f4\r\n" +
+		"To: \"0440\" <sip:0440@212.209.220.131>;tag=2600\r\n" +
+		"From: \"Andreas\" <sip:andreas@e-horizon.se>;tag=8524\r\n" +
+		"Call-ID: f51a1851c5f570606140f14c8eb64fd3@172.18.1.29\r\n" +
+		"CSeq: 1 INVITE\r\n" +
+		"Content-Length: 0\r\n\r\n"
+
+	testOptions = "OPTIONS sip:carol@chicago.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bKhjhs8ass877\r\n" +
+		"To: <sip:carol@chicago.com>\r\n" +
+		"From: Alice <sip:alice@atlanta.com>;tag=1928301774\r\n" +
+		"Call-ID: a84b4c76e66710\r\n" +
+		"CSeq: 63104 OPTIONS\r\n" +
+		"Content-Length: 0\r\n\r\n"
+)
+
+func TestReadResponse(t *testing.T) {
+	resp, err := ReadResponse(bufio.NewReader(strings.NewReader(testRinging)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetStatusCode() != RINGING || resp.GetReasonPhrase() != "Ringing" {
+		t.Errorf("status line = %d %s", resp.GetStatusCode(), resp.GetReasonPhrase())
+	}
+
+	if _, err = ReadResponse(bufio.NewReader(strings.NewReader(testOptions))); err == nil {
+		t.Error("ReadResponse accepted a request")
+	}
+}
+
+func TestReadRequest(t *testing.T) {
+	req, err := ReadRequest(bufio.NewReader(strings.NewReader(testOptions)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.GetMethod() != OPTIONS || req.GetRequestURIString() != "sip:carol@chicago.com" {
+		t.Errorf("request line = %s %s", req.GetMethod(), req.GetRequestURIString())
+	}
+
+	if _, err = ReadRequest(bufio.NewReader(strings.NewReader(testRinging))); err == nil {
+		t.Error("ReadRequest accepted a response")
+	}
+}
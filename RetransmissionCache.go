@@ -0,0 +1,166 @@
+package sip
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A RetransmissionCache lets stateless components, such as a stateless
+// proxy or a registrar, recognize retransmitted requests and replay the
+// response they gave the first time without creating a transaction. The
+// requests are keyed by the branch of their top Via and their CSeq, and are
+// forgotten after a TTL or when the least recently seen one is evicted.
+type RetransmissionCache interface {
+	Check(req Request) (resp Response, retransmission bool)
+	Store(req Request, resp Response)
+	Remove(req Request)
+	Len() int
+
+	SetCapacity(capacity int)
+	SetTTL(ttl time.Duration)
+	SetClock(clock Clock)
+}
+
+// By default requests are remembered for 64*T1, like a server transaction.
+const (
+	RETRANSMISSIONCACHE_CAPACITY = 4096
+	RETRANSMISSIONCACHE_TTL      = 32 * time.Second
+)
+
+////////////////////Implementation////////////////////////
+
+type retransmissionCache struct {
+	mutex sync.Mutex
+
+	capacity int
+	ttl      time.Duration
+	clock    Clock
+
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type retransmissionEntry struct {
+	key      string
+	response Response
+	expires  time.Time
+}
+
+func NewRetransmissionCache() RetransmissionCache {
+	this := &retransmissionCache{}
+
+	this.capacity = RETRANSMISSIONCACHE_CAPACITY
+	this.ttl = RETRANSMISSIONCACHE_TTL
+	this.clock = RealClock
+
+	this.entries = make(map[string]*list.Element)
+	this.lru = list.New()
+
+	return this
+}
+
+// Check reports whether req was already seen, and returns the response
+// stored for it, which is nil while the first copy is still being
+// processed. A request seen for the first time is remembered.
+func (this *retransmissionCache) Check(req Request) (Response, bool) {
+	key := getRetransmissionKey(req)
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	now := this.clock.Now()
+	if e, ok := this.entries[key]; ok {
+		entry := e.Value.(*retransmissionEntry)
+		if now.Before(entry.expires) {
+			this.lru.MoveToFront(e)
+			return entry.response, true
+		}
+		this.remove(e)
+	}
+
+	this.entries[key] = this.lru.PushFront(&retransmissionEntry{key: key, expires: now.Add(this.ttl)})
+	this.evict()
+	return nil, false
+}
+
+// Store records resp as the answer to req, to be replayed to its
+// retransmissions.
+func (this *retransmissionCache) Store(req Request, resp Response) {
+	key := getRetransmissionKey(req)
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if e, ok := this.entries[key]; ok {
+		e.Value.(*retransmissionEntry).response = resp
+		this.lru.MoveToFront(e)
+		return
+	}
+	this.entries[key] = this.lru.PushFront(&retransmissionEntry{key: key, response: resp, expires: this.clock.Now().Add(this.ttl)})
+	this.evict()
+}
+
+func (this *retransmissionCache) Remove(req Request) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if e, ok := this.entries[getRetransmissionKey(req)]; ok {
+		this.remove(e)
+	}
+}
+
+func (this *retransmissionCache) Len() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.lru.Len()
+}
+
+func (this *retransmissionCache) SetCapacity(capacity int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.capacity = capacity
+	this.evict()
+}
+
+func (this *retransmissionCache) SetTTL(ttl time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.ttl = ttl
+}
+
+func (this *retransmissionCache) SetClock(clock Clock) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.clock = clock
+}
+
+func (this *retransmissionCache) remove(e *list.Element) {
+	delete(this.entries, e.Value.(*retransmissionEntry).key)
+	this.lru.Remove(e)
+}
+
+// evict drops the expired entries from the back of the list, then the least
+// recently seen ones over capacity.
+func (this *retransmissionCache) evict() {
+	now := this.clock.Now()
+	for e := this.lru.Back(); e != nil; e = this.lru.Back() {
+		if this.lru.Len() <= this.capacity && now.Before(e.Value.(*retransmissionEntry).expires) {
+			return
+		}
+		this.remove(e)
+	}
+}
+
+// getRetransmissionKey identifies a request by the branch of its top Via and
+// its whole CSeq.
+func getRetransmissionKey(req Request) string {
+	return getBranch(req) + " " + strings.Join(strings.Fields(req.GetHeader().Get("Cseq")), " ")
+}
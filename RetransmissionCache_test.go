@@ -0,0 +1,54 @@
+package sip
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetransmissionCache(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	c := NewRetransmissionCache()
+	c.SetClock(clock)
+	c.SetTTL(time.Second)
+	c.SetCapacity(2)
+
+	newRegister := func(branch, cseq string) Request {
+		req := NewRequest(REGISTER, "sip:example.com", nil)
+		req.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.1;branch="+branch)
+		req.GetHeader().Set("Cseq", cseq)
+		return req
+	}
+
+	first := newRegister("z9hG4bK1", "1 REGISTER")
+	if _, ok := c.Check(first); ok {
+		t.Fatal("first copy reported as a retransmission")
+	}
+	if resp, ok := c.Check(newRegister("z9hG4bK1", "1  REGISTER")); !ok || resp != nil {
+		t.Fatalf("retransmission before answer = %v, %v", resp, ok)
+	}
+
+	ok200 := CreateResponse(first, OK)
+	c.Store(first, ok200)
+	if resp, ok := c.Check(first); !ok || resp != ok200 {
+		t.Fatalf("retransmission after answer = %v, %v", resp, ok)
+	}
+	if _, ok := c.Check(newRegister("z9hG4bK1", "2 REGISTER")); ok {
+		t.Fatal("new CSeq reported as a retransmission")
+	}
+
+	//capacity evicts the least recently seen request
+	c.Check(first)
+	c.Check(newRegister("z9hG4bK3", "1 REGISTER"))
+	if c.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", c.Len())
+	}
+	if _, ok := c.Check(newRegister("z9hG4bK1", "2 REGISTER")); ok {
+		t.Fatal("evicted request reported as a retransmission")
+	}
+
+	clock.Advance(2 * time.Second)
+	if _, ok := c.Check(first); ok {
+		t.Fatal("expired request reported as a retransmission")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package sip
+
+import (
+	"syscall"
+)
+
+const soReusePort = syscall.SO_REUSEPORT
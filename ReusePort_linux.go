@@ -0,0 +1,7 @@
+//go:build linux && (386 || amd64 || arm || arm64 || ppc64 || ppc64le || riscv64 || s390x || loong64)
+
+package sip
+
+// The syscall package predates SO_REUSEPORT on Linux; its value is 15 on the
+// architectures above.
+const soReusePort = 0xf
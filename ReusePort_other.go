@@ -0,0 +1,11 @@
+//go:build !((linux && (386 || amd64 || arm || arm64 || ppc64 || ppc64le || riscv64 || s390x || loong64)) || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package sip
+
+import (
+	"syscall"
+)
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return ErrReusePortUnsupported
+}
@@ -0,0 +1,21 @@
+//go:build (linux && (386 || amd64 || arm || arm64 || ppc64 || ppc64le || riscv64 || s390x || loong64)) || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package sip
+
+import (
+	"syscall"
+)
+
+// reusePortControl sets SO_REUSEADDR and SO_REUSEPORT on a listening socket
+// before it is bound.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		if err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err == nil {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+		}
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
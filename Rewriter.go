@@ -0,0 +1,232 @@
+package sip
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sip/address"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A RewriteRule rewrites the requests it matches. It matches a request
+// when all of its conditions hold: the method is one of Methods, the
+// Request-URI matches URI, the user part of the Request-URI matches User,
+// and each header of Headers has a value matching its pattern. An empty
+// condition always holds. Patterns are regular expressions (regexp
+// package), unanchored unless written with ^ and $.
+//
+// The actions of a matching rule apply in the order of the fields: the user
+// part becomes UserReplacement, expanded with the submatches of User ($1);
+// Prefix is put in front of it; the host becomes Host; the headers of
+// StripHeaders are removed, and those of SetHeaders set.
+//
+// Rules are plain data, and can be loaded from JSON with
+// LoadRewriteRules, e.g. to normalize national numbers:
+//
+//	[{"name": "national", "methods": ["INVITE"], "user": "^0([1-9][0-9]+)$",
+//	  "user_replacement": "+49$1", "host": "gw.example.com"}]
+type RewriteRule struct {
+	Name string `json:"name,omitempty"`
+
+	Methods []string          `json:"methods,omitempty"`
+	URI     string            `json:"uri,omitempty"`
+	User    string            `json:"user,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+
+	UserReplacement string            `json:"user_replacement,omitempty"`
+	Prefix          string            `json:"prefix,omitempty"`
+	Host            string            `json:"host,omitempty"`
+	StripHeaders    []string          `json:"strip_headers,omitempty"`
+	SetHeaders      map[string]string `json:"set_headers,omitempty"`
+
+	// Final stops the rules which follow from being tried on a request
+	// this one matched.
+	Final bool `json:"final,omitempty"`
+}
+
+// A Rewriter applies rewrite rules to requests, in order. It is given the
+// incoming requests by the Provider once set, before the listeners see
+// them, and can be called directly on the requests a proxy or a B2BUA
+// forwards. Rewrite returns the names of the rules which matched.
+type Rewriter interface {
+	Rewrite(req Request) []string
+	GetRules() []RewriteRule
+}
+
+////////////////////Implementation////////////////////////
+
+type compiledRule struct {
+	RewriteRule
+
+	uri     *regexp.Regexp
+	user    *regexp.Regexp
+	headers map[string]*regexp.Regexp
+}
+
+type rewriter struct {
+	rules []*compiledRule
+}
+
+// NewRewriter compiles rules, failing on the first invalid pattern.
+func NewRewriter(rules []RewriteRule) (Rewriter, error) {
+	this := &rewriter{}
+
+	for i, rule := range rules {
+		c := &compiledRule{RewriteRule: rule}
+
+		var err error
+		if c.uri, err = compilePattern(rule.URI); err != nil {
+			return nil, fmt.Errorf("sip: rewrite rule %d: uri: %v", i, err)
+		}
+		if c.user, err = compilePattern(rule.User); err != nil {
+			return nil, fmt.Errorf("sip: rewrite rule %d: user: %v", i, err)
+		}
+		c.headers = make(map[string]*regexp.Regexp)
+		for name, pattern := range rule.Headers {
+			if c.headers[name], err = compilePattern(pattern); err != nil {
+				return nil, fmt.Errorf("sip: rewrite rule %d: header %s: %v", i, name, err)
+			}
+		}
+		this.rules = append(this.rules, c)
+	}
+
+	return this, nil
+}
+
+// LoadRewriteRules reads a JSON array of rules from r and compiles them.
+func LoadRewriteRules(r io.Reader) (Rewriter, error) {
+	var rules []RewriteRule
+	if err := json.NewDecoder(r).Decode(&rules); err != nil {
+		return nil, err
+	}
+	return NewRewriter(rules)
+}
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
+
+func (this *rewriter) GetRules() []RewriteRule {
+	rules := make([]RewriteRule, len(this.rules))
+	for i, c := range this.rules {
+		rules[i] = c.RewriteRule
+	}
+	return rules
+}
+
+func (this *rewriter) Rewrite(req Request) []string {
+	var matched []string
+	for _, c := range this.rules {
+		if !c.matches(req) {
+			continue
+		}
+		c.apply(req)
+		matched = append(matched, c.Name)
+		if c.Final {
+			break
+		}
+	}
+	return matched
+}
+
+func (this *compiledRule) matches(req Request) bool {
+	if len(this.Methods) > 0 {
+		found := false
+		for _, method := range this.Methods {
+			if method == req.GetMethod() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if this.uri != nil && !this.uri.MatchString(req.GetRequestURIString()) {
+		return false
+	}
+	if this.user != nil {
+		user, ok := getURIUser(req.GetRequestURI())
+		if !ok || !this.user.MatchString(user) {
+			return false
+		}
+	}
+	for name, pattern := range this.headers {
+		if !pattern.MatchString(req.GetHeader().Get(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (this *compiledRule) apply(req Request) {
+	if this.UserReplacement != "" || this.Prefix != "" || this.Host != "" {
+		uri := req.GetRequestURI()
+		if user, ok := getURIUser(uri); ok {
+			if this.UserReplacement != "" {
+				if this.user != nil {
+					user = this.user.ReplaceAllString(user, this.UserReplacement)
+				} else {
+					user = this.UserReplacement
+				}
+			}
+			setURIUser(uri, this.Prefix+user)
+		}
+		if sipuri, ok := uri.(*address.SipURIImpl); ok && this.Host != "" {
+			sipuri.SetHostString(this.Host)
+		}
+		if uri != nil {
+			req.SetRequestURI(uri)
+		}
+	}
+
+	for _, name := range this.StripHeaders {
+		req.GetHeader().Del(name)
+	}
+	for name, value := range this.SetHeaders {
+		req.GetHeader().Set(name, value)
+	}
+}
+
+// getURIUser returns the user part of a sip URI, or the number of a tel URI.
+func getURIUser(uri address.URI) (string, bool) {
+	switch u := uri.(type) {
+	case *address.SipURIImpl:
+		return u.GetUser(), true
+	case *address.TelURLImpl:
+		return u.GetPhoneNumber(), true
+	}
+	return "", false
+}
+
+func setURIUser(uri address.URI, user string) {
+	switch u := uri.(type) {
+	case *address.SipURIImpl:
+		u.SetUser(user)
+	case *address.TelURLImpl:
+		u.SetPhoneNumber(user)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (this *provider) GetRewriter() Rewriter {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.rewriter
+}
+
+// SetRewriter sets the rules applied to the incoming requests before they
+// are given to the listeners, or none if rewriter is nil.
+func (this *provider) SetRewriter(rewriter Rewriter) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.rewriter = rewriter
+}
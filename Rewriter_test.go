@@ -0,0 +1,82 @@
+package sip
+
+import (
+	"strings"
+	"testing"
+)
+
+const testRewriteRules = `[
+	{"name": "emergency", "user": "^(112|911)$", "host": "psap.example.com", "final": true},
+	{"name": "national", "methods": ["INVITE"], "user": "^0([1-9][0-9]+)$", "user_replacement": "+49$1"},
+	{"name": "trunk", "uri": "@trunk\\.example\\.com", "headers": {"P-Asserted-Identity": "sip:\\+49"},
+	 "prefix": "9", "strip_headers": ["P-Asserted-Identity"], "set_headers": {"Privacy": "id"}}
+]`
+
+func TestRewriter(t *testing.T) {
+	rewriter, err := LoadRewriteRules(strings.NewReader(testRewriteRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules := rewriter.GetRules(); len(rules) != 3 || rules[1].UserReplacement != "+49$1" {
+		t.Fatalf("rules = %+v", rules)
+	}
+
+	tvi := []struct {
+		method  string
+		uri     string
+		pai     string
+		matched string
+		newURI  string
+	}{
+		{INVITE, "sip:112@example.com", "", "emergency", "sip:112@psap.example.com"},
+		{INVITE, "sip:0301234567@example.com;user=phone", "", "national", "sip:+49301234567@example.com;user=phone"},
+		{MESSAGE, "sip:0301234567@example.com", "", "", "sip:0301234567@example.com"},
+		{INVITE, "tel:0301234567", "", "national", "tel:+49301234567"},
+		{OPTIONS, "sip:555@trunk.example.com", "<sip:+4930@example.com>", "trunk", "sip:9555@trunk.example.com"},
+		{OPTIONS, "sip:555@trunk.example.com", "<sip:alice@example.com>", "", "sip:555@trunk.example.com"},
+	}
+	for i, tv := range tvi {
+		req := NewRequest(tv.method, tv.uri, nil)
+		if tv.pai != "" {
+			req.GetHeader().Set("P-Asserted-Identity", tv.pai)
+		}
+		matched := strings.Join(rewriter.Rewrite(req), ",")
+		if matched != tv.matched || req.GetRequestURIString() != tv.newURI {
+			t.Errorf("%d: rules %q, Request-URI %s, want %q, %s", i, matched, req.GetRequestURIString(), tv.matched, tv.newURI)
+		}
+		if tv.matched == "trunk" && (req.GetHeader().Get("P-Asserted-Identity") != "" || req.GetHeader().Get("Privacy") != "id") {
+			t.Errorf("%d: headers = %v", i, req.GetHeader())
+		}
+	}
+
+	if _, err = NewRewriter([]RewriteRule{{User: "("}}); err == nil {
+		t.Error("invalid pattern compiled")
+	}
+}
+
+func TestProviderRewriter(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+
+	rewriter, err := NewRewriter([]RewriteRule{{Prefix: "00"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetRewriter(rewriter)
+
+	req := newServerTestRequest(OPTIONS, "UDP", "z9hG4bKrw")
+	p.processMessage(req)
+	if len(l.requests) != 1 || l.requests[0].GetRequest().GetRequestURIString() != "sip:00bob@example.com" {
+		t.Fatalf("request not rewritten")
+	}
+
+	//the retransmission still matches the transaction
+	p.processMessage(newServerTestRequest(OPTIONS, "UDP", "z9hG4bKrw"))
+	if len(l.requests) != 1 {
+		t.Error("retransmission delivered again")
+	}
+}
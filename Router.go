@@ -0,0 +1,209 @@
+package sip
+
+import (
+	"errors"
+	"net"
+	"sip/address"
+	"sip/header"
+	"sip/parser"
+	"strconv"
+	"strings"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A Hop is the transport destination of a request, see address.Hop.
+// The TTL is only meaningful for multicast destinations selected by maddr
+// and is -1 otherwise.
+type Hop interface {
+	address.Hop
+
+	GetTTL() int
+}
+
+////////////////////Implementation////////////////////////
+
+type hop struct {
+	host      string
+	port      int
+	transport string
+	ttl       int
+
+	//taken from the URI, for the server location of RFC 3263
+	secure           bool
+	defaultPort      bool
+	defaultTransport bool
+}
+
+func NewHop(host string, port int, transport string) Hop {
+	return &hop{host: strings.Trim(host, "[]"), port: port, transport: transport, ttl: -1}
+}
+
+func (this *hop) GetHost() string {
+	return this.host
+}
+
+func (this *hop) GetPort() int {
+	return this.port
+}
+
+func (this *hop) GetTransport() string {
+	return this.transport
+}
+
+func (this *hop) GetTTL() int {
+	return this.ttl
+}
+
+func (this *hop) String() string {
+	return net.JoinHostPort(this.host, strconv.Itoa(this.port)) + "/" + this.transport
+}
+
+// GetNextHop selects the destination of an outbound request, fixing up the
+// request first when the next hop is a strict router (RFC 3261 12.2.1.1
+// and 16.6 step 6): the Request-URI is appended as the last Route, and the
+// first Route is removed and becomes the Request-URI.
+//
+// The destination is the first Route for a loose router, otherwise the
+// Request-URI; maddr, port, transport and ttl of that URI are honored
+// (RFC 3261 8.1.2 and 18.1.1).
+func GetNextHop(req Request) (Hop, error) {
+	routes, err := getRoutes(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var target address.URI
+	if len(routes) == 0 {
+		if target, err = ParseURI(req.GetRequestURIString()); err != nil {
+			return nil, err
+		}
+	} else {
+		target = routes[0].GetAddress().GetURI()
+		if uri, ok := target.(*address.SipURIImpl); ok && !uri.HasLrParam() {
+			routes = append(routes[1:], newRoute(req.GetRequestURIString()))
+			req.SetRequestURI(uri)
+			setRoutes(req, routes)
+		}
+	}
+
+	uri, ok := target.(*address.SipURIImpl)
+	if !ok {
+		return nil, errors.New("sip: next hop is not a SIP URI: " + target.String())
+	}
+	return getHop(uri), nil
+}
+
+func getHop(uri *address.SipURIImpl) Hop {
+	this := &hop{ttl: -1}
+
+	this.host = uri.GetHost()
+	if maddr := uri.GetMAddrParam(); maddr != "" {
+		this.host = maddr
+		this.ttl = uri.GetTTLParam()
+	}
+	this.host = strings.Trim(this.host, "[]")
+
+	this.secure = uri.IsSecure()
+	this.transport = strings.ToLower(uri.GetTransportParam())
+	if this.transport == "" {
+		this.defaultTransport = true
+		if uri.IsSecure() {
+			this.transport = TLS
+		} else {
+			this.transport = UDP
+		}
+	} else if this.transport == WS && uri.IsSecure() {
+		//a sips URI reached over WebSocket is reached over TLS (RFC 7118 5.2)
+		this.transport = WSS
+	} else if this.transport == TCP && uri.IsSecure() {
+		//and over TCP, over TLS (RFC 3261 26.2.2)
+		this.transport = TLS
+	}
+
+	this.port = uri.GetPort()
+	if this.port <= 0 {
+		this.defaultPort = true
+		this.port = getDefaultPort(this.transport, uri.IsSecure())
+	}
+
+	return this
+}
+
+// getDefaultPort returns the port of transport when the URI or the Via has
+// none: 5060, or 5061 over TLS, and the HTTP ports for WebSocket.
+func getDefaultPort(transport string, secure bool) int {
+	switch {
+	case transport == WS:
+		return 80
+	case transport == WSS:
+		return 443
+	case secure || transport == TLS:
+		return 5061
+	}
+	return 5060
+}
+
+// getRoutes parses all Route header values of req, in order.
+func getRoutes(req Request) ([]*header.Route, error) {
+	var routes []*header.Route
+	for _, v := range req.GetHeader()["Route"] {
+		sh, err := parser.NewRouteParser("Route: " + v + "\n").Parse()
+		if err != nil {
+			return nil, err
+		}
+		list := sh.(*header.RouteList)
+		for e := list.Front(); e != nil; e = e.Next() {
+			routes = append(routes, e.Value.(*header.Route))
+		}
+	}
+	return routes, nil
+}
+
+func setRoutes(req Request, routes []*header.Route) {
+	values := make([]string, len(routes))
+	for i, route := range routes {
+		values[i] = route.EncodeBody()
+	}
+	if len(values) > 0 {
+		req.GetHeader()["Route"] = values
+	} else {
+		req.GetHeader().Del("Route")
+	}
+}
+
+// preprocessRoute removes the element for which isLocal holds from the
+// route of req (RFC 3261 16.4): a Request-URI it put in a Record-Route,
+// left there by a strict router, is replaced by the last Route, and a
+// first Route naming it is removed.
+func preprocessRoute(req Request, isLocal func(uri address.URI) bool) error {
+	routes, err := getRoutes(req)
+	if err != nil {
+		return err
+	}
+	n := len(routes)
+	if n > 0 {
+		if uri, err := ParseURI(req.GetRequestURIString()); err == nil && isLocal(uri) {
+			last := routes[len(routes)-1]
+			req.SetRequestURIString(last.GetAddress().GetURI().String())
+			routes = routes[:len(routes)-1]
+		}
+	}
+	if len(routes) > 0 && isLocal(routes[0].GetAddress().GetURI()) {
+		routes = routes[1:]
+	}
+	if len(routes) != n {
+		setRoutes(req, routes)
+	}
+	return nil
+}
+
+func newRoute(uri string) *header.Route {
+	route := header.NewRoute()
+	if sh, err := parser.NewRouteParser("Route: <" + uri + ">\n").Parse(); err == nil {
+		if list := sh.(*header.RouteList); list.Len() > 0 {
+			route = list.Front().Value.(*header.Route)
+		}
+	}
+	return route
+}
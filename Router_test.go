@@ -0,0 +1,54 @@
+package sip
+
+import (
+	"testing"
+)
+
+func TestGetNextHop(t *testing.T) {
+	var tvi = []struct {
+		requestURI string
+		route      string
+		hop        string
+		ttl        int
+		newURI     string
+		newRoutes  []string
+	}{
+		{"sip:bob@biloxi.com", "", "biloxi.com:5060/udp", -1, "sip:bob@biloxi.com", nil},
+		{"sips:bob@biloxi.com", "", "biloxi.com:5061/tls", -1, "sips:bob@biloxi.com", nil},
+		{"sips:bob@biloxi.com;transport=tcp", "", "biloxi.com:5061/tls", -1, "sips:bob@biloxi.com;transport=tcp", nil},
+		{"sip:bob@biloxi.com;maddr=239.255.255.1;ttl=16", "", "239.255.255.1:5060/udp", 16, "sip:bob@biloxi.com;maddr=239.255.255.1;ttl=16", nil},
+		{"sip:bob@biloxi.com", "<sip:p1.example.com:5070;lr>", "p1.example.com:5070/udp", -1, "sip:bob@biloxi.com",
+			[]string{"<sip:p1.example.com:5070;lr>"}},
+		{"sip:bob@biloxi.com", "<sip:p1.example.com;transport=tcp>, <sip:p2.example.com;lr>", "p1.example.com:5060/tcp", -1, "sip:p1.example.com;transport=tcp",
+			[]string{"<sip:p2.example.com;lr>", "<sip:bob@biloxi.com>"}},
+	}
+
+	for i := 0; i < len(tvi); i++ {
+		req := NewRequest(INVITE, tvi[i].requestURI, nil)
+		if tvi[i].route != "" {
+			req.GetHeader().Add("Route", tvi[i].route)
+		}
+
+		hop, err := GetNextHop(req)
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if hop.String() != tvi[i].hop || hop.GetTTL() != tvi[i].ttl {
+			t.Errorf("%d: hop = %s ttl %d, want %s ttl %d", i, hop, hop.GetTTL(), tvi[i].hop, tvi[i].ttl)
+		}
+		if req.GetRequestURIString() != tvi[i].newURI {
+			t.Errorf("%d: Request-URI = %s, want %s", i, req.GetRequestURIString(), tvi[i].newURI)
+		}
+		routes := req.GetHeader()["Route"]
+		if len(routes) != len(tvi[i].newRoutes) {
+			t.Errorf("%d: Route = %v, want %v", i, routes, tvi[i].newRoutes)
+			continue
+		}
+		for j := range routes {
+			if routes[j] != tvi[i].newRoutes[j] {
+				t.Errorf("%d: Route = %v, want %v", i, routes, tvi[i].newRoutes)
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package sip
+
+import (
+	"net"
+	"strings"
+)
+
+// The provider stamps the top Via of each request it receives with where
+// it came from (RFC 3261 18.2.1): a received parameter with the source IP
+// address when the sent-by host differs from it, and, when the Via asks for
+// it with an empty rport parameter, the source port in rport, together
+// with received in any case (RFC 3581 4). The responses then go back to
+// that address and port, see getResponseHop, through the NATs a client
+// sits behind. Once SetRport is on, the provider asks the same of the next
+// hops of the requests it sends, adding an empty rport to their top Via.
+
+////////////////////Implementation////////////////////////
+
+func (this *provider) GetRport() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.rport
+}
+
+// SetRport sets whether the requests sent have an rport parameter added to
+// their top Via, off by default.
+func (this *provider) SetRport(rport bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.rport = rport
+}
+
+// stampRport adds an empty rport parameter to the top Via of req, sent by
+// the provider, if it is to and there is none.
+func (this *provider) stampRport(req Request) {
+	if !this.GetRport() {
+		return
+	}
+	if _, ok := getViaParam(getTopVia(req), "rport"); !ok {
+		setTopVia(req, setViaParam(getTopVia(req), "rport", ""))
+	}
+}
+
+// stampReceived sets the received and rport parameters of the top Via of
+// req to the address it was received from.
+func stampReceived(req Request) {
+	info := req.GetMessageInfo()
+	if info == nil || info.RemoteAddr == nil {
+		return
+	}
+	ip, port, err := net.SplitHostPort(info.RemoteAddr.String())
+	if err != nil {
+		return
+	}
+	//an IPv6 address is written without brackets, nor zone
+	if i := strings.Index(ip, "%"); i >= 0 {
+		ip = ip[:i]
+	}
+	via := getTopVia(req)
+	if via == "" {
+		return
+	}
+
+	host := getSentBy(req)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	sentBy := net.ParseIP(strings.Trim(host, "[]"))
+	_, rport := getViaParam(via, "rport")
+	if rport || sentBy == nil || !sentBy.Equal(net.ParseIP(ip)) {
+		via = setViaParam(via, "received", ip)
+	}
+	if rport {
+		via = setViaParam(via, "rport", port)
+	}
+	setTopVia(req, via)
+}
+
+// getViaParam returns the value of the parameter name of via, a single
+// Via value, and whether it has one.
+func getViaParam(via, name string) (string, bool) {
+	for _, param := range strings.Split(via, ";")[1:] {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if strings.EqualFold(kv[0], name) {
+			if len(kv) == 2 {
+				return kv[1], true
+			}
+			return "", true
+		}
+	}
+	return "", false
+}
+
+// setViaParam sets the parameter name of via, a single Via value, to
+// value, or leaves it without one if value is empty.
+func setViaParam(via, name, value string) string {
+	param := name
+	if value != "" {
+		param += "=" + value
+	}
+	params := strings.Split(via, ";")
+	for i, p := range params[1:] {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if strings.EqualFold(kv[0], name) {
+			params[i+1] = param
+			return strings.Join(params, ";")
+		}
+	}
+	return via + ";" + param
+}
+
+// setTopVia replaces the top Via of msg with via, keeping the others as
+// they are.
+func setTopVia(msg Message, via string) {
+	vias := msg.GetHeader()["Via"]
+	if len(vias) == 0 {
+		return
+	}
+	rest := ""
+	if i := strings.Index(vias[0], ","); i >= 0 {
+		rest = vias[0][i:]
+	}
+	vias[0] = via + rest
+}
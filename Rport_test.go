@@ -0,0 +1,72 @@
+package sip
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStampReceived(t *testing.T) {
+	var tvi = []struct {
+		via    string
+		source string
+		want   string
+	}{
+		{"SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK1", "192.0.2.1:5060", "SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK1"},
+		{"SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK1", "198.51.100.7:1024", "SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK1;received=198.51.100.7"},
+		{"SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK1", "192.0.2.1:5060", "SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK1;received=192.0.2.1"},
+		{"SIP/2.0/UDP 192.0.2.1:5060;rport;branch=z9hG4bK1", "192.0.2.1:5060", "SIP/2.0/UDP 192.0.2.1:5060;rport=5060;branch=z9hG4bK1;received=192.0.2.1"},
+		{"SIP/2.0/UDP 192.0.2.1;rport;branch=z9hG4bK1, SIP/2.0/UDP 192.0.2.9;branch=z9hG4bK2", "198.51.100.7:1024",
+			"SIP/2.0/UDP 192.0.2.1;rport=1024;branch=z9hG4bK1;received=198.51.100.7, SIP/2.0/UDP 192.0.2.9;branch=z9hG4bK2"},
+		{"SIP/2.0/UDP [2001:db8::1]:5060;branch=z9hG4bK1", "[2001:db8::1]:5060", "SIP/2.0/UDP [2001:db8::1]:5060;branch=z9hG4bK1"},
+		{"SIP/2.0/UDP [2001:DB8::1];branch=z9hG4bK1", "[2001:db8::1]:5060", "SIP/2.0/UDP [2001:DB8::1];branch=z9hG4bK1"},
+		{"SIP/2.0/UDP [2001:db8::1];rport;branch=z9hG4bK1", "[fe80::1%lo]:1024", "SIP/2.0/UDP [2001:db8::1];rport=1024;branch=z9hG4bK1;received=fe80::1"},
+	}
+
+	for i, tv := range tvi {
+		req := NewRequest(OPTIONS, "sip:bob@example.com", nil)
+		req.GetHeader().Set("Via", tv.via)
+		source, _ := net.ResolveUDPAddr("udp", tv.source)
+		req.SetMessageInfo(&MessageInfo{Network: UDP, RemoteAddr: source})
+		stampReceived(req)
+		if via := req.GetHeader().Get("Via"); via != tv.want {
+			t.Errorf("%d: Via = %s, want %s", i, via, tv.want)
+		}
+	}
+}
+
+func TestRport(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	ls := &serverListener{}
+	p.AddListener(ls)
+
+	//the response goes back to the address the request came from
+	req := newServerTestRequest(OPTIONS, "UDP", "z9hG4bKrport1;rport")
+	req.SetMessageInfo(&MessageInfo{Network: UDP, RemoteAddr: &net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 1024}})
+	p.processMessage(req)
+	if err := ls.transactions[0].SendResponse(CreateResponse(req, OK)); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, 1)
+	if h := sent.hops[0].String(); h != "198.51.100.7:1024/udp" {
+		t.Errorf("response sent to %s", h)
+	}
+
+	//and a request sent asks for the same
+	p.SetRport(true)
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.3", 5060, UDP)}, nil
+	}))
+	out := NewRequest(OPTIONS, "sip:carol@192.0.2.3", nil)
+	out.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.10;branch=z9hG4bKrport2")
+	if err := p.SendRequest(out); err != nil {
+		t.Fatal(err)
+	}
+	if via := out.GetHeader().Get("Via"); via != "SIP/2.0/UDP 192.0.2.10;branch=z9hG4bKrport2;rport" {
+		t.Errorf("Via %s", via)
+	}
+}
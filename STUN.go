@@ -0,0 +1,273 @@
+package sip
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// The flows over UDP carry the STUN keep-alives of RFC 5626 4.4.2: a STUN
+// Binding request (RFC 5389) sent to the edge proxy from the SIP port,
+// whose success response holds the address the proxy saw it from, in its
+// XOR-MAPPED-ADDRESS. A provider answers the Binding requests it receives
+// on its UDP sockets, as an edge proxy supporting outbound must (RFC 5626
+// 8), and takes the responses to those it sent; the STUN messages are told
+// from the SIP ones by their first two bits, always 0, and the magic cookie
+// which follows (RFC 5389 6). A Binding request is sent again after 500 ms,
+// then after twice as long each time, and fails 8 s after its seventh
+// transmission if it got no response (RFC 5389 7.2.1).
+
+const (
+	// STUN_KEEPALIVE_INTERVAL is the interval of the keep-alives of a flow
+	// over UDP whose registrar gave no Flow-Timer.
+	STUN_KEEPALIVE_INTERVAL = 30 * time.Second
+
+	// STUN_RTO is the initial retransmission timeout of a Binding request.
+	STUN_RTO = 500 * time.Millisecond
+
+	// STUN_REQUEST_COUNT is the times a Binding request is sent (Rc).
+	STUN_REQUEST_COUNT = 7
+
+	// STUN_LAST_WAIT is how long the last one waits for a response (Rm
+	// times the initial timeout).
+	STUN_LAST_WAIT = 16 * STUN_RTO
+)
+
+const (
+	stunMagicCookie     = 0x2112A442
+	stunHeaderSize      = 20
+	stunBindingRequest  = 0x0001
+	stunBindingSuccess  = 0x0101
+	stunBindingError    = 0x0111
+	stunXorMappedAddr   = 0x0020
+	stunFamilyIPv4      = 0x01
+	stunFamilyIPv6      = 0x02
+	stunTransactionSize = 12
+)
+
+var (
+	ErrSTUNTimeout      = errors.New("sip: STUN Binding request timed out")
+	ErrBadSTUNResponse  = errors.New("sip: invalid STUN Binding response")
+	ErrSTUNNotSupported = errors.New("sip: provider cannot send STUN Binding requests")
+)
+
+////////////////////Implementation////////////////////////
+
+// stunTransaction is a Binding request sent, waiting for its response.
+type stunTransaction struct {
+	id      string
+	address net.Addr
+	request []byte
+	sent    int   //the times the request was sent
+	timer   Timer //the next retransmission, or the timeout
+	done    func(mapped string, err error)
+}
+
+// sendBindingRequest sends a STUN Binding request to address, a host and
+// port, from the UDP socket of the provider. done is called with the
+// address of its XOR-MAPPED-ADDRESS once it is answered, or with an error
+// if it fails or times out.
+func (this *provider) sendBindingRequest(address string, done func(mapped string, err error)) error {
+	t := this.getTransport(UDP)
+	if t == nil {
+		return ErrNoTransport
+	}
+	if t.pconn == nil {
+		return ErrNotListening
+	}
+	raddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return err
+	}
+
+	id := make([]byte, stunTransactionSize)
+	if _, err := rand.Read(id); err != nil {
+		panic("sip: no randomness: " + err.Error())
+	}
+	tx := &stunTransaction{
+		id:      string(id),
+		address: raddr,
+		request: newSTUNMessage(stunBindingRequest, id, nil),
+		done:    done,
+	}
+
+	this.mutex.Lock()
+	this.stunTransactions[tx.id] = tx
+	this.mutex.Unlock()
+
+	this.retransmitBindingRequest(t.pconn, tx)
+	return nil
+}
+
+// retransmitBindingRequest sends the request of tx, unless it was answered
+// meanwhile, and arms the timer of its next transmission or, after the
+// last one, of its timeout.
+func (this *provider) retransmitBindingRequest(conn *datagramConn, tx *stunTransaction) {
+	this.mutex.Lock()
+	if this.stunTransactions[tx.id] != tx {
+		this.mutex.Unlock()
+		return
+	}
+	tx.sent++
+	if tx.sent < STUN_REQUEST_COUNT {
+		tx.timer = this.GetClock().AfterFunc(STUN_RTO<<uint(tx.sent-1), func() {
+			this.retransmitBindingRequest(conn, tx)
+		})
+	} else {
+		tx.timer = this.GetClock().AfterFunc(STUN_LAST_WAIT, func() {
+			this.endBindingRequest(tx, "", ErrSTUNTimeout)
+		})
+	}
+	this.mutex.Unlock()
+
+	if _, err := conn.WriteTo(tx.request, tx.address); err != nil {
+		this.getLogger(COMPONENT_TRANSPORT).Log(LOG_ERROR, "Sending keep-alive failed", "address", tx.address, "error", err)
+	}
+}
+
+// endBindingRequest ends tx, unless it was already, and tells its caller.
+func (this *provider) endBindingRequest(tx *stunTransaction, mapped string, err error) {
+	this.mutex.Lock()
+	if this.stunTransactions[tx.id] != tx {
+		this.mutex.Unlock()
+		return
+	}
+	delete(this.stunTransactions, tx.id)
+	stopTimer(tx.timer)
+	this.mutex.Unlock()
+
+	tx.done(mapped, err)
+}
+
+// processSTUN takes a STUN message received on conn from source: it answers
+// a Binding request with the address of source, and ends the transaction
+// of a Binding response.
+func (this *provider) processSTUN(conn *datagramConn, data []byte, source net.Addr) {
+	msgType := binary.BigEndian.Uint16(data[0:2])
+	id := data[8:stunHeaderSize]
+
+	switch msgType {
+	case stunBindingRequest:
+		udp, ok := source.(*net.UDPAddr)
+		if !ok {
+			return
+		}
+		resp := newSTUNMessage(stunBindingSuccess, id, encodeXorMappedAddress(udp, id))
+		if _, err := conn.WriteTo(resp, source); err != nil {
+			this.getLogger(COMPONENT_TRANSPORT).Log(LOG_ERROR, "Answering keep-alive failed", "remote", source, "error", err)
+		}
+
+	case stunBindingSuccess, stunBindingError:
+		this.mutex.Lock()
+		tx := this.stunTransactions[string(id)]
+		this.mutex.Unlock()
+		if tx == nil {
+			return
+		}
+		if msgType == stunBindingError {
+			this.endBindingRequest(tx, "", ErrBadSTUNResponse)
+			return
+		}
+		mapped := decodeXorMappedAddress(data[stunHeaderSize:], id)
+		if mapped == nil {
+			this.endBindingRequest(tx, "", ErrBadSTUNResponse)
+			return
+		}
+		this.endBindingRequest(tx, mapped.String(), nil)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// isSTUNMessage reports whether data, a datagram received, is a STUN
+// message rather than a SIP one.
+func isSTUNMessage(data []byte) bool {
+	if len(data) < stunHeaderSize || data[0]&0xC0 != 0 {
+		return false
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	return binary.BigEndian.Uint32(data[4:8]) == stunMagicCookie && length%4 == 0 && stunHeaderSize+length == len(data)
+}
+
+// newSTUNMessage returns a STUN message of msgType for the transaction id,
+// holding the attributes attrs, already encoded.
+func newSTUNMessage(msgType uint16, id []byte, attrs []byte) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, msgType)
+	binary.Write(&b, binary.BigEndian, uint16(len(attrs)))
+	binary.Write(&b, binary.BigEndian, uint32(stunMagicCookie))
+	b.Write(id)
+	b.Write(attrs)
+	return b.Bytes()
+}
+
+// stunXorKey returns what the address of an XOR-MAPPED-ADDRESS is xored
+// with: the magic cookie, and the transaction id for an IPv6 address.
+func stunXorKey(id []byte) []byte {
+	key := make([]byte, 4, 16)
+	binary.BigEndian.PutUint32(key, stunMagicCookie)
+	return append(key, id...)
+}
+
+// encodeXorMappedAddress returns the XOR-MAPPED-ADDRESS attribute holding
+// addr, for the transaction id.
+func encodeXorMappedAddress(addr *net.UDPAddr, id []byte) []byte {
+	family, ip := byte(stunFamilyIPv4), addr.IP.To4()
+	if ip == nil {
+		family, ip = stunFamilyIPv6, addr.IP.To16()
+	}
+	key := stunXorKey(id)
+
+	value := make([]byte, 4+len(ip))
+	value[1] = family
+	binary.BigEndian.PutUint16(value[2:4], uint16(addr.Port)^uint16(stunMagicCookie>>16))
+	for i := range ip {
+		value[4+i] = ip[i] ^ key[i]
+	}
+
+	attr := make([]byte, 4, 4+len(value))
+	binary.BigEndian.PutUint16(attr[0:2], stunXorMappedAddr)
+	binary.BigEndian.PutUint16(attr[2:4], uint16(len(value)))
+	return append(attr, value...)
+}
+
+// decodeXorMappedAddress returns the address of the XOR-MAPPED-ADDRESS of
+// attrs, the attributes of a message of the transaction id, or nil if it
+// has none.
+func decodeXorMappedAddress(attrs []byte, id []byte) *net.UDPAddr {
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		length := int(binary.BigEndian.Uint16(attrs[2:4]))
+		padded := (length + 3) &^ 3
+		if len(attrs) < 4+padded {
+			return nil
+		}
+		value := attrs[4 : 4+length]
+		attrs = attrs[4+padded:]
+		if attrType != stunXorMappedAddr || length < 4 {
+			continue
+		}
+
+		size := 0
+		switch value[1] {
+		case stunFamilyIPv4:
+			size = net.IPv4len
+		case stunFamilyIPv6:
+			size = net.IPv6len
+		}
+		if size == 0 || length != 4+size {
+			return nil
+		}
+		key := stunXorKey(id)
+		ip := make(net.IP, size)
+		for i := range ip {
+			ip[i] = value[4+i] ^ key[i]
+		}
+		port := binary.BigEndian.Uint16(value[2:4]) ^ uint16(stunMagicCookie>>16)
+		return &net.UDPAddr{IP: ip, Port: int(port)}
+	}
+	return nil
+}
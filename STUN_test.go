@@ -0,0 +1,122 @@
+package sip
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// readSTUN reads the next STUN message peer receives, or fails t.
+func readSTUN(t *testing.T, peer net.PacketConn) ([]byte, net.Addr) {
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, DATAGRAM_SIZE)
+	n, from, err := peer.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isSTUNMessage(buffer[:n]) {
+		t.Fatalf("received %q", buffer[:n])
+	}
+	return buffer[:n], from
+}
+
+func TestSTUNAddress(t *testing.T) {
+	id := []byte("0123456789ab")
+	for _, addr := range []*net.UDPAddr{
+		{IP: net.ParseIP("192.0.2.1"), Port: 5060},
+		{IP: net.ParseIP("2001:db8::1"), Port: 40000},
+	} {
+		msg := newSTUNMessage(stunBindingSuccess, id, encodeXorMappedAddress(addr, id))
+		if !isSTUNMessage(msg) {
+			t.Fatalf("%x is not a STUN message", msg)
+		}
+		if mapped := decodeXorMappedAddress(msg[stunHeaderSize:], id); mapped == nil || mapped.String() != addr.String() {
+			t.Errorf("%v decoded as %v", addr, mapped)
+		}
+	}
+	if isSTUNMessage([]byte(testOptions)) {
+		t.Error("OPTIONS taken for a STUN message")
+	}
+}
+
+func TestSTUNBinding(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	tr := newTransport(UDP, "127.0.0.1", 0, nil)
+	p.AddTransport(tr)
+	if err := tr.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tr.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.spawn(resourceServe, func() { p.ServeConn(tr, conn) })
+	defer func() {
+		close(p.quit)
+		conn.Close()
+		p.waitGroup.Wait()
+	}()
+
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	//a Binding request received is answered with the address it came from
+	id := []byte("0123456789ab")
+	if _, err := peer.WriteTo(newSTUNMessage(stunBindingRequest, id, nil), conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	resp, _ := readSTUN(t, peer)
+	if mapped := decodeXorMappedAddress(resp[stunHeaderSize:], id); mapped == nil || mapped.String() != peer.LocalAddr().String() {
+		t.Errorf("Binding request answered with %v", mapped)
+	}
+
+	//one sent gets the address the peer saw it from
+	results := make(chan string, 1)
+	done := func(mapped string, err error) {
+		if err != nil {
+			results <- err.Error()
+			return
+		}
+		results <- mapped
+	}
+	if err := p.sendBindingRequest(peer.LocalAddr().String(), done); err != nil {
+		t.Fatal(err)
+	}
+	req, from := readSTUN(t, peer)
+	udp := from.(*net.UDPAddr)
+	peer.WriteTo(newSTUNMessage(stunBindingSuccess, req[8:stunHeaderSize], encodeXorMappedAddress(udp, req[8:stunHeaderSize])), from)
+	select {
+	case mapped := <-results:
+		if mapped != conn.LocalAddr().String() {
+			t.Errorf("Binding request answered with %v", mapped)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no response")
+	}
+
+	//and one never answered is sent again, then times out
+	if err := p.sendBindingRequest(peer.LocalAddr().String(), done); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := readSTUN(t, peer)
+	wait := STUN_RTO
+	for i := 1; i < STUN_REQUEST_COUNT; i++ {
+		clock.Advance(wait)
+		if again, _ := readSTUN(t, peer); string(again) != string(first) {
+			t.Fatalf("transmission %d %x, first %x", i+1, again, first)
+		}
+		wait *= 2
+	}
+	clock.Advance(STUN_LAST_WAIT - time.Millisecond)
+	if len(results) != 0 {
+		t.Fatal("Binding request timed out early")
+	}
+	clock.Advance(time.Millisecond)
+	if result := <-results; result != ErrSTUNTimeout.Error() {
+		t.Errorf("unanswered Binding request ended with %v", result)
+	}
+}
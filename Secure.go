@@ -0,0 +1,117 @@
+package sip
+
+import (
+	"errors"
+	"sip/address"
+	"strings"
+)
+
+// A request to a SIPS URI, in its Request-URI or its top Route, must reach
+// it over TLS on every hop (RFC 3261 26.2.2): the provider only sends it
+// over TLS or WSS, failing with ErrSecureTransport when it has no such
+// transport or the next hop resolved to none, and a SIPS URI naming TCP as
+// its transport means TLS. The Contact the provider generates for a secure
+// dialog, in its in-dialog requests and in the response setting it up, is
+// a SIPS URI as well (RFC 3261 8.1.1.8 and 12.1.1), and so is the
+// Record-Route a proxy adds to a request to a SIPS URI.
+
+var ErrSecureTransport = errors.New("sip: no TLS transport for a sips URI")
+
+////////////////////Implementation////////////////////////
+
+// isSipsURI reports whether uri is a SIPS URI.
+func isSipsURI(uri string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(uri)), "sips:")
+}
+
+// isSecureRequest reports whether req targets a SIPS URI, in its
+// Request-URI or its top Route.
+func isSecureRequest(req Request) bool {
+	if isSipsURI(req.GetRequestURIString()) {
+		return true
+	}
+	if routes := req.GetHeader().Values("Route"); len(routes) > 0 {
+		return isSipsURI(getAddressURI(routes[0]))
+	}
+	return false
+}
+
+// needsSecureContact reports whether the response of a UAS to req, which
+// sets up a dialog, must carry a SIPS Contact: the Request-URI, the top
+// Record-Route, or without one the Contact of req is a SIPS URI (RFC 3261
+// 12.1.1).
+func needsSecureContact(req Request) bool {
+	if isSipsURI(req.GetRequestURIString()) {
+		return true
+	}
+	h := req.GetHeader()
+	if rr := h.Values("Record-Route"); len(rr) > 0 {
+		return isSipsURI(getAddressURI(rr[0]))
+	}
+	return isSipsURI(getAddressURI(h.Get("Contact")))
+}
+
+// isSecureTransport reports whether network is a TLS transport.
+func isSecureTransport(network string) bool {
+	return strings.EqualFold(network, TLS) || strings.EqualFold(network, WSS)
+}
+
+// setSecureContact turns the SIP URIs of the Contact of msg into SIPS ones.
+func setSecureContact(msg Message) {
+	h := msg.GetHeader()
+	values := h.Values("Contact")
+	for i, v := range values {
+		values[i] = getSecureContact(v)
+	}
+	h.ReplaceAll("Contact", values)
+}
+
+// getSecureContact returns contact, a Contact value, with a SIPS URI.
+func getSecureContact(contact string) string {
+	if isSipsURI(getAddressURI(contact)) {
+		return contact
+	}
+	c := parseContact(contact)
+	if c == nil {
+		return contact
+	}
+	uri, ok := c.GetAddress().GetURI().(*address.SipURIImpl)
+	if !ok {
+		return contact
+	}
+	uri.SetSecure(true)
+	return c.EncodeBody()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// checkSecure returns ErrSecureTransport if req is to go over TLS and the
+// provider has no TLS transport.
+func (this *provider) checkSecure(req Request) error {
+	if isSecureRequest(req) && this.getTransport(TLS) == nil && this.getTransport(WSS) == nil {
+		return ErrSecureTransport
+	}
+	return nil
+}
+
+// getSecureHops returns the hops to send req to: hops, only the TLS ones if
+// req targets a SIPS URI, or ErrSecureTransport if none is left.
+func (this *provider) getSecureHops(req Request, hops []Hop) ([]Hop, error) {
+	if !isSecureRequest(req) {
+		return hops, nil
+	}
+	if err := this.checkSecure(req); err != nil {
+		return nil, err
+	}
+
+	secure := make([]Hop, 0, len(hops))
+	for _, h := range hops {
+		if isSecureTransport(h.GetTransport()) {
+			secure = append(secure, h)
+		}
+	}
+	if len(secure) == 0 {
+		return nil, ErrSecureTransport
+	}
+	return secure, nil
+}
@@ -0,0 +1,91 @@
+package sip
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSecureHops(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	sent := captureSends(p)
+	p.AddTransport(newTransport(UDP, "192.0.2.10", 5060, nil))
+	p.AddTransport(newTransport(TCP, "192.0.2.10", 5060, nil))
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.3", 5060, UDP), NewHop("192.0.2.3", 5061, TLS)}, nil
+	}))
+	newRequest := func() Request {
+		req := NewRequest(OPTIONS, "sips:bob@biloxi.com", nil)
+		req.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.10;branch=z9hG4bKsec1")
+		req.GetHeader().Set("Call-Id", "sec1")
+		req.GetHeader().Set("Cseq", "1 OPTIONS")
+		return req
+	}
+
+	//without a TLS transport, a request to a sips URI is refused
+	if err := p.SendRequest(newRequest()); err != ErrSecureTransport {
+		t.Errorf("sent without TLS: %v", err)
+	}
+	ct := newClientTransaction(newRequest())
+	ct.provider = p
+	if err := ct.SendRequest(); err != ErrSecureTransport {
+		t.Errorf("transaction sent without TLS: %v", err)
+	}
+	if sent.len() != 0 {
+		t.Fatalf("%d messages sent", sent.len())
+	}
+
+	//with one, it only goes over TLS
+	p.AddTransport(newTransport(TLS, "192.0.2.10", 5061, nil))
+	if err := p.SendRequest(newRequest()); err != nil {
+		t.Fatal(err)
+	}
+	if sent.len() != 1 || sent.hops[0].String() != "192.0.2.3:5061/tls" {
+		t.Errorf("sent to %v", sent.hops)
+	}
+	if err := p.sendRequest(newRequest(), NewHop("192.0.2.3", 5060, TCP)); err != ErrSecureTransport {
+		t.Errorf("sent over TCP: %v", err)
+	}
+}
+
+func TestSecureDialog(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	ls := &serverListener{}
+	p.AddListener(ls)
+
+	invite := newServerTestRequest(INVITE, "TLS", "z9hG4bKsec2")
+	invite.SetRequestURIString("sips:bob@example.com")
+	invite.GetHeader().Set("Contact", "<sips:alice@192.0.2.1>")
+	invite.SetMessageInfo(&MessageInfo{
+		Network:    TLS,
+		LocalAddr:  &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5061},
+		RemoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000},
+	})
+	p.processMessage(invite)
+	resp := CreateResponse(invite, OK)
+	resp.GetHeader().Set("To", "<sip:bob@example.com>;tag=b1")
+	resp.GetHeader().Set("Contact", "<sip:bob@192.0.2.2>")
+	if err := ls.transactions[0].SendResponse(resp); err != nil {
+		t.Fatal(err)
+	}
+
+	//the Contact of the response and of the dialog is a SIPS URI
+	if c := resp.GetHeader().Get("Contact"); c != "<sips:bob@192.0.2.2>" {
+		t.Errorf("Contact %q", c)
+	}
+	d := ls.transactions[0].GetDialog().(*dialog)
+	if !d.IsSecure() {
+		t.Error("dialog not secure")
+	}
+	reinvite, err := d.CreateRequest(INVITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := reinvite.GetHeader().Get("Contact"); c != "<sips:bob@192.0.2.2>" {
+		t.Errorf("re-INVITE Contact %q", c)
+	}
+}
@@ -0,0 +1,142 @@
+package sip
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Each stream connection has a queue of messages to write, written in
+// turn by a goroutine of its own, so that a peer which stops reading, and
+// fills the socket's write buffer, does not block the transactions sending
+// to it or to anyone else. When the queue is full the destination is
+// congested: the message is refused with ErrSendQueueFull, which a client
+// transaction handles as a transport error, and the OverflowHandler of the
+// provider, if any, is told.
+
+const (
+	// SEND_QUEUE_LIMIT is the default number of messages waiting to be
+	// written to a connection.
+	SEND_QUEUE_LIMIT = 128
+
+	// SEND_TIMEOUT bounds a write: a connection which accepts nothing for
+	// that long is closed.
+	SEND_TIMEOUT = 10 * time.Second
+)
+
+var ErrSendQueueFull = errors.New("sip: send queue full")
+
+// An OverflowHandler is called with a message refused because the queue
+// of the connection to network and address is full.
+type OverflowHandler func(network, address string, b []byte)
+
+// newConnection serves c for sending through a queue of limit messages,
+// logging its write failures to logger.
+func newConnection(c net.Conn, limit int, logger Logger) *connection {
+	this := &connection{}
+
+	this.Conn = c
+	this.logger = logger
+	this.queue = make(chan []byte, limit)
+	this.quit = make(chan bool)
+	this.done = make(chan bool)
+	go this.writeLoop()
+
+	return this
+}
+
+// send queues b, or fails at once if the queue is full or the connection
+// closed.
+func (this *connection) send(b []byte) error {
+	select {
+	case <-this.quit:
+		return net.ErrClosed
+	default:
+	}
+	select {
+	case this.queue <- b:
+		return nil
+	default:
+		return ErrSendQueueFull
+	}
+}
+
+// getQueueLength returns the number of messages waiting to be written.
+func (this *connection) getQueueLength() int {
+	return len(this.queue)
+}
+
+func (this *connection) writeLoop() {
+	defer close(this.done)
+
+	for {
+		select {
+		case b := <-this.queue:
+			this.Conn.SetWriteDeadline(time.Now().Add(SEND_TIMEOUT))
+			if _, err := this.Conn.Write(b); err != nil {
+				//the reader of the connection removes it
+				this.logger.Log(LOG_ERROR, "Writing failed", "remote", this.RemoteAddr(), "error", err)
+				this.Close()
+				return
+			}
+		case <-this.quit:
+			return
+		}
+	}
+}
+
+func (this *connection) Close() error {
+	this.once.Do(func() {
+		close(this.quit)
+	})
+	return this.Conn.Close()
+}
+
+// wait waits for the writer of a closed connection to return.
+func (this *connection) wait() {
+	<-this.done
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (this *provider) GetSendQueueLimit() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.sendQueueLimit
+}
+
+// SetSendQueueLimit sets the number of messages which may wait to be
+// written to a connection, SEND_QUEUE_LIMIT by default. It applies to the
+// connections set up afterwards.
+func (this *provider) SetSendQueueLimit(limit int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.sendQueueLimit = limit
+}
+
+func (this *provider) GetOverflowHandler() OverflowHandler {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.overflowHandler
+}
+
+// SetOverflowHandler sets the handler called with the messages refused
+// because the queue of their connection is full.
+func (this *provider) SetOverflowHandler(handler OverflowHandler) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.overflowHandler = handler
+}
+
+// GetSendQueueLength returns the number of messages waiting to be written
+// to address over network, 0 without a connection to it.
+func (this *provider) GetSendQueueLength(network, address string) int {
+	if conn := this.connections.get(network, address); conn != nil {
+		return conn.getQueueLength()
+	}
+	return 0
+}
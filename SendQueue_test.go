@@ -0,0 +1,96 @@
+package sip
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestConnectionQueue(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := newConnection(local, 2, LogOff())
+
+	//the peer reads nothing: the writer blocks on the first message and
+	//two more are queued
+	sent := 0
+	for ; sent < 4; sent++ {
+		if err := conn.send([]byte{byte('a' + sent)}); err == ErrSendQueueFull {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sent != 3 || conn.getQueueLength() != 2 {
+		t.Fatalf("%d messages sent, %d queued, want 3, 2", sent, conn.getQueueLength())
+	}
+
+	b := make([]byte, 3)
+	remote.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(remote, b); err != nil || string(b) != "abc" {
+		t.Errorf("read %q, %v", b, err)
+	}
+
+	conn.Close()
+	if err := conn.send([]byte("d")); err != net.ErrClosed {
+		t.Errorf("send on a closed connection: %v", err)
+	}
+}
+
+func TestSendQueueOverflow(t *testing.T) {
+	lner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lner.Close()
+	go func() {
+		//accept, never read
+		if c, err := lner.Accept(); err == nil {
+			defer c.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	p := newProvider(TraceOff(), RealClock)
+	p.AddTransport(newTransport(TCP, "127.0.0.1", 0, nil))
+	p.SetSendQueueLimit(1)
+	overflows := make(chan string, 1)
+	p.SetOverflowHandler(func(network, address string, b []byte) {
+		select {
+		case overflows <- network + " " + address:
+		default:
+		}
+	})
+	go p.Run()
+	defer p.Stop()
+
+	port := lner.Addr().(*net.TCPAddr).Port
+	h := NewHop("127.0.0.1", port, TCP)
+	body := bytes.Repeat([]byte("x"), 1<<20)
+	for i := 0; ; i++ {
+		if i == 256 {
+			t.Fatal("the send queue never filled up")
+		}
+		req := NewRequest(MESSAGE, "sip:bob@127.0.0.1:"+strconv.Itoa(port), bytes.NewReader(body))
+		if err := p.transmit(req, h); err == ErrSendQueueFull {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case dest := <-overflows:
+		if dest != "tcp 127.0.0.1:"+strconv.Itoa(port) {
+			t.Errorf("overflow of %s", dest)
+		}
+	case <-time.After(time.Second):
+		t.Error("overflow handler not called")
+	}
+	if n := p.GetSendQueueLength(TCP, "127.0.0.1:"+strconv.Itoa(port)); n != 1 {
+		t.Errorf("queue length = %d, want 1", n)
+	}
+}
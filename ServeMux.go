@@ -0,0 +1,283 @@
+package sip
+
+import (
+	"regexp"
+	"sip/address"
+	"sort"
+	"strings"
+	"sync"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A ServeMux is a Listener giving each request to the Listener of the
+// route it matches, so that one provider can serve, say, a Registrar for
+// REGISTER, a voicemail range of users and a conference domain. When
+// several routes match, the one of the highest Priority wins, then the
+// most specific one: by its user, exact before a prefix, the longest
+// prefix first, then before a Pattern; by its domain, exact before a
+// wildcard, the longest first; then the one restricting the methods, and
+// the one with the most header predicates. Among equals, the first one
+// added wins. A request matching no route is given to the next Listener,
+// or answered with 404 Not Found if there is none.
+//
+// The responses, timeouts and terminated dialogs are given to every
+// Listener of the routes, and to the next one, as the provider gives them
+// to every Listener: each one picks those of its own transactions.
+type ServeMux interface {
+	DialogListener
+
+	AddRoute(route Route, l Listener)
+	RemoveRoutes(l Listener)
+}
+
+// A Route selects requests by their method, their Request-URI and their
+// headers. A zero field does not restrict anything.
+type Route struct {
+	Methods []string
+
+	// User is the user of a SIP or SIPS Request-URI: exactly, or, ending
+	// with "*", by prefix, such as "vm-*".
+	User string
+
+	// Domain is the host of a SIP or SIPS Request-URI, whatever its case:
+	// exactly, or, starting with "*.", any subdomain, such as
+	// "*.conference.example.com".
+	Domain string
+
+	// Pattern matches the whole Request-URI, of any scheme.
+	Pattern *regexp.Regexp
+
+	// Headers holds, by name, a pattern one of the values of the header
+	// must match.
+	Headers map[string]*regexp.Regexp
+
+	// Match, if set, is a predicate the request must satisfy too. It
+	// counts as a header predicate for precedence.
+	Match func(req Request) bool
+
+	// Priority overrides the precedence by specificity: a route of a
+	// higher Priority is tried first.
+	Priority int
+}
+
+////////////////////Implementation////////////////////////
+
+type serveMux struct {
+	next Listener
+
+	mutex  sync.Mutex
+	routes []*muxEntry
+}
+
+type muxEntry struct {
+	route    Route
+	listener Listener
+	rank     []int
+}
+
+// NewServeMux returns a ServeMux giving the requests matching none of its
+// routes to next, which may be nil.
+func NewServeMux(next Listener) ServeMux {
+	this := &serveMux{}
+
+	this.next = next
+
+	return this
+}
+
+// AddRoute gives l the requests matching route. A Listener may serve
+// several routes.
+func (this *serveMux) AddRoute(route Route, l Listener) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	//a new slice, the requests being routed keep the old one
+	n := len(this.routes)
+	routes := append(this.routes[:n:n], &muxEntry{route: route, listener: l, rank: route.rank()})
+	sort.SliceStable(routes, func(i, j int) bool {
+		a, b := routes[i].rank, routes[j].rank
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] > b[k]
+			}
+		}
+		return false
+	})
+	this.routes = routes
+}
+
+// RemoveRoutes removes the routes of l.
+func (this *serveMux) RemoveRoutes(l Listener) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	routes := this.routes[:0:0]
+	for _, e := range this.routes {
+		if e.listener != l {
+			routes = append(routes, e)
+		}
+	}
+	this.routes = routes
+}
+
+func (this *serveMux) ProcessRequest(requestEvent RequestEvent) {
+	req := requestEvent.GetRequest()
+	if l := this.route(req); l != nil {
+		l.ProcessRequest(requestEvent)
+		return
+	}
+	if this.next != nil {
+		this.next.ProcessRequest(requestEvent)
+		return
+	}
+	if w := requestEvent.GetResponseWriter(); w != nil {
+		if err := w.Respond(NOT_FOUND, nil); err != nil {
+			GetDefaultLogger().Log(LOG_ERROR, "Sending response failed", "error", err)
+		}
+	}
+}
+
+func (this *serveMux) ProcessResponse(responseEvent ResponseEvent) {
+	for _, l := range this.getListeners() {
+		l.ProcessResponse(responseEvent)
+	}
+}
+
+func (this *serveMux) ProcessTimeout(timeoutEvent TimeoutEvent) {
+	for _, l := range this.getListeners() {
+		l.ProcessTimeout(timeoutEvent)
+	}
+}
+
+func (this *serveMux) ProcessDialogTerminated(dialogTerminatedEvent DialogTerminatedEvent) {
+	for _, l := range this.getListeners() {
+		if dl, ok := l.(DialogListener); ok {
+			dl.ProcessDialogTerminated(dialogTerminatedEvent)
+		}
+	}
+}
+
+// route returns the Listener of the first route req matches, or nil.
+func (this *serveMux) route(req Request) Listener {
+	this.mutex.Lock()
+	routes := this.routes
+	this.mutex.Unlock()
+
+	uri, _ := ParseURI(req.GetRequestURIString())
+	for _, e := range routes {
+		if e.route.matches(req, uri) {
+			return e.listener
+		}
+	}
+	return nil
+}
+
+// getListeners returns the Listeners of the routes, and the next one, each
+// once.
+func (this *serveMux) getListeners() []Listener {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	var ls []Listener
+	seen := make(map[Listener]bool)
+	for _, e := range this.routes {
+		if !seen[e.listener] {
+			seen[e.listener] = true
+			ls = append(ls, e.listener)
+		}
+	}
+	if this.next != nil && !seen[this.next] {
+		ls = append(ls, this.next)
+	}
+	return ls
+}
+
+// matches reports whether req, with the Request-URI uri, which is nil if
+// it does not parse, passes the route.
+func (this Route) matches(req Request, uri address.URI) bool {
+	if len(this.Methods) > 0 {
+		found := false
+		for _, m := range this.Methods {
+			found = found || m == req.GetMethod()
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if this.User != "" || this.Domain != "" {
+		sipURI, ok := uri.(*address.SipURIImpl)
+		if !ok || sipURI == nil {
+			return false
+		}
+		if this.User != "" {
+			user := sipURI.GetUser()
+			if prefix := strings.TrimSuffix(this.User, "*"); prefix != this.User {
+				if !strings.HasPrefix(user, prefix) {
+					return false
+				}
+			} else if user != this.User {
+				return false
+			}
+		}
+		if this.Domain != "" {
+			host := strings.ToLower(strings.Trim(sipURI.GetHost(), "[]"))
+			domain := strings.ToLower(this.Domain)
+			if strings.HasPrefix(domain, "*.") {
+				if !strings.HasSuffix(host, domain[1:]) {
+					return false
+				}
+			} else if host != domain {
+				return false
+			}
+		}
+	}
+
+	if this.Pattern != nil && !this.Pattern.MatchString(req.GetRequestURIString()) {
+		return false
+	}
+	h := req.GetHeader()
+	for name, pattern := range this.Headers {
+		found := false
+		for _, v := range h.Values(name) {
+			found = found || pattern.MatchString(v)
+		}
+		if !found {
+			return false
+		}
+	}
+	return this.Match == nil || this.Match(req)
+}
+
+// rank returns the precedence of the route, compared in order, the
+// greater first.
+func (this Route) rank() []int {
+	user, prefix := 0, 0
+	switch {
+	case this.User != "" && strings.HasSuffix(this.User, "*"):
+		user, prefix = 2, len(this.User)
+	case this.User != "":
+		user = 3
+	case this.Pattern != nil:
+		user = 1
+	}
+
+	domain, suffix := 0, 0
+	switch {
+	case strings.HasPrefix(this.Domain, "*."):
+		domain, suffix = 1, len(this.Domain)
+	case this.Domain != "":
+		domain = 2
+	}
+
+	methods := 0
+	if len(this.Methods) > 0 {
+		methods = 1
+	}
+	headers := len(this.Headers)
+	if this.Match != nil {
+		headers++
+	}
+	return []int{this.Priority, user, prefix, domain, suffix, methods, headers}
+}
@@ -0,0 +1,61 @@
+package sip
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestServeMux(t *testing.T) {
+	var events []string
+	listener := func(name string) Listener {
+		return &recordingListener{name: name, events: &events}
+	}
+	next := listener("next")
+	mux := NewServeMux(next)
+	registrar := listener("registrar")
+	mux.AddRoute(Route{Methods: []string{REGISTER}}, registrar)
+	mux.AddRoute(Route{User: "vm-*", Domain: "example.com"}, listener("voicemail"))
+	mux.AddRoute(Route{User: "vm-admin"}, listener("admin"))
+	mux.AddRoute(Route{Domain: "*.conf.example.com"}, listener("conference"))
+	mux.AddRoute(Route{Domain: "bridge.conf.example.com"}, listener("bridge"))
+	mux.AddRoute(Route{Pattern: regexp.MustCompile(`^tel:\+1800`)}, listener("tollfree"))
+	mux.AddRoute(Route{Headers: map[string]*regexp.Regexp{"Priority": regexp.MustCompile(`^emergency$`)}, Priority: 1}, listener("emergency"))
+
+	for _, test := range []struct {
+		method, uri, priority, want string
+	}{
+		{REGISTER, "sip:example.com", "", "registrar"},
+		{INVITE, "sip:vm-1234@Example.COM", "", "voicemail"},
+		{INVITE, "sip:vm-admin@example.com", "", "admin"},
+		{INVITE, "sip:room1@a.conf.example.com", "", "conference"},
+		{INVITE, "sip:room1@bridge.conf.example.com", "", "bridge"},
+		{INVITE, "tel:+18005551234", "", "tollfree"},
+		{INVITE, "sip:vm-1234@example.com", "emergency", "emergency"},
+		{INVITE, "sip:bob@example.com", "", "next"},
+	} {
+		events = nil
+		req := NewRequest(test.method, test.uri, nil)
+		if test.priority != "" {
+			req.GetHeader().Set("Priority", test.priority)
+		}
+		mux.ProcessRequest(*NewRequestEvent(nil, req))
+		if len(events) != 1 || events[0] != test.want+" "+test.method {
+			t.Errorf("%s %s routed to %q, want %s", test.method, test.uri, events, test.want)
+		}
+	}
+
+	//the responses go to every listener
+	events = nil
+	mux.RemoveRoutes(registrar)
+	mux.ProcessResponse(*NewResponseEvent(nil, NewResponse(OK, "OK", nil)))
+	if len(events) != 7 || events[6] != "next OK" {
+		t.Errorf("response given to %q", events)
+	}
+
+	//without a next listener, 404
+	st := &registrarTransaction{request: NewRequest(INVITE, "sip:bob@example.com", nil)}
+	NewServeMux(nil).ProcessRequest(*NewRequestEvent(st, st.request))
+	if st.response == nil || st.response.GetStatusCode() != NOT_FOUND {
+		t.Errorf("unrouted request answered %v", st.response)
+	}
+}
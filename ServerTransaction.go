@@ -1,24 +1,368 @@
 package sip
 
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
 type ServerTransaction interface {
 	Transaction
 
 	SendResponse(Response) error
 }
 
+var ErrTransactionCompleted = errors.New("sip: transaction already completed")
+
+// PROVISIONAL_INTERVAL is the interval at which the last provisional
+// response to an INVITE is sent again over an unreliable transport, so that
+// the stateless proxies on the way keep from retransmitting the request
+// (RFC 3261 13.3.1.1).
+const PROVISIONAL_INTERVAL = time.Minute
+
 type serverTransaction struct {
 	transaction
+
+	provider *provider
+
+	//state machine of RFC 3261 17.2, guarded by mutex
+	mutex       sync.Mutex
+	reliable    bool
+	response    Response
+	interval    time.Duration
+	retransmit  Timer //G
+	timeout     Timer //H
+	linger      Timer //I or J
+	provisional Timer //the next provisional retransmission
+
+	//the reliable provisional responses, see scheduleReliable: the RSeq
+	//of the last one, and the one awaiting its PRACK with its timers
+	rseq         int
+	unacked      Response
+	rinterval    time.Duration
+	rretransmit  Timer
+	prackTimeout Timer
 }
 
+// newServerTransaction returns the transaction of an incoming request. An
+// INVITE transaction starts in the proceeding state, any other in the
+// trying state.
 func newServerTransaction(request Request) *serverTransaction {
-	return &serverTransaction{
+	this := &serverTransaction{
 		transaction: transaction{
 			request: request,
 			quit:    make(chan bool),
 		},
 	}
+	this.reliable = isReliable(request)
+	if request.GetMethod() == INVITE {
+		this.SetState(TRANSACTIONSTATE_PROCEEDING)
+	} else {
+		this.SetState(TRANSACTIONSTATE_TRYING)
+	}
+	return this
 }
 
+// SendResponse sends resp through the state machine. Provisional responses
+// keep the transaction proceeding. A 2xx to an INVITE terminates it, the
+// dialog taking over the retransmissions; any other final response
+// completes it: an INVITE transaction then retransmits the response on
+// Timer G until the ACK arrives or Timer H fires, and another transaction
+// absorbs the retransmitted requests until Timer J fires. A 101-299 response
+// to a dialog-forming request sets up its dialog. Over an unreliable
+// transport, the last 101-199 response to an INVITE is sent again at the
+// provisional interval of the provider while the transaction proceeds,
+// unless it is sent reliably, see PRACK.
 func (this *serverTransaction) SendResponse(resp Response) error {
-	return nil
+	statusCode := resp.GetStatusCode()
+	invite := this.request.GetMethod() == INVITE
+
+	switch this.GetState() {
+	case TRANSACTIONSTATE_TRYING, TRANSACTIONSTATE_PROCEEDING:
+	default:
+		return ErrTransactionCompleted
+	}
+	if this.provider != nil && isDialogForming(this.request) && !this.isProxied() && needsSecureContact(this.request) {
+		setSecureContact(resp)
+	}
+	if this.provider != nil && !this.isProxied() {
+		this.provider.stampSessionResponse(this.request, resp)
+	}
+	needsPrack, err := this.prepareReliable(resp)
+	if err != nil {
+		return err
+	}
+	//a response setting up the dialog is run through it once it exists
+	d, _ := this.GetDialog().(*dialog)
+	if d != nil {
+		if !this.isProxied() {
+			d.stampContact(this.request, resp)
+		}
+		if err := d.processOffer(resp, true); err != nil {
+			return err
+		}
+	}
+
+	this.mutex.Lock()
+	switch this.GetState() {
+	case TRANSACTIONSTATE_TRYING, TRANSACTIONSTATE_PROCEEDING:
+	default:
+		this.mutex.Unlock()
+		return ErrTransactionCompleted
+	}
+
+	this.response = resp
+	terminated := false
+	if statusCode >= OK {
+		this.stopReliable()
+	}
+	switch {
+	case statusCode < OK:
+		this.SetState(TRANSACTIONSTATE_PROCEEDING)
+		if needsPrack {
+			this.scheduleReliable(resp)
+		} else if invite && statusCode > TRYING && !this.reliable && this.provider != nil {
+			this.scheduleProvisional()
+		}
+	case invite && statusCode < MULTIPLE_CHOICES:
+		terminated = this.setTerminated()
+	case invite:
+		this.SetState(TRANSACTIONSTATE_COMPLETED)
+		stopTimer(this.provisional)
+		if this.provider != nil {
+			clock := this.provider.GetClock()
+			this.interval = this.getT1()
+			if !this.reliable {
+				this.retransmit = clock.AfterFunc(this.interval, this.onRetransmit)
+			}
+			this.timeout = clock.AfterFunc(64*this.getT1(), this.onTimeout)
+		}
+	default:
+		this.SetState(TRANSACTIONSTATE_COMPLETED)
+		if this.reliable {
+			terminated = this.setTerminated()
+		} else if this.provider != nil {
+			this.linger = this.provider.GetClock().AfterFunc(64*this.getT1(), this.terminate)
+		}
+	}
+	this.mutex.Unlock()
+
+	if this.provider != nil {
+		if isDialogForming(this.request) && !this.isProxied() {
+			this.provider.processDialog(this, resp, true)
+			if d == nil {
+				if d, ok := this.GetDialog().(*dialog); ok {
+					d.processOffer(resp, true)
+				}
+			}
+		}
+		if d, ok := this.GetDialog().(*dialog); ok && !this.isProxied() {
+			d.processSession(this, resp)
+		}
+		if statusCode >= OK {
+			this.recordSent(this.provider.GetClock().Now())
+		}
+	}
+	err = this.transmit(resp)
+	if terminated {
+		this.leave()
+	}
+	return err
+}
+
+// Close abandons the transaction and stops its timers.
+func (this *serverTransaction) Close() {
+	this.mutex.Lock()
+	this.setTerminated()
+	this.mutex.Unlock()
+
+	this.transaction.Close()
+	this.leave()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// processRequest handles a request matched to the transaction: a
+// retransmission of the request is answered with the last response, if
+// any, and the ACK of a non-2xx final response confirms an INVITE
+// transaction, which then absorbs the ACK retransmissions until Timer I
+// fires.
+func (this *serverTransaction) processRequest(req Request) {
+	this.mutex.Lock()
+	state := this.GetState()
+	if req.GetMethod() == ACK {
+		if state == TRANSACTIONSTATE_COMPLETED {
+			this.SetState(TRANSACTIONSTATE_CONFIRMED)
+			stopTimer(this.retransmit)
+			stopTimer(this.timeout)
+			if this.reliable {
+				terminated := this.setTerminated()
+				this.mutex.Unlock()
+				if terminated {
+					this.leave()
+				}
+				return
+			}
+			if this.provider != nil {
+				this.linger = this.provider.GetClock().AfterFunc(TIMER_I, this.terminate)
+			}
+		}
+		this.mutex.Unlock()
+		return
+	}
+
+	resp := this.response
+	this.mutex.Unlock()
+
+	switch state {
+	case TRANSACTIONSTATE_PROCEEDING, TRANSACTIONSTATE_COMPLETED:
+		if resp != nil {
+			if err := this.transmit(resp); err != nil {
+				this.processError(err)
+			}
+		}
+	}
+}
+
+// onRetransmit fires Timer G, which doubles up to T2.
+func (this *serverTransaction) onRetransmit() {
+	this.mutex.Lock()
+	if this.GetState() != TRANSACTIONSTATE_COMPLETED {
+		this.mutex.Unlock()
+		return
+	}
+	if this.interval *= 2; this.interval > TIMER_T2 {
+		this.interval = TIMER_T2
+	}
+	this.retransmit = this.provider.GetClock().AfterFunc(this.interval, this.onRetransmit)
+	resp := this.response
+	this.mutex.Unlock()
+
+	this.recordRetransmission()
+	this.provider.getMetrics().AddRetransmission(this.request.GetMethod())
+	if err := this.transmit(resp); err != nil {
+		this.processError(err)
+	}
+}
+
+// scheduleProvisional arms the retransmission of the provisional response
+// just sent; mutex is held.
+func (this *serverTransaction) scheduleProvisional() {
+	stopTimer(this.provisional)
+	if interval := this.provider.GetProvisionalInterval(); interval > 0 {
+		this.provisional = this.provider.GetClock().AfterFunc(interval, this.onProvisional)
+	}
+}
+
+// onProvisional sends the last provisional response again while the
+// transaction proceeds.
+func (this *serverTransaction) onProvisional() {
+	this.mutex.Lock()
+	resp := this.response
+	if this.GetState() != TRANSACTIONSTATE_PROCEEDING || resp == nil || resp.GetStatusCode() >= OK {
+		this.mutex.Unlock()
+		return
+	}
+	this.scheduleProvisional()
+	this.mutex.Unlock()
+
+	if err := this.transmit(resp); err != nil {
+		this.processError(err)
+	}
+}
+
+// onTimeout fires Timer H: the ACK never came. The listeners are told with
+// a TimeoutEvent.
+func (this *serverTransaction) onTimeout() {
+	this.mutex.Lock()
+	terminated := this.GetState() == TRANSACTIONSTATE_COMPLETED && this.setTerminated()
+	this.mutex.Unlock()
+
+	if terminated {
+		this.leave()
+		this.provider.getMetrics().AddTimeout(this.request.GetMethod())
+		this.provider.listeners.fireTimeout(newErrorTimeoutEvent(this, &TimeoutError{Transaction: this}))
+	}
+}
+
+// processError terminates the transaction when a response could not be
+// sent again, telling the listeners with a TIMEOUT_RETRANSMIT (RFC 3261
+// 17.2.4).
+func (this *serverTransaction) processError(err error) {
+	this.provider.getLogger(COMPONENT_TRANSACTION).Log(LOG_ERROR, "Retransmitting response failed", "error", err)
+
+	this.mutex.Lock()
+	terminated := this.setTerminated()
+	this.mutex.Unlock()
+
+	if terminated {
+		this.leave()
+		this.provider.listeners.fireTimeout(newErrorTimeoutEvent(this, &TransportError{Err: err}))
+	}
+}
+
+// terminate ends the transaction when Timer I or J fires.
+func (this *serverTransaction) terminate() {
+	this.mutex.Lock()
+	terminated := this.setTerminated()
+	this.mutex.Unlock()
+
+	if terminated {
+		this.leave()
+	}
+}
+
+// setTerminated moves the transaction to the terminated state and stops its
+// timers. It reports false if it was terminated already; mutex is held.
+func (this *serverTransaction) setTerminated() bool {
+	if this.GetState() == TRANSACTIONSTATE_TERMINATED {
+		return false
+	}
+	this.SetState(TRANSACTIONSTATE_TERMINATED)
+	stopTimer(this.retransmit)
+	stopTimer(this.timeout)
+	stopTimer(this.linger)
+	stopTimer(this.provisional)
+	this.stopReliable()
+	return true
+}
+
+func (this *serverTransaction) transmit(resp Response) error {
+	if this.provider == nil {
+		return nil
+	}
+	return this.provider.SendResponse(resp)
+}
+
+// leave removes a terminated transaction from the provider.
+func (this *serverTransaction) leave() {
+	if this.provider == nil {
+		return
+	}
+	this.provider.forgetServerTransaction(this)
+	this.provider.spawn(resourceLeave, func() {
+		select {
+		case this.provider.leave <- this:
+		case <-this.provider.quit:
+		}
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (this *provider) GetProvisionalInterval() time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.provisionalInterval
+}
+
+// SetProvisionalInterval sets the interval at which the provisional
+// responses to INVITEs are sent again over unreliable transports,
+// PROVISIONAL_INTERVAL by default; 0 sends them once.
+func (this *provider) SetProvisionalInterval(interval time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.provisionalInterval = interval
 }
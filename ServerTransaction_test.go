@@ -0,0 +1,296 @@
+package sip
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type serverListener struct {
+	requests      []RequestEvent
+	transactions  []ServerTransaction
+	timeouts      int
+	timeoutEvents []TimeoutEvent
+}
+
+func (this *serverListener) ProcessRequest(requestEvent RequestEvent) {
+	this.requests = append(this.requests, requestEvent)
+	this.transactions = append(this.transactions, requestEvent.GetServerTransaction())
+}
+
+func (this *serverListener) ProcessResponse(responseEvent ResponseEvent) {
+}
+
+func (this *serverListener) ProcessTimeout(timeoutEvent TimeoutEvent) {
+	this.timeouts++
+	this.timeoutEvents = append(this.timeoutEvents, timeoutEvent)
+}
+
+func newServerTestRequest(method, transport, branch string) Request {
+	req := NewRequest(method, "sip:bob@example.com", nil)
+	req.GetHeader().Set("Via", "SIP/2.0/"+transport+" 192.0.2.1;branch="+branch)
+	req.GetHeader().Set("From", "<sip:alice@example.com>;tag=1")
+	req.GetHeader().Set("To", "<sip:bob@example.com>")
+	req.GetHeader().Set("Call-Id", "3848276298220188511")
+	req.GetHeader().Set("Cseq", "1 "+method)
+	return req
+}
+
+func TestInviteServerTransaction(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKst1")
+	p.processMessage(invite)
+	if len(l.transactions) != 1 {
+		t.Fatalf("%d requests delivered", len(l.transactions))
+	}
+	st := l.transactions[0].(*serverTransaction)
+	if st.GetState() != TRANSACTIONSTATE_PROCEEDING {
+		t.Fatalf("state = %d, want proceeding", st.GetState())
+	}
+
+	//the delayed 100 Trying goes through the transaction
+	clock.Advance(TRYING_DELAY)
+	if st.response == nil || st.response.GetStatusCode() != TRYING {
+		t.Fatalf("last response = %v, want 100 Trying", st.response)
+	}
+
+	//retransmissions are absorbed, and answered with the last response
+	p.processMessage(invite)
+	if len(l.requests) != 1 {
+		t.Fatal("retransmitted INVITE delivered to the listener")
+	}
+	if sent.len() != 2 || sent.last().(Response).GetStatusCode() != TRYING {
+		t.Fatalf("%d responses sent, want the 100 Trying twice", sent.len())
+	}
+
+	if err := st.SendResponse(CreateResponse(invite, BUSY_HERE)); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SendResponse(CreateResponse(invite, OK)); err != ErrTransactionCompleted {
+		t.Errorf("second final response: %v", err)
+	}
+
+	//Timer G doubles up to T2
+	for _, d := range []time.Duration{TIMER_T1, 2 * TIMER_T1, 4 * TIMER_T1, TIMER_T2} {
+		clock.Advance(d)
+	}
+	if st.GetStats().Retransmissions != 4 || st.interval != TIMER_T2 || sent.len() != 7 {
+		t.Fatalf("%d retransmissions, Timer G = %v", st.GetStats().Retransmissions, st.interval)
+	}
+
+	//the ACK confirms the transaction and is not delivered
+	ack := newServerTestRequest(ACK, "UDP", "z9hG4bKst1")
+	p.processMessage(ack)
+	if st.GetState() != TRANSACTIONSTATE_CONFIRMED || len(l.requests) != 1 {
+		t.Fatalf("state = %d after ACK, %d requests delivered", st.GetState(), len(l.requests))
+	}
+	clock.Advance(TIMER_I)
+	if st.GetState() != TRANSACTIONSTATE_TERMINATED || p.matchServerTransaction(ack) != nil {
+		t.Errorf("state = %d after Timer I", st.GetState())
+	}
+
+	//without ACK, Timer H times the transaction out
+	invite = newServerTestRequest(INVITE, "UDP", "z9hG4bKst2")
+	p.processMessage(invite)
+	st = l.transactions[1].(*serverTransaction)
+	st.SendResponse(CreateResponse(invite, DECLINE))
+	clock.Advance(64 * TIMER_T1)
+	if st.GetState() != TRANSACTIONSTATE_TERMINATED || l.timeouts != 1 {
+		t.Errorf("state = %d, %d timeouts after Timer H", st.GetState(), l.timeouts)
+	}
+
+	//a 2xx terminates the transaction: its ACK goes to the listener
+	invite = newServerTestRequest(INVITE, "UDP", "z9hG4bKst3")
+	p.processMessage(invite)
+	st = l.transactions[2].(*serverTransaction)
+	st.SendResponse(CreateResponse(invite, OK))
+	if st.GetState() != TRANSACTIONSTATE_TERMINATED {
+		t.Fatalf("state = %d after 200", st.GetState())
+	}
+	p.processMessage(newServerTestRequest(ACK, "UDP", "z9hG4bKst3"))
+	if len(l.requests) != 4 || l.requests[3].GetRequest().GetMethod() != ACK {
+		t.Errorf("ACK of the 2xx not delivered")
+	}
+}
+
+func TestNonInviteServerTransaction(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+
+	options := newServerTestRequest(OPTIONS, "UDP", "z9hG4bKst4")
+	p.processMessage(options)
+	st := l.transactions[0].(*serverTransaction)
+	if st.GetState() != TRANSACTIONSTATE_TRYING {
+		t.Fatalf("state = %d, want trying", st.GetState())
+	}
+	st.SendResponse(CreateResponse(options, OK))
+	if st.GetState() != TRANSACTIONSTATE_COMPLETED {
+		t.Fatalf("state = %d, want completed", st.GetState())
+	}
+
+	//Timer J absorbs retransmissions for 64*T1 over UDP
+	p.processMessage(options)
+	if len(l.requests) != 1 {
+		t.Fatal("retransmitted OPTIONS delivered to the listener")
+	}
+	if sent.len() != 2 || sent.last().(Response).GetStatusCode() != OK {
+		t.Fatalf("%d responses sent, want the 200 twice", sent.len())
+	}
+	clock.Advance(64 * TIMER_T1)
+	if st.GetState() != TRANSACTIONSTATE_TERMINATED {
+		t.Errorf("state = %d after Timer J", st.GetState())
+	}
+
+	//Timer J is zero over TCP
+	options = newServerTestRequest(OPTIONS, "TCP", "z9hG4bKst5")
+	p.processMessage(options)
+	st = l.transactions[1].(*serverTransaction)
+	st.SendResponse(CreateResponse(options, OK))
+	if st.GetState() != TRANSACTIONSTATE_TERMINATED || clock.Pending() != 0 {
+		t.Errorf("state = %d with %d timers pending over TCP", st.GetState(), clock.Pending())
+	}
+}
+
+func TestServerTransactionMatching(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+
+	//the same branch from another sent-by is another transaction
+	p.processMessage(newServerTestRequest(OPTIONS, "UDP", "z9hG4bKst3"))
+	other := newServerTestRequest(OPTIONS, "UDP", "z9hG4bKst3")
+	other.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.2;branch=z9hG4bKst3")
+	p.processMessage(other)
+	if len(l.requests) != 2 || l.transactions[0] == l.transactions[1] {
+		t.Fatalf("%d requests delivered, want 2 transactions", len(l.requests))
+	}
+
+	//RFC 2543 requests are matched without the magic cookie
+	invite := newServerTestRequest(INVITE, "UDP", "1")
+	p.processMessage(invite)
+	p.processMessage(newServerTestRequest(INVITE, "UDP", "1"))
+	if len(l.requests) != 3 {
+		t.Fatalf("%d requests delivered, want the RFC 2543 retransmission absorbed", len(l.requests))
+	}
+	st := l.transactions[2].(*serverTransaction)
+
+	busy := CreateResponse(invite, BUSY_HERE)
+	busy.GetHeader().Set("To", "<sip:bob@example.com>;tag=b1")
+	if err := st.SendResponse(busy); err != nil {
+		t.Fatal(err)
+	}
+
+	//the ACK matches by the To tag of the response
+	ack := newServerTestRequest(ACK, "UDP", "1")
+	ack.GetHeader().Set("To", "<sip:bob@example.com>;tag=b2")
+	ack.GetHeader().Set("Cseq", "1 ACK")
+	if p.matchServerTransaction(ack) != nil {
+		t.Error("ACK with another To tag matched")
+	}
+	ack.GetHeader().Set("To", "<sip:bob@example.com>;tag=b1")
+	p.processMessage(ack)
+	if st.GetState() != TRANSACTIONSTATE_CONFIRMED || len(l.requests) != 3 {
+		t.Errorf("state = %d, %d requests delivered, want the ACK confirming", st.GetState(), len(l.requests))
+	}
+}
+
+func TestProvisionalRetransmission(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKprov1")
+	p.processMessage(invite)
+	st := l.transactions[0]
+	if err := st.SendResponse(CreateResponse(invite, RINGING)); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.SendResponse(CreateResponse(invite, SESSION_PROGRESS)); err != nil {
+		t.Fatal(err)
+	}
+
+	//the last provisional response, every minute
+	for i := 1; i <= 2; i++ {
+		n := sent.len()
+		clock.Advance(PROVISIONAL_INTERVAL)
+		if sent.len() != n+1 || sent.last().(Response).GetStatusCode() != SESSION_PROGRESS {
+			t.Fatalf("%d: %d responses sent after a minute", i, sent.len()-n)
+		}
+	}
+
+	//until the final response
+	if err := st.SendResponse(CreateResponse(invite, BUSY_HERE)); err != nil {
+		t.Fatal(err)
+	}
+	n := sent.len()
+	clock.Advance(PROVISIONAL_INTERVAL)
+	for i := n; i < sent.len(); i++ {
+		if sent.get(i).(Response).GetStatusCode() == SESSION_PROGRESS {
+			t.Error("provisional response sent after the final one")
+		}
+	}
+
+	//never over a reliable transport
+	invite = newServerTestRequest(INVITE, "TCP", "z9hG4bKprov2")
+	p.processMessage(invite)
+	if err := l.transactions[1].SendResponse(CreateResponse(invite, RINGING)); err != nil {
+		t.Fatal(err)
+	}
+	n = sent.len()
+	clock.Advance(PROVISIONAL_INTERVAL)
+	for i := n; i < sent.len(); i++ {
+		if sent.get(i).(Response).GetStatusCode() == RINGING {
+			t.Error("provisional response retransmitted over TCP")
+		}
+	}
+}
+
+func TestResponseRetransmissionFailure(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKstfail")
+	p.processMessage(invite)
+	st := l.transactions[0].(*serverTransaction)
+	if err := st.SendResponse(CreateResponse(invite, BUSY_HERE)); err != nil {
+		t.Fatal(err)
+	}
+
+	//the response cannot be sent again on Timer G
+	p.send = func(msg Message, h Hop) error { return errors.New("network unreachable") }
+	clock.Advance(TIMER_T1)
+	if st.GetState() != TRANSACTIONSTATE_TERMINATED || len(l.timeoutEvents) != 1 {
+		t.Fatalf("state = %d, %d timeouts", st.GetState(), len(l.timeoutEvents))
+	}
+	ev := l.timeoutEvents[0]
+	timeout := ev.GetTimeout()
+	var te *TransportError
+	if timeout.GetValue() != TIMEOUT_RETRANSMIT || !errors.As(ev.GetError(), &te) || ev.GetTransaction() != st {
+		t.Errorf("timeout %s, error %v", timeout.String(), ev.GetError())
+	}
+}
@@ -0,0 +1,421 @@
+package sip
+
+import (
+	"sip/header"
+	"sip/parser"
+	"strconv"
+	"time"
+)
+
+// Session timers (RFC 4028) keep the dialogs of a session from outliving
+// it when a user agent crashes or loses its network without a BYE. The
+// INVITE setting up the session, or an UPDATE or re-INVITE within it,
+// asks for a session interval in its Session-Expires, and its 2xx settles
+// the interval and which user agent refreshes the session. The refresher
+// sends a re-INVITE or an UPDATE halfway through the interval; the other
+// user agent, if no refresh came, sends a BYE shortly before the session
+// expires. Each 2xx to a session refresh starts the interval again. A
+// request whose interval is shorter than the Min-SE of a user agent or a
+// proxy is rejected with 422 (Session Interval Too Small) and the Min-SE
+// to retry with.
+
+////////////////////Interface//////////////////////////////
+
+// A SessionTimer sets up the session timers of the dialogs of a provider.
+// The zero value leaves them off; DefaultSessionTimer turns them on with
+// the intervals recommended by RFC 4028.
+type SessionTimer struct {
+	// Expires is the session interval asked for in the Session-Expires of
+	// the INVITEs and UPDATEs sent, and the longest one granted in their
+	// 2xx responses; 0 turns session timers off.
+	Expires time.Duration
+
+	// MinSE is the shortest session interval accepted, SESSION_MIN_SE if
+	// 0. It is sent in the Min-SE of the requests.
+	MinSE time.Duration
+
+	// Refresher is the refresher parameter, header.SessionExpires_UAC or
+	// header.SessionExpires_UAS, of the requests sent, and the choice of
+	// a 2xx to a request which left it open; if empty, the UAS chooses
+	// the UAC whenever it supports session timers.
+	Refresher string
+
+	// Method is the method of the session refreshes, UPDATE unless it is
+	// INVITE or the remote party does not allow UPDATE. A re-INVITE
+	// carries the last session description sent.
+	Method string
+}
+
+const (
+	// SESSION_EXPIRES is the session interval recommended by RFC 4028.
+	SESSION_EXPIRES = 1800 * time.Second
+
+	// SESSION_MIN_SE is the smallest session interval of RFC 4028, which
+	// a Min-SE cannot lower.
+	SESSION_MIN_SE = 90 * time.Second
+
+	// SESSION_BYE_MARGIN bounds how long before the session expires the
+	// user agent which does not refresh it sends its BYE; it is a third
+	// of the interval if shorter.
+	SESSION_BYE_MARGIN = 32 * time.Second
+
+	// OPTIONTAG_TIMER is the option tag of RFC 4028.
+	OPTIONTAG_TIMER = "timer"
+)
+
+var DefaultSessionTimer = SessionTimer{
+	Expires: SESSION_EXPIRES,
+	MinSE:   SESSION_MIN_SE,
+}
+
+////////////////////Implementation////////////////////////
+
+// IsEnabled reports whether session timers are on.
+func (this SessionTimer) IsEnabled() bool {
+	return this.Expires > 0
+}
+
+// getMinSE returns the shortest session interval accepted.
+func (this SessionTimer) getMinSE() time.Duration {
+	if this.MinSE < SESSION_MIN_SE {
+		return SESSION_MIN_SE
+	}
+	return this.MinSE
+}
+
+// getMethod returns the method of the session refreshes.
+func (this SessionTimer) getMethod() string {
+	if this.Method == INVITE {
+		return INVITE
+	}
+	return UPDATE
+}
+
+// GetSessionExpires returns the session interval and the refresher
+// parameter of the Session-Expires of msg, and false if it has none.
+func GetSessionExpires(msg Message) (time.Duration, string, bool) {
+	v := msg.GetHeader().Get("Session-Expires")
+	if v == "" {
+		return 0, "", false
+	}
+	sh, err := parser.NewSessionExpiresParser("Session-Expires: " + v + "\n").Parse()
+	if err != nil {
+		return 0, "", false
+	}
+	se := sh.(*header.SessionExpires)
+	return time.Duration(se.GetDelta()) * time.Second, se.GetRefresher(), true
+}
+
+// SetSessionExpires sets the Session-Expires of msg to interval, in whole
+// seconds, with the refresher parameter if not empty.
+func SetSessionExpires(msg Message, interval time.Duration, refresher string) {
+	se := header.NewSessionExpires()
+	if err := se.SetRefresher(refresher); err != nil {
+		return
+	}
+	se.SetDelta(int(interval / time.Second))
+	msg.GetHeader().Set(se.GetHeaderName(), se.EncodeBody())
+}
+
+// GetMinSE returns the Min-SE of msg, or SESSION_MIN_SE if it has none.
+func GetMinSE(msg Message) time.Duration {
+	if v := msg.GetHeader().Get("Min-SE"); v != "" {
+		if sh, err := parser.NewMinSEParser("Min-SE: " + v + "\n").Parse(); err == nil {
+			if minSE := time.Duration(sh.(*header.MinSE).GetDelta()) * time.Second; minSE > SESSION_MIN_SE {
+				return minSE
+			}
+		}
+	}
+	return SESSION_MIN_SE
+}
+
+// isSessionRefresh reports whether requests of method may refresh a
+// session.
+func isSessionRefresh(method string) bool {
+	return method == INVITE || method == UPDATE
+}
+
+// getByeDelay returns how long after a refresh the user agent which does
+// not refresh the session sends its BYE if no other refresh came.
+func getByeDelay(interval time.Duration) time.Duration {
+	margin := SESSION_BYE_MARGIN
+	if interval/3 < margin {
+		margin = interval / 3
+	}
+	return interval - margin
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// GetSessionExpires returns the session interval of the dialog, 0 if it
+// has no session timer.
+func (this *dialog) GetSessionExpires() time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.sessionInterval
+}
+
+// IsSessionRefresher reports whether this side of the dialog refreshes its
+// session.
+func (this *dialog) IsSessionRefresher() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.sessionInterval > 0 && this.sessionRefresher
+}
+
+// processSession (re)starts the session timer of the dialog on a 2xx to an
+// INVITE or an UPDATE, sent when the dialog is the UAS of t and received
+// otherwise. The refresher parameter names the UAC or the UAS of t, not of
+// the dialog. A 2xx without a Session-Expires stops the session timer.
+func (this *dialog) processSession(t Transaction, resp Response) {
+	if this.provider == nil || resp.GetStatusCode()/100 != 2 || !isSessionRefresh(t.GetRequest().GetMethod()) {
+		return
+	}
+	if !this.provider.GetSessionTimer().IsEnabled() {
+		return
+	}
+	_, client := t.(ClientTransaction)
+	interval, refresher, ok := GetSessionExpires(resp)
+	if ok && interval < SESSION_MIN_SE {
+		ok = false
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.state == DIALOGSTATE_TERMINATED {
+		return
+	}
+	stopTimer(this.sessionTimer)
+	this.sessionTimer = nil
+	this.sessionInterval = 0
+	if !ok {
+		return
+	}
+	this.sessionInterval = interval
+	this.sessionRefresher = (refresher == header.SessionExpires_UAC) == client
+	if this.sessionRefresher {
+		this.sessionTimer = this.provider.GetClock().AfterFunc(interval/2, this.refreshSession)
+	} else {
+		this.sessionTimer = this.provider.GetClock().AfterFunc(getByeDelay(interval), this.expireSession)
+	}
+}
+
+// refreshSession sends the session refresh of the refresher halfway
+// through the session interval, then waits for its 2xx until the other
+// side would send its BYE, and sends one itself if none came.
+func (this *dialog) refreshSession() {
+	this.mutex.Lock()
+	if this.state != DIALOGSTATE_CONFIRMED || this.sessionInterval == 0 {
+		this.mutex.Unlock()
+		return
+	}
+	interval := this.sessionInterval
+	this.sessionTimer = this.provider.GetClock().AfterFunc(getByeDelay(interval)-interval/2, this.expireSession)
+	this.mutex.Unlock()
+
+	method := this.provider.GetSessionTimer().getMethod()
+	if method == UPDATE && !this.isRemoteAllowed(UPDATE) {
+		method = INVITE
+	}
+	this.sendRefresh(method, interval)
+}
+
+// sendRefresh sends a session refresh of method asking for interval, with
+// this side as the refresher.
+func (this *dialog) sendRefresh(method string, interval time.Duration) {
+	req, err := this.CreateRequest(method)
+	if err != nil {
+		return
+	}
+	SetSessionExpires(req, interval, header.SessionExpires_UAC)
+	req.GetHeader().Set("Min-SE", strconv.Itoa(int(this.provider.GetSessionTimer().getMinSE()/time.Second)))
+
+	this.mutex.Lock()
+	sdp := this.localSDP
+	this.mutex.Unlock()
+	if method == INVITE && sdp != nil {
+		req.SetBody(NewSDPBody(sdp))
+	}
+
+	ct := this.provider.GetNewClientTransaction(req)
+	this.mutex.Lock()
+	this.sessionRefresh = ct
+	this.mutex.Unlock()
+	if err := this.SendRequest(ct); err != nil {
+		this.provider.getLogger(COMPONENT_DIALOG).Log(LOG_ERROR, "Sending session refresh failed", "dialog", this.GetDialogId(), "error", err)
+	}
+}
+
+// processRefreshResponse handles a final response to the session refresh
+// ct sent by the dialog: a 2xx to a re-INVITE is acknowledged, a 422 sends
+// the refresh again with the Min-SE it asks for, and a 405 or 501 to an
+// UPDATE sends it again as a re-INVITE. It reports whether it sent the
+// refresh again, the listeners then getting the responses to the retry
+// instead.
+func (this *dialog) processRefreshResponse(ct ClientTransaction, resp Response) bool {
+	statusCode := resp.GetStatusCode()
+	if statusCode < OK {
+		return false
+	}
+
+	this.mutex.Lock()
+	if this.sessionRefresh != ct {
+		this.mutex.Unlock()
+		return false
+	}
+	this.sessionRefresh = nil
+	this.mutex.Unlock()
+
+	req := ct.GetRequest()
+	interval, _, _ := GetSessionExpires(req)
+	switch {
+	case statusCode/100 == 2 && req.GetMethod() == INVITE:
+		ack, err := this.CreateRequest(ACK)
+		if err != nil {
+			return false
+		}
+		if err := this.SendAck(ack); err != nil {
+			this.provider.getLogger(COMPONENT_DIALOG).Log(LOG_ERROR, "Sending ACK failed", "dialog", this.GetDialogId(), "error", err)
+		}
+	case statusCode == SESSION_INTERVAL_TOO_SMALL:
+		if minSE := GetMinSE(resp); minSE > interval {
+			interval = minSE
+		}
+		this.sendRefresh(req.GetMethod(), interval)
+		return true
+	case (statusCode == METHOD_NOT_ALLOWED || statusCode == NOT_IMPLEMENTED) && req.GetMethod() == UPDATE:
+		this.sendRefresh(INVITE, interval)
+		return true
+	}
+	return false
+}
+
+// expireSession ends a dialog whose session was not refreshed in time with
+// a BYE.
+func (this *dialog) expireSession() {
+	if this.GetState() != DIALOGSTATE_CONFIRMED {
+		return
+	}
+	bye, err := this.CreateRequest(BYE)
+	this.terminate(DIALOGTERMINATED_SESSION_EXPIRED)
+	if err != nil {
+		return
+	}
+	bye.GetHeader().Set("Reason", `SIP;cause=408;text="Session expired"`)
+	if err := this.provider.GetNewClientTransaction(bye).SendRequest(); err != nil {
+		this.provider.getLogger(COMPONENT_DIALOG).Log(LOG_ERROR, "Sending BYE failed", "dialog", this.GetDialogId(), "error", err)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+func (this *provider) GetSessionTimer() SessionTimer {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.sessionTimer
+}
+
+// SetSessionTimer sets up the session timers of the dialogs set up or
+// refreshed from then on, off by default; see DefaultSessionTimer.
+func (this *provider) SetSessionTimer(sessionTimer SessionTimer) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.sessionTimer = sessionTimer
+}
+
+// stampSessionExpires asks for a session timer in an INVITE or an UPDATE
+// sent by a user agent: it gets the session interval of its dialog, if the
+// session has a timer, else the one of the provider, unless it has a
+// Session-Expires already, with the Min-SE and the timer option tag.
+func (this *provider) stampSessionExpires(req Request, d *dialog) {
+	sessionTimer := this.GetSessionTimer()
+	if !sessionTimer.IsEnabled() || !isSessionRefresh(req.GetMethod()) {
+		return
+	}
+	h := req.GetHeader()
+	if h.Get("Session-Expires") == "" {
+		interval, refresher := sessionTimer.Expires, sessionTimer.Refresher
+		if d != nil {
+			d.mutex.Lock()
+			if d.sessionInterval > 0 {
+				interval, refresher = d.sessionInterval, header.SessionExpires_UAS
+				if d.sessionRefresher {
+					refresher = header.SessionExpires_UAC
+				}
+			}
+			d.mutex.Unlock()
+		}
+		if interval < sessionTimer.getMinSE() {
+			interval = sessionTimer.getMinSE()
+		}
+		SetSessionExpires(req, interval, refresher)
+		if h.Get("Min-SE") == "" {
+			h.Set("Min-SE", strconv.Itoa(int(sessionTimer.getMinSE()/time.Second)))
+		}
+	}
+	if !hasOptionTag(req, "Supported", OPTIONTAG_TIMER) {
+		h.Add("Supported", OPTIONTAG_TIMER)
+	}
+}
+
+// checkSessionExpires returns a 422 with the Min-SE of the provider if the
+// session interval req asks for is too short, and nil otherwise.
+func (this *provider) checkSessionExpires(req Request) Response {
+	sessionTimer := this.GetSessionTimer()
+	if !sessionTimer.IsEnabled() || !isSessionRefresh(req.GetMethod()) {
+		return nil
+	}
+	interval, _, ok := GetSessionExpires(req)
+	if !ok || interval >= sessionTimer.getMinSE() {
+		return nil
+	}
+	resp := CreateResponse(req, SESSION_INTERVAL_TOO_SMALL)
+	resp.GetHeader().Set("Min-SE", strconv.Itoa(int(sessionTimer.getMinSE()/time.Second)))
+	return resp
+}
+
+// stampSessionResponse settles the session timer in resp, a 2xx to req, an
+// INVITE or an UPDATE received by a user agent, unless it has a
+// Session-Expires already (RFC 4028 9). The interval is the one of req,
+// shortened to the one of the provider but not below the Min-SE of req,
+// or the one of the provider if req has none. The refresher is the one req
+// asked for, else the UAC if it supports session timers and the provider
+// does not prefer the UAS, else the UAS. A UAC refresher is required to
+// support session timers.
+func (this *provider) stampSessionResponse(req Request, resp Response) {
+	sessionTimer := this.GetSessionTimer()
+	if !sessionTimer.IsEnabled() || !isSessionRefresh(req.GetMethod()) || resp.GetStatusCode()/100 != 2 {
+		return
+	}
+	h := resp.GetHeader()
+	if h.Get("Session-Expires") != "" {
+		return
+	}
+
+	interval, refresher, ok := GetSessionExpires(req)
+	if !ok || interval > sessionTimer.Expires {
+		interval = sessionTimer.Expires
+	}
+	if minSE := GetMinSE(req); interval < minSE {
+		interval = minSE
+	}
+	supported := IsSupported(req, OPTIONTAG_TIMER)
+	if refresher == "" {
+		refresher = header.SessionExpires_UAS
+		if supported && sessionTimer.Refresher != header.SessionExpires_UAS {
+			refresher = header.SessionExpires_UAC
+		}
+	}
+	if refresher == header.SessionExpires_UAC && !supported {
+		refresher = header.SessionExpires_UAS
+	}
+	SetSessionExpires(resp, interval, refresher)
+	if refresher == header.SessionExpires_UAC && !hasOptionTag(resp, "Require", OPTIONTAG_TIMER) {
+		h.Add("Require", OPTIONTAG_TIMER)
+	}
+}
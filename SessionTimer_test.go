@@ -0,0 +1,222 @@
+package sip
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// findSent returns the last request of method sent, or nil.
+func findSent(sent *sentMessages, method string) Request {
+	for i := sent.len() - 1; i >= 0; i-- {
+		if req, ok := sent.get(i).(Request); ok && req.GetMethod() == method {
+			return req
+		}
+	}
+	return nil
+}
+
+func TestSessionTimerRefresher(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.7", 5060, UDP)}, nil
+	}))
+	p.SetSessionTimer(SessionTimer{Expires: 120 * time.Second})
+
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKse1")
+	invite.GetHeader().Set("Contact", "<sip:alice@192.0.2.1>")
+	ct := p.GetNewClientTransaction(invite)
+	if err := ct.SendRequest(); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, 1)
+	h := invite.GetHeader()
+	if h.Get("Session-Expires") != "120" || h.Get("Min-SE") != "90" || !hasOptionTag(invite, "Supported", OPTIONTAG_TIMER) {
+		t.Errorf("INVITE asked for Session-Expires %q, Min-SE %q, Supported %q", h.Get("Session-Expires"), h.Get("Min-SE"), h.Get("Supported"))
+	}
+
+	ok200 := CreateResponse(invite, OK)
+	ok200.GetHeader().Set("To", "<sip:bob@example.com>;tag=b1")
+	ok200.GetHeader().Set("Contact", "<sip:bob@192.0.2.7>")
+	ok200.GetHeader().Set("Session-Expires", "120;refresher=uac")
+	ok200.GetHeader().Set("Require", OPTIONTAG_TIMER)
+	p.processResponse(ok200)
+	d := ct.GetDialog()
+	if d.GetSessionExpires() != 120*time.Second || !d.IsSessionRefresher() {
+		t.Fatalf("session of %v, refresher %v", d.GetSessionExpires(), d.IsSessionRefresher())
+	}
+
+	//halfway through the interval, an UPDATE refreshes the session
+	clock.Advance(60*time.Second - time.Millisecond)
+	if findSent(sent, UPDATE) != nil {
+		t.Fatal("session refreshed early")
+	}
+	clock.Advance(time.Millisecond)
+	var update Request
+	for i := 0; update == nil && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		update = findSent(sent, UPDATE)
+	}
+	if update == nil {
+		t.Fatal("session not refreshed")
+	}
+	if v := update.GetHeader().Get("Session-Expires"); v != "120;refresher=uac" {
+		t.Errorf("refresh Session-Expires %q", v)
+	}
+	if v := update.GetHeader().Get("Cseq"); v != "2 UPDATE" {
+		t.Errorf("refresh CSeq %q", v)
+	}
+	refreshed := CreateResponse(update, OK)
+	refreshed.GetHeader().Set("Session-Expires", "120;refresher=uac")
+	p.processResponse(refreshed)
+
+	//an unanswered refresh ends the session with a BYE
+	clock.Advance(60 * time.Second)
+	for i := 0; findSent(sent, UPDATE) == update && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if v := findSent(sent, UPDATE).GetHeader().Get("Cseq"); v != "3 UPDATE" {
+		t.Fatalf("second refresh CSeq %q", v)
+	}
+	clock.Advance(getByeDelay(120*time.Second) - 60*time.Second)
+	var bye Request
+	for i := 0; bye == nil && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		bye = findSent(sent, BYE)
+	}
+	if bye == nil {
+		t.Fatal("no BYE once the session expired")
+	}
+	if d.GetState() != DIALOGSTATE_TERMINATED {
+		t.Errorf("dialog %v once the session expired", d.GetState())
+	}
+}
+
+func TestSessionTimerServer(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+	p.SetSessionTimer(SessionTimer{Expires: 1800 * time.Second, MinSE: 300 * time.Second})
+
+	//too short an interval is rejected with the Min-SE
+	short := newServerTestRequest(INVITE, "UDP", "z9hG4bKse2")
+	short.GetHeader().Set("Session-Expires", "100")
+	p.processMessage(short)
+	resp, ok := sent.last().(Response)
+	if !ok || resp.GetStatusCode() != SESSION_INTERVAL_TOO_SMALL || resp.GetHeader().Get("Min-SE") != "300" {
+		t.Fatalf("short session interval answered %v", sent.last())
+	}
+	if len(l.requests) != 0 {
+		t.Error("listener got the rejected INVITE")
+	}
+
+	//the UAC supporting session timers refreshes the session
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKse3")
+	invite.GetHeader().Set("Cseq", "2 INVITE")
+	invite.GetHeader().Set("Contact", "<sip:alice@192.0.2.1>")
+	invite.GetHeader().Set("Session-Expires", "600")
+	invite.GetHeader().Set("Supported", OPTIONTAG_TIMER)
+	invite.SetMessageInfo(&MessageInfo{Network: UDP, LocalAddr: &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5060}})
+	p.processMessage(invite)
+	if len(l.transactions) != 1 {
+		t.Fatalf("%d requests", len(l.transactions))
+	}
+	st := l.transactions[0]
+	ok200 := CreateResponse(invite, OK)
+	ok200.GetHeader().Set("To", "<sip:bob@example.com>;tag=b2")
+	ok200.GetHeader().Set("Contact", "<sip:bob@192.0.2.2>")
+	if err := st.SendResponse(ok200); err != nil {
+		t.Fatal(err)
+	}
+	if v := ok200.GetHeader().Get("Session-Expires"); v != "600;refresher=uac" || !hasOptionTag(ok200, "Require", OPTIONTAG_TIMER) {
+		t.Errorf("2xx Session-Expires %q, Require %q", v, ok200.GetHeader().Get("Require"))
+	}
+	d := st.GetDialog()
+	if d.GetSessionExpires() != 600*time.Second || d.IsSessionRefresher() {
+		t.Fatalf("session of %v, refresher %v", d.GetSessionExpires(), d.IsSessionRefresher())
+	}
+
+	//without a refresh, the UAS sends a BYE 32s before the session expires
+	n := sent.len()
+	clock.Advance(600*time.Second - SESSION_BYE_MARGIN - time.Millisecond)
+	if findSent(sent, BYE) != nil {
+		t.Fatal("BYE sent early")
+	}
+	clock.Advance(time.Millisecond)
+	waitSent(t, sent, n+1)
+	if bye := findSent(sent, BYE); bye == nil || bye.GetHeader().Get("Cseq") != "1 BYE" {
+		t.Fatalf("no BYE once the session expired: %v", sent.last())
+	}
+	if d.GetState() != DIALOGSTATE_TERMINATED {
+		t.Errorf("dialog %v once the session expired", d.GetState())
+	}
+}
+
+func TestSessionTimerRetry(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	p.SetSessionTimer(SessionTimer{Expires: 90 * time.Second})
+
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKse4")
+	invite.GetHeader().Set("Contact", "<sip:alice@192.0.2.1>")
+	ct := p.GetNewClientTransaction(invite).(*clientTransaction)
+	ct.hops = []Hop{NewHop("192.0.2.7", 5060, UDP)}
+	ct.start()
+	ok200 := CreateResponse(invite, OK)
+	ok200.GetHeader().Set("To", "<sip:bob@example.com>;tag=b4")
+	ok200.GetHeader().Set("Contact", "<sip:bob@192.0.2.7>")
+	ok200.GetHeader().Set("Session-Expires", "90;refresher=uac")
+	p.processResponse(ok200)
+	d := ct.GetDialog().(*dialog)
+
+	//an UPDATE refused with 422 is sent again with the Min-SE asked for,
+	//then as a re-INVITE if the peer does not implement UPDATE
+	n := sent.len()
+	d.sendRefresh(UPDATE, 90*time.Second)
+	waitSent(t, sent, n+1)
+	update := findSent(sent, UPDATE)
+	tooSmall := CreateResponse(update, SESSION_INTERVAL_TOO_SMALL)
+	tooSmall.GetHeader().Set("Min-SE", "240")
+	p.processResponse(tooSmall)
+	waitSent(t, sent, n+2)
+	retry := findSent(sent, UPDATE)
+	if retry == update || retry.GetHeader().Get("Session-Expires") != "240;refresher=uac" {
+		t.Fatalf("refresh sent again with Session-Expires %q", retry.GetHeader().Get("Session-Expires"))
+	}
+	n = sent.len()
+	p.processResponse(CreateResponse(retry, NOT_IMPLEMENTED))
+	waitSent(t, sent, n+1)
+	reinvite := findSent(sent, INVITE)
+	if reinvite == invite || reinvite.GetHeader().Get("Session-Expires") != "240;refresher=uac" {
+		t.Fatalf("refresh not sent as a re-INVITE: %v", sent.last())
+	}
+
+	//the 2xx to the re-INVITE is acknowledged and restarts the session
+	reinvited := CreateResponse(reinvite, OK)
+	reinvited.GetHeader().Set("To", "<sip:bob@example.com>;tag=b4")
+	reinvited.GetHeader().Set("Contact", "<sip:bob@192.0.2.7>")
+	reinvited.GetHeader().Set("Session-Expires", "240;refresher=uas")
+	n = sent.len()
+	p.processResponse(reinvited)
+	waitSent(t, sent, n+1)
+	cseq, _ := getCSeq(reinvite)
+	if ack := findSent(sent, ACK); ack == nil || ack.GetHeader().Get("Cseq") != strconv.Itoa(cseq)+" ACK" {
+		t.Errorf("re-INVITE acknowledged with %v", ack)
+	}
+	if d.GetSessionExpires() != 240*time.Second || d.IsSessionRefresher() {
+		t.Errorf("session of %v, refresher %v", d.GetSessionExpires(), d.IsSessionRefresher())
+	}
+}
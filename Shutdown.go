@@ -0,0 +1,78 @@
+package sip
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// A provider shuts down gracefully, see Shutdown, in three steps: it stops
+// taking new work, answering the requests outside of its dialogs with 503
+// Service Unavailable and a Retry-After, while those within them, a BYE
+// above all, are still served; it waits for its transactions and dialogs
+// to end, or for the context to be done; and it stops as Stop does,
+// closing what is left and its transports.
+
+const (
+	// SHUTDOWN_RETRY_AFTER is the Retry-After, in seconds, of the 503 of a
+	// provider shutting down: about the time it takes for another instance
+	// to take over.
+	SHUTDOWN_RETRY_AFTER = 30
+
+	// SHUTDOWN_POLL is how often a provider shutting down checks whether its
+	// transactions and dialogs ended.
+	SHUTDOWN_POLL = 100 * time.Millisecond
+)
+
+////////////////////Implementation////////////////////////
+
+// Shutdown stops the provider gracefully: new requests are refused with
+// 503, then it waits for its transactions and dialogs to end before
+// stopping. If ctx is done first, the provider stops all the same and the
+// error of ctx is returned.
+func (this *provider) Shutdown(ctx context.Context) error {
+	this.mutex.Lock()
+	this.shuttingDown = true
+	this.mutex.Unlock()
+
+	ticker := time.NewTicker(SHUTDOWN_POLL)
+	defer ticker.Stop()
+
+	var err error
+	for !this.isIdle() {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-this.quit:
+		case <-ticker.C:
+			continue
+		}
+		break
+	}
+	this.Stop()
+	return err
+}
+
+// IsShuttingDown reports whether Shutdown was called.
+func (this *provider) IsShuttingDown() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.shuttingDown
+}
+
+// isIdle reports whether the provider has no transaction nor dialog left.
+func (this *provider) isIdle() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return len(this.clients) == 0 && len(this.servers) == 0 && len(this.dialogs) == 0
+}
+
+// rejectShuttingDown answers req, a request outside of the dialogs of a
+// provider shutting down, with 503 and a Retry-After.
+func (this *provider) rejectShuttingDown(req Request) error {
+	resp := CreateResponse(req, SERVICE_UNAVAILABLE)
+	resp.GetHeader().Set("Retry-After", strconv.Itoa(SHUTDOWN_RETRY_AFTER))
+	return this.SendResponse(resp)
+}
@@ -0,0 +1,99 @@
+package sip
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	ls := &serverListener{}
+	p.AddListener(ls)
+
+	//a call in progress
+	invite := newServerTestRequest(INVITE, "TCP", "z9hG4bKdown1")
+	invite.GetHeader().Set("Contact", "<sip:alice@192.0.2.1;transport=tcp>")
+	invite.SetMessageInfo(&MessageInfo{
+		Network:    TCP,
+		LocalAddr:  &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5060},
+		RemoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5060},
+	})
+	p.processMessage(invite)
+	resp := CreateResponse(invite, OK)
+	resp.GetHeader().Set("To", "<sip:bob@example.com>;tag=down")
+	resp.GetHeader().Set("Contact", "<sip:bob@192.0.2.2;transport=tcp>")
+	if err := ls.transactions[0].SendResponse(resp); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- p.Shutdown(context.Background()) }()
+	for !p.IsShuttingDown() {
+		time.Sleep(time.Millisecond)
+	}
+
+	//new requests are refused
+	p.processMessage(newServerTestRequest(OPTIONS, "TCP", "z9hG4bKdown2"))
+	if r, ok := sent.last().(Response); !ok || r.GetStatusCode() != SERVICE_UNAVAILABLE || r.GetHeader().Get("Retry-After") != "30" {
+		t.Fatalf("OPTIONS answered with %v", sent.last())
+	}
+	if len(ls.requests) != 1 {
+		t.Errorf("%d requests given to the listener", len(ls.requests))
+	}
+
+	//those of the call are not, and the provider stops once it ended
+	bye := newServerTestRequest(BYE, "TCP", "z9hG4bKdown3")
+	bye.GetHeader().Set("To", "<sip:bob@example.com>;tag=down")
+	bye.GetHeader().Set("Cseq", "2 BYE")
+	p.processMessage(bye)
+	if len(ls.requests) != 2 {
+		t.Fatal("BYE refused")
+	}
+	select {
+	case <-done:
+		t.Fatal("stopped with a transaction in progress")
+	case <-time.After(3 * SHUTDOWN_POLL):
+	}
+	if err := ls.transactions[1].SendResponse(CreateResponse(bye, OK)); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(TIMER_D)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("provider still running")
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	captureSends(p)
+	go p.Run()
+	p.processMessage(newServerTestRequest(OPTIONS, "TCP", "z9hG4bKdown4"))
+
+	//the transaction left unanswered is closed at the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 2*SHUTDOWN_POLL)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown: %v", err)
+	}
+	select {
+	case <-p.stopped:
+	default:
+		t.Error("provider not stopped")
+	}
+
+	s := newStack(TraceOff())
+	s.CreateProvider()
+	if err := s.Stop(context.Background()); err != nil {
+		t.Errorf("stack Stop: %v", err)
+	}
+}
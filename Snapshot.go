@@ -0,0 +1,318 @@
+package sip
+
+import (
+	"bytes"
+	"io"
+	"sip/address"
+	"sip/header"
+	"sync"
+)
+
+// With message snapshots on, see Provider.SetMessageSnapshots, each
+// Listener is handed a view of the message of an event of its own, so that
+// what a Listener changes is seen neither by the next Listeners nor by the
+// transactions and caches of the provider, which keep the message as
+// received. A view shares the message until it is written to: the first
+// setter, or GetHeader and the typed header getters, whose results can be
+// changed in place, make it a copy of its own. Its body can be read any
+// number of times.
+
+////////////////////Implementation////////////////////////
+
+type messageView struct {
+	mutex  sync.Mutex
+	shared Message
+	own    Message
+	body   []byte
+
+	copy func() Message
+}
+
+func (this *messageView) get() Message {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.own != nil {
+		return this.own
+	}
+	return this.shared
+}
+
+// writable returns the copy of the view, made on first use.
+func (this *messageView) writable() Message {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.own == nil {
+		this.own = this.copy()
+	}
+	return this.own
+}
+
+func (this *messageView) StartLineWrite(w io.Writer) error {
+	return this.get().StartLineWrite(w)
+}
+
+func (this *messageView) GetSIPVersion() string {
+	return this.get().GetSIPVersion()
+}
+
+func (this *messageView) SetSIPVersion(s string) error {
+	return this.writable().SetSIPVersion(s)
+}
+
+func (this *messageView) GetHeader() Header {
+	return this.writable().GetHeader()
+}
+
+func (this *messageView) SetHeader(h Header) {
+	this.writable().SetHeader(h)
+}
+
+func (this *messageView) GetContentLength() int64 {
+	return this.get().GetContentLength()
+}
+
+func (this *messageView) SetContentLength(l int64) {
+	this.writable().SetContentLength(l)
+}
+
+// GetBody returns a new reader of the body as received until another body
+// is set.
+func (this *messageView) GetBody() io.Reader {
+	this.mutex.Lock()
+	own := this.own
+	this.mutex.Unlock()
+
+	if own != nil {
+		return own.GetBody()
+	}
+	if this.body == nil {
+		return nil
+	}
+	return bytes.NewReader(this.body)
+}
+
+func (this *messageView) SetBody(body io.Reader) {
+	this.writable().SetBody(body)
+}
+
+func (this *messageView) GetBodyBytes() []byte {
+	this.mutex.Lock()
+	own := this.own
+	this.mutex.Unlock()
+
+	if own != nil {
+		return own.GetBodyBytes()
+	}
+	return this.body
+}
+
+func (this *messageView) SetBodyBytes(body []byte) {
+	this.writable().SetBodyBytes(body)
+}
+
+func (this *messageView) Write(w io.Writer) error {
+	return this.writable().Write(w)
+}
+
+func (this *messageView) WriteWithOptions(w io.Writer, options *WriteOptions) error {
+	return this.writable().WriteWithOptions(w, options)
+}
+
+func (this *messageView) GetSize(options *WriteOptions) int {
+	return this.get().GetSize(options)
+}
+
+func (this *messageView) GetMessageInfo() *MessageInfo {
+	return this.writable().GetMessageInfo()
+}
+
+func (this *messageView) SetMessageInfo(info *MessageInfo) {
+	this.writable().SetMessageInfo(info)
+}
+
+func (this *messageView) GetVia() []*header.Via {
+	return this.writable().GetVia()
+}
+
+func (this *messageView) SetVia(vias []*header.Via) {
+	this.writable().SetVia(vias)
+}
+
+func (this *messageView) GetFrom() *header.From {
+	return this.writable().GetFrom()
+}
+
+func (this *messageView) SetFrom(from *header.From) {
+	this.writable().SetFrom(from)
+}
+
+func (this *messageView) GetTo() *header.To {
+	return this.writable().GetTo()
+}
+
+func (this *messageView) SetTo(to *header.To) {
+	this.writable().SetTo(to)
+}
+
+func (this *messageView) GetCSeq() *header.CSeq {
+	return this.writable().GetCSeq()
+}
+
+func (this *messageView) SetCSeq(cseq *header.CSeq) {
+	this.writable().SetCSeq(cseq)
+}
+
+func (this *messageView) GetCallID() *header.CallID {
+	return this.writable().GetCallID()
+}
+
+func (this *messageView) SetCallID(callID *header.CallID) {
+	this.writable().SetCallID(callID)
+}
+
+func (this *messageView) AddHeader(h header.Header) {
+	this.writable().AddHeader(h)
+}
+
+// GetHeaders parses new header objects on every call, which can be changed
+// freely.
+func (this *messageView) GetHeaders(name string) ([]header.Header, error) {
+	return this.get().GetHeaders(name)
+}
+
+func (this *messageView) RemoveHeader(name string) {
+	this.writable().RemoveHeader(name)
+}
+
+func (this *messageView) GetHeaderNames() []string {
+	return this.get().GetHeaderNames()
+}
+
+type requestView struct {
+	messageView
+}
+
+// newRequestView returns a view of req, whose body is body.
+func newRequestView(req Request, body []byte) *requestView {
+	this := &requestView{}
+
+	this.shared = req
+	this.body = body
+	this.copy = func() Message {
+		return copyRequest(req, body)
+	}
+
+	return this
+}
+
+func (this *requestView) GetMethod() string {
+	return this.get().(Request).GetMethod()
+}
+
+func (this *requestView) SetMethod(method string) error {
+	return this.writable().(Request).SetMethod(method)
+}
+
+func (this *requestView) GetRequestURI() address.URI {
+	return this.get().(Request).GetRequestURI()
+}
+
+func (this *requestView) SetRequestURI(uri address.URI) error {
+	return this.writable().(Request).SetRequestURI(uri)
+}
+
+func (this *requestView) GetRequestURIString() string {
+	return this.get().(Request).GetRequestURIString()
+}
+
+func (this *requestView) SetRequestURIString(uri string) error {
+	return this.writable().(Request).SetRequestURIString(uri)
+}
+
+type responseView struct {
+	messageView
+}
+
+// newResponseView returns a view of resp, whose body is body.
+func newResponseView(resp Response, body []byte) *responseView {
+	this := &responseView{}
+
+	this.shared = resp
+	this.body = body
+	this.copy = func() Message {
+		return copyResponse(resp, body)
+	}
+
+	return this
+}
+
+func (this *responseView) GetStatusCode() int {
+	return this.get().(Response).GetStatusCode()
+}
+
+func (this *responseView) SetStatusCode(statusCode int) error {
+	return this.writable().(Response).SetStatusCode(statusCode)
+}
+
+func (this *responseView) GetReasonPhrase() string {
+	return this.get().(Response).GetReasonPhrase()
+}
+
+func (this *responseView) SetReasonPhrase(reasonPhrase string) error {
+	return this.writable().(Response).SetReasonPhrase(reasonPhrase)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// readBody returns the body of msg.
+func readBody(msg Message) []byte {
+	return msg.GetBodyBytes()
+}
+
+// copyRequest returns a copy of req with body, sharing none of its
+// headers.
+func copyRequest(req Request, body []byte) *request {
+	c := NewRequest(req.GetMethod(), req.GetRequestURIString(), nil)
+	copyMessage(&c.message, req, body)
+	return c
+}
+
+// copyResponse returns a copy of resp with body.
+func copyResponse(resp Response, body []byte) *response {
+	c := NewResponse(resp.GetStatusCode(), resp.GetReasonPhrase(), nil)
+	copyMessage(&c.message, resp, body)
+	return c
+}
+
+func copyMessage(m *message, msg Message, body []byte) {
+	m.sipVersion = msg.GetSIPVersion()
+	m.header = msg.GetHeader().clone()
+	if f, ok := msg.(interface{ getFieldNames() []string }); ok {
+		m.names = f.getFieldNames()
+	} else {
+		m.names = msg.GetHeaderNames()
+	}
+	if info := msg.GetMessageInfo(); info != nil {
+		c := *info
+		m.info = &c
+	}
+	if body != nil {
+		m.SetBodyBytes(body)
+	}
+}
+
+// GetMessageSnapshots reports whether the Listeners are handed views of the
+// messages of their own, see SetMessageSnapshots.
+func (this *provider) GetMessageSnapshots() bool {
+	return this.listeners.isViewing()
+}
+
+// SetMessageSnapshots makes the requests and responses given to the
+// Listeners copy-on-write views, one for each Listener, so that a Listener
+// changing a message leaves it as received for the other Listeners and the
+// transactions. It is off by default: the Listeners share the messages.
+func (this *provider) SetMessageSnapshots(on bool) {
+	this.listeners.setViewing(on)
+}
@@ -0,0 +1,93 @@
+package sip
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// editingListener changes the messages it is given, after noting what they
+// were.
+type editingListener struct {
+	seen []string
+}
+
+func (this *editingListener) note(msg Message) {
+	var body []byte
+	if r := msg.GetBody(); r != nil {
+		body, _ = ioutil.ReadAll(r)
+	}
+	this.seen = append(this.seen, msg.GetHeader().Get("Subject")+" "+string(body))
+}
+
+func (this *editingListener) ProcessRequest(requestEvent RequestEvent) {
+	req := requestEvent.GetRequest()
+	this.note(req)
+	req.GetHeader().Set("Subject", "edited")
+	req.SetRequestURIString("sip:carol@example.com")
+	req.SetBody(strings.NewReader("edited"))
+}
+
+func (this *editingListener) ProcessResponse(responseEvent ResponseEvent) {
+	resp := responseEvent.GetResponse()
+	this.note(resp)
+	resp.GetHeader().Set("Subject", "edited")
+	resp.SetStatusCode(BUSY_HERE)
+}
+
+func (this *editingListener) ProcessTimeout(timeoutEvent TimeoutEvent) {
+}
+
+func TestMessageSnapshots(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	go p.Run()
+	defer p.Stop()
+
+	first, second := &editingListener{}, &editingListener{}
+	p.AddListener(first)
+	p.AddListener(second)
+	if p.GetMessageSnapshots() {
+		t.Fatal("snapshots on by default")
+	}
+	p.SetMessageSnapshots(true)
+
+	req := newProxiedRequest(MESSAGE, "sip:bob@example.com", "z9hG4bKsn1")
+	req.GetHeader().Set("Subject", "original")
+	req.SetBody(strings.NewReader("hello"))
+	p.processMessage(req)
+
+	if len(second.seen) != 1 || second.seen[0] != "original hello" {
+		t.Errorf("second listener saw %q", second.seen)
+	}
+	st := p.GetNewServerTransaction(req)
+	if st == nil {
+		t.Fatal("no server transaction")
+	}
+	received := st.GetRequest()
+	body, _ := ioutil.ReadAll(received.GetBody())
+	if received.GetHeader().Get("Subject") != "original" || received.GetRequestURIString() != "sip:bob@example.com" || string(body) != "hello" {
+		t.Errorf("transaction request %q %q %q", received.GetHeader().Get("Subject"), received.GetRequestURIString(), body)
+	}
+
+	invite := newProxiedRequest(INVITE, "sip:bob@example.com", "z9hG4bKsn2")
+	p.GetNewClientTransaction(invite)
+	resp := CreateResponse(invite, RINGING)
+	resp.GetHeader().Set("Subject", "original")
+	p.processMessage(resp)
+	if len(second.seen) != 2 || second.seen[1] != "original " {
+		t.Errorf("second listener saw %q", second.seen)
+	}
+	if resp.GetStatusCode() != RINGING || resp.GetHeader().Get("Subject") != "original" {
+		t.Errorf("response changed to %d %q", resp.GetStatusCode(), resp.GetHeader().Get("Subject"))
+	}
+
+	//off, the listeners share the message
+	p.SetMessageSnapshots(false)
+	shared := newProxiedRequest(MESSAGE, "sip:bob@example.com", "z9hG4bKsn3")
+	shared.GetHeader().Set("Subject", "original")
+	p.processMessage(shared)
+	if len(second.seen) != 3 || second.seen[2] != "edited edited" {
+		t.Errorf("second listener saw %q", second.seen)
+	}
+}
@@ -1,6 +1,7 @@
 package sip
 
 import (
+	"context"
 	"crypto/tls"
 )
 
@@ -15,8 +16,15 @@ type Stack interface {
 	GetProviders() []Provider
 	DeleteProvider(p Provider)
 
+	GetClock() Clock
+	SetClock(Clock)
+
+	GetAllowedMethods() []string
+	SetAllowedMethods([]string)
+	Capabilities() Capabilities
+
 	Run()
-	Stop()
+	Stop(ctx context.Context) error
 }
 
 ////////////////////Implementation////////////////////////
@@ -34,6 +42,9 @@ type stack struct {
 	transports map[Transport]*transport
 	providers  map[Provider]*provider
 	tracer     Tracer
+	clock      Clock
+
+	allowedMethods []string
 }
 
 func newStack(tracer Tracer) Stack {
@@ -42,6 +53,7 @@ func newStack(tracer Tracer) Stack {
 	this.transports = make(map[Transport]*transport)
 	this.providers = make(map[Provider]*provider)
 	this.tracer = tracer
+	this.clock = RealClock
 
 	return this
 }
@@ -71,7 +83,8 @@ func (this *stack) DeleteTransport(t Transport) {
 }
 
 func (this *stack) CreateProvider() Provider {
-	p := newProvider(this.tracer)
+	p := newProvider(this.tracer, this.clock)
+	p.SetAllowedMethods(this.allowedMethods)
 
 	this.providers[p] = p
 
@@ -94,14 +107,63 @@ func (this *stack) DeleteProvider(p Provider) {
 	delete(this.providers, p)
 }
 
+func (this *stack) GetClock() Clock {
+	return this.clock
+}
+
+// SetClock replaces the Clock of the stack and of its providers.
+func (this *stack) SetClock(clock Clock) {
+	this.clock = clock
+	for _, p := range this.providers {
+		p.SetClock(clock)
+	}
+}
+
+func (this *stack) GetAllowedMethods() []string {
+	return this.allowedMethods
+}
+
+// SetAllowedMethods configures the supported methods of the stack and of
+// its providers, see Provider.SetAllowedMethods.
+func (this *stack) SetAllowedMethods(methods []string) {
+	this.allowedMethods = methods
+	for _, p := range this.providers {
+		p.SetAllowedMethods(methods)
+	}
+}
+
+// Capabilities returns a snapshot of the capabilities of the stack: its
+// allowed methods, and those of each of its providers, see
+// Provider.Capabilities.
+func (this *stack) Capabilities() Capabilities {
+	capabilities := Capabilities{Methods: append([]string(nil), this.allowedMethods...)}
+	for _, p := range this.providers {
+		capabilities = capabilities.merge(p.Capabilities())
+	}
+	return capabilities
+}
+
 func (this *stack) Run() {
 	for _, p := range this.providers {
 		go p.Run()
 	}
 }
 
-func (this *stack) Stop() {
+// Stop shuts the providers of the stack down gracefully, see
+// Provider.Shutdown, all at once, and returns the first error. A done ctx
+// stops them at once.
+func (this *stack) Stop(ctx context.Context) error {
+	errs := make(chan error, len(this.providers))
 	for _, p := range this.providers {
-		p.Stop()
+		p := p
+		go func() { errs <- p.Shutdown(ctx) }()
+	}
+
+	var err error
+	for range this.providers {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
 	}
+	return err
 }
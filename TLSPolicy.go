@@ -0,0 +1,128 @@
+package sip
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"strings"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A TLSPolicy tightens the TLS and WSS connections of a transport beyond
+// its tls.Config: the lowest version negotiated, whether a listening
+// transport requires the certificate of its clients (mutual TLS), the SIP
+// domain the servers dialed must prove (RFC 5922 7.3) and a hook seeing
+// the SIP domains of every peer certificate. It must be set before Listen
+// or Dial; the connections the provider dials through the transport follow
+// it too.
+type TLSPolicy struct {
+	//the lowest TLS version, e.g. tls.VersionTLS13; the default of
+	//crypto/tls if 0
+	MinVersion uint16
+
+	//the client certificates a listening transport asks for, verified
+	//against the ClientCAs of its tls.Config
+	ClientAuth tls.ClientAuthType
+
+	//the SIP domain the certificate of the servers dialed must be for, by
+	//its sip URI or DNS subjectAltNames (RFC 5922 7.1), and sent as the
+	//server name; if empty, the host dialed as crypto/tls does
+	ServerName string
+
+	//called with the SIP domains of the certificate of the peer, server
+	//or client, once it is verified; an error fails the handshake
+	VerifyPeer func(domains []string, state tls.ConnectionState) error
+}
+
+var ErrSIPDomainMismatch = errors.New("sip: certificate is not valid for the SIP domain")
+
+////////////////////Implementation////////////////////////
+
+// GetSIPDomains returns the SIP domains cert is valid for (RFC 5922 7.1):
+// those of its sip URI subjectAltNames, and its DNS ones, or its common
+// name if it has neither.
+func GetSIPDomains(cert *x509.Certificate) []string {
+	var domains []string
+	for _, uri := range cert.URIs {
+		if strings.EqualFold(uri.Scheme, "sip") && uri.Opaque != "" && !strings.Contains(uri.Opaque, "@") {
+			domains = append(domains, strings.ToLower(uri.Opaque))
+		}
+	}
+	for _, name := range cert.DNSNames {
+		domains = append(domains, strings.ToLower(name))
+	}
+	if len(domains) == 0 && len(cert.URIs) == 0 && cert.Subject.CommonName != "" {
+		domains = append(domains, strings.ToLower(cert.Subject.CommonName))
+	}
+	return domains
+}
+
+// VerifySIPDomain checks that cert is valid for the SIP domain domain,
+// compared case-insensitively and without wildcards (RFC 5922 7.2).
+func VerifySIPDomain(cert *x509.Certificate, domain string) error {
+	for _, d := range GetSIPDomains(cert) {
+		if strings.EqualFold(d, domain) {
+			return nil
+		}
+	}
+	return ErrSIPDomainMismatch
+}
+
+// clientConfig returns tlsc, nil for the defaults, as the policy dials with
+// it.
+func (this TLSPolicy) clientConfig(tlsc *tls.Config) *tls.Config {
+	c := this.config(tlsc)
+	if this.ServerName == "" {
+		c.VerifyConnection = this.verifyPeer
+		return c
+	}
+	//crypto/tls only knows DNS names, so the chain is verified here
+	c.ServerName = this.ServerName
+	c.InsecureSkipVerify = true
+	roots := c.RootCAs
+	c.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return ErrSIPDomainMismatch
+		}
+		opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+		for _, cert := range state.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		if _, err := state.PeerCertificates[0].Verify(opts); err != nil {
+			return err
+		}
+		if err := VerifySIPDomain(state.PeerCertificates[0], this.ServerName); err != nil {
+			return err
+		}
+		return this.verifyPeer(state)
+	}
+	return c
+}
+
+// serverConfig returns tlsc, nil for the defaults, as the policy listens
+// with it.
+func (this TLSPolicy) serverConfig(tlsc *tls.Config) *tls.Config {
+	c := this.config(tlsc)
+	c.ClientAuth = this.ClientAuth
+	c.VerifyConnection = this.verifyPeer
+	return c
+}
+
+func (this TLSPolicy) config(tlsc *tls.Config) *tls.Config {
+	c := &tls.Config{}
+	if tlsc != nil {
+		c = tlsc.Clone()
+	}
+	if this.MinVersion != 0 {
+		c.MinVersion = this.MinVersion
+	}
+	return c
+}
+
+func (this TLSPolicy) verifyPeer(state tls.ConnectionState) error {
+	if this.VerifyPeer == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return this.VerifyPeer(GetSIPDomains(state.PeerCertificates[0]), state)
+}
@@ -0,0 +1,132 @@
+package sip
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newTestCertificate(t *testing.T, name string, uris ...string) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, uri := range uris {
+		u, _ := url.Parse(uri)
+		template.URIs = append(template.URIs, u)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+func TestSIPDomains(t *testing.T) {
+	_, cert := newTestCertificate(t, "cn.example.com", "sip:Example.com", "sip:alice@example.com", "https://example.net")
+	cert.DNSNames = []string{"sip.example.com"}
+	if v := GetSIPDomains(cert); len(v) != 2 || v[0] != "example.com" || v[1] != "sip.example.com" {
+		t.Errorf("domains %v", v)
+	}
+	if VerifySIPDomain(cert, "EXAMPLE.com") != nil || VerifySIPDomain(cert, "cn.example.com") != ErrSIPDomainMismatch {
+		t.Error("domain verified against the common name")
+	}
+
+	//the common name counts only without subjectAltNames
+	_, cert = newTestCertificate(t, "cn.example.com")
+	if VerifySIPDomain(cert, "cn.example.com") != nil {
+		t.Error("common name not verified")
+	}
+}
+
+func TestTLSPolicy(t *testing.T) {
+	serverCert, serverX509 := newTestCertificate(t, "proxy", "sip:example.com")
+	clientCert, clientX509 := newTestCertificate(t, "client", "sip:client.example.org")
+	roots, clientCAs := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(serverX509)
+	clientCAs.AddCert(clientX509)
+
+	server := newTransport(TLS, "127.0.0.1", 0, &tls.Config{Certificates: []tls.Certificate{serverCert}, ClientCAs: clientCAs})
+	peers := make(chan []string, 4)
+	server.SetTLSPolicy(TLSPolicy{
+		MinVersion: tls.VersionTLS13,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		VerifyPeer: func(domains []string, state tls.ConnectionState) error {
+			peers <- domains
+			return nil
+		},
+	})
+	if err := server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.lner.Close()
+	go func() {
+		for {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	port := server.lner.Addr().(*net.TCPAddr).Port
+	dial := func(policy TLSPolicy, certs ...tls.Certificate) error {
+		client := newTransport(TLS, "127.0.0.1", port, &tls.Config{RootCAs: roots, Certificates: certs})
+		client.SetTLSPolicy(policy)
+		conn, err := client.Dial()
+		if err == nil {
+			//the server verifies the client after the client is done
+			_, err = conn.Read(make([]byte, 1))
+			conn.Close()
+		}
+		return err
+	}
+
+	//the server is verified against the SIP domain, the client by the server
+	if err := dial(TLSPolicy{ServerName: "example.com"}, clientCert); err != io.EOF {
+		t.Fatalf("mutual TLS: %v", err)
+	}
+	select {
+	case domains := <-peers:
+		if len(domains) != 1 || domains[0] != "client.example.org" {
+			t.Errorf("client domains %v", domains)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("client certificate not verified")
+	}
+
+	if err := dial(TLSPolicy{ServerName: "example.net"}, clientCert); !errors.Is(err, ErrSIPDomainMismatch) {
+		t.Errorf("wrong domain: %v", err)
+	}
+	if err := dial(TLSPolicy{ServerName: "example.com"}); err == nil || err == io.EOF {
+		t.Errorf("no client certificate: %v", err)
+	}
+
+	//nor below the lowest version
+	config := &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{clientCert}, MaxVersion: tls.VersionTLS12}
+	if _, err := tls.Dial("tcp", server.lner.Addr().String(), config); err == nil {
+		t.Error("TLS 1.2 client accepted")
+	}
+}
@@ -1,5 +1,8 @@
 package sip
 
+// The timeouts of a TimeoutEvent: a message of the transaction could not
+// be sent, or sent again, for a transport error, or the transaction expired
+// (Timer B, F or H).
 const (
 	TIMEOUT_RETRANSMIT  = iota //0
 	TIMEOUT_TRANSACTION        //1
@@ -3,6 +3,7 @@ package sip
 type TimeoutEvent struct {
 	transaction Transaction
 	timeout     Timeout
+	err         error
 }
 
 func NewTimeoutEvent(transaction Transaction, timeout Timeout) *TimeoutEvent {
@@ -12,6 +13,18 @@ func NewTimeoutEvent(transaction Transaction, timeout Timeout) *TimeoutEvent {
 	}
 }
 
+// newErrorTimeoutEvent returns the event of transaction ending with err: a
+// TIMEOUT_TRANSACTION for a *TimeoutError, a TIMEOUT_RETRANSMIT otherwise.
+func newErrorTimeoutEvent(transaction Transaction, err error) *TimeoutEvent {
+	timeout := TIMEOUT_RETRANSMIT
+	if _, ok := err.(*TimeoutError); ok {
+		timeout = TIMEOUT_TRANSACTION
+	}
+	this := NewTimeoutEvent(transaction, *NewTimeout(timeout))
+	this.err = err
+	return this
+}
+
 func (this *TimeoutEvent) GetTransaction() Transaction {
 	return this.transaction
 }
@@ -24,3 +37,9 @@ func (this *TimeoutEvent) IsServerTransaction() bool {
 func (this *TimeoutEvent) GetTimeout() Timeout {
 	return this.timeout
 }
+
+// GetError returns what ended the transaction, a *TimeoutError or a
+// *TransportError, or nil if unknown.
+func (this *TimeoutEvent) GetError() error {
+	return this.err
+}
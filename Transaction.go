@@ -1,5 +1,12 @@
 package sip
 
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
 type Transaction interface {
 	GetDialog() Dialog
 	GetState() TransactionState
@@ -7,6 +14,7 @@ type Transaction interface {
 	SetRetransmitTimer(retransmitTimer int)
 	GetBranchId() string
 	GetRequest() Request
+	GetStats() TransactionStats
 	Close()
 }
 
@@ -19,6 +27,20 @@ const (
 	TRANSACTIONSTATE_COMPLETED                          //3
 	TRANSACTIONSTATE_CONFIRMED                          //4
 	TRANSACTIONSTATE_TERMINATED                         //5
+	TRANSACTIONSTATE_RESOLVING                          //6
+)
+
+// Timer values of RFC 3261 17.1.1.1 and Table 4. T1 is the round-trip time
+// estimate, T2 the longest retransmission interval of a non-INVITE request
+// and T4 the time a message may stay in the network.
+const (
+	TIMER_T1 = 500 * time.Millisecond
+	TIMER_T2 = 4 * time.Second
+	TIMER_T4 = 5 * time.Second
+
+	TIMER_D = 32 * time.Second
+	TIMER_I = TIMER_T4
+	TIMER_K = TIMER_T4
 )
 
 ///////////////////////////////////////////////////////////////
@@ -29,18 +51,36 @@ type transaction struct {
 	branchId         string
 	request          Request
 	quit             chan bool
+
+	stateMutex sync.Mutex
+	stats      TransactionStats
+
+	//the request is forwarded by a proxy, which forms no dialog
+	proxied bool
 }
 
 func (this *transaction) GetDialog() Dialog {
+	this.stateMutex.Lock()
+	defer this.stateMutex.Unlock()
+
 	return this.dialog
 }
 func (this *transaction) SetDialog(dialog Dialog) {
+	this.stateMutex.Lock()
+	defer this.stateMutex.Unlock()
+
 	this.dialog = dialog
 }
 func (this *transaction) GetState() TransactionState {
+	this.stateMutex.Lock()
+	defer this.stateMutex.Unlock()
+
 	return this.transactionState
 }
 func (this *transaction) SetState(transactionState TransactionState) {
+	this.stateMutex.Lock()
+	defer this.stateMutex.Unlock()
+
 	this.transactionState = transactionState
 }
 func (this *transaction) GetRetransmitTimer() int {
@@ -58,6 +98,252 @@ func (this *transaction) SetBranchId(branchId string) {
 func (this *transaction) GetRequest() Request {
 	return this.request
 }
+func (this *transaction) isProxied() bool {
+	this.stateMutex.Lock()
+	defer this.stateMutex.Unlock()
+
+	return this.proxied
+}
+
+func (this *transaction) setProxied() {
+	this.stateMutex.Lock()
+	defer this.stateMutex.Unlock()
+
+	this.proxied = true
+}
+func (this *transaction) GetStats() TransactionStats {
+	this.stateMutex.Lock()
+	defer this.stateMutex.Unlock()
+
+	return this.stats
+}
+
+// resetStats clears the statistics of a transaction started anew.
+func (this *transaction) resetStats() {
+	this.stateMutex.Lock()
+	defer this.stateMutex.Unlock()
+
+	this.stats = TransactionStats{}
+}
+
+// recordSent notes the first transmission of the request.
+func (this *transaction) recordSent(now time.Time) {
+	this.stateMutex.Lock()
+	defer this.stateMutex.Unlock()
+
+	this.stats.Sent = now
+}
+
+func (this *transaction) recordRetransmission() {
+	this.stateMutex.Lock()
+	defer this.stateMutex.Unlock()
+
+	this.stats.Retransmissions++
+}
+
+// recordResponse measures the RTT on the first response, which it reports
+// by returning true.
+func (this *transaction) recordResponse(now time.Time) bool {
+	this.stateMutex.Lock()
+	defer this.stateMutex.Unlock()
+
+	if this.stats.Sent.IsZero() || this.stats.RTT > 0 {
+		return false
+	}
+	if this.stats.RTT = now.Sub(this.stats.Sent); this.stats.RTT <= 0 {
+		this.stats.RTT = time.Nanosecond
+	}
+	return true
+}
+
 func (this *transaction) Close() {
-	close(this.quit)
+	this.stateMutex.Lock()
+	defer this.stateMutex.Unlock()
+
+	select {
+	case <-this.quit:
+		//already closed
+	default:
+		close(this.quit)
+	}
+}
+
+// getT1 returns the round-trip time estimate of the transaction: its
+// retransmit timer, in milliseconds, or TIMER_T1 if none was set.
+func (this *transaction) getT1() time.Duration {
+	if this.retransmitTimer > 0 {
+		return time.Duration(this.retransmitTimer) * time.Millisecond
+	}
+	return TIMER_T1
+}
+
+// getTransactionKey identifies the client transaction of a message by the
+// branch of its top Via and the method of its CSeq (RFC 3261 17.1.3).
+func getTransactionKey(msg Message) string {
+	return getBranch(msg) + " " + getCSeqMethod(msg)
+}
+
+// getServerTransactionKey identifies the server transaction of msg (RFC 3261
+// 17.2.3): by the branch and sent-by of its top Via and the method of its
+// CSeq when the branch starts with the magic cookie, otherwise, for a
+// request from an RFC 2543 element, by its Request-URI, From tag, Call-ID,
+// CSeq number and top Via. The To tag is checked apart, see
+// matchesServerTransaction. The ACK of a non-2xx final response belongs to
+// the INVITE transaction. A response from an RFC 2543 transaction has no
+// key.
+func getServerTransactionKey(msg Message) string {
+	method := getCSeqMethod(msg)
+	if method == ACK {
+		method = INVITE
+	}
+	return getServerTransactionKeyOf(msg, method)
+}
+
+// getServerTransactionKeyOf returns the key of the server transaction of
+// the request of method msg belongs to, such as the INVITE of a CANCEL.
+func getServerTransactionKeyOf(msg Message, method string) string {
+	branch := getBranch(msg)
+	if strings.HasPrefix(branch, BRANCH_MAGIC_COOKIE) {
+		return branch + " " + getSentBy(msg) + " " + method
+	}
+
+	req, ok := msg.(Request)
+	if !ok {
+		return ""
+	}
+	cseq, _ := getCSeq(req)
+	h := req.GetHeader()
+	return strings.Join([]string{
+		req.GetRequestURIString(),
+		getTag(h.Get("From")),
+		h.Get("Call-Id"),
+		strconv.Itoa(cseq),
+		method,
+		getTopVia(req),
+	}, " ")
+}
+
+// matchesServerTransaction checks the To tag of a request matched to st by
+// the RFC 2543 rules: a retransmission carries the To tag of the original
+// request, an ACK the To tag of the response it acknowledges.
+func matchesServerTransaction(st *serverTransaction, req Request) bool {
+	if strings.HasPrefix(getBranch(req), BRANCH_MAGIC_COOKIE) {
+		return true
+	}
+
+	tag := getTag(req.GetHeader().Get("To"))
+	if req.GetMethod() == ACK {
+		st.mutex.Lock()
+		resp := st.response
+		st.mutex.Unlock()
+		return resp != nil && getTag(resp.GetHeader().Get("To")) == tag
+	}
+	return getTag(st.GetRequest().GetHeader().Get("To")) == tag
+}
+
+// getCSeqMethod returns the method of the CSeq header of msg.
+func getCSeqMethod(msg Message) string {
+	if fields := strings.Fields(msg.GetHeader().Get("Cseq")); len(fields) == 2 {
+		return fields[1]
+	}
+	return ""
+}
+
+// getTopVia returns the top Via of msg.
+func getTopVia(msg Message) string {
+	via := msg.GetHeader().Get("Via")
+	if i := strings.Index(via, ","); i >= 0 {
+		via = via[:i]
+	}
+	return strings.TrimSpace(via)
+}
+
+// setViaTransport sets the transport of the top Via of msg, keeping its
+// protocol name and version.
+func setViaTransport(msg Message, transport string) {
+	vias := msg.GetHeader()["Via"]
+	if len(vias) == 0 || transport == "" {
+		return
+	}
+	via := strings.TrimLeft(vias[0], " \t")
+	i := strings.IndexAny(via, " \t")
+	if i < 0 {
+		return
+	}
+	protocol := strings.Split(via[:i], "/")
+	if len(protocol) != 3 || strings.EqualFold(protocol[2], transport) {
+		return
+	}
+	protocol[2] = strings.ToUpper(transport)
+	vias[0] = strings.Join(protocol, "/") + via[i:]
+}
+
+// setViaBranch sets the branch of the top Via of msg.
+func setViaBranch(msg Message, branch string) {
+	vias := msg.GetHeader()["Via"]
+	if len(vias) == 0 {
+		return
+	}
+	via, rest := vias[0], ""
+	if i := strings.Index(via, ","); i >= 0 {
+		via, rest = via[:i], via[i:]
+	}
+	via = strings.TrimSpace(via)
+	if i := strings.Index(strings.ToLower(via), ";branch="); i >= 0 {
+		if end := strings.IndexByte(via[i+1:], ';'); end < 0 {
+			via = via[:i]
+		} else {
+			via = via[:i] + via[i+1+end:]
+		}
+	}
+	vias[0] = via + ";branch=" + branch + rest
+}
+
+// isReliable reports whether msg travels over a reliable transport, by the
+// transport of its top Via: every transport but UDP is.
+func isReliable(msg Message) bool {
+	fields := strings.Fields(getTopVia(msg))
+	if len(fields) == 0 {
+		return false
+	}
+	protocol := strings.Split(fields[0], "/")
+	return len(protocol) == 3 && !strings.EqualFold(protocol[2], UDP)
+}
+
+// getSentBy returns the sent-by of the top Via of msg, in lower case.
+func getSentBy(msg Message) string {
+	fields := strings.Fields(getTopVia(msg))
+	if len(fields) < 2 {
+		return ""
+	}
+	sentBy := strings.Join(fields[1:], "")
+	if i := strings.Index(sentBy, ";"); i >= 0 {
+		sentBy = sentBy[:i]
+	}
+	return strings.ToLower(sentBy)
+}
+
+// getTag returns the tag parameter of a From or To header value.
+func getTag(value string) string {
+	if i := strings.LastIndex(value, ">"); i >= 0 {
+		value = value[i+1:]
+	}
+	for _, param := range strings.Split(value, ";")[1:] {
+		if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && strings.EqualFold(kv[0], "tag") {
+			return kv[1]
+		}
+	}
+	return ""
+}
+
+// getBranch returns the branch parameter of the top Via of msg.
+func getBranch(msg Message) string {
+	via := getTopVia(msg)
+
+	for _, param := range strings.Split(via, ";")[1:] {
+		if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && strings.EqualFold(kv[0], "branch") {
+			return kv[1]
+		}
+	}
+	return ""
 }
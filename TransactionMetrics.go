@@ -0,0 +1,94 @@
+package sip
+
+import (
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// TransactionStats are the transmission statistics of one transaction.
+// RTT runs from the first transmission of the request to its first
+// response, provisional or final, and stays zero until then.
+type TransactionStats struct {
+	Sent            time.Time
+	Retransmissions int
+	RTT             time.Duration
+}
+
+// TransactionMetrics aggregate the statistics of the client transactions of
+// a provider. Following Karn's algorithm, only the RTT of transactions
+// answered before any retransmission is sampled, since the response of a
+// retransmitted request cannot be told apart from that of the original.
+type TransactionMetrics struct {
+	Transactions    uint64
+	Retransmissions uint64
+	Timeouts        uint64
+
+	RTTSamples uint64
+	RTTSum     time.Duration
+	MinRTT     time.Duration
+	MaxRTT     time.Duration
+}
+
+// MeanRTT returns the mean of the sampled RTTs, or zero without samples.
+func (this TransactionMetrics) MeanRTT() time.Duration {
+	if this.RTTSamples == 0 {
+		return 0
+	}
+	return this.RTTSum / time.Duration(this.RTTSamples)
+}
+
+////////////////////Implementation////////////////////////
+
+type transactionMetrics struct {
+	mutex sync.Mutex
+	TransactionMetrics
+}
+
+func (this *transactionMetrics) get() TransactionMetrics {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.TransactionMetrics
+}
+
+func (this *transactionMetrics) addTransaction() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.Transactions++
+}
+
+func (this *transactionMetrics) addRetransmission() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.Retransmissions++
+}
+
+func (this *transactionMetrics) addTimeout() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.Timeouts++
+}
+
+// addRTT samples the RTT of a transaction, unless it was retransmitted.
+func (this *transactionMetrics) addRTT(stats TransactionStats) {
+	if stats.Retransmissions > 0 {
+		return
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.RTTSamples++
+	this.RTTSum += stats.RTT
+	if this.MinRTT == 0 || stats.RTT < this.MinRTT {
+		this.MinRTT = stats.RTT
+	}
+	if stats.RTT > this.MaxRTT {
+		this.MaxRTT = stats.RTT
+	}
+}
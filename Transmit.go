@@ -0,0 +1,305 @@
+package sip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sip/header"
+	"sip/parser"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoTransport  = errors.New("sip: no transport for the destination")
+	ErrNotListening = errors.New("sip: transport is not listening")
+	ErrMissingVia   = errors.New("sip: missing or invalid Via")
+)
+
+// connection is a stream connection of the provider, accepted or dialed,
+// which writes the messages sent to it in turn, see newConnection.
+type connection struct {
+	net.Conn
+
+	queue  chan []byte
+	quit   chan bool
+	done   chan bool //closed when the writer returns
+	once   sync.Once
+	logger Logger
+
+	keepalive *crlfKeepalive //of a dialed connection, if pinged
+
+	//guarded by the mutex of the connectionManager
+	network  string
+	dialed   bool
+	lastUsed time.Time
+	idle     Timer
+}
+
+// getConnectionKey identifies a stream connection by its network and its
+// remote address.
+func getConnectionKey(network string, addr string) string {
+	return network + "/" + addr
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// SendRequest sends req statelessly to its next hop, resolving it first and
+// trying each address found until one can be sent to; a request too large
+// for UDP tries TCP first, and one to a SIPS URI only goes over TLS. Unlike
+// a client transaction, it blocks on DNS.
+func (this *provider) SendRequest(req Request) error {
+	return this.sendRequest(req, nil)
+}
+
+// sendRequest sends req to h, or to its next hop if h is nil.
+func (this *provider) sendRequest(req Request, h Hop) error {
+	return this.sendRequestContext(context.Background(), req, h)
+}
+
+// sendRequestContext sends req as sendRequest does, resolving its next hop
+// under ctx.
+func (this *provider) sendRequestContext(ctx context.Context, req Request, h Hop) error {
+	this.stampAllow(req)
+	this.stampRport(req)
+	this.stampAllowEvents(req)
+	this.stampSupported(req)
+	this.stampAccept(req)
+	this.normalize(req)
+
+	if h == nil {
+		next, err := GetNextHop(req)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(ctx, RESOLVE_TIMEOUT)
+		defer cancel()
+		hops, err := this.GetResolver().Resolve(ctx, next)
+		if err != nil {
+			return err
+		}
+		if len(hops) == 0 {
+			return &net.DNSError{Err: "no addresses", Name: next.GetHost()}
+		}
+		hops = this.getSizedHops(req, hops)
+		if hops, err = this.getSecureHops(req, hops); err != nil {
+			return err
+		}
+		//fail over to the next address when one cannot be sent to, skipping
+		//those down but the last
+		for i, h := range hops {
+			if i < len(hops)-1 && !this.isAvailable(h) {
+				continue
+			}
+			setViaTransport(req, h.GetTransport())
+			if err = this.send(req, h); err == nil {
+				this.reportPeerSuccess(h, 0)
+				return nil
+			}
+			this.reportPeerFailure(h, PEERFAILURE_TRANSPORT)
+		}
+		return err
+	} else if _, err := this.getSecureHops(req, []Hop{h}); err != nil {
+		return err
+	}
+
+	return this.send(req, h)
+}
+
+func (this *provider) SendResponse(resp Response) error {
+	this.stampAllow(resp)
+	this.stampAllowEvents(resp)
+	this.stampSupported(resp)
+	this.stampAccept(resp)
+	this.normalize(resp)
+	this.release(resp)
+	if resp.GetStatusCode() != TRYING {
+		this.cancelTrying(resp)
+	}
+
+	h, err := this.getResponseHop(resp)
+	if err != nil {
+		return err
+	}
+	return this.send(resp, h)
+}
+
+// getResponseHop returns the destination of resp from its top Via (RFC
+// 3261 18.2.2): the maddr if any, else the received address, else the
+// sent-by host, at the rport if any (RFC 3581), else at the sent-by port.
+// Over a reliable transport, the response goes back on the connection of
+// its request when the server transaction knows it.
+func (this *provider) getResponseHop(resp Response) (Hop, error) {
+	value := resp.GetHeader().Get("Via")
+	if value == "" {
+		return nil, ErrMissingVia
+	}
+	sh, err := parser.NewViaParser("Via: " + value + "\n").Parse()
+	if err != nil {
+		return nil, ErrMissingVia
+	}
+	e := sh.(*header.ViaList).Front()
+	if e == nil {
+		return nil, ErrMissingVia
+	}
+	via := e.Value.(*header.Via)
+
+	h := &hop{host: via.GetHost(), port: via.GetPort(), transport: strings.ToLower(via.GetTransport()), ttl: -1}
+	if h.port <= 0 {
+		h.port = getDefaultPort(h.transport, false)
+	}
+	if maddr := via.GetMAddr(); maddr != "" {
+		h.host = maddr
+		h.ttl = via.GetTTL()
+	} else if received := via.GetReceived(); received != "" {
+		h.host = received
+	}
+	if rport, ok := via.GetViaParms().GetValue("rport").(string); ok {
+		if port, err := strconv.Atoi(rport); err == nil && port > 0 {
+			h.port = port
+		}
+	}
+	h.host = strings.Trim(h.host, "[]")
+
+	if h.transport != UDP {
+		this.mutex.Lock()
+		st := this.servers[getServerTransactionKey(resp)]
+		this.mutex.Unlock()
+
+		if st != nil {
+			if info := st.GetRequest().GetMessageInfo(); info != nil && info.RemoteAddr != nil {
+				if host, port, err := net.SplitHostPort(info.RemoteAddr.String()); err == nil {
+					h.host = host
+					h.port, _ = strconv.Atoi(port)
+				}
+			}
+		}
+	}
+	return h, nil
+}
+
+// transmit writes msg to h, over a transport of the provider for the
+// network of h. A datagram leaves from the listening socket of the
+// transport, so that the peer answers to it. A stream reuses the
+// connection to h, accepted or dialed, or dials a new one, served like an
+// accepted one.
+func (this *provider) transmit(msg Message, h Hop) error {
+	t := this.getTransport(h.GetTransport())
+	if t == nil {
+		return ErrNoTransport
+	}
+
+	buffer := bufferPool.Get().(*bytes.Buffer)
+	buffer.Reset()
+	if err := msg.Write(buffer); err != nil {
+		putBuffer(buffer)
+		return err
+	}
+
+	if t.GetNetwork() == UDP {
+		defer putBuffer(buffer)
+		if t.pconn == nil {
+			return ErrNotListening
+		}
+		raddr, err := getUDPAddr(h.GetHost(), h.GetPort())
+		if err != nil {
+			return err
+		}
+		if _, err = t.pconn.WriteTo(buffer.Bytes(), raddr); err == nil {
+			this.captureWire(WIRE_OUT, t.GetNetwork(), t.pconn.LocalAddr(), raddr, buffer.Bytes())
+			this.countMessage(WIRE_OUT, msg)
+		}
+		return err
+	}
+
+	//the queue of the connection keeps the bytes, the buffer is not reused
+	addr := net.JoinHostPort(h.GetHost(), strconv.Itoa(h.GetPort()))
+	conn, err := this.getConnection(t, addr)
+	if err != nil {
+		return err
+	}
+	switch err = conn.send(buffer.Bytes()); err {
+	case nil:
+		this.connections.touch(conn)
+		this.captureWire(WIRE_OUT, t.GetNetwork(), conn.LocalAddr(), conn.RemoteAddr(), buffer.Bytes())
+		this.countMessage(WIRE_OUT, msg)
+	case ErrSendQueueFull:
+		if handler := this.GetOverflowHandler(); handler != nil {
+			handler(t.GetNetwork(), addr, buffer.Bytes())
+		}
+	default:
+		conn.Close()
+		this.removeConnection(t.GetNetwork(), conn)
+	}
+	return err
+}
+
+// bufferPool holds the buffers messages are written to before a datagram
+// is sent, so that a busy UDP server allocates none per message.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// putBuffer returns b to the pool unless it grew past a datagram.
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() <= DATAGRAM_SIZE {
+		bufferPool.Put(b)
+	}
+}
+
+// getUDPAddr returns the address of host and port, only looking host up
+// when it is not an IP address already, as hops mostly are.
+func getUDPAddr(host string, port int) (*net.UDPAddr, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return &net.UDPAddr{IP: ip, Port: port}, nil
+	}
+	return net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+func (this *provider) getTransport(network string) *transport {
+	for _, t := range this.transports {
+		if tr, ok := t.(*transport); ok && strings.EqualFold(tr.GetNetwork(), network) {
+			return tr
+		}
+	}
+	return nil
+}
+
+// getConnection returns the connection to addr over t, dialing it if
+// needed.
+func (this *provider) getConnection(t *transport, addr string) (*connection, error) {
+	if conn := this.connections.get(t.GetNetwork(), addr); conn != nil {
+		return conn, nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	p, _ := strconv.Atoi(port)
+	d := newTransport(t.GetNetwork(), host, p, t.GetTLSConfig())
+	d.SetTLSPolicy(t.GetTLSPolicy())
+	c, err := d.Dial()
+	if err != nil {
+		return nil, err
+	}
+
+	conn := this.connections.add(t.GetNetwork(), c, true, this.GetSendQueueLimit())
+	this.startKeepalive(t.GetNetwork(), addr, conn)
+	this.spawn(resourceServe, func() { this.ServeConn(t, conn) })
+	return conn, nil
+}
+
+// addConnection registers an accepted stream connection for sending.
+func (this *provider) addConnection(network string, c net.Conn) *connection {
+	return this.connections.add(network, c, false, this.GetSendQueueLimit())
+}
+
+func (this *provider) removeConnection(network string, conn net.Conn) {
+	this.connections.remove(network, conn)
+}
@@ -0,0 +1,265 @@
+package sip
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sentMessages records the messages a provider sends instead of writing
+// them to a transport.
+type sentMessages struct {
+	mutex    sync.Mutex
+	messages []Message
+	hops     []Hop
+}
+
+func captureSends(p *provider) *sentMessages {
+	this := &sentMessages{}
+	p.send = func(msg Message, h Hop) error {
+		this.mutex.Lock()
+		defer this.mutex.Unlock()
+
+		this.messages = append(this.messages, msg)
+		this.hops = append(this.hops, h)
+		return nil
+	}
+	return this
+}
+
+func (this *sentMessages) len() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return len(this.messages)
+}
+
+func (this *sentMessages) last() Message {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.messages[len(this.messages)-1]
+}
+
+func (this *sentMessages) get(i int) Message {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.messages[i]
+}
+
+func TestResponseHop(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	sent := captureSends(p)
+
+	req, err := ReadRequest(bufio.NewReader(strings.NewReader(testOptions)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		via  string
+		want string
+	}{
+		{"SIP/2.0/UDP pc33.atlanta.com;branch=z9hG4bK1", "pc33.atlanta.com:5060/udp"},
+		{"SIP/2.0/UDP pc33.atlanta.com:5070;received=192.0.2.1;branch=z9hG4bK1", "192.0.2.1:5070/udp"},
+		{"SIP/2.0/UDP 10.0.0.1:5060;received=192.0.2.1;rport=61000;branch=z9hG4bK1", "192.0.2.1:61000/udp"},
+		{"SIP/2.0/TLS pc33.atlanta.com;branch=z9hG4bK1", "pc33.atlanta.com:5061/tls"},
+		{"SIP/2.0/UDP pc33.atlanta.com;maddr=239.255.255.1;ttl=15;branch=z9hG4bK1", "239.255.255.1:5060/udp"},
+		{"SIP/2.0/UDP [2001:db8::1];branch=z9hG4bK1", "[2001:db8::1]:5060/udp"},
+		{"SIP/2.0/UDP [2001:db8::1]:5070;received=2001:db8::2;rport=61000;branch=z9hG4bK1", "[2001:db8::2]:61000/udp"},
+		{"SIP/2.0/TCP [2001:db8::1]:5070;received=[2001:db8::3];branch=z9hG4bK1", "[2001:db8::3]:5070/tcp"},
+	} {
+		resp := CreateResponse(req, OK)
+		resp.GetHeader().Set("Via", c.via)
+		if err := p.SendResponse(resp); err != nil {
+			t.Fatal(err)
+		}
+		if got := sent.hops[len(sent.hops)-1].String(); got != c.want {
+			t.Errorf("Via %s: sent to %s, want %s", c.via, got, c.want)
+		}
+	}
+
+	resp := CreateResponse(req, OK)
+	resp.GetHeader().Del("Via")
+	if err := p.SendResponse(resp); err != ErrMissingVia {
+		t.Errorf("response without Via: %v", err)
+	}
+}
+
+func TestTransmit(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	udp := newTransport(UDP, "127.0.0.1", 0, nil)
+	tcp := newTransport(TCP, "127.0.0.1", 0, nil)
+	p.AddTransport(udp)
+	p.AddTransport(tcp)
+	if err := udp.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		close(p.quit)
+		udp.pconn.Close()
+		p.waitGroup.Wait()
+	}()
+
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	//a datagram leaves from the listening socket
+	req := NewRequest(OPTIONS, "sip:bob@"+peer.LocalAddr().String(), nil)
+	req.GetHeader().Set("Via", "SIP/2.0/UDP 127.0.0.1;branch=z9hG4bKtx1")
+	req.GetHeader().Set("Cseq", "1 OPTIONS")
+	if err := p.SendRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	peer.SetDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, DATAGRAM_SIZE)
+	n, from, err := peer.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from.String() != udp.pconn.LocalAddr().String() || !strings.HasPrefix(string(buffer[:n]), "OPTIONS sip:bob@") {
+		t.Errorf("received %q from %s", buffer[:n], from)
+	}
+
+	//a stream is dialed once and reused
+	lner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lner.Close()
+	req = NewRequest(OPTIONS, "sip:bob@"+lner.Addr().String()+";transport=tcp", nil)
+	req.GetHeader().Set("Via", "SIP/2.0/TCP 127.0.0.1;branch=z9hG4bKtx2")
+	req.GetHeader().Set("Cseq", "1 OPTIONS")
+	for i := 0; i < 2; i++ {
+		if err := p.SendRequest(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn, err := lner.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	for i := 0; i < 2; i++ {
+		if _, err := ReadMessage(r); err != nil {
+			t.Fatalf("message %d on the dialed connection: %v", i, err)
+		}
+	}
+
+	//no transport for the network
+	req.SetRequestURIString("sip:bob@127.0.0.1;transport=tls")
+	if err := p.SendRequest(req); err != ErrNoTransport {
+		t.Errorf("send over TLS: %v", err)
+	}
+}
+
+func TestTransmitIPv6(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	udp := newTransport(UDP, "[::1]", 0, nil)
+	tcp := newTransport(TCP, "::1", 0, nil)
+	p.AddTransport(udp)
+	p.AddTransport(tcp)
+	if err := udp.Listen(); err != nil {
+		t.Skip("no IPv6 loopback:", err)
+	}
+	defer func() {
+		close(p.quit)
+		udp.pconn.Close()
+		p.waitGroup.Wait()
+	}()
+
+	peer, err := net.ListenPacket("udp", "[::1]:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	req := NewRequest(OPTIONS, "sip:bob@"+peer.LocalAddr().String(), nil)
+	req.GetHeader().Set("Via", "SIP/2.0/UDP [::1];branch=z9hG4bKtx6")
+	req.GetHeader().Set("Cseq", "1 OPTIONS")
+	if err := p.SendRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	peer.SetDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, DATAGRAM_SIZE)
+	n, _, err := peer.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(buffer[:n]), "OPTIONS sip:bob@"+peer.LocalAddr().String()+" SIP/2.0") {
+		t.Errorf("received %q", buffer[:n])
+	}
+
+	lner, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lner.Close()
+	req = NewRequest(OPTIONS, "sip:bob@"+lner.Addr().String()+";transport=tcp", nil)
+	req.GetHeader().Set("Via", "SIP/2.0/TCP [::1];branch=z9hG4bKtx7")
+	req.GetHeader().Set("Cseq", "1 OPTIONS")
+	if err := p.SendRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := lner.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := ReadMessage(bufio.NewReader(conn)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkTransmitUDP(b *testing.B) {
+	p := newProvider(TraceOff(), RealClock)
+	udp := newTransport(UDP, "127.0.0.1", 0, nil)
+	p.AddTransport(udp)
+	if err := udp.Listen(); err != nil {
+		b.Fatal(err)
+	}
+	defer udp.pconn.Close()
+
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer peer.Close()
+	go func() {
+		buffer := make([]byte, DATAGRAM_SIZE)
+		for {
+			if _, _, err := peer.ReadFrom(buffer); err != nil {
+				return
+			}
+		}
+	}()
+
+	//a registrar's 200 to a REGISTER
+	req := NewRequest(REGISTER, "sip:registrar.biloxi.com", nil)
+	h := req.GetHeader()
+	h.Set("Via", "SIP/2.0/UDP bobspc.biloxi.com:5060;branch=z9hG4bKnashds7;received=192.0.2.4")
+	h.Set("From", "Bob <sip:bob@biloxi.com>;tag=456248")
+	h.Set("To", "Bob <sip:bob@biloxi.com>")
+	h.Set("Call-Id", "843817637684230@998sdasdh09")
+	h.Set("Cseq", "1826 REGISTER")
+	resp := CreateResponse(req, OK)
+	resp.GetHeader().Set("Contact", "<sip:bob@192.0.2.4>;expires=7200")
+	resp.GetHeader().Set("Date", "Thu, 01 Jan 2026 00:00:00 GMT")
+	hop := NewHop("127.0.0.1", peer.LocalAddr().(*net.UDPAddr).Port, UDP)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.transmit(resp, hop); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
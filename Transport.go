@@ -3,8 +3,13 @@ package sip
 import (
 	"crypto/tls"
 	"errors"
+	"io"
 	"net"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,10 +20,12 @@ const (
 	TCP  = "tcp"
 	TLS  = "tls"
 	SCTP = "sctp"
+	WS   = "ws"
+	WSS  = "wss"
 )
 
 type Transport interface {
-	GetNetwork() string //""udp", tcp", or "tls"...
+	GetNetwork() string //"udp", "tcp", "tls", "ws" or "wss"
 	GetAddress() string
 	GetPort() int
 	GetTLSConfig() *tls.Config
@@ -27,8 +34,33 @@ type Transport interface {
 
 	Listen() error
 	Accept() (net.Conn, error)
+
+	SetReusePort(reusePort bool)
+	IsReusePort() bool
+
+	SetCertificateReloader(reloader CertificateReloader)
+	SetSessionTicketKeys(keys [][32]byte)
+	SetTLSPolicy(policy TLSPolicy)
+	GetTLSPolicy() TLSPolicy
+
+	SetListenerFile(f *os.File)
+	File() (*os.File, error)
+
+	GetMetrics() TransportMetrics
 }
 
+// TransportMetrics count the traffic of one listener: the connections it
+// accepted, and the messages it read, those it dropped as malformed and
+// the datagrams dropped by the PreParseFilter of the provider.
+type TransportMetrics struct {
+	Connections uint64
+	Messages    uint64
+	Malformed   uint64
+	Filtered    uint64
+}
+
+var ErrReusePortUnsupported = errors.New("sip: SO_REUSEPORT is not supported on this platform")
+
 ////////////////////Implementation////////////////////////
 
 type transport struct {
@@ -36,17 +68,32 @@ type transport struct {
 	address string //for server, it is laddr; for client, it is raddr
 	port    int
 	tlsc    *tls.Config
+	policy  TLSPolicy
 
 	//for server
-	lner net.Listener
-	quit chan bool
+	lner      net.Listener
+	pconn     *datagramConn
+	quit      chan bool
+	stop      sync.Once
+	reusePort bool
+
+	//the listening socket, before TLS, and the one to adopt instead of
+	//binding, see Handover
+	socket net.Listener
+	file   *os.File
+
+	connections uint64
+	messages    uint64
+	malformed   uint64
+	filtered    uint64
 }
 
 func newTransport(network string, address string, port int, tlsc *tls.Config) *transport {
 	this := &transport{}
 
 	this.network = network
-	this.address = address
+	//an IPv6 reference, e.g. [2001:db8::1], is joined to the port again
+	this.address = strings.Trim(address, "[]")
 	this.port = port
 	this.tlsc = tlsc
 
@@ -72,18 +119,100 @@ func (this *transport) GetTLSConfig() *tls.Config {
 	return this.tlsc
 }
 
+// SetReusePort makes Listen set SO_REUSEPORT, so that several transports,
+// each served by its own provider or process, listen on the same address
+// and port and share its load. Every listener on the port must set it.
+func (this *transport) SetReusePort(reusePort bool) {
+	this.reusePort = reusePort
+}
+
+func (this *transport) IsReusePort() bool {
+	return this.reusePort
+}
+
+// SetCertificateReloader makes a TLS transport present the certificate of
+// reloader, which can then be rotated while the transport runs. It must be
+// set before Listen.
+func (this *transport) SetCertificateReloader(reloader CertificateReloader) {
+	if this.tlsc == nil {
+		this.tlsc = &tls.Config{}
+	} else {
+		this.tlsc = this.tlsc.Clone()
+	}
+	this.tlsc.Certificates = nil
+	this.tlsc.GetCertificate = reloader.GetCertificate
+}
+
+// SetSessionTicketKeys sets the keys of the session tickets of a TLS
+// transport, the first one encrypting new tickets; the others still
+// decrypt tickets of earlier keys. Transports sharing a port, or servers
+// behind one address, resume each other's sessions once they share the
+// keys. Unlike the other settings, it takes effect on a running transport.
+func (this *transport) SetSessionTicketKeys(keys [][32]byte) {
+	if this.tlsc == nil {
+		this.tlsc = &tls.Config{}
+	}
+	this.tlsc.SetSessionTicketKeys(keys)
+}
+
+// SetTLSPolicy sets the policy of the TLS and WSS connections of the
+// transport, none by default. It must be set before Listen.
+func (this *transport) SetTLSPolicy(policy TLSPolicy) {
+	this.policy = policy
+}
+
+func (this *transport) GetTLSPolicy() TLSPolicy {
+	return this.policy
+}
+
+func (this *transport) GetMetrics() TransportMetrics {
+	return TransportMetrics{
+		Connections: atomic.LoadUint64(&this.connections),
+		Messages:    atomic.LoadUint64(&this.messages),
+		Malformed:   atomic.LoadUint64(&this.malformed),
+		Filtered:    atomic.LoadUint64(&this.filtered),
+	}
+}
+
+// DIAL_TIMEOUT bounds the set up of a connection over TCP, TLS or
+// WebSocket, the TLS handshake included; the WebSocket one has its own.
+const DIAL_TIMEOUT = 10 * time.Second
+
 //Client Transport
 func (this *transport) Dial() (net.Conn, error) {
 	var conn net.Conn
 	var err error
 
+	dialer := &net.Dialer{Timeout: DIAL_TIMEOUT}
 	switch this.network {
 	case TCP:
-		conn, err = net.Dial("tcp", net.JoinHostPort(this.address, strconv.Itoa(this.port)))
+		conn, err = dialer.Dial("tcp", net.JoinHostPort(this.address, strconv.Itoa(this.port)))
 	case TLS:
-		conn, err = tls.Dial("tcp", net.JoinHostPort(this.address, strconv.Itoa(this.port)), this.tlsc)
+		conn, err = tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(this.address, strconv.Itoa(this.port)), this.policy.clientConfig(this.tlsc))
+	case WS, WSS:
+		address := net.JoinHostPort(this.address, strconv.Itoa(this.port))
+		if this.network == WSS {
+			conn, err = tls.DialWithDialer(dialer, "tcp", address, this.policy.clientConfig(this.tlsc))
+		} else {
+			conn, err = dialer.Dial("tcp", address)
+		}
+		if err == nil {
+			conn, err = dialWebSocket(conn, address)
+		}
+	case UDP:
+		//the socket is not connected, since with rport (RFC 3581) responses
+		//may come from another address than the one the request was sent
+		//to; they are matched by branch only.
+		var raddr *net.UDPAddr
+		if raddr, err = net.ResolveUDPAddr("udp", net.JoinHostPort(this.address, strconv.Itoa(this.port))); err != nil {
+			return nil, err
+		}
+		var pc net.PacketConn
+		if pc, err = net.ListenPacket("udp", ":0"); err != nil {
+			return nil, err
+		}
+		conn = newDatagramConn(pc, raddr)
 		//TODO:
-		//case UDP
 		//case SCTP
 	}
 
@@ -94,20 +223,40 @@ func (this *transport) Dial() (net.Conn, error) {
 func (this *transport) Listen() error {
 	var err error
 
+	lc := net.ListenConfig{}
+	if this.reusePort {
+		lc.Control = reusePortControl
+	}
+	address := net.JoinHostPort(this.address, strconv.Itoa(this.port))
+
 	switch this.network {
-	case TCP:
-		this.lner, err = net.Listen("tcp", net.JoinHostPort(this.address, strconv.Itoa(this.port)))
-	case TLS:
-		this.lner, err = tls.Listen("tcp", net.JoinHostPort(this.address, strconv.Itoa(this.port)), this.tlsc)
+	case TCP, WS:
+		this.lner, err = this.listen(lc, address)
+	case TLS, WSS:
+		var lner net.Listener
+		if lner, err = this.listen(lc, address); err == nil {
+			//kept, for SetSessionTicketKeys to reach the listener
+			this.tlsc = this.policy.serverConfig(this.tlsc)
+			this.lner = tls.NewListener(lner, this.tlsc)
+		}
+	case UDP:
+		var pc net.PacketConn
+		if pc, err = this.listenPacket(lc, address); err == nil {
+			this.pconn = newDatagramConn(pc, nil)
+		}
 		//TODO:
-		//case UDP
 		//case SCTP
 	}
 
 	return err
 }
 
+// Accept returns the next connection. A UDP transport has a single one,
+// reading the datagrams of every peer.
 func (this *transport) Accept() (net.Conn, error) {
+	if this.network == UDP && this.pconn != nil {
+		return this.pconn, nil
+	}
 	if this.lner != nil {
 		var conn net.Conn
 		var err error
@@ -117,6 +266,11 @@ func (this *transport) Accept() (net.Conn, error) {
 			fallthrough
 		case TLS:
 			conn, err = this.lner.Accept()
+		case WS, WSS:
+			//the upgrade completes on the first read or write
+			if conn, err = this.lner.Accept(); err == nil {
+				conn = acceptWebSocket(conn)
+			}
 		}
 
 		return conn, err
@@ -126,9 +280,78 @@ func (this *transport) Accept() (net.Conn, error) {
 }
 
 func (this *transport) SetDeadline(t time.Time) error {
-	if tcpln, ok := this.lner.(*net.TCPListener); ok {
+	if tcpln, ok := this.socket.(*net.TCPListener); ok {
 		return tcpln.SetDeadline(t)
 	} else {
 		return errors.New("Listener doesn't support SetDeadline\n")
 	}
 }
+
+////////////////////////////////////////////////////////////////////////////////
+
+// datagramConn serves a UDP socket as a net.Conn, one datagram at a time:
+// Read returns io.EOF at the end of a datagram, and the next Read starts on
+// the next one. RemoteAddr is the sender of the datagram being read, or the
+// peer given to Dial until one is read, and Write sends to it.
+type datagramConn struct {
+	net.PacketConn
+
+	buffer  []byte
+	unread  []byte
+	pending bool
+	remote  net.Addr
+
+	//skips the datagrams it returns false for, if set
+	filter func(data []byte, source net.Addr) bool
+}
+
+// DATAGRAM_SIZE is the largest UDP payload.
+const DATAGRAM_SIZE = 65535
+
+func newDatagramConn(pc net.PacketConn, remote net.Addr) *datagramConn {
+	return &datagramConn{
+		PacketConn: pc,
+		buffer:     make([]byte, DATAGRAM_SIZE),
+		remote:     remote,
+	}
+}
+
+func (this *datagramConn) Read(b []byte) (int, error) {
+	for !this.pending {
+		n, addr, err := this.ReadFrom(this.buffer)
+		if err != nil {
+			return 0, err
+		}
+		if this.filter != nil && !this.filter(this.buffer[:n], addr) {
+			continue
+		}
+		this.unread = this.buffer[:n]
+		this.remote = addr
+		this.pending = true
+	}
+	if len(this.unread) == 0 {
+		this.pending = false
+		return 0, io.EOF
+	}
+
+	n := copy(b, this.unread)
+	this.unread = this.unread[n:]
+	return n, nil
+}
+
+// Discard drops what is left of the datagram being read.
+func (this *datagramConn) Discard() {
+	this.unread = nil
+	this.pending = false
+}
+
+func (this *datagramConn) Write(b []byte) (int, error) {
+	if this.remote == nil {
+		return 0, errors.New("sip: no destination for the datagram")
+	}
+	return this.WriteTo(b, this.remote)
+}
+
+func (this *datagramConn) RemoteAddr() net.Addr {
+	return this.remote
+}
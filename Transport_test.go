@@ -0,0 +1,284 @@
+package sip
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUDPTransport(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	tr := newTransport(UDP, "127.0.0.1", 0, nil)
+	if err := tr.Listen(); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan Message, 4)
+	p.dispatcher = NewDispatcher(1, func(msg Message) { received <- msg })
+	p.dispatcher.Start()
+	defer p.dispatcher.Stop()
+
+	conn, err := tr.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.spawn(resourceServe, func() { p.ServeConn(tr, conn) })
+	defer func() {
+		close(p.quit)
+		conn.Close()
+		p.waitGroup.Wait()
+	}()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	//one message per datagram; a malformed one is skipped without closing
+	//the socket
+	client.Write([]byte(testOptions))
+	client.Write([]byte("garbage\r\n\r\n"))
+	client.Write([]byte(testOptions))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-received:
+			info := msg.GetMessageInfo()
+			if info.Network != UDP || info.RemoteAddr.String() != client.LocalAddr().String() {
+				t.Errorf("MessageInfo = %+v", info)
+			}
+			if req, ok := msg.(Request); !ok || req.GetMethod() != OPTIONS {
+				t.Errorf("message %d = %v", i, msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("datagram %d not received", i)
+		}
+	}
+
+	if m := tr.GetMetrics(); m.Messages != 2 || m.Malformed != 1 {
+		t.Errorf("metrics = %+v", m)
+	}
+
+	//a dialed UDP transport accepts responses from any source port
+	out, err := newTransport(UDP, "127.0.0.1", tr.pconn.LocalAddr().(*net.UDPAddr).Port, nil).Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if _, err := out.Write([]byte(testOptions)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		other, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer other.Close()
+		other.WriteTo([]byte("pong"), msg.GetMessageInfo().RemoteAddr)
+
+		out.SetDeadline(time.Now().Add(2 * time.Second))
+		buffer := make([]byte, 16)
+		if n, err := out.Read(buffer); err != nil || string(buffer[:n]) != "pong" {
+			t.Errorf("reply from another port: %q, %v", buffer[:n], err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("dialed datagram not received")
+	}
+}
+
+func TestTCPTransport(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	received := make(chan Message, 4)
+	p.dispatcher = NewDispatcher(1, func(msg Message) { received <- msg })
+	p.dispatcher.Start()
+	defer p.dispatcher.Stop()
+
+	server, client := dialPair(t, l)
+	defer client.Close()
+	c := p.addConnection(TCP, server)
+	p.spawn(resourceServe, func() { p.ServeConn(newTransport(TCP, "127.0.0.1", 0, nil), c) })
+	defer func() {
+		close(p.quit)
+		c.Close()
+		p.waitGroup.Wait()
+	}()
+
+	//two messages back to back in a segment, and one split across segments
+	//sent further apart than a read waits
+	client.Write([]byte(testOptions + testRinging))
+	client.Write([]byte(testOptions[:40]))
+	time.Sleep(1200 * time.Millisecond)
+	client.Write([]byte(testOptions[40:]))
+
+	for i, want := range []bool{true, false, true} {
+		select {
+		case msg := <-received:
+			if _, ok := msg.(Request); ok != want {
+				t.Errorf("message %d = %v", i, msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("message %d not received", i)
+		}
+	}
+}
+
+func TestWebSocketTransport(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	tr := newTransport(WS, "127.0.0.1", 0, nil)
+	if err := tr.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.lner.Close()
+
+	received := make(chan Message, 4)
+	p.dispatcher = NewDispatcher(1, func(msg Message) { received <- msg })
+	p.dispatcher.Start()
+	defer p.dispatcher.Stop()
+
+	port := tr.lner.Addr().(*net.TCPAddr).Port
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := tr.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	client, err := newTransport(WS, "127.0.0.1", port, nil).Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn := <-accepted
+	p.spawn(resourceServe, func() { p.ServeConn(tr, conn) })
+	defer func() {
+		close(p.quit)
+		conn.Close()
+		p.waitGroup.Wait()
+	}()
+
+	//one message per WebSocket message
+	client.Write([]byte(testOptions))
+	client.Write([]byte(testOptions))
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-received:
+			if info := msg.GetMessageInfo(); info.Network != WS {
+				t.Errorf("MessageInfo = %+v", info)
+			}
+			if req, ok := msg.(Request); !ok || req.GetMethod() != OPTIONS {
+				t.Errorf("message %d = %v", i, msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("message %d not received", i)
+		}
+	}
+
+	//and back, the end of the message read as io.EOF
+	if _, err := conn.Write([]byte("SIP/2.0 200 OK\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if b, err := io.ReadAll(client); err != nil || string(b) != "SIP/2.0 200 OK\r\n\r\n" {
+		t.Errorf("reply = %q, %v", b, err)
+	}
+
+	//an upgrade without the sip subprotocol is refused
+	go func() {
+		if conn, err := tr.Accept(); err == nil {
+			conn.Read(make([]byte, 1))
+		}
+	}()
+	raw, err := net.Dial("tcp", tr.lner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	io.WriteString(raw, "GET / HTTP/1.1\r\nHost: 127.0.0.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	raw.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if resp, err := http.ReadResponse(bufio.NewReader(raw), nil); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("upgrade without subprotocol = %v, %v", resp, err)
+	}
+}
+
+func TestReusePort(t *testing.T) {
+	first := newTransport(UDP, "127.0.0.1", 0, nil)
+	first.SetReusePort(true)
+	if err := first.Listen(); err == ErrReusePortUnsupported {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer first.pconn.Close()
+	port := first.pconn.LocalAddr().(*net.UDPAddr).Port
+
+	//without SO_REUSEPORT the port is taken
+	if err := newTransport(UDP, "127.0.0.1", port, nil).Listen(); err == nil {
+		t.Fatal("second listener bound without SO_REUSEPORT")
+	}
+
+	second := newTransport(UDP, "127.0.0.1", port, nil)
+	second.SetReusePort(true)
+	if err := second.Listen(); err != nil {
+		t.Fatalf("UDP listener sharing port %d: %v", port, err)
+	}
+	second.pconn.Close()
+
+	//the TCP port comes from a listener of its own, the UDP one may be
+	//taken over TCP
+	tcp := newTransport(TCP, "127.0.0.1", 0, nil)
+	tcp.SetReusePort(true)
+	if err := tcp.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.lner.Close()
+	port = tcp.lner.Addr().(*net.TCPAddr).Port
+
+	tcp = newTransport(TCP, "127.0.0.1", port, nil)
+	tcp.SetReusePort(true)
+	if err := tcp.Listen(); err != nil {
+		t.Fatalf("TCP listener sharing port %d: %v", port, err)
+	}
+	defer tcp.lner.Close()
+}
+
+func TestMissingContentLength(t *testing.T) {
+	p := newProvider(TraceOff(), RealClock)
+	p.dispatcher = NewDispatcher(1, func(msg Message) { t.Errorf("dispatched %v", msg) })
+	p.dispatcher.Start()
+	defer p.dispatcher.Stop()
+
+	tr := newTransport(TCP, "127.0.0.1", 0, nil)
+	conn, client := net.Pipe()
+	defer client.Close()
+	p.spawn(resourceServe, func() { p.ServeConn(tr, conn) })
+
+	//the request is rejected, and the connection closed
+	go client.Write([]byte("OPTIONS sip:carol@chicago.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/TCP pc33.atlanta.com;branch=z9hG4bKcl1\r\n" +
+		"To: <sip:carol@chicago.com>\r\n" +
+		"From: Alice <sip:alice@atlanta.com>;tag=1928301774\r\n" +
+		"Call-ID: a84b4c76e66710\r\n" +
+		"CSeq: 63104 OPTIONS\r\n\r\n"))
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp, err := ReadResponse(bufio.NewReader(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetStatusCode() != BAD_REQUEST || resp.GetReasonPhrase() != "Missing Content-Length" {
+		t.Errorf("answered %d %s", resp.GetStatusCode(), resp.GetReasonPhrase())
+	}
+	p.waitGroup.Wait()
+}
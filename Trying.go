@@ -0,0 +1,96 @@
+package sip
+
+import (
+	"time"
+)
+
+// A TryingPolicy tells when the provider answers an incoming request with
+// 100 Trying on behalf of the application.
+type TryingPolicy int
+
+const (
+	TRYINGPOLICY_DELAYED   TryingPolicy = iota //0, unless the TU responds within TRYING_DELAY
+	TRYINGPOLICY_IMMEDIATE                     //1
+	TRYINGPOLICY_NEVER                         //2, e.g. for stateless forwarding
+)
+
+// The server INVITE transaction must send 100 Trying if the TU does not
+// respond within 200ms (RFC 3261 17.2.1).
+const TRYING_DELAY = 200 * time.Millisecond
+
+// GetTryingPolicy returns the policy of method. By default INVITE requests
+// get a delayed 100 Trying and the other requests none, as non-INVITE
+// transactions are expected to complete quickly.
+func (this *provider) GetTryingPolicy(method string) TryingPolicy {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if policy, ok := this.tryingPolicies[method]; ok {
+		return policy
+	}
+	if method == INVITE {
+		return TRYINGPOLICY_DELAYED
+	}
+	return TRYINGPOLICY_NEVER
+}
+
+func (this *provider) SetTryingPolicy(method string, policy TryingPolicy) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.tryingPolicies[method] = policy
+}
+
+// scheduleTrying applies the policy of the method of req, which was just
+// admitted.
+func (this *provider) scheduleTrying(req Request) {
+	switch this.GetTryingPolicy(req.GetMethod()) {
+	case TRYINGPOLICY_IMMEDIATE:
+		this.sendTrying(req)
+	case TRYINGPOLICY_DELAYED:
+		key := getTransactionKey(req)
+		timer := this.GetClock().AfterFunc(TRYING_DELAY, func() {
+			this.mutex.Lock()
+			_, pending := this.trying[key]
+			delete(this.trying, key)
+			this.mutex.Unlock()
+
+			if pending {
+				this.sendTrying(req)
+			}
+		})
+
+		this.mutex.Lock()
+		this.trying[key] = timer
+		this.mutex.Unlock()
+	}
+}
+
+// cancelTrying stops the delayed 100 Trying of the transaction of resp,
+// which the TU answered in time.
+func (this *provider) cancelTrying(resp Response) {
+	key := getTransactionKey(resp)
+
+	this.mutex.Lock()
+	timer, ok := this.trying[key]
+	delete(this.trying, key)
+	this.mutex.Unlock()
+
+	if ok {
+		timer.Stop()
+	}
+}
+
+// sendTrying sends 100 Trying, through the server transaction of req if it
+// has one so that retransmissions of req are answered with it.
+func (this *provider) sendTrying(req Request) {
+	var err error
+	if st := this.matchServerTransaction(req); st != nil {
+		err = st.SendResponse(CreateResponse(req, TRYING))
+	} else {
+		err = this.SendResponse(CreateResponse(req, TRYING))
+	}
+	if err != nil {
+		this.getLogger(COMPONENT_TRANSACTION).Log(LOG_ERROR, "Sending 100 Trying failed", "error", err)
+	}
+}
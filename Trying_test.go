@@ -0,0 +1,47 @@
+package sip
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTryingPolicy(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+
+	if p.GetTryingPolicy(INVITE) != TRYINGPOLICY_DELAYED || p.GetTryingPolicy(OPTIONS) != TRYINGPOLICY_NEVER {
+		t.Fatal("unexpected default policies")
+	}
+
+	invite, err := ReadRequest(bufio.NewReader(strings.NewReader(strings.Replace(testOptions, "OPTIONS", INVITE, -1))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//answered in time: no 100 Trying
+	p.scheduleTrying(invite)
+	clock.Advance(100 * time.Millisecond)
+	p.SendResponse(CreateResponse(invite, RINGING))
+	if len(p.trying) != 0 || clock.Pending() != 0 {
+		t.Fatalf("delayed 100 Trying not cancelled by the 180")
+	}
+
+	//not answered in time: the 100 Trying goes out at 200ms
+	p.scheduleTrying(invite)
+	clock.Advance(TRYING_DELAY - time.Millisecond)
+	if len(p.trying) != 1 {
+		t.Fatal("100 Trying sent too early")
+	}
+	clock.Advance(time.Millisecond)
+	if len(p.trying) != 0 || clock.Pending() != 0 {
+		t.Fatal("100 Trying not sent at the deadline")
+	}
+
+	p.SetTryingPolicy(INVITE, TRYINGPOLICY_NEVER)
+	p.scheduleTrying(invite)
+	if len(p.trying) != 0 || clock.Pending() != 0 {
+		t.Fatal("100 Trying scheduled despite the never policy")
+	}
+}
@@ -0,0 +1,53 @@
+package sip
+
+import (
+	"errors"
+)
+
+// An UPDATE (RFC 3311) changes the session of a dialog, early or
+// confirmed, without changing its state: it is a target refresh request,
+// and its offer and answer follow the rules of the dialog, see OfferState.
+// A dialog sends an UPDATE only to a remote party allowing it, as the
+// Allow of its last dialog-forming or target refresh message says; one
+// which sent no Allow is taken to. An UPDATE answered with 491, its offer
+// crossing another one, is sent again after the glare delay, see Glare.
+// The 2xx answering an UPDATE or a re-INVITE gets the Contact of the
+// dialog unless it has one.
+
+var ErrUpdateNotAllowed = errors.New("sip: the remote party does not allow UPDATE")
+
+////////////////////Implementation////////////////////////
+
+// setRemoteAllow takes the methods the remote party allows from the Allow
+// of msg, which it sent, if any; mutex is held.
+func (this *dialog) setRemoteAllow(msg Message) {
+	if allow := GetOptionTags(msg, "Allow"); allow != nil {
+		this.remoteAllow = allow
+	}
+}
+
+// isRemoteAllowed reports whether the remote party allows method, as far
+// as the dialog knows.
+func (this *dialog) isRemoteAllowed(method string) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.remoteAllow == nil || containsToken(this.remoteAllow, method)
+}
+
+// stampContact gives resp, answering req received within the dialog, the
+// Contact of the dialog if resp is a 2xx to an UPDATE or an INVITE without
+// one (RFC 3311 5.2).
+func (this *dialog) stampContact(req Request, resp Response) {
+	if method := req.GetMethod(); (method != UPDATE && method != INVITE) || resp.GetStatusCode()/100 != 2 || resp.GetHeader().Get("Contact") != "" {
+		return
+	}
+
+	this.mutex.Lock()
+	contact := this.localContact
+	this.mutex.Unlock()
+
+	if contact != "" {
+		resp.GetHeader().Set("Contact", contact)
+	}
+}
@@ -0,0 +1,177 @@
+package sip
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUpdateEarlyDialog(t *testing.T) {
+	clock := NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := newProvider(TraceOff(), clock)
+	sent := captureSends(p)
+	go p.Run()
+	defer p.Stop()
+
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKup1")
+	invite.GetHeader().Set("Contact", "<sip:alice@192.0.2.1>")
+	invite.GetHeader().Set("Supported", OPTIONTAG_100REL)
+	invite.SetBody(NewSDPBody([]byte("v=0\r\no=offer\r\n")))
+	ct := p.GetNewClientTransaction(invite).(*clientTransaction)
+	ct.hops = []Hop{NewHop("192.0.2.7", 5060, UDP)}
+	ct.start()
+
+	progress := CreateResponse(invite, SESSION_PROGRESS)
+	progress.GetHeader().Set("To", "<sip:bob@example.com>;tag=u1")
+	progress.GetHeader().Set("Contact", "<sip:bob@192.0.2.7>")
+	progress.GetHeader().Set("Allow", "INVITE, ACK, BYE, PRACK, UPDATE")
+	progress.GetHeader().Set("Require", OPTIONTAG_100REL)
+	progress.GetHeader().Set("RSeq", "1")
+	progress.SetBody(NewSDPBody([]byte("v=0\r\no=answer\r\n")))
+	n := sent.len()
+	p.processResponse(progress)
+	waitSent(t, sent, n+1)
+	d := ct.GetDialog()
+	if d.GetOfferState() != OFFERSTATE_NONE {
+		t.Fatalf("offer state %v once answered", d.GetOfferState())
+	}
+	p.processResponse(CreateResponse(findSent(sent, PRACK), OK))
+
+	//an UPDATE offers a new session in the early dialog, and no other
+	//offer can be made until it is answered
+	update, err := d.CreateRequest(UPDATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update.SetBody(NewSDPBody([]byte("v=0\r\no=update\r\n")))
+	n = sent.len()
+	if err := d.SendRequest(p.GetNewClientTransaction(update)); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, n+1)
+	if update.GetHeader().Get("Cseq") != "3 UPDATE" || update.GetHeader().Get("Contact") != "<sip:alice@192.0.2.1>" || d.GetOfferState() != OFFERSTATE_LOCAL {
+		t.Fatalf("UPDATE %v, offer state %v", update.GetHeader(), d.GetOfferState())
+	}
+	second, _ := d.CreateRequest(UPDATE)
+	second.SetBody(NewSDPBody([]byte("v=0\r\n")))
+	if err := d.SendRequest(p.GetNewClientTransaction(second)); err != ErrOfferPending {
+		t.Errorf("second offer sent with %v", err)
+	}
+
+	//one answered with 491 is sent again after the glare delay
+	p.processResponse(CreateResponse(update, REQUEST_PENDING))
+	clock.Advance(GLARE_OWNER_MAX)
+	retry := update
+	for i := 0; retry == update && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		retry = findSent(sent, UPDATE)
+	}
+	if retry == update || retry.GetHeader().Get("Cseq") != "5 UPDATE" || string(readSDP(retry)) != "v=0\r\no=update\r\n" {
+		t.Fatalf("UPDATE sent again as %v", retry)
+	}
+	answered := CreateResponse(retry, OK)
+	answered.SetBody(NewSDPBody([]byte("v=0\r\no=updated\r\n")))
+	p.processResponse(answered)
+	if d.GetOfferState() != OFFERSTATE_NONE {
+		t.Errorf("offer state %v once the UPDATE is answered", d.GetOfferState())
+	}
+
+	//the ACK of the 2xx takes the CSeq of the INVITE
+	ok200 := CreateResponse(invite, OK)
+	ok200.GetHeader().Set("To", "<sip:bob@example.com>;tag=u1")
+	ok200.GetHeader().Set("Contact", "<sip:bob@192.0.2.7>")
+	p.processResponse(ok200)
+	ack, err := d.CreateRequest(ACK)
+	if err != nil || ack.GetHeader().Get("Cseq") != "1 ACK" {
+		t.Errorf("ACK %v, %v", ack, err)
+	}
+}
+
+func TestUpdateNotAllowed(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKup2")
+	invite.GetHeader().Set("Contact", "<sip:alice@192.0.2.1>")
+	ct := p.GetNewClientTransaction(invite).(*clientTransaction)
+	ct.hops = []Hop{NewHop("192.0.2.7", 5060, UDP)}
+	ct.start()
+	ok200 := CreateResponse(invite, OK)
+	ok200.GetHeader().Set("To", "<sip:bob@example.com>;tag=u2")
+	ok200.GetHeader().Set("Contact", "<sip:bob@192.0.2.7>")
+	ok200.GetHeader().Set("Allow", "INVITE, ACK, BYE")
+	p.processResponse(ok200)
+	d := ct.GetDialog()
+
+	update, _ := d.CreateRequest(UPDATE)
+	if err := d.SendRequest(p.GetNewClientTransaction(update)); err != ErrUpdateNotAllowed {
+		t.Errorf("UPDATE sent with %v", err)
+	}
+
+	//until a target refresh says otherwise
+	reinvite := NewRequest(INVITE, "sip:alice@192.0.2.1", nil)
+	h := reinvite.GetHeader()
+	h.Set("Via", "SIP/2.0/UDP 192.0.2.7;branch=z9hG4bKup3")
+	h.Set("From", "<sip:bob@example.com>;tag=u2")
+	h.Set("To", "<sip:alice@example.com>;tag=1")
+	h.Set("Call-Id", invite.GetHeader().Get("Call-Id"))
+	h.Set("Cseq", "1 INVITE")
+	h.Set("Contact", "<sip:bob@192.0.2.7>")
+	h.Set("Allow", "INVITE, ACK, BYE, UPDATE")
+	p.processMessage(reinvite)
+	if len(l.transactions) != 1 {
+		t.Fatalf("%d requests", len(l.transactions))
+	}
+	update, _ = d.CreateRequest(UPDATE)
+	if err := d.SendRequest(p.GetNewClientTransaction(update)); err != nil {
+		t.Errorf("UPDATE once allowed: %v", err)
+	}
+}
+
+func TestUpdateServer(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	captureSends(p)
+	go p.Run()
+	defer p.Stop()
+	l := &serverListener{}
+	p.AddListener(l)
+
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKup4")
+	invite.GetHeader().Set("Contact", "<sip:alice@192.0.2.1>")
+	invite.SetMessageInfo(&MessageInfo{Network: UDP, LocalAddr: &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5060}})
+	p.processMessage(invite)
+	ok200 := CreateResponse(invite, OK)
+	ok200.GetHeader().Set("To", "<sip:bob@example.com>;tag=u4")
+	ok200.GetHeader().Set("Contact", "<sip:bob@192.0.2.2>")
+	if err := l.transactions[0].SendResponse(ok200); err != nil {
+		t.Fatal(err)
+	}
+
+	//an UPDATE refreshes the remote target, and its 2xx gets the Contact
+	//of the dialog
+	update := newServerTestRequest(UPDATE, "UDP", "z9hG4bKup5")
+	update.GetHeader().Set("To", "<sip:bob@example.com>;tag=u4")
+	update.GetHeader().Set("Cseq", "2 UPDATE")
+	update.GetHeader().Set("Contact", "<sip:alice@192.0.2.9>")
+	update.SetBody(NewSDPBody([]byte("v=0\r\no=update\r\n")))
+	p.processMessage(update)
+	if len(l.requests) != 2 {
+		t.Fatalf("%d requests", len(l.requests))
+	}
+	d := l.requests[1].GetDialog()
+	if d.GetRemoteTarget() != "sip:alice@192.0.2.9" || d.GetOfferState() != OFFERSTATE_REMOTE {
+		t.Fatalf("remote target %s, offer state %v", d.GetRemoteTarget(), d.GetOfferState())
+	}
+	answer := CreateResponse(update, OK)
+	answer.SetBody(NewSDPBody([]byte("v=0\r\no=answer\r\n")))
+	if err := l.transactions[1].SendResponse(answer); err != nil {
+		t.Fatal(err)
+	}
+	if v := answer.GetHeader().Get("Contact"); v != "<sip:bob@192.0.2.2>" || d.GetOfferState() != OFFERSTATE_NONE {
+		t.Errorf("2xx Contact %q, offer state %v", v, d.GetOfferState())
+	}
+}
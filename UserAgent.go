@@ -0,0 +1,567 @@
+package sip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sip/address"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A UserAgent registers an address of record and places and receives calls
+// through a Provider, without handling transactions or dialogs: the
+// requests it sends get their Via, Max-Forwards, From, To, Call-ID, CSeq
+// and Contact from the address of record and the contact it was created
+// with, and a request challenged with a 401 or 407 is sent again with the
+// credentials of its Authorizer. The Service-Route of the last 2xx to a
+// REGISTER is the Route of the requests sent afterwards, see GetServiceRoute.
+//
+// Invite places a call, whose responses update it until it is answered;
+// the 2xx is acknowledged, so the call is up once its state is confirmed.
+// An INVITE received is given to the IncomingCall handler as a Call to
+// answer or reject; an INVITE nobody handles is answered 480. The call is
+// hung up with Bye, and the CallTerminated handler called once it ended,
+// whichever side ended it. A re-INVITE or an UPDATE refreshing a call is
+// answered with its local session description unchanged. The UserAgent
+// must be added to the Provider as a Listener.
+type UserAgent interface {
+	DialogListener
+
+	GetProvider() Provider
+	GetAddressOfRecord() string
+	GetContact() string
+	GetAuthorizer() Authorizer
+	SetAuthorizer(authorizer Authorizer)
+	GetServiceRoute() []string
+
+	Register(ctx context.Context, expires time.Duration) (Response, error)
+	Invite(ctx context.Context, target string, sdp []byte) (Call, error)
+
+	OnIncomingCall(handler CallHandler)
+	OnCallTerminated(handler CallHandler)
+}
+
+// An Authorizer adds the credentials answering the challenges of resp, a
+// 401 or a 407, to req, the request to send again, see auth.Authenticator.
+type Authorizer interface {
+	Authorize(req Request, resp Response) error
+}
+
+// A CallHandler is called with a call received, or one which ended.
+type CallHandler func(call Call)
+
+// The times a request challenged is sent again: once for a 401 and once
+// for a 407, which may follow it.
+const USERAGENT_AUTH_ATTEMPTS = 2
+
+var (
+	ErrBadAddressOfRecord = errors.New("sip: address of record is not a SIP URI")
+	ErrBadContact         = errors.New("sip: contact is not a SIP URI")
+)
+
+////////////////////Implementation////////////////////////
+
+type userAgent struct {
+	mutex sync.Mutex
+
+	provider Provider
+	aor      string
+	contact  string
+	via      string
+
+	authorizer Authorizer
+
+	//REGISTERs share a Call-ID (RFC 3261 10.2)
+	registerCallId string
+	registerCSeq   int
+	serviceRoute   []string //the Service-Route of the last registration
+
+	pending map[ClientTransaction]*outgoingCall
+	calls   map[string]*call
+
+	incomingCallHandler   CallHandler
+	callTerminatedHandler CallHandler
+}
+
+// outgoingCall is an INVITE of a call placed, and the times it was
+// challenged.
+type outgoingCall struct {
+	call     *call
+	target   string
+	attempts int
+}
+
+// aor is the address of record to register and place calls from, e.g.
+// "sip:alice@example.com", and contact the URI to reach the user agent at,
+// e.g. "sip:alice@192.0.2.1:5060;transport=tcp", which also sets the Via
+// of the requests sent. Both must be SIP or SIPS URIs.
+func NewUserAgent(provider Provider, aor, contact string) (UserAgent, error) {
+	if _, err := getRegistrarURI(aor); err != nil {
+		return nil, err
+	}
+	via, err := getContactVia(contact)
+	if err != nil {
+		return nil, err
+	}
+
+	this := &userAgent{}
+
+	this.provider = provider
+	this.aor = aor
+	this.contact = contact
+	this.via = via
+
+	this.registerCallId = provider.GetNewCallId()
+
+	this.pending = make(map[ClientTransaction]*outgoingCall)
+	this.calls = make(map[string]*call)
+
+	return this, nil
+}
+
+func (this *userAgent) GetProvider() Provider {
+	return this.provider
+}
+
+func (this *userAgent) GetAddressOfRecord() string {
+	return this.aor
+}
+
+func (this *userAgent) GetContact() string {
+	return this.contact
+}
+
+func (this *userAgent) GetAuthorizer() Authorizer {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.authorizer
+}
+
+func (this *userAgent) SetAuthorizer(authorizer Authorizer) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.authorizer = authorizer
+}
+
+func (this *userAgent) GetServiceRoute() []string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return append([]string(nil), this.serviceRoute...)
+}
+
+// setServiceRoute keeps the Service-Route of resp, a 2xx to a REGISTER
+// binding the contact, for the requests sent afterwards (RFC 3608 6).
+func (this *userAgent) setServiceRoute(resp Response) {
+	route, err := GetServiceRoute(resp)
+	if err != nil {
+		this.getLogger().Log(LOG_WARN, "Service-Route ignored", "error", err)
+		return
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.serviceRoute = route
+}
+
+func (this *userAgent) OnIncomingCall(handler CallHandler) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.incomingCallHandler = handler
+}
+
+func (this *userAgent) OnCallTerminated(handler CallHandler) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.callTerminatedHandler = handler
+}
+
+// Register binds the contact to the address of record at its registrar,
+// the domain of the address of record, for expires; an expires of 0
+// removes the binding. It returns the final response to the REGISTER.
+func (this *userAgent) Register(ctx context.Context, expires time.Duration) (Response, error) {
+	registrar, err := getRegistrarURI(this.aor)
+	if err != nil {
+		return nil, err
+	}
+
+	this.mutex.Lock()
+	authorizer := this.authorizer
+	this.mutex.Unlock()
+
+	var resp Response
+	for attempt := 0; ; attempt++ {
+		this.mutex.Lock()
+		this.registerCSeq++
+		cseq := this.registerCSeq
+		this.mutex.Unlock()
+
+		req := this.createRequest(REGISTER, registrar, this.aor, this.registerCallId, GenerateTag(), cseq)
+		req.GetHeader().Set("Expires", strconv.Itoa(int(expires/time.Second)))
+		if attempt > 0 {
+			if err := authorizer.Authorize(req, resp); err != nil {
+				return resp, nil
+			}
+		}
+
+		resp, err = this.provider.Do(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.GetStatusCode() >= OK && resp.GetStatusCode() < MULTIPLE_CHOICES {
+			if expires == 0 {
+				this.mutex.Lock()
+				this.serviceRoute = nil
+				this.mutex.Unlock()
+			} else {
+				this.setServiceRoute(resp)
+			}
+		}
+		if !isChallenge(resp) || authorizer == nil || attempt == USERAGENT_AUTH_ATTEMPTS {
+			return resp, nil
+		}
+	}
+}
+
+// Invite places a call to target, offering sdp, or making a delayed offer
+// if it is nil. ctx bounds sending the INVITE, not the call.
+func (this *userAgent) Invite(ctx context.Context, target string, sdp []byte) (Call, error) {
+	invite := this.createInvite(target, this.provider.GetNewCallId(), GenerateTag(), 1, sdp)
+	c := NewOutgoingCall(this.provider, invite).(*call)
+	ct := this.provider.GetNewClientTransaction(invite)
+
+	this.mutex.Lock()
+	this.pending[ct] = &outgoingCall{call: c, target: target}
+	this.calls[c.GetCallId()] = c
+	this.mutex.Unlock()
+
+	if err := ct.SendRequestContext(ctx); err != nil {
+		this.mutex.Lock()
+		delete(this.pending, ct)
+		delete(this.calls, c.GetCallId())
+		this.mutex.Unlock()
+		return nil, err
+	}
+	return c, nil
+}
+
+// createRequest returns a request outside of any dialog from the address of
+// record to to, routed through the service route unless a REGISTER.
+func (this *userAgent) createRequest(method, uri, to, callId, fromTag string, cseq int) Request {
+	req := NewRequest(method, uri, nil)
+	h := req.GetHeader()
+
+	if method != REGISTER {
+		for _, route := range this.GetServiceRoute() {
+			h.Add("Route", route)
+		}
+	}
+	h.Set("Via", this.via+";branch="+RandomBranch.GetBranch(req))
+	h.Set("Max-Forwards", "70")
+	h.Set("From", "<"+this.aor+">;tag="+fromTag)
+	h.Set("To", "<"+to+">")
+	h.Set("Call-Id", callId)
+	h.Set("Cseq", strconv.Itoa(cseq)+" "+method)
+	h.Set("Contact", "<"+this.contact+">")
+
+	return req
+}
+
+func (this *userAgent) createInvite(target, callId, fromTag string, cseq int, sdp []byte) Request {
+	invite := this.createRequest(INVITE, target, target, callId, fromTag, cseq)
+	if sdp != nil {
+		invite.SetBody(NewSDPBody(sdp))
+	}
+	return invite
+}
+
+// retry sends the INVITE of o challenged by resp again with credentials,
+// as a new transaction of the call, and reports whether it did.
+func (this *userAgent) retry(o *outgoingCall, resp Response) bool {
+	this.mutex.Lock()
+	authorizer := this.authorizer
+	this.mutex.Unlock()
+
+	if authorizer == nil || o.attempts == USERAGENT_AUTH_ATTEMPTS {
+		return false
+	}
+
+	c := o.call
+	c.mutex.Lock()
+	cseq := c.cseq + 1
+	invite := this.createInvite(o.target, c.GetCallId(), getTag(c.invite.GetHeader().Get("From")), cseq, c.localSDP)
+	c.mutex.Unlock()
+	if err := authorizer.Authorize(invite, resp); err != nil {
+		return false
+	}
+
+	c.mutex.Lock()
+	c.invite = invite
+	c.cseq = cseq
+	c.mutex.Unlock()
+
+	ct := this.provider.GetNewClientTransaction(invite)
+	this.mutex.Lock()
+	this.pending[ct] = &outgoingCall{call: c, target: o.target, attempts: o.attempts + 1}
+	this.mutex.Unlock()
+
+	if err := ct.SendRequest(); err != nil {
+		this.mutex.Lock()
+		delete(this.pending, ct)
+		this.mutex.Unlock()
+		this.getLogger().Log(LOG_ERROR, "Sending INVITE failed", "call", c.GetCallId(), "error", err)
+		return false
+	}
+	return true
+}
+
+// acknowledge sends the ACK of the 2xx answering c in the dialog of ct,
+// with the answer to a delayed offer.
+func (this *userAgent) acknowledge(c *call, ct ClientTransaction) {
+	d := ct.GetDialog()
+	if d == nil {
+		return
+	}
+	c.mutex.Lock()
+	if c.dialog == nil {
+		c.dialog = d
+	}
+	c.mutex.Unlock()
+
+	ack, err := d.CreateRequest(ACK)
+	if err == nil {
+		if err = c.ProcessAck(ack); err == nil {
+			err = d.SendAck(ack)
+		}
+	}
+	if err != nil {
+		this.getLogger().Log(LOG_ERROR, "Sending ACK failed", "call", c.GetCallId(), "error", err)
+	}
+}
+
+// getCall returns the call of callId.
+func (this *userAgent) getCall(callId string) *call {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.calls[callId]
+}
+
+// ended forgets c and tells the CallTerminated handler, once per call.
+func (this *userAgent) ended(c *call) {
+	this.mutex.Lock()
+	if this.calls[c.GetCallId()] != c {
+		this.mutex.Unlock()
+		return
+	}
+	delete(this.calls, c.GetCallId())
+	handler := this.callTerminatedHandler
+	this.mutex.Unlock()
+
+	c.terminate()
+	if handler != nil {
+		handler(c)
+	}
+}
+
+func (this *userAgent) getLogger() Logger {
+	return this.provider.GetLogger().With(LOG_COMPONENT, COMPONENT_DIALOG)
+}
+
+func (this *userAgent) ProcessRequest(requestEvent RequestEvent) {
+	req := requestEvent.GetRequest()
+	c := this.getCall(req.GetHeader().Get("Call-Id"))
+
+	switch req.GetMethod() {
+	case INVITE:
+		if requestEvent.GetDialog() == nil {
+			this.processInvite(requestEvent)
+			return
+		}
+		this.refresh(requestEvent, c)
+	case UPDATE:
+		this.refresh(requestEvent, c)
+	case ACK:
+		if c != nil && c.IsIncoming() {
+			if err := c.ProcessAck(req); err != nil {
+				this.getLogger().Log(LOG_WARN, "ACK rejected", "call", c.GetCallId(), "error", err)
+			}
+		}
+	case BYE:
+		//the dialog ended with the BYE, and the call with it
+		statusCode := OK
+		if requestEvent.GetDialog() == nil {
+			statusCode = CALL_OR_TRANSACTION_DOES_NOT_EXIST
+		}
+		if w := requestEvent.GetResponseWriter(); w != nil {
+			if err := w.Respond(statusCode, nil); err != nil {
+				this.getLogger().Log(LOG_ERROR, "Sending response failed", "error", err)
+			}
+		}
+	case CANCEL:
+		//the provider answered the INVITE with 487 already
+		if c != nil && c.IsIncoming() && c.GetState() < CALLSTATE_CONFIRMED {
+			this.ended(c)
+		}
+	}
+}
+
+// processInvite gives a call received to the IncomingCall handler.
+func (this *userAgent) processInvite(requestEvent RequestEvent) {
+	this.mutex.Lock()
+	handler := this.incomingCallHandler
+	this.mutex.Unlock()
+
+	st := requestEvent.GetServerTransaction()
+	if handler == nil || st == nil {
+		if w := requestEvent.GetResponseWriter(); w != nil {
+			if err := w.Respond(TEMPORARILY_UNAVAILABLE, nil); err != nil {
+				this.getLogger().Log(LOG_ERROR, "Sending response failed", "error", err)
+			}
+		}
+		return
+	}
+
+	c := NewIncomingCall(this.provider, st).(*call)
+	c.contact = "<" + this.contact + ">"
+
+	this.mutex.Lock()
+	this.calls[c.GetCallId()] = c
+	this.mutex.Unlock()
+
+	handler(c)
+}
+
+// refresh answers a re-INVITE or an UPDATE within the dialog of c with its
+// local session description: as the answer to an offer, or as an offer
+// when a re-INVITE has none.
+func (this *userAgent) refresh(requestEvent RequestEvent, c *call) {
+	w := requestEvent.GetResponseWriter()
+	if c == nil || w == nil || requestEvent.GetDialog() == nil || c.GetDialog() != requestEvent.GetDialog() {
+		return
+	}
+
+	w.Header().Set("Contact", "<"+this.contact+">")
+	var err error
+	if sdp := c.GetLocalSDP(); sdp != nil && (requestEvent.GetRequest().GetMethod() == INVITE || readSDP(requestEvent.GetRequest()) != nil) {
+		err = w.Respond(OK, NewSDPBody(sdp))
+	} else {
+		err = w.Respond(OK, nil)
+	}
+	if err != nil {
+		this.getLogger().Log(LOG_ERROR, "Sending response failed", "call", c.GetCallId(), "error", err)
+	}
+}
+
+func (this *userAgent) ProcessResponse(responseEvent ResponseEvent) {
+	ct := responseEvent.GetClientTransaction()
+	resp := responseEvent.GetResponse()
+	if ct == nil {
+		return
+	}
+	statusCode := resp.GetStatusCode()
+
+	this.mutex.Lock()
+	o := this.pending[ct]
+	if statusCode >= OK {
+		delete(this.pending, ct)
+	}
+	this.mutex.Unlock()
+	if o == nil {
+		return
+	}
+
+	if isChallenge(resp) && this.retry(o, resp) {
+		return
+	}
+	if err := o.call.ProcessResponse(resp); err != nil {
+		this.getLogger().Log(LOG_ERROR, "Processing response failed", "call", o.call.GetCallId(), "error", err)
+	}
+	switch {
+	case statusCode < OK:
+	case statusCode < MULTIPLE_CHOICES:
+		this.acknowledge(o.call, ct)
+	default:
+		this.ended(o.call)
+	}
+}
+
+func (this *userAgent) ProcessTimeout(timeoutEvent TimeoutEvent) {
+	ct, ok := timeoutEvent.GetTransaction().(ClientTransaction)
+	if !ok {
+		return
+	}
+
+	this.mutex.Lock()
+	o := this.pending[ct]
+	delete(this.pending, ct)
+	this.mutex.Unlock()
+
+	if o != nil {
+		this.ended(o.call)
+	}
+}
+
+// ProcessDialogTerminated ends the call of a dialog which terminated, by a
+// BYE sent or received, or a 481 or 408 answering a request within it.
+func (this *userAgent) ProcessDialogTerminated(dialogTerminatedEvent DialogTerminatedEvent) {
+	d := dialogTerminatedEvent.GetDialog()
+	if c := this.getCall(d.GetCallId()); c != nil && c.GetDialog() == d {
+		this.ended(c)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// isChallenge reports whether resp asks for credentials.
+func isChallenge(resp Response) bool {
+	return resp.GetStatusCode() == UNAUTHORIZED || resp.GetStatusCode() == PROXY_AUTHENTICATION_REQUIRED
+}
+
+// getRegistrarURI returns the URI of the registrar of aor, its domain.
+func getRegistrarURI(aor string) (string, error) {
+	uri, err := ParseURI(aor)
+	if err != nil {
+		return "", ErrBadAddressOfRecord
+	}
+	sipURI, ok := uri.(*address.SipURIImpl)
+	if !ok {
+		return "", ErrBadAddressOfRecord
+	}
+	return sipURI.GetScheme() + ":" + sipURI.GetHostPort().String(), nil
+}
+
+// getContactVia returns the Via, without a branch, of the requests sent
+// from contact: its transport, host and port.
+func getContactVia(contact string) (string, error) {
+	uri, err := ParseURI(contact)
+	if err != nil {
+		return "", ErrBadContact
+	}
+	sipURI, ok := uri.(*address.SipURIImpl)
+	if !ok {
+		return "", ErrBadContact
+	}
+	transport := strings.ToLower(sipURI.GetTransportParam())
+	if transport == "" {
+		transport = UDP
+		if sipURI.IsSecure() {
+			transport = TLS
+		}
+	}
+	port := sipURI.GetPort()
+	if port <= 0 {
+		port = getDefaultPort(transport, sipURI.IsSecure())
+	}
+	return "SIP/2.0/" + strings.ToUpper(transport) + " " + net.JoinHostPort(strings.Trim(sipURI.GetHost(), "[]"), strconv.Itoa(port)), nil
+}
@@ -0,0 +1,259 @@
+package sip
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+type authorizerFunc func(req Request, resp Response) error
+
+func (f authorizerFunc) Authorize(req Request, resp Response) error {
+	return f(req, resp)
+}
+
+func newUserAgentTestProvider() (*provider, *sentMessages) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	sent := captureSends(p)
+	go p.Run()
+	p.SetResolver(resolverFunc(func(ctx context.Context, h Hop) ([]Hop, error) {
+		return []Hop{NewHop("192.0.2.7", 5060, UDP)}, nil
+	}))
+	return p, sent
+}
+
+func TestUserAgentRegister(t *testing.T) {
+	p, sent := newUserAgentTestProvider()
+	defer p.Stop()
+
+	ua, err := NewUserAgent(p, "sip:alice@example.com", "sip:alice@192.0.2.1:5070")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddListener(ua)
+	ua.SetAuthorizer(authorizerFunc(func(req Request, resp Response) error {
+		req.GetHeader().Set("Authorization", "Digest username=\"alice\"")
+		return nil
+	}))
+
+	results := make(chan Response, 1)
+	go func() {
+		resp, err := ua.Register(context.Background(), time.Hour)
+		if err != nil {
+			t.Error(err)
+		}
+		results <- resp
+	}()
+
+	//the REGISTER goes to the domain of the address of record
+	waitSent(t, sent, 1)
+	first := findSent(sent, REGISTER)
+	h := first.GetHeader()
+	if first.GetRequestURIString() != "sip:example.com" || h.Get("To") != "<sip:alice@example.com>" || getTag(h.Get("From")) == "" ||
+		h.Get("Contact") != "<sip:alice@192.0.2.1:5070>" || h.Get("Expires") != "3600" || h.Get("Cseq") != "1 REGISTER" || h.Get("Max-Forwards") != "70" {
+		t.Errorf("REGISTER %s %v", first.GetRequestURIString(), h)
+	}
+	if via := h.Get("Via"); via != "SIP/2.0/UDP 192.0.2.1:5070;branch="+getBranch(first) || getBranch(first) == "" {
+		t.Errorf("Via %s", via)
+	}
+
+	//a challenge is answered with the credentials, in a new transaction
+	challenge := CreateResponse(first, UNAUTHORIZED)
+	challenge.GetHeader().Set("WWW-Authenticate", "Digest realm=\"example.com\", nonce=\"1\"")
+	p.processResponse(challenge)
+	waitSent(t, sent, 2)
+	second := findSent(sent, REGISTER)
+	if h := second.GetHeader(); h.Get("Authorization") == "" || h.Get("Cseq") != "2 REGISTER" || h.Get("Call-Id") != first.GetHeader().Get("Call-Id") || getBranch(second) == getBranch(first) {
+		t.Errorf("REGISTER sent again with %v", h)
+	}
+	p.processResponse(withToTag(CreateResponse(second, OK), "r1"))
+	select {
+	case resp := <-results:
+		if resp == nil || resp.GetStatusCode() != OK {
+			t.Errorf("Register returned %v", resp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Register did not return")
+	}
+}
+
+func TestUserAgentOutgoingCall(t *testing.T) {
+	p, sent := newUserAgentTestProvider()
+	defer p.Stop()
+
+	ua, err := NewUserAgent(p, "sip:alice@example.com", "sip:alice@192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddListener(ua)
+	var ended []Call
+	ua.OnCallTerminated(func(call Call) { ended = append(ended, call) })
+
+	c, err := ua.Invite(context.Background(), "sip:bob@example.com", []byte("v=0\r\no=alice\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, 1)
+	invite := findSent(sent, INVITE)
+	h := invite.GetHeader()
+	if h.Get("Via") != "SIP/2.0/UDP 192.0.2.1:5060;branch="+getBranch(invite) || h.Get("Contact") != "<sip:alice@192.0.2.1>" ||
+		h.Get("Cseq") != "1 INVITE" || h.Get("Call-Id") != c.GetCallId() || string(invite.GetBodyBytes()) != "v=0\r\no=alice\r\n" {
+		t.Errorf("INVITE %v", h)
+	}
+	if err := c.Bye(); err != ErrCallNotConfirmed {
+		t.Errorf("Bye before the answer: %v", err)
+	}
+
+	//the 2xx is acknowledged within the dialog
+	ringing := withToTag(CreateResponse(invite, RINGING), "b1")
+	ringing.GetHeader().Set("Contact", "<sip:bob@192.0.2.7>")
+	p.processResponse(ringing)
+	if c.GetState() != CALLSTATE_EARLY {
+		t.Errorf("state %v after 180", c.GetState())
+	}
+	ok200 := withToTag(CreateResponse(invite, OK), "b1")
+	ok200.GetHeader().Set("Contact", "<sip:bob@192.0.2.7>")
+	ok200.SetBody(NewSDPBody([]byte("v=0\r\no=bob\r\n")))
+	p.processResponse(ok200)
+	waitSent(t, sent, 2)
+	ack := findSent(sent, ACK)
+	if ack == nil || ack.GetRequestURIString() != "sip:bob@192.0.2.7" || ack.GetHeader().Get("Cseq") != "1 ACK" {
+		t.Fatalf("ACK %v", ack)
+	}
+	if c.GetState() != CALLSTATE_CONFIRMED || c.GetDialog() == nil || string(c.GetRemoteSDP()) != "v=0\r\no=bob\r\n" {
+		t.Fatalf("call %v, dialog %v", c.GetState(), c.GetDialog())
+	}
+
+	//Bye hangs up, and the call ends with the response to the BYE
+	if err := c.Bye(); err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, 3)
+	bye := findSent(sent, BYE)
+	if bye == nil || bye.GetHeader().Get("Cseq") != "2 BYE" {
+		t.Fatalf("BYE %v", bye)
+	}
+	p.processResponse(CreateResponse(bye, OK))
+	if len(ended) != 1 || ended[0] != c || c.GetState() != CALLSTATE_TERMINATED {
+		t.Errorf("ended %v, state %v", ended, c.GetState())
+	}
+}
+
+func TestUserAgentOutgoingCallChallenged(t *testing.T) {
+	p, sent := newUserAgentTestProvider()
+	defer p.Stop()
+
+	ua, err := NewUserAgent(p, "sip:alice@example.com", "sip:alice@192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddListener(ua)
+	var ended []Call
+	ua.OnCallTerminated(func(call Call) { ended = append(ended, call) })
+
+	//without an Authorizer a challenge ends the call
+	c, err := ua.Invite(context.Background(), "sip:bob@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, 1)
+	p.processResponse(withToTag(CreateResponse(findSent(sent, INVITE), PROXY_AUTHENTICATION_REQUIRED), "px"))
+	if len(ended) != 1 || c.GetState() != CALLSTATE_TERMINATED {
+		t.Fatalf("ended %v, state %v", ended, c.GetState())
+	}
+
+	//with one the INVITE is sent again, with the next CSeq
+	ua.SetAuthorizer(authorizerFunc(func(req Request, resp Response) error {
+		req.GetHeader().Set("Proxy-Authorization", "Digest username=\"alice\"")
+		return nil
+	}))
+	c, err = ua.Invite(context.Background(), "sip:bob@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitSent(t, sent, 3)
+	first := findSent(sent, INVITE)
+	p.processResponse(withToTag(CreateResponse(first, PROXY_AUTHENTICATION_REQUIRED), "px"))
+	waitSent(t, sent, 5)
+	second := findSent(sent, INVITE)
+	if h := second.GetHeader(); h.Get("Proxy-Authorization") == "" || h.Get("Cseq") != "2 INVITE" || h.Get("Call-Id") != c.GetCallId() || getTag(h.Get("From")) != getTag(first.GetHeader().Get("From")) {
+		t.Errorf("INVITE sent again with %v", h)
+	}
+	if len(ended) != 1 || c.GetState() != CALLSTATE_CALLING || c.GetInvite() != second {
+		t.Errorf("challenged call %v ended %d", c.GetState(), len(ended))
+	}
+}
+
+func TestUserAgentIncomingCall(t *testing.T) {
+	p, sent := newUserAgentTestProvider()
+	defer p.Stop()
+
+	ua, err := NewUserAgent(p, "sip:bob@example.com", "sip:bob@192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddListener(ua)
+	var ended []Call
+
+	//nobody to take the call
+	p.processMessage(newServerTestRequest(INVITE, "UDP", "z9hG4bKua1"))
+	waitSent(t, sent, 1)
+	if resp, ok := sent.last().(Response); !ok || resp.GetStatusCode() != TEMPORARILY_UNAVAILABLE {
+		t.Fatalf("unhandled INVITE answered %v", sent.last())
+	}
+
+	var incoming Call
+	ua.OnIncomingCall(func(call Call) {
+		incoming = call
+		call.SetLocalSDP([]byte("v=0\r\no=bob\r\n"))
+		if err := call.Answer(); err != nil {
+			t.Error(err)
+		}
+	})
+	ua.OnCallTerminated(func(call Call) { ended = append(ended, call) })
+
+	invite := newServerTestRequest(INVITE, "UDP", "z9hG4bKua2")
+	invite.GetHeader().Set("Call-Id", "ua2@example.com")
+	invite.GetHeader().Set("Contact", "<sip:alice@192.0.2.1>")
+	invite.SetBody(NewSDPBody([]byte("v=0\r\no=alice\r\n")))
+	invite.SetMessageInfo(&MessageInfo{Network: UDP, LocalAddr: &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5060}})
+	p.processMessage(invite)
+	waitSent(t, sent, 2)
+	ok200, ok := sent.last().(Response)
+	if !ok || ok200.GetStatusCode() != OK || ok200.GetHeader().Get("Contact") != "<sip:bob@192.0.2.2>" || string(ok200.GetBodyBytes()) != "v=0\r\no=bob\r\n" {
+		t.Fatalf("INVITE answered %v", sent.last())
+	}
+	if incoming == nil || incoming.GetState() != CALLSTATE_CONFIRMED || incoming.GetDialog() == nil {
+		t.Fatalf("incoming call %v", incoming)
+	}
+
+	//the caller hangs up
+	bye := NewRequest(BYE, "sip:bob@192.0.2.2", nil)
+	bye.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.1;branch=z9hG4bKua3")
+	bye.GetHeader().Set("From", "<sip:alice@example.com>;tag=1")
+	bye.GetHeader().Set("To", ok200.GetHeader().Get("To"))
+	bye.GetHeader().Set("Call-Id", "ua2@example.com")
+	bye.GetHeader().Set("Cseq", "2 BYE")
+	p.processMessage(bye)
+	waitSent(t, sent, 3)
+	if resp, ok := sent.last().(Response); !ok || resp.GetStatusCode() != OK || resp.GetHeader().Get("Cseq") != "2 BYE" {
+		t.Errorf("BYE answered %v", sent.last())
+	}
+	if len(ended) != 1 || ended[0] != incoming || incoming.GetState() != CALLSTATE_TERMINATED {
+		t.Errorf("ended %v, state %v", ended, incoming.GetState())
+	}
+}
+
+func TestNewUserAgent(t *testing.T) {
+	p := newProvider(TraceOff(), NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	if _, err := NewUserAgent(p, "tel:+15551234", "sip:alice@192.0.2.1"); err != ErrBadAddressOfRecord {
+		t.Errorf("tel: address of record %v", err)
+	}
+	for _, contact := range []string{"tel:+15551234", "alice@192.0.2.1"} {
+		if _, err := NewUserAgent(p, "sip:alice@example.com", contact); err != ErrBadContact {
+			t.Errorf("contact %q %v", contact, err)
+		}
+	}
+}
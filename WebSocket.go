@@ -0,0 +1,415 @@
+package sip
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SIP over WebSocket (RFC 7118): a connection starts with the HTTP upgrade
+// of RFC 6455 negotiating the "sip" subprotocol, then carries one SIP
+// message in each WebSocket message. A wsConn serves such a connection as
+// a net.Conn the way datagramConn serves a UDP socket: Read returns io.EOF
+// at the end of a message, and each Write sends one message.
+
+const (
+	WEBSOCKET_SUBPROTOCOL = "sip"
+
+	// WEBSOCKET_MESSAGE_SIZE bounds the messages read, fragmented or not.
+	WEBSOCKET_MESSAGE_SIZE = 1 << 20
+
+	// WEBSOCKET_HANDSHAKE_TIMEOUT bounds the upgrade of an accepted
+	// connection.
+	WEBSOCKET_HANDSHAKE_TIMEOUT = 10 * time.Second
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+var (
+	ErrWebSocketHandshake = errors.New("sip: WebSocket handshake failed")
+	errWebSocketFrame     = errors.New("sip: malformed WebSocket frame")
+)
+
+type wsConn struct {
+	net.Conn
+
+	client bool
+	reader *bufio.Reader
+
+	//messages read by the reader goroutine, closed when it stops
+	messages chan []byte
+	ready    chan bool
+	err      error
+	quit     chan bool
+	once     sync.Once
+
+	readMutex sync.Mutex
+	unread    []byte
+	pending   bool
+	deadline  time.Time
+
+	writeMutex sync.Mutex
+}
+
+func newWSConn(conn net.Conn, reader *bufio.Reader, client bool) *wsConn {
+	this := &wsConn{}
+
+	this.Conn = conn
+	this.client = client
+	this.reader = reader
+	if this.reader == nil {
+		this.reader = bufio.NewReader(conn)
+	}
+	this.messages = make(chan []byte, 16)
+	this.ready = make(chan bool)
+	this.quit = make(chan bool)
+
+	return this
+}
+
+// acceptWebSocket serves an accepted connection, upgrading it on a
+// goroutine of its own so that a slow client does not hold up Accept.
+func acceptWebSocket(conn net.Conn) *wsConn {
+	this := newWSConn(conn, nil, false)
+	go func() {
+		if err := this.serverHandshake(); err != nil {
+			this.stop(err)
+			this.Conn.Close()
+			return
+		}
+		close(this.ready)
+		this.readLoop()
+	}()
+	return this
+}
+
+// dialWebSocket upgrades a dialed connection to host.
+func dialWebSocket(conn net.Conn, host string) (*wsConn, error) {
+	this := newWSConn(conn, nil, true)
+	if err := this.clientHandshake(host); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	close(this.ready)
+	go this.readLoop()
+	return this, nil
+}
+
+func websocketAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func hasHeaderToken(h http.Header, name, token string) bool {
+	for _, v := range h[name] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (this *wsConn) serverHandshake() error {
+	this.Conn.SetDeadline(time.Now().Add(WEBSOCKET_HANDSHAKE_TIMEOUT))
+	defer this.Conn.SetDeadline(time.Time{})
+
+	req, err := http.ReadRequest(this.reader)
+	if err != nil {
+		return err
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != http.MethodGet || key == "" ||
+		!hasHeaderToken(req.Header, "Upgrade", "websocket") ||
+		!hasHeaderToken(req.Header, "Connection", "upgrade") ||
+		req.Header.Get("Sec-WebSocket-Version") != "13" ||
+		!hasHeaderToken(req.Header, "Sec-Websocket-Protocol", WEBSOCKET_SUBPROTOCOL) {
+		io.WriteString(this.Conn, "HTTP/1.1 400 Bad Request\r\nConnection: close\r\n\r\n")
+		return ErrWebSocketHandshake
+	}
+
+	_, err = io.WriteString(this.Conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: "+websocketAccept(key)+"\r\n"+
+		"Sec-WebSocket-Protocol: "+WEBSOCKET_SUBPROTOCOL+"\r\n\r\n")
+	return err
+}
+
+func (this *wsConn) clientHandshake(host string) error {
+	this.Conn.SetDeadline(time.Now().Add(WEBSOCKET_HANDSHAKE_TIMEOUT))
+	defer this.Conn.SetDeadline(time.Time{})
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	if _, err := io.WriteString(this.Conn, "GET / HTTP/1.1\r\n"+
+		"Host: "+host+"\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: "+key+"\r\n"+
+		"Sec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Protocol: "+WEBSOCKET_SUBPROTOCOL+"\r\n\r\n"); err != nil {
+		return err
+	}
+
+	resp, err := http.ReadResponse(this.reader, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) ||
+		!hasHeaderToken(resp.Header, "Sec-Websocket-Protocol", WEBSOCKET_SUBPROTOCOL) {
+		return ErrWebSocketHandshake
+	}
+	return nil
+}
+
+// readLoop reads the messages of the connection until it fails or closes,
+// answering the control frames on the way.
+func (this *wsConn) readLoop() {
+	var message []byte
+	fragmented := false
+	for {
+		fin, opcode, payload, err := this.readFrame()
+		if err != nil {
+			this.stop(err)
+			return
+		}
+
+		switch opcode {
+		case wsOpPing:
+			this.writeFrame(wsOpPong, payload)
+		case wsOpPong:
+		case wsOpClose:
+			this.writeFrame(wsOpClose, payload)
+			this.stop(io.EOF)
+			this.Conn.Close()
+			return
+		case wsOpText, wsOpBinary, wsOpContinuation:
+			if (opcode == wsOpContinuation) != fragmented || len(message)+len(payload) > WEBSOCKET_MESSAGE_SIZE {
+				this.stop(errWebSocketFrame)
+				this.Conn.Close()
+				return
+			}
+			message = append(message, payload...)
+			fragmented = !fin
+			if fin {
+				select {
+				case this.messages <- message:
+				case <-this.quit:
+					this.stop(net.ErrClosed)
+					return
+				}
+				message = nil
+			}
+		default:
+			this.stop(errWebSocketFrame)
+			this.Conn.Close()
+			return
+		}
+	}
+}
+
+func (this *wsConn) stop(err error) {
+	this.err = err
+	close(this.messages)
+}
+
+// readFrame reads a frame, unmasking its payload. The frames of a client
+// are masked and those of a server are not (RFC 6455 5.1).
+func (this *wsConn) readFrame() (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(this.reader, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := head[0]&0x80 != 0
+	opcode := head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+	if masked == this.client {
+		return false, 0, nil, errWebSocketFrame
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(this.reader, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(this.reader, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(b[:])
+	}
+	if length > WEBSOCKET_MESSAGE_SIZE {
+		return false, 0, nil, errWebSocketFrame
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(this.reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(this.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+func (this *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+
+	var maskBit byte
+	if this.client {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+
+	if this.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	this.writeMutex.Lock()
+	defer this.writeMutex.Unlock()
+
+	_, err := this.Conn.Write(frame)
+	return err
+}
+
+// Read reads the message being read, returning io.EOF at its end, and
+// waits for the next one otherwise. Once the connection is closed it
+// fails with an error wrapping net.ErrClosed.
+func (this *wsConn) Read(b []byte) (int, error) {
+	this.readMutex.Lock()
+	defer this.readMutex.Unlock()
+
+	if !this.pending {
+		var timeout <-chan time.Time
+		if !this.deadline.IsZero() {
+			timer := time.NewTimer(time.Until(this.deadline))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case message, ok := <-this.messages:
+			if !ok {
+				return 0, fmt.Errorf("%w: %v", net.ErrClosed, this.err)
+			}
+			this.unread = message
+			this.pending = true
+		case <-timeout:
+			return 0, &net.OpError{Op: "read", Net: "ws", Addr: this.RemoteAddr(), Err: os.ErrDeadlineExceeded}
+		}
+	}
+	if len(this.unread) == 0 {
+		this.pending = false
+		return 0, io.EOF
+	}
+
+	n := copy(b, this.unread)
+	this.unread = this.unread[n:]
+	return n, nil
+}
+
+// Discard drops what is left of the message being read.
+func (this *wsConn) Discard() {
+	this.readMutex.Lock()
+	defer this.readMutex.Unlock()
+
+	this.unread = nil
+	this.pending = false
+}
+
+// Write sends b as one text message, once the handshake is done.
+func (this *wsConn) Write(b []byte) (int, error) {
+	select {
+	case <-this.ready:
+	case <-time.After(WEBSOCKET_HANDSHAKE_TIMEOUT):
+		return 0, ErrWebSocketHandshake
+	}
+	if err := this.writeFrame(wsOpText, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// SetDeadline only bounds the wait for a message: the frames are read
+// without deadline, by the reader goroutine.
+func (this *wsConn) SetDeadline(t time.Time) error {
+	return this.SetReadDeadline(t)
+}
+
+func (this *wsConn) SetReadDeadline(t time.Time) error {
+	this.readMutex.Lock()
+	defer this.readMutex.Unlock()
+
+	this.deadline = t
+	return nil
+}
+
+func (this *wsConn) Close() error {
+	this.once.Do(func() {
+		close(this.quit)
+		select {
+		case <-this.ready:
+			this.writeFrame(wsOpClose, []byte{0x03, 0xe8}) //1000, normal closure
+		default:
+		}
+	})
+	return this.Conn.Close()
+}
@@ -0,0 +1,146 @@
+package sip
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// The messages a provider sends and receives can be captured as they are
+// on the wire, for sipdump or pcap-style logging or to feed a capture
+// server, by the WireTaps added to it. A received message is captured as
+// the bytes it was read from, once it parsed, and a sent one as the bytes
+// it was written as, once handed to its socket or to the queue of its
+// connection. The keep-alives are not captured.
+
+type WireDirection int
+
+const (
+	WIRE_IN  WireDirection = iota //0
+	WIRE_OUT                      //1
+)
+
+// A WireEvent is a message captured on the wire.
+type WireEvent struct {
+	Direction  WireDirection
+	Network    string
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+	Time       time.Time
+
+	// Data is the message, the WireTap may keep it.
+	Data []byte
+}
+
+////////////////////Interface//////////////////////////////
+
+// A WireTap is given the messages a provider sends and receives. It is
+// called on the goroutine reading or sending the message, and should not
+// block.
+type WireTap interface {
+	Capture(event WireEvent)
+}
+
+////////////////////Implementation////////////////////////
+
+func (this WireDirection) String() string {
+	if this == WIRE_OUT {
+		return "out"
+	}
+	return "in"
+}
+
+// wireRecorder keeps the bytes read from a connection, while the provider
+// has a WireTap, for the message they are parsed into to be captured.
+type wireRecorder struct {
+	reader  io.Reader
+	enabled func() bool
+	data    []byte
+}
+
+func (this *wireRecorder) Read(b []byte) (int, error) {
+	n, err := this.reader.Read(b)
+	if n > 0 && this.enabled() {
+		this.data = append(this.data, b[:n]...)
+	}
+	return n, err
+}
+
+// take returns the bytes recorded but the last buffered ones, read ahead
+// of what was parsed, which it keeps for the next message.
+func (this *wireRecorder) take(buffered int) []byte {
+	if buffered > len(this.data) {
+		buffered = len(this.data)
+	}
+	consumed := len(this.data) - buffered
+	if consumed == 0 {
+		return nil
+	}
+	data := append([]byte(nil), this.data[:consumed]...)
+	this.data = append(this.data[:0], this.data[consumed:]...)
+	return data
+}
+
+// reset drops the bytes recorded.
+func (this *wireRecorder) reset() {
+	this.data = this.data[:0]
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// AddWireTap makes tap capture the messages the provider sends and
+// receives; a WireTap added twice captures them once.
+func (this *provider) AddWireTap(tap WireTap) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for _, t := range this.wireTaps {
+		if t == tap {
+			return
+		}
+	}
+	this.wireTaps = append(this.wireTaps, tap)
+}
+
+func (this *provider) RemoveWireTap(tap WireTap) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for i, t := range this.wireTaps {
+		if t == tap {
+			this.wireTaps = append(this.wireTaps[:i:i], this.wireTaps[i+1:]...)
+			return
+		}
+	}
+}
+
+// hasWireTaps reports whether a WireTap was added to the provider.
+func (this *provider) hasWireTaps() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return len(this.wireTaps) > 0
+}
+
+// captureWire gives data, a message sent or received, to the WireTaps of
+// the provider.
+func (this *provider) captureWire(direction WireDirection, network string, local, remote net.Addr, data []byte) {
+	this.mutex.Lock()
+	taps := this.wireTaps
+	this.mutex.Unlock()
+
+	if len(taps) == 0 {
+		return
+	}
+	event := WireEvent{
+		Direction:  direction,
+		Network:    network,
+		LocalAddr:  local,
+		RemoteAddr: remote,
+		Time:       this.GetClock().Now(),
+	}
+	for _, tap := range taps {
+		event.Data = append([]byte(nil), data...)
+		tap.Capture(event)
+	}
+}
@@ -0,0 +1,131 @@
+package sip
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+type wireListener struct {
+	events chan WireEvent
+}
+
+func (this *wireListener) Capture(event WireEvent) {
+	this.events <- event
+}
+
+func (this *wireListener) next(t *testing.T) WireEvent {
+	select {
+	case event := <-this.events:
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing captured")
+	}
+	return WireEvent{}
+}
+
+// newListeningProvider returns a provider listening on UDP and TCP on the
+// loopback, not running yet, and the addresses it listens on. The sockets
+// are bound here, for their ports to be known before Run.
+func newListeningProvider(t *testing.T) (*provider, *net.UDPAddr, string) {
+	p := newProvider(TraceOff(), RealClock)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := pc.LocalAddr().(*net.UDPAddr)
+	address := lner.Addr().String()
+	for _, socket := range []interface{ File() (*os.File, error) }{pc.(*net.UDPConn), lner.(*net.TCPListener)} {
+		f, err := socket.File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		network := UDP
+		if _, ok := socket.(*net.TCPListener); ok {
+			network = TCP
+		}
+		tr := newTransport(network, "", 0, nil)
+		tr.SetListenerFile(f)
+		p.AddTransport(tr)
+	}
+	pc.Close()
+	lner.Close()
+	return p, server, address
+}
+
+func TestWireTap(t *testing.T) {
+	p, server, address := newListeningProvider(t)
+	tap := &wireListener{events: make(chan WireEvent, 8)}
+	p.AddWireTap(tap)
+	p.AddWireTap(tap)
+	go p.Run()
+	defer p.Stop()
+
+	options := "OPTIONS sip:bob@127.0.0.1 SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP 127.0.0.1:5070;branch=z9hG4bKwire1\r\n" +
+		"From: <sip:alice@example.com>;tag=w1\r\n" +
+		"To: <sip:bob@example.com>\r\n" +
+		"Call-ID: wire1@example.com\r\n" +
+		"CSeq: 1 OPTIONS\r\n" +
+		"Content-Length: 0\r\n\r\n"
+
+	//a datagram is captured as received, a request as sent
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.WriteTo([]byte(options), server)
+	in := tap.next(t)
+	if in.Direction != WIRE_IN || in.Network != UDP || string(in.Data) != options || in.RemoteAddr.String() != client.LocalAddr().String() {
+		t.Errorf("captured %s %s from %v: %q", in.Direction, in.Network, in.RemoteAddr, in.Data)
+	}
+	req := NewRequest(OPTIONS, "sip:alice@"+client.LocalAddr().String(), nil)
+	req.GetHeader().Set("Via", "SIP/2.0/UDP 127.0.0.1;branch=z9hG4bKwire2")
+	req.GetHeader().Set("Cseq", "1 OPTIONS")
+	if err := p.SendRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	out := tap.next(t)
+	buffer := make([]byte, DATAGRAM_SIZE)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := client.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Direction != WIRE_OUT || string(out.Data) != string(buffer[:n]) || out.RemoteAddr.String() != client.LocalAddr().String() {
+		t.Errorf("captured %s to %v: %q, sent %q", out.Direction, out.RemoteAddr, out.Data, buffer[:n])
+	}
+
+	//over a stream, messages pipelined behind keep-alives are captured
+	//one at a time, without the keep-alives
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	second := "OPTIONS sip:bob@127.0.0.1 SIP/2.0\r\n" +
+		"Via: SIP/2.0/TCP 127.0.0.1:5070;branch=z9hG4bKwire3\r\n" +
+		"CSeq: 2 OPTIONS\r\n" +
+		"Content-Length: 5\r\n\r\n" +
+		"hello"
+	conn.Write([]byte("\r\n\r\n" + options + second))
+	for _, want := range []string{options, second} {
+		if in := tap.next(t); string(in.Data) != want || in.Network != TCP {
+			t.Errorf("captured %q over %s, want %q", in.Data, in.Network, want)
+		}
+	}
+
+	p.RemoveWireTap(tap)
+	client.WriteTo([]byte(options), server)
+	select {
+	case event := <-tap.events:
+		t.Errorf("captured %q once removed", event.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -44,6 +44,23 @@ func NewSipURIImpl() *SipURIImpl {
 	return this
 }
 
+/** Creates a sip URI for the given user, host and port. The user may be
+ * empty, and a port of 0 leaves the port out.
+ */
+func NewSipURI(user, host string, port int) *SipURIImpl {
+	this := NewSipURIImpl()
+
+	this.SetHostString(host)
+	if user != "" {
+		this.SetUser(user)
+	}
+	if port > 0 {
+		this.SetPort(port)
+	}
+
+	return this
+}
+
 /** Constructor given the scheme.
  * The scheme must be either Sip or Sips
  */
@@ -734,9 +751,8 @@ func (this *SipURIImpl) GetParameterNames() *list.List {
  * @return the value of the <code>ttl</code> parameter
  */
 func (this *SipURIImpl) GetTTLParam() int {
-	ttl := this.uriParms.GetValue("ttl")
-	if ttl != nil {
-		return ttl.(int)
+	if ttl, err := strconv.Atoi(this.GetParameter("ttl")); err == nil {
+		return ttl
 	}
 	return -1
 }
@@ -748,7 +764,7 @@ func (this *SipURIImpl) GetTTLParam() int {
  */
 func (this *SipURIImpl) GetTransportParam() string {
 	if this.uriParms != nil {
-		return this.uriParms.GetValue(core.SIPTransportNames_TRANSPORT).(string)
+		return this.GetParameter(core.SIPTransportNames_TRANSPORT)
 	}
 	return ""
 }
@@ -903,7 +919,7 @@ func (this *SipURIImpl) SetTTLParam(ttl int) error {
 	}
 	if this.uriParms != nil {
 		this.uriParms.Delete("ttl")
-		nv := core.NewNameValue("ttl", ttl)
+		nv := core.NewNameValue("ttl", strconv.Itoa(ttl))
 		this.uriParms.AddNameValue(nv)
 	}
 
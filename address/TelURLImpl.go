@@ -2,6 +2,7 @@ package address
 
 import (
 	"container/list"
+	"strings"
 )
 
 /** Implementation of the TelURL interface.
@@ -19,6 +20,23 @@ func NewTelURLImpl() *TelURLImpl {
 	this := &TelURLImpl{}
 
 	this.scheme = "tel"
+	this.telephoneNumber = NewTelephoneNumber()
+
+	return this
+}
+
+/** Creates a TelURL for the given phone number; a leading '+' makes it
+ * global.
+ *@param phoneNumber -- the phone number, with its visual separators.
+ */
+func NewTelURL(phoneNumber string) *TelURLImpl {
+	this := NewTelURLImpl()
+
+	if strings.HasPrefix(phoneNumber, "+") {
+		this.SetGlobal(true)
+		phoneNumber = phoneNumber[1:]
+	}
+	this.SetPhoneNumber(phoneNumber)
 
 	return this
 }
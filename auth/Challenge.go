@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"sip"
+	"sip/header"
+	"sip/parser"
+	"strings"
+)
+
+// A Challenge is a WWW-Authenticate or Proxy-Authenticate header of a 401
+// or 407 response (RFC 3261 22.1).
+type Challenge struct {
+	// Header is the name of the header the challenge came in.
+	Header string
+
+	Scheme    string
+	Realm     string
+	Domain    string
+	Nonce     string
+	Opaque    string
+	Algorithm string
+	Qop       []string
+	Stale     bool
+}
+
+// ParseChallenge parses the value of a WWW-Authenticate or
+// Proxy-Authenticate header, named by name.
+func ParseChallenge(name, value string) (*Challenge, error) {
+	var sh header.Header
+	var err error
+	if strings.EqualFold(name, "Proxy-Authenticate") {
+		name = "Proxy-Authenticate"
+		sh, err = parser.NewProxyAuthenticateParser(name + ": " + value + "\n").Parse()
+	} else {
+		name = "WWW-Authenticate"
+		sh, err = parser.NewWWWAuthenticateParser(name + ": " + value + "\n").Parse()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var a *header.Authentication
+	switch h := sh.(type) {
+	case *header.WWWAuthenticate:
+		a = &h.Authentication
+	case *header.ProxyAuthenticate:
+		a = &h.Authentication
+	}
+
+	this := &Challenge{Header: name}
+
+	this.Scheme = a.GetScheme()
+	this.Realm = a.GetRealm()
+	this.Domain = a.GetDomain()
+	this.Nonce = a.GetNonce()
+	this.Opaque = a.GetOpaque()
+	this.Algorithm = a.GetAlgorithm()
+	for _, qop := range strings.Split(a.GetQop(), ",") {
+		if qop = strings.TrimSpace(qop); qop != "" {
+			this.Qop = append(this.Qop, strings.ToLower(qop))
+		}
+	}
+	this.Stale = strings.EqualFold(a.GetParameter("stale"), "true")
+
+	return this, nil
+}
+
+// GetChallenges returns the Digest challenges of a 401 or 407 response.
+// Challenges of other schemes are skipped.
+func GetChallenges(resp sip.Response) ([]*Challenge, error) {
+	var challenges []*Challenge
+	for _, name := range []string{"WWW-Authenticate", "Proxy-Authenticate"} {
+		for _, value := range resp.GetHeader()[sip.CanonicalHeaderKey(name)] {
+			ch, err := ParseChallenge(name, value)
+			if err != nil {
+				return nil, err
+			}
+			if ch.IsDigest() {
+				challenges = append(challenges, ch)
+			}
+		}
+	}
+	return challenges, nil
+}
+
+func (this *Challenge) IsDigest() bool {
+	return strings.EqualFold(this.Scheme, "Digest")
+}
+
+// GetAuthorizationHeader returns the name of the header answering the
+// challenge: Authorization or Proxy-Authorization.
+func (this *Challenge) GetAuthorizationHeader() string {
+	if this.Header == "Proxy-Authenticate" {
+		return "Proxy-Authorization"
+	}
+	return "Authorization"
+}
+
+// HasQop reports whether the challenge offers qop.
+func (this *Challenge) HasQop(qop string) bool {
+	for _, q := range this.Qop {
+		if q == qop {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sip"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+////////////////////Interface//////////////////////////////
+
+// Credentials authenticate a user in a realm. An empty Realm answers the
+// challenges of any realm.
+type Credentials struct {
+	Username string
+	Password string
+	Realm    string
+}
+
+// An Authenticator answers the digest challenges of 401 and 407 responses
+// (RFC 3261 22.2, 22.3). Authorize adds to req, the request which was
+// challenged by resp, an Authorization or Proxy-Authorization header for
+// each challenge it has credentials for, replacing those of the same realm.
+type Authenticator interface {
+	Authorize(req sip.Request, resp sip.Response) error
+}
+
+// A Client sends requests like Provider.Do, and resubmits those challenged
+// by a 401 or 407 with the credentials of its Authenticator. The response
+// returned is the last one received: a 401 or 407 when the credentials are
+// missing or rejected.
+type Client interface {
+	Do(ctx context.Context, req sip.Request) (sip.Response, error)
+}
+
+var (
+	ErrNoChallenge   = errors.New("auth: no Digest challenge")
+	ErrNoCredentials = errors.New("auth: no credentials for the realm challenged")
+)
+
+// The times a request is resubmitted: once for a 401 and once for a 407,
+// which may follow it.
+const MAX_ATTEMPTS = 2
+
+////////////////////Implementation////////////////////////
+
+type authenticator struct {
+	mutex sync.Mutex
+
+	credentials []Credentials
+	//nonce-count of each nonce answered, RFC 3261 22.4
+	counts map[string]uint32
+}
+
+func NewAuthenticator(credentials ...Credentials) Authenticator {
+	this := &authenticator{}
+
+	this.credentials = credentials
+	this.counts = make(map[string]uint32)
+
+	return this
+}
+
+func (this *authenticator) getCredentials(realm string) (Credentials, bool) {
+	for _, c := range this.credentials {
+		if c.Realm == "" || c.Realm == realm {
+			return c, true
+		}
+	}
+	return Credentials{}, false
+}
+
+func (this *authenticator) nextCount(nonce string) uint32 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.counts[nonce]++
+	return this.counts[nonce]
+}
+
+func (this *authenticator) Authorize(req sip.Request, resp sip.Response) error {
+	challenges, err := GetChallenges(resp)
+	if err != nil {
+		return err
+	}
+	if len(challenges) == 0 {
+		return ErrNoChallenge
+	}
+
+	//for auth-int
+	body := req.GetBodyBytes()
+
+	answered := 0
+	for _, ch := range challenges {
+		c, ok := this.getCredentials(ch.Realm)
+		if !ok {
+			continue
+		}
+		a, err := this.authorize(ch, c, req, body)
+		if err != nil {
+			return err
+		}
+		setAuthorization(req, ch.GetAuthorizationHeader(), a)
+		answered++
+	}
+	if answered == 0 {
+		return ErrNoCredentials
+	}
+
+	return nil
+}
+
+func (this *authenticator) authorize(ch *Challenge, c Credentials, req sip.Request, body []byte) (*Authorization, error) {
+	a := &Authorization{
+		Username:  c.Username,
+		Realm:     ch.Realm,
+		Nonce:     ch.Nonce,
+		URI:       req.GetRequestURIString(),
+		Algorithm: ch.Algorithm,
+		Opaque:    ch.Opaque,
+	}
+
+	//auth is preferred, auth-int only used when it is the sole choice
+	if ch.HasQop(QOP_AUTH) {
+		a.Qop = QOP_AUTH
+	} else if ch.HasQop(QOP_AUTH_INT) {
+		a.Qop = QOP_AUTH_INT
+	}
+	nc := ""
+	if a.Qop != "" {
+		a.Cnonce = sip.GenerateTag()
+		a.Nc = this.nextCount(ch.Nonce)
+		nc = formatCount(a.Nc)
+	}
+
+	ha1, err := HA1(ch.Algorithm, c.Username, ch.Realm, c.Password)
+	if err != nil {
+		return nil, err
+	}
+	if a.Response, err = Response(ch.Algorithm, ha1, a.Nonce, nc, a.Cnonce, a.Qop, req.GetMethod(), a.URI, body); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// setAuthorization replaces the credentials for the realm of a in the
+// header name of req.
+func setAuthorization(req sip.Request, name string, a *Authorization) {
+	h := req.GetHeader()
+
+	var values []string
+	for _, v := range h[sip.CanonicalHeaderKey(name)] {
+		if old, err := ParseAuthorization(name, v); err == nil && old.Realm == a.Realm {
+			continue
+		}
+		values = append(values, v)
+	}
+	h.Del(name)
+	for _, v := range values {
+		h.Add(name, v)
+	}
+	h.Add(name, a.String())
+}
+
+func formatCount(nc uint32) string {
+	s := strconv.FormatUint(uint64(nc), 16)
+	return strings.Repeat("0", 8-len(s)) + s
+}
+
+type client struct {
+	provider      sip.Provider
+	authenticator Authenticator
+}
+
+func NewClient(provider sip.Provider, authenticator Authenticator) Client {
+	this := &client{}
+
+	this.provider = provider
+	this.authenticator = authenticator
+
+	return this
+}
+
+func (this *client) Do(ctx context.Context, req sip.Request) (sip.Response, error) {
+	for attempt := 0; ; attempt++ {
+		//keep the request intact for the retry, the transport consumes its body
+		next, err := copyRequest(req)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := this.provider.Do(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		code := resp.GetStatusCode()
+		if (code != sip.UNAUTHORIZED && code != sip.PROXY_AUTHENTICATION_REQUIRED) || attempt == MAX_ATTEMPTS {
+			return resp, nil
+		}
+
+		if err := this.authenticator.Authorize(next, resp); err != nil {
+			return resp, nil
+		}
+		if err := nextTransaction(next); err != nil {
+			return nil, err
+		}
+		req = next
+	}
+}
+
+// copyRequest copies the start line, headers and body of req.
+func copyRequest(req sip.Request) (sip.Request, error) {
+	body := req.GetBodyBytes()
+
+	c := sip.NewRequest(req.GetMethod(), req.GetRequestURIString(), nil)
+	for key, values := range req.GetHeader() {
+		c.GetHeader()[key] = append([]string(nil), values...)
+	}
+	if body != nil {
+		c.SetBody(bytes.NewReader(body))
+	}
+
+	return c, nil
+}
+
+// nextTransaction makes req a new transaction of the same call: its CSeq
+// is incremented and its Via gets a new branch (RFC 3261 22.2).
+func nextTransaction(req sip.Request) error {
+	h := req.GetHeader()
+
+	fields := strings.Fields(h.Get("Cseq"))
+	if len(fields) != 2 {
+		return errors.New("auth: malformed CSeq")
+	}
+	seq, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return err
+	}
+	h.Set("Cseq", strconv.Itoa(seq+1)+" "+fields[1])
+
+	vias := h[sip.CanonicalHeaderKey("Via")]
+	if len(vias) == 0 {
+		return errors.New("auth: missing Via")
+	}
+	via := vias[0]
+	if i := strings.Index(strings.ToLower(via), ";branch="); i >= 0 {
+		end := strings.IndexAny(via[i+1:], ";,")
+		if end < 0 {
+			via = via[:i]
+		} else {
+			via = via[:i] + via[i+1+end:]
+		}
+	}
+	vias[0] = via + ";branch=" + sip.GenerateBranchId()
+
+	return nil
+}
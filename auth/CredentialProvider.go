@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A CredentialProvider looks up the secrets of the users a server
+// authenticates: the HA1 of username in realm for a digest algorithm (see
+// HA1), so that the passwords themselves can stay in the subscriber
+// database. LookupHA1 may block, on a database or a remote service, until
+// ctx is done; it returns ErrUnknownUser for a user it does not know.
+type CredentialProvider interface {
+	LookupHA1(ctx context.Context, username, realm, algorithm string) (string, error)
+}
+
+// CredentialProviderFunc adapts a function to a CredentialProvider.
+type CredentialProviderFunc func(ctx context.Context, username, realm, algorithm string) (string, error)
+
+func (f CredentialProviderFunc) LookupHA1(ctx context.Context, username, realm, algorithm string) (string, error) {
+	return f(ctx, username, realm, algorithm)
+}
+
+var ErrUnknownUser = errors.New("auth: unknown user")
+
+////////////////////Implementation////////////////////////
+
+type staticCredentialProvider struct {
+	credentials []Credentials
+}
+
+// NewStaticCredentialProvider returns a CredentialProvider knowing the
+// passwords of credentials, e.g. for tests and small deployments.
+func NewStaticCredentialProvider(credentials ...Credentials) CredentialProvider {
+	this := &staticCredentialProvider{}
+
+	this.credentials = credentials
+
+	return this
+}
+
+func (this *staticCredentialProvider) LookupHA1(ctx context.Context, username, realm, algorithm string) (string, error) {
+	for _, c := range this.credentials {
+		if c.Username == username && (c.Realm == "" || c.Realm == realm) {
+			return HA1(algorithm, username, realm, c.Password)
+		}
+	}
+	return "", ErrUnknownUser
+}
+
+type httpCredentialProvider struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPCredentialProvider returns a CredentialProvider asking a web
+// service for the HA1 of the users. Each lookup is a GET of rawurl with
+// the query parameters username, realm and algorithm added, e.g.
+//
+//	GET /ha1?algorithm=MD5&realm=atlanta.com&username=alice
+//
+// answered by 200 OK with a JSON object {"ha1": "..."}, or by 404 Not Found
+// for an unknown user. The request is cancelled with the lookup's ctx. A
+// nil client is http.DefaultClient.
+func NewHTTPCredentialProvider(rawurl string, client *http.Client) (CredentialProvider, error) {
+	if _, err := url.Parse(rawurl); err != nil {
+		return nil, err
+	}
+
+	this := &httpCredentialProvider{}
+
+	this.url = rawurl
+	this.client = client
+	if this.client == nil {
+		this.client = http.DefaultClient
+	}
+
+	return this, nil
+}
+
+func (this *httpCredentialProvider) LookupHA1(ctx context.Context, username, realm, algorithm string) (string, error) {
+	u, _ := url.Parse(this.url)
+	query := u.Query()
+	query.Set("username", username)
+	query.Set("realm", realm)
+	if algorithm == "" {
+		algorithm = MD5
+	}
+	query.Set("algorithm", algorithm)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", ErrUnknownUser
+	default:
+		return "", fmt.Errorf("auth: credential lookup: %s", resp.Status)
+	}
+
+	var body struct {
+		HA1 string `json:"ha1"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("auth: credential lookup: %v", err)
+	}
+	if body.HA1 == "" {
+		return "", errors.New("auth: credential lookup: no ha1")
+	}
+	return body.HA1, nil
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"sip/header"
+	"sip/parser"
+	"strconv"
+	"strings"
+)
+
+// The digest algorithms of RFC 8760, each with its session variant.
+const (
+	MD5             = "MD5"
+	MD5_SESS        = "MD5-sess"
+	SHA256          = "SHA-256"
+	SHA256_SESS     = "SHA-256-sess"
+	SHA512_256      = "SHA-512-256"
+	SHA512_256_SESS = "SHA-512-256-sess"
+)
+
+const (
+	QOP_AUTH     = "auth"
+	QOP_AUTH_INT = "auth-int"
+)
+
+var (
+	ErrAlgorithm = errors.New("auth: unsupported digest algorithm")
+	ErrScheme    = errors.New("auth: not a Digest authorization")
+)
+
+// getHash returns the hash function of algorithm, and whether it is a
+// session variant. An empty algorithm is MD5 (RFC 3261 22.4).
+func getHash(algorithm string) (func() hash.Hash, bool, error) {
+	sess := false
+	if strings.HasSuffix(strings.ToLower(algorithm), "-sess") {
+		algorithm = algorithm[:len(algorithm)-len("-sess")]
+		sess = true
+	}
+	switch strings.ToUpper(algorithm) {
+	case "", MD5:
+		return md5.New, sess, nil
+	case SHA256:
+		return sha256.New, sess, nil
+	case SHA512_256:
+		return sha512.New512_256, sess, nil
+	}
+	return nil, false, ErrAlgorithm
+}
+
+func digest(h func() hash.Hash, s string) string {
+	d := h()
+	d.Write([]byte(s))
+	return hex.EncodeToString(d.Sum(nil))
+}
+
+// HA1 returns H(username:realm:password), the secret a server keeps for
+// a user instead of the password. The session variants are derived from it
+// by Response.
+func HA1(algorithm, username, realm, password string) (string, error) {
+	h, _, err := getHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	return digest(h, username+":"+realm+":"+password), nil
+}
+
+// Response computes the request-digest of RFC 3261 22.4 and RFC 8760 from
+// ha1. Without qop it is the RFC 2069 form H(HA1:nonce:H(A2)); body is only
+// used by qop=auth-int.
+func Response(algorithm, ha1, nonce, nc, cnonce, qop, method, uri string, body []byte) (string, error) {
+	h, sess, err := getHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	if sess {
+		ha1 = digest(h, ha1+":"+nonce+":"+cnonce)
+	}
+
+	a2 := method + ":" + uri
+	if qop == QOP_AUTH_INT {
+		d := h()
+		d.Write(body)
+		a2 += ":" + hex.EncodeToString(d.Sum(nil))
+	}
+	ha2 := digest(h, a2)
+
+	if qop == "" {
+		return digest(h, ha1+":"+nonce+":"+ha2), nil
+	}
+	return digest(h, ha1+":"+nonce+":"+nc+":"+cnonce+":"+qop+":"+ha2), nil
+}
+
+// An Authorization holds the credentials of an Authorization or
+// Proxy-Authorization header.
+type Authorization struct {
+	Username  string
+	Realm     string
+	Nonce     string
+	URI       string
+	Response  string
+	Algorithm string
+	Cnonce    string
+	Opaque    string
+	Qop       string
+	Nc        uint32
+}
+
+// ParseAuthorization parses the value of an Authorization or
+// Proxy-Authorization header, named by name.
+func ParseAuthorization(name, value string) (*Authorization, error) {
+	var sh header.Header
+	var err error
+	if strings.EqualFold(name, "Proxy-Authorization") {
+		sh, err = parser.NewProxyAuthorizationParser("Proxy-Authorization: " + value + "\n").Parse()
+	} else {
+		sh, err = parser.NewAuthorizationParser("Authorization: " + value + "\n").Parse()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var a *header.Authentication
+	switch h := sh.(type) {
+	case *header.Authorization:
+		a = &h.Authentication
+	case *header.ProxyAuthorization:
+		a = &h.Authentication
+	}
+	if !strings.EqualFold(a.GetScheme(), "Digest") {
+		return nil, ErrScheme
+	}
+
+	this := &Authorization{}
+
+	this.Username = a.GetUsername()
+	this.Realm = a.GetRealm()
+	this.Nonce = a.GetNonce()
+	this.URI = a.GetParameter(header.ParameterNames_URI)
+	this.Response = a.GetResponse()
+	this.Algorithm = a.GetAlgorithm()
+	this.Cnonce = a.GetCNonce()
+	this.Opaque = a.GetOpaque()
+	this.Qop = a.GetQop()
+	if nc := a.GetParameter(header.ParameterNames_NC); nc != "" {
+		n, err := strconv.ParseUint(nc, 16, 32)
+		if err != nil {
+			return nil, err
+		}
+		this.Nc = uint32(n)
+	}
+
+	return this, nil
+}
+
+// String formats the header value. The cnonce and nc are only written with
+// a qop, as RFC 3261 22.4 requires.
+func (this *Authorization) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Digest username=%q, realm=%q, nonce=%q, uri=%q, response=%q",
+		this.Username, this.Realm, this.Nonce, this.URI, this.Response)
+	if this.Algorithm != "" {
+		b.WriteString(", algorithm=" + this.Algorithm)
+	}
+	if this.Qop != "" {
+		fmt.Fprintf(&b, ", cnonce=%q, qop=%s, nc=%08x", this.Cnonce, this.Qop, this.Nc)
+	}
+	if this.Opaque != "" {
+		fmt.Fprintf(&b, ", opaque=%q", this.Opaque)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"io/ioutil"
+	"sip"
+	"strings"
+	"testing"
+)
+
+func TestResponse(t *testing.T) {
+	//RFC 2617 3.5 and RFC 7616 3.9.1
+	var tv = []struct {
+		algorithm, realm, password, nonce, cnonce, response string
+	}{
+		{MD5, "testrealm@host.com", "Circle Of Life", "dcd98b7102dd2f0e8b11d0f600bfb0c093", "0a4f113b",
+			"6629fae49393a05397450978507c4ef1"},
+		{MD5, "http-auth@example.org", "Circle of Life", "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			"8ca523f5e9506fed4657c9700eebdbec"},
+		{SHA256, "http-auth@example.org", "Circle of Life", "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ",
+			"753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+	}
+
+	for _, v := range tv {
+		ha1, err := HA1(v.algorithm, "Mufasa", v.realm, v.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := Response(v.algorithm, ha1, v.nonce, "00000001", v.cnonce, QOP_AUTH, "GET", "/dir/index.html", nil)
+		if err != nil || response != v.response {
+			t.Errorf("%s response = %s, %v, want %s", v.algorithm, response, err, v.response)
+		}
+	}
+
+	if _, err := HA1("SHA-1", "Mufasa", "realm", "secret"); err != ErrAlgorithm {
+		t.Errorf("SHA-1 = %v, want ErrAlgorithm", err)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	ch, err := ParseChallenge("Proxy-Authenticate",
+		`Digest realm="atlanta.com", domain="sip:ss1.carrier.com", qop="auth,auth-int", nonce="f84f1cec41e6cbe5aea9c8e88d359", opaque="", stale=TRUE, algorithm=SHA-256`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ch.IsDigest() || ch.Realm != "atlanta.com" || ch.Nonce != "f84f1cec41e6cbe5aea9c8e88d359" || ch.Algorithm != SHA256 || !ch.Stale {
+		t.Errorf("challenge = %+v", ch)
+	}
+	if !ch.HasQop(QOP_AUTH) || !ch.HasQop(QOP_AUTH_INT) {
+		t.Errorf("qop = %v", ch.Qop)
+	}
+	if ch.GetAuthorizationHeader() != "Proxy-Authorization" {
+		t.Errorf("answered in %s", ch.GetAuthorizationHeader())
+	}
+}
+
+func newChallengedRequest() sip.Request {
+	req := sip.NewRequest(sip.INVITE, "sip:bob@biloxi.com", strings.NewReader("v=0\r\n"))
+	req.GetHeader().Set("Via", "SIP/2.0/UDP 192.0.2.1;branch=z9hG4bK776asdhds;rport")
+	req.GetHeader().Set("From", "<sip:alice@atlanta.com>;tag=1928301774")
+	req.GetHeader().Set("To", "<sip:bob@biloxi.com>")
+	req.GetHeader().Set("Call-Id", "a84b4c76e66710")
+	req.GetHeader().Set("Cseq", "314159 INVITE")
+	return req
+}
+
+func TestAuthorize(t *testing.T) {
+	req := newChallengedRequest()
+	resp := sip.CreateResponse(req, sip.PROXY_AUTHENTICATION_REQUIRED)
+	resp.GetHeader().Set("Proxy-Authenticate", `Digest realm="atlanta.com", nonce="wf84f1ceczx41ae6cbe5aea9c8e88d359", qop="auth-int", algorithm=MD5`)
+
+	a := NewAuthenticator(Credentials{Username: "alice", Password: "zanzibar", Realm: "biloxi.com"})
+	if err := a.Authorize(req, resp); err != ErrNoCredentials {
+		t.Errorf("Authorize without credentials = %v", err)
+	}
+
+	a = NewAuthenticator(Credentials{Username: "alice", Password: "zanzibar"})
+	for nc := uint32(1); nc <= 2; nc++ {
+		if err := a.Authorize(req, resp); err != nil {
+			t.Fatal(err)
+		}
+		values := req.GetHeader()["Proxy-Authorization"]
+		if len(values) != 1 {
+			t.Fatalf("Proxy-Authorization = %q", values)
+		}
+		auth, err := ParseAuthorization("Proxy-Authorization", values[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if auth.Username != "alice" || auth.URI != "sip:bob@biloxi.com" || auth.Qop != QOP_AUTH_INT || auth.Nc != nc {
+			t.Errorf("authorization = %+v", auth)
+		}
+		ha1, _ := HA1(MD5, "alice", "atlanta.com", "zanzibar")
+		want, _ := Response(MD5, ha1, auth.Nonce, formatCount(auth.Nc), auth.Cnonce, auth.Qop, sip.INVITE, auth.URI, []byte("v=0\r\n"))
+		if auth.Response != want {
+			t.Errorf("response = %s, want %s", auth.Response, want)
+		}
+	}
+
+	//the body is still there for the transport
+	if body, _ := ioutil.ReadAll(req.GetBody()); string(body) != "v=0\r\n" {
+		t.Errorf("body = %q", body)
+	}
+
+	if err := a.Authorize(req, sip.CreateResponse(req, sip.FORBIDDEN)); err != ErrNoChallenge {
+		t.Errorf("Authorize of a 403 = %v", err)
+	}
+}
+
+func TestNextTransaction(t *testing.T) {
+	req := newChallengedRequest()
+	next, err := copyRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nextTransaction(next); err != nil {
+		t.Fatal(err)
+	}
+
+	if next.GetHeader().Get("Cseq") != "314160 INVITE" {
+		t.Errorf("CSeq = %s", next.GetHeader().Get("Cseq"))
+	}
+	via := next.GetHeader().Get("Via")
+	if !strings.HasPrefix(via, "SIP/2.0/UDP 192.0.2.1;rport;branch="+sip.BRANCH_MAGIC_COOKIE) || strings.Contains(via, "776asdhds") {
+		t.Errorf("Via = %s", via)
+	}
+	if req.GetHeader().Get("Cseq") != "314159 INVITE" {
+		t.Errorf("original CSeq changed to %s", req.GetHeader().Get("Cseq"))
+	}
+	for _, r := range []sip.Request{req, next} {
+		if body, _ := ioutil.ReadAll(r.GetBody()); string(body) != "v=0\r\n" {
+			t.Errorf("body = %q", body)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"sip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A Middleware is a sip.Listener guarding another: the requests are only
+// given to the next listener once their digest credentials check out
+// against the CredentialProvider. The others are answered by the
+// Middleware itself, with a 401 challenging for credentials of its realm,
+// or a 503 when the lookup fails.
+//
+// The lookup runs on a goroutine of its own, bounded by the lookup
+// timeout, so that a slow subscriber database does not hold up the
+// messages of the other calls. An authenticated request is therefore given
+// to the next listener on that goroutine. ACK and CANCEL, which cannot be
+// challenged (RFC 3261 22.1), pass through, as do responses and timeouts.
+//
+// The credentials must be of the algorithm challenged with, and with a qop.
+// The nonces carry their time of issue and a MAC, and are honoured for the
+// nonce lifetime, after which the client is challenged again with
+// stale=true. Meanwhile the Middleware keeps the highest nonce-count each
+// was used with: a request which does not count above it is taken for a
+// replay, and challenged again with stale=true too (RFC 7616 3.4).
+type Middleware interface {
+	sip.DialogListener
+
+	GetRealm() string
+
+	GetLookupTimeout() time.Duration
+	SetLookupTimeout(d time.Duration)
+
+	GetNonceLifetime() time.Duration
+	SetNonceLifetime(d time.Duration)
+}
+
+const (
+	AUTH_LOOKUP_TIMEOUT = 2 * time.Second
+	AUTH_NONCE_LIFETIME = 5 * time.Minute
+)
+
+////////////////////Implementation////////////////////////
+
+type middleware struct {
+	next        sip.Listener
+	realm       string
+	algorithm   string
+	credentials CredentialProvider
+	clock       sip.Clock
+	key         []byte
+
+	mutex         sync.Mutex
+	lookupTimeout time.Duration
+	nonceLifetime time.Duration
+	counts        map[string]*nonceCount //of the nonces in use
+}
+
+// nonceCount is the highest nonce-count a nonce was used with.
+type nonceCount struct {
+	issued time.Time
+	count  uint32
+}
+
+// NewMiddleware guards next with the credentials of realm, challenging
+// with algorithm (MD5 if empty). A nil clock is sip.RealClock.
+func NewMiddleware(next sip.Listener, realm, algorithm string, credentials CredentialProvider, clock sip.Clock) (Middleware, error) {
+	if _, _, err := getHash(algorithm); err != nil {
+		return nil, err
+	}
+
+	this := &middleware{}
+
+	this.next = next
+	this.realm = realm
+	this.algorithm = algorithm
+	if this.algorithm == "" {
+		this.algorithm = MD5
+	}
+	this.credentials = credentials
+	this.clock = clock
+	if this.clock == nil {
+		this.clock = sip.RealClock
+	}
+	this.key = make([]byte, 32)
+	if _, err := rand.Read(this.key); err != nil {
+		return nil, err
+	}
+	this.lookupTimeout = AUTH_LOOKUP_TIMEOUT
+	this.nonceLifetime = AUTH_NONCE_LIFETIME
+	this.counts = make(map[string]*nonceCount)
+
+	return this, nil
+}
+
+func (this *middleware) GetRealm() string {
+	return this.realm
+}
+
+func (this *middleware) GetLookupTimeout() time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.lookupTimeout
+}
+
+func (this *middleware) SetLookupTimeout(d time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.lookupTimeout = d
+}
+
+func (this *middleware) GetNonceLifetime() time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.nonceLifetime
+}
+
+func (this *middleware) SetNonceLifetime(d time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.nonceLifetime = d
+}
+
+func (this *middleware) ProcessRequest(requestEvent sip.RequestEvent) {
+	req := requestEvent.GetRequest()
+	w := requestEvent.GetResponseWriter()
+	if w == nil || req.GetMethod() == sip.ACK || req.GetMethod() == sip.CANCEL {
+		this.next.ProcessRequest(requestEvent)
+		return
+	}
+
+	a := this.getAuthorization(req)
+	if a == nil {
+		this.challenge(w, false)
+		return
+	}
+	issued, ok := this.checkNonce(a.Nonce)
+	if !ok || a.URI != req.GetRequestURIString() {
+		this.challenge(w, false)
+		return
+	}
+	if a.Algorithm == "" {
+		a.Algorithm = MD5
+	}
+	if !strings.EqualFold(a.Algorithm, this.algorithm) || (a.Qop != QOP_AUTH && a.Qop != QOP_AUTH_INT) {
+		this.challenge(w, false)
+		return
+	}
+	a.Algorithm = this.algorithm
+
+	var body []byte
+	if a.Qop == QOP_AUTH_INT {
+		body = req.GetBodyBytes()
+	}
+
+	go this.verify(requestEvent, a, issued, body)
+}
+
+func (this *middleware) verify(requestEvent sip.RequestEvent, a *Authorization, issued time.Time, body []byte) {
+	req := requestEvent.GetRequest()
+	w := requestEvent.GetResponseWriter()
+
+	ctx, cancel := context.WithTimeout(context.Background(), this.GetLookupTimeout())
+	defer cancel()
+
+	ha1, err := this.credentials.LookupHA1(ctx, a.Username, a.Realm, a.Algorithm)
+	if err == ErrUnknownUser {
+		this.challenge(w, false)
+		return
+	} else if err != nil {
+		w.Respond(sip.SERVICE_UNAVAILABLE, nil)
+		return
+	}
+
+	want, err := Response(a.Algorithm, ha1, a.Nonce, formatCount(a.Nc), a.Cnonce, a.Qop, req.GetMethod(), a.URI, body)
+	if err != nil || subtle.ConstantTimeCompare([]byte(want), []byte(a.Response)) != 1 {
+		this.challenge(w, false)
+		return
+	}
+	if this.clock.Since(issued) > this.GetNonceLifetime() || !this.count(a.Nonce, issued, a.Nc) {
+		this.challenge(w, true)
+		return
+	}
+
+	this.next.ProcessRequest(requestEvent)
+}
+
+// count records that nonce, issued then, was used with the nonce-count nc,
+// and reports whether nc is above the counts it was used with before. The
+// nonces past their lifetime are forgotten.
+func (this *middleware) count(nonce string, issued time.Time, nc uint32) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	c, ok := this.counts[nonce]
+	if !ok {
+		for n, c := range this.counts {
+			if this.clock.Since(c.issued) > this.nonceLifetime {
+				delete(this.counts, n)
+			}
+		}
+		c = &nonceCount{issued: issued}
+		this.counts[nonce] = c
+	}
+	if nc <= c.count {
+		return false
+	}
+	c.count = nc
+	return true
+}
+
+// getAuthorization returns the credentials of req for the realm, or nil.
+func (this *middleware) getAuthorization(req sip.Request) *Authorization {
+	for _, v := range req.GetHeader()[sip.CanonicalHeaderKey("Authorization")] {
+		a, err := ParseAuthorization("Authorization", v)
+		if err == nil && a.Realm == this.realm {
+			return a
+		}
+	}
+	return nil
+}
+
+func (this *middleware) challenge(w sip.ResponseWriter, stale bool) {
+	value := "Digest realm=\"" + this.realm + "\", nonce=\"" + this.newNonce() + "\", algorithm=" + this.algorithm + ", qop=\"auth,auth-int\""
+	if stale {
+		value += ", stale=true"
+	}
+	w.Header().Set("WWW-Authenticate", value)
+	w.Respond(sip.UNAUTHORIZED, nil)
+}
+
+// newNonce returns the time of issue in hex, followed by its MAC.
+func (this *middleware) newNonce() string {
+	ts := strconv.FormatInt(this.clock.Now().UnixNano(), 16)
+	return ts + this.mac(ts)
+}
+
+// checkNonce returns the time of issue of a nonce this middleware made.
+func (this *middleware) checkNonce(nonce string) (time.Time, bool) {
+	size := sha256.Size //the MAC is half a SHA-256, in hex
+	if len(nonce) <= size {
+		return time.Time{}, false
+	}
+	ts := nonce[:len(nonce)-size]
+	if !hmac.Equal([]byte(nonce[len(ts):]), []byte(this.mac(ts))) {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(ts, 16, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, n), true
+}
+
+// mac returns the first 128 bits of the HMAC of ts, in hex.
+func (this *middleware) mac(ts string) string {
+	m := hmac.New(sha256.New, this.key)
+	m.Write([]byte(ts))
+	return hex.EncodeToString(m.Sum(nil)[:sha256.Size/2])
+}
+
+func (this *middleware) ProcessResponse(responseEvent sip.ResponseEvent) {
+	this.next.ProcessResponse(responseEvent)
+}
+
+func (this *middleware) ProcessTimeout(timeoutEvent sip.TimeoutEvent) {
+	this.next.ProcessTimeout(timeoutEvent)
+}
+
+func (this *middleware) ProcessDialogTerminated(dialogTerminatedEvent sip.DialogTerminatedEvent) {
+	if next, ok := this.next.(sip.DialogListener); ok {
+		next.ProcessDialogTerminated(dialogTerminatedEvent)
+	}
+}
@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServerTransaction hands the responses sent to a channel.
+type fakeServerTransaction struct {
+	sip.ServerTransaction
+
+	request   sip.Request
+	responses chan sip.Response
+}
+
+func (this *fakeServerTransaction) GetRequest() sip.Request {
+	return this.request
+}
+
+func (this *fakeServerTransaction) SendResponse(resp sip.Response) error {
+	this.responses <- resp
+	return nil
+}
+
+type nextListener struct {
+	requests chan sip.Request
+}
+
+func (this *nextListener) ProcessRequest(requestEvent sip.RequestEvent) {
+	this.requests <- requestEvent.GetRequest()
+}
+
+func (this *nextListener) ProcessResponse(responseEvent sip.ResponseEvent) {}
+
+func (this *nextListener) ProcessTimeout(timeoutEvent sip.TimeoutEvent) {}
+
+// serve gives req to m, and returns the response it sent, or nil if req
+// was passed on to next.
+func serve(t *testing.T, m Middleware, next *nextListener, req sip.Request) sip.Response {
+	st := &fakeServerTransaction{request: req, responses: make(chan sip.Response, 1)}
+	m.ProcessRequest(*sip.NewRequestEvent(st, req))
+
+	select {
+	case resp := <-st.responses:
+		return resp
+	case <-next.requests:
+		return nil
+	case <-time.After(5 * time.Second):
+		t.Fatal("request neither answered nor passed on")
+	}
+	return nil
+}
+
+func TestMiddleware(t *testing.T) {
+	clock := sip.NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	next := &nextListener{requests: make(chan sip.Request, 1)}
+	credentials := CredentialProviderFunc(func(ctx context.Context, username, realm, algorithm string) (string, error) {
+		switch username {
+		case "alice":
+			return HA1(algorithm, username, realm, "zanzibar")
+		case "carol":
+			return "", errors.New("database down")
+		}
+		return "", ErrUnknownUser
+	})
+	m, err := NewMiddleware(next, "atlanta.com", SHA256, credentials, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := newChallengedRequest()
+	challenge := serve(t, m, next, req)
+	if challenge == nil || challenge.GetStatusCode() != sip.UNAUTHORIZED {
+		t.Fatalf("unauthenticated request answered %v", challenge)
+	}
+	ch, err := ParseChallenge("WWW-Authenticate", challenge.GetHeader().Get("WWW-Authenticate"))
+	if err != nil || ch.Realm != "atlanta.com" || ch.Algorithm != SHA256 || ch.Stale {
+		t.Fatalf("challenge = %+v, %v", ch, err)
+	}
+
+	for _, user := range []struct {
+		username, password string
+		statusCode         int
+	}{
+		{"alice", "zanzibar", 0},
+		{"alice", "wrong", sip.UNAUTHORIZED},
+		{"bob", "zanzibar", sip.UNAUTHORIZED},
+		{"carol", "zanzibar", sip.SERVICE_UNAVAILABLE},
+	} {
+		req := newChallengedRequest()
+		NewAuthenticator(Credentials{Username: user.username, Password: user.password}).Authorize(req, challenge)
+		resp := serve(t, m, next, req)
+		if (resp == nil && user.statusCode != 0) || (resp != nil && resp.GetStatusCode() != user.statusCode) {
+			t.Errorf("%s:%s answered %v, want %d", user.username, user.password, resp, user.statusCode)
+		}
+	}
+
+	//a nonce of another server, or tampered with
+	req = newChallengedRequest()
+	forged := sip.CreateResponse(req, sip.UNAUTHORIZED)
+	forged.GetHeader().Set("WWW-Authenticate", `Digest realm="atlanta.com", nonce="`+strings.Repeat("0", 48)+`", algorithm=SHA-256, qop="auth"`)
+	NewAuthenticator(Credentials{Username: "alice", Password: "zanzibar"}).Authorize(req, forged)
+	if resp := serve(t, m, next, req); resp == nil || resp.GetStatusCode() != sip.UNAUTHORIZED {
+		t.Errorf("forged nonce answered %v", resp)
+	}
+
+	//the good credentials on an old nonce are stale
+	clock.Advance(AUTH_NONCE_LIFETIME + time.Second)
+	req = newChallengedRequest()
+	NewAuthenticator(Credentials{Username: "alice", Password: "zanzibar"}).Authorize(req, challenge)
+	resp := serve(t, m, next, req)
+	if resp == nil || resp.GetStatusCode() != sip.UNAUTHORIZED {
+		t.Fatalf("stale nonce answered %v", resp)
+	}
+	if ch, _ := ParseChallenge("WWW-Authenticate", resp.GetHeader().Get("WWW-Authenticate")); ch == nil || !ch.Stale {
+		t.Errorf("challenge = %+v, want stale", ch)
+	}
+
+	//ACK is never challenged
+	ack := newChallengedRequest()
+	ack.SetMethod(sip.ACK)
+	if resp := serve(t, m, next, ack); resp != nil {
+		t.Errorf("ACK answered %d", resp.GetStatusCode())
+	}
+}
+
+func TestMiddlewareCredentials(t *testing.T) {
+	clock := sip.NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	next := &nextListener{requests: make(chan sip.Request, 1)}
+	credentials := CredentialProviderFunc(func(ctx context.Context, username, realm, algorithm string) (string, error) {
+		return HA1(algorithm, username, realm, "zanzibar")
+	})
+	m, err := NewMiddleware(next, "atlanta.com", SHA256, credentials, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := serve(t, m, next, newChallengedRequest())
+	value := challenge.GetHeader().Get("WWW-Authenticate")
+	alice := Credentials{Username: "alice", Password: "zanzibar"}
+
+	//answering with another algorithm, or without qop, is challenged again
+	for _, v := range []string{
+		strings.Replace(value, "algorithm="+SHA256, "algorithm="+MD5, 1),
+		strings.Replace(value, `, qop="auth,auth-int"`, "", 1),
+	} {
+		altered := sip.CreateResponse(newChallengedRequest(), sip.UNAUTHORIZED)
+		altered.GetHeader().Set("WWW-Authenticate", v)
+		req := newChallengedRequest()
+		NewAuthenticator(alice).Authorize(req, altered)
+		resp := serve(t, m, next, req)
+		if resp == nil || resp.GetStatusCode() != sip.UNAUTHORIZED {
+			t.Fatalf("credentials for %s answered %v", v, resp)
+		}
+		if ch, _ := ParseChallenge("WWW-Authenticate", resp.GetHeader().Get("WWW-Authenticate")); ch == nil || ch.Stale {
+			t.Errorf("challenge = %+v, want not stale", ch)
+		}
+	}
+
+	//the nonce-count must go up with each request
+	a := NewAuthenticator(alice)
+	first := newChallengedRequest()
+	a.Authorize(first, challenge)
+	if resp := serve(t, m, next, first); resp != nil {
+		t.Fatalf("nonce-count 1 answered %d", resp.GetStatusCode())
+	}
+	second := newChallengedRequest()
+	a.Authorize(second, challenge)
+	if resp := serve(t, m, next, second); resp != nil {
+		t.Fatalf("nonce-count 2 answered %d", resp.GetStatusCode())
+	}
+	replayed := newChallengedRequest()
+	NewAuthenticator(alice).Authorize(replayed, challenge)
+	for _, req := range []sip.Request{first, replayed} {
+		resp := serve(t, m, next, req)
+		if resp == nil || resp.GetStatusCode() != sip.UNAUTHORIZED {
+			t.Fatalf("replayed nonce-count answered %v", resp)
+		}
+		if ch, _ := ParseChallenge("WWW-Authenticate", resp.GetHeader().Get("WWW-Authenticate")); ch == nil || !ch.Stale {
+			t.Errorf("challenge = %+v, want stale", ch)
+		}
+	}
+}
+
+func TestHTTPCredentialProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("tenant") != "1" || q.Get("realm") != "atlanta.com" || q.Get("algorithm") != MD5 {
+			http.Error(w, "bad query "+r.URL.RawQuery, http.StatusBadRequest)
+			return
+		}
+		switch q.Get("username") {
+		case "alice":
+			ha1, _ := HA1(MD5, "alice", "atlanta.com", "zanzibar")
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"ha1": "` + ha1 + `"}`))
+		case "slow":
+			<-r.Context().Done()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	p, err := NewHTTPCredentialProvider(server.URL+"/ha1?tenant=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := HA1(MD5, "alice", "atlanta.com", "zanzibar")
+	if ha1, err := p.LookupHA1(context.Background(), "alice", "atlanta.com", ""); err != nil || ha1 != want {
+		t.Errorf("alice = %s, %v, want %s", ha1, err, want)
+	}
+	if _, err := p.LookupHA1(context.Background(), "bob", "atlanta.com", MD5); err != ErrUnknownUser {
+		t.Errorf("bob = %v, want ErrUnknownUser", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.LookupHA1(ctx, "slow", "atlanta.com", MD5); err == nil {
+		t.Error("lookup outlived its context")
+	}
+}
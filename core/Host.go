@@ -39,8 +39,11 @@ func NewHost(hname string) *Host {
 	this.hostname = hname
 	if this.isIPv6Address(hname) {
 		this.addressType = IPV6ADDRESS
+	} else if net.ParseIP(hname) != nil {
+		this.addressType = IPV4ADDRESS
+	} else {
+		this.addressType = HOSTNAME
 	}
-	this.addressType = IPV4ADDRESS
 
 	return this
 }
@@ -192,7 +195,8 @@ func (this *Host) GetInetAddress() net.IP {
 	if this.inetAddress != nil {
 		return this.inetAddress
 	}
-	this.inetAddress = net.ParseIP(this.hostname)
+	//an IPv6 reference is enclosed in square brackets
+	this.inetAddress = net.ParseIP(strings.Trim(this.hostname, "[]"))
 	return this.inetAddress
 
 }
@@ -9,6 +9,7 @@ func TestHostNameParser(t *testing.T) {
 		"proxima.chaplin.bt.co.uk",
 		"129.6.55.181:2345",
 		":2345",
+		"[2001:db8::1]:5060",
 	}
 
 	for i := 0; i < len(hostNames); i++ {
@@ -25,3 +26,27 @@ func TestHostNameParser(t *testing.T) {
 		}
 	}
 }
+
+func TestIPv6Host(t *testing.T) {
+	hp, err := NewHostNameParser("[2001:db8::1]:5060").GetHostPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hp.String() != "[2001:db8::1]:5060" || hp.GetPort() != 5060 || !hp.GetHost().IsIPAddress() {
+		t.Errorf("host port %s", hp.String())
+	}
+	if ip := hp.GetHost().GetInetAddress(); ip == nil || ip.String() != "2001:db8::1" {
+		t.Errorf("address %v", ip)
+	}
+
+	//an address without brackets gets them once encoded
+	hp = NewHostPort()
+	hp.SetHost(NewHost("2001:db8::1"))
+	hp.SetPort(5070)
+	if hp.String() != "[2001:db8::1]:5070" {
+		t.Errorf("host port %s", hp.String())
+	}
+	if NewHost("example.com").IsIPAddress() || !NewHost("192.0.2.1").IsIPAddress() {
+		t.Error("host name taken for an address")
+	}
+}
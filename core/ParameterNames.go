@@ -76,6 +76,18 @@ const SIPHeaderNames_SUBSCRIPTION_STATE = "Subscription-State"   //48
 const SIPHeaderNames_EVENT = "Event"                             //44
 const SIPHeaderNames_ALLOW_EVENTS = "Allow-Events"               //45
 const SIPHeaderNames_REFER_TO = "Refer-To"                       //46
+const SIPHeaderNames_ACCEPT_CONTACT = "Accept-Contact"
+const SIPHeaderNames_REJECT_CONTACT = "Reject-Contact"
+const SIPHeaderNames_REQUEST_DISPOSITION = "Request-Disposition"
+const SIPHeaderNames_RESOURCE_PRIORITY = "Resource-Priority"
+const SIPHeaderNames_ANSWER_MODE = "Answer-Mode"
+const SIPHeaderNames_PRIV_ANSWER_MODE = "Priv-Answer-Mode"
+const SIPHeaderNames_SESSION_EXPIRES = "Session-Expires"
+const SIPHeaderNames_MIN_SE = "Min-SE"
+const SIPHeaderNames_REPLACES = "Replaces"
+const SIPHeaderNames_JOIN = "Join"
+const SIPHeaderNames_PATH = "Path"
+const SIPHeaderNames_SERVICE_ROUTE = "Service-Route"
 const SIPHeaderNames_K = "K"
 const SIPHeaderNames_C = "C"
 const SIPHeaderNames_E = "E"
@@ -87,6 +99,10 @@ const SIPHeaderNames_S = "S"
 const SIPHeaderNames_T = "T"
 const SIPHeaderNames_V = "V"
 const SIPHeaderNames_R = "R"
+const SIPHeaderNames_A = "A"
+const SIPHeaderNames_J = "J"
+const SIPHeaderNames_D = "D"
+const SIPHeaderNames_X = "X"
 
 const SIPMethodNames_INVITE = "INVITE"
 const SIPMethodNames_ACK = "ACK"
@@ -0,0 +1,42 @@
+package header
+
+/**
+ * The Accept-Contact header field carries a caller preference (RFC 3841):
+ * a feature predicate describing the contacts the request should preferably
+ * be routed to.
+ * <p>
+ * The value of the header is always "*" followed by feature parameters,
+ * and optionally the "require" and "explicit" parameters. With "require",
+ * contacts that don't match the predicate are discarded instead of only
+ * being ranked lower; with "explicit", only contacts that explicitly
+ * registered the features are considered a match.
+ *
+ * For Example:<br>
+ * <code>Accept-Contact: *;audio;video;require</code>
+ *
+ * @see RejectContactHeader
+ * @see RequestDispositionHeader
+ */
+type AcceptContactHeader interface {
+	ParametersHeader
+
+	/**
+	 * Returns true if the "require" parameter is present.
+	 */
+	IsRequire() bool
+
+	/**
+	 * Sets or removes the "require" parameter.
+	 */
+	SetRequire(require bool)
+
+	/**
+	 * Returns true if the "explicit" parameter is present.
+	 */
+	IsExplicit() bool
+
+	/**
+	 * Sets or removes the "explicit" parameter.
+	 */
+	SetExplicit(explicit bool)
+}
@@ -0,0 +1,64 @@
+package header
+
+import (
+	"bytes"
+	"sip/core"
+)
+
+/**
+* Accept-Contact Header, see RFC 3841.
+ */
+type AcceptContact struct {
+	Parameters
+}
+
+/** Default constructor.
+ */
+func NewAcceptContact() *AcceptContact {
+	this := &AcceptContact{}
+	this.Parameters.super(core.SIPHeaderNames_ACCEPT_CONTACT)
+	return this
+}
+
+func (this *AcceptContact) IsRequire() bool {
+	return this.HasParameter(ParameterNames_REQUIRE)
+}
+
+func (this *AcceptContact) SetRequire(require bool) {
+	if require {
+		this.parameters.SetNameValue(core.NewNameValue(ParameterNames_REQUIRE, nil))
+	} else {
+		this.RemoveParameter(ParameterNames_REQUIRE)
+	}
+}
+
+func (this *AcceptContact) IsExplicit() bool {
+	return this.HasParameter(ParameterNames_EXPLICIT)
+}
+
+func (this *AcceptContact) SetExplicit(explicit bool) {
+	if explicit {
+		this.parameters.SetNameValue(core.NewNameValue(ParameterNames_EXPLICIT, nil))
+	} else {
+		this.RemoveParameter(ParameterNames_EXPLICIT)
+	}
+}
+
+func (this *AcceptContact) String() string {
+	return this.headerName + core.SIPSeparatorNames_COLON +
+		core.SIPSeparatorNames_SP + this.EncodeBody() + core.SIPSeparatorNames_NEWLINE
+}
+
+/** Encode the body of this header (the stuff that follows headerName).
+ * A.K.A headerValue.
+ */
+func (this *AcceptContact) EncodeBody() string {
+	var encoding bytes.Buffer
+	encoding.WriteString(core.SIPSeparatorNames_STAR)
+
+	if this.parameters != nil && this.parameters.Len() > 0 {
+		encoding.WriteString(core.SIPSeparatorNames_SEMICOLON)
+		encoding.WriteString(this.parameters.String())
+	}
+	return encoding.String()
+}
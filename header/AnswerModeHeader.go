@@ -0,0 +1,43 @@
+package header
+
+/**
+ * The Answer-Mode header field asks the UAS to answer a session
+ * automatically or manually (RFC 5373); the Priv-Answer-Mode header field
+ * asks the same with privileges that override the local settings of the
+ * UAS, as needed by intercom and paging services. With the require
+ * parameter, the UAS must reject the request rather than ignore the mode.
+ * In a 2xx response, it tells how the UAS actually answered.
+ *
+ * For Example:<br>
+ * <code>Answer-Mode: Auto;require</code>
+ */
+type AnswerModeHeader interface {
+	ParametersHeader
+
+	/**
+	 * Sets the answer mode, AnswerMode_AUTO or AnswerMode_MANUAL.
+	 */
+	SetMode(mode string) (ParseException error)
+
+	/**
+	 * Returns the answer mode.
+	 */
+	GetMode() string
+
+	/**
+	 * Returns true if the require parameter is present.
+	 */
+	IsRequire() bool
+
+	/**
+	 * Sets or removes the require parameter.
+	 */
+	SetRequire(require bool)
+}
+
+/** The answer modes.
+ */
+const (
+	AnswerMode_AUTO   = "Auto"
+	AnswerMode_MANUAL = "Manual"
+)
@@ -0,0 +1,88 @@
+package header
+
+import (
+	"bytes"
+	"errors"
+	"sip/core"
+	"strings"
+)
+
+/**
+* Answer-Mode and Priv-Answer-Mode Header, see RFC 5373.
+ */
+type AnswerMode struct {
+	Parameters
+
+	/** mode field.
+	 */
+	mode string
+}
+
+/** Default constructor.
+ */
+func NewAnswerMode() *AnswerMode {
+	this := &AnswerMode{}
+	this.Parameters.super(core.SIPHeaderNames_ANSWER_MODE)
+	return this
+}
+
+/** Constructor for Priv-Answer-Mode.
+ */
+func NewPrivAnswerMode() *AnswerMode {
+	this := &AnswerMode{}
+	this.Parameters.super(core.SIPHeaderNames_PRIV_ANSWER_MODE)
+	return this
+}
+
+func (this *AnswerMode) String() string {
+	return this.headerName + core.SIPSeparatorNames_COLON +
+		core.SIPSeparatorNames_SP + this.EncodeBody() + core.SIPSeparatorNames_NEWLINE
+}
+
+/**
+ * Encode value of header into canonical string.
+ * @return encoded value of header.
+ */
+func (this *AnswerMode) EncodeBody() string {
+	var encoding bytes.Buffer
+	encoding.WriteString(this.mode)
+
+	if this.parameters != nil && this.parameters.Len() > 0 {
+		encoding.WriteString(core.SIPSeparatorNames_SEMICOLON)
+		encoding.WriteString(this.parameters.String())
+	}
+	return encoding.String()
+}
+
+/** Set the answer mode. The registered modes are matched
+ * case-insensitively and kept in their canonical case.
+ */
+func (this *AnswerMode) SetMode(mode string) (ParseException error) {
+	if mode == "" {
+		return errors.New("NullPointerException: the mode parameter is null")
+	}
+	switch {
+	case strings.EqualFold(mode, AnswerMode_AUTO):
+		mode = AnswerMode_AUTO
+	case strings.EqualFold(mode, AnswerMode_MANUAL):
+		mode = AnswerMode_MANUAL
+	}
+	this.mode = mode
+	return nil
+}
+
+func (this *AnswerMode) GetMode() string {
+	return this.mode
+}
+
+func (this *AnswerMode) IsRequire() bool {
+	return this.HasParameter(ParameterNames_REQUIRE)
+}
+
+func (this *AnswerMode) SetRequire(require bool) {
+	if require {
+		this.parameters.SetNameValue(core.NewNameValue(ParameterNames_REQUIRE, nil))
+	} else {
+		this.RemoveParameter(ParameterNames_REQUIRE)
+	}
+}
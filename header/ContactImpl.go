@@ -6,6 +6,7 @@ import (
 	"sip/core"
 	"sip/address"
 	"strconv"
+	"strings"
 )
 
 /**
@@ -197,3 +198,95 @@ func (this *Contact) SetQValue(q float32) (InvalidArgumentException error) {
 	this.SetParameter(ParameterNames_Q, strconv.FormatFloat(float64(q), 'f', -1, 32))
 	return nil
 }
+
+/**
+ * Returns the names of the feature tags of this contact (RFC 3840), in
+ * order.
+ */
+func (this *Contact) GetFeatureTagNames() []string {
+	var names []string
+	for e := this.parameters.Front(); e != nil; e = e.Next() {
+		if name := e.Value.(*core.NameValue).GetName(); IsFeatureTag(name) {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	return names
+}
+
+/**
+ * Returns the values of a feature tag, and false if the contact does not
+ * have it. A tag without a value is the boolean TRUE.
+ */
+func (this *Contact) GetFeatureTag(name string) ([]string, bool) {
+	for e := this.parameters.Front(); e != nil; e = e.Next() {
+		nv := e.Value.(*core.NameValue)
+		if strings.EqualFold(nv.GetName(), name) {
+			value, _ := nv.GetValue().(string)
+			return DecodeFeatureValues(value), true
+		}
+	}
+	return nil, false
+}
+
+/**
+ * Sets a feature tag. Without values the tag is a boolean flag, encoded
+ * without a value; otherwise the values are encoded as a quoted list.
+ */
+func (this *Contact) SetFeatureTag(name string, values ...string) {
+	this.RemoveFeatureTag(name)
+	if len(values) == 0 {
+		this.parameters.AddNameValue(core.NewNameValue(name, nil))
+	} else {
+		this.SetQuotedParameter(name, EncodeFeatureValues(values))
+	}
+}
+
+/**
+ * Removes a feature tag.
+ */
+func (this *Contact) RemoveFeatureTag(name string) {
+	for e := this.parameters.Front(); e != nil; e = e.Next() {
+		if strings.EqualFold(e.Value.(*core.NameValue).GetName(), name) {
+			this.parameters.Remove(e)
+			return
+		}
+	}
+}
+
+/**
+ * Returns the instance ID (+sip.instance, RFC 5626) of this contact,
+ * without the enclosing "<" and ">", or "" if it has none.
+ */
+func (this *Contact) GetInstance() string {
+	if values, ok := this.GetFeatureTag(FeatureTag_INSTANCE); ok && len(values) > 0 {
+		return strings.TrimSuffix(strings.TrimPrefix(values[0], "<"), ">")
+	}
+	return ""
+}
+
+/**
+ * Sets the instance ID (+sip.instance, RFC 5626) of this contact, e.g.
+ * "urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6".
+ */
+func (this *Contact) SetInstance(instance string) {
+	this.SetFeatureTag(FeatureTag_INSTANCE, "<"+instance+">")
+}
+
+/**
+ * Returns the reg-id (RFC 5626) of this contact, the flow it registers, or
+ * 0 if it has none or an invalid one.
+ */
+func (this *Contact) GetRegId() int {
+	regId, err := strconv.Atoi(this.GetParameter(ParameterNames_REG_ID))
+	if err != nil || regId <= 0 {
+		return 0
+	}
+	return regId
+}
+
+/**
+ * Sets the reg-id (RFC 5626) of this contact.
+ */
+func (this *Contact) SetRegId(regId int) {
+	this.SetParameter(ParameterNames_REG_ID, strconv.Itoa(regId))
+}
@@ -0,0 +1,88 @@
+package header
+
+import (
+	"strings"
+)
+
+/**
+* Media feature tags of RFC 3840. The base tags are encoded as is and
+* every other tag with a leading "+", e.g. "+sip.instance".
+ */
+const (
+	FeatureTag_AUDIO       = "audio"
+	FeatureTag_APPLICATION = "application"
+	FeatureTag_DATA        = "data"
+	FeatureTag_CONTROL     = "control"
+	FeatureTag_VIDEO       = "video"
+	FeatureTag_TEXT        = "text"
+	FeatureTag_AUTOMATA    = "automata"
+	FeatureTag_CLASS       = "class"
+	FeatureTag_DUPLEX      = "duplex"
+	FeatureTag_MOBILITY    = "mobility"
+	FeatureTag_DESCRIPTION = "description"
+	FeatureTag_EVENTS      = "events"
+	FeatureTag_PRIORITY    = "priority"
+	FeatureTag_METHODS     = "methods"
+	FeatureTag_SCHEMES     = "schemes"
+	FeatureTag_EXTENSIONS  = "extensions"
+	FeatureTag_ISFOCUS     = "isfocus"
+	FeatureTag_ACTOR       = "actor"
+	FeatureTag_LANGUAGE    = "language"
+	FeatureTag_TYPE        = "type"
+	FeatureTag_INSTANCE    = "+sip.instance"
+)
+
+var baseFeatureTags = map[string]bool{
+	FeatureTag_AUDIO: true, FeatureTag_APPLICATION: true, FeatureTag_DATA: true,
+	FeatureTag_CONTROL: true, FeatureTag_VIDEO: true, FeatureTag_TEXT: true,
+	FeatureTag_AUTOMATA: true, FeatureTag_CLASS: true, FeatureTag_DUPLEX: true,
+	FeatureTag_MOBILITY: true, FeatureTag_DESCRIPTION: true, FeatureTag_EVENTS: true,
+	FeatureTag_PRIORITY: true, FeatureTag_METHODS: true, FeatureTag_SCHEMES: true,
+	FeatureTag_EXTENSIONS: true, FeatureTag_ISFOCUS: true, FeatureTag_ACTOR: true,
+	FeatureTag_LANGUAGE: true, FeatureTag_TYPE: true,
+}
+
+/**
+* Returns true if name is a feature tag parameter: a base tag or an
+* extension tag with a leading "+".
+ */
+func IsFeatureTag(name string) bool {
+	name = strings.ToLower(name)
+	return baseFeatureTags[name] || (len(name) > 1 && name[0] == '+')
+}
+
+/**
+* Encodes the values of a feature tag as the quoted tag-value-list of
+* RFC 3840 9, without the quotes. Each value is a token, a boolean, a
+* numeric ("#>=2", "#1:5"), optionally negated with "!", or a string
+* enclosed in "<" and ">".
+ */
+func EncodeFeatureValues(values []string) string {
+	return strings.Join(values, ",")
+}
+
+/**
+* Decodes a feature tag parameter value into its values. A missing value is
+* the boolean TRUE. Commas inside a string value do not split it.
+ */
+func DecodeFeatureValues(value string) []string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	if value == "" {
+		return []string{"TRUE"}
+	}
+	if value[0] == '<' {
+		//string-value, taken as a whole
+		return []string{value}
+	}
+
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
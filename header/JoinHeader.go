@@ -0,0 +1,46 @@
+package header
+
+/**
+ * The Join header field of an INVITE asks its recipient to join the
+ * session the INVITE sets up with an existing dialog (RFC 3911), in a
+ * conference the recipient creates if needed, as in barge-in or call
+ * monitoring. It identifies the dialog by its Call-ID and its tags as the
+ * recipient sees them: the to-tag is the local tag of the recipient and
+ * the from-tag its remote tag.
+ *
+ * For Example:<br>
+ * <code>Join: 12adf2f34456gs5;to-tag=12345;from-tag=54321</code>
+ */
+type JoinHeader interface {
+	ParametersHeader
+
+	/**
+	 * Sets the Call-ID of the dialog.
+	 */
+	SetCallId(callId string) (ParseException error)
+
+	/**
+	 * Returns the Call-ID of the dialog.
+	 */
+	GetCallId() string
+
+	/**
+	 * Sets the to-tag parameter, the local tag of the recipient.
+	 */
+	SetToTag(tag string) (ParseException error)
+
+	/**
+	 * Returns the to-tag parameter.
+	 */
+	GetToTag() string
+
+	/**
+	 * Sets the from-tag parameter, the remote tag of the recipient.
+	 */
+	SetFromTag(tag string) (ParseException error)
+
+	/**
+	 * Returns the from-tag parameter.
+	 */
+	GetFromTag() string
+}
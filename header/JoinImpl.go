@@ -0,0 +1,81 @@
+package header
+
+import (
+	"bytes"
+	"errors"
+	"sip/core"
+	"strings"
+)
+
+/**
+* Join Header, see RFC 3911.
+ */
+type Join struct {
+	Parameters
+
+	/** callId field
+	 */
+	callId string
+}
+
+/** Default constructor.
+ */
+func NewJoin() *Join {
+	this := &Join{}
+	this.Parameters.super(core.SIPHeaderNames_JOIN)
+	return this
+}
+
+func (this *Join) String() string {
+	return this.headerName + core.SIPSeparatorNames_COLON +
+		core.SIPSeparatorNames_SP + this.EncodeBody() + core.SIPSeparatorNames_NEWLINE
+}
+
+/**
+ * Encode value of header into canonical string.
+ * @return encoded value of header.
+ */
+func (this *Join) EncodeBody() string {
+	var encoding bytes.Buffer
+	encoding.WriteString(this.callId)
+
+	if this.parameters != nil && this.parameters.Len() > 0 {
+		encoding.WriteString(core.SIPSeparatorNames_SEMICOLON)
+		encoding.WriteString(this.parameters.String())
+	}
+	return encoding.String()
+}
+
+func (this *Join) SetCallId(callId string) (ParseException error) {
+	if callId == "" || strings.ContainsAny(callId, " \t;") {
+		return errors.New("ParseException: bad Call-ID " + callId)
+	}
+	this.callId = callId
+	return nil
+}
+
+func (this *Join) GetCallId() string {
+	return this.callId
+}
+
+func (this *Join) SetToTag(tag string) (ParseException error) {
+	if tag == "" {
+		return errors.New("ParseException: empty to-tag")
+	}
+	return this.Parameters.SetParameter(ParameterNames_TO_TAG, tag)
+}
+
+func (this *Join) GetToTag() string {
+	return this.Parameters.GetParameter(ParameterNames_TO_TAG)
+}
+
+func (this *Join) SetFromTag(tag string) (ParseException error) {
+	if tag == "" {
+		return errors.New("ParseException: empty from-tag")
+	}
+	return this.Parameters.SetParameter(ParameterNames_FROM_TAG, tag)
+}
+
+func (this *Join) GetFromTag() string {
+	return this.Parameters.GetParameter(ParameterNames_FROM_TAG)
+}
@@ -0,0 +1,27 @@
+package header
+
+/**
+ * The Min-SE header field conveys the smallest session interval, in
+ * delta-seconds, the user agents and proxies on the path of a request
+ * accept for its session timer (RFC 4028). An element finding the
+ * Session-Expires of a request too small rejects it with a 422 (Session
+ * Interval Too Small) response carrying its own Min-SE, and the UAC may
+ * retry with a session interval at least as large. It is 90 seconds when
+ * absent.
+ *
+ * For Example:<br>
+ * <code>Min-SE: 3600</code>
+ */
+type MinSEHeader interface {
+	ParametersHeader
+
+	/**
+	 * Sets the minimum session interval, in seconds.
+	 */
+	SetDelta(delta int) (InvalidArgumentException error)
+
+	/**
+	 * Returns the minimum session interval, in seconds.
+	 */
+	GetDelta() int
+}
@@ -0,0 +1,59 @@
+package header
+
+import (
+	"bytes"
+	"errors"
+	"sip/core"
+	"strconv"
+)
+
+/**
+* Min-SE Header, see RFC 4028.
+ */
+type MinSE struct {
+	Parameters
+
+	/** delta field, in seconds.
+	 */
+	delta int
+}
+
+/** Default constructor.
+ */
+func NewMinSE() *MinSE {
+	this := &MinSE{}
+	this.Parameters.super(core.SIPHeaderNames_MIN_SE)
+	return this
+}
+
+func (this *MinSE) String() string {
+	return this.headerName + core.SIPSeparatorNames_COLON +
+		core.SIPSeparatorNames_SP + this.EncodeBody() + core.SIPSeparatorNames_NEWLINE
+}
+
+/**
+ * Encode value of header into canonical string.
+ * @return encoded value of header.
+ */
+func (this *MinSE) EncodeBody() string {
+	var encoding bytes.Buffer
+	encoding.WriteString(strconv.Itoa(this.delta))
+
+	if this.parameters != nil && this.parameters.Len() > 0 {
+		encoding.WriteString(core.SIPSeparatorNames_SEMICOLON)
+		encoding.WriteString(this.parameters.String())
+	}
+	return encoding.String()
+}
+
+func (this *MinSE) SetDelta(delta int) (InvalidArgumentException error) {
+	if delta < 0 {
+		return errors.New("InvalidArgumentException: the delta parameter is <0")
+	}
+	this.delta = delta
+	return nil
+}
+
+func (this *MinSE) GetDelta() int {
+	return this.delta
+}
@@ -57,6 +57,13 @@ const ParameterNames_TRANSPORT = "transport"
 const ParameterNames_TEXT = "text"
 const ParameterNames_CAUSE = "cause"
 const ParameterNames_ID = "id"
+const ParameterNames_REQUIRE = "require"
+const ParameterNames_EXPLICIT = "explicit"
+const ParameterNames_REFRESHER = "refresher"
+const ParameterNames_TO_TAG = "to-tag"
+const ParameterNames_FROM_TAG = "from-tag"
+const ParameterNames_EARLY_ONLY = "early-only"
+const ParameterNames_REG_ID = "reg-id"
 
 const SIPConstants_DEFAULT_ENCODING = "UTF-8"
 const SIPConstants_DEFAULT_PORT = 5060
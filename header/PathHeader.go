@@ -0,0 +1,20 @@
+package header
+
+/**
+ * The Path header field of a REGISTER records the proxies it went through
+ * on its way to the registrar (RFC 3327), each proxy adding its URI to the
+ * beginning of the list. The registrar keeps the Path with the binding, and
+ * the requests to the contact are then routed through those proxies, a
+ * Route header being preloaded with the Path values in order. The registrar
+ * returns the Path it stored in the 2xx to the REGISTER.
+ * <p>
+ * For Example:<br>
+ * <code>Path: <sip:P1.EXAMPLEVISITED.COM;lr></code>
+ *
+ * @see RouteHeader
+ * @see ServiceRouteHeader
+ */
+type PathHeader interface {
+	AddressHeader
+	ParametersHeader
+}
@@ -0,0 +1,58 @@
+package header
+
+import (
+	"bytes"
+	"sip/address"
+	"sip/core"
+)
+
+/** The Path header is added to a REGISTER by the proxies which want to
+ * be on the path of the requests to the contact registered, see RFC 3327.
+ */
+type Path struct {
+	AddressParameters
+}
+
+/**  constructor
+ * @param addr address to set
+ */
+func NewPathFromAddress(addr address.Address) *Path {
+	this := &Path{}
+	this.AddressParameters.super(core.SIPHeaderNames_PATH)
+	this.addr = addr
+	return this
+}
+
+/** default constructor
+ */
+func NewPath() *Path {
+	this := &Path{}
+	this.AddressParameters.super(core.SIPHeaderNames_PATH)
+	return this
+}
+
+func (this *Path) String() string {
+	return this.headerName + core.SIPSeparatorNames_COLON +
+		core.SIPSeparatorNames_SP + this.EncodeBody() + core.SIPSeparatorNames_NEWLINE
+}
+
+/** Encode into canonical form.
+ *@return String containing the canonicaly encoded header.
+ */
+func (this *Path) EncodeBody() string {
+	var encoding bytes.Buffer
+	addr, _ := this.addr.(*address.AddressImpl)
+	if addr.GetAddressType() == address.ADDRESS_SPEC {
+		encoding.WriteString(core.SIPSeparatorNames_LESS_THAN)
+	}
+	encoding.WriteString(this.addr.String())
+	if addr.GetAddressType() == address.ADDRESS_SPEC {
+		encoding.WriteString(core.SIPSeparatorNames_GREATER_THAN)
+	}
+
+	if this.parameters != nil && this.parameters.Len() > 0 {
+		encoding.WriteString(core.SIPSeparatorNames_SEMICOLON)
+		encoding.WriteString(this.parameters.String())
+	}
+	return encoding.String()
+}
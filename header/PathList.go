@@ -0,0 +1,18 @@
+package header
+
+import "sip/core"
+
+/**
+* Path List of SIP headers (a collection of Addresses)
+ */
+type PathList struct {
+	SIPHeaderList
+}
+
+/** Default constructor
+ */
+func NewPathList() *PathList {
+	this := &PathList{}
+	this.SIPHeaderList.super(core.SIPHeaderNames_PATH)
+	return this
+}
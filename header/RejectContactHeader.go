@@ -0,0 +1,16 @@
+package header
+
+/**
+ * The Reject-Contact header field carries a caller preference (RFC 3841):
+ * a feature predicate describing the contacts the request must not be
+ * routed to. A contact is rejected when it explicitly has every feature
+ * of the predicate.
+ *
+ * For Example:<br>
+ * <code>Reject-Contact: *;actor="msg-taker";video</code>
+ *
+ * @see AcceptContactHeader
+ */
+type RejectContactHeader interface {
+	ParametersHeader
+}
@@ -0,0 +1,18 @@
+package header
+
+import "sip/core"
+
+/**
+* List of Reject-Contact headers.
+ */
+type RejectContactList struct {
+	SIPHeaderList
+}
+
+/** Default constructor
+ */
+func NewRejectContactList() *RejectContactList {
+	this := &RejectContactList{}
+	this.SIPHeaderList.super(core.SIPHeaderNames_REJECT_CONTACT)
+	return this
+}
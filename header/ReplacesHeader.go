@@ -0,0 +1,56 @@
+package header
+
+/**
+ * The Replaces header field of an INVITE asks its recipient to replace an
+ * existing dialog with the one the INVITE sets up (RFC 3891), as in an
+ * attended transfer or a call pickup. It identifies the dialog by its
+ * Call-ID and its tags as the recipient sees them: the to-tag is the local
+ * tag of the recipient and the from-tag its remote tag. With the
+ * early-only parameter, the dialog is only to be replaced while early.
+ *
+ * For Example:<br>
+ * <code>Replaces: 425928@bobster.example.org;to-tag=7743;from-tag=6472</code>
+ */
+type ReplacesHeader interface {
+	ParametersHeader
+
+	/**
+	 * Sets the Call-ID of the dialog.
+	 */
+	SetCallId(callId string) (ParseException error)
+
+	/**
+	 * Returns the Call-ID of the dialog.
+	 */
+	GetCallId() string
+
+	/**
+	 * Sets the to-tag parameter, the local tag of the recipient.
+	 */
+	SetToTag(tag string) (ParseException error)
+
+	/**
+	 * Returns the to-tag parameter.
+	 */
+	GetToTag() string
+
+	/**
+	 * Sets the from-tag parameter, the remote tag of the recipient.
+	 */
+	SetFromTag(tag string) (ParseException error)
+
+	/**
+	 * Returns the from-tag parameter.
+	 */
+	GetFromTag() string
+
+	/**
+	 * Returns true if the early-only parameter is present.
+	 */
+	IsEarlyOnly() bool
+
+	/**
+	 * Sets or removes the early-only parameter.
+	 */
+	SetEarlyOnly(earlyOnly bool)
+}
@@ -0,0 +1,93 @@
+package header
+
+import (
+	"bytes"
+	"errors"
+	"sip/core"
+	"strings"
+)
+
+/**
+* Replaces Header, see RFC 3891.
+ */
+type Replaces struct {
+	Parameters
+
+	/** callId field
+	 */
+	callId string
+}
+
+/** Default constructor.
+ */
+func NewReplaces() *Replaces {
+	this := &Replaces{}
+	this.Parameters.super(core.SIPHeaderNames_REPLACES)
+	return this
+}
+
+func (this *Replaces) String() string {
+	return this.headerName + core.SIPSeparatorNames_COLON +
+		core.SIPSeparatorNames_SP + this.EncodeBody() + core.SIPSeparatorNames_NEWLINE
+}
+
+/**
+ * Encode value of header into canonical string.
+ * @return encoded value of header.
+ */
+func (this *Replaces) EncodeBody() string {
+	var encoding bytes.Buffer
+	encoding.WriteString(this.callId)
+
+	if this.parameters != nil && this.parameters.Len() > 0 {
+		encoding.WriteString(core.SIPSeparatorNames_SEMICOLON)
+		encoding.WriteString(this.parameters.String())
+	}
+	return encoding.String()
+}
+
+func (this *Replaces) SetCallId(callId string) (ParseException error) {
+	if callId == "" || strings.ContainsAny(callId, " \t;") {
+		return errors.New("ParseException: bad Call-ID " + callId)
+	}
+	this.callId = callId
+	return nil
+}
+
+func (this *Replaces) GetCallId() string {
+	return this.callId
+}
+
+func (this *Replaces) SetToTag(tag string) (ParseException error) {
+	if tag == "" {
+		return errors.New("ParseException: empty to-tag")
+	}
+	return this.Parameters.SetParameter(ParameterNames_TO_TAG, tag)
+}
+
+func (this *Replaces) GetToTag() string {
+	return this.Parameters.GetParameter(ParameterNames_TO_TAG)
+}
+
+func (this *Replaces) SetFromTag(tag string) (ParseException error) {
+	if tag == "" {
+		return errors.New("ParseException: empty from-tag")
+	}
+	return this.Parameters.SetParameter(ParameterNames_FROM_TAG, tag)
+}
+
+func (this *Replaces) GetFromTag() string {
+	return this.Parameters.GetParameter(ParameterNames_FROM_TAG)
+}
+
+func (this *Replaces) IsEarlyOnly() bool {
+	return this.HasParameter(ParameterNames_EARLY_ONLY)
+}
+
+func (this *Replaces) SetEarlyOnly(earlyOnly bool) {
+	if earlyOnly {
+		this.parameters.SetNameValue(core.NewNameValue(ParameterNames_EARLY_ONLY, nil))
+	} else {
+		this.RemoveParameter(ParameterNames_EARLY_ONLY)
+	}
+}
@@ -0,0 +1,41 @@
+package header
+
+/**
+ * The Request-Disposition header field carries caller preferences about
+ * how a proxy processes the request (RFC 3841 9.1): whether it proxies or
+ * redirects, forks, recurses on 3xx responses, searches in parallel or
+ * sequentially, and may queue the request.
+ *
+ * For Example:<br>
+ * <code>Request-Disposition: proxy, recurse, parallel</code>
+ */
+type RequestDispositionHeader interface {
+	Header
+
+	/**
+	 * Sets the directive of this header, e.g. "no-fork".
+	 */
+	SetDirective(directive string) (ParseException error)
+
+	/**
+	 * Returns the directive of this header.
+	 */
+	GetDirective() string
+}
+
+/** The directives of the Request-Disposition header, in pairs.
+ */
+const (
+	RequestDisposition_PROXY      = "proxy"
+	RequestDisposition_REDIRECT   = "redirect"
+	RequestDisposition_CANCEL     = "cancel"
+	RequestDisposition_NO_CANCEL  = "no-cancel"
+	RequestDisposition_FORK       = "fork"
+	RequestDisposition_NO_FORK    = "no-fork"
+	RequestDisposition_RECURSE    = "recurse"
+	RequestDisposition_NO_RECURSE = "no-recurse"
+	RequestDisposition_PARALLEL   = "parallel"
+	RequestDisposition_SEQUENTIAL = "sequential"
+	RequestDisposition_QUEUE      = "queue"
+	RequestDisposition_NO_QUEUE   = "no-queue"
+)
@@ -0,0 +1,50 @@
+package header
+
+import (
+	"errors"
+	"sip/core"
+	"strings"
+)
+
+/**
+* Request-Disposition Header, see RFC 3841.
+ */
+type RequestDisposition struct {
+	SIPHeader
+
+	/** directive field
+	 */
+	directive string
+}
+
+/** default constructor
+ */
+func NewRequestDisposition() *RequestDisposition {
+	this := &RequestDisposition{}
+	this.SIPHeader.super(core.SIPHeaderNames_REQUEST_DISPOSITION)
+	return this
+}
+
+func (this *RequestDisposition) GetDirective() string {
+	return this.directive
+}
+
+func (this *RequestDisposition) SetDirective(directive string) (ParseException error) {
+	if directive == "" {
+		return errors.New("NullPointerException: the directive parameter is null")
+	}
+	this.directive = strings.ToLower(directive)
+	return nil
+}
+
+func (this *RequestDisposition) String() string {
+	return this.headerName + core.SIPSeparatorNames_COLON +
+		core.SIPSeparatorNames_SP + this.EncodeBody() + core.SIPSeparatorNames_NEWLINE
+}
+
+/** Return body encoded in canonical form.
+ * @return body encoded as a string.
+ */
+func (this *RequestDisposition) EncodeBody() string {
+	return this.directive
+}
@@ -0,0 +1,29 @@
+package header
+
+import "sip/core"
+
+/**
+* List of Request-Disposition headers.
+ */
+type RequestDispositionList struct {
+	SIPHeaderList
+}
+
+/** Default constructor
+ */
+func NewRequestDispositionList() *RequestDispositionList {
+	this := &RequestDispositionList{}
+	this.SIPHeaderList.super(core.SIPHeaderNames_REQUEST_DISPOSITION)
+	return this
+}
+
+/**
+ * Returns the directives of the list, in order.
+ */
+func (this *RequestDispositionList) GetDirectives() []string {
+	var directives []string
+	for e := this.Front(); e != nil; e = e.Next() {
+		directives = append(directives, e.Value.(*RequestDisposition).GetDirective())
+	}
+	return directives
+}
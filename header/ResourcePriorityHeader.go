@@ -0,0 +1,45 @@
+package header
+
+/**
+ * The Resource-Priority header field indicates the priority of a request
+ * within one or more namespaces (RFC 4412), so that elements in an
+ * emergency or government network can serve it ahead of normal traffic.
+ * Each value is a namespace and a priority-value separated by a dot.
+ *
+ * For Example:<br>
+ * <code>Resource-Priority: wps.3, dsn.flash</code>
+ */
+type ResourcePriorityHeader interface {
+	Header
+
+	/**
+	 * Sets the namespace of this header, e.g. "ets".
+	 */
+	SetNamespace(namespace string) (ParseException error)
+
+	/**
+	 * Returns the namespace of this header.
+	 */
+	GetNamespace() string
+
+	/**
+	 * Sets the priority-value of this header within its namespace.
+	 */
+	SetPriority(priority string) (ParseException error)
+
+	/**
+	 * Returns the priority-value of this header.
+	 */
+	GetPriority() string
+}
+
+/** The namespaces registered by RFC 4412 and RFC 7135.
+ */
+const (
+	ResourcePriority_DSN   = "dsn"
+	ResourcePriority_DRSN  = "drsn"
+	ResourcePriority_Q735  = "q735"
+	ResourcePriority_ETS   = "ets"
+	ResourcePriority_WPS   = "wps"
+	ResourcePriority_ESNET = "esnet"
+)
@@ -0,0 +1,70 @@
+package header
+
+import (
+	"errors"
+	"sip/core"
+	"strings"
+)
+
+/**
+* Resource-Priority Header, see RFC 4412.
+ */
+type ResourcePriority struct {
+	SIPHeader
+
+	/** namespace field
+	 */
+	namespace string
+
+	/** priority field
+	 */
+	priority string
+}
+
+/** default constructor
+ */
+func NewResourcePriority() *ResourcePriority {
+	this := &ResourcePriority{}
+	this.SIPHeader.super(core.SIPHeaderNames_RESOURCE_PRIORITY)
+	return this
+}
+
+func (this *ResourcePriority) GetNamespace() string {
+	return this.namespace
+}
+
+/** Namespaces are case-insensitive, they are kept lowercased.
+ */
+func (this *ResourcePriority) SetNamespace(namespace string) (ParseException error) {
+	if namespace == "" {
+		return errors.New("NullPointerException: the namespace parameter is null")
+	}
+	this.namespace = strings.ToLower(namespace)
+	return nil
+}
+
+func (this *ResourcePriority) GetPriority() string {
+	return this.priority
+}
+
+/** Priority-values are case-insensitive, they are kept lowercased.
+ */
+func (this *ResourcePriority) SetPriority(priority string) (ParseException error) {
+	if priority == "" {
+		return errors.New("NullPointerException: the priority parameter is null")
+	}
+	this.priority = strings.ToLower(priority)
+	return nil
+}
+
+func (this *ResourcePriority) String() string {
+	return this.headerName + core.SIPSeparatorNames_COLON +
+		core.SIPSeparatorNames_SP + this.EncodeBody() + core.SIPSeparatorNames_NEWLINE
+}
+
+/** Return body encoded in canonical form.
+ * @return body encoded as a string.
+ */
+func (this *ResourcePriority) EncodeBody() string {
+	return this.namespace + core.SIPSeparatorNames_DOT + this.priority
+}
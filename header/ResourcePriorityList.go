@@ -0,0 +1,18 @@
+package header
+
+import "sip/core"
+
+/**
+* List of Resource-Priority headers.
+ */
+type ResourcePriorityList struct {
+	SIPHeaderList
+}
+
+/** Default constructor
+ */
+func NewResourcePriorityList() *ResourcePriorityList {
+	this := &ResourcePriorityList{}
+	this.SIPHeaderList.super(core.SIPHeaderNames_RESOURCE_PRIORITY)
+	return this
+}
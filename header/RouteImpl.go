@@ -57,7 +57,6 @@ func (this *Route) HashCode() int { //TODO by LY
 	//hp, _ := this.addr.GetHostPort()
 	//strcon.Atoi(hp.String() //.toLowerCase().hashCode();
 	panic("Route.HashCode() Not implement yet")
-	return 0
 }
 
 func (this *Route) String() string {
@@ -0,0 +1,20 @@
+package header
+
+/**
+ * The Service-Route header field of a 2xx to a REGISTER lists the proxies
+ * the registrar wants the requests of the registered UA to go through (RFC
+ * 3608). The UA preloads the requests it sends outside of a dialog with a
+ * Route header holding the Service-Route values in order, until it
+ * registers again.
+ * <p>
+ * For Example:<br>
+ * <code>Service-Route: <sip:P2.HOME.EXAMPLE.COM;lr>,
+ * <sip:HSP.HOME.EXAMPLE.COM;lr></code>
+ *
+ * @see RouteHeader
+ * @see PathHeader
+ */
+type ServiceRouteHeader interface {
+	AddressHeader
+	ParametersHeader
+}
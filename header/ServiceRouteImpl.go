@@ -0,0 +1,59 @@
+package header
+
+import (
+	"bytes"
+	"sip/address"
+	"sip/core"
+)
+
+/** The Service-Route header is returned by a registrar in the 2xx to a
+ * REGISTER with the proxies the requests of the UA are to go through, see
+ * RFC 3608.
+ */
+type ServiceRoute struct {
+	AddressParameters
+}
+
+/**  constructor
+ * @param addr address to set
+ */
+func NewServiceRouteFromAddress(addr address.Address) *ServiceRoute {
+	this := &ServiceRoute{}
+	this.AddressParameters.super(core.SIPHeaderNames_SERVICE_ROUTE)
+	this.addr = addr
+	return this
+}
+
+/** default constructor
+ */
+func NewServiceRoute() *ServiceRoute {
+	this := &ServiceRoute{}
+	this.AddressParameters.super(core.SIPHeaderNames_SERVICE_ROUTE)
+	return this
+}
+
+func (this *ServiceRoute) String() string {
+	return this.headerName + core.SIPSeparatorNames_COLON +
+		core.SIPSeparatorNames_SP + this.EncodeBody() + core.SIPSeparatorNames_NEWLINE
+}
+
+/** Encode into canonical form.
+ *@return String containing the canonicaly encoded header.
+ */
+func (this *ServiceRoute) EncodeBody() string {
+	var encoding bytes.Buffer
+	addr, _ := this.addr.(*address.AddressImpl)
+	if addr.GetAddressType() == address.ADDRESS_SPEC {
+		encoding.WriteString(core.SIPSeparatorNames_LESS_THAN)
+	}
+	encoding.WriteString(this.addr.String())
+	if addr.GetAddressType() == address.ADDRESS_SPEC {
+		encoding.WriteString(core.SIPSeparatorNames_GREATER_THAN)
+	}
+
+	if this.parameters != nil && this.parameters.Len() > 0 {
+		encoding.WriteString(core.SIPSeparatorNames_SEMICOLON)
+		encoding.WriteString(this.parameters.String())
+	}
+	return encoding.String()
+}
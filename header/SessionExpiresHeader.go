@@ -0,0 +1,46 @@
+package header
+
+/**
+ * The Session-Expires header field conveys the session interval of a
+ * session timer (RFC 4028): the longest time, in delta-seconds, the
+ * session may go without a refresh, a re-INVITE or an UPDATE, before
+ * either user agent tears it down with a BYE. The refresher parameter
+ * tells which user agent of the dialog refreshes the session, the UAC or
+ * the UAS; a request may leave the choice to the UAS, whose 2xx response
+ * always makes it.
+ *
+ * For Example:<br>
+ * <code>Session-Expires: 4000;refresher=uac<br>
+ * x: 1800</code>
+ */
+type SessionExpiresHeader interface {
+	ParametersHeader
+
+	/**
+	 * Sets the session interval, in seconds.
+	 */
+	SetDelta(delta int) (InvalidArgumentException error)
+
+	/**
+	 * Returns the session interval, in seconds.
+	 */
+	GetDelta() int
+
+	/**
+	 * Sets the refresher parameter, SessionExpires_UAC or
+	 * SessionExpires_UAS, or removes it if empty.
+	 */
+	SetRefresher(refresher string) (ParseException error)
+
+	/**
+	 * Returns the refresher parameter, or "" if there is none.
+	 */
+	GetRefresher() string
+}
+
+/** The values of the refresher parameter.
+ */
+const (
+	SessionExpires_UAC = "uac"
+	SessionExpires_UAS = "uas"
+)
@@ -0,0 +1,79 @@
+package header
+
+import (
+	"bytes"
+	"errors"
+	"sip/core"
+	"strconv"
+	"strings"
+)
+
+/**
+* Session-Expires Header, see RFC 4028.
+ */
+type SessionExpires struct {
+	Parameters
+
+	/** delta field, in seconds.
+	 */
+	delta int
+}
+
+/** Default constructor.
+ */
+func NewSessionExpires() *SessionExpires {
+	this := &SessionExpires{}
+	this.Parameters.super(core.SIPHeaderNames_SESSION_EXPIRES)
+	return this
+}
+
+func (this *SessionExpires) String() string {
+	return this.headerName + core.SIPSeparatorNames_COLON +
+		core.SIPSeparatorNames_SP + this.EncodeBody() + core.SIPSeparatorNames_NEWLINE
+}
+
+/**
+ * Encode value of header into canonical string.
+ * @return encoded value of header.
+ */
+func (this *SessionExpires) EncodeBody() string {
+	var encoding bytes.Buffer
+	encoding.WriteString(strconv.Itoa(this.delta))
+
+	if this.parameters != nil && this.parameters.Len() > 0 {
+		encoding.WriteString(core.SIPSeparatorNames_SEMICOLON)
+		encoding.WriteString(this.parameters.String())
+	}
+	return encoding.String()
+}
+
+func (this *SessionExpires) SetDelta(delta int) (InvalidArgumentException error) {
+	if delta < 0 {
+		return errors.New("InvalidArgumentException: the delta parameter is <0")
+	}
+	this.delta = delta
+	return nil
+}
+
+func (this *SessionExpires) GetDelta() int {
+	return this.delta
+}
+
+/** Set the refresher, matched case-insensitively and kept in lower
+ * case.
+ */
+func (this *SessionExpires) SetRefresher(refresher string) (ParseException error) {
+	switch refresher = strings.ToLower(refresher); refresher {
+	case "":
+		this.RemoveParameter(ParameterNames_REFRESHER)
+	case SessionExpires_UAC, SessionExpires_UAS:
+		this.Parameters.SetParameter(ParameterNames_REFRESHER, refresher)
+	default:
+		return errors.New("ParseException: bad refresher " + refresher)
+	}
+	return nil
+}
+
+func (this *SessionExpires) GetRefresher() string {
+	return this.Parameters.GetParameter(ParameterNames_REFRESHER)
+}
@@ -0,0 +1,62 @@
+package parser
+
+import (
+	"sip/core"
+	"sip/header"
+)
+
+/** SIPParser for Accept-Contact header.
+ */
+type AcceptContactParser struct {
+	ParametersParser
+}
+
+/** Creates a new instance of AcceptContactParser
+ * @param acceptContact the header to parse
+ */
+func NewAcceptContactParser(acceptContact string) *AcceptContactParser {
+	this := &AcceptContactParser{}
+	this.ParametersParser.super(acceptContact)
+	return this
+}
+
+/** Constructor
+ * @param lexer the lexer to use to parse the header
+ */
+func NewAcceptContactParserFromLexer(lexer core.Lexer) *AcceptContactParser {
+	this := &AcceptContactParser{}
+	this.ParametersParser.superFromLexer(lexer)
+	return this
+}
+
+/** parse the String message
+ * @return SIPHeader (AcceptContactList object)
+ * @throws SIPParseException if the message does not respect the spec.
+ */
+func (this *AcceptContactParser) Parse() (sh header.Header, ParseException error) {
+	acceptContactList := header.NewAcceptContactList()
+
+	var ch byte
+	lexer := this.GetLexer()
+	this.HeaderName(TokenTypes_ACCEPT_CONTACT)
+	lexer.SPorHT()
+	for ch, _ = lexer.LookAheadK(0); ch != '\n'; ch, _ = lexer.LookAheadK(0) {
+		acceptContact := header.NewAcceptContact()
+		if _, ParseException = lexer.Match('*'); ParseException != nil {
+			return nil, ParseException
+		}
+		if ParseException = this.ParametersParser.Parse(acceptContact); ParseException != nil {
+			return nil, ParseException
+		}
+		flagParameters(acceptContact.GetParameters())
+		acceptContactList.PushBack(acceptContact)
+		if ch, _ = lexer.LookAheadK(0); ch == ',' {
+			lexer.Match(',')
+			lexer.SPorHT()
+		} else {
+			lexer.SPorHT()
+		}
+	}
+
+	return acceptContactList, nil
+}
@@ -0,0 +1,23 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestAcceptContactParser(t *testing.T) {
+	var tvi = []string{
+		"Accept-Contact: *;audio;video;require\n",
+		"Accept-Contact: *;methods=\"INVITE,BYE\";explicit, *;+sip.instance=\"<urn:uuid:1>\"\n",
+		"a: *;mobility=\"mobile\"\n",
+	}
+	var tvo = []string{
+		"Accept-Contact: *;audio;video;require\n",
+		"Accept-Contact: *;methods=\"INVITE,BYE\";explicit,*;+sip.instance=\"<urn:uuid:1>\"\n",
+		"Accept-Contact: *;mobility=\"mobile\"\n",
+	}
+
+	for i := 0; i < len(tvi); i++ {
+		shp := NewAcceptContactParser(tvi[i])
+		testHeaderParser(t, shp, tvo[i])
+	}
+}
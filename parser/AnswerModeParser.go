@@ -0,0 +1,79 @@
+package parser
+
+import (
+	"sip/core"
+	"sip/header"
+)
+
+/** SIPParser for Answer-Mode and Priv-Answer-Mode headers.
+ */
+type AnswerModeParser struct {
+	ParametersParser
+
+	/** privileged is true for Priv-Answer-Mode.
+	 */
+	privileged bool
+}
+
+/** Creates a new instance of AnswerModeParser
+ * @param answerMode the header to parse
+ */
+func NewAnswerModeParser(answerMode string) *AnswerModeParser {
+	this := &AnswerModeParser{}
+	this.ParametersParser.super(answerMode)
+	return this
+}
+
+/** Constructor
+ * @param lexer the lexer to use to parse the header
+ */
+func NewAnswerModeParserFromLexer(lexer core.Lexer) *AnswerModeParser {
+	this := &AnswerModeParser{}
+	this.ParametersParser.superFromLexer(lexer)
+	return this
+}
+
+/** Creates a new instance of AnswerModeParser for Priv-Answer-Mode
+ * @param privAnswerMode the header to parse
+ */
+func NewPrivAnswerModeParser(privAnswerMode string) *AnswerModeParser {
+	this := NewAnswerModeParser(privAnswerMode)
+	this.privileged = true
+	return this
+}
+
+/** parse the Answer-Mode String header
+ * @return Header (AnswerMode object)
+ * @throws SIPParseException if the message does not respect the spec.
+ */
+func (this *AnswerModeParser) Parse() (sh header.Header, ParseException error) {
+	var am *header.AnswerMode
+
+	lexer := this.GetLexer()
+	if this.privileged {
+		this.HeaderName(TokenTypes_PRIV_ANSWER_MODE)
+		am = header.NewPrivAnswerMode()
+	} else {
+		this.HeaderName(TokenTypes_ANSWER_MODE)
+		am = header.NewAnswerMode()
+	}
+
+	lexer.SPorHT()
+	if _, ParseException = lexer.Match(TokenTypes_ID); ParseException != nil {
+		return nil, ParseException
+	}
+	token := lexer.GetNextToken()
+	if ParseException = am.SetMode(token.GetTokenValue()); ParseException != nil {
+		return nil, ParseException
+	}
+	lexer.SPorHT()
+	if ParseException = this.ParametersParser.Parse(am); ParseException != nil {
+		return nil, ParseException
+	}
+	flagParameters(am.GetParameters())
+
+	lexer.SPorHT()
+	lexer.Match('\n')
+
+	return am, nil
+}
@@ -0,0 +1,24 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestAnswerModeParser(t *testing.T) {
+	var tvi = []string{
+		"Answer-Mode: Auto\n",
+		"Answer-Mode: manual;require\n",
+	}
+	var tvo = []string{
+		"Answer-Mode: Auto\n",
+		"Answer-Mode: Manual;require\n",
+	}
+
+	for i := 0; i < len(tvi); i++ {
+		shp := NewAnswerModeParser(tvi[i])
+		testHeaderParser(t, shp, tvo[i])
+	}
+
+	shp := NewPrivAnswerModeParser("Priv-Answer-Mode: AUTO ; require\n")
+	testHeaderParser(t, shp, "Priv-Answer-Mode: Auto;require\n")
+}
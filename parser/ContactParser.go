@@ -41,6 +41,7 @@ func (this *ContactParser) Parse() (sh header.Header, ParseException error) {
 			if ParseException = this.AddressParametersParser.Parse(contact); ParseException != nil {
 				return nil, ParseException
 			}
+			flagParameters(contact.GetContactParms())
 
 			if contact.HasParameter(header.ParameterNames_EXPIRES) {
 				if _, ParseException = strconv.Atoi(contact.GetParameter(header.ParameterNames_EXPIRES)); ParseException != nil {
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"sip/header"
 	"testing"
 )
 
@@ -43,3 +44,47 @@ func TestContactParser(t *testing.T) {
 		testHeaderParser(t, shp, tvo[i])
 	}
 }
+
+func TestContactFeatureTags(t *testing.T) {
+	var tvi = []string{
+		"Contact: <sip:alice@192.0.2.4>;audio;video;methods=\"INVITE,BYE\"\n",
+		"Contact: <sip:alice@192.0.2.4>;+sip.instance=\"<urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6>\";expires=3600\n",
+		"Contact: <sip:alice@192.0.2.4>;+sip.rendering=\"!no\";+x.size=\"#>=2\"\n",
+	}
+	var tvo = []string{
+		"Contact: <sip:alice@192.0.2.4>;audio;video;methods=\"INVITE,BYE\"\n",
+		"Contact: <sip:alice@192.0.2.4>;+sip.instance=\"<urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6>\";expires=3600\n",
+		"Contact: <sip:alice@192.0.2.4>;+sip.rendering=\"!no\";+x.size=\"#>=2\"\n",
+	}
+
+	for i := 0; i < len(tvi); i++ {
+		shp := NewContactParser(tvi[i])
+		testHeaderParser(t, shp, tvo[i])
+	}
+
+	sh, err := NewContactParser(tvi[0]).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contact := sh.(*header.ContactList).Front().Value.(*header.Contact)
+	if names := contact.GetFeatureTagNames(); len(names) != 3 {
+		t.Errorf("feature tags = %v", names)
+	}
+	if values, ok := contact.GetFeatureTag(header.FeatureTag_METHODS); !ok || len(values) != 2 || values[1] != "BYE" {
+		t.Errorf("methods = %v", values)
+	}
+	if values, ok := contact.GetFeatureTag(header.FeatureTag_VIDEO); !ok || values[0] != "TRUE" {
+		t.Errorf("video = %v", values)
+	}
+
+	contact.RemoveFeatureTag(header.FeatureTag_VIDEO)
+	contact.SetFeatureTag(header.FeatureTag_AUTOMATA)
+	contact.SetFeatureTag(header.FeatureTag_EVENTS, "presence", "dialog")
+	contact.SetInstance("urn:uuid:1")
+	if body := contact.EncodeBody(); body != "<sip:alice@192.0.2.4>;audio;methods=\"INVITE,BYE\";automata;events=\"presence,dialog\";+sip.instance=\"<urn:uuid:1>\"" {
+		t.Errorf("encoded = %s", body)
+	}
+	if contact.GetInstance() != "urn:uuid:1" {
+		t.Errorf("instance = %q", contact.GetInstance())
+	}
+}
@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"sip/core"
+	"sip/header"
+)
+
+/** SIPParser for Min-SE header.
+ */
+type MinSEParser struct {
+	ParametersParser
+}
+
+/** Creates a new instance of MinSEParser
+ * @param minSE the header to parse
+ */
+func NewMinSEParser(minSE string) *MinSEParser {
+	this := &MinSEParser{}
+	this.ParametersParser.super(minSE)
+	return this
+}
+
+/** Constructor
+ * @param lexer the lexer to use to parse the header
+ */
+func NewMinSEParserFromLexer(lexer core.Lexer) *MinSEParser {
+	this := &MinSEParser{}
+	this.ParametersParser.superFromLexer(lexer)
+	return this
+}
+
+/** parse the Min-SE String header
+ * @return Header (MinSE object)
+ * @throws SIPParseException if the message does not respect the spec.
+ */
+func (this *MinSEParser) Parse() (sh header.Header, ParseException error) {
+	minSE := header.NewMinSE()
+
+	lexer := this.GetLexer()
+	this.HeaderName(TokenTypes_MIN_SE)
+
+	var delta int
+	if delta, ParseException = lexer.Number(); ParseException != nil {
+		return nil, ParseException
+	}
+	if ParseException = minSE.SetDelta(delta); ParseException != nil {
+		return nil, ParseException
+	}
+	lexer.SPorHT()
+	if ParseException = this.ParametersParser.Parse(minSE); ParseException != nil {
+		return nil, ParseException
+	}
+	flagParameters(minSE.GetParameters())
+
+	lexer.SPorHT()
+	lexer.Match('\n')
+
+	return minSE, nil
+}
@@ -66,3 +66,16 @@ func (this *ParametersParser) Parse(parametersHeader header.ParametersHeader) (P
 	}
 	return nil
 }
+
+/** Parameters without a value are flags, e.g. the feature tags of RFC 3840
+ * or ";require": keep them valueless so that they encode as ";video" and
+ * not ";video=".
+ */
+func flagParameters(parameters *core.NameValueList) {
+	for e := parameters.Front(); e != nil; e = e.Next() {
+		nv := e.Value.(*core.NameValue)
+		if v, ok := nv.GetValue().(string); ok && v == "" && !nv.IsValueQuoted() {
+			nv.SetValue(nil)
+		}
+	}
+}
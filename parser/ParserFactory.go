@@ -4,12 +4,45 @@ import (
 	"errors"
 	"sip/core"
 	"strings"
+	"sync"
 )
 
 /** A factory class that does a name lookup on a registered parser and
 * returns a header parser for the given name.
  */
 
+// A HeaderParserFactory returns the parser of a header line, "Name: value"
+// ending with a newline, for a header registered with RegisterHeaderParser.
+type HeaderParserFactory func(line string) Parser
+
+var registry = struct {
+	sync.RWMutex
+	factories map[string]HeaderParserFactory
+}{factories: make(map[string]HeaderParserFactory)}
+
+// RegisterHeaderParser makes CreateParser use factory for the headers
+// called name, in any case, instead of its built-in parser or the generic
+// one of the extension headers, so that an application can parse its own
+// headers into types of its own. A nil factory removes the registration.
+func RegisterHeaderParser(name string, factory HeaderParserFactory) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	if factory == nil {
+		delete(registry.factories, name)
+	} else {
+		registry.factories[name] = factory
+	}
+}
+
+func getHeaderParserFactory(name string) HeaderParserFactory {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	return registry.factories[name]
+}
+
 /** create a parser for a header. This is the parser factory.
  */
 func CreateParser(line string) (parser Parser, ParseException error) {
@@ -19,6 +52,9 @@ func CreateParser(line string) (parser Parser, ParseException error) {
 	if headerName == "" || headerValue == "" {
 		return nil, errors.New("ParseException: The header name or value is null")
 	}
+	if factory := getHeaderParserFactory(headerName); factory != nil {
+		return factory(line), nil
+	}
 
 	switch headerName {
 	case strings.ToLower(core.SIPHeaderNames_REPLY_TO):
@@ -147,6 +183,38 @@ func CreateParser(line string) (parser Parser, ParseException error) {
 		parser = NewAcceptParser(line)
 	case strings.ToLower(core.SIPHeaderNames_REFER_TO):
 		parser = NewReferToParser(line)
+	case strings.ToLower(core.SIPHeaderNames_ACCEPT_CONTACT):
+		parser = NewAcceptContactParser(line)
+	case "a":
+		parser = NewAcceptContactParser(line)
+	case strings.ToLower(core.SIPHeaderNames_REJECT_CONTACT):
+		parser = NewRejectContactParser(line)
+	case "j":
+		parser = NewRejectContactParser(line)
+	case strings.ToLower(core.SIPHeaderNames_REQUEST_DISPOSITION):
+		parser = NewRequestDispositionParser(line)
+	case "d":
+		parser = NewRequestDispositionParser(line)
+	case strings.ToLower(core.SIPHeaderNames_RESOURCE_PRIORITY):
+		parser = NewResourcePriorityParser(line)
+	case strings.ToLower(core.SIPHeaderNames_ANSWER_MODE):
+		parser = NewAnswerModeParser(line)
+	case strings.ToLower(core.SIPHeaderNames_PRIV_ANSWER_MODE):
+		parser = NewPrivAnswerModeParser(line)
+	case strings.ToLower(core.SIPHeaderNames_SESSION_EXPIRES):
+		parser = NewSessionExpiresParser(line)
+	case "x":
+		parser = NewSessionExpiresParser(line)
+	case strings.ToLower(core.SIPHeaderNames_MIN_SE):
+		parser = NewMinSEParser(line)
+	case strings.ToLower(core.SIPHeaderNames_REPLACES):
+		parser = NewReplacesParser(line)
+	case strings.ToLower(core.SIPHeaderNames_JOIN):
+		parser = NewJoinParser(line)
+	case strings.ToLower(core.SIPHeaderNames_PATH):
+		parser = NewPathParser(line)
+	case strings.ToLower(core.SIPHeaderNames_SERVICE_ROUTE):
+		parser = NewServiceRouteParser(line)
 	default:
 		// Just generate a generic SIPHeader. We define
 		// parsers only for the above.
@@ -0,0 +1,91 @@
+package parser
+
+import (
+	"errors"
+	"sip/core"
+	"sip/header"
+	"strings"
+	"testing"
+)
+
+// tenant is an application header, "X-Tenant: name;zone=zone".
+type tenant struct {
+	*header.SIPHeader
+
+	name string
+	zone string
+}
+
+func (this *tenant) EncodeBody() string {
+	return this.name + ";zone=" + this.zone
+}
+
+func (this *tenant) GetHeaderValue() string {
+	return this.EncodeBody()
+}
+
+func (this *tenant) GetValue() string {
+	return this.EncodeBody()
+}
+
+func (this *tenant) String() string {
+	return this.GetName() + core.SIPSeparatorNames_COLON + core.SIPSeparatorNames_SP + this.EncodeBody() + core.SIPSeparatorNames_NEWLINE
+}
+
+func (this *tenant) Clone() interface{} {
+	return &tenant{header.NewSIPHeader(this.GetName()), this.name, this.zone}
+}
+
+type tenantParser string
+
+func (this tenantParser) Parse() (header.Header, error) {
+	var lexer SIPLexer
+	name, zone := lexer.GetHeaderValue(string(this)), ""
+	if i := strings.Index(name, ";zone="); i >= 0 {
+		name, zone = name[:i], name[i+len(";zone="):]
+	}
+	if name == "" || zone == "" {
+		return nil, errors.New("ParseException: invalid X-Tenant")
+	}
+	return &tenant{header.NewSIPHeader("X-Tenant"), strings.TrimSpace(name), strings.TrimSpace(zone)}, nil
+}
+
+func TestRegisterHeaderParser(t *testing.T) {
+	RegisterHeaderParser("X-Tenant", func(line string) Parser {
+		return tenantParser(line)
+	})
+	defer RegisterHeaderParser("X-Tenant", nil)
+
+	p, err := CreateParser("x-tenant: acme ;zone=eu\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, ok := sh.(*tenant)
+	if !ok || h.name != "acme" || h.zone != "eu" {
+		t.Fatalf("parsed %#v", sh)
+	}
+
+	clone := h.Clone().(*tenant)
+	clone.zone = "us"
+	if h.String() != "X-Tenant: acme;zone=eu\r\n" || clone.String() != "X-Tenant: acme;zone=us\r\n" {
+		t.Errorf("encoded %q and %q", h.String(), clone.String())
+	}
+	if p, _ := CreateParser("X-Tenant: acme\n"); p != nil {
+		if _, err := p.Parse(); err == nil {
+			t.Error("invalid X-Tenant parsed")
+		}
+	}
+
+	//unregistered, it is an extension header again
+	RegisterHeaderParser("X-Tenant", nil)
+	p, _ = CreateParser("X-Tenant: acme;zone=eu\n")
+	if sh, err := p.Parse(); err != nil || sh.GetHeaderValue() != "acme;zone=eu" {
+		t.Errorf("parsed %#v, %v", sh, err)
+	} else if _, ok := sh.(*header.Extension); !ok {
+		t.Errorf("parsed %T, want *header.Extension", sh)
+	}
+}
@@ -0,0 +1,62 @@
+package parser
+
+import (
+	"sip/core"
+	"sip/header"
+)
+
+/** SIPParser for Reject-Contact header.
+ */
+type RejectContactParser struct {
+	ParametersParser
+}
+
+/** Creates a new instance of RejectContactParser
+ * @param rejectContact the header to parse
+ */
+func NewRejectContactParser(rejectContact string) *RejectContactParser {
+	this := &RejectContactParser{}
+	this.ParametersParser.super(rejectContact)
+	return this
+}
+
+/** Constructor
+ * @param lexer the lexer to use to parse the header
+ */
+func NewRejectContactParserFromLexer(lexer core.Lexer) *RejectContactParser {
+	this := &RejectContactParser{}
+	this.ParametersParser.superFromLexer(lexer)
+	return this
+}
+
+/** parse the String message
+ * @return SIPHeader (RejectContactList object)
+ * @throws SIPParseException if the message does not respect the spec.
+ */
+func (this *RejectContactParser) Parse() (sh header.Header, ParseException error) {
+	rejectContactList := header.NewRejectContactList()
+
+	var ch byte
+	lexer := this.GetLexer()
+	this.HeaderName(TokenTypes_REJECT_CONTACT)
+	lexer.SPorHT()
+	for ch, _ = lexer.LookAheadK(0); ch != '\n'; ch, _ = lexer.LookAheadK(0) {
+		rejectContact := header.NewRejectContact()
+		if _, ParseException = lexer.Match('*'); ParseException != nil {
+			return nil, ParseException
+		}
+		if ParseException = this.ParametersParser.Parse(rejectContact); ParseException != nil {
+			return nil, ParseException
+		}
+		flagParameters(rejectContact.GetParameters())
+		rejectContactList.PushBack(rejectContact)
+		if ch, _ = lexer.LookAheadK(0); ch == ',' {
+			lexer.Match(',')
+			lexer.SPorHT()
+		} else {
+			lexer.SPorHT()
+		}
+	}
+
+	return rejectContactList, nil
+}
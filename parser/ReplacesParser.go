@@ -0,0 +1,60 @@
+package parser
+
+import (
+	"errors"
+	"sip/core"
+	"sip/header"
+	"strings"
+)
+
+/** SIPParser for Replaces header.
+ */
+type ReplacesParser struct {
+	ParametersParser
+}
+
+/** Creates a new instance of ReplacesParser
+ * @param replaces the header to parse
+ */
+func NewReplacesParser(replaces string) *ReplacesParser {
+	this := &ReplacesParser{}
+	this.ParametersParser.super(replaces)
+	return this
+}
+
+/** Constructor
+ * @param lexer the lexer to use to parse the header
+ */
+func NewReplacesParserFromLexer(lexer core.Lexer) *ReplacesParser {
+	this := &ReplacesParser{}
+	this.ParametersParser.superFromLexer(lexer)
+	return this
+}
+
+/** parse the Replaces String header
+ * @return Header (Replaces object)
+ * @throws SIPParseException if the message does not respect the spec.
+ */
+func (this *ReplacesParser) Parse() (sh header.Header, ParseException error) {
+	replaces := header.NewReplaces()
+
+	lexer := this.GetLexer()
+	this.HeaderName(TokenTypes_REPLACES)
+
+	if ParseException = replaces.SetCallId(strings.TrimSpace(lexer.ByteStringNoSemicolon())); ParseException != nil {
+		return nil, ParseException
+	}
+	if ParseException = this.ParametersParser.Parse(replaces); ParseException != nil {
+		return nil, ParseException
+	}
+	flagParameters(replaces.GetParameters())
+	//both tags are required
+	if replaces.GetToTag() == "" || replaces.GetFromTag() == "" {
+		return nil, errors.New("ParseException: Replaces without to-tag or from-tag")
+	}
+
+	lexer.SPorHT()
+	lexer.Match('\n')
+
+	return replaces, nil
+}
@@ -0,0 +1,45 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestReplacesParser(t *testing.T) {
+	var tvi = []string{
+		"Replaces: 98732@sip.example.com;from-tag=r33th4x0r;to-tag=ff87ff\n",
+		"Replaces: 425928@bobster.example.org ;to-tag=7743 ; from-tag=6472;early-only\n",
+	}
+	var tvo = []string{
+		"Replaces: 98732@sip.example.com;from-tag=r33th4x0r;to-tag=ff87ff\n",
+		"Replaces: 425928@bobster.example.org;to-tag=7743;from-tag=6472;early-only\n",
+	}
+
+	for i := 0; i < len(tvi); i++ {
+		shp := NewReplacesParser(tvi[i])
+		testHeaderParser(t, shp, tvo[i])
+	}
+
+	if _, err := NewReplacesParser("Replaces: 98732@sip.example.com;to-tag=ff87ff\n").Parse(); err == nil {
+		t.Error("parsed a Replaces without from-tag")
+	}
+}
+
+func TestJoinParser(t *testing.T) {
+	var tvi = []string{
+		"Join: 12adf2f34456gs5;to-tag=12345;from-tag=54321\n",
+		"Join: 12adf2f34456gs5@example.com ; from-tag=54321;to-tag=12345\n",
+	}
+	var tvo = []string{
+		"Join: 12adf2f34456gs5;to-tag=12345;from-tag=54321\n",
+		"Join: 12adf2f34456gs5@example.com;from-tag=54321;to-tag=12345\n",
+	}
+
+	for i := 0; i < len(tvi); i++ {
+		shp := NewJoinParser(tvi[i])
+		testHeaderParser(t, shp, tvo[i])
+	}
+
+	if _, err := NewJoinParser("Join: ;to-tag=1;from-tag=2\n").Parse(); err == nil {
+		t.Error("parsed a Join without Call-ID")
+	}
+}
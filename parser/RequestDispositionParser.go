@@ -0,0 +1,67 @@
+package parser
+
+import (
+	"sip/core"
+	"sip/header"
+)
+
+/** SIPParser for Request-Disposition header.
+ */
+type RequestDispositionParser struct {
+	HeaderParser
+}
+
+/**
+ * Creates a new instance of RequestDispositionParser
+ * @param requestDisposition the header to parse
+ */
+func NewRequestDispositionParser(requestDisposition string) *RequestDispositionParser {
+	this := &RequestDispositionParser{}
+	this.HeaderParser.super(requestDisposition)
+	return this
+}
+
+/** Constructor
+ * @param lexer the lexer to use to parse the header
+ */
+func NewRequestDispositionParserFromLexer(lexer core.Lexer) *RequestDispositionParser {
+	this := &RequestDispositionParser{}
+	this.HeaderParser.superFromLexer(lexer)
+	return this
+}
+
+/** parse the Request-Disposition String header
+ * @return Header (RequestDispositionList object)
+ * @throws SIPParseException if the message does not respect the spec.
+ */
+func (this *RequestDispositionParser) Parse() (sh header.Header, ParseException error) {
+	requestDispositionList := header.NewRequestDispositionList()
+
+	var ch byte
+
+	lexer := this.GetLexer()
+	this.HeaderName(TokenTypes_REQUEST_DISPOSITION)
+
+	lexer.SPorHT()
+	for {
+		requestDisposition := header.NewRequestDisposition()
+		if _, ParseException = lexer.Match(TokenTypes_ID); ParseException != nil {
+			return nil, ParseException
+		}
+		token := lexer.GetNextToken()
+		if ParseException = requestDisposition.SetDirective(token.GetTokenValue()); ParseException != nil {
+			return nil, ParseException
+		}
+		requestDispositionList.PushBack(requestDisposition)
+
+		lexer.SPorHT()
+		if ch, _ = lexer.LookAheadK(0); ch != ',' {
+			break
+		}
+		lexer.Match(',')
+		lexer.SPorHT()
+	}
+	lexer.Match('\n')
+
+	return requestDispositionList, nil
+}
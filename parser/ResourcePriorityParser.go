@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"sip/core"
+	"sip/header"
+	"strings"
+)
+
+/** SIPParser for Resource-Priority header.
+ */
+type ResourcePriorityParser struct {
+	HeaderParser
+}
+
+/**
+ * Creates a new instance of ResourcePriorityParser
+ * @param resourcePriority the header to parse
+ */
+func NewResourcePriorityParser(resourcePriority string) *ResourcePriorityParser {
+	this := &ResourcePriorityParser{}
+	this.HeaderParser.super(resourcePriority)
+	return this
+}
+
+/** Constructor
+ * @param lexer the lexer to use to parse the header
+ */
+func NewResourcePriorityParserFromLexer(lexer core.Lexer) *ResourcePriorityParser {
+	this := &ResourcePriorityParser{}
+	this.HeaderParser.superFromLexer(lexer)
+	return this
+}
+
+/** parse the Resource-Priority String header
+ * @return Header (ResourcePriorityList object)
+ * @throws SIPParseException if the message does not respect the spec.
+ */
+func (this *ResourcePriorityParser) Parse() (sh header.Header, ParseException error) {
+	resourcePriorityList := header.NewResourcePriorityList()
+
+	var ch byte
+
+	lexer := this.GetLexer()
+	this.HeaderName(TokenTypes_RESOURCE_PRIORITY)
+
+	lexer.SPorHT()
+	for {
+		resourcePriority := header.NewResourcePriority()
+		if _, ParseException = lexer.Match(TokenTypes_ID); ParseException != nil {
+			return nil, ParseException
+		}
+		// r-value = namespace "." r-priority, both tokens without a dot
+		token := lexer.GetNextToken().GetTokenValue()
+		i := strings.Index(token, core.SIPSeparatorNames_DOT)
+		if i <= 0 || i == len(token)-1 || strings.Count(token, core.SIPSeparatorNames_DOT) != 1 {
+			return nil, this.CreateParseException("namespace.priority expected")
+		}
+		resourcePriority.SetNamespace(token[:i])
+		resourcePriority.SetPriority(token[i+1:])
+		resourcePriorityList.PushBack(resourcePriority)
+
+		lexer.SPorHT()
+		if ch, _ = lexer.LookAheadK(0); ch != ',' {
+			break
+		}
+		lexer.Match(',')
+		lexer.SPorHT()
+	}
+	lexer.Match('\n')
+
+	return resourcePriorityList, nil
+}
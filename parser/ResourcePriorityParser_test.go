@@ -0,0 +1,29 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestResourcePriorityParser(t *testing.T) {
+	var tvi = []string{
+		"Resource-Priority: wps.3, dsn.flash\n",
+		"Resource-Priority: ETS.0\n",
+		"Resource-Priority: esnet.1\n",
+	}
+	var tvo = []string{
+		"Resource-Priority: wps.3,dsn.flash\n",
+		"Resource-Priority: ets.0\n",
+		"Resource-Priority: esnet.1\n",
+	}
+
+	for i := 0; i < len(tvi); i++ {
+		shp := NewResourcePriorityParser(tvi[i])
+		testHeaderParser(t, shp, tvo[i])
+	}
+
+	for _, s := range []string{"Resource-Priority: wps\n", "Resource-Priority: wps.3.1\n"} {
+		if _, err := NewResourcePriorityParser(s).Parse(); err == nil {
+			t.Errorf("%q: expected a parse error", s)
+		}
+	}
+}
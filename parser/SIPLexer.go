@@ -120,6 +120,18 @@ func (this *SIPLexer) SelectLexer(lexerName string) {
 			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_WWW_AUTHENTICATE), TokenTypes_WWW_AUTHENTICATE)
 			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_CALL_INFO), TokenTypes_CALL_INFO)
 			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_CONTENT_DISPOSITION), TokenTypes_CONTENT_DISPOSITION)
+			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_ACCEPT_CONTACT), TokenTypes_ACCEPT_CONTACT)
+			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_REJECT_CONTACT), TokenTypes_REJECT_CONTACT)
+			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_REQUEST_DISPOSITION), TokenTypes_REQUEST_DISPOSITION)
+			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_RESOURCE_PRIORITY), TokenTypes_RESOURCE_PRIORITY)
+			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_ANSWER_MODE), TokenTypes_ANSWER_MODE)
+			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_PRIV_ANSWER_MODE), TokenTypes_PRIV_ANSWER_MODE)
+			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_SESSION_EXPIRES), TokenTypes_SESSION_EXPIRES)
+			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_MIN_SE), TokenTypes_MIN_SE)
+			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_REPLACES), TokenTypes_REPLACES)
+			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_JOIN), TokenTypes_JOIN)
+			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_PATH), TokenTypes_PATH)
+			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_SERVICE_ROUTE), TokenTypes_SERVICE_ROUTE)
 			// And now the dreaded short forms....
 			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_K), TokenTypes_SUPPORTED)
 			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_C), TokenTypes_CONTENT_TYPE)
@@ -132,6 +144,10 @@ func (this *SIPLexer) SelectLexer(lexerName string) {
 			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_T), TokenTypes_TO)
 			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_V), TokenTypes_VIA)
 			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_R), TokenTypes_REFER_TO)
+			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_A), TokenTypes_ACCEPT_CONTACT)
+			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_J), TokenTypes_REJECT_CONTACT)
+			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_D), TokenTypes_REQUEST_DISPOSITION)
+			this.AddKeyword(strings.ToUpper(core.SIPHeaderNames_X), TokenTypes_SESSION_EXPIRES)
 		} else if lexerName == "status_lineLexer" {
 			this.AddKeyword(strings.ToUpper(core.SIPTransportNames_SIP), TokenTypes_SIP)
 		} else if lexerName == "request_lineLexer" {
@@ -139,6 +155,7 @@ func (this *SIPLexer) SelectLexer(lexerName string) {
 		} else if lexerName == "sip_urlLexer" {
 			this.AddKeyword(strings.ToUpper(core.SIPTransportNames_TEL), TokenTypes_TEL)
 			this.AddKeyword(strings.ToUpper(core.SIPTransportNames_SIP), TokenTypes_SIP)
+			this.AddKeyword(strings.ToUpper(core.SIPTransportNames_SIPS), TokenTypes_SIPS)
 		}
 	} /*else{
 		println("this.CurrentLexer() != nil");
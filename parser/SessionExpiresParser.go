@@ -0,0 +1,73 @@
+package parser
+
+import (
+	"sip/core"
+	"sip/header"
+	"strings"
+)
+
+/** SIPParser for Session-Expires header.
+ */
+type SessionExpiresParser struct {
+	ParametersParser
+}
+
+/** Creates a new instance of SessionExpiresParser
+ * @param sessionExpires the header to parse
+ */
+func NewSessionExpiresParser(sessionExpires string) *SessionExpiresParser {
+	this := &SessionExpiresParser{}
+	this.ParametersParser.super(sessionExpires)
+	return this
+}
+
+/** Constructor
+ * @param lexer the lexer to use to parse the header
+ */
+func NewSessionExpiresParserFromLexer(lexer core.Lexer) *SessionExpiresParser {
+	this := &SessionExpiresParser{}
+	this.ParametersParser.superFromLexer(lexer)
+	return this
+}
+
+/** parse the Session-Expires String header
+ * @return Header (SessionExpires object)
+ * @throws SIPParseException if the message does not respect the spec.
+ */
+func (this *SessionExpiresParser) Parse() (sh header.Header, ParseException error) {
+	se := header.NewSessionExpires()
+
+	lexer := this.GetLexer()
+	this.HeaderName(TokenTypes_SESSION_EXPIRES)
+
+	var delta int
+	if delta, ParseException = lexer.Number(); ParseException != nil {
+		return nil, ParseException
+	}
+	if ParseException = se.SetDelta(delta); ParseException != nil {
+		return nil, ParseException
+	}
+	lexer.SPorHT()
+	if ParseException = this.ParametersParser.Parse(se); ParseException != nil {
+		return nil, ParseException
+	}
+	flagParameters(se.GetParameters())
+	//the refresher parameter is matched case-insensitively
+	for e := se.GetParameters().Front(); e != nil; e = e.Next() {
+		nv := e.Value.(*core.NameValue)
+		if !strings.EqualFold(nv.GetName(), header.ParameterNames_REFRESHER) {
+			continue
+		}
+		refresher, _ := nv.GetValue().(string)
+		se.GetParameters().Remove(e)
+		if ParseException = se.SetRefresher(refresher); ParseException != nil {
+			return nil, ParseException
+		}
+		break
+	}
+
+	lexer.SPorHT()
+	lexer.Match('\n')
+
+	return se, nil
+}
@@ -0,0 +1,43 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestSessionExpiresParser(t *testing.T) {
+	var tvi = []string{
+		"Session-Expires: 4000;refresher=uac\n",
+		"Session-Expires: 1800 ; Refresher = UAS\n",
+		"x: 90\n",
+	}
+	var tvo = []string{
+		"Session-Expires: 4000;refresher=uac\n",
+		"Session-Expires: 1800;refresher=uas\n",
+		"Session-Expires: 90\n",
+	}
+
+	for i := 0; i < len(tvi); i++ {
+		shp := NewSessionExpiresParser(tvi[i])
+		testHeaderParser(t, shp, tvo[i])
+	}
+
+	if _, err := NewSessionExpiresParser("Session-Expires: 1800;refresher=proxy\n").Parse(); err == nil {
+		t.Error("parsed a bad refresher")
+	}
+}
+
+func TestMinSEParser(t *testing.T) {
+	var tvi = []string{
+		"Min-SE: 90\n",
+		"Min-SE: 3600;lr\n",
+	}
+	var tvo = []string{
+		"Min-SE: 90\n",
+		"Min-SE: 3600;lr\n",
+	}
+
+	for i := 0; i < len(tvi); i++ {
+		shp := NewMinSEParser(tvi[i])
+		testHeaderParser(t, shp, tvo[i])
+	}
+}
@@ -84,7 +84,7 @@ func (this *URLParser) ParamNameOrValue() (s string, ParseException error) {
 	var retval bytes.Buffer
 	for this.GetLexer().HasMoreChars() {
 		next, _ := this.GetLexer().LookAheadK(0)
-		if next == '[' || next == '[' || next == '/' ||
+		if next == '[' || next == ']' || next == '/' ||
 			next == ':' || next == '&' || next == '+' ||
 			next == '$' || this.IsUnreserved(next) {
 			retval.WriteByte(next)
@@ -233,7 +233,7 @@ func (this *URLParser) UriReference() (url address.URI, ParseException error) {
 	t1 := vect[0]
 	t2 := vect[1]
 
-	if t1.GetTokenType() == TokenTypes_SIP {
+	if t1.GetTokenType() == TokenTypes_SIP || t1.GetTokenType() == TokenTypes_SIPS {
 		if t2.GetTokenType() == ':' {
 			if retval, ParseException = this.SipURL(); ParseException != nil {
 				return nil, ParseException
@@ -403,9 +403,14 @@ func (this *URLParser) TelURL() (telUrl *address.TelURLImpl, ParseException erro
 func (this *URLParser) SipURL() (sipurl *address.SipURIImpl, ParseException error) {
 	retval := address.NewSipURIImpl()
 
-	this.GetLexer().Match(TokenTypes_SIP)
+	if tokens, _ := this.GetLexer().PeekNextTokenK(1); len(tokens) > 0 && tokens[0].GetTokenType() == TokenTypes_SIPS {
+		this.GetLexer().Match(TokenTypes_SIPS)
+		retval.SetScheme(core.SIPTransportNames_SIPS)
+	} else {
+		this.GetLexer().Match(TokenTypes_SIP)
+		retval.SetScheme(core.SIPTransportNames_SIP)
+	}
 	this.GetLexer().Match(':')
-	retval.SetScheme(core.SIPTransportNames_SIP)
 
 	buffer := this.GetLexer().GetRest()
 	if n := strings.Index(buffer, "@"); n == -1 {
@@ -19,6 +19,7 @@ func TestURLParser(t *testing.T) {
 		"sip:1212@gateway.com",
 		"sip:alice@10.1.2.3",
 		"sip:alice@example.com",
+		"sips:alice@example.com;transport=tcp",
 		"sip:alice",
 		"sip:alice@registrar.com;method=REGISTER",
 		"sip:annc@10.10.30.186:6666;early=no;play=http://10.10.30.186:8080/examples/pin.vxml",
@@ -39,6 +40,7 @@ func TestURLParser(t *testing.T) {
 		"sip:1212@gateway.com",
 		"sip:alice@10.1.2.3",
 		"sip:alice@example.com",
+		"sips:alice@example.com;transport=tcp",
 		"sip:alice",
 		"sip:alice@registrar.com;method=REGISTER",
 		"sip:annc@10.10.30.186:6666;early=no;play=http://10.10.30.186:8080/examples/pin.vxml",
@@ -0,0 +1,198 @@
+// Package siptest provides test doubles for the applications of package
+// sip: a Listener recording the events it is given, a ServerTransaction
+// recording the responses sent in it, and an in-memory Transport, so that
+// the logic of a Listener can be unit tested without a network or a running
+// provider.
+package siptest
+
+import (
+	"sip"
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A MockListener is a sip.DialogListener recording every event it is given,
+// in order, then calling the matching function, if set, for the test to
+// script its answer, say to respond to the request through its
+// ResponseWriter. The functions must be set before the listener is used.
+type MockListener struct {
+	OnRequest          func(requestEvent sip.RequestEvent)
+	OnResponse         func(responseEvent sip.ResponseEvent)
+	OnTimeout          func(timeoutEvent sip.TimeoutEvent)
+	OnDialogTerminated func(dialogTerminatedEvent sip.DialogTerminatedEvent)
+
+	mutex       sync.Mutex
+	requests    []sip.RequestEvent
+	responses   []sip.ResponseEvent
+	timeouts    []sip.TimeoutEvent
+	terminated  []sip.DialogTerminatedEvent
+	invocations int
+	changed     chan struct{} //closed on the next event
+}
+
+////////////////////Implementation////////////////////////
+
+// NewMockListener returns a MockListener without scripted answers.
+func NewMockListener() *MockListener {
+	return &MockListener{}
+}
+
+func (this *MockListener) ProcessRequest(requestEvent sip.RequestEvent) {
+	this.mutex.Lock()
+	this.requests = append(this.requests, requestEvent)
+	this.notify()
+	this.mutex.Unlock()
+
+	if this.OnRequest != nil {
+		this.OnRequest(requestEvent)
+	}
+}
+
+func (this *MockListener) ProcessResponse(responseEvent sip.ResponseEvent) {
+	this.mutex.Lock()
+	this.responses = append(this.responses, responseEvent)
+	this.notify()
+	this.mutex.Unlock()
+
+	if this.OnResponse != nil {
+		this.OnResponse(responseEvent)
+	}
+}
+
+func (this *MockListener) ProcessTimeout(timeoutEvent sip.TimeoutEvent) {
+	this.mutex.Lock()
+	this.timeouts = append(this.timeouts, timeoutEvent)
+	this.notify()
+	this.mutex.Unlock()
+
+	if this.OnTimeout != nil {
+		this.OnTimeout(timeoutEvent)
+	}
+}
+
+func (this *MockListener) ProcessDialogTerminated(dialogTerminatedEvent sip.DialogTerminatedEvent) {
+	this.mutex.Lock()
+	this.terminated = append(this.terminated, dialogTerminatedEvent)
+	this.notify()
+	this.mutex.Unlock()
+
+	if this.OnDialogTerminated != nil {
+		this.OnDialogTerminated(dialogTerminatedEvent)
+	}
+}
+
+// GetRequestEvents returns the request events given so far.
+func (this *MockListener) GetRequestEvents() []sip.RequestEvent {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return append([]sip.RequestEvent(nil), this.requests...)
+}
+
+// GetRequests returns the requests of the request events given so far.
+func (this *MockListener) GetRequests() []sip.Request {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	requests := make([]sip.Request, len(this.requests))
+	for i, e := range this.requests {
+		requests[i] = e.GetRequest()
+	}
+	return requests
+}
+
+// GetResponseEvents returns the response events given so far.
+func (this *MockListener) GetResponseEvents() []sip.ResponseEvent {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return append([]sip.ResponseEvent(nil), this.responses...)
+}
+
+// GetResponses returns the responses of the response events given so far.
+func (this *MockListener) GetResponses() []sip.Response {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	responses := make([]sip.Response, len(this.responses))
+	for i, e := range this.responses {
+		responses[i] = e.GetResponse()
+	}
+	return responses
+}
+
+// GetTimeoutEvents returns the timeout events given so far.
+func (this *MockListener) GetTimeoutEvents() []sip.TimeoutEvent {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return append([]sip.TimeoutEvent(nil), this.timeouts...)
+}
+
+// GetDialogTerminatedEvents returns the dialog terminated events given so
+// far.
+func (this *MockListener) GetDialogTerminatedEvents() []sip.DialogTerminatedEvent {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return append([]sip.DialogTerminatedEvent(nil), this.terminated...)
+}
+
+// GetInvocations returns how many events, of any kind, were given so far.
+func (this *MockListener) GetInvocations() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.invocations
+}
+
+// Reset forgets the events given so far.
+func (this *MockListener) Reset() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.requests = nil
+	this.responses = nil
+	this.timeouts = nil
+	this.terminated = nil
+	this.invocations = 0
+}
+
+// Wait waits until n events, of any kind, were given since the listener was
+// created or reset, and reports whether they were before the timeout. The
+// provider giving the events on goroutines of its own, a test should wait
+// for them before looking at them.
+func (this *MockListener) Wait(n int, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		this.mutex.Lock()
+		if this.invocations >= n {
+			this.mutex.Unlock()
+			return true
+		}
+		if this.changed == nil {
+			this.changed = make(chan struct{})
+		}
+		changed := this.changed
+		this.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+// notify counts an event and wakes up the waiters. The mutex must be held.
+func (this *MockListener) notify() {
+	this.invocations++
+	if this.changed != nil {
+		close(this.changed)
+		this.changed = nil
+	}
+}
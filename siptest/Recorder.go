@@ -0,0 +1,185 @@
+package siptest
+
+import (
+	"sip"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A Recorder is a sip.ServerTransaction recording the responses sent in it
+// instead of transmitting them, for a Listener to be given requests without
+// a provider:
+//
+//	event, rec := siptest.NewRequestEvent(req)
+//	l.ProcessRequest(event)
+//	if rec.GetLastResponse().GetStatusCode() != sip.OK { ... }
+//
+// It follows the states of a server transaction: trying, or proceeding for
+// an INVITE, until a final response completes it, or terminates it for a 2xx
+// to an INVITE. A response sent once it completed is refused with
+// sip.ErrTransactionCompleted.
+type Recorder struct {
+	request sip.Request
+
+	mutex           sync.Mutex
+	dialog          sip.Dialog
+	state           sip.TransactionState
+	retransmitTimer int
+	responses       []sip.Response
+	closed          bool
+}
+
+////////////////////Implementation////////////////////////
+
+// NewRecorder returns the Recorder of the server transaction of req.
+func NewRecorder(req sip.Request) *Recorder {
+	this := &Recorder{}
+
+	this.request = req
+	this.retransmitTimer = int(sip.TIMER_T1 / time.Millisecond)
+	if req.GetMethod() == sip.INVITE {
+		this.state = sip.TRANSACTIONSTATE_PROCEEDING
+	} else {
+		this.state = sip.TRANSACTIONSTATE_TRYING
+	}
+
+	return this
+}
+
+// NewRequestEvent returns the event of req received in a new Recorder, and
+// the Recorder.
+func NewRequestEvent(req sip.Request) (sip.RequestEvent, *Recorder) {
+	rec := NewRecorder(req)
+	return *sip.NewRequestEvent(rec, req), rec
+}
+
+func (this *Recorder) GetDialog() sip.Dialog {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.dialog
+}
+
+// SetDialog sets the dialog the transaction belongs to, nil by default.
+func (this *Recorder) SetDialog(d sip.Dialog) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.dialog = d
+}
+
+func (this *Recorder) GetState() sip.TransactionState {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.state
+}
+
+func (this *Recorder) GetRetransmitTimer() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.retransmitTimer
+}
+
+func (this *Recorder) SetRetransmitTimer(retransmitTimer int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.retransmitTimer = retransmitTimer
+}
+
+// GetBranchId returns the branch parameter of the top Via of the request.
+func (this *Recorder) GetBranchId() string {
+	via := strings.SplitN(this.request.GetHeader().Get("Via"), ",", 2)[0]
+	for _, param := range strings.Split(via, ";")[1:] {
+		if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && strings.EqualFold(kv[0], "branch") {
+			return kv[1]
+		}
+	}
+	return ""
+}
+
+func (this *Recorder) GetRequest() sip.Request {
+	return this.request
+}
+
+func (this *Recorder) GetStats() sip.TransactionStats {
+	return sip.TransactionStats{}
+}
+
+// Close terminates the transaction.
+func (this *Recorder) Close() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.closed = true
+	this.state = sip.TRANSACTIONSTATE_TERMINATED
+}
+
+// IsClosed reports whether Close was called.
+func (this *Recorder) IsClosed() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.closed
+}
+
+// SendResponse records resp, unless the transaction completed.
+func (this *Recorder) SendResponse(resp sip.Response) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	switch this.state {
+	case sip.TRANSACTIONSTATE_TRYING, sip.TRANSACTIONSTATE_PROCEEDING:
+	default:
+		return sip.ErrTransactionCompleted
+	}
+
+	this.responses = append(this.responses, resp)
+	statusCode := resp.GetStatusCode()
+	switch {
+	case statusCode < sip.OK:
+		this.state = sip.TRANSACTIONSTATE_PROCEEDING
+	case this.request.GetMethod() == sip.INVITE && statusCode < sip.MULTIPLE_CHOICES:
+		this.state = sip.TRANSACTIONSTATE_TERMINATED
+	default:
+		this.state = sip.TRANSACTIONSTATE_COMPLETED
+	}
+	return nil
+}
+
+// GetResponses returns the responses sent so far.
+func (this *Recorder) GetResponses() []sip.Response {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return append([]sip.Response(nil), this.responses...)
+}
+
+// GetLastResponse returns the last response sent, or nil.
+func (this *Recorder) GetLastResponse() sip.Response {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if len(this.responses) == 0 {
+		return nil
+	}
+	return this.responses[len(this.responses)-1]
+}
+
+// GetFinalResponse returns the final response sent, or nil.
+func (this *Recorder) GetFinalResponse() sip.Response {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for _, resp := range this.responses {
+		if resp.GetStatusCode() >= sip.OK {
+			return resp
+		}
+	}
+	return nil
+}
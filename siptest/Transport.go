@@ -0,0 +1,305 @@
+package siptest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sip"
+	"sync"
+)
+
+////////////////////Interface//////////////////////////////
+
+// A MockTransport is a sip.Transport carrying its connections in memory,
+// over net.Pipe. The messages written on the connections it dials are
+// recorded, and the requests among them answered with the response of the
+// Responder, if set, for the code under test to see its requests answered
+// as scripted. Connections to accept once it listens are opened with
+// Connect, which returns the peer end for the test to write its requests on
+// and read the answers from.
+//
+// The provider of package sip serves only the transports it creates, a
+// MockTransport is meant for the code which takes a sip.Transport of its
+// own.
+type MockTransport struct {
+	Responder func(req sip.Request) sip.Response
+
+	network string
+	address string
+	port    int
+
+	mutex     sync.Mutex
+	tlsc      *tls.Config
+	reusePort bool
+	reloader  sip.CertificateReloader
+	keys      [][32]byte
+	policy    sip.TLSPolicy
+	file      *os.File
+	dials     int
+	listening bool
+	messages  []sip.Message
+	metrics   sip.TransportMetrics
+
+	accepted chan net.Conn
+	closed   chan struct{}
+	close    sync.Once
+}
+
+var ErrNotListening = errors.New("siptest: transport is not listening")
+var ErrTransportClosed = errors.New("siptest: transport closed")
+var ErrNoFile = errors.New("siptest: an in-memory transport has no file")
+
+////////////////////Implementation////////////////////////
+
+// NewMockTransport returns a MockTransport of network, "udp", "tcp", "tls",
+// "ws" or "wss", at address and port.
+func NewMockTransport(network, address string, port int) *MockTransport {
+	this := &MockTransport{}
+
+	this.network = network
+	this.address = address
+	this.port = port
+	this.accepted = make(chan net.Conn)
+	this.closed = make(chan struct{})
+
+	return this
+}
+
+func (this *MockTransport) GetNetwork() string {
+	return this.network
+}
+
+func (this *MockTransport) GetAddress() string {
+	return this.address
+}
+
+func (this *MockTransport) GetPort() int {
+	return this.port
+}
+
+func (this *MockTransport) GetTLSConfig() *tls.Config {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.tlsc
+}
+
+// SetTLSConfig sets the configuration GetTLSConfig returns, nil by default.
+func (this *MockTransport) SetTLSConfig(tlsc *tls.Config) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.tlsc = tlsc
+}
+
+// Dial returns a new connection, the messages written on which are recorded
+// and, for requests, answered by the Responder.
+func (this *MockTransport) Dial() (net.Conn, error) {
+	select {
+	case <-this.closed:
+		return nil, ErrTransportClosed
+	default:
+	}
+
+	this.mutex.Lock()
+	this.dials++
+	this.mutex.Unlock()
+
+	conn, peer := net.Pipe()
+	go this.serve(peer)
+	return conn, nil
+}
+
+func (this *MockTransport) Listen() error {
+	select {
+	case <-this.closed:
+		return ErrTransportClosed
+	default:
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.listening = true
+	return nil
+}
+
+// Accept returns the next connection opened with Connect, and fails once
+// the transport is closed.
+func (this *MockTransport) Accept() (net.Conn, error) {
+	select {
+	case conn := <-this.accepted:
+		return conn, nil
+	case <-this.closed:
+		return nil, ErrTransportClosed
+	}
+}
+
+// Connect opens a connection to the listening transport and returns its
+// peer end, once Accept returned the other one.
+func (this *MockTransport) Connect() (net.Conn, error) {
+	this.mutex.Lock()
+	listening := this.listening
+	this.mutex.Unlock()
+	if !listening {
+		return nil, ErrNotListening
+	}
+
+	conn, peer := net.Pipe()
+	select {
+	case this.accepted <- conn:
+	case <-this.closed:
+		conn.Close()
+		peer.Close()
+		return nil, ErrTransportClosed
+	}
+
+	this.mutex.Lock()
+	this.metrics.Connections++
+	this.mutex.Unlock()
+	return peer, nil
+}
+
+// Close stops the transport: Accept, Connect and Dial fail from then on.
+// The connections already open are left to their owners.
+func (this *MockTransport) Close() {
+	this.close.Do(func() {
+		close(this.closed)
+	})
+}
+
+func (this *MockTransport) SetReusePort(reusePort bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.reusePort = reusePort
+}
+
+func (this *MockTransport) IsReusePort() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.reusePort
+}
+
+func (this *MockTransport) SetCertificateReloader(reloader sip.CertificateReloader) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.reloader = reloader
+}
+
+// GetCertificateReloader returns the reloader last set, or nil.
+func (this *MockTransport) GetCertificateReloader() sip.CertificateReloader {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.reloader
+}
+
+func (this *MockTransport) SetSessionTicketKeys(keys [][32]byte) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.keys = append([][32]byte(nil), keys...)
+}
+
+// GetSessionTicketKeys returns the keys last set, or nil.
+func (this *MockTransport) GetSessionTicketKeys() [][32]byte {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return append([][32]byte(nil), this.keys...)
+}
+
+func (this *MockTransport) SetTLSPolicy(policy sip.TLSPolicy) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.policy = policy
+}
+
+func (this *MockTransport) GetTLSPolicy() sip.TLSPolicy {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.policy
+}
+
+// SetListenerFile records f, which Listen ignores: the connections to
+// accept come from Connect.
+func (this *MockTransport) SetListenerFile(f *os.File) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.file = f
+}
+
+// File fails with ErrNoFile, connections in memory having no socket to
+// hand over.
+func (this *MockTransport) File() (*os.File, error) {
+	return nil, ErrNoFile
+}
+
+// GetMetrics counts the connections opened with Connect, and the messages
+// written on the dialed ones.
+func (this *MockTransport) GetMetrics() sip.TransportMetrics {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.metrics
+}
+
+// GetDials returns how many times Dial succeeded.
+func (this *MockTransport) GetDials() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.dials
+}
+
+// GetMessages returns the messages written on the dialed connections so
+// far, in the order they were read.
+func (this *MockTransport) GetMessages() []sip.Message {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return append([]sip.Message(nil), this.messages...)
+}
+
+// serve reads the messages written on conn, the peer end of a dialed
+// connection, until it closes or carries a malformed message.
+func (this *MockTransport) serve(conn net.Conn) {
+	defer conn.Close()
+
+	b := bufio.NewReader(conn)
+	for {
+		msg, err := sip.ReadMessage(b)
+		if err != nil {
+			if err != io.EOF && err != io.ErrClosedPipe {
+				this.mutex.Lock()
+				this.metrics.Malformed++
+				this.mutex.Unlock()
+			}
+			return
+		}
+
+		this.mutex.Lock()
+		this.messages = append(this.messages, msg)
+		this.metrics.Messages++
+		this.mutex.Unlock()
+
+		req, ok := msg.(sip.Request)
+		if !ok || this.Responder == nil {
+			continue
+		}
+		if resp := this.Responder(req); resp != nil {
+			if err := resp.Write(conn); err != nil {
+				return
+			}
+		}
+	}
+}
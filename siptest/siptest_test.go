@@ -0,0 +1,114 @@
+package siptest
+
+import (
+	"bufio"
+	"sip"
+	"testing"
+	"time"
+)
+
+func newRequest(method, branch string) sip.Request {
+	req := sip.NewRequest(method, "sip:bob@biloxi.com", nil)
+	h := req.GetHeader()
+	h.Set("Via", "SIP/2.0/UDP 192.0.2.1:5060;branch="+branch)
+	h.Set("From", "<sip:alice@atlanta.com>;tag=1928301774")
+	h.Set("To", "<sip:bob@biloxi.com>")
+	h.Set("Call-Id", "a84b4c76e66710@192.0.2.1")
+	h.Set("Cseq", "1 "+method)
+	h.Set("Content-Length", "0")
+	return req
+}
+
+func TestMockListenerRecorder(t *testing.T) {
+	l := NewMockListener()
+	l.OnRequest = func(requestEvent sip.RequestEvent) {
+		w := requestEvent.GetResponseWriter()
+		if err := w.Provisional(sip.RINGING); err != nil {
+			t.Error(err)
+		}
+		if err := w.Respond(sip.BUSY_HERE, nil); err != nil {
+			t.Error(err)
+		}
+	}
+
+	event, rec := NewRequestEvent(newRequest(sip.INVITE, "z9hG4bKst1"))
+	l.ProcessRequest(event)
+	if !l.Wait(1, time.Second) || len(l.GetRequests()) != 1 || l.GetRequests()[0].GetMethod() != sip.INVITE {
+		t.Fatalf("%d events recorded", l.GetInvocations())
+	}
+
+	responses := rec.GetResponses()
+	if len(responses) != 2 || responses[0].GetStatusCode() != sip.RINGING || rec.GetFinalResponse().GetStatusCode() != sip.BUSY_HERE {
+		t.Fatalf("%d responses recorded", len(responses))
+	}
+	if rec.GetState() != sip.TRANSACTIONSTATE_COMPLETED || rec.GetBranchId() != "z9hG4bKst1" {
+		t.Errorf("state %d, branch %q", rec.GetState(), rec.GetBranchId())
+	}
+	if err := rec.SendResponse(sip.CreateResponse(rec.GetRequest(), sip.OK)); err != sip.ErrTransactionCompleted {
+		t.Errorf("response after the final one sent with %v", err)
+	}
+
+	l.Reset()
+	if l.GetInvocations() != 0 || l.Wait(1, 10*time.Millisecond) {
+		t.Error("events left after Reset")
+	}
+}
+
+func TestMockTransport(t *testing.T) {
+	tr := NewMockTransport(sip.TCP, "192.0.2.2", 5060)
+	tr.Responder = func(req sip.Request) sip.Response {
+		return sip.CreateResponse(req, sip.OK)
+	}
+
+	//a dialed connection answers as scripted
+	conn, err := tr.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := newRequest(sip.OPTIONS, "z9hG4bKtr1").Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := sip.ReadMessage(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, ok := msg.(sip.Response); !ok || resp.GetStatusCode() != sip.OK {
+		t.Fatalf("read %v", msg)
+	}
+	if tr.GetDials() != 1 || len(tr.GetMessages()) != 1 || tr.GetMetrics().Messages != 1 {
+		t.Errorf("%d dials, %d messages recorded", tr.GetDials(), len(tr.GetMessages()))
+	}
+
+	//a connection to accept
+	if _, err := tr.Connect(); err != ErrNotListening {
+		t.Errorf("connected with %v before listening", err)
+	}
+	if err := tr.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan error, 1)
+	go func() {
+		c, err := tr.Accept()
+		if err == nil {
+			c.Close()
+		}
+		accepted <- err
+	}()
+	peer, err := tr.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer.Close()
+	if err := <-accepted; err != nil {
+		t.Fatal(err)
+	}
+
+	tr.Close()
+	if _, err := tr.Accept(); err != ErrTransportClosed {
+		t.Errorf("accepted with %v once closed", err)
+	}
+	if _, err := tr.Dial(); err != ErrTransportClosed {
+		t.Errorf("dialed with %v once closed", err)
+	}
+}